@@ -3,6 +3,10 @@ package account
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/safing/spn/clock"
 )
 
 const (
@@ -10,6 +14,10 @@ const (
 	AuthHeaderToken               = "Token-17"
 	AuthHeaderNextToken           = "Next-Token-17"
 	AuthHeaderNextTokenDeprecated = "Next_token_17"
+
+	// HeaderRetryAfter holds the delay after which a rejected request may be
+	// repeated, eg. when the token issuance quota was exceeded.
+	HeaderRetryAfter = "Retry-After"
 )
 
 var (
@@ -56,3 +64,39 @@ func GetNextTokenFromResponse(resp *http.Response) (token string, ok bool) {
 func ApplyNextTokenToResponse(w http.ResponseWriter, token string) {
 	w.Header().Set(AuthHeaderNextToken, token)
 }
+
+// ApplyRetryAfterToResponse sets the delay until the given time as the
+// Retry-After header of the response. The delay is rounded up to full
+// seconds, as required by the header.
+func ApplyRetryAfterToResponse(w http.ResponseWriter, retryAfter time.Time) {
+	delay := retryAfter.Sub(clock.Now())
+	seconds := int64(delay / time.Second)
+	if delay%time.Second > 0 {
+		seconds++
+	}
+	if seconds < 0 {
+		seconds = 0
+	}
+	w.Header().Set(HeaderRetryAfter, strconv.FormatInt(seconds, 10))
+}
+
+// GetRetryAfterFromResponse returns the time after which the request may be
+// repeated, as set by the Retry-After header of the response. Both a delay in
+// seconds and an HTTP date are supported.
+func GetRetryAfterFromResponse(resp *http.Response) (retryAfter time.Time, ok bool) {
+	value := resp.Header.Get(HeaderRetryAfter)
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return time.Time{}, false
+		}
+		return clock.Now().Add(time.Duration(seconds) * time.Second), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date, true
+	}
+	return time.Time{}, false
+}
@@ -1,6 +1,10 @@
 package account
 
-import "time"
+import (
+	"time"
+
+	"github.com/safing/spn/clock"
+)
 
 // User, Subscription and Charge states.
 const (
@@ -23,6 +27,13 @@ const (
 	ChargeStateDead      = "dead"
 )
 
+// Account tiers. Higher tiers include all zones of lower tiers.
+const (
+	TierNone  = 0
+	TierBasic = 1
+	TierPlus  = 2
+)
+
 // Agent and Hub return statuses.
 const (
 	// StatusInvalidAuth [401 Unauthorized] is returned when the credentials are
@@ -35,6 +46,9 @@ const (
 	StatusReachedDeviceLimit = 409
 	// StatusDeviceInactive [423 Locked] is returned when the device is locked.
 	StatusDeviceInactive = 423
+	// StatusQuotaExceeded [429 Too Many Requests] is returned when the token
+	// issuance quota of the device is exceeded.
+	StatusQuotaExceeded = 429
 	// StatusNotLoggedIn [412 Precondition] is returned by the Portmaster, if an action required to be logged in, but the user is not logged in.
 	StatusNotLoggedIn = 412
 )
@@ -48,12 +62,27 @@ type User struct {
 	Subscription *Subscription `json:"subscription"`
 	CurrentPlan  *Plan         `json:"current_plan"`
 	NextPlan     *Plan         `json:"next_plan"`
+	Tier         int           `json:"tier,omitempty"`
 }
 
 // MayUseSPN return whether the user may currently use the SPN.
 func (u *User) MayUseSPN() bool {
 	return u.State == UserStateApproved &&
-		time.Now().Before(u.Subscription.EndsAt)
+		clock.Now().Before(u.Subscription.EndsAt)
+}
+
+// GetTier returns the effective account tier of the user.
+// Users that may use the SPN, but have no tier set, are regarded as basic
+// users, as the account server might not report tiers yet.
+func (u *User) GetTier() int {
+	switch {
+	case u.Subscription == nil || !u.MayUseSPN():
+		return TierNone
+	case u.Tier == TierNone:
+		return TierBasic
+	default:
+		return u.Tier
+	}
 }
 
 // Device describes a device of an SPN user.
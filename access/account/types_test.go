@@ -0,0 +1,79 @@
+package account
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/safing/spn/clock"
+)
+
+func TestSubscriptionExpiry(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	defer clock.Set(fake)()
+
+	user := &User{
+		State: UserStateApproved,
+		Subscription: &Subscription{
+			EndsAt: start.Add(time.Hour),
+		},
+		Tier: TierBasic,
+	}
+
+	// The subscription is active until it ends.
+	if !user.MayUseSPN() {
+		t.Fatal("user should be able to use the SPN before the subscription ends")
+	}
+	fake.Advance(59 * time.Minute)
+	if user.GetTier() != TierBasic {
+		t.Fatal("user should keep the tier before the subscription ends")
+	}
+
+	// The subscription expires at its end.
+	fake.Advance(time.Minute)
+	if user.MayUseSPN() {
+		t.Fatal("user should not be able to use the SPN after the subscription ended")
+	}
+	if user.GetTier() != TierNone {
+		t.Fatal("user should lose the tier after the subscription ended")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	defer clock.Set(fake)()
+
+	// Delays are sent in full seconds, rounded up.
+	w := httptest.NewRecorder()
+	ApplyRetryAfterToResponse(w, start.Add(90*time.Second+time.Millisecond))
+	resp := w.Result()
+	if value := resp.Header.Get(HeaderRetryAfter); value != "91" {
+		t.Fatalf("unexpected Retry-After header: %q", value)
+	}
+	retryAfter, ok := GetRetryAfterFromResponse(resp)
+	if !ok || !retryAfter.Equal(start.Add(91*time.Second)) {
+		t.Fatalf("unexpected retry after: %s (%v)", retryAfter, ok)
+	}
+
+	// HTTP dates are supported, invalid values are ignored.
+	for _, test := range []struct {
+		value      string
+		retryAfter time.Time
+		ok         bool
+	}{
+		{start.Add(time.Hour).Format(http.TimeFormat), start.Add(time.Hour), true},
+		{"", time.Time{}, false},
+		{"-1", time.Time{}, false},
+		{"soon", time.Time{}, false},
+	} {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set(HeaderRetryAfter, test.value)
+		retryAfter, ok := GetRetryAfterFromResponse(resp)
+		if ok != test.ok || !retryAfter.Equal(test.retryAfter) {
+			t.Errorf("%q: expected %s (%v), got %s (%v)", test.value, test.retryAfter, test.ok, retryAfter, ok)
+		}
+	}
+}
@@ -60,6 +60,62 @@ func registerAPIEndpoints() error {
 		return err
 	}
 
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:       `spn/account/zones`,
+		Read:       api.PermitUser,
+		ReadMethod: http.MethodGet,
+		StructFunc: func(_ *api.Request) (i interface{}, err error) {
+			return &ZonesStatus{
+				Tier:  getClientTier(),
+				Zones: ListZones(),
+			}, nil
+		},
+		Name:        "SPN Account Zones",
+		Description: "List the access zones and whether the account tier permits using them.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:       `spn/account/events`,
+		Read:       api.PermitUser,
+		ReadMethod: http.MethodGet,
+		StructFunc: func(_ *api.Request) (i interface{}, err error) {
+			return RecentEvents(), nil
+		},
+		Name:        "SPN Account Events",
+		Description: "List recent state changes of the SPN account, such as logins and token refills.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:       `spn/account/zones/usage`,
+		Read:       api.PermitUser,
+		ReadMethod: http.MethodGet,
+		StructFunc: func(_ *api.Request) (i interface{}, err error) {
+			return GetTokenUsage(), nil
+		},
+		Name:        "SPN Zone Token Usage",
+		Description: "List how many tokens were accepted per zone and when the last one was accepted.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/account/zones/{zone:[A-Za-z0-9_-]+}/test-issuance`,
+		Write:       api.PermitAdmin,
+		BelongsTo:   module,
+		WriteMethod: http.MethodPost,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return TestIssuance(ar.URLVars["zone"])
+		},
+		Name:        "SPN Token Issuance Test",
+		Description: "Run a full token issuance round-trip for a zone against the token issuer, without affecting the stored tokens. The issued tokens count towards the account's allowance.",
+	}); err != nil {
+		return err
+	}
+
 	return nil
 }
 
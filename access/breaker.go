@@ -0,0 +1,178 @@
+package access
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Token issuer circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// DefaultTokenIssuerBreakerThreshold is the default amount of consecutive
+// failures that open the circuit breaker. A single failure opens it, so that
+// fallback tokens are available as soon as the token issuer fails.
+const DefaultTokenIssuerBreakerThreshold = 1
+
+var (
+	// tokenIssuerBreakerThreshold defines how many consecutive failures open
+	// the circuit breaker. It must be accessed atomically.
+	tokenIssuerBreakerThreshold int32 = DefaultTokenIssuerBreakerThreshold
+
+	// tokenIssuerBreakerCooldown defines how long the circuit breaker stays
+	// open before a single probe request is allowed through.
+	tokenIssuerBreakerCooldown = 1 * time.Minute
+
+	tokenIssuerBreaker = &circuitBreaker{
+		state: BreakerClosed,
+	}
+)
+
+// SetTokenIssuerBreakerThreshold sets how many consecutive failures open the
+// circuit breaker wrapping the requests to the token issuer. Higher values
+// tolerate single hiccups, but delay using fallback tokens while the token
+// issuer is failing.
+func SetTokenIssuerBreakerThreshold(n int) error {
+	if n < 1 || n > 100 {
+		return errors.New("token issuer breaker threshold must be between 1 and 100")
+	}
+
+	atomic.StoreInt32(&tokenIssuerBreakerThreshold, int32(n))
+	return nil
+}
+
+// BreakerInfo holds the state of the token issuer circuit breaker for
+// diagnostics.
+type BreakerInfo struct {
+	State               string
+	ConsecutiveFailures int
+	OpenedAt            time.Time `json:",omitempty"`
+	NextProbeAt         time.Time `json:",omitempty"`
+}
+
+// circuitBreaker stops requests to a failing service. When open, requests
+// fail fast without a network attempt. After a cooldown, the breaker goes
+// half-open and allows exactly one probe request, which either closes the
+// breaker again or re-opens it.
+type circuitBreaker struct {
+	lock sync.Mutex
+
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow returns whether a request may be made now. If it returns true, the
+// result of the request must be reported with success or failure.
+func (cb *circuitBreaker) allow(now time.Time) bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if now.Sub(cb.openedAt) < tokenIssuerBreakerCooldown {
+			return false
+		}
+		// Cooldown is over, allow a probe.
+		cb.state = BreakerHalfOpen
+		cb.probing = true
+		return true
+
+	case BreakerHalfOpen:
+		// Only allow one probe at a time.
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+		return true
+
+	default:
+		return true
+	}
+}
+
+// success reports a successful request and returns whether the breaker was
+// closed by it.
+func (cb *circuitBreaker) success() (closed bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	closed = cb.state != BreakerClosed
+	cb.state = BreakerClosed
+	cb.failures = 0
+	cb.openedAt = time.Time{}
+	cb.probing = false
+	return closed
+}
+
+// failure reports a failed request and returns whether the breaker was opened
+// by it. A failed probe re-opens the breaker without reporting it as opened.
+func (cb *circuitBreaker) failure(now time.Time) (opened bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	cb.failures++
+	cb.probing = false
+
+	switch cb.state {
+	case BreakerHalfOpen:
+		cb.state = BreakerOpen
+		cb.openedAt = now
+		return false
+
+	case BreakerOpen:
+		return false
+
+	default:
+		if cb.failures < int(atomic.LoadInt32(&tokenIssuerBreakerThreshold)) {
+			return false
+		}
+		cb.state = BreakerOpen
+		cb.openedAt = now
+		return true
+	}
+}
+
+// release releases a request that was allowed, but not made, without
+// reporting a result.
+func (cb *circuitBreaker) release() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	cb.probing = false
+}
+
+// isFailing returns whether the breaker is not closed.
+func (cb *circuitBreaker) isFailing() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	return cb.state != BreakerClosed
+}
+
+func (cb *circuitBreaker) info() *BreakerInfo {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	info := &BreakerInfo{
+		State:               cb.state,
+		ConsecutiveFailures: cb.failures,
+		OpenedAt:            cb.openedAt,
+	}
+	if cb.state == BreakerOpen {
+		info.NextProbeAt = cb.openedAt.Add(tokenIssuerBreakerCooldown)
+	}
+	return info
+}
+
+// GetTokenIssuerBreakerInfo returns the state of the circuit breaker wrapping
+// the requests to the token issuer.
+func GetTokenIssuerBreakerInfo() *BreakerInfo {
+	return tokenIssuerBreaker.info()
+}
@@ -0,0 +1,124 @@
+package access
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	defer SetTokenIssuerBreakerThreshold(DefaultTokenIssuerBreakerThreshold) //nolint:errcheck
+	if err := SetTokenIssuerBreakerThreshold(3); err != nil {
+		t.Fatal(err)
+	}
+	threshold := 3
+
+	cb := &circuitBreaker{
+		state: BreakerClosed,
+	}
+	now := time.Now()
+
+	// Closed breaker allows requests.
+	if !cb.allow(now) {
+		t.Fatal("closed breaker should allow requests")
+	}
+
+	// Failures below the threshold keep the breaker closed.
+	for i := 1; i < threshold; i++ {
+		if cb.failure(now) {
+			t.Fatalf("failure %d should not open the breaker", i)
+		}
+		if cb.isFailing() {
+			t.Fatalf("breaker should not be failing after %d failures", i)
+		}
+	}
+
+	// Reaching the threshold opens the breaker.
+	if !cb.failure(now) {
+		t.Fatal("failure should open the breaker")
+	}
+	if !cb.isFailing() {
+		t.Fatal("open breaker should be failing")
+	}
+	if cb.allow(now.Add(tokenIssuerBreakerCooldown / 2)) {
+		t.Fatal("open breaker should fail fast during cooldown")
+	}
+
+	// After the cooldown, exactly one probe is allowed.
+	probeTime := now.Add(tokenIssuerBreakerCooldown)
+	if !cb.allow(probeTime) {
+		t.Fatal("breaker should allow a probe after cooldown")
+	}
+	if cb.info().State != BreakerHalfOpen {
+		t.Fatalf("expected half-open breaker, got %s", cb.info().State)
+	}
+	if cb.allow(probeTime) {
+		t.Fatal("half-open breaker should only allow one probe")
+	}
+
+	// Failed probe re-opens the breaker.
+	if cb.failure(probeTime) {
+		t.Fatal("failed probe should not report as newly opened")
+	}
+	if cb.allow(probeTime.Add(tokenIssuerBreakerCooldown / 2)) {
+		t.Fatal("re-opened breaker should fail fast during cooldown")
+	}
+	info := cb.info()
+	if info.State != BreakerOpen || info.ConsecutiveFailures != threshold+1 {
+		t.Fatalf("unexpected breaker info: %+v", info)
+	}
+	if !info.NextProbeAt.Equal(probeTime.Add(tokenIssuerBreakerCooldown)) {
+		t.Fatalf("unexpected next probe time: %s", info.NextProbeAt)
+	}
+
+	// Successful probe closes the breaker.
+	if !cb.allow(probeTime.Add(tokenIssuerBreakerCooldown)) {
+		t.Fatal("breaker should allow a probe after cooldown")
+	}
+	if !cb.success() {
+		t.Fatal("successful probe should close the breaker")
+	}
+	if cb.isFailing() || cb.info().ConsecutiveFailures != 0 {
+		t.Fatal("closed breaker should be reset")
+	}
+}
+
+func TestTokenIssuerFallbackTiming(t *testing.T) {
+	defer SetTokenIssuerBreakerThreshold(DefaultTokenIssuerBreakerThreshold) //nolint:errcheck
+
+	for _, threshold := range []int{DefaultTokenIssuerBreakerThreshold, 2} {
+		if err := SetTokenIssuerBreakerThreshold(threshold); err != nil {
+			t.Fatal(err)
+		}
+		cb := &circuitBreaker{
+			state: BreakerClosed,
+		}
+		now := time.Now()
+
+		// Fallback tokens must only be used after the configured amount of
+		// consecutive failures.
+		for i := 1; i <= threshold; i++ {
+			cb.allow(now)
+			opened := cb.failure(now)
+			if opened != (i == threshold) || cb.isFailing() != (i == threshold) {
+				t.Fatalf("threshold %d: unexpected breaker state after %d failures: %+v", threshold, i, cb.info())
+			}
+		}
+
+		// Fallback tokens stay available until a probe succeeds.
+		if cb.allow(now.Add(tokenIssuerBreakerCooldown - time.Second)) {
+			t.Fatalf("threshold %d: breaker should not probe before the cooldown", threshold)
+		}
+		if !cb.allow(now.Add(tokenIssuerBreakerCooldown)) || !cb.isFailing() {
+			t.Fatalf("threshold %d: breaker should probe after the cooldown while still failing", threshold)
+		}
+		cb.success()
+		if cb.isFailing() {
+			t.Fatalf("threshold %d: breaker should be closed after a successful probe", threshold)
+		}
+	}
+
+	// Invalid thresholds are rejected.
+	if err := SetTokenIssuerBreakerThreshold(0); err == nil {
+		t.Fatal("threshold of 0 should be rejected")
+	}
+}
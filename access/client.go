@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/safing/portbase/log"
 	"github.com/safing/spn/access/account"
 	"github.com/safing/spn/access/token"
+	"github.com/safing/spn/clock"
 )
 
 const (
@@ -23,18 +26,27 @@ const (
 	TokenRequestIssuePath = "/api/v1/token/request/issue"
 	HealthCheckPath       = "/api/v1/health"
 
-	defaultDataFormat     = dsd.CBOR
-	defaultRequestTimeout = 10 * time.Second
+	defaultDataFormat = dsd.CBOR
 )
 
 var (
-	accountClient     = &http.Client{}
 	clientRequestLock sync.Mutex
+
+	// authTokenLock serializes requests that use the auth token, from applying
+	// the auth token until the next auth token of the response is saved, as
+	// every response may replace the auth token.
+	authTokenLock sync.Mutex
+
+	// issuanceQuotaRetryAfter holds the time after which tokens may be
+	// requested again, after the token issuance quota of this device was
+	// exceeded.
+	issuanceQuotaRetryAfter     time.Time
+	issuanceQuotaRetryAfterLock sync.Mutex
 )
 
 type clientRequestOptions struct {
 	method               string
-	url                  string
+	path                 string
 	send                 interface{}
 	recv                 interface{}
 	dataFormat           uint8
@@ -43,6 +55,25 @@ type clientRequestOptions struct {
 	requireNextAuthToken bool
 	logoutOnAuthError    bool
 	requestSetupFunc     func(*http.Request) error
+	// recvFunc is called with the response instead of loading it into recv,
+	// so that the response body can be processed while it streams in.
+	recvFunc func(*http.Response) error
+
+	// session pins related requests to a single token issuer endpoint.
+	session *issuerSession
+}
+
+// issuerSession pins related requests, such as the token setup and issue
+// requests, to the token issuer endpoint that replied to the first of them,
+// as the endpoints do not share session state.
+type issuerSession struct {
+	endpoint *issuerEndpoint
+}
+
+// mayFailOver returns whether the request may be repeated at another
+// endpoint after it reached an endpoint that failed.
+func (cro *clientRequestOptions) mayFailOver() bool {
+	return cro.method == http.MethodGet || cro.method == http.MethodHead
 }
 
 func (cro *clientRequestOptions) logoutOnAuthErrorIfDesired(err error) {
@@ -53,25 +84,106 @@ func (cro *clientRequestOptions) logoutOnAuthErrorIfDesired(err error) {
 	}
 }
 
+// issuerRequestResult describes the outcome of a request to a token issuer
+// endpoint.
+type issuerRequestResult int
+
+const (
+	// issuerNotReached means that the request failed before it was sent.
+	issuerNotReached issuerRequestResult = iota
+	// issuerReplied means that the endpoint replied as expected.
+	issuerReplied
+	// issuerUnreachable means that the endpoint could not be connected to,
+	// so the request never reached it.
+	issuerUnreachable
+	// issuerFailed means that the request reached the endpoint, but the
+	// endpoint did not reply as expected.
+	issuerFailed
+)
+
 func makeClientRequest(opts *clientRequestOptions) (resp *http.Response, err error) {
-	// Get request timeout.
+	// Fail fast if the circuit breaker does not allow a request.
+	if !tokenIssuerBreaker.allow(clock.Now()) {
+		return nil, ErrTokenIssuerUnavailable
+	}
+
+	// Try the endpoints in order of preference until one replies as expected.
+	// Requests of a session may only use the endpoint of the session.
+	var pinned *issuerEndpoint
+	if opts.session != nil {
+		pinned = opts.session.endpoint
+	}
+	endpoints := selectIssuerEndpoints(clock.Now(), pinned)
+	for i, endpoint := range endpoints {
+		var result issuerRequestResult
+		resp, result, err = makeClientRequestTo(endpoint.url, opts)
+		switch result {
+		case issuerNotReached:
+			// Release all endpoints and the overall breaker.
+			for _, e := range endpoints[i:] {
+				e.breaker.release()
+			}
+			tokenIssuerBreaker.release()
+			return resp, err
+
+		case issuerReplied:
+			// Release remaining endpoints.
+			for _, e := range endpoints[i+1:] {
+				e.breaker.release()
+			}
+			endpoint.breaker.success()
+			setActiveIssuerEndpoint(endpoint)
+			if opts.session != nil {
+				opts.session.endpoint = endpoint
+			}
+			tokenIssuerSucceeded(endpoint.url)
+			return resp, err
+
+		case issuerUnreachable, issuerFailed:
+			endpoint.breaker.failure(clock.Now())
+
+			// Do not repeat requests that reached the endpoint, unless they are
+			// safe to repeat, as the endpoint may have acted on them already.
+			if result == issuerFailed && !opts.mayFailOver() {
+				for _, e := range endpoints[i+1:] {
+					e.breaker.release()
+				}
+				tokenIssuerFailed()
+				return resp, err
+			}
+
+			if i < len(endpoints)-1 {
+				log.Warningf("access: token issuer endpoint %s failed, trying next: %s", endpoint.url, err)
+			}
+		}
+	}
+
+	// All endpoints failed or are waiting for their retry.
+	tokenIssuerFailed()
+	if err == nil {
+		err = ErrTokenIssuerUnavailable
+	}
+	return resp, err
+}
+
+// makeClientRequestTo makes the request to the token issuer endpoint with
+// the given base URL.
+func makeClientRequestTo(baseURL string, opts *clientRequestOptions) (resp *http.Response, result issuerRequestResult, err error) {
+	// Get client and request timeout.
+	client, requestTimeout := getIssuerClient()
 	if opts.requestTimeout == 0 {
-		opts.requestTimeout = defaultRequestTimeout
+		opts.requestTimeout = requestTimeout
 	}
 	// Get context for request.
-	var ctx context.Context
-	if module.Online() {
-		// Only use module context if online.
-		ctx, _ = context.WithTimeout(module.Ctx, opts.requestTimeout)
-	} else {
-		// Otherwise, use the background context.
-		ctx, _ = context.WithTimeout(context.Background(), opts.requestTimeout)
-	}
+	// The lifecycle context outlives the module context during shutdown, so
+	// that running account updates may finish.
+	ctx, cancel := context.WithTimeout(getLifecycleCtx(), opts.requestTimeout)
+	defer cancel()
 
 	// Create new request.
-	request, err := http.NewRequestWithContext(ctx, opts.method, opts.url, nil)
+	request, err := http.NewRequestWithContext(ctx, opts.method, baseURL+opts.path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request structure: %w", err)
+		return nil, issuerNotReached, fmt.Errorf("failed to create request structure: %w", err)
 	}
 
 	// Prepare body and content type.
@@ -82,22 +194,32 @@ func makeClientRequest(opts *clientRequestOptions) (resp *http.Response, err err
 		// Add data to body.
 		err = dsd.DumpToHTTPRequest(request, opts.send, opts.dataFormat)
 		if err != nil {
-			return nil, fmt.Errorf("failed to add request body: %w", err)
+			return nil, issuerNotReached, fmt.Errorf("failed to add request body: %w", err)
 		}
 	} else {
 		// Set requested HTTP response format.
 		_, err = dsd.RequestHTTPResponseFormat(request, opts.dataFormat)
 		if err != nil {
-			return nil, fmt.Errorf("failed to set requested response format: %w", err)
+			return nil, issuerNotReached, fmt.Errorf("failed to set requested response format: %w", err)
 		}
 	}
 
 	// Get auth token to apply to request.
+	// Hold the auth token until the next auth token is saved, so that
+	// concurrent requests do not use an auth token that was already replaced.
 	var authToken *AuthTokenRecord
+	releaseAuthToken := func() {}
 	if opts.setAuthToken {
+		authTokenLock.Lock()
+		var unlockOnce sync.Once
+		releaseAuthToken = func() {
+			unlockOnce.Do(authTokenLock.Unlock)
+		}
+		defer releaseAuthToken()
+
 		authToken, err = GetAuthToken()
 		if err != nil {
-			return nil, ErrNotLoggedIn
+			return nil, issuerNotReached, ErrNotLoggedIn
 		}
 		authToken.Token.ApplyTo(request)
 	}
@@ -106,17 +228,30 @@ func makeClientRequest(opts *clientRequestOptions) (resp *http.Response, err err
 	if opts.requestSetupFunc != nil {
 		err = opts.requestSetupFunc(request)
 		if err != nil {
-			return nil, err
+			return nil, issuerNotReached, err
 		}
 	}
 
 	// Make request.
-	resp, err = accountClient.Do(request)
+	resp, err = client.Do(request)
 	if err != nil {
-		tokenIssuerFailed()
-		return nil, fmt.Errorf("http request failed: %w", err)
+		// Canceled requests, eg. during shutdown, say nothing about the token
+		// issuer.
+		if errors.Is(err, context.Canceled) {
+			return nil, issuerNotReached, fmt.Errorf("http request canceled: %w", err)
+		}
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return nil, issuerUnreachable, fmt.Errorf("http request failed: %w", err)
+		}
+		return nil, issuerFailed, fmt.Errorf("http request failed: %w", err)
 	}
 	defer resp.Body.Close()
+
+	// The token issuer is only regarded as failing if it does not reply as
+	// expected.
+	result = issuerReplied
+
 	// Handle request error.
 	switch resp.StatusCode {
 	case http.StatusOK, http.StatusCreated:
@@ -125,21 +260,29 @@ func makeClientRequest(opts *clientRequestOptions) (resp *http.Response, err err
 	case account.StatusInvalidAuth, account.StatusInvalidDevice:
 		// Wrong username / password.
 		opts.logoutOnAuthErrorIfDesired(err)
-		return resp, ErrInvalidCredentials
+		return resp, result, ErrInvalidCredentials
 
 	case account.StatusReachedDeviceLimit:
 		// Device limit is reached.
 		opts.logoutOnAuthErrorIfDesired(err)
-		return resp, ErrDeviceLimitReached
+		return resp, result, ErrDeviceLimitReached
 
 	case account.StatusDeviceInactive:
 		// Device is locked.
 		opts.logoutOnAuthErrorIfDesired(err)
-		return resp, ErrDeviceIsLocked
+		return resp, result, ErrDeviceIsLocked
+
+	case account.StatusQuotaExceeded:
+		// Too many tokens were requested, wait as long as requested.
+		retryAfter, ok := account.GetRetryAfterFromResponse(resp)
+		if !ok {
+			retryAfter = clock.Now().Add(tokenIssuerRetryDuration)
+		}
+		setIssuanceQuotaRetryAfter(retryAfter)
+		return resp, result, fmt.Errorf("%w: retry after %s", ErrIssuanceQuotaExceeded, retryAfter.Format(time.RFC3339))
 
 	default:
-		tokenIssuerFailed()
-		return resp, fmt.Errorf("unexpected reply: [%d] %s", resp.StatusCode, resp.Status)
+		return resp, issuerFailed, fmt.Errorf("unexpected reply: [%d] %s", resp.StatusCode, resp.Status)
 	}
 
 	// Save next auth token.
@@ -148,26 +291,31 @@ func makeClientRequest(opts *clientRequestOptions) (resp *http.Response, err err
 		if err != nil {
 			if errors.Is(err, account.ErrMissingToken) {
 				if opts.requireNextAuthToken {
-					return resp, fmt.Errorf("failed to save next auth token: %w", err)
+					return resp, result, fmt.Errorf("failed to save next auth token: %w", err)
 				}
 			} else {
-				return resp, fmt.Errorf("failed to save next auth token: %w", err)
+				return resp, result, fmt.Errorf("failed to save next auth token: %w", err)
 			}
 		}
 	} else if opts.requireNextAuthToken {
-		return resp, fmt.Errorf("failed to save next auth token: %w", account.ErrMissingToken)
+		return resp, result, fmt.Errorf("failed to save next auth token: %w", account.ErrMissingToken)
 	}
+	releaseAuthToken()
 
 	// Load response data.
-	if opts.recv != nil {
+	if opts.recvFunc != nil {
+		err = opts.recvFunc(resp)
+		if err != nil {
+			return resp, result, fmt.Errorf("failed to process response: %w", err)
+		}
+	} else if opts.recv != nil {
 		_, err = dsd.LoadFromHTTPResponse(resp, opts.recv)
 		if err != nil {
-			return resp, fmt.Errorf("failed to parse response: %w", err)
+			return resp, result, fmt.Errorf("failed to parse response: %w", err)
 		}
 	}
 
-	tokenIssuerIsFailing.UnSet()
-	return resp, nil
+	return resp, result, nil
 }
 
 func login(username, password string) (user *UserRecord, code int, err error) {
@@ -187,7 +335,7 @@ func login(username, password string) (user *UserRecord, code int, err error) {
 	userAccount := &account.User{}
 	requestOptions := &clientRequestOptions{
 		method:     http.MethodPost,
-		url:        AccountServer + LoginPath,
+		path:       LoginPath,
 		recv:       userAccount,
 		dataFormat: dsd.JSON,
 		requestSetupFunc: func(request *http.Request) error {
@@ -213,6 +361,7 @@ func login(username, password string) (user *UserRecord, code int, err error) {
 			resp, err = makeClientRequest(requestOptions)
 		}
 		if err != nil {
+			recordEvent(EventLoginFailed, "%s", err)
 			if resp != nil {
 				return nil, resp.StatusCode, err
 			} else {
@@ -241,6 +390,7 @@ func login(username, password string) (user *UserRecord, code int, err error) {
 	// Enable the SPN right after login.
 	enableSPN()
 
+	recordEvent(EventLoggedIn, "on device %s", user.Device.ID)
 	log.Infof("access: logged in as %q on device %q", user.Username, user.Device.Name)
 	return user, resp.StatusCode, nil
 }
@@ -256,14 +406,14 @@ func logout(shallow, purge bool) error {
 	clearTokens()
 
 	// Delete auth token.
-	err := db.Delete(authTokenRecordKey)
+	err := getRecordStore().Delete(authTokenRecordKey)
 	if err != nil && !errors.Is(err, database.ErrNotFound) {
 		return fmt.Errorf("failed to delete auth token: %w", err)
 	}
 
 	// Delete all user data if purging.
 	if purge {
-		err := db.Delete(userRecordKey)
+		err := getRecordStore().Delete(userRecordKey)
 		if err != nil && !errors.Is(err, database.ErrNotFound) {
 			return fmt.Errorf("failed to delete user: %w", err)
 		}
@@ -271,6 +421,7 @@ func logout(shallow, purge bool) error {
 		// Disable SPN when the user logs out directly.
 		disableSPN()
 
+		recordEvent(EventLoggedOut, "purged data")
 		log.Info("access: logged out and purged data")
 		return nil
 	}
@@ -311,8 +462,10 @@ func logout(shallow, purge bool) error {
 	}
 
 	if shallow {
+		recordEvent(EventLoggedOut, "shallow logout of device %s", user.Device.ID)
 		log.Info("access: logged out shallow")
 	} else {
+		recordEvent(EventLoggedOut, "logout of device %s", user.Device.ID)
 		log.Info("access: logged out")
 
 		// Disable SPN when the user logs out directly.
@@ -330,7 +483,7 @@ func getUserProfile() (user *UserRecord, statusCode int, err error) {
 	userData := &account.User{}
 	requestOptions := &clientRequestOptions{
 		method:               http.MethodGet,
-		url:                  AccountServer + UserProfilePath,
+		path:                 UserProfilePath,
 		recv:                 userData,
 		dataFormat:           dsd.JSON,
 		setAuthToken:         true,
@@ -393,20 +546,95 @@ func getTokens() error {
 		return ErrMayNotUseSPN
 	}
 
+	// Skip refilling until the token issuance quota permits new tokens again.
+	if retryAfter := getIssuanceQuotaRetryAfter(); clock.Now().Before(retryAfter) {
+		log.Debugf("access: skipping token refill, issuance quota exceeded until %s", retryAfter.Format(time.RFC3339))
+		return nil
+	}
+
+	// Refill all zones that need new tokens. Every zone is refilled with its
+	// own requests, with a limited number of refills in flight at once.
+	zones := token.ZonesToRequest()
+	if len(zones) == 0 {
+		return nil
+	}
+	var (
+		failed   int
+		firstErr error
+	)
+	for _, err := range refillConcurrently(getLifecycleCtx(), zones, refillZone) {
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if failed < len(zones) {
+		logRefilledTokens()
+	}
+	if firstErr != nil {
+		return fmt.Errorf("failed to refill %d of %d zones: %w", failed, len(zones), firstErr)
+	}
+
+	return nil
+}
+
+// setIssuanceQuotaRetryAfter sets the time after which tokens may be
+// requested again.
+func setIssuanceQuotaRetryAfter(retryAfter time.Time) {
+	issuanceQuotaRetryAfterLock.Lock()
+	defer issuanceQuotaRetryAfterLock.Unlock()
+
+	issuanceQuotaRetryAfter = retryAfter
+}
+
+// getIssuanceQuotaRetryAfter returns the time after which tokens may be
+// requested again. It is zero if the quota was not exceeded.
+func getIssuanceQuotaRetryAfter() time.Time {
+	issuanceQuotaRetryAfterLock.Lock()
+	defer issuanceQuotaRetryAfterLock.Unlock()
+
+	return issuanceQuotaRetryAfter
+}
+
+// logRefilledTokens logs the current amount of tokens after a refill.
+func logRefilledTokens() {
+	regular, fallback := GetTokenAmount(GetExpandAndConnectZones())
+	recordEvent(EventTokensRefilled, "now at %d regular and %d fallback tokens", regular, fallback)
+	log.Infof(
+		"access: got new tokens, now at %d regular and %d fallback tokens for expand and connect",
+		regular,
+		fallback,
+	)
+}
+
+// refillZone requests new tokens for the given zone with a single setup and
+// issue request. Both requests are made to the same endpoint.
+func refillZone(zone string) error {
+	// Skip if the token issuance quota was exceeded by another refill.
+	if retryAfter := getIssuanceQuotaRetryAfter(); clock.Now().Before(retryAfter) {
+		return fmt.Errorf("%w: retry after %s", ErrIssuanceQuotaExceeded, retryAfter.Format(time.RFC3339))
+	}
+
+	zones := []string{zone}
+	session := &issuerSession{}
+
 	// Create setup request, return if not required.
-	setupRequest, setupRequired := token.CreateSetupRequest()
+	setupRequest, setupRequired := token.CreateSetupRequestForZones(zones)
 	var setupResponse *token.SetupResponse
 	if setupRequired {
 		// Request setup data.
 		setupResponse = &token.SetupResponse{}
 		_, err := makeClientRequest(&clientRequestOptions{
 			method:            http.MethodPost,
-			url:               AccountServer + TokenRequestSetupPath,
+			path:              TokenRequestSetupPath,
 			send:              setupRequest,
 			recv:              setupResponse,
 			dataFormat:        dsd.MsgPack,
 			setAuthToken:      true,
 			logoutOnAuthError: true,
+			session:           session,
 		})
 		if err != nil {
 			return fmt.Errorf("failed to request setup data: %w", err)
@@ -414,7 +642,7 @@ func getTokens() error {
 	}
 
 	// Create request for issuing new tokens.
-	tokenRequest, requestRequired, err := token.CreateTokenRequest(setupResponse)
+	tokenRequest, requestRequired, err := token.CreateTokenRequestForZones(setupResponse, zones)
 	if err != nil {
 		return fmt.Errorf("failed to create token request: %w", err)
 	}
@@ -422,38 +650,59 @@ func getTokens() error {
 		return nil
 	}
 
-	// Request issuing new tokens.
-	issuedTokens := &token.IssuedTokens{}
+	// Request issuing new tokens and process them as they come in.
+	stream := token.NewIssuedTokensStream()
 	_, err = makeClientRequest(&clientRequestOptions{
 		method:            http.MethodPost,
-		url:               AccountServer + TokenRequestIssuePath,
+		path:              TokenRequestIssuePath,
 		send:              tokenRequest,
-		recv:              issuedTokens,
 		dataFormat:        dsd.MsgPack,
 		setAuthToken:      true,
 		logoutOnAuthError: true,
+		session:           session,
+		requestSetupFunc:  requestIssuedTokensStream,
+		recvFunc: func(resp *http.Response) error {
+			return recvIssuedTokens(resp, stream)
+		},
 	})
 	if err != nil {
 		return fmt.Errorf("failed to request tokens: %w", err)
 	}
 
 	// Save tokens to handlers.
-	err = token.ProcessIssuedTokens(issuedTokens)
+	err = stream.Finish()
 	if err != nil {
 		return fmt.Errorf("failed to process issued tokens: %w", err)
 	}
 
-	// Log new status.
-	regular, fallback := GetTokenAmount(ExpandAndConnectZones)
-	log.Infof(
-		"access: got new tokens, now at %d regular and %d fallback tokens for expand and connect",
-		regular,
-		fallback,
-	)
+	return nil
+}
 
+// requestIssuedTokensStream asks the token issuer to stream the issued tokens.
+// Token issuers that do not support streaming reply with all tokens at once.
+func requestIssuedTokensStream(request *http.Request) error {
+	accept := token.IssuedTokensStreamMIMEType
+	if existing := request.Header.Get("Accept"); existing != "" {
+		accept += ", " + existing
+	}
+	request.Header.Set("Accept", accept)
 	return nil
 }
 
+// recvIssuedTokens processes the issued tokens of the response with the
+// given stream, regardless of whether the response is streamed.
+func recvIssuedTokens(resp *http.Response, stream *token.IssuedTokensStream) error {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), token.IssuedTokensStreamMIMEType) {
+		return stream.ProcessFrom(resp.Body)
+	}
+
+	issuedTokens := &token.IssuedTokens{}
+	if _, err := dsd.LoadFromHTTPResponse(resp, issuedTokens); err != nil {
+		return err
+	}
+	return stream.ProcessAll(issuedTokens)
+}
+
 var (
 	lastHealthCheckExpires          time.Time
 	lastHealthCheckLock             sync.Mutex
@@ -465,20 +714,20 @@ func healthCheck() (ok bool) {
 	defer lastHealthCheckLock.Unlock()
 
 	// Return current value if recently checked.
-	if time.Now().Before(lastHealthCheckExpires) {
-		return tokenIssuerIsFailing.IsNotSet()
+	if clock.Now().Before(lastHealthCheckExpires) {
+		return !TokenIssuerIsFailing()
 	}
 
 	// Check health.
 	_, err := makeClientRequest(&clientRequestOptions{
 		method: http.MethodGet,
-		url:    AccountServer + HealthCheckPath,
+		path:   HealthCheckPath,
 	})
 	if err != nil {
 		log.Warningf("access: token issuer health check failed: %s", err)
 	}
 	// Update health check expiry.
-	lastHealthCheckExpires = time.Now().Add(lastHealthCheckValidityDuration)
+	lastHealthCheckExpires = clock.Now().Add(lastHealthCheckValidityDuration)
 
-	return tokenIssuerIsFailing.IsNotSet()
+	return !TokenIssuerIsFailing()
 }
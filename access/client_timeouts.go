@@ -0,0 +1,107 @@
+package access
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IssuerClientTimeouts holds the timeouts of the HTTP client that is used for
+// requests to the token issuer.
+type IssuerClientTimeouts struct {
+	// Dial is the maximum time for establishing the TCP connection.
+	Dial time.Duration
+	// TLSHandshake is the maximum time for the TLS handshake.
+	TLSHandshake time.Duration
+	// ResponseHeader is the maximum time to wait for the response headers
+	// after the request was sent.
+	ResponseHeader time.Duration
+	// Request is the maximum time for the whole request, including reading
+	// the response body.
+	Request time.Duration
+}
+
+// DefaultIssuerClientTimeouts are the default timeouts of the token issuer client.
+var DefaultIssuerClientTimeouts = IssuerClientTimeouts{
+	Dial:           5 * time.Second,
+	TLSHandshake:   5 * time.Second,
+	ResponseHeader: 10 * time.Second,
+	Request:        10 * time.Second,
+}
+
+var (
+	accountClient         = newIssuerClient(DefaultIssuerClientTimeouts)
+	accountClientTimeouts = DefaultIssuerClientTimeouts
+	accountClientLock     sync.Mutex
+)
+
+// SetIssuerClientTimeouts sets the timeouts of the HTTP client that is used
+// for requests to the token issuer. Zero values are replaced with the default.
+// Requests that are already in progress keep their previous timeouts.
+func SetIssuerClientTimeouts(timeouts IssuerClientTimeouts) error {
+	// Apply defaults.
+	if timeouts.Dial == 0 {
+		timeouts.Dial = DefaultIssuerClientTimeouts.Dial
+	}
+	if timeouts.TLSHandshake == 0 {
+		timeouts.TLSHandshake = DefaultIssuerClientTimeouts.TLSHandshake
+	}
+	if timeouts.ResponseHeader == 0 {
+		timeouts.ResponseHeader = DefaultIssuerClientTimeouts.ResponseHeader
+	}
+	if timeouts.Request == 0 {
+		timeouts.Request = DefaultIssuerClientTimeouts.Request
+	}
+
+	// Check timeouts.
+	if timeouts.Dial < 0 || timeouts.TLSHandshake < 0 ||
+		timeouts.ResponseHeader < 0 || timeouts.Request < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+
+	accountClientLock.Lock()
+	defer accountClientLock.Unlock()
+
+	accountClient = newIssuerClient(timeouts)
+	accountClientTimeouts = timeouts
+	return nil
+}
+
+// GetIssuerClientTimeouts returns the effective timeouts of the HTTP client
+// that is used for requests to the token issuer.
+func GetIssuerClientTimeouts() IssuerClientTimeouts {
+	accountClientLock.Lock()
+	defer accountClientLock.Unlock()
+
+	return accountClientTimeouts
+}
+
+// getIssuerClient returns the HTTP client for requests to the token issuer
+// and its overall request timeout.
+func getIssuerClient() (client *http.Client, requestTimeout time.Duration) {
+	accountClientLock.Lock()
+	defer accountClientLock.Unlock()
+
+	return accountClient, accountClientTimeouts.Request
+}
+
+func newIssuerClient(timeouts IssuerClientTimeouts) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   timeouts.Dial,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   timeouts.TLSHandshake,
+			ResponseHeaderTimeout: timeouts.ResponseHeader,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		Timeout: timeouts.Request,
+	}
+}
@@ -0,0 +1,49 @@
+package access
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIssuerClientTimeouts(t *testing.T) {
+	defer func() {
+		_ = SetIssuerClientTimeouts(DefaultIssuerClientTimeouts)
+	}()
+
+	// Negative timeouts are rejected.
+	if err := SetIssuerClientTimeouts(IssuerClientTimeouts{Dial: -1}); err == nil {
+		t.Fatal("negative timeout should be rejected")
+	}
+
+	// Zero values are replaced with the defaults.
+	if err := SetIssuerClientTimeouts(IssuerClientTimeouts{ResponseHeader: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	timeouts := GetIssuerClientTimeouts()
+	if timeouts.ResponseHeader != 50*time.Millisecond ||
+		timeouts.Dial != DefaultIssuerClientTimeouts.Dial ||
+		timeouts.Request != DefaultIssuerClientTimeouts.Request {
+		t.Fatalf("unexpected effective timeouts: %+v", timeouts)
+	}
+
+	// A hung server does not block the client.
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, _ := getIssuerClient()
+	started := time.Now()
+	resp, err := client.Get(server.URL)
+	if err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("request to hung server should time out")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("request took %s to time out", elapsed)
+	}
+}
@@ -0,0 +1,38 @@
+package access
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/clock"
+)
+
+var (
+	// clockJumpCheckInterval defines how often the wall clock is checked for
+	// jumps.
+	clockJumpCheckInterval = 1 * time.Minute
+
+	// clockRollbackThreshold defines how far the wall clock must be set back
+	// in order to be reported.
+	clockRollbackThreshold = 5 * time.Minute
+
+	clockJumpDetector *clock.JumpDetector
+)
+
+// checkClockRollback checks if the wall clock was set back since the last
+// check. Scheduling and timeouts are not affected, as they use the monotonic
+// clock, but comparisons with timestamps set by the token issuer, such as the
+// end of the subscription, are.
+func checkClockRollback(_ context.Context, _ *modules.Task) error {
+	jump := clockJumpDetector.Check()
+	if jump < -clockRollbackThreshold {
+		log.Warningf(
+			"access: system clock was set back by %s, checks against timestamps of the token issuer may be inaccurate",
+			-jump,
+		)
+		recordEvent(EventClockRollback, "set back by %s", -jump)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/safing/portbase/database"
 	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/formats/dsd"
 	"github.com/safing/spn/access/account"
 )
 
@@ -22,10 +23,114 @@ var db = database.NewInterface(&database.Options{
 	Internal: true,
 })
 
+// RecordStore is a storage backend for the user and auth token records.
+// It is satisfied by the portbase database interface.
+type RecordStore interface {
+	// Get returns the record with the given key.
+	// Must return database.ErrNotFound if the record does not exist.
+	Get(key string) (record.Record, error)
+	// Put stores the given record.
+	Put(r record.Record) error
+	// Delete removes the record with the given key.
+	Delete(key string) error
+}
+
+var (
+	recordStore     RecordStore = db
+	recordStoreLock sync.Mutex
+)
+
+// SetRecordStore sets the storage backend for the user and auth token records
+// and clears the cached records.
+// It must be called before the access module starts.
+func SetRecordStore(store RecordStore) {
+	recordStoreLock.Lock()
+	defer recordStoreLock.Unlock()
+
+	recordStore = store
+	clearUserCaches()
+}
+
+func getRecordStore() RecordStore {
+	recordStoreLock.Lock()
+	defer recordStoreLock.Unlock()
+
+	return recordStore
+}
+
+// MemoryRecordStore stores records in memory.
+// It is mainly intended for testing.
+type MemoryRecordStore struct {
+	lock    sync.Mutex
+	records map[string]record.Record
+
+	// wrap defines whether records are stored serialized, like the database
+	// does. Records are then returned wrapped when loaded.
+	wrap bool
+}
+
+// NewMemoryRecordStore returns a new, empty in-memory record store. If wrap is
+// true, records are stored serialized and returned wrapped, as the database
+// does for records that were not cached.
+func NewMemoryRecordStore(wrap bool) *MemoryRecordStore {
+	return &MemoryRecordStore{
+		records: make(map[string]record.Record),
+		wrap:    wrap,
+	}
+}
+
+// Get returns the record with the given key.
+func (mrs *MemoryRecordStore) Get(key string) (record.Record, error) {
+	mrs.lock.Lock()
+	defer mrs.lock.Unlock()
+
+	r, ok := mrs.records[key]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	return r, nil
+}
+
+// Put stores the given record.
+func (mrs *MemoryRecordStore) Put(r record.Record) error {
+	mrs.lock.Lock()
+	defer mrs.lock.Unlock()
+
+	if mrs.wrap {
+		data, err := r.Marshal(r, dsd.JSON)
+		if err != nil {
+			return fmt.Errorf("failed to serialize record: %w", err)
+		}
+		wrapper, err := record.NewWrapper(r.Key(), r.Meta().Duplicate(), dsd.JSON, data)
+		if err != nil {
+			return fmt.Errorf("failed to wrap record: %w", err)
+		}
+		r = wrapper
+	}
+
+	mrs.records[r.Key()] = r
+	return nil
+}
+
+// Delete removes the record with the given key.
+func (mrs *MemoryRecordStore) Delete(key string) error {
+	mrs.lock.Lock()
+	defer mrs.lock.Unlock()
+
+	if _, ok := mrs.records[key]; !ok {
+		return database.ErrNotFound
+	}
+	delete(mrs.records, key)
+	return nil
+}
+
 type UserRecord struct {
 	record.Base
 	sync.Mutex
 
+	// SchemaVersion is the schema version the record was stored with.
+	SchemaVersion int
+
 	*account.User
 
 	MayUseSPN  bool
@@ -36,6 +141,9 @@ type AuthTokenRecord struct {
 	record.Base
 	sync.Mutex
 
+	// SchemaVersion is the schema version the record was stored with.
+	SchemaVersion int
+
 	Token *account.AuthToken
 }
 
@@ -104,28 +212,41 @@ func GetUser() (*UserRecord, error) {
 	}
 
 	// Load from disk.
-	r, err := db.Get(userRecordKey)
+	r, err := getRecordStore().Get(userRecordKey)
 	if err != nil {
 		return nil, err
 	}
 
 	// Unwrap record.
+	var new *UserRecord
 	if r.IsWrapped() {
 		// only allocate a new struct, if we need it
-		new := &UserRecord{}
+		new = &UserRecord{}
 		err = record.Unwrap(r, new)
 		if err != nil {
 			return nil, err
 		}
-		cachedUser = new
-		return cachedUser, nil
+	} else {
+		// Or adjust type.
+		var ok bool
+		new, ok = r.(*UserRecord)
+		if !ok {
+			return nil, fmt.Errorf("record not of type *UserRecord, but %T", r)
+		}
 	}
 
-	// Or adjust type.
-	new, ok := r.(*UserRecord)
-	if !ok {
-		return nil, fmt.Errorf("record not of type *UserRecord, but %T", r)
+	// Migrate record to the current schema and save it in the new format.
+	migrated, err := new.migrateSchema()
+	if err != nil {
+		return nil, err
+	}
+	if migrated {
+		new.UpdateMeta()
+		if err := getRecordStore().Put(new); err != nil {
+			return nil, fmt.Errorf("failed to save migrated user record: %w", err)
+		}
 	}
+
 	cachedUser = new
 	return cachedUser, nil
 }
@@ -137,6 +258,7 @@ func (user *UserRecord) Save() error {
 		defer user.Unlock()
 
 		user.MayUseSPN = user.User.MayUseSPN()
+		user.SchemaVersion = CurrentRecordSchema
 	}()
 
 	// Update cache.
@@ -150,7 +272,7 @@ func (user *UserRecord) Save() error {
 	}
 	user.UpdateMeta()
 
-	return db.Put(user)
+	return getRecordStore().Put(user)
 }
 
 func GetAuthToken() (*AuthTokenRecord, error) {
@@ -162,33 +284,53 @@ func GetAuthToken() (*AuthTokenRecord, error) {
 	}
 
 	// Load from disk.
-	r, err := db.Get(authTokenRecordKey)
+	r, err := getRecordStore().Get(authTokenRecordKey)
 	if err != nil {
 		return nil, err
 	}
 
 	// Unwrap record.
+	var new *AuthTokenRecord
 	if r.IsWrapped() {
 		// only allocate a new struct, if we need it
-		new := &AuthTokenRecord{}
+		new = &AuthTokenRecord{}
 		err = record.Unwrap(r, new)
 		if err != nil {
 			return nil, err
 		}
-		cachedAuthToken = new
-		return new, nil
+	} else {
+		// Or adjust type.
+		var ok bool
+		new, ok = r.(*AuthTokenRecord)
+		if !ok {
+			return nil, fmt.Errorf("record not of type *AuthTokenRecord, but %T", r)
+		}
 	}
 
-	// Or adjust type.
-	new, ok := r.(*AuthTokenRecord)
-	if !ok {
-		return nil, fmt.Errorf("record not of type *AuthTokenRecord, but %T", r)
+	// Migrate record to the current schema and save it in the new format.
+	migrated, err := new.migrateSchema()
+	if err != nil {
+		return nil, err
+	}
+	if migrated {
+		new.UpdateMeta()
+		if err := getRecordStore().Put(new); err != nil {
+			return nil, fmt.Errorf("failed to save migrated auth token record: %w", err)
+		}
 	}
+
 	cachedAuthToken = new
 	return new, nil
 }
 
 func (authToken *AuthTokenRecord) Save() error {
+	func() {
+		authToken.Lock()
+		defer authToken.Unlock()
+
+		authToken.SchemaVersion = CurrentRecordSchema
+	}()
+
 	// Update cache.
 	accountCacheLock.Lock()
 	defer accountCacheLock.Unlock()
@@ -202,5 +344,5 @@ func (authToken *AuthTokenRecord) Save() error {
 	authToken.Meta().MakeSecret()
 	authToken.Meta().MakeCrownJewel()
 
-	return db.Put(authToken)
+	return getRecordStore().Put(authToken)
 }
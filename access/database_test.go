@@ -0,0 +1,99 @@
+package access
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/spn/access/account"
+)
+
+func testResponseWithToken(token string) *http.Response {
+	resp := &http.Response{Header: make(http.Header)}
+	resp.Header.Set(account.AuthHeaderNextToken, token)
+	return resp
+}
+
+func TestUserRecordStore(t *testing.T) {
+	defer SetRecordStore(db)
+
+	for _, wrap := range []bool{false, true} {
+		SetRecordStore(NewMemoryRecordStore(wrap))
+
+		// Nothing stored yet.
+		if _, err := GetUser(); !errors.Is(err, database.ErrNotFound) {
+			t.Fatalf("wrap=%v: expected ErrNotFound, got %v", wrap, err)
+		}
+
+		// Save and get from cache.
+		user := &UserRecord{
+			User: &account.User{
+				Username: "test",
+				State:    account.UserStateApproved,
+			},
+		}
+		if err := user.Save(); err != nil {
+			t.Fatalf("wrap=%v: %s", wrap, err)
+		}
+		cached, err := GetUser()
+		if err != nil {
+			t.Fatalf("wrap=%v: %s", wrap, err)
+		}
+		if cached != user {
+			t.Fatalf("wrap=%v: expected cached user", wrap)
+		}
+
+		// Load from the store.
+		clearUserCaches()
+		loaded, err := GetUser()
+		if err != nil {
+			t.Fatalf("wrap=%v: %s", wrap, err)
+		}
+		if loaded.Username != "test" {
+			t.Fatalf("wrap=%v: unexpected username %q", wrap, loaded.Username)
+		}
+		if wrap == (loaded == user) {
+			t.Fatalf("wrap=%v: unexpected record instance", wrap)
+		}
+	}
+}
+
+func TestAuthTokenRecordStore(t *testing.T) {
+	defer SetRecordStore(db)
+
+	for _, wrap := range []bool{false, true} {
+		SetRecordStore(NewMemoryRecordStore(wrap))
+
+		// A response without a token is rejected.
+		if err := SaveNewAuthToken("device", &http.Response{}); !errors.Is(err, account.ErrMissingToken) {
+			t.Fatalf("wrap=%v: expected ErrMissingToken, got %v", wrap, err)
+		}
+
+		// Save new token.
+		if err := SaveNewAuthToken("device", testResponseWithToken("token-1")); err != nil {
+			t.Fatalf("wrap=%v: %s", wrap, err)
+		}
+		clearUserCaches()
+		authToken, err := GetAuthToken()
+		if err != nil {
+			t.Fatalf("wrap=%v: %s", wrap, err)
+		}
+		if token := authToken.GetToken(); token.Device != "device" || token.Token != "token-1" {
+			t.Fatalf("wrap=%v: unexpected token %+v", wrap, token)
+		}
+
+		// Update token.
+		if err := authToken.Update(testResponseWithToken("token-2")); err != nil {
+			t.Fatalf("wrap=%v: %s", wrap, err)
+		}
+		clearUserCaches()
+		authToken, err = GetAuthToken()
+		if err != nil {
+			t.Fatalf("wrap=%v: %s", wrap, err)
+		}
+		if token := authToken.GetToken(); token.Device != "device" || token.Token != "token-2" {
+			t.Fatalf("wrap=%v: unexpected token %+v", wrap, token)
+		}
+	}
+}
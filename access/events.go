@@ -0,0 +1,87 @@
+package access
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Access event types.
+const (
+	EventLoggedIn             = "logged-in"
+	EventLoginFailed          = "login-failed"
+	EventLoggedOut            = "logged-out"
+	EventTokenIssuerFailed    = "token-issuer-failed"
+	EventTokenIssuerRecovered = "token-issuer-recovered"
+	EventTokensRefilled       = "tokens-refilled"
+	EventTierChanged          = "tier-changed"
+	EventSPNEnabled           = "spn-enabled"
+	EventSPNDisabled          = "spn-disabled"
+	EventClockRollback        = "clock-rollback"
+)
+
+// maxRecentEvents defines how many access events are kept in memory.
+const maxRecentEvents = 100
+
+// AccessEvent describes a state transition of the access module.
+// Events must not contain personal information beyond device and zone
+// identifiers, as they are meant to be included in diagnostic data.
+type AccessEvent struct {
+	Time    time.Time
+	Type    string
+	Message string
+}
+
+var (
+	recentEvents     [maxRecentEvents]AccessEvent
+	recentEventsNext int
+	recentEventsFull bool
+	recentEventsLock sync.Mutex
+)
+
+// recordEvent adds an event to the recent events ring buffer, overwriting the
+// oldest event when it is full.
+func recordEvent(eventType, format string, a ...interface{}) {
+	event := AccessEvent{
+		Time:    time.Now(),
+		Type:    eventType,
+		Message: fmt.Sprintf(format, a...),
+	}
+
+	recentEventsLock.Lock()
+	defer recentEventsLock.Unlock()
+
+	recentEvents[recentEventsNext] = event
+	recentEventsNext++
+	if recentEventsNext >= maxRecentEvents {
+		recentEventsNext = 0
+		recentEventsFull = true
+	}
+}
+
+// RecentEvents returns the recent state transitions of the access module,
+// ordered from oldest to newest.
+func RecentEvents() []AccessEvent {
+	recentEventsLock.Lock()
+	defer recentEventsLock.Unlock()
+
+	if !recentEventsFull {
+		events := make([]AccessEvent, recentEventsNext)
+		copy(events, recentEvents[:recentEventsNext])
+		return events
+	}
+
+	events := make([]AccessEvent, 0, maxRecentEvents)
+	events = append(events, recentEvents[recentEventsNext:]...)
+	events = append(events, recentEvents[:recentEventsNext]...)
+	return events
+}
+
+// resetRecentEvents removes all recorded events.
+func resetRecentEvents() {
+	recentEventsLock.Lock()
+	defer recentEventsLock.Unlock()
+
+	recentEventsNext = 0
+	recentEventsFull = false
+}
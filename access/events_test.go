@@ -0,0 +1,42 @@
+package access
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRecentEvents(t *testing.T) {
+	resetRecentEvents()
+	defer resetRecentEvents()
+
+	// Check empty state.
+	if len(RecentEvents()) != 0 {
+		t.Fatal("expected no events")
+	}
+
+	// Add some events.
+	recordEvent(EventLoggedIn, "device %s", "abc")
+	recordEvent(EventTokensRefilled, "zone %s", "pblind1")
+	events := RecentEvents()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Type != EventLoggedIn || events[0].Message != "device abc" {
+		t.Errorf("unexpected first event: %+v", events[0])
+	}
+
+	// Overflow the ring buffer.
+	for i := 0; i < maxRecentEvents+10; i++ {
+		recordEvent(EventTokensRefilled, "%d", i)
+	}
+	events = RecentEvents()
+	if len(events) != maxRecentEvents {
+		t.Fatalf("expected %d events, got %d", maxRecentEvents, len(events))
+	}
+	if events[0].Message != "10" {
+		t.Errorf("expected oldest event to be 10, got %s", events[0].Message)
+	}
+	if events[len(events)-1].Message != fmt.Sprintf("%d", maxRecentEvents+9) {
+		t.Errorf("unexpected newest event: %s", events[len(events)-1].Message)
+	}
+}
@@ -0,0 +1,180 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/spn/access/token"
+)
+
+// Issuance test phases.
+const (
+	IssuancePhaseSetup    = "setup"
+	IssuancePhaseRequest  = "request"
+	IssuancePhaseIssue    = "issue"
+	IssuancePhaseFinalize = "finalize"
+	IssuancePhaseVerify   = "verify"
+)
+
+// IssuanceTestResult holds the result of a token issuance test.
+type IssuanceTestResult struct {
+	Zone    string
+	Success bool
+	// FailedPhase is the phase in which the test failed.
+	FailedPhase string `json:",omitempty"`
+	// Error describes why the test failed.
+	Error string `json:",omitempty"`
+	// TokensIssued is the amount of tokens the issuer issued for the test.
+	// The issuer counts them like any other issued tokens.
+	TokensIssued int
+	// Phases holds the completed phases in the order they were run.
+	Phases []*IssuancePhaseResult
+	// Duration is the total duration of the test.
+	Duration time.Duration
+}
+
+// IssuancePhaseResult holds the result of a single phase of an issuance test.
+type IssuancePhaseResult struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// TestIssuance runs a full token issuance round-trip for the given zone
+// against the live token issuer: It requests the setup, creates the token
+// request, has the tokens issued, finalizes them and verifies one of them.
+// The test uses a scratch token handler, which is discarded afterwards, so
+// the real token store is not affected. As the tokens are really issued, the
+// smallest batch size the zone permits is requested and the amount of issued
+// tokens is reported in the result.
+// An error is only returned if the test could not be started. A failed test
+// is reported in the result.
+func TestIssuance(zone string) (*IssuanceTestResult, error) {
+	// Get zone config.
+	var zc *ZoneConfig
+	for _, c := range getZoneConfigs() {
+		if c.Zone == zone {
+			zc = c
+			break
+		}
+	}
+	switch {
+	case zc == nil:
+		return nil, fmt.Errorf("unknown zone %q", zone)
+	case zc.Type != ZoneTypePBlind:
+		return nil, fmt.Errorf("zone %q has type %s, only %s zones can be tested", zone, zc.Type, ZoneTypePBlind)
+	}
+
+	// Check if the user may request tokens.
+	user, err := GetUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if !user.MayUseTheSPN() {
+		return nil, ErrMayNotUseSPN
+	}
+
+	// Do not run requests in parallel to other requests, as every response
+	// rotates the auth token.
+	clientRequestLock.Lock()
+	defer clientRequestLock.Unlock()
+
+	// Create scratch handler that requests the smallest permitted batch size.
+	scratch, err := zc.newPBlindHandler(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer scratch.Close()
+	scratch.SetPreferredBatchSize(1)
+
+	result := &IssuanceTestResult{
+		Zone: zone,
+	}
+	started := time.Now()
+	defer func() {
+		result.Duration = time.Since(started)
+	}()
+
+	// runPhase runs a phase and records its duration or failure.
+	runPhase := func(phase string, fn func() error) bool {
+		phaseStarted := time.Now()
+		if err := fn(); err != nil {
+			result.FailedPhase = phase
+			result.Error = err.Error()
+			return false
+		}
+		result.Phases = append(result.Phases, &IssuancePhaseResult{
+			Phase:    phase,
+			Duration: time.Since(phaseStarted),
+		})
+		return true
+	}
+
+	var (
+		setupResponse = &token.SetupResponse{}
+		tokenRequest  *token.PBlindTokenRequest
+		issuedTokens  = &token.IssuedTokens{}
+		session       = &issuerSession{}
+	)
+	ok := runPhase(IssuancePhaseSetup, func() error {
+		_, err := makeClientRequest(&clientRequestOptions{
+			method: http.MethodPost,
+			path:   TokenRequestSetupPath,
+			send: &token.SetupRequest{
+				PBlind: map[string]*token.PBlindSetupRequest{
+					zone: {
+						BatchSize: scratch.PreferredBatchSize(),
+					},
+				},
+			},
+			recv:         setupResponse,
+			dataFormat:   dsd.MsgPack,
+			setAuthToken: true,
+			session:      session,
+		})
+		return err
+	}) && runPhase(IssuancePhaseRequest, func() error {
+		pblindSetup, ok := setupResponse.PBlind[zone]
+		if !ok {
+			return errors.New("setup response is missing the zone")
+		}
+		tokenRequest, err = scratch.CreateTokenRequest(pblindSetup)
+		return err
+	}) && runPhase(IssuancePhaseIssue, func() error {
+		_, err := makeClientRequest(&clientRequestOptions{
+			method: http.MethodPost,
+			path:   TokenRequestIssuePath,
+			send: &token.TokenRequest{
+				SessionID: setupResponse.SessionID,
+				PBlind: map[string]*token.PBlindTokenRequest{
+					zone: tokenRequest,
+				},
+			},
+			recv:         issuedTokens,
+			dataFormat:   dsd.MsgPack,
+			setAuthToken: true,
+			session:      session,
+		})
+		if pblindTokens, ok := issuedTokens.PBlind[zone]; ok {
+			result.TokensIssued = len(pblindTokens.Msgs)
+		}
+		return err
+	}) && runPhase(IssuancePhaseFinalize, func() error {
+		pblindTokens, ok := issuedTokens.PBlind[zone]
+		if !ok {
+			return errors.New("issued tokens are missing the zone")
+		}
+		return scratch.ProcessIssuedTokens(pblindTokens)
+	}) && runPhase(IssuancePhaseVerify, func() error {
+		t, err := scratch.GetToken()
+		if err != nil {
+			return err
+		}
+		return scratch.VerifySignatureOnly(t)
+	})
+	result.Success = ok
+
+	return result, nil
+}
@@ -0,0 +1,16 @@
+package access
+
+import (
+	"testing"
+)
+
+func TestTestIssuanceZoneChecks(t *testing.T) {
+	t.Parallel()
+
+	if _, err := TestIssuance("does-not-exist"); err == nil {
+		t.Fatal("unknown zone should be rejected")
+	}
+	if _, err := TestIssuance("alpha2"); err == nil {
+		t.Fatal("scramble zone should be rejected")
+	}
+}
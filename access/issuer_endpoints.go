@@ -0,0 +1,142 @@
+package access
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// issuerEndpoint is a token issuer endpoint with its own failure tracking.
+type issuerEndpoint struct {
+	url     string
+	breaker *circuitBreaker
+}
+
+// IssuerEndpointInfo holds the state of a token issuer endpoint for
+// diagnostics.
+type IssuerEndpointInfo struct {
+	URL     string
+	Primary bool
+	Active  bool
+	Breaker *BreakerInfo
+}
+
+var (
+	issuerEndpoints      = newIssuerEndpoints([]string{AccountServer})
+	activeIssuerEndpoint *issuerEndpoint
+	issuerEndpointsLock  sync.Mutex
+)
+
+func newIssuerEndpoints(urls []string) []*issuerEndpoint {
+	endpoints := make([]*issuerEndpoint, 0, len(urls))
+	for _, u := range urls {
+		endpoints = append(endpoints, &issuerEndpoint{
+			url: u,
+			breaker: &circuitBreaker{
+				state: BreakerClosed,
+			},
+		})
+	}
+	return endpoints
+}
+
+// SetIssuerEndpoints sets the base URLs of the token issuer. The first
+// endpoint is the primary and is preferred while it is healthy. The other
+// endpoints are used in the given order while the preceding ones are failing.
+// If no endpoints are given, the default AccountServer is used.
+func SetIssuerEndpoints(urls []string) error {
+	if len(urls) == 0 {
+		urls = []string{AccountServer}
+	}
+
+	// Check endpoints.
+	seen := make(map[string]struct{}, len(urls))
+	cleaned := make([]string, 0, len(urls))
+	for _, u := range urls {
+		u = strings.TrimSuffix(u, "/")
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("invalid token issuer endpoint %q: %w", u, err)
+		}
+		if parsed.Scheme != "https" && parsed.Scheme != "http" {
+			return fmt.Errorf("token issuer endpoint %q must use http or https", u)
+		}
+		if parsed.Host == "" {
+			return fmt.Errorf("token issuer endpoint %q has no host", u)
+		}
+		if _, ok := seen[u]; ok {
+			return fmt.Errorf("duplicate token issuer endpoint %q", u)
+		}
+		seen[u] = struct{}{}
+		cleaned = append(cleaned, u)
+	}
+
+	issuerEndpointsLock.Lock()
+	defer issuerEndpointsLock.Unlock()
+
+	// Keep the state of the endpoints if they did not change.
+	if len(cleaned) == len(issuerEndpoints) {
+		unchanged := true
+		for i, endpoint := range issuerEndpoints {
+			if endpoint.url != cleaned[i] {
+				unchanged = false
+				break
+			}
+		}
+		if unchanged {
+			return nil
+		}
+	}
+
+	issuerEndpoints = newIssuerEndpoints(cleaned)
+	activeIssuerEndpoint = nil
+	return nil
+}
+
+// selectIssuerEndpoints returns the endpoints that may be used for a request
+// now, in order of preference. If pinned is set, only the pinned endpoint is
+// considered. The result of the request to every returned endpoint must be
+// reported to its breaker, or the endpoint released.
+func selectIssuerEndpoints(now time.Time, pinned *issuerEndpoint) []*issuerEndpoint {
+	issuerEndpointsLock.Lock()
+	defer issuerEndpointsLock.Unlock()
+
+	selected := make([]*issuerEndpoint, 0, len(issuerEndpoints))
+	for _, endpoint := range issuerEndpoints {
+		if pinned != nil && endpoint != pinned {
+			continue
+		}
+		if endpoint.breaker.allow(now) {
+			selected = append(selected, endpoint)
+		}
+	}
+	return selected
+}
+
+// setActiveIssuerEndpoint marks the given endpoint as the one that last
+// served a request successfully.
+func setActiveIssuerEndpoint(endpoint *issuerEndpoint) {
+	issuerEndpointsLock.Lock()
+	defer issuerEndpointsLock.Unlock()
+
+	activeIssuerEndpoint = endpoint
+}
+
+// GetIssuerEndpointsInfo returns the state of all token issuer endpoints.
+func GetIssuerEndpointsInfo() []*IssuerEndpointInfo {
+	issuerEndpointsLock.Lock()
+	defer issuerEndpointsLock.Unlock()
+
+	infos := make([]*IssuerEndpointInfo, 0, len(issuerEndpoints))
+	for i, endpoint := range issuerEndpoints {
+		infos = append(infos, &IssuerEndpointInfo{
+			URL:     endpoint.url,
+			Primary: i == 0,
+			Active:  endpoint == activeIssuerEndpoint,
+			Breaker: endpoint.breaker.info(),
+		})
+	}
+	return infos
+}
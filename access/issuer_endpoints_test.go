@@ -0,0 +1,223 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/safing/spn/access/account"
+	"github.com/safing/spn/clock"
+)
+
+func TestIssuerEndpointFailover(t *testing.T) {
+	defer func() {
+		_ = SetIssuerEndpoints(nil)
+		tokenIssuerBreaker.success()
+	}()
+
+	// Invalid endpoints are rejected.
+	if err := SetIssuerEndpoints([]string{"ftp://example.com"}); err == nil {
+		t.Fatal("endpoint with invalid scheme should be rejected")
+	}
+	if err := SetIssuerEndpoints([]string{"https://example.com", "https://example.com/"}); err == nil {
+		t.Fatal("duplicate endpoints should be rejected")
+	}
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backup.Close()
+
+	if err := SetIssuerEndpoints([]string{primary.URL, backup.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The backup serves the requests while the primary is failing.
+	for i := 0; i < DefaultTokenIssuerBreakerThreshold; i++ {
+		_, err := makeClientRequest(&clientRequestOptions{
+			method: http.MethodGet,
+			path:   HealthCheckPath,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if TokenIssuerIsFailing() {
+			t.Fatal("token issuer should not be failing while the backup works")
+		}
+	}
+
+	infos := GetIssuerEndpointsInfo()
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 endpoints, got %d", len(infos))
+	}
+	if !infos[0].Primary || infos[0].Active || infos[0].Breaker.State != BreakerOpen {
+		t.Fatalf("unexpected primary state: %+v", infos[0])
+	}
+	if !infos[1].Active || infos[1].Breaker.State != BreakerClosed {
+		t.Fatalf("unexpected backup state: %+v", infos[1])
+	}
+
+	// If all endpoints fail repeatedly, the token issuer is failing.
+	backup.Close()
+	for i := 0; i < DefaultTokenIssuerBreakerThreshold; i++ {
+		_, err := makeClientRequest(&clientRequestOptions{
+			method: http.MethodGet,
+			path:   HealthCheckPath,
+		})
+		if err == nil {
+			t.Fatal("request should fail if all endpoints are failing")
+		}
+	}
+	if !TokenIssuerIsFailing() {
+		t.Fatal("token issuer should be failing if all endpoints failed")
+	}
+}
+
+func TestIssuerEndpointNoUnsafeFailover(t *testing.T) {
+	defer func() {
+		_ = SetIssuerEndpoints(nil)
+		tokenIssuerBreaker.success()
+	}()
+
+	var primaryRequests, backupRequests int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryRequests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backupRequests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backup.Close()
+
+	if err := SetIssuerEndpoints([]string{primary.URL, backup.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A POST request that reached the primary is not repeated at the backup.
+	_, err := makeClientRequest(&clientRequestOptions{
+		method: http.MethodPost,
+		path:   TokenRequestIssuePath,
+	})
+	if err == nil {
+		t.Fatal("request should fail, as it may not be repeated")
+	}
+	if primaryRequests != 1 || backupRequests != 0 {
+		t.Fatalf("unexpected requests: primary=%d backup=%d", primaryRequests, backupRequests)
+	}
+
+	// A session is pinned to the endpoint that replied first.
+	tokenIssuerBreaker.success()
+	session := &issuerSession{}
+	_, err = makeClientRequest(&clientRequestOptions{
+		method:  http.MethodGet,
+		path:    HealthCheckPath,
+		session: session,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.endpoint == nil || session.endpoint.url != backup.URL {
+		t.Fatal("session should be pinned to the backup")
+	}
+}
+
+func TestIssuerRequestCanceled(t *testing.T) {
+	defer func() {
+		_ = SetIssuerEndpoints(nil)
+		tokenIssuerBreaker.success()
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	if err := SetIssuerEndpoints([]string{server.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Canceled requests are not regarded as failures.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < DefaultTokenIssuerBreakerThreshold; i++ {
+		_, err := makeClientRequest(&clientRequestOptions{
+			method: http.MethodGet,
+			path:   HealthCheckPath,
+			requestSetupFunc: func(r *http.Request) error {
+				*r = *r.WithContext(ctx)
+				return nil
+			},
+		})
+		if err == nil {
+			t.Fatal("canceled request should fail")
+		}
+	}
+	if info := GetTokenIssuerBreakerInfo(); info.State != BreakerClosed || info.ConsecutiveFailures != 0 {
+		t.Fatalf("canceled requests should not count as failures: %+v", info)
+	}
+	if info := GetIssuerEndpointsInfo()[0].Breaker; info.State != BreakerClosed || info.ConsecutiveFailures != 0 {
+		t.Fatalf("canceled requests should not count as endpoint failures: %+v", info)
+	}
+}
+
+func TestIssuanceQuotaRetryAfter(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	defer clock.Set(fake)()
+	defer func() {
+		_ = SetIssuerEndpoints(nil)
+		tokenIssuerBreaker.success()
+		setIssuanceQuotaRetryAfter(time.Time{})
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account.ApplyRetryAfterToResponse(w, start.Add(2*time.Minute))
+		w.WriteHeader(account.StatusQuotaExceeded)
+	}))
+	defer server.Close()
+	if err := SetIssuerEndpoints([]string{server.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The delay of the token issuer is honored.
+	_, err := makeClientRequest(&clientRequestOptions{
+		method: http.MethodPost,
+		path:   TokenRequestIssuePath,
+	})
+	if !errors.Is(err, ErrIssuanceQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if retryAfter := getIssuanceQuotaRetryAfter(); !retryAfter.Equal(start.Add(2 * time.Minute)) {
+		t.Fatalf("unexpected retry after: %s", retryAfter)
+	}
+	if TokenIssuerIsFailing() {
+		t.Fatal("exceeded quota should not count as failure")
+	}
+
+	// Without a delay, the default retry duration is used.
+	noDelayServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(account.StatusQuotaExceeded)
+	}))
+	defer noDelayServer.Close()
+	if err := SetIssuerEndpoints([]string{noDelayServer.URL}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = makeClientRequest(&clientRequestOptions{
+		method: http.MethodPost,
+		path:   TokenRequestIssuePath,
+	})
+	if !errors.Is(err, ErrIssuanceQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if retryAfter := getIssuanceQuotaRetryAfter(); !retryAfter.Equal(start.Add(tokenIssuerRetryDuration)) {
+		t.Fatalf("unexpected retry after: %s", retryAfter)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/safing/spn/access/account"
-	"github.com/tevino/abool"
+	"github.com/safing/spn/access/token"
+	"github.com/safing/spn/clock"
 
 	"github.com/safing/portbase/config"
 	"github.com/safing/portbase/log"
@@ -20,18 +22,38 @@ var (
 
 	accountUpdateTask *modules.Task
 
-	tokenIssuerIsFailing     = abool.New()
 	tokenIssuerRetryDuration = 10 * time.Minute
+
+	// accountUpdateDrainTimeout defines how long stopping the module waits for
+	// running account updates to finish before cancelling them.
+	accountUpdateDrainTimeout = 10 * time.Second
+	// accountUpdates tracks running account updates.
+	accountUpdates sync.WaitGroup
+	// accountUpdatesDraining is set while stopping the module, so that no new
+	// account updates are added while waiting for the running ones.
+	accountUpdatesDraining bool
+	accountUpdatesLock     sync.Mutex
+
+	// lifecycleCtx is used for requests to the token issuer. In contrast to the
+	// module context, it is only cancelled when stopping the module after
+	// running account updates had the chance to finish.
+	lifecycleCtx       context.Context
+	cancelLifecycleCtx context.CancelFunc
+	lifecycleCtxLock   sync.Mutex
 )
 
 // Errors.
 var (
-	ErrDeviceIsLocked       = errors.New("device is locked")
-	ErrDeviceLimitReached   = errors.New("device limit reached")
-	ErrFallbackNotAvailable = errors.New("fallback tokens not available, token issuer is online")
-	ErrInvalidCredentials   = errors.New("invalid credentials")
-	ErrMayNotUseSPN         = errors.New("may not use SPN")
-	ErrNotLoggedIn          = errors.New("not logged in")
+	ErrDeviceIsLocked         = errors.New("device is locked")
+	ErrDeviceLimitReached     = errors.New("device limit reached")
+	ErrFallbackNotAvailable   = errors.New("fallback tokens not available, token issuer is online")
+	ErrInvalidCredentials     = errors.New("invalid credentials")
+	ErrMayNotUseSPN           = errors.New("may not use SPN")
+	ErrNotLoggedIn            = errors.New("not logged in")
+	ErrZoneAboveTier          = errors.New("zone requires a higher account tier")
+	ErrInvalidZoneConfig      = errors.New("invalid zone config")
+	ErrTokenIssuerUnavailable = errors.New("token issuer unavailable, waiting for retry")
+	ErrIssuanceQuotaExceeded  = errors.New("token issuance quota of this device exceeded, try again later")
 )
 
 func init() {
@@ -51,15 +73,44 @@ func prep() error {
 }
 
 func start() error {
+	// Enable token issuance metrics.
+	token.EnableMetrics()
+
+	// Get the account tier of the client for initializing the zones.
+	if conf.Client() {
+		if user, err := GetUser(); err == nil {
+			clientTierLock.Lock()
+			clientTier = user.GetTier()
+			clientTierLock.Unlock()
+		}
+	}
+
 	// Initialize zones.
 	if err := initializeZones(); err != nil {
 		return err
 	}
 
+	// Create context for requests to the token issuer.
+	lifecycleCtxLock.Lock()
+	lifecycleCtx, cancelLifecycleCtx = context.WithCancel(context.Background())
+	lifecycleCtxLock.Unlock()
+
+	// Allow account updates.
+	accountUpdatesLock.Lock()
+	accountUpdatesDraining = false
+	accountUpdatesLock.Unlock()
+
 	if conf.Client() {
 		// Load tokens from database.
 		loadTokens()
 
+		// Watch for the wall clock being set back.
+		clockJumpDetector = clock.NewJumpDetector()
+		module.NewTask(
+			"check clock rollback",
+			checkClockRollback,
+		).Repeat(clockJumpCheckInterval)
+
 		// Register new task.
 		accountUpdateTask = module.NewTask(
 			"update account",
@@ -77,29 +128,119 @@ func stop() error {
 		accountUpdateTask.Cancel()
 		accountUpdateTask = nil
 
+		// Wait for running account updates to finish, so that no tokens are lost.
+		drainAccountUpdates()
+	}
+
+	// Cancel any remaining requests to the token issuer.
+	lifecycleCtxLock.Lock()
+	if cancelLifecycleCtx != nil {
+		cancelLifecycleCtx()
+	}
+	lifecycleCtx, cancelLifecycleCtx = nil, nil
+	lifecycleCtxLock.Unlock()
+
+	if conf.Client() {
 		// Store tokens to database.
 		storeTokens()
 	}
 
+	// Write remaining token receipts and close the sink.
+	DisableTokenReceipts()
+
 	// Reset zones.
 	resetZones()
 
 	return nil
 }
 
+// drainAccountUpdates waits for running account updates to finish. If they
+// do not finish within accountUpdateDrainTimeout, their requests to the token
+// issuer are cancelled.
+func drainAccountUpdates() {
+	// Stop accepting new account updates before waiting, as the wait group
+	// must not be added to while waiting.
+	accountUpdatesLock.Lock()
+	accountUpdatesDraining = true
+	accountUpdatesLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		accountUpdates.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(accountUpdateDrainTimeout):
+	}
+
+	// Cancel requests and give the update a moment to return.
+	log.Warningf("access: account update did not finish within %s, cancelling", accountUpdateDrainTimeout)
+	lifecycleCtxLock.Lock()
+	if cancelLifecycleCtx != nil {
+		cancelLifecycleCtx()
+	}
+	lifecycleCtxLock.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		log.Warningf("access: account update did not return after being cancelled")
+	}
+}
+
+// addAccountUpdate adds a running account update to the tracked updates. It
+// returns false if the module is stopping and the update must not be started.
+func addAccountUpdate() bool {
+	accountUpdatesLock.Lock()
+	defer accountUpdatesLock.Unlock()
+
+	if accountUpdatesDraining {
+		return false
+	}
+	accountUpdates.Add(1)
+	return true
+}
+
+// getLifecycleCtx returns the context to use for requests to the token
+// issuer. If the module is not started, the background context is returned.
+func getLifecycleCtx() context.Context {
+	lifecycleCtxLock.Lock()
+	defer lifecycleCtxLock.Unlock()
+
+	if lifecycleCtx == nil {
+		return context.Background()
+	}
+	return lifecycleCtx
+}
+
 func UpdateAccount(_ context.Context, task *modules.Task) error {
+	// Track running update for draining on shutdown.
+	if !addAccountUpdate() {
+		return errors.New("module is stopping")
+	}
+	defer accountUpdates.Done()
+
 	// Retry sooner if the token issuer is failing.
 	defer func() {
-		if tokenIssuerIsFailing.IsSet() && task != nil {
+		if TokenIssuerIsFailing() && task != nil {
 			task.Schedule(time.Now().Add(tokenIssuerRetryDuration))
 		}
 	}()
 
-	_, _, err := getUserProfile()
+	user, _, err := getUserProfile()
 	if err != nil {
 		return fmt.Errorf("failed to update user profile: %w", err)
 	}
 
+	// Update the zones to the account tier.
+	err = updateClientTier(user.GetTier())
+	if err != nil {
+		return fmt.Errorf("failed to update zones to account tier: %w", err)
+	}
+
 	err = getTokens()
 	if err != nil {
 		return fmt.Errorf("failed to get tokens: %w", err)
@@ -112,25 +253,38 @@ func enableSPN() {
 	err := config.SetConfigOption("spn/enable", true)
 	if err != nil {
 		log.Warningf("access: failed to enable the SPN during login: %s", err)
+		return
 	}
+	recordEvent(EventSPNEnabled, "enabled during login")
 }
 
 func disableSPN() {
 	err := config.SetConfigOption("spn/enable", false)
 	if err != nil {
 		log.Warningf("access: failed to disable the SPN during logout: %s", err)
+		return
 	}
+	recordEvent(EventSPNDisabled, "disabled during logout")
 }
 
+// TokenIssuerIsFailing returns whether the circuit breaker wrapping the
+// requests to the token issuer is open or half-open.
 func TokenIssuerIsFailing() bool {
-	return tokenIssuerIsFailing.IsSet()
+	return tokenIssuerBreaker.isFailing()
+}
+
+func tokenIssuerSucceeded(url string) {
+	if tokenIssuerBreaker.success() {
+		recordEvent(EventTokenIssuerRecovered, "request to %s succeeded", url)
+	}
 }
 
 func tokenIssuerFailed() {
-	if !tokenIssuerIsFailing.SetToIf(false, true) {
+	if !tokenIssuerBreaker.failure(clock.Now()) {
 		return
 	}
-	if !module.Online() {
+	recordEvent(EventTokenIssuerFailed, "retrying in %s", tokenIssuerRetryDuration)
+	if !module.Online() || accountUpdateTask == nil {
 		return
 	}
 
@@ -155,3 +309,11 @@ func (user *UserRecord) MayUseTheSPN() bool {
 
 	return user.User.MayUseSPN()
 }
+
+// GetTier returns the effective account tier of the user.
+func (user *UserRecord) GetTier() int {
+	user.Lock()
+	defer user.Unlock()
+
+	return user.User.GetTier()
+}
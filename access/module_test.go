@@ -1,7 +1,9 @@
 package access
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/safing/portmaster/core/pmtesting"
 )
@@ -9,3 +11,43 @@ import (
 func TestMain(m *testing.M) {
 	pmtesting.TestMain(m, module)
 }
+
+func TestDrainAccountUpdates(t *testing.T) {
+	defer func(timeout time.Duration) {
+		accountUpdateDrainTimeout = timeout
+		lifecycleCtxLock.Lock()
+		lifecycleCtx, cancelLifecycleCtx = context.WithCancel(context.Background())
+		lifecycleCtxLock.Unlock()
+		accountUpdatesLock.Lock()
+		accountUpdatesDraining = false
+		accountUpdatesLock.Unlock()
+	}(accountUpdateDrainTimeout)
+	accountUpdateDrainTimeout = 100 * time.Millisecond
+
+	// Finished updates are waited for.
+	if !addAccountUpdate() {
+		t.Fatal("account update should be added")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		accountUpdates.Done()
+	}()
+	drainAccountUpdates()
+	if getLifecycleCtx().Err() != nil {
+		t.Fatal("lifecycle context should not be cancelled if the update finished")
+	}
+	if addAccountUpdate() {
+		t.Fatal("account updates must not be added while draining")
+	}
+
+	// Hanging updates are cancelled.
+	accountUpdates.Add(1)
+	go func() {
+		<-getLifecycleCtx().Done()
+		accountUpdates.Done()
+	}()
+	drainAccountUpdates()
+	if getLifecycleCtx().Err() == nil {
+		t.Fatal("lifecycle context should be cancelled if the update did not finish")
+	}
+}
@@ -30,7 +30,7 @@ func AuthorizeToTerminal(t terminal.OpTerminal) (*AuthorizeOp, *terminal.Error)
 	op := &AuthorizeOp{}
 	op.Init(0)
 
-	newToken, err := GetToken(ExpandAndConnectZones)
+	newToken, err := GetToken(GetExpandAndConnectZones())
 	if err != nil {
 		return nil, terminal.ErrInternalError.With("failed to get access token: %w", err)
 	}
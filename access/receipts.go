@@ -0,0 +1,277 @@
+package access
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/safing/jess/lhash"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/access/token"
+	"github.com/safing/spn/hub"
+)
+
+/*
+
+Token Redemption Receipts:
+
+A Hub may issue a signed receipt for every token it accepts, which proves that
+the Hub accepted a valid token at a time. Receipts support dispute resolution
+and billing reconciliation without revealing the tokens themselves.
+
+Receipt Format (DSD/JSON, signed with the Hub's identity key):
+
+- Version: the receipt format version
+- HubID: the ID of the accepting Hub
+- Zone: the zone of the token
+- Fingerprint: BLAKE2b-256 labeled hash of the raw token
+- Timestamp: unix timestamp, rounded down to TokenReceiptTimeGranularity
+
+Unlinkability:
+
+The fingerprint is taken from the unblinded token, which the token issuer never
+sees, so it cannot be linked to the issuance. The token serial is not included,
+as the issuer knows which serials were issued in which batch. The timestamp is
+rounded, so that receipts cannot be correlated with other records by exact
+time. Only the holder of a token can match a receipt to it.
+
+*/
+
+// TokenReceiptVersion is the current version of the receipt format.
+const TokenReceiptVersion = 1
+
+// TokenReceiptTimeGranularity defines the precision of receipt timestamps.
+var TokenReceiptTimeGranularity = time.Minute
+
+// TokenReceipt proves that a Hub accepted a token.
+type TokenReceipt struct {
+	Version     int
+	HubID       string
+	Zone        string
+	Fingerprint []byte
+	Timestamp   int64
+}
+
+// ReceiptSigner signs a receipt.
+type ReceiptSigner func(data []byte) ([]byte, error)
+
+// ReceiptSink stores or streams signed receipts.
+type ReceiptSink interface {
+	// Store stores or streams a signed receipt.
+	Store(receipt *TokenReceipt, signed []byte) error
+	// Close closes the sink after the last receipt was stored.
+	Close() error
+}
+
+// tokenReceiptQueueSize defines how many receipts may wait to be signed and
+// stored. Receipts are dropped if the queue is full.
+const tokenReceiptQueueSize = 1000
+
+var (
+	receiptHubID string
+	receiptQueue chan *TokenReceipt
+	receiptsLock sync.Mutex
+)
+
+// EnableTokenReceipts enables issuing receipts for accepted tokens. Receipts
+// are signed by the given signer, which should use the identity key of the
+// Hub with the given ID, and are passed to the given sink by a single worker.
+// The sink is closed when token receipts are disabled again.
+func EnableTokenReceipts(hubID string, signer ReceiptSigner, sink ReceiptSink) error {
+	if hubID == "" || signer == nil || sink == nil {
+		return errors.New("hub ID, signer and sink are required for token receipts")
+	}
+
+	receiptsLock.Lock()
+	defer receiptsLock.Unlock()
+
+	// Stop the writer of the previous sink.
+	if receiptQueue != nil {
+		close(receiptQueue)
+	}
+
+	receiptHubID = hubID
+	receiptQueue = make(chan *TokenReceipt, tokenReceiptQueueSize)
+	module.StartWorker("write token receipts", tokenReceiptWriter(receiptQueue, signer, sink))
+	return nil
+}
+
+// DisableTokenReceipts disables issuing receipts for accepted tokens. Queued
+// receipts are still written before the sink is closed.
+func DisableTokenReceipts() {
+	receiptsLock.Lock()
+	defer receiptsLock.Unlock()
+
+	if receiptQueue != nil {
+		close(receiptQueue)
+	}
+	receiptHubID = ""
+	receiptQueue = nil
+}
+
+// TokenFingerprint returns the fingerprint of the given token, as used in
+// receipts.
+func TokenFingerprint(t *token.Token) []byte {
+	return lhash.Digest(lhash.BLAKE2b_256, t.Raw()).Bytes()
+}
+
+// newTokenReceipt creates a receipt for the given token at the given time.
+func newTokenReceipt(hubID string, t *token.Token, at time.Time) *TokenReceipt {
+	return &TokenReceipt{
+		Version:     TokenReceiptVersion,
+		HubID:       hubID,
+		Zone:        t.Zone,
+		Fingerprint: TokenFingerprint(t),
+		Timestamp:   at.Truncate(TokenReceiptTimeGranularity).Unix(),
+	}
+}
+
+// issueTokenReceipt issues a receipt for the given accepted token, if enabled.
+// Signing and storing is done in the background.
+func issueTokenReceipt(t *token.Token) {
+	receiptsLock.Lock()
+	defer receiptsLock.Unlock()
+
+	if receiptQueue == nil {
+		return
+	}
+
+	select {
+	case receiptQueue <- newTokenReceipt(receiptHubID, t, time.Now()):
+	default:
+		log.Warningf("spn/access: token receipt queue is full, dropping receipt")
+	}
+}
+
+// tokenReceiptWriter returns a worker that signs and stores the receipts of
+// the given queue until it is closed, and then closes the sink.
+func tokenReceiptWriter(queue <-chan *TokenReceipt, signer ReceiptSigner, sink ReceiptSink) func(context.Context) error {
+	write := func(receipt *TokenReceipt) {
+		signed, err := receipt.sign(signer)
+		if err != nil {
+			log.Warningf("spn/access: failed to issue token receipt: %s", err)
+			return
+		}
+		if err := sink.Store(receipt, signed); err != nil {
+			log.Warningf("spn/access: failed to store token receipt: %s", err)
+		}
+	}
+
+	return func(ctx context.Context) error {
+		defer func() {
+			if err := sink.Close(); err != nil {
+				log.Warningf("spn/access: failed to close token receipt sink: %s", err)
+			}
+		}()
+
+		for {
+			select {
+			case receipt, ok := <-queue:
+				if !ok {
+					return nil
+				}
+				write(receipt)
+
+			case <-ctx.Done():
+				// Write queued receipts before stopping.
+				for {
+					select {
+					case receipt, ok := <-queue:
+						if !ok {
+							return nil
+						}
+						write(receipt)
+					default:
+						return nil
+					}
+				}
+			}
+		}
+	}
+}
+
+// sign serializes and signs the receipt.
+func (r *TokenReceipt) sign(signer ReceiptSigner) ([]byte, error) {
+	data, err := dsd.Dump(r, dsd.JSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack receipt: %w", err)
+	}
+	return signer(data)
+}
+
+// VerifyTokenReceipt checks the signature of the given receipt against the
+// given Hub and returns the receipt.
+func VerifyTokenReceipt(signed []byte, h *hub.Hub) (*TokenReceipt, error) {
+	data, _, _, err := hub.OpenHubMsg(h, signed, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify receipt signature: %w", err)
+	}
+
+	receipt := &TokenReceipt{}
+	if _, err := dsd.Load(data, receipt); err != nil {
+		return nil, fmt.Errorf("failed to parse receipt: %w", err)
+	}
+	if receipt.Version != TokenReceiptVersion {
+		return nil, fmt.Errorf("unsupported receipt version %d", receipt.Version)
+	}
+	if receipt.HubID != h.ID {
+		return nil, fmt.Errorf("receipt was issued for hub %s, but signed by %s", receipt.HubID, h.ID)
+	}
+	return receipt, nil
+}
+
+// Matches returns whether the receipt was issued for the given token.
+func (r *TokenReceipt) Matches(t *token.Token) bool {
+	return r.Zone == t.Zone && bytes.Equal(r.Fingerprint, TokenFingerprint(t))
+}
+
+// FileReceiptSink appends signed receipts to a file, one base64 encoded
+// receipt per line.
+type FileReceiptSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFileReceiptSink returns a sink that appends signed receipts to the file
+// at the given path.
+func NewFileReceiptSink(path string) (*FileReceiptSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open receipt file: %w", err)
+	}
+
+	return &FileReceiptSink{
+		file: file,
+	}, nil
+}
+
+// Store appends the signed receipt to the file.
+func (s *FileReceiptSink) Store(_ *TokenReceipt, signed []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return errors.New("receipt file is closed")
+	}
+	_, err := s.file.WriteString(base64.StdEncoding.EncodeToString(signed) + "\n")
+	return err
+}
+
+// Close closes the file. Receipts cannot be stored anymore after closing.
+func (s *FileReceiptSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,152 @@
+package access
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/spn/access/token"
+)
+
+func TestTokenReceipt(t *testing.T) {
+	t.Parallel()
+
+	tk := &token.Token{Zone: "test", Data: []byte("token data")}
+	other := &token.Token{Zone: "test", Data: []byte("other data")}
+
+	at := time.Date(2021, 5, 4, 13, 37, 42, 0, time.UTC)
+	receipt := newTokenReceipt("hub", tk, at)
+
+	// Check timestamp rounding.
+	if receipt.Timestamp != at.Truncate(TokenReceiptTimeGranularity).Unix() {
+		t.Errorf("receipt timestamp %d was not rounded", receipt.Timestamp)
+	}
+
+	// Check matching.
+	if !receipt.Matches(tk) {
+		t.Error("receipt should match its token")
+	}
+	if receipt.Matches(other) {
+		t.Error("receipt should not match other token")
+	}
+
+	// The receipt must not contain the token itself.
+	if bytes.Contains(receipt.Fingerprint, tk.Data) {
+		t.Error("receipt fingerprint must not contain the token")
+	}
+
+	// Sign with a fake signer and check the signed data.
+	signed, err := receipt.sign(func(data []byte) ([]byte, error) {
+		return data, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &TokenReceipt{}
+	if _, err := dsd.Load(signed, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Matches(tk) || loaded.HubID != "hub" || loaded.Timestamp != receipt.Timestamp {
+		t.Errorf("loaded receipt %+v does not match issued receipt %+v", loaded, receipt)
+	}
+}
+
+func TestFileReceiptSink(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "receipts")
+	sink, err := NewFileReceiptSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"first", "second"} {
+		if err := sink.Store(nil, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Store(nil, []byte("closed")); err == nil {
+		t.Fatal("storing to a closed sink should fail")
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 receipts, got %d", len(lines))
+	}
+	decoded, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "second" {
+		t.Errorf("unexpected receipt %q", decoded)
+	}
+}
+
+type testReceiptSink struct {
+	sync.Mutex
+	stored []*TokenReceipt
+	closed bool
+}
+
+func (s *testReceiptSink) Store(receipt *TokenReceipt, _ []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stored = append(s.stored, receipt)
+	return nil
+}
+
+func (s *testReceiptSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+func TestTokenReceiptWriter(t *testing.T) {
+	sink := &testReceiptSink{}
+	err := EnableTokenReceipts("hub", func(data []byte) ([]byte, error) {
+		return data, nil
+	}, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Receipts are written by a single worker.
+	for i := 0; i < 10; i++ {
+		issueTokenReceipt(&token.Token{Zone: "test", Data: []byte{byte(i)}})
+	}
+
+	// Disabling writes the queued receipts and closes the sink.
+	DisableTokenReceipts()
+	issueTokenReceipt(&token.Token{Zone: "test", Data: []byte("disabled")})
+	for i := 0; ; i++ {
+		sink.Lock()
+		closed, stored := sink.closed, len(sink.stored)
+		sink.Unlock()
+		if closed {
+			if stored != 10 {
+				t.Fatalf("expected 10 stored receipts, got %d", stored)
+			}
+			break
+		}
+		if i > 100 {
+			t.Fatal("sink was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,81 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultRefillConcurrency is the default maximum number of token refill
+// requests that are in flight at the same time.
+const DefaultRefillConcurrency = 2
+
+var (
+	refillConcurrency     = DefaultRefillConcurrency
+	refillConcurrencyLock sync.Mutex
+)
+
+// SetRefillConcurrency sets the maximum number of zones that are refilled at
+// the same time. Every zone is refilled with its own requests to the token
+// issuer. Further zones wait until a running refill finishes. This limits the
+// load on the token issuer, for example after an outage, when all zones are
+// empty at the same time.
+// Refills that are already in progress are not affected.
+func SetRefillConcurrency(n int) error {
+	if n < 1 {
+		return errors.New("refill concurrency must be at least 1")
+	}
+
+	refillConcurrencyLock.Lock()
+	defer refillConcurrencyLock.Unlock()
+
+	refillConcurrency = n
+	return nil
+}
+
+// GetRefillConcurrency returns the maximum number of zones that are refilled
+// at the same time.
+func GetRefillConcurrency() int {
+	refillConcurrencyLock.Lock()
+	defer refillConcurrencyLock.Unlock()
+
+	return refillConcurrency
+}
+
+// refillConcurrently calls refill for every given zone, with at most the
+// configured refill concurrency running at the same time. It returns the
+// errors of the refills in the order of the given zones. Zones that did not
+// start before the context was canceled fail with the context error.
+func refillConcurrently(ctx context.Context, zones []string, refill func(zone string) error) []error {
+	var (
+		slots = make(chan struct{}, GetRefillConcurrency())
+		errs  = make([]error, len(zones))
+		wg    sync.WaitGroup
+	)
+
+	for i, zone := range zones {
+		// Wait for a free slot.
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			for j := i; j < len(zones); j++ {
+				errs[j] = ctx.Err()
+			}
+			wg.Wait()
+			return errs
+		}
+
+		wg.Add(1)
+		go func(i int, zone string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			errs[i] = refill(zone)
+		}(i, zone)
+	}
+
+	wg.Wait()
+	return errs
+}
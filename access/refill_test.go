@@ -0,0 +1,87 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRefillConcurrency(t *testing.T) {
+	defer func() {
+		_ = SetRefillConcurrency(DefaultRefillConcurrency)
+	}()
+
+	if GetRefillConcurrency() != DefaultRefillConcurrency {
+		t.Fatalf("unexpected default concurrency %d", GetRefillConcurrency())
+	}
+	if err := SetRefillConcurrency(0); err == nil {
+		t.Fatal("concurrency of 0 should be rejected")
+	}
+	if err := SetRefillConcurrency(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refill more zones than may be in flight and track the maximum.
+	var (
+		inFlight    int
+		maxInFlight int
+		lock        sync.Mutex
+		zones       = []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+		errFailed   = errors.New("failed")
+	)
+	errs := refillConcurrently(context.Background(), zones, func(zone string) error {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+
+		if zone == "c" {
+			return errFailed
+		}
+		return nil
+	})
+
+	if maxInFlight != 3 {
+		t.Fatalf("expected at most 3 refills in flight, got %d", maxInFlight)
+	}
+	for i, err := range errs {
+		if zones[i] == "c" {
+			if !errors.Is(err, errFailed) {
+				t.Fatalf("expected zone c to fail, got %v", err)
+			}
+		} else if err != nil {
+			t.Fatalf("unexpected error for zone %s: %s", zones[i], err)
+		}
+	}
+
+	// Zones that did not start before the context is canceled are not refilled.
+	if err := SetRefillConcurrency(1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var started int
+	errs = refillConcurrently(ctx, zones, func(zone string) error {
+		started++
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	if started != 1 {
+		t.Fatalf("expected a single refill to start, got %d", started)
+	}
+	for i, err := range errs[1:] {
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected zone %s to be canceled, got %v", zones[i+1], err)
+		}
+	}
+}
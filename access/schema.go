@@ -0,0 +1,129 @@
+package access
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/log"
+)
+
+// Schema versions of the stored user and auth token records and the stored
+// tokens.
+// Increase CurrentRecordSchema and add migrations for the previous version
+// whenever the stored format of a record changes.
+const (
+	// RecordSchemaV1 is the format of records stored before schema versions
+	// were introduced. Records without a schema version are regarded as v1.
+	RecordSchemaV1 = 1
+	// RecordSchemaV2 adds the schema version to the records and stores tokens
+	// with a schema version.
+	RecordSchemaV2 = 2
+
+	// CurrentRecordSchema is the schema version records are stored with.
+	CurrentRecordSchema = RecordSchemaV2
+)
+
+// recordMigrations maps a schema version to the migration to the next version.
+type recordMigrations map[int]func() error
+
+// migrateRecordSchema migrates a record from the given schema version to the
+// current schema version by running all migrations in order. Versions without
+// a migration only need their schema version updated.
+// Records with a newer schema version, eg. after a downgrade, are used as is,
+// as newer schema versions only add to the format.
+// It returns whether the record was migrated.
+func migrateRecordSchema(recordType string, version *int, migrations recordMigrations) (migrated bool, err error) {
+	if *version < RecordSchemaV1 {
+		*version = RecordSchemaV1
+	}
+	switch {
+	case *version == CurrentRecordSchema:
+		return false, nil
+	case *version > CurrentRecordSchema:
+		log.Warningf(
+			"access: %s has schema version %d, but only up to %d is supported, ignoring unknown data",
+			recordType, *version, CurrentRecordSchema,
+		)
+		return false, nil
+	}
+
+	for *version < CurrentRecordSchema {
+		if migrate, ok := migrations[*version]; ok {
+			if err := migrate(); err != nil {
+				return false, fmt.Errorf("failed to migrate %s from schema version %d: %w", recordType, *version, err)
+			}
+		}
+		*version++
+	}
+
+	return true, nil
+}
+
+// migrateSchema migrates the user record to the current schema version.
+func (user *UserRecord) migrateSchema() (migrated bool, err error) {
+	user.Lock()
+	defer user.Unlock()
+
+	return migrateRecordSchema("user record", &user.SchemaVersion, recordMigrations{
+		// v1 records stored MayUseSPN as it was when the record was last saved,
+		// which may be stale. Derive it from the stored user again.
+		RecordSchemaV1: func() error {
+			if user.User == nil {
+				return errors.New("missing user data")
+			}
+			user.MayUseSPN = user.Subscription != nil && user.User.MayUseSPN()
+			return nil
+		},
+	})
+}
+
+// migrateSchema migrates the auth token record to the current schema version.
+func (authToken *AuthTokenRecord) migrateSchema() (migrated bool, err error) {
+	authToken.Lock()
+	defer authToken.Unlock()
+
+	return migrateRecordSchema("auth token record", &authToken.SchemaVersion, recordMigrations{
+		// v1 records were saved without checking the token.
+		RecordSchemaV1: func() error {
+			if authToken.Token == nil || authToken.Token.Token == "" {
+				return errors.New("missing auth token")
+			}
+			return nil
+		},
+	})
+}
+
+// storedTokens is the format of stored tokens since RecordSchemaV2.
+// Tokens stored with RecordSchemaV1 are the raw exported tokens.
+type storedTokens struct {
+	SchemaVersion int
+	Data          []byte
+}
+
+// packStoredTokens packs the given exported tokens for storage.
+func packStoredTokens(data []byte) ([]byte, error) {
+	return json.Marshal(&storedTokens{
+		SchemaVersion: CurrentRecordSchema,
+		Data:          data,
+	})
+}
+
+// unpackStoredTokens returns the exported tokens from the given stored data
+// with the given format and migrates them to the current schema version.
+func unpackStoredTokens(zone string, format uint8, data []byte) ([]byte, error) {
+	// Tokens stored with v1 are stored raw.
+	if format == dsd.RAW {
+		return data, nil
+	}
+
+	stored := &storedTokens{}
+	if err := json.Unmarshal(data, stored); err != nil {
+		return nil, fmt.Errorf("failed to parse stored %s tokens: %w", zone, err)
+	}
+	if _, err := migrateRecordSchema(zone+" tokens", &stored.SchemaVersion, nil); err != nil {
+		return nil, err
+	}
+	return stored.Data, nil
+}
@@ -0,0 +1,147 @@
+package access
+
+import (
+	"testing"
+
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/spn/access/account"
+)
+
+// v1UserRecord is a user record in the format stored before schema versions
+// were introduced.
+const v1UserRecord = `{
+	"username": "legacy",
+	"state": "suspended",
+	"device": {"name": "legacy-device", "id": "legacy-device-id"},
+	"MayUseSPN": true
+}`
+
+func TestUserRecordSchemaMigration(t *testing.T) {
+	defer SetRecordStore(db)
+	store := NewMemoryRecordStore(false)
+	SetRecordStore(store)
+
+	// Store v1 record.
+	r, err := record.NewWrapper(userRecordKey, &record.Meta{}, dsd.JSON, []byte(v1UserRecord))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Load with current code.
+	user, err := GetUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.SchemaVersion != CurrentRecordSchema {
+		t.Fatalf("expected schema version %d, got %d", CurrentRecordSchema, user.SchemaVersion)
+	}
+	if user.Username != "legacy" || user.State != account.UserStateSuspended ||
+		user.Device == nil || user.Device.Name != "legacy-device" {
+		t.Fatalf("user data was not migrated correctly: %+v", user.User)
+	}
+	if user.MayUseSPN {
+		t.Fatal("stale MayUseSPN field of suspended user should have been migrated")
+	}
+
+	// The migrated record must have been saved.
+	stored, err := store.Get(userRecordKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedUser, ok := stored.(*UserRecord)
+	if !ok || storedUser.SchemaVersion != CurrentRecordSchema {
+		t.Fatalf("migrated record was not saved: %T", stored)
+	}
+}
+
+func TestNewerRecordSchema(t *testing.T) {
+	defer SetRecordStore(db)
+	store := NewMemoryRecordStore(false)
+	SetRecordStore(store)
+
+	// Store record from a newer version.
+	r, err := record.NewWrapper(
+		authTokenRecordKey,
+		&record.Meta{},
+		dsd.JSON,
+		[]byte(`{"SchemaVersion": 99, "Token": {"Device": "device", "Token": "token"}, "Unknown": true}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading must use the known data without touching the stored record.
+	authToken, err := GetAuthToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authToken.SchemaVersion != 99 {
+		t.Fatalf("schema version must be kept, got %d", authToken.SchemaVersion)
+	}
+	if authToken.Token == nil || authToken.Token.Device != "device" || authToken.Token.Token != "token" {
+		t.Fatalf("auth token was not loaded correctly: %+v", authToken.Token)
+	}
+	if stored, _ := store.Get(authTokenRecordKey); stored != r {
+		t.Fatal("record from newer version must not be overwritten")
+	}
+}
+
+func TestAuthTokenRecordSchemaMigration(t *testing.T) {
+	defer SetRecordStore(db)
+	store := NewMemoryRecordStore(false)
+	SetRecordStore(store)
+
+	// Store v1 record without a token.
+	r, err := record.NewWrapper(authTokenRecordKey, &record.Meta{}, dsd.JSON, []byte(`{"Token": {"Device": "device"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading must fail, as there is no token.
+	if _, err := GetAuthToken(); err == nil {
+		t.Fatal("migrating auth token record without token should fail")
+	}
+}
+
+func TestStoredTokensSchema(t *testing.T) {
+	t.Parallel()
+
+	exported := []byte("exported tokens")
+
+	// Tokens stored with v1 are raw.
+	data, err := unpackStoredTokens("test", dsd.RAW, exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(exported) {
+		t.Fatalf("unexpected v1 tokens: %q", data)
+	}
+
+	// Current tokens are packed with the schema version.
+	packed, err := packStoredTokens(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = unpackStoredTokens("test", dsd.JSON, packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(exported) {
+		t.Fatalf("unexpected packed tokens: %q", data)
+	}
+
+	// Corrupted data must fail.
+	if _, err := unpackStoredTokens("test", dsd.JSON, exported); err == nil {
+		t.Fatal("unpacking corrupted tokens should fail")
+	}
+}
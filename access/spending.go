@@ -0,0 +1,65 @@
+package access
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/safing/spn/access/token"
+)
+
+// TokenSpendingStrategy orders the zones that may satisfy a token request.
+// Tokens are taken from the first zone in the returned order that has
+// tokens available. The given slice must not be modified.
+type TokenSpendingStrategy func(zones []string) []string
+
+var (
+	tokenSpendingStrategy     TokenSpendingStrategy = SpendInGivenOrder
+	tokenSpendingStrategyLock sync.Mutex
+)
+
+// SpendInGivenOrder spends tokens from the zones in the order they were
+// requested in, only moving on to the next zone when a zone is empty.
+// This is the default strategy.
+func SpendInGivenOrder(zones []string) []string {
+	return zones
+}
+
+// SpendFromLargestSupply spends tokens from the zone with the most tokens
+// first. This desynchronizes when the zones run empty, so that the zones do
+// not all need a refill from the token issuer at the same time.
+// Zones with the same amount of tokens keep their requested order.
+func SpendFromLargestSupply(zones []string) []string {
+	amounts := make(map[string]int, len(zones))
+	for _, zone := range zones {
+		if handler, ok := token.GetHandler(zone); ok {
+			amounts[zone] = handler.Amount()
+		}
+	}
+
+	ordered := make([]string, len(zones))
+	copy(ordered, zones)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return amounts[ordered[i]] > amounts[ordered[j]]
+	})
+	return ordered
+}
+
+// SetTokenSpendingStrategy sets the strategy for choosing which zone to spend
+// tokens from. Setting nil restores the default SpendInGivenOrder strategy.
+func SetTokenSpendingStrategy(strategy TokenSpendingStrategy) {
+	if strategy == nil {
+		strategy = SpendInGivenOrder
+	}
+
+	tokenSpendingStrategyLock.Lock()
+	defer tokenSpendingStrategyLock.Unlock()
+
+	tokenSpendingStrategy = strategy
+}
+
+func getTokenSpendingStrategy() TokenSpendingStrategy {
+	tokenSpendingStrategyLock.Lock()
+	defer tokenSpendingStrategyLock.Unlock()
+
+	return tokenSpendingStrategy
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/safing/portbase/database"
@@ -14,44 +15,217 @@ import (
 	"github.com/safing/spn/access/token"
 )
 
-func loadTokens() {
-	for _, zone := range persistentZones {
-		// Get handler of zone.
-		handler, ok := token.GetHandler(zone)
-		if !ok {
-			log.Warningf("access: could not find zone %s for loading tokens", zone)
-			continue
+// ErrNoStoredTokens is returned by a TokenStore if there are no stored tokens
+// for a zone.
+var ErrNoStoredTokens = errors.New("no stored tokens")
+
+// TokenStore is a storage backend for the exported tokens of a zone.
+type TokenStore interface {
+	// Save stores the exported tokens of the given zone.
+	Save(zone string, data []byte) error
+	// Load returns the exported tokens of the given zone.
+	// Must return ErrNoStoredTokens if there is nothing stored.
+	Load(zone string) ([]byte, error)
+	// Delete removes the stored tokens of the given zone.
+	Delete(zone string) error
+	// Clear removes all stored tokens, including zones no longer in use.
+	Clear() (n int, err error)
+}
+
+var (
+	tokenStore     TokenStore = &DatabaseTokenStore{}
+	tokenStoreLock sync.Mutex
+)
+
+// SetTokenStore sets the storage backend for tokens.
+// It must be called before the access module starts.
+func SetTokenStore(store TokenStore) {
+	tokenStoreLock.Lock()
+	defer tokenStoreLock.Unlock()
+
+	tokenStore = store
+}
+
+func getTokenStore() TokenStore {
+	tokenStoreLock.Lock()
+	defer tokenStoreLock.Unlock()
+
+	return tokenStore
+}
+
+// DatabaseTokenStore stores tokens in the portbase database.
+// This is the default token store.
+type DatabaseTokenStore struct{}
+
+// Save stores the exported tokens of the given zone.
+func (dbs *DatabaseTokenStore) Save(zone string, data []byte) error {
+	// Pack data with the schema version and wrap into record.
+	packed, err := packStoredTokens(data)
+	if err != nil {
+		return fmt.Errorf("failed to pack tokens: %w", err)
+	}
+	r, err := record.NewWrapper(fmt.Sprintf(tokenStorageKeyTemplate, zone), nil, dsd.JSON, packed)
+	if err != nil {
+		return fmt.Errorf("failed to prepare record: %w", err)
+	}
+
+	// Let tokens expire after one month.
+	// This will regularly happen when we switch zones.
+	r.UpdateMeta()
+	r.Meta().MakeSecret()
+	r.Meta().MakeCrownJewel()
+	r.Meta().SetRelativateExpiry(30 * 86400)
+
+	// Save to database.
+	return db.Put(r)
+}
+
+// Load returns the exported tokens of the given zone.
+func (dbs *DatabaseTokenStore) Load(zone string) ([]byte, error) {
+	// Get data from database.
+	r, err := db.Get(fmt.Sprintf(tokenStorageKeyTemplate, zone))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrNoStoredTokens
 		}
+		return nil, err
+	}
 
-		// Get data from database.
-		r, err := db.Get(fmt.Sprintf(tokenStorageKeyTemplate, zone))
-		if err != nil {
-			if errors.Is(err, database.ErrNotFound) {
-				log.Debugf("access: no %s tokens to load", zone)
-			} else {
-				log.Warningf("access: failed to load %s tokens: %s", zone, err)
-			}
+	// Get wrapper.
+	wrapper, ok := r.(*record.Wrapper)
+	if !ok {
+		return nil, fmt.Errorf("expected wrapper, got %T", r)
+	}
+	return unpackStoredTokens(zone, wrapper.Format, wrapper.Data)
+}
+
+// Delete removes the stored tokens of the given zone.
+func (dbs *DatabaseTokenStore) Delete(zone string) error {
+	return db.Delete(fmt.Sprintf(tokenStorageKeyTemplate, zone))
+}
+
+// Clear removes all stored tokens.
+func (dbs *DatabaseTokenStore) Clear() (n int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return db.Purge(ctx, query.New(fmt.Sprintf(tokenStorageKeyTemplate, "")))
+}
+
+// MemoryTokenStore stores tokens in memory.
+// It is mainly intended for testing.
+type MemoryTokenStore struct {
+	lock   sync.Mutex
+	tokens map[string][]byte
+}
+
+// NewMemoryTokenStore returns a new, empty in-memory token store.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: make(map[string][]byte),
+	}
+}
+
+// Save stores the exported tokens of the given zone.
+func (ms *MemoryTokenStore) Save(zone string, data []byte) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	copied := make([]byte, len(data))
+	copy(copied, data)
+	ms.tokens[zone] = copied
+	return nil
+}
+
+// Load returns the exported tokens of the given zone.
+func (ms *MemoryTokenStore) Load(zone string) ([]byte, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	data, ok := ms.tokens[zone]
+	if !ok {
+		return nil, ErrNoStoredTokens
+	}
+	return data, nil
+}
+
+// Delete removes the stored tokens of the given zone.
+func (ms *MemoryTokenStore) Delete(zone string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	delete(ms.tokens, zone)
+	return nil
+}
+
+// Clear removes all stored tokens.
+func (ms *MemoryTokenStore) Clear() (n int, err error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	n = len(ms.tokens)
+	ms.tokens = make(map[string][]byte)
+	return n, nil
+}
+
+func loadTokens() {
+	store := getTokenStore()
+
+	for _, zone := range getPersistentZones() {
+		// Skip zones that are not initialized for the account tier.
+		if !mayInitializeZone(zone) {
 			continue
 		}
 
-		// Get wrapper.
-		wrapper, ok := r.(*record.Wrapper)
+		// Get handler of zone.
+		handler, ok := token.GetHandler(zone)
 		if !ok {
-			log.Warningf("access: failed to parse %s tokens: expected wrapper, got %T", zone, r)
+			log.Warningf("access: could not find zone %s for loading tokens", zone)
 			continue
 		}
 
-		// Load into handler.
-		err = handler.Load(wrapper.Data)
-		if err != nil {
+		loadZoneTokens(store, zone, handler)
+	}
+}
+
+func loadZoneTokens(store TokenStore, zone string, handler token.Handler) {
+	// Get data from store.
+	data, err := store.Load(zone)
+	if err != nil {
+		if errors.Is(err, ErrNoStoredTokens) {
+			log.Debugf("access: no %s tokens to load", zone)
+		} else {
 			log.Warningf("access: failed to load %s tokens: %s", zone, err)
 		}
-		log.Infof("access: loaded %d %s tokens", handler.Amount(), zone)
+		return
+	}
+
+	// Load into handler.
+	// Skip invalid tokens, so that a single corrupted token does not discard
+	// all stored tokens.
+	loaded, dropped, err := handler.LoadLenient(data)
+	if err != nil {
+		log.Warningf("access: failed to load %s tokens: %s", zone, err)
+		return
 	}
+	if dropped > 0 {
+		log.Warningf("access: dropped %d invalid or corrupted %s tokens from storage, loaded %d", dropped, zone, loaded)
+		// Repair storage by saving the remaining tokens.
+		storeZoneTokens(store, zone, handler)
+		return
+	}
+	log.Infof("access: loaded %d %s tokens", loaded, zone)
 }
 
 func storeTokens() {
-	for _, zone := range persistentZones {
+	store := getTokenStore()
+
+	for _, zone := range getPersistentZones() {
+		// Skip zones that are not initialized for the account tier.
+		if !mayInitializeZone(zone) {
+			continue
+		}
+
 		// Get handler of zone.
 		handler, ok := token.GetHandler(zone)
 		if !ok {
@@ -59,55 +233,42 @@ func storeTokens() {
 			continue
 		}
 
-		// Generate storage key.
-		storageKey := fmt.Sprintf(tokenStorageKeyTemplate, zone)
-
-		// Check if there is data to save.
-		amount := handler.Amount()
-		if amount == 0 {
-			// Remove possible old entry from database.
-			err := db.Delete(storageKey)
-			if err != nil {
-				log.Warningf("access: failed to delete possible old %s tokens from storage: %s", err)
-			}
-			log.Debugf("access: no %s tokens to store")
-			continue
-		}
-
-		// Export data.
-		data, err := handler.Save()
-		if err != nil {
-			log.Warningf("access: failed to export %s tokens for storing: %s", zone, err)
-			continue
-		}
+		storeZoneTokens(store, zone, handler)
+	}
+}
 
-		// Wrap data into raw record.
-		r, err := record.NewWrapper(storageKey, nil, dsd.RAW, data)
+func storeZoneTokens(store TokenStore, zone string, handler token.Handler) {
+	// Check if there is data to save.
+	amount := handler.Amount()
+	if amount == 0 {
+		// Remove possible old entry from store.
+		err := store.Delete(zone)
 		if err != nil {
-			log.Warningf("access: failed to prepare %s token export for storing: %s", zone, err)
-			continue
+			log.Warningf("access: failed to delete possible old %s tokens from storage: %s", zone, err)
 		}
+		log.Debugf("access: no %s tokens to store", zone)
+		return
+	}
 
-		// Let tokens expire after one month.
-		// This will regularly happen when we switch zones.
-		r.UpdateMeta()
-		r.Meta().MakeSecret()
-		r.Meta().MakeCrownJewel()
-		r.Meta().SetRelativateExpiry(30 * 86400)
-
-		// Save to database.
-		err = db.Put(r)
-		if err != nil {
-			log.Warningf("access: failed to store %s tokens: %s", zone, err)
-			continue
-		}
+	// Export data.
+	data, err := handler.Save()
+	if err != nil {
+		log.Warningf("access: failed to export %s tokens for storing: %s", zone, err)
+		return
+	}
 
-		log.Infof("access: stored %d %s tokens", amount, zone)
+	// Save to store.
+	err = store.Save(zone, data)
+	if err != nil {
+		log.Warningf("access: failed to store %s tokens: %s", zone, err)
+		return
 	}
+
+	log.Infof("access: stored %d %s tokens", amount, zone)
 }
 
 func clearTokens() {
-	for _, zone := range persistentZones {
+	for _, zone := range getPersistentZones() {
 		// Get handler of zone.
 		handler, ok := token.GetHandler(zone)
 		if !ok {
@@ -119,11 +280,10 @@ func clearTokens() {
 		handler.Clear()
 	}
 
-	// Purge database storage prefix.
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	n, err := db.Purge(ctx, query.New(fmt.Sprintf(tokenStorageKeyTemplate, "")))
+	// Purge token storage.
+	n, err := getTokenStore().Clear()
 	if err != nil {
-		log.Warningf("access: failed to clear token storages: %s")
+		log.Warningf("access: failed to clear token storages: %s", err)
 		return
 	}
 	log.Infof("access: cleared %d token storages", n)
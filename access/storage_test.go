@@ -0,0 +1,48 @@
+package access
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMemoryTokenStore(t *testing.T) {
+	store := NewMemoryTokenStore()
+
+	// Nothing stored yet.
+	if _, err := store.Load("test"); !errors.Is(err, ErrNoStoredTokens) {
+		t.Fatalf("expected ErrNoStoredTokens, got %v", err)
+	}
+
+	// Save and load.
+	data := []byte("test tokens")
+	if err := store.Save("test", data); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.Load("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, loaded) {
+		t.Fatalf("loaded data does not match: %q", loaded)
+	}
+
+	// Delete.
+	if err := store.Delete("test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load("test"); !errors.Is(err, ErrNoStoredTokens) {
+		t.Fatalf("expected ErrNoStoredTokens after delete, got %v", err)
+	}
+
+	// Clear.
+	_ = store.Save("a", data)
+	_ = store.Save("b", data)
+	n, err := store.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected to clear 2 zones, cleared %d", n)
+	}
+}
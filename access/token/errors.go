@@ -4,9 +4,11 @@ import "errors"
 
 var (
 	ErrEmpty          = errors.New("token storage is empty")
+	ErrHandlerClosed  = errors.New("token handler is closed")
 	ErrNoZone         = errors.New("no zone specified")
 	ErrTokenInvalid   = errors.New("token is invalid")
 	ErrTokenMalformed = errors.New("token malformed")
+	ErrTokenRevoked   = errors.New("token revoked")
 	ErrTokenUsed      = errors.New("token already used")
 	ErrZoneMismatch   = errors.New("zone mismatch")
 	ErrZoneTaken      = errors.New("zone taken")
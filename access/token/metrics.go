@@ -0,0 +1,262 @@
+package token
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/metrics"
+	"github.com/tevino/abool"
+)
+
+// Issuance operations for metrics.
+const (
+	issuanceOpSetup   = "setup"
+	issuanceOpIssue   = "issue"
+	issuanceOpProcess = "process"
+)
+
+var (
+	metricsEnabled = abool.New()
+
+	issuanceMetrics     = make(map[string]*issuanceOpMetrics) // Key is zone and operation.
+	issuanceMetricsLock sync.Mutex
+
+	verificationFailureMetrics     = make(map[string]*metrics.Counter) // Key is zone and cause.
+	verificationFailureMetricsLock sync.Mutex
+
+	quotaRejectionMetrics     = make(map[string]*metrics.Counter) // Key is zone.
+	quotaRejectionMetricsLock sync.Mutex
+)
+
+type issuanceOpMetrics struct {
+	succeeded *metrics.Counter
+	failed    *metrics.Counter
+	latency   *metrics.Histogram
+}
+
+// EnableMetrics enables recording of token issuance and verification metrics.
+// Metrics are created per zone when first needed.
+func EnableMetrics() {
+	metricsEnabled.Set()
+}
+
+// getIssuanceMetrics returns the metrics for the given zone and operation and
+// creates them if they don't exist yet.
+func getIssuanceMetrics(zone, op string) *issuanceOpMetrics {
+	issuanceMetricsLock.Lock()
+	defer issuanceMetricsLock.Unlock()
+
+	// Return existing metrics.
+	key := zone + "/" + op
+	m, ok := issuanceMetrics[key]
+	if ok {
+		return m
+	}
+
+	// Create new metrics.
+	m = &issuanceOpMetrics{}
+	var err error
+	m.succeeded, err = metrics.NewCounter(
+		"spn/tokens/issuance/total",
+		map[string]string{
+			"zone":   zone,
+			"op":     op,
+			"result": "success",
+		},
+		&metrics.Options{
+			Name:       "SPN Token Issuance Successes",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		log.Warningf("spn/token: failed to register success metric for %s: %s", key, err)
+	}
+	m.failed, err = metrics.NewCounter(
+		"spn/tokens/issuance/total",
+		map[string]string{
+			"zone":   zone,
+			"op":     op,
+			"result": "failure",
+		},
+		&metrics.Options{
+			Name:       "SPN Token Issuance Failures",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		log.Warningf("spn/token: failed to register failure metric for %s: %s", key, err)
+	}
+	m.latency, err = metrics.NewHistogram(
+		"spn/tokens/issuance/histogram/duration/seconds",
+		map[string]string{
+			"zone": zone,
+			"op":   op,
+		},
+		&metrics.Options{
+			Name:       "SPN Token Issuance Batch Duration Histogram",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		log.Warningf("spn/token: failed to register latency metric for %s: %s", key, err)
+	}
+
+	issuanceMetrics[key] = m
+	return m
+}
+
+// reportIssuance records the result and duration of a token issuance
+// operation of a batch, if metrics are enabled.
+func reportIssuance(zone, op string, started time.Time, err error) {
+	if !metricsEnabled.IsSet() {
+		return
+	}
+
+	m := getIssuanceMetrics(zone, op)
+	if err != nil {
+		if m.failed != nil {
+			m.failed.Inc()
+		}
+		return
+	}
+
+	if m.succeeded != nil {
+		m.succeeded.Inc()
+	}
+	if m.latency != nil {
+		m.latency.UpdateDuration(started)
+	}
+}
+
+// Causes of verification failures for metrics.
+const (
+	verificationCauseZoneMismatch = "zone_mismatch"
+	verificationCauseMalformed    = "malformed"
+	verificationCauseInvalid      = "invalid"
+	verificationCauseUsed         = "used"
+	verificationCauseRevoked      = "revoked"
+	verificationCauseOther        = "other"
+)
+
+// getVerificationFailureCause returns the cause of the given verification
+// error for metrics.
+func getVerificationFailureCause(err error) string {
+	switch {
+	case errors.Is(err, ErrZoneMismatch):
+		return verificationCauseZoneMismatch
+	case errors.Is(err, ErrTokenMalformed):
+		return verificationCauseMalformed
+	case errors.Is(err, ErrTokenInvalid):
+		return verificationCauseInvalid
+	case errors.Is(err, ErrTokenUsed):
+		return verificationCauseUsed
+	case errors.Is(err, ErrTokenRevoked):
+		return verificationCauseRevoked
+	default:
+		return verificationCauseOther
+	}
+}
+
+// getVerificationFailureMetric returns the failure counter for the given zone
+// and cause and creates it if it does not exist yet.
+func getVerificationFailureMetric(zone, cause string) *metrics.Counter {
+	verificationFailureMetricsLock.Lock()
+	defer verificationFailureMetricsLock.Unlock()
+
+	// Return existing metric.
+	key := zone + "/" + cause
+	m, ok := verificationFailureMetrics[key]
+	if ok {
+		return m
+	}
+
+	// Create new metric.
+	m, err := metrics.NewCounter(
+		"spn/tokens/verification/failures/total",
+		map[string]string{
+			"zone":  zone,
+			"cause": cause,
+		},
+		&metrics.Options{
+			Name:       "SPN Token Verification Failures",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		log.Warningf("spn/token: failed to register verification failure metric for %s: %s", key, err)
+	}
+
+	verificationFailureMetrics[key] = m
+	return m
+}
+
+// reportVerificationFailure logs the failed verification of a token and
+// records it by cause, if metrics are enabled.
+func reportVerificationFailure(zone string, err error) {
+	// Closed handlers do not say anything about the token.
+	if errors.Is(err, ErrHandlerClosed) {
+		return
+	}
+
+	// Log invalid signatures more prominently, as they indicate forgery attempts.
+	cause := getVerificationFailureCause(err)
+	if cause == verificationCauseInvalid {
+		log.Warningf("spn/token: failed to verify %s token: %s", zone, err)
+	} else {
+		log.Debugf("spn/token: failed to verify %s token: %s", zone, err)
+	}
+
+	if !metricsEnabled.IsSet() {
+		return
+	}
+	if m := getVerificationFailureMetric(zone, cause); m != nil {
+		m.Inc()
+	}
+}
+
+// getQuotaRejectionMetric returns the quota rejection counter for the given
+// zone and creates it if it does not exist yet.
+func getQuotaRejectionMetric(zone string) *metrics.Counter {
+	quotaRejectionMetricsLock.Lock()
+	defer quotaRejectionMetricsLock.Unlock()
+
+	// Return existing metric.
+	m, ok := quotaRejectionMetrics[zone]
+	if ok {
+		return m
+	}
+
+	// Create new metric.
+	m, err := metrics.NewCounter(
+		"spn/tokens/issuance/quota/rejections/total",
+		map[string]string{
+			"zone": zone,
+		},
+		&metrics.Options{
+			Name:       "SPN Token Issuance Quota Rejections",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		log.Warningf("spn/token: failed to register quota rejection metric for %s: %s", zone, err)
+	}
+
+	quotaRejectionMetrics[zone] = m
+	return m
+}
+
+// reportQuotaRejection logs the rejection of a token request because of an
+// exceeded quota and records it, if metrics are enabled.
+func reportQuotaRejection(zone string) {
+	log.Debugf("spn/token: rejected %s token request because of exceeded quota", zone)
+
+	if !metricsEnabled.IsSet() {
+		return
+	}
+	if m := getQuotaRejectionMetric(zone); m != nil {
+		m.Inc()
+	}
+}
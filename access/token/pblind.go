@@ -5,21 +5,28 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
-	"math"
-	"math/big"
-	mrand "math/rand"
+	"io/ioutil"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/mr-tron/base58"
 	"github.com/rot256/pblind"
 	"github.com/safing/portbase/container"
 	"github.com/safing/portbase/formats/dsd"
+	"github.com/tevino/abool"
 )
 
 const (
 	pblindSecretSize = 32
 )
 
+// MaxPBlindBatchSize defines the maximum batch size of a PBlindHandler.
+// Every token of a batch requires a signer state on the issuer, so this
+// limits the memory a single request may use.
+var MaxPBlindBatchSize = 10000
+
 type PBlindToken struct {
 	Serial    int               `json:"N,omitempty"`
 	Token     []byte            `json:"T,omitempty"`
@@ -48,26 +55,69 @@ type PBlindHandler struct {
 	publicKey  *pblind.PublicKey
 	privateKey *pblind.SecretKey
 
+	// sharedInfo holds the info that is shared by all tokens, if serials are
+	// not used. It is computed once, as compressing the info is expensive.
+	sharedInfo *pblind.Info
+
 	storageLock sync.Mutex
 	Storage     []*PBlindToken
 
 	// Client request state.
 	requestStateLock sync.Mutex
 	requestState     []RequestState
+
+	// preferredBatchSize is the batch size the client requests.
+	preferredBatchSize int
+
+	// setupPool holds pre-generated signer setups, if enabled.
+	setupPool *pblindSetupPool
+
+	// closed signifies that the handler was closed and must not be used anymore.
+	closed abool.AtomicBool
 }
 
 type PBlindOptions struct {
-	Zone                  string
-	CurveName             string
-	Curve                 elliptic.Curve
-	PublicKey             string
-	PrivateKey            string
+	Zone      string
+	CurveName string
+	Curve     elliptic.Curve
+	PublicKey string
+	// PrivateKey holds the base58 encoded private key. For production
+	// issuers, prefer PrivateKeyFile or PrivateKeyEnv, so that the key does
+	// not need to be part of the configuration.
+	// Only one of PrivateKey, PrivateKeyFile and PrivateKeyEnv may be set.
+	PrivateKey string
+	// PrivateKeyFile is the path of a file holding the base58 encoded private
+	// key. It is read when the handler is created.
+	PrivateKeyFile string
+	// PrivateKeyEnv is the name of an environment variable holding the base58
+	// encoded private key. It is read when the handler is created.
+	PrivateKeyEnv         string
 	UseSerials            bool
 	BatchSize             int
+	MinBatchSize          int
+	MaxBatchSize          int
 	RandomizeOrder        bool
 	SignalShouldRequest   func(Handler)
 	DoubleSpendProtection func([]byte) error
-	Fallback              bool
+	// OnTokenAccepted is called with the zone and serial of every token that
+	// was accepted by Verify. It is called synchronously, so it should return
+	// quickly.
+	OnTokenAccepted func(zone string, serial int)
+	Revocations     *RevocationList
+	Fallback        bool
+	// SetupPoolSize defines how many signer setups of the default batch size
+	// are pre-generated in the background by issuers. Zero disables the pool.
+	SetupPoolSize int
+	// SetupPoolRefillInterval defines the interval in which one signer setup
+	// is added to the pool. Defaults to DefaultPBlindSetupPoolRefillInterval.
+	SetupPoolRefillInterval time.Duration
+}
+
+// PBlindSetupRequest holds the parameters of a setup request.
+type PBlindSetupRequest struct {
+	// BatchSize is the requested batch size. If zero, the default batch size
+	// of the issuer is used.
+	BatchSize int `json:"N,omitempty"`
 }
 
 type PBlindSignerState struct {
@@ -87,8 +137,9 @@ type IssuedPBlindTokens struct {
 }
 
 type RequestState struct {
-	Token []byte
-	State *pblind.StateRequester
+	Token  []byte
+	Serial int
+	State  *pblind.StateRequester
 }
 
 func NewPBlindHandler(opts PBlindOptions) (*PBlindHandler, error) {
@@ -98,20 +149,42 @@ func NewPBlindHandler(opts PBlindOptions) (*PBlindHandler, error) {
 
 	// Check curve, get from name.
 	if opts.Curve == nil {
-		switch opts.CurveName {
-		case "P-256":
-			opts.Curve = elliptic.P256()
-		case "P-384":
-			opts.Curve = elliptic.P384()
-		case "P-521":
-			opts.Curve = elliptic.P521()
-		default:
+		opts.Curve = pblindCurve(opts.CurveName)
+		if opts.Curve == nil {
 			return nil, errors.New("no curve supplied")
 		}
 	} else if opts.CurveName != "" {
 		return nil, errors.New("both curve and curve name supplied")
 	}
 
+	// Check batch size.
+	switch {
+	case opts.BatchSize < 1:
+		return nil, fmt.Errorf("batch size must be at least 1, got %d", opts.BatchSize)
+	case opts.BatchSize > MaxPBlindBatchSize:
+		return nil, fmt.Errorf("batch size must not exceed %d, got %d", MaxPBlindBatchSize, opts.BatchSize)
+	}
+
+	// Check batch size bounds, default to the batch size.
+	if opts.MinBatchSize == 0 {
+		opts.MinBatchSize = opts.BatchSize
+	}
+	if opts.MaxBatchSize == 0 {
+		opts.MaxBatchSize = opts.BatchSize
+	}
+	switch {
+	case opts.MinBatchSize < 1 || opts.MinBatchSize > opts.BatchSize:
+		return nil, fmt.Errorf("min batch size must be between 1 and the batch size %d, got %d", opts.BatchSize, opts.MinBatchSize)
+	case opts.MaxBatchSize < opts.BatchSize || opts.MaxBatchSize > MaxPBlindBatchSize:
+		return nil, fmt.Errorf("max batch size must be between the batch size %d and %d, got %d", opts.BatchSize, MaxPBlindBatchSize, opts.MaxBatchSize)
+	}
+	pbh.preferredBatchSize = opts.BatchSize
+
+	// Load private key from the configured source.
+	if err := opts.loadPrivateKey(); err != nil {
+		return nil, err
+	}
+
 	// Load keys.
 	switch {
 	case pbh.opts.PrivateKey != "":
@@ -132,24 +205,85 @@ func NewPBlindHandler(opts PBlindOptions) (*PBlindHandler, error) {
 		}
 
 	case pbh.opts.PublicKey != "":
-		keyData, err := base58.Decode(pbh.opts.PublicKey)
+		publicKey, err := decodePBlindPublicKey(pbh.opts.Curve, pbh.opts.PublicKey)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode public key: %w", err)
+			return nil, err
 		}
-		publicKey, err := pblind.PublicKeyFromBytes(pbh.opts.Curve, keyData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode public key: %w", err)
-		}
-		pbh.publicKey = &publicKey
+		pbh.publicKey = publicKey
 
 	default:
 		return nil, errors.New("no key supplied")
 	}
 
+	// Compute the shared info once, if serials are not used.
+	if !pbh.opts.UseSerials {
+		info, err := pbh.makeInfo(0)
+		if err != nil {
+			return nil, err
+		}
+		pbh.sharedInfo = info
+	}
+
+	// Start pre-generating signer setups, if enabled.
+	switch {
+	case opts.SetupPoolSize < 0:
+		return nil, fmt.Errorf("setup pool size must not be negative, got %d", opts.SetupPoolSize)
+	case opts.SetupPoolSize > 0:
+		if pbh.privateKey == nil {
+			return nil, errors.New("setup pool requires a private key")
+		}
+		pbh.setupPool = newPBlindSetupPool(pbh, opts.SetupPoolSize, opts.SetupPoolRefillInterval)
+	}
+
 	return pbh, nil
 }
 
+// pblindCurve returns the curve with the given name, or nil if unsupported.
+func pblindCurve(name string) elliptic.Curve {
+	switch name {
+	case "P-256":
+		return elliptic.P256()
+	case "P-384":
+		return elliptic.P384()
+	case "P-521":
+		return elliptic.P521()
+	default:
+		return nil
+	}
+}
+
+// decodePBlindPublicKey decodes the given base58 encoded public key.
+func decodePBlindPublicKey(curve elliptic.Curve, key string) (*pblind.PublicKey, error) {
+	keyData, err := base58.Decode(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+	publicKey, err := pblind.PublicKeyFromBytes(curve, keyData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+	return &publicKey, nil
+}
+
+// CheckPBlindPublicKey checks if the given base58 encoded public key is a
+// valid key on the curve with the given name.
+func CheckPBlindPublicKey(curveName, key string) error {
+	curve := pblindCurve(curveName)
+	if curve == nil {
+		return fmt.Errorf("unsupported curve %q", curveName)
+	}
+	_, err := decodePBlindPublicKey(curve, key)
+	return err
+}
+
+// makeInfo returns the info for the given serial. If serials are not used,
+// the info is the same for all tokens and the shared info is returned.
+// The returned info must not be modified.
 func (pbh *PBlindHandler) makeInfo(serial int) (*pblind.Info, error) {
+	if pbh.sharedInfo != nil {
+		return pbh.sharedInfo, nil
+	}
+
 	// Gather data for info.
 	infoData := container.New()
 	infoData.AppendAsBlock([]byte(pbh.opts.Zone))
@@ -181,7 +315,54 @@ func (pbh *PBlindHandler) ShouldRequest() bool {
 
 func (pbh *PBlindHandler) shouldRequest() bool {
 	// Return true if storage is at or below 10%.
-	return len(pbh.Storage) == 0 || pbh.opts.BatchSize/len(pbh.Storage) > 10
+	// Multiply instead of dividing in order to avoid integer division rounding
+	// and division by zero.
+	return len(pbh.Storage)*10 <= pbh.preferredBatchSize
+}
+
+// SetPreferredBatchSize sets the batch size the client requests. The batch size
+// is limited to the configured min and max batch size. Setting it to zero
+// resets it to the default batch size.
+func (pbh *PBlindHandler) SetPreferredBatchSize(batchSize int) {
+	pbh.storageLock.Lock()
+	defer pbh.storageLock.Unlock()
+
+	pbh.preferredBatchSize = pbh.limitBatchSize(batchSize)
+}
+
+// PreferredBatchSize returns the batch size the client requests.
+func (pbh *PBlindHandler) PreferredBatchSize() int {
+	pbh.storageLock.Lock()
+	defer pbh.storageLock.Unlock()
+
+	return pbh.preferredBatchSize
+}
+
+// limitBatchSize returns the given batch size limited to the configured min
+// and max batch size. Zero is replaced by the default batch size.
+func (pbh *PBlindHandler) limitBatchSize(batchSize int) int {
+	switch {
+	case batchSize == 0:
+		return pbh.opts.BatchSize
+	case batchSize < pbh.opts.MinBatchSize:
+		return pbh.opts.MinBatchSize
+	case batchSize > pbh.opts.MaxBatchSize:
+		return pbh.opts.MaxBatchSize
+	default:
+		return batchSize
+	}
+}
+
+// checkBatchSize checks if the given batch size is within the configured min
+// and max batch size.
+func (pbh *PBlindHandler) checkBatchSize(batchSize int) error {
+	if batchSize < pbh.opts.MinBatchSize || batchSize > pbh.opts.MaxBatchSize {
+		return fmt.Errorf(
+			"batch size of %d is not between %d and %d",
+			batchSize, pbh.opts.MinBatchSize, pbh.opts.MaxBatchSize,
+		)
+	}
+	return nil
 }
 
 // Amount returns the current amount of tokens in this handler.
@@ -197,17 +378,62 @@ func (pbh *PBlindHandler) IsFallback() bool {
 	return pbh.opts.Fallback
 }
 
-// CreateSetup sets up signers for a request.
+// validSerial returns whether the given serial may be used by a token. Serials
+// are the position of the token within its batch, starting at 1.
+//
+// Serials are part of the public info that is signed with the token and
+// revealed when the token is used. They are therefore deliberately tied to
+// the batch position: every batch uses the same serials, so a serial only
+// tells which position a token had in some batch. Serials chosen by the
+// issuer from a larger space would not strengthen unlinkability, but break
+// it, as a unique serial seen at issuance and again at use links the used
+// token to the request it was issued in. Clients break the link between
+// issuance order and use order by using their tokens in random order, see
+// RandomizeOrder.
+func (pbh *PBlindHandler) validSerial(serial int) bool {
+	if !pbh.opts.UseSerials {
+		return serial == 0
+	}
+	return serial > 0 && serial <= pbh.opts.MaxBatchSize
+}
+
+// CreateSetup sets up signers for a request with the default batch size.
 func (pbh *PBlindHandler) CreateSetup() (state *PBlindSignerState, setupResponse *PBlindSetupResponse, err error) {
+	return pbh.CreateSetupWithBatchSize(0)
+}
+
+// CreateSetupWithBatchSize sets up signers for a request with the requested
+// batch size. The batch size is limited to the configured min and max batch
+// size and zero selects the default batch size. The agreed batch size is
+// communicated to the client via the amount of setup messages.
+func (pbh *PBlindHandler) CreateSetupWithBatchSize(requestedBatchSize int) (state *PBlindSignerState, setupResponse *PBlindSetupResponse, err error) {
+	if pbh.closed.IsSet() {
+		return nil, nil, ErrHandlerClosed
+	}
+
+	// Use a pre-generated setup, if available.
+	batchSize := pbh.limitBatchSize(requestedBatchSize)
+	if batchSize == pbh.opts.BatchSize && pbh.setupPool != nil {
+		if setup := pbh.setupPool.take(); setup != nil {
+			return setup.state, setup.response, nil
+		}
+	}
+
+	return pbh.createSetup(batchSize)
+}
+
+// createSetup creates signers and the setup response for a batch of the given
+// size.
+func (pbh *PBlindHandler) createSetup(batchSize int) (state *PBlindSignerState, setupResponse *PBlindSetupResponse, err error) {
 	state = &PBlindSignerState{
-		signers: make([]*pblind.StateSigner, pbh.opts.BatchSize),
+		signers: make([]*pblind.StateSigner, batchSize),
 	}
 	setupResponse = &PBlindSetupResponse{
-		Msgs: make([]*pblind.Message1, pbh.opts.BatchSize),
+		Msgs: make([]*pblind.Message1, batchSize),
 	}
 
 	// Go through the batch.
-	for i := 0; i < pbh.opts.BatchSize; i++ {
+	for i := 0; i < batchSize; i++ {
 		info, err := pbh.makeInfo(i + 1)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to create info #%d: %w", i, err)
@@ -233,21 +459,27 @@ func (pbh *PBlindHandler) CreateSetup() (state *PBlindSignerState, setupResponse
 
 // CreateTokenRequest creates a token request to be sent to the token server.
 func (pbh *PBlindHandler) CreateTokenRequest(requestSetup *PBlindSetupResponse) (request *PBlindTokenRequest, err error) {
+	if pbh.closed.IsSet() {
+		return nil, ErrHandlerClosed
+	}
+
 	// Check request setup data.
-	if len(requestSetup.Msgs) != pbh.opts.BatchSize {
-		return nil, fmt.Errorf("invalid request setup msg count of %d", len(requestSetup.Msgs))
+	// The batch size is defined by the issuer within the agreed bounds.
+	batchSize := len(requestSetup.Msgs)
+	if err := pbh.checkBatchSize(batchSize); err != nil {
+		return nil, fmt.Errorf("invalid request setup msg count: %w", err)
 	}
 
 	// Lock and reset the request state.
 	pbh.requestStateLock.Lock()
 	defer pbh.requestStateLock.Unlock()
-	pbh.requestState = make([]RequestState, pbh.opts.BatchSize)
+	pbh.requestState = make([]RequestState, batchSize)
 	request = &PBlindTokenRequest{
-		Msgs: make([]*pblind.Message2, pbh.opts.BatchSize),
+		Msgs: make([]*pblind.Message2, batchSize),
 	}
 
 	// Go through the batch.
-	for i := 0; i < pbh.opts.BatchSize; i++ {
+	for i := 0; i < batchSize; i++ {
 		// Check if we have setup data.
 		if requestSetup.Msgs[i] == nil {
 			return nil, fmt.Errorf("missing setup data #%d", i)
@@ -263,6 +495,7 @@ func (pbh *PBlindHandler) CreateTokenRequest(requestSetup *PBlindSetupResponse)
 			return nil, fmt.Errorf("failed to get full random token #%d: only got %d bytes", i, n)
 		}
 		pbh.requestState[i].Token = token
+		pbh.requestState[i].Serial = i + 1
 
 		// Create public metadata.
 		info, err := pbh.makeInfo(i + 1)
@@ -295,122 +528,48 @@ func (pbh *PBlindHandler) CreateTokenRequest(requestSetup *PBlindSetupResponse)
 
 // IssueTokens sign the requested tokens.
 func (pbh *PBlindHandler) IssueTokens(state *PBlindSignerState, request *PBlindTokenRequest) (response *IssuedPBlindTokens, err error) {
-	// Check request data.
-	if len(request.Msgs) != pbh.opts.BatchSize {
-		return nil, fmt.Errorf("invalid request msg count of %d", len(request.Msgs))
-	}
-	if len(state.signers) != pbh.opts.BatchSize {
-		return nil, fmt.Errorf("invalid request state count of %d", len(request.Msgs))
-	}
-
-	// Create response.
 	response = &IssuedPBlindTokens{
-		Msgs: make([]*pblind.Message3, pbh.opts.BatchSize),
+		Msgs: make([]*pblind.Message3, len(request.Msgs)),
 	}
-
-	// Go through the batch.
-	for i := 0; i < pbh.opts.BatchSize; i++ {
-		// Check if we have request data.
-		if request.Msgs[i] == nil {
-			return nil, fmt.Errorf("missing request data #%d", i)
-		}
-
-		// Process request msg.
-		err = state.signers[i].ProcessMessage2(*request.Msgs[i])
-		if err != nil {
-			return nil, fmt.Errorf("failed to process request msg #%d: %w", i, err)
-		}
-
-		// Issue token.
-		responseMsg, err := state.signers[i].CreateMessage3()
-		if err != nil {
-			return nil, fmt.Errorf("failed to issue token #%d: %w", i, err)
-		}
-		response.Msgs[i] = &responseMsg
+	err = pbh.IssueTokensStream(state, request, func(i int, msg *pblind.Message3) error {
+		response.Msgs[i] = msg
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return
+	return response, nil
 }
 
 // ProcessIssuedTokens processes the issued token from the server.
 func (pbh *PBlindHandler) ProcessIssuedTokens(issuedTokens *IssuedPBlindTokens) error {
+	stream, err := pbh.StreamIssuedTokens()
+	if err != nil {
+		return err
+	}
+
 	// Check data.
-	if len(issuedTokens.Msgs) != pbh.opts.BatchSize {
+	if len(issuedTokens.Msgs) != stream.BatchSize() {
 		return fmt.Errorf("invalid issued token count of %d", len(issuedTokens.Msgs))
 	}
 
-	// Step 1: Process issued tokens.
-
-	// Lock and reset the request state.
-	pbh.requestStateLock.Lock()
-	defer pbh.requestStateLock.Unlock()
-	defer func() {
-		pbh.requestState = make([]RequestState, pbh.opts.BatchSize)
-	}()
-	finalizedTokens := make([]*PBlindToken, pbh.opts.BatchSize)
-
 	// Go through the batch.
-	for i := 0; i < pbh.opts.BatchSize; i++ {
-		// Finalize token.
-		err := pbh.requestState[i].State.ProcessMessage3(*issuedTokens.Msgs[i])
-		if err != nil {
-			return fmt.Errorf("failed to create final signature #%d: %w", i, err)
-		}
-
-		// Get and check final signature.
-		signature, err := pbh.requestState[i].State.Signature()
-		if err != nil {
-			return fmt.Errorf("failed to create final signature #%d: %w", i, err)
-		}
-		info, err := pbh.makeInfo(i + 1)
-		if err != nil {
-			return fmt.Errorf("failed to make token info #%d: %w", i, err)
-		}
-		if !pbh.publicKey.Check(signature, *info, pbh.requestState[i].Token) {
-			return fmt.Errorf("invalid signature on #%d", i)
-		}
-
-		// Save to temporary slice.
-		newToken := &PBlindToken{
-			Token:     pbh.requestState[i].Token,
-			Signature: &signature,
-		}
-		if pbh.opts.UseSerials {
-			newToken.Serial = i + 1
-		}
-		finalizedTokens[i] = newToken
-	}
-
-	// Step 2: Randomize received tokens
-
-	if pbh.opts.RandomizeOrder {
-		rInt, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
-		if err != nil {
-			return fmt.Errorf("failed to get seed for shuffle: %w", err)
+	for i, msg := range issuedTokens.Msgs {
+		if err := stream.Process(i, msg); err != nil {
+			return err
 		}
-		mrand.Seed(rInt.Int64())
-		mrand.Shuffle(len(finalizedTokens), func(i, j int) {
-			finalizedTokens[i], finalizedTokens[j] = finalizedTokens[j], finalizedTokens[i]
-		})
 	}
 
-	// Step 3: Add tokens to storage.
-
-	// Wait for all processing to be complete, as using tokens from a faulty
-	// batch can be dangerous, as the server could be doing this purposely to
-	// create conditions that may benefit an attacker.
-
-	pbh.storageLock.Lock()
-	defer pbh.storageLock.Unlock()
-
-	// Add finalized tokens to storage.
-	pbh.Storage = append(pbh.Storage, finalizedTokens...)
-
-	return nil
+	return stream.Finish()
 }
 
 // GetToken returns a token.
 func (pbh *PBlindHandler) GetToken() (token *Token, err error) {
+	if pbh.closed.IsSet() {
+		return nil, ErrHandlerClosed
+	}
+
 	pbh.storageLock.Lock()
 	defer pbh.storageLock.Unlock()
 
@@ -439,48 +598,97 @@ func (pbh *PBlindHandler) GetToken() (token *Token, err error) {
 	}, nil
 }
 
-// Verify verifies the given token.
+// Verify verifies the given token and runs the double spend protection, which
+// may record the token as spent. Revoked tokens are rejected before the double
+// spend protection is run.
 func (pbh *PBlindHandler) Verify(token *Token) error {
+	err := pbh.verify(token)
+	if err != nil {
+		reportVerificationFailure(pbh.opts.Zone, err)
+	}
+	return err
+}
+
+// verify verifies the given token and runs the double spend protection.
+func (pbh *PBlindHandler) verify(token *Token) error {
+	t, err := pbh.verifySignature(token)
+	if err != nil {
+		return err
+	}
+
+	// Check if the token was revoked.
+	if pbh.opts.Revocations != nil && pbh.opts.Revocations.IsRevoked(t.Serial, t.Token) {
+		return ErrTokenRevoked
+	}
+
+	// Check for double spending.
+	if pbh.opts.DoubleSpendProtection != nil {
+		if err := pbh.opts.DoubleSpendProtection(t.Token); err != nil {
+			return fmt.Errorf("%w: %s", ErrTokenUsed, err)
+		}
+	}
+
+	// Report accepted token.
+	if pbh.opts.OnTokenAccepted != nil {
+		pbh.opts.OnTokenAccepted(pbh.opts.Zone, t.Serial)
+	}
+
+	return nil
+}
+
+// VerifySignatureOnly checks the zone, serial and signature of the given token,
+// but never runs the double spend protection. The token is not consumed.
+// It must only be used for diagnostics and display purposes, never for
+// granting access.
+func (pbh *PBlindHandler) VerifySignatureOnly(token *Token) error {
+	_, err := pbh.verifySignature(token)
+	return err
+}
+
+// verifySignature checks the zone, serial and signature of the given token and
+// returns the unpacked token.
+func (pbh *PBlindHandler) verifySignature(token *Token) (*PBlindToken, error) {
+	if pbh.closed.IsSet() {
+		return nil, ErrHandlerClosed
+	}
+
 	// Check if zone matches.
 	if token.Zone != pbh.opts.Zone {
-		return ErrZoneMismatch
+		return nil, ErrZoneMismatch
 	}
 
 	// Unpack token.
 	t, err := UnpackPBlindToken(token.Data)
 	if err != nil {
-		return fmt.Errorf("%w: %s", ErrTokenMalformed, err)
+		return nil, fmt.Errorf("%w: %s", ErrTokenMalformed, err)
 	}
 
-	// Check if serial is valid.
-	switch {
-	case pbh.opts.UseSerials && t.Serial > 0 && t.Serial <= pbh.opts.BatchSize:
-		// Using serials in accepted range.
-	case !pbh.opts.UseSerials && t.Serial == 0:
-		// Not using serials and serial is zero.
-	default:
-		return fmt.Errorf("%w: invalid serial", ErrTokenMalformed)
+	// Check if all parts are present.
+	if len(t.Token) == 0 || t.Signature == nil {
+		return nil, fmt.Errorf("%w: missing token or signature", ErrTokenMalformed)
+	}
+
+	// Check if serial is a valid batch position.
+	// Without serials, the serial must not be set.
+	if !pbh.validSerial(t.Serial) {
+		return nil, fmt.Errorf("%w: invalid serial", ErrTokenMalformed)
 	}
 
 	// Build info for checking signature.
+	// The info is derived solely from the zone and the claimed serial. As the
+	// signature binds the info, a token with a swapped serial fails the
+	// signature check.
 	info, err := pbh.makeInfo(t.Serial)
 	if err != nil {
-		return fmt.Errorf("%w: %s", ErrTokenMalformed, err)
+		return nil, fmt.Errorf("%w: %s", ErrTokenMalformed, err)
 	}
 
 	// Check signature.
 	if !pbh.publicKey.Check(*t.Signature, *info, t.Token) {
-		return ErrTokenInvalid
+		return nil, ErrTokenInvalid
 	}
 
-	// Check for double spending.
-	if pbh.opts.DoubleSpendProtection != nil {
-		if err := pbh.opts.DoubleSpendProtection(t.Token); err != nil {
-			return fmt.Errorf("%w: %s", ErrTokenUsed, err)
-		}
-	}
-
-	return nil
+	return t, nil
 }
 
 type PBlindStorage struct {
@@ -489,6 +697,10 @@ type PBlindStorage struct {
 
 // Save serializes and returns the current tokens.
 func (pbh *PBlindHandler) Save() ([]byte, error) {
+	if pbh.closed.IsSet() {
+		return nil, ErrHandlerClosed
+	}
+
 	pbh.storageLock.Lock()
 	defer pbh.storageLock.Unlock()
 
@@ -505,6 +717,10 @@ func (pbh *PBlindHandler) Save() ([]byte, error) {
 
 // Load loads the given tokens into the handler.
 func (pbh *PBlindHandler) Load(data []byte) error {
+	if pbh.closed.IsSet() {
+		return ErrHandlerClosed
+	}
+
 	pbh.storageLock.Lock()
 	defer pbh.storageLock.Unlock()
 
@@ -516,19 +732,64 @@ func (pbh *PBlindHandler) Load(data []byte) error {
 
 	// Check signatures on load.
 	for _, t := range s.Storage {
-		// Build info for checking signature.
-		info, err := pbh.makeInfo(t.Serial)
-		if err != nil {
+		if err := pbh.checkStoredToken(t); err != nil {
 			return err
 		}
+	}
 
-		// Check signature.
-		if !pbh.publicKey.Check(*t.Signature, *info, t.Token) {
-			return ErrTokenInvalid
+	pbh.Storage = s.Storage
+	return nil
+}
+
+// LoadLenient loads the given tokens into the handler, but skips invalid or
+// corrupted tokens instead of failing. It returns the amount of loaded and
+// dropped tokens. An error is only returned if the data cannot be parsed at
+// all.
+func (pbh *PBlindHandler) LoadLenient(data []byte) (loaded, dropped int, err error) {
+	if pbh.closed.IsSet() {
+		return 0, 0, ErrHandlerClosed
+	}
+
+	pbh.storageLock.Lock()
+	defer pbh.storageLock.Unlock()
+
+	s := &PBlindStorage{}
+	_, err = dsd.Load(data, s)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Check signatures on load and only keep valid tokens.
+	valid := make([]*PBlindToken, 0, len(s.Storage))
+	for _, t := range s.Storage {
+		if err := pbh.checkStoredToken(t); err != nil {
+			dropped++
+			continue
 		}
+		valid = append(valid, t)
+	}
+
+	pbh.Storage = valid
+	return len(valid), dropped, nil
+}
+
+// checkStoredToken checks the signature of a token loaded from storage.
+func (pbh *PBlindHandler) checkStoredToken(t *PBlindToken) error {
+	if t == nil || t.Signature == nil {
+		return ErrTokenMalformed
+	}
+
+	// Build info for checking signature.
+	info, err := pbh.makeInfo(t.Serial)
+	if err != nil {
+		return err
+	}
+
+	// Check signature.
+	if !pbh.publicKey.Check(*t.Signature, *info, t.Token) {
+		return ErrTokenInvalid
 	}
 
-	pbh.Storage = s.Storage
 	return nil
 }
 
@@ -539,3 +800,88 @@ func (pbh *PBlindHandler) Clear() {
 
 	pbh.Storage = nil
 }
+
+// Close clears all tokens, wipes secret material and marks the handler as
+// closed. All subsequent calls that use the handler return ErrHandlerClosed.
+// Wiping is best effort: The secret tokens of pending requests and stored
+// tokens are zeroed, but the private key is held by types of the pblind
+// library that do not expose their internals, so it can only be dereferenced
+// and is left to the garbage collector. The same applies to the private key
+// string in the options, as strings are immutable.
+func (pbh *PBlindHandler) Close() {
+	if !pbh.closed.SetToIf(false, true) {
+		return
+	}
+
+	// Stop and drain the setup pool before removing the private key.
+	if pbh.setupPool != nil {
+		pbh.setupPool.stop()
+	}
+
+	// Wipe stored tokens.
+	pbh.storageLock.Lock()
+	for _, t := range pbh.Storage {
+		wipeBytes(t.Token)
+	}
+	pbh.Storage = nil
+	pbh.storageLock.Unlock()
+
+	// Wipe secret tokens of pending requests.
+	pbh.requestStateLock.Lock()
+	for i := range pbh.requestState {
+		wipeBytes(pbh.requestState[i].Token)
+		pbh.requestState[i].State = nil
+	}
+	pbh.requestState = nil
+	pbh.requestStateLock.Unlock()
+
+	// Remove references to the private key.
+	pbh.Lock()
+	pbh.privateKey = nil
+	pbh.opts.PrivateKey = ""
+	pbh.Unlock()
+}
+
+// loadPrivateKey loads the private key from the configured file or
+// environment variable into PrivateKey. It fails if more than one source of
+// the private key is configured.
+func (opts *PBlindOptions) loadPrivateKey() error {
+	// Check that at most one source is configured.
+	var sources int
+	for _, source := range []string{opts.PrivateKey, opts.PrivateKeyFile, opts.PrivateKeyEnv} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("only one of private key, private key file and private key env may be supplied")
+	}
+
+	switch {
+	case opts.PrivateKeyFile != "":
+		keyData, err := ioutil.ReadFile(opts.PrivateKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read private key file: %w", err)
+		}
+		opts.PrivateKey = strings.TrimSpace(string(keyData))
+		wipeBytes(keyData)
+		if opts.PrivateKey == "" {
+			return fmt.Errorf("private key file %s is empty", opts.PrivateKeyFile)
+		}
+
+	case opts.PrivateKeyEnv != "":
+		opts.PrivateKey = strings.TrimSpace(os.Getenv(opts.PrivateKeyEnv))
+		if opts.PrivateKey == "" {
+			return fmt.Errorf("private key env %s is not set", opts.PrivateKeyEnv)
+		}
+	}
+
+	return nil
+}
+
+// wipeBytes overwrites the given slice with zeros.
+func wipeBytes(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
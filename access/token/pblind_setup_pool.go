@@ -0,0 +1,109 @@
+package token
+
+import (
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+// DefaultPBlindSetupPoolRefillInterval is the default interval in which a
+// signer setup is added to the setup pool.
+const DefaultPBlindSetupPoolRefillInterval = 100 * time.Millisecond
+
+// pblindSetupPool pre-generates signer setups in the background, so that
+// issuers can respond to setup requests without doing the expensive EC work
+// inline. Every setup is handed out at most once.
+type pblindSetupPool struct {
+	pbh            *PBlindHandler
+	setups         chan *pblindSetup
+	refillInterval time.Duration
+
+	stopping chan struct{}
+	stopped  sync.WaitGroup
+}
+
+type pblindSetup struct {
+	state    *PBlindSignerState
+	response *PBlindSetupResponse
+}
+
+func newPBlindSetupPool(pbh *PBlindHandler, size int, refillInterval time.Duration) *pblindSetupPool {
+	if refillInterval <= 0 {
+		refillInterval = DefaultPBlindSetupPoolRefillInterval
+	}
+
+	pool := &pblindSetupPool{
+		pbh:            pbh,
+		setups:         make(chan *pblindSetup, size),
+		refillInterval: refillInterval,
+		stopping:       make(chan struct{}),
+	}
+	pool.stopped.Add(1)
+	go pool.refiller()
+
+	return pool
+}
+
+// take returns a pre-generated setup, or nil if the pool is empty.
+func (pool *pblindSetupPool) take() *pblindSetup {
+	select {
+	case setup := <-pool.setups:
+		return setup
+	default:
+		return nil
+	}
+}
+
+// size returns the amount of setups currently in the pool.
+func (pool *pblindSetupPool) size() int {
+	return len(pool.setups)
+}
+
+// refiller adds a setup to the pool in every refill interval, until the pool
+// is full.
+func (pool *pblindSetupPool) refiller() {
+	defer pool.stopped.Done()
+
+	ticker := time.NewTicker(pool.refillInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-pool.stopping:
+			return
+		}
+
+		// Check if the pool is full.
+		// The refiller is the only writer, so the pool cannot fill up meanwhile.
+		if len(pool.setups) >= cap(pool.setups) {
+			continue
+		}
+
+		state, response, err := pool.pbh.createSetup(pool.pbh.opts.BatchSize)
+		if err != nil {
+			log.Warningf("spn/token: failed to pre-generate %s signer setup: %s", pool.pbh.opts.Zone, err)
+			continue
+		}
+		pool.setups <- &pblindSetup{
+			state:    state,
+			response: response,
+		}
+	}
+}
+
+// stop stops the refiller and discards all remaining setups.
+func (pool *pblindSetupPool) stop() {
+	close(pool.stopping)
+	pool.stopped.Wait()
+
+	for {
+		select {
+		case setup := <-pool.setups:
+			setup.state.signers = nil
+		default:
+			return
+		}
+	}
+}
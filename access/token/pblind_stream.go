@@ -0,0 +1,222 @@
+package token
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	mrand "math/rand"
+
+	"github.com/rot256/pblind"
+)
+
+// IssueTokensStream signs the requested tokens like IssueTokens, but hands
+// every issued token to emit as soon as it is signed, instead of collecting
+// the whole batch in memory. If emit returns an error, issuing is aborted and
+// the error is returned.
+func (pbh *PBlindHandler) IssueTokensStream(
+	state *PBlindSignerState,
+	request *PBlindTokenRequest,
+	emit func(i int, msg *pblind.Message3) error,
+) error {
+	if pbh.closed.IsSet() {
+		return ErrHandlerClosed
+	}
+
+	// Check request data.
+	batchSize := len(state.signers)
+	if err := pbh.checkBatchSize(batchSize); err != nil {
+		return fmt.Errorf("invalid request state count: %w", err)
+	}
+	if len(request.Msgs) != batchSize {
+		return fmt.Errorf("invalid request msg count of %d", len(request.Msgs))
+	}
+
+	// Go through the batch.
+	for i := 0; i < batchSize; i++ {
+		// Check if we have request data and the token was not issued yet.
+		switch {
+		case request.Msgs[i] == nil:
+			return fmt.Errorf("missing request data #%d", i)
+		case state.signers[i] == nil:
+			return fmt.Errorf("token #%d already issued", i)
+		}
+
+		// Process request msg.
+		err := state.signers[i].ProcessMessage2(*request.Msgs[i])
+		if err != nil {
+			return fmt.Errorf("failed to process request msg #%d: %w", i, err)
+		}
+
+		// Issue token.
+		responseMsg, err := state.signers[i].CreateMessage3()
+		if err != nil {
+			return fmt.Errorf("failed to issue token #%d: %w", i, err)
+		}
+		if err := emit(i, &responseMsg); err != nil {
+			return fmt.Errorf("failed to emit token #%d: %w", i, err)
+		}
+
+		// Release the signer state, as it is not needed anymore.
+		state.signers[i] = nil
+	}
+
+	return nil
+}
+
+// PBlindIssuedTokenStream processes issued tokens one at a time as they are
+// received from the server. The tokens are only added to the storage when
+// Finish is called and all tokens of the batch were processed successfully.
+type PBlindIssuedTokenStream struct {
+	pbh *PBlindHandler
+
+	requestState []RequestState
+	finalized    []*PBlindToken
+	processed    int
+
+	// err holds the first error that occurred while processing. Once set, the
+	// stream is failed and will not add any tokens to the storage.
+	err error
+}
+
+// StreamIssuedTokens starts processing issued tokens one at a time. The
+// pending token request is taken over by the stream, so it is discarded
+// even if the stream is never finished.
+func (pbh *PBlindHandler) StreamIssuedTokens() (*PBlindIssuedTokenStream, error) {
+	if pbh.closed.IsSet() {
+		return nil, ErrHandlerClosed
+	}
+
+	// Take over the request state.
+	pbh.requestStateLock.Lock()
+	defer pbh.requestStateLock.Unlock()
+
+	if len(pbh.requestState) == 0 {
+		return nil, errors.New("no pending token request")
+	}
+	stream := &PBlindIssuedTokenStream{
+		pbh:          pbh,
+		requestState: pbh.requestState,
+		finalized:    make([]*PBlindToken, len(pbh.requestState)),
+	}
+	pbh.requestState = nil
+
+	return stream, nil
+}
+
+// BatchSize returns the amount of tokens expected by the stream.
+func (s *PBlindIssuedTokenStream) BatchSize() int {
+	return len(s.requestState)
+}
+
+// Process finalizes the issued token with the given index of the batch.
+// Any error fails the whole stream.
+func (s *PBlindIssuedTokenStream) Process(i int, msg *pblind.Message3) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.err = s.process(i, msg)
+	return s.err
+}
+
+func (s *PBlindIssuedTokenStream) process(i int, msg *pblind.Message3) error {
+	// Check data.
+	switch {
+	case i < 0 || i >= len(s.requestState):
+		return fmt.Errorf("invalid issued token index #%d", i)
+	case s.finalized[i] != nil:
+		return fmt.Errorf("duplicate issued token #%d", i)
+	case msg == nil:
+		return fmt.Errorf("missing issued token #%d", i)
+	}
+
+	// Finalize token.
+	requestState := s.requestState[i]
+	err := requestState.State.ProcessMessage3(*msg)
+	if err != nil {
+		return fmt.Errorf("failed to create final signature #%d: %w", i, err)
+	}
+
+	// Get and check final signature.
+	signature, err := requestState.State.Signature()
+	if err != nil {
+		return fmt.Errorf("failed to create final signature #%d: %w", i, err)
+	}
+	info, err := s.pbh.makeInfo(requestState.Serial)
+	if err != nil {
+		return fmt.Errorf("failed to make token info #%d: %w", i, err)
+	}
+	if !s.pbh.publicKey.Check(signature, *info, requestState.Token) {
+		return fmt.Errorf("invalid signature on #%d", i)
+	}
+
+	// Save to temporary slice.
+	newToken := &PBlindToken{
+		Token:     requestState.Token,
+		Signature: &signature,
+	}
+	if s.pbh.opts.UseSerials {
+		newToken.Serial = requestState.Serial
+	}
+	s.finalized[i] = newToken
+	s.processed++
+
+	return nil
+}
+
+// Finish checks that all tokens of the batch were processed and adds them to
+// the storage.
+func (s *PBlindIssuedTokenStream) Finish() error {
+	if err := s.complete(); err != nil {
+		return err
+	}
+	return s.commit()
+}
+
+// complete checks if all tokens of the batch were processed successfully.
+func (s *PBlindIssuedTokenStream) complete() error {
+	switch {
+	case s.err != nil:
+		return s.err
+	case s.processed != len(s.requestState):
+		s.err = fmt.Errorf("incomplete issued tokens: got %d of %d", s.processed, len(s.requestState))
+		return s.err
+	}
+	return nil
+}
+
+// commit adds the finalized tokens to the storage. It must only be called
+// after complete succeeded.
+func (s *PBlindIssuedTokenStream) commit() error {
+	if s.pbh.closed.IsSet() {
+		return ErrHandlerClosed
+	}
+
+	// Randomize received tokens.
+	if s.pbh.opts.RandomizeOrder {
+		rInt, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+		if err != nil {
+			return fmt.Errorf("failed to get seed for shuffle: %w", err)
+		}
+		mrand.Seed(rInt.Int64())
+		mrand.Shuffle(len(s.finalized), func(i, j int) {
+			s.finalized[i], s.finalized[j] = s.finalized[j], s.finalized[i]
+		})
+	}
+
+	// Wait for all processing to be complete, as using tokens from a faulty
+	// batch can be dangerous, as the server could be doing this purposely to
+	// create conditions that may benefit an attacker.
+
+	s.pbh.storageLock.Lock()
+	defer s.pbh.storageLock.Unlock()
+
+	// Add finalized tokens to storage.
+	s.pbh.Storage = append(s.pbh.Storage, s.finalized...)
+	s.finalized = nil
+	s.err = errors.New("issued tokens were already added to storage")
+
+	return nil
+}
@@ -3,6 +3,11 @@ package token
 import (
 	"crypto/elliptic"
 	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -57,7 +62,13 @@ func TestPBlind(t *testing.T) {
 	}
 
 	// Verifier
-	verifier, err := NewPBlindHandler(*opts)
+	var doubleSpendChecks int
+	verifierOpts := *opts
+	verifierOpts.DoubleSpendProtection = func([]byte) error {
+		doubleSpendChecks++
+		return nil
+	}
+	verifier, err := NewPBlindHandler(verifierOpts)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -79,6 +90,12 @@ func TestPBlind(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Issuing again with the same signer state must fail.
+	_, err = issuer.IssueTokens(signerState, request)
+	if err == nil {
+		t.Fatal("issuing tokens twice with the same signer state should fail")
+	}
+
 	err = client.ProcessIssuedTokens(issuedTokens)
 	if err != nil {
 		t.Fatal(err)
@@ -89,10 +106,22 @@ func TestPBlind(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Signature only verification must not run the double spend protection.
+	err = verifier.VerifySignatureOnly(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doubleSpendChecks != 0 {
+		t.Fatalf("signature only verification ran double spend protection %d times", doubleSpendChecks)
+	}
+
 	err = verifier.Verify(token)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if doubleSpendChecks != 1 {
+		t.Fatalf("verification ran double spend protection %d times, expected once", doubleSpendChecks)
+	}
 }
 
 func TestPBlindLibrary(t *testing.T) {
@@ -254,3 +283,591 @@ func lenOfByteSlices(v [][]byte) (length int) {
 	}
 	return
 }
+
+func TestPBlindBatchSizeValidation(t *testing.T) {
+	opts := PBlindOptions{
+		Zone:       PBlindTestZone,
+		Curve:      elliptic.P256(),
+		PrivateKey: "HbwGtLsqek1Fdwuz1MhNQfiY7tj9EpWHeMWHPZ9c6KYY",
+	}
+
+	for _, batchSize := range []int{-1, 0, MaxPBlindBatchSize + 1} {
+		opts.BatchSize = batchSize
+		if _, err := NewPBlindHandler(opts); err == nil {
+			t.Errorf("batch size %d should be rejected", batchSize)
+		}
+	}
+
+	for _, batchSize := range []int{1, MaxPBlindBatchSize} {
+		opts.BatchSize = batchSize
+		if _, err := NewPBlindHandler(opts); err != nil {
+			t.Errorf("batch size %d should be accepted: %s", batchSize, err)
+		}
+	}
+}
+
+func TestPBlindClose(t *testing.T) {
+	issuer, err := NewPBlindHandler(PBlindOptions{
+		Zone:       PBlindTestZone,
+		Curve:      elliptic.P256(),
+		PrivateKey: "HbwGtLsqek1Fdwuz1MhNQfiY7tj9EpWHeMWHPZ9c6KYY",
+		BatchSize:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a token to check wiping.
+	secret := []byte{1, 2, 3}
+	issuer.Storage = append(issuer.Storage, &PBlindToken{Token: secret})
+
+	issuer.Close()
+	if issuer.Amount() != 0 {
+		t.Fatal("storage should be empty after closing")
+	}
+	for _, b := range secret {
+		if b != 0 {
+			t.Fatal("token secret should be wiped after closing")
+		}
+	}
+
+	// All usage must fail after closing.
+	if _, _, err := issuer.CreateSetup(); !errors.Is(err, ErrHandlerClosed) {
+		t.Fatalf("expected ErrHandlerClosed, got %v", err)
+	}
+	if _, err := issuer.GetToken(); !errors.Is(err, ErrHandlerClosed) {
+		t.Fatalf("expected ErrHandlerClosed, got %v", err)
+	}
+
+	// Closing again is fine.
+	issuer.Close()
+}
+
+func TestPBlindBatchSizeNegotiation(t *testing.T) {
+	opts := PBlindOptions{
+		Zone:         PBlindTestZone,
+		Curve:        elliptic.P256(),
+		UseSerials:   true,
+		BatchSize:    10,
+		MinBatchSize: 5,
+		MaxBatchSize: 20,
+	}
+
+	issuer, client, err := newPBlindTestHandlers(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The preferred batch size is limited to the bounds.
+	client.SetPreferredBatchSize(100)
+	if size := client.PreferredBatchSize(); size != 20 {
+		t.Fatalf("expected preferred batch size of 20, got %d", size)
+	}
+	client.SetPreferredBatchSize(15)
+
+	// The issuer limits the requested batch size and communicates it via the
+	// setup messages.
+	_, setupResponse, err := issuer.CreateSetupWithBatchSize(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(setupResponse.Msgs) != 5 {
+		t.Fatalf("expected limited batch size of 5, got %d", len(setupResponse.Msgs))
+	}
+
+	// Play through the whole use case with the preferred batch size.
+	signerState, setupResponse, err := issuer.CreateSetupWithBatchSize(client.PreferredBatchSize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := client.CreateTokenRequest(setupResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuedTokens, err := issuer.IssueTokens(signerState, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.ProcessIssuedTokens(issuedTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount := client.Amount(); amount != 15 {
+		t.Fatalf("expected 15 tokens, got %d", amount)
+	}
+
+	// Tokens with serials above the default batch size must verify.
+	for i := 0; i < 15; i++ {
+		token, err := client.GetToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := issuer.Verify(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Batch sizes outside of the bounds are rejected by the client.
+	_, setupResponse, err = issuer.CreateSetupWithBatchSize(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupResponse.Msgs = append(setupResponse.Msgs, setupResponse.Msgs[0])
+	if _, err := client.CreateTokenRequest(setupResponse); err == nil {
+		t.Fatal("batch size above the max batch size should be rejected")
+	}
+}
+
+func TestPBlindWithoutSerials(t *testing.T) {
+	opts := PBlindOptions{
+		Zone:      PBlindTestZone,
+		Curve:     elliptic.P256(),
+		BatchSize: 10,
+	}
+
+	issuer, client, err := newPBlindTestHandlers(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The info is computed once and shared by all tokens.
+	info1, err := client.makeInfo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info2, err := client.makeInfo(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info1 != info2 || info1 != client.sharedInfo {
+		t.Fatal("info should be shared when serials are not used")
+	}
+
+	// Play through the whole use case.
+	signerState, setupResponse, err := issuer.CreateSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := client.CreateTokenRequest(setupResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuedTokens, err := issuer.IssueTokens(signerState, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.ProcessIssuedTokens(issuedTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens must not carry anything that links them to their position in the
+	// batch, and must still verify.
+	for i := 0; i < 10; i++ {
+		token, err := client.GetToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pbt, err := UnpackPBlindToken(token.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pbt.Serial != 0 {
+			t.Fatalf("token #%d carries serial %d", i, pbt.Serial)
+		}
+		if err := issuer.Verify(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPBlindLoadLenient(t *testing.T) {
+	opts := PBlindOptions{
+		Zone:       PBlindTestZone,
+		Curve:      elliptic.P256(),
+		UseSerials: true,
+		BatchSize:  10,
+	}
+
+	issuer, client, err := newPBlindTestHandlers(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Get tokens.
+	signerState, setupResponse, err := issuer.CreateSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := client.CreateTokenRequest(setupResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuedTokens, err := issuer.IssueTokens(signerState, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.ProcessIssuedTokens(issuedTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt two of the stored tokens.
+	client.Storage[3].Token = []byte("corrupted")
+	client.Storage[7].Signature = nil
+	data, err := client.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Strict loading fails.
+	client.Clear()
+	if err := client.Load(data); err == nil {
+		t.Fatal("strict load should fail with corrupted tokens")
+	}
+	if client.Amount() != 0 {
+		t.Fatalf("strict load should not load any tokens, has %d", client.Amount())
+	}
+
+	// Lenient loading only drops the corrupted tokens.
+	loaded, dropped, err := client.LoadLenient(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 8 || dropped != 2 || client.Amount() != 8 {
+		t.Fatalf("unexpected result: loaded=%d dropped=%d amount=%d", loaded, dropped, client.Amount())
+	}
+
+	// Remaining tokens are still valid.
+	for i := 0; i < 8; i++ {
+		token, err := client.GetToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := issuer.Verify(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPBlindOnTokenAccepted(t *testing.T) {
+	opts := PBlindOptions{
+		Zone:       PBlindTestZone,
+		Curve:      elliptic.P256(),
+		UseSerials: true,
+		BatchSize:  10,
+	}
+
+	issuer, client, err := newPBlindTestHandlers(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verifier
+	accepted := make(map[int]int)
+	verifierOpts := *client.opts
+	verifierOpts.DoubleSpendProtection = func(token []byte) error {
+		if string(token) == "" {
+			return errors.New("empty token")
+		}
+		return nil
+	}
+	verifierOpts.OnTokenAccepted = func(zone string, serial int) {
+		if zone != PBlindTestZone {
+			t.Errorf("unexpected zone %s", zone)
+		}
+		accepted[serial]++
+	}
+	verifier, err := NewPBlindHandler(verifierOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Get tokens.
+	signerState, setupResponse, err := issuer.CreateSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := client.CreateTokenRequest(setupResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuedTokens, err := issuer.IssueTokens(signerState, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.ProcessIssuedTokens(issuedTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := client.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbt, err := UnpackPBlindToken(token.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signature only verification does not accept the token.
+	if err := verifier.VerifySignatureOnly(token); err != nil {
+		t.Fatal(err)
+	}
+	if len(accepted) != 0 {
+		t.Fatal("signature only verification should not report accepted tokens")
+	}
+
+	// Verification reports the accepted token.
+	if err := verifier.Verify(token); err != nil {
+		t.Fatal(err)
+	}
+	if accepted[pbt.Serial] != 1 || len(accepted) != 1 {
+		t.Fatalf("unexpected accepted tokens: %v", accepted)
+	}
+
+	// Rejected tokens are not reported.
+	token.Data = []byte("invalid")
+	if err := verifier.Verify(token); err == nil {
+		t.Fatal("invalid token should be rejected")
+	}
+	if len(accepted) != 1 {
+		t.Fatalf("rejected token was reported: %v", accepted)
+	}
+}
+
+func TestVerificationFailureCause(t *testing.T) {
+	t.Parallel()
+
+	for err, expected := range map[error]string{
+		ErrZoneMismatch:                          verificationCauseZoneMismatch,
+		fmt.Errorf("%w: bad", ErrTokenMalformed): verificationCauseMalformed,
+		ErrTokenInvalid:                          verificationCauseInvalid,
+		fmt.Errorf("%w: twice", ErrTokenUsed):    verificationCauseUsed,
+		ErrTokenRevoked:                          verificationCauseRevoked,
+		errors.New("unexpected"):                 verificationCauseOther,
+	} {
+		if cause := getVerificationFailureCause(err); cause != expected {
+			t.Errorf("expected cause %s for %q, got %s", expected, err, cause)
+		}
+	}
+}
+
+func TestPBlindPrivateKeySources(t *testing.T) {
+	privateKey := "HbwGtLsqek1Fdwuz1MhNQfiY7tj9EpWHeMWHPZ9c6KYY"
+	newOpts := func() PBlindOptions {
+		return PBlindOptions{
+			Zone:       PBlindTestZone,
+			Curve:      elliptic.P256(),
+			UseSerials: true,
+			BatchSize:  10,
+			PublicKey:  "285oMDh3w5mxyFgpmmURifKfhkcqwwsdnePpPZ6Nqm8cc",
+		}
+	}
+
+	// Load from file.
+	keyFile := filepath.Join(t.TempDir(), "pblind.key")
+	if err := ioutil.WriteFile(keyFile, []byte(privateKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := newOpts()
+	opts.PrivateKeyFile = keyFile
+	if _, err := NewPBlindHandler(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Load from env.
+	envName := "SPN_TEST_PBLIND_PRIVATE_KEY"
+	if err := os.Setenv(envName, privateKey); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Unsetenv(envName)
+	}()
+	opts = newOpts()
+	opts.PrivateKeyEnv = envName
+	if _, err := NewPBlindHandler(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Multiple sources are rejected.
+	opts = newOpts()
+	opts.PrivateKey = privateKey
+	opts.PrivateKeyEnv = envName
+	if _, err := NewPBlindHandler(opts); err == nil {
+		t.Fatal("multiple private key sources should be rejected")
+	}
+
+	// Missing sources are rejected.
+	opts = newOpts()
+	opts.PrivateKeyFile = keyFile + ".missing"
+	if _, err := NewPBlindHandler(opts); err == nil {
+		t.Fatal("missing private key file should be rejected")
+	}
+	opts = newOpts()
+	opts.PrivateKeyEnv = envName + "_MISSING"
+	if _, err := NewPBlindHandler(opts); err == nil {
+		t.Fatal("missing private key env should be rejected")
+	}
+}
+
+func TestPBlindSetupPool(t *testing.T) {
+	opts := PBlindOptions{
+		Zone:                    "test-pblind-setup-pool",
+		Curve:                   elliptic.P256(),
+		UseSerials:              true,
+		BatchSize:               10,
+		MaxBatchSize:            20,
+		SetupPoolSize:           2,
+		SetupPoolRefillInterval: time.Millisecond,
+	}
+	issuer, client, err := newPBlindTestHandlers(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the pool to fill up.
+	for i := 0; issuer.setupPool.size() < 2; i++ {
+		if i > 1000 {
+			t.Fatal("setup pool did not fill up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Pre-generated setups are used once and work for issuing.
+	signerState, setupResponse, err := issuer.CreateSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := client.CreateTokenRequest(setupResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuedTokens, err := issuer.IssueTokens(signerState, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ProcessIssuedTokens(issuedTokens); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other batch sizes are created inline.
+	_, setupResponse, err = issuer.CreateSetupWithBatchSize(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(setupResponse.Msgs) != 20 {
+		t.Fatalf("expected batch size of 20, got %d", len(setupResponse.Msgs))
+	}
+
+	// Unregistering closes the handler and drains the pool.
+	if err := RegisterPBlindHandler(issuer); err != nil {
+		t.Fatal(err)
+	}
+	if !UnregisterHandler(opts.Zone) {
+		t.Fatal("handler should have been registered")
+	}
+	if issuer.closed.IsNotSet() {
+		t.Fatal("unregistered handler was not closed")
+	}
+	if issuer.setupPool.size() != 0 {
+		t.Fatal("setup pool was not drained")
+	}
+	if _, _, err := issuer.CreateSetup(); !errors.Is(err, ErrHandlerClosed) {
+		t.Fatalf("expected closed handler error, got %v", err)
+	}
+}
+
+func TestPBlindSerialBinding(t *testing.T) {
+	t.Parallel()
+
+	opts := PBlindOptions{
+		Zone:       PBlindTestZone,
+		Curve:      elliptic.P256(),
+		UseSerials: true,
+		BatchSize:  10,
+	}
+
+	issuer, client, err := newPBlindTestHandlers(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verifiers
+	verifier, err := NewPBlindHandler(*client.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noSerialOpts := *client.opts
+	noSerialOpts.UseSerials = false
+	noSerialVerifier, err := NewPBlindHandler(noSerialOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Get a token.
+	signerState, setupResponse, err := issuer.CreateSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := client.CreateTokenRequest(setupResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuedTokens, err := issuer.IssueTokens(signerState, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ProcessIssuedTokens(issuedTokens); err != nil {
+		t.Fatal(err)
+	}
+	token, err := client.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifySignatureOnly(token); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create modified copies of the token.
+	modified := func(modify func(pbt *PBlindToken)) *Token {
+		pbt, err := UnpackPBlindToken(token.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modify(pbt)
+		data, err := pbt.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Token{Zone: token.Zone, Data: data}
+	}
+
+	// A token with a swapped, but valid, serial fails the signature check.
+	swapped := modified(func(pbt *PBlindToken) {
+		pbt.Serial = pbt.Serial%10 + 1
+	})
+	if err := verifier.VerifySignatureOnly(swapped); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("serial-swapped token should be invalid, got %v", err)
+	}
+
+	// Serials outside of the serial space are rejected.
+	outOfSpace := modified(func(pbt *PBlindToken) {
+		pbt.Serial = 11
+	})
+	if err := verifier.VerifySignatureOnly(outOfSpace); !errors.Is(err, ErrTokenMalformed) {
+		t.Fatalf("token with serial outside of serial space should be malformed, got %v", err)
+	}
+
+	// Tokens without signature are rejected.
+	unsigned := modified(func(pbt *PBlindToken) {
+		pbt.Signature = nil
+	})
+	if err := verifier.VerifySignatureOnly(unsigned); !errors.Is(err, ErrTokenMalformed) {
+		t.Fatalf("unsigned token should be malformed, got %v", err)
+	}
+
+	// Verifiers without serials reject tokens with serials.
+	if err := noSerialVerifier.VerifySignatureOnly(token); !errors.Is(err, ErrTokenMalformed) {
+		t.Fatalf("token with serial should be malformed without serials, got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is returned when the token issuance quota of a device is
+// exceeded.
+var ErrQuotaExceeded = errors.New("token issuance quota exceeded")
+
+// QuotaExceededError is returned when the token issuance quota of a device is
+// exceeded. It matches ErrQuotaExceeded with errors.Is.
+type QuotaExceededError struct {
+	Zone string
+	// RetryAfter is the time after which the device may request tokens again.
+	// Token issuers send it to the client with account.ApplyRetryAfterToResponse.
+	RetryAfter time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s for %s, retry after %s", ErrQuotaExceeded, e.Zone, e.RetryAfter.Format(time.RFC3339))
+}
+
+// Is returns whether the error matches the given target.
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// IssuanceQuota checks whether a device may be issued tokens. Implementations
+// may keep their state in memory or in a database.
+type IssuanceQuota interface {
+	// Reserve reserves the given amount of tokens of the given zone for the
+	// given device. It returns a *QuotaExceededError if the quota of the device
+	// does not permit the amount.
+	Reserve(deviceID, zone string, amount int, now time.Time) error
+
+	// Release releases the given amount of tokens that were reserved at the
+	// given time, because they were not issued.
+	Release(deviceID, zone string, amount int, reservedAt time.Time)
+}
+
+var (
+	issuanceQuota     IssuanceQuota
+	issuanceQuotaLock sync.RWMutex
+)
+
+// SetIssuanceQuota sets the quota that is checked by IssueTokensForDevice.
+// Set to nil to disable quota checks.
+func SetIssuanceQuota(quota IssuanceQuota) {
+	issuanceQuotaLock.Lock()
+	defer issuanceQuotaLock.Unlock()
+
+	issuanceQuota = quota
+}
+
+func getIssuanceQuota() IssuanceQuota {
+	issuanceQuotaLock.RLock()
+	defer issuanceQuotaLock.RUnlock()
+
+	return issuanceQuota
+}
+
+// MemoryIssuanceQuota is an in-memory IssuanceQuota that permits a maximum
+// amount of tokens per device and zone within a fixed time window.
+// Passed windows are cleaned up while reserving, at most once per window.
+type MemoryIssuanceQuota struct {
+	lock sync.Mutex
+
+	limit     int
+	window    time.Duration
+	usage     map[string]*quotaUsage // Key is device ID and zone.
+	lastClean time.Time
+}
+
+type quotaUsage struct {
+	windowStart time.Time
+	issued      int
+}
+
+// NewMemoryIssuanceQuota returns a new in-memory quota that permits the given
+// amount of tokens per device and zone within the given time window.
+func NewMemoryIssuanceQuota(limit int, window time.Duration) (*MemoryIssuanceQuota, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid quota limit %d", limit)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid quota window %s", window)
+	}
+
+	return &MemoryIssuanceQuota{
+		limit:  limit,
+		window: window,
+		usage:  make(map[string]*quotaUsage),
+	}, nil
+}
+
+// Reserve reserves the given amount of tokens of the given zone for the given
+// device.
+func (q *MemoryIssuanceQuota) Reserve(deviceID, zone string, amount int, now time.Time) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// Clean up passed windows regularly.
+	if now.Sub(q.lastClean) >= q.window {
+		q.clean(now)
+	}
+
+	// Get usage and start a new window if the current one has passed.
+	key := deviceID + "/" + zone
+	usage, ok := q.usage[key]
+	if !ok || now.Sub(usage.windowStart) >= q.window {
+		usage = &quotaUsage{
+			windowStart: now,
+		}
+		q.usage[key] = usage
+	}
+
+	// Check quota.
+	if usage.issued+amount > q.limit {
+		return &QuotaExceededError{
+			Zone:       zone,
+			RetryAfter: usage.windowStart.Add(q.window),
+		}
+	}
+
+	usage.issued += amount
+	return nil
+}
+
+// Release releases the given amount of tokens of the given zone for the given
+// device. Reservations of a passed window are not released.
+func (q *MemoryIssuanceQuota) Release(deviceID, zone string, amount int, reservedAt time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	usage, ok := q.usage[deviceID+"/"+zone]
+	if !ok || reservedAt.Before(usage.windowStart) {
+		return
+	}
+
+	usage.issued -= amount
+	if usage.issued < 0 {
+		usage.issued = 0
+	}
+}
+
+// Clean removes the usage of all devices whose window has passed.
+func (q *MemoryIssuanceQuota) Clean(now time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.clean(now)
+}
+
+func (q *MemoryIssuanceQuota) clean(now time.Time) {
+	q.lastClean = now
+	for key, usage := range q.usage {
+		if now.Sub(usage.windowStart) >= q.window {
+			delete(q.usage, key)
+		}
+	}
+}
+
+// IssueTokensForDevice issues the requested tokens like IssueTokens, but
+// first checks the requested amount of tokens against the configured
+// issuance quota of the given device. Scramble tokens are not subject to
+// quotas, as they are not unique. If issuing fails, the reserved quota is
+// released.
+func IssueTokensForDevice(state *RequestHandlingState, request *TokenRequest, deviceID string) (response *IssuedTokens, err error) {
+	reservation, err := reserveIssuanceQuota(state, request, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err = IssueTokens(state, request)
+	if err != nil {
+		reservation.release(nil)
+		return nil, err
+	}
+	return response, nil
+}
+
+// IssueTokensStreamForDevice issues the requested tokens like
+// IssueTokensStream, but first checks the requested amount of tokens against
+// the configured issuance quota of the given device. If issuing fails, the
+// reserved quota of the tokens that were not emitted is released.
+func IssueTokensStreamForDevice(state *RequestHandlingState, request *TokenRequest, deviceID string, emit func(*IssuedToken) error) error {
+	reservation, err := reserveIssuanceQuota(state, request, deviceID)
+	if err != nil {
+		return err
+	}
+
+	emitted := make(map[string]int)
+	err = IssueTokensStream(state, request, func(issued *IssuedToken) error {
+		if err := emit(issued); err != nil {
+			return err
+		}
+		if issued.PBlind != nil {
+			emitted[issued.Zone]++
+		}
+		return nil
+	})
+	if err != nil {
+		reservation.release(emitted)
+		return err
+	}
+	return nil
+}
+
+// quotaReservation holds the amounts of tokens per zone that were reserved
+// for a device.
+type quotaReservation struct {
+	quota      IssuanceQuota
+	deviceID   string
+	reservedAt time.Time
+	amounts    map[string]int
+}
+
+// reserveIssuanceQuota reserves the requested amount of tokens of all zones
+// that will be issued with the configured issuance quota. Either all zones
+// are reserved or none.
+func reserveIssuanceQuota(state *RequestHandlingState, request *TokenRequest, deviceID string) (*quotaReservation, error) {
+	quota := getIssuanceQuota()
+	if quota == nil {
+		return nil, nil
+	}
+
+	reservation := &quotaReservation{
+		quota:      quota,
+		deviceID:   deviceID,
+		reservedAt: time.Now(),
+		amounts:    make(map[string]int, len(request.PBlind)),
+	}
+	for zone, pblindRequest := range request.PBlind {
+		// Only check zones that will actually be issued.
+		if _, ok := state.PBlind[zone]; !ok || pblindRequest == nil {
+			continue
+		}
+
+		err := quota.Reserve(deviceID, zone, len(pblindRequest.Msgs), reservation.reservedAt)
+		if err != nil {
+			reservation.release(nil)
+			if errors.Is(err, ErrQuotaExceeded) {
+				reportQuotaRejection(zone)
+			}
+			return nil, fmt.Errorf("failed to issue tokens for %s: %w", zone, err)
+		}
+		reservation.amounts[zone] = len(pblindRequest.Msgs)
+	}
+
+	return reservation, nil
+}
+
+// release releases the reserved tokens, except for the given amounts of
+// tokens per zone that were issued.
+func (r *quotaReservation) release(issued map[string]int) {
+	if r == nil {
+		return
+	}
+
+	for zone, amount := range r.amounts {
+		if unused := amount - issued[zone]; unused > 0 {
+			r.quota.Release(r.deviceID, zone, unused, r.reservedAt)
+		}
+	}
+}
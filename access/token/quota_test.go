@@ -0,0 +1,72 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryIssuanceQuota(t *testing.T) {
+	t.Parallel()
+
+	quota, err := NewMemoryIssuanceQuota(10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	// Reserve up to the limit.
+	if err := quota.Reserve("device1", "zone", 6, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := quota.Reserve("device1", "zone", 4, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exceeding the limit is rejected.
+	err = quota.Reserve("device1", "zone", 1, now.Add(time.Minute))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected quota to be exceeded, got %v", err)
+	}
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) || !quotaErr.RetryAfter.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected quota error: %v", err)
+	}
+
+	// Released tokens may be reserved again.
+	quota.Release("device1", "zone", 4, now)
+	if err := quota.Reserve("device1", "zone", 4, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	quota.Release("device1", "zone", 4, now.Add(-time.Hour))
+	if err := quota.Reserve("device1", "zone", 1, now.Add(time.Minute)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("releasing from a passed window should have no effect, got %v", err)
+	}
+
+	// Other devices and zones have their own quota.
+	if err := quota.Reserve("device2", "zone", 10, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := quota.Reserve("device1", "other", 10, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The quota is reset after the window.
+	if err := quota.Reserve("device1", "zone", 10, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cleaning removes passed windows.
+	quota.Clean(now.Add(time.Hour))
+	if len(quota.usage) != 1 {
+		t.Errorf("expected 1 remaining usage entry, got %d", len(quota.usage))
+	}
+
+	// Passed windows are also cleaned while reserving.
+	if err := quota.Reserve("device3", "zone", 1, now.Add(3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(quota.usage) != 1 {
+		t.Errorf("expected 1 remaining usage entry, got %d", len(quota.usage))
+	}
+}
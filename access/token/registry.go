@@ -28,6 +28,11 @@ type Handler interface {
 	// Load loads the given tokens into the handler.
 	Load(data []byte) error
 
+	// LoadLenient loads the given tokens into the handler, but skips invalid
+	// tokens instead of failing. It returns the amount of loaded and dropped
+	// tokens.
+	LoadLenient(data []byte) (loaded, dropped int, err error)
+
 	// Clear clears all the tokens in the handler.
 	Clear()
 }
@@ -96,9 +101,57 @@ func GetHandler(zone string) (handler Handler, ok bool) {
 	return
 }
 
+// ResetRegistry removes and closes all registered handlers.
 func ResetRegistry() {
+	registryLock.Lock()
+	handlers := registry
+	initRegistry()
+	registryLock.Unlock()
+
+	for _, handler := range handlers {
+		closeHandler(handler)
+	}
+}
+
+// UnregisterHandler removes the handler of the given zone from the registry
+// and closes it. It returns whether a handler was registered for the zone.
+func UnregisterHandler(zone string) bool {
+	handler, ok := unregisterHandler(zone)
+	if ok {
+		closeHandler(handler)
+	}
+	return ok
+}
+
+func unregisterHandler(zone string) (handler Handler, ok bool) {
 	registryLock.Lock()
 	defer registryLock.Unlock()
 
-	initRegistry()
+	handler, ok = registry[zone]
+	if !ok {
+		return nil, false
+	}
+	delete(registry, zone)
+
+	for i, h := range pblindRegistry {
+		if h.opts.Zone == zone {
+			pblindRegistry = append(pblindRegistry[:i], pblindRegistry[i+1:]...)
+			break
+		}
+	}
+	for i, h := range scrambleRegistry {
+		if h.opts.Zone == zone {
+			scrambleRegistry = append(scrambleRegistry[:i], scrambleRegistry[i+1:]...)
+			break
+		}
+	}
+	return handler, true
+}
+
+// closeHandler closes the given handler, if it supports closing, so that its
+// workers, such as the setup pool, are stopped.
+func closeHandler(handler Handler) {
+	if closer, ok := handler.(interface{ Close() }); ok {
+		closer.Close()
+	}
 }
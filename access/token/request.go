@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/mr-tron/base58"
 )
@@ -16,7 +17,7 @@ type RequestHandlingState struct {
 }
 
 type SetupRequest struct {
-	PBlind map[string]struct{} `json:"PB,omitempty"`
+	PBlind map[string]*PBlindSetupRequest `json:"PB,omitempty"`
 }
 
 type SetupResponse struct {
@@ -35,19 +36,29 @@ type IssuedTokens struct {
 	Scramble map[string]*IssuedScrambleTokens `json:"SC,omitempty"`
 }
 
+// CreateSetupRequest creates a setup request for all zones that should request
+// new tokens.
 func CreateSetupRequest() (request *SetupRequest, setupRequired bool) {
+	return CreateSetupRequestForZones(nil)
+}
+
+// CreateSetupRequestForZones creates a setup request for the given zones, if
+// they should request new tokens. If zones is nil, all zones are considered.
+func CreateSetupRequestForZones(zones []string) (request *SetupRequest, setupRequired bool) {
 	registryLock.RLock()
 	defer registryLock.RUnlock()
 
 	request = &SetupRequest{
-		PBlind: make(map[string]struct{}, len(pblindRegistry)),
+		PBlind: make(map[string]*PBlindSetupRequest, len(pblindRegistry)),
 	}
 
 	// Go through handlers and create request setups.
 	for _, pblindHandler := range pblindRegistry {
 		// Check if we need to request with this handler.
-		if pblindHandler.ShouldRequest() {
-			request.PBlind[pblindHandler.Zone()] = struct{}{}
+		if zoneSelected(zones, pblindHandler.Zone()) && pblindHandler.ShouldRequest() {
+			request.PBlind[pblindHandler.Zone()] = &PBlindSetupRequest{
+				BatchSize: pblindHandler.PreferredBatchSize(),
+			}
 			setupRequired = true
 		}
 	}
@@ -55,6 +66,40 @@ func CreateSetupRequest() (request *SetupRequest, setupRequired bool) {
 	return
 }
 
+// ZonesToRequest returns the zones that should request new tokens.
+func ZonesToRequest() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	zones := make([]string, 0, len(pblindRegistry)+len(scrambleRegistry))
+	for _, pblindHandler := range pblindRegistry {
+		if pblindHandler.ShouldRequest() {
+			zones = append(zones, pblindHandler.Zone())
+		}
+	}
+	for _, scrambleHandler := range scrambleRegistry {
+		if scrambleHandler.ShouldRequest() {
+			zones = append(zones, scrambleHandler.Zone())
+		}
+	}
+
+	return zones
+}
+
+// zoneSelected returns whether the zone is in the given zones. If zones is
+// nil, all zones are selected.
+func zoneSelected(zones []string, zone string) bool {
+	if zones == nil {
+		return true
+	}
+	for _, z := range zones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
+
 func HandleSetupRequest(request *SetupRequest) (*RequestHandlingState, *SetupResponse, error) {
 	registryLock.RLock()
 	defer registryLock.RUnlock()
@@ -83,12 +128,20 @@ func HandleSetupRequest(request *SetupRequest) (*RequestHandlingState, *SetupRes
 	// Go through handlers and create setups.
 	for _, pblindHandler := range pblindRegistry {
 		// Check if we have a request for this handler.
-		_, ok := request.PBlind[pblindHandler.Zone()]
+		pblindRequest, ok := request.PBlind[pblindHandler.Zone()]
 		if !ok {
 			continue
 		}
 
-		plindState, pblindSetup, err := pblindHandler.CreateSetup()
+		// Get the requested batch size. The handler enforces its limits.
+		var requestedBatchSize int
+		if pblindRequest != nil {
+			requestedBatchSize = pblindRequest.BatchSize
+		}
+
+		started := time.Now()
+		plindState, pblindSetup, err := pblindHandler.CreateSetupWithBatchSize(requestedBatchSize)
+		reportIssuance(pblindHandler.Zone(), issuanceOpSetup, started, err)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to create setup for %s: %w", pblindHandler.Zone(), err)
 		}
@@ -100,7 +153,15 @@ func HandleSetupRequest(request *SetupRequest) (*RequestHandlingState, *SetupRes
 	return state, setup, nil
 }
 
+// CreateTokenRequest creates a token request for all zones that should request
+// new tokens.
 func CreateTokenRequest(setup *SetupResponse) (request *TokenRequest, requestRequired bool, err error) {
+	return CreateTokenRequestForZones(setup, nil)
+}
+
+// CreateTokenRequestForZones creates a token request for the given zones, if
+// they should request new tokens. If zones is nil, all zones are considered.
+func CreateTokenRequestForZones(setup *SetupResponse, zones []string) (request *TokenRequest, requestRequired bool, err error) {
 	registryLock.RLock()
 	defer registryLock.RUnlock()
 
@@ -140,7 +201,7 @@ func CreateTokenRequest(setup *SetupResponse) (request *TokenRequest, requestReq
 	}
 	for _, scrambleHandler := range scrambleRegistry {
 		// Check if we need to request with this handler.
-		if scrambleHandler.ShouldRequest() {
+		if zoneSelected(zones, scrambleHandler.Zone()) && scrambleHandler.ShouldRequest() {
 			requestRequired = true
 			request.Scramble[scrambleHandler.Zone()] = scrambleHandler.CreateTokenRequest()
 		}
@@ -172,7 +233,9 @@ func IssueTokens(state *RequestHandlingState, request *TokenRequest) (response *
 		}
 
 		// Issue tokens.
+		started := time.Now()
 		pblindTokens, err := pblindHandler.IssueTokens(pblindState, pblindRequest)
+		reportIssuance(pblindHandler.Zone(), issuanceOpIssue, started, err)
 		if err != nil {
 			return nil, fmt.Errorf("failed to issue tokens for %s: %w", pblindHandler.Zone(), err)
 		}
@@ -187,7 +250,9 @@ func IssueTokens(state *RequestHandlingState, request *TokenRequest) (response *
 		}
 
 		// Issue tokens.
+		started := time.Now()
 		scrambleTokens, err := scrambleHandler.IssueTokens(scrambleRequest)
+		reportIssuance(scrambleHandler.Zone(), issuanceOpIssue, started, err)
 		if err != nil {
 			return nil, fmt.Errorf("failed to issue tokens for %s: %w", scrambleHandler.Zone(), err)
 		}
@@ -211,7 +276,9 @@ func ProcessIssuedTokens(response *IssuedTokens) error {
 		}
 
 		// Process issued tokens.
+		started := time.Now()
 		err := pblindHandler.ProcessIssuedTokens(pblindResponse)
+		reportIssuance(pblindHandler.Zone(), issuanceOpProcess, started, err)
 		if err != nil {
 			return fmt.Errorf("failed to process issued tokens for %s: %w", pblindHandler.Zone(), err)
 		}
@@ -224,7 +291,9 @@ func ProcessIssuedTokens(response *IssuedTokens) error {
 		}
 
 		// Process issued tokens.
+		started := time.Now()
 		err := scrambleHandler.ProcessIssuedTokens(scrambleResponse)
+		reportIssuance(scrambleHandler.Zone(), issuanceOpProcess, started, err)
 		if err != nil {
 			return fmt.Errorf("failed to process issued tokens for %s: %w", scrambleHandler.Zone(), err)
 		}
@@ -0,0 +1,282 @@
+package token
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rot256/pblind"
+	"github.com/safing/portbase/formats/dsd"
+)
+
+// IssuedTokensStreamMIMEType is the MIME type of a streamed token issuing
+// response. The response body is a sequence of issued tokens, each encoded
+// with dsd and prefixed with its length as an unsigned varint.
+const IssuedTokensStreamMIMEType = "application/vnd.safing.spn.issued-tokens-stream"
+
+// maxStreamedIssuedTokenSize is the maximum size of a single streamed issued
+// token.
+const maxStreamedIssuedTokenSize = 1 << 20 // 1MB
+
+// IssuedToken is a single issued token, as emitted by IssueTokensStream.
+// Either PBlind or Scramble is set. Scramble tokens are emitted as a whole, as
+// they are not issued in large batches.
+type IssuedToken struct {
+	Zone     string                `json:"Z,omitempty"`
+	Index    int                   `json:"I,omitempty"`
+	PBlind   *pblind.Message3      `json:"PB,omitempty"`
+	Scramble *IssuedScrambleTokens `json:"SC,omitempty"`
+}
+
+// IssueTokensStream issues the requested tokens like IssueTokens, but hands
+// every issued token to emit as soon as it is signed, so that it can be sent
+// without buffering the whole response. If emit returns an error, issuing is
+// aborted and the error is returned.
+func IssueTokensStream(state *RequestHandlingState, request *TokenRequest, emit func(*IssuedToken) error) error {
+	// Copy the handlers, as the registry must not be locked while streaming to
+	// a possibly slow receiver.
+	registryLock.RLock()
+	pblindHandlers := make([]*PBlindHandler, len(pblindRegistry))
+	copy(pblindHandlers, pblindRegistry)
+	scrambleHandlers := make([]*ScrambleHandler, len(scrambleRegistry))
+	copy(scrambleHandlers, scrambleRegistry)
+	registryLock.RUnlock()
+
+	// Go through handlers and issue tokens.
+	for _, pblindHandler := range pblindHandlers {
+		// Check if we have all the data for issuing.
+		zone := pblindHandler.Zone()
+		pblindState, ok := state.PBlind[zone]
+		if !ok {
+			continue
+		}
+		pblindRequest, ok := request.PBlind[zone]
+		if !ok {
+			continue
+		}
+
+		// Issue tokens.
+		started := time.Now()
+		err := pblindHandler.IssueTokensStream(pblindState, pblindRequest, func(i int, msg *pblind.Message3) error {
+			return emit(&IssuedToken{
+				Zone:   zone,
+				Index:  i,
+				PBlind: msg,
+			})
+		})
+		reportIssuance(zone, issuanceOpIssue, started, err)
+		if err != nil {
+			return fmt.Errorf("failed to issue tokens for %s: %w", zone, err)
+		}
+	}
+	for _, scrambleHandler := range scrambleHandlers {
+		// Check if we have all the data for issuing.
+		zone := scrambleHandler.Zone()
+		scrambleRequest, ok := request.Scramble[zone]
+		if !ok {
+			continue
+		}
+
+		// Issue tokens.
+		started := time.Now()
+		scrambleTokens, err := scrambleHandler.IssueTokens(scrambleRequest)
+		if err == nil {
+			err = emit(&IssuedToken{
+				Zone:     zone,
+				Scramble: scrambleTokens,
+			})
+		}
+		reportIssuance(zone, issuanceOpIssue, started, err)
+		if err != nil {
+			return fmt.Errorf("failed to issue tokens for %s: %w", zone, err)
+		}
+	}
+
+	return nil
+}
+
+// WriteIssuedToken writes the issued token to w in the format of the
+// IssuedTokensStreamMIMEType.
+func WriteIssuedToken(w io.Writer, issued *IssuedToken) error {
+	data, err := dsd.Dump(issued, dsd.MsgPack)
+	if err != nil {
+		return fmt.Errorf("failed to serialize issued token: %w", err)
+	}
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(data)))
+	if _, err := w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// IssuedTokensStream processes issued tokens as they stream in. No tokens are
+// added to the storage until Finish is called and all streamed tokens of all
+// zones were processed successfully.
+type IssuedTokensStream struct {
+	pblind   map[string]*PBlindIssuedTokenStream
+	scramble map[string]*IssuedScrambleTokens
+	started  time.Time
+
+	// err holds the first error that occurred while processing.
+	err error
+}
+
+// NewIssuedTokensStream returns a new stream for processing issued tokens.
+func NewIssuedTokensStream() *IssuedTokensStream {
+	return &IssuedTokensStream{
+		pblind:   make(map[string]*PBlindIssuedTokenStream),
+		scramble: make(map[string]*IssuedScrambleTokens),
+		started:  time.Now(),
+	}
+}
+
+// Process processes a single issued token. Any error fails the whole stream.
+func (s *IssuedTokensStream) Process(issued *IssuedToken) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.err = s.process(issued)
+	return s.err
+}
+
+// ProcessFrom processes issued tokens read from r in the format of the
+// IssuedTokensStreamMIMEType until r is exhausted.
+func (s *IssuedTokensStream) ProcessFrom(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		size, err := binary.ReadUvarint(br)
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return fmt.Errorf("failed to read issued token size: %w", err)
+		case size > maxStreamedIssuedTokenSize:
+			return fmt.Errorf("issued token of %d bytes exceeds maximum size", size)
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("failed to read issued token: %w", err)
+		}
+		issued := &IssuedToken{}
+		if _, err := dsd.Load(data, issued); err != nil {
+			return fmt.Errorf("failed to parse issued token: %w", err)
+		}
+		if err := s.Process(issued); err != nil {
+			return err
+		}
+	}
+}
+
+// ProcessAll processes all issued tokens of a non-streamed response.
+func (s *IssuedTokensStream) ProcessAll(response *IssuedTokens) error {
+	for zone, pblindTokens := range response.PBlind {
+		if pblindTokens == nil {
+			continue
+		}
+		for i, msg := range pblindTokens.Msgs {
+			if err := s.Process(&IssuedToken{
+				Zone:   zone,
+				Index:  i,
+				PBlind: msg,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	for zone, scrambleTokens := range response.Scramble {
+		if err := s.Process(&IssuedToken{
+			Zone:     zone,
+			Scramble: scrambleTokens,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *IssuedTokensStream) process(issued *IssuedToken) error {
+	switch {
+	case issued == nil:
+		return errors.New("missing issued token")
+
+	case issued.PBlind != nil:
+		stream, ok := s.pblind[issued.Zone]
+		if !ok {
+			handler, _ := GetHandler(issued.Zone)
+			pblindHandler, ok := handler.(*PBlindHandler)
+			if !ok {
+				return fmt.Errorf("received issued tokens for unknown zone %s", issued.Zone)
+			}
+			var err error
+			stream, err = pblindHandler.StreamIssuedTokens()
+			if err != nil {
+				return fmt.Errorf("failed to process issued tokens for %s: %w", issued.Zone, err)
+			}
+			s.pblind[issued.Zone] = stream
+		}
+		if err := stream.Process(issued.Index, issued.PBlind); err != nil {
+			return fmt.Errorf("failed to process issued tokens for %s: %w", issued.Zone, err)
+		}
+
+	case issued.Scramble != nil:
+		if _, ok := s.scramble[issued.Zone]; ok {
+			return fmt.Errorf("received duplicate issued tokens for %s", issued.Zone)
+		}
+		s.scramble[issued.Zone] = issued.Scramble
+
+	default:
+		return fmt.Errorf("received empty issued token for %s", issued.Zone)
+	}
+
+	return nil
+}
+
+// Finish checks that all streamed batches are complete and only then adds
+// the issued tokens of all zones to the storage.
+func (s *IssuedTokensStream) Finish() error {
+	if s.err != nil {
+		return s.err
+	}
+
+	// Check all batches before adding any tokens.
+	for zone, stream := range s.pblind {
+		if err := stream.complete(); err != nil {
+			reportIssuance(zone, issuanceOpProcess, s.started, err)
+			return fmt.Errorf("failed to process issued tokens for %s: %w", zone, err)
+		}
+	}
+
+	// Process scramble tokens.
+	for zone, scrambleTokens := range s.scramble {
+		handler, _ := GetHandler(zone)
+		scrambleHandler, ok := handler.(*ScrambleHandler)
+		if !ok {
+			return fmt.Errorf("received issued tokens for unknown zone %s", zone)
+		}
+		started := time.Now()
+		err := scrambleHandler.ProcessIssuedTokens(scrambleTokens)
+		reportIssuance(zone, issuanceOpProcess, started, err)
+		if err != nil {
+			return fmt.Errorf("failed to process issued tokens for %s: %w", zone, err)
+		}
+	}
+
+	// Add PBlind tokens to storage last, as processing them cannot fail anymore.
+	for zone, stream := range s.pblind {
+		err := stream.commit()
+		reportIssuance(zone, issuanceOpProcess, s.started, err)
+		if err != nil {
+			return fmt.Errorf("failed to process issued tokens for %s: %w", zone, err)
+		}
+	}
+
+	return nil
+}
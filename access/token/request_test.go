@@ -1,6 +1,7 @@
 package token
 
 import (
+	"bytes"
 	"testing"
 	"time"
 
@@ -121,3 +122,123 @@ func TestFull(t *testing.T) {
 
 	t.Logf("full simulation took %s", time.Since(testStart))
 }
+
+func TestStreamedIssuance(t *testing.T) {
+	pblindHandler, ok := GetHandler(PBlindTestZone)
+	if !ok {
+		t.Fatal("pblind test handler not registered")
+	}
+
+	// issueStreamed requests new tokens and streams all issued tokens through
+	// the given filter.
+	issueStreamed := func(filter func(*IssuedToken) bool) error {
+		serverState, setupResponse, err := HandleSetupRequest(&SetupRequest{
+			PBlind: map[string]*PBlindSetupRequest{
+				PBlindTestZone: nil,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		request, _, err := CreateTokenRequest(setupResponse)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stream := NewIssuedTokensStream()
+		err = IssueTokensStream(serverState, request, func(issued *IssuedToken) error {
+			if !filter(issued) {
+				return nil
+			}
+
+			// Simulate transport.
+			data, err := dsd.Dump(issued, dsd.CBOR)
+			if err != nil {
+				return err
+			}
+			loaded := &IssuedToken{}
+			if _, err := dsd.Load(data, loaded); err != nil {
+				return err
+			}
+
+			return stream.Process(loaded)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stream.Finish()
+	}
+
+	// Issue all tokens.
+	amount := pblindHandler.Amount()
+	err := issueStreamed(func(*IssuedToken) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pblindHandler.Amount() <= amount {
+		t.Fatalf("expected streamed tokens to be added, have %d tokens", pblindHandler.Amount())
+	}
+
+	// An incomplete stream must not add any tokens.
+	amount = pblindHandler.Amount()
+	err = issueStreamed(func(issued *IssuedToken) bool {
+		return issued.PBlind == nil || issued.Index != 0
+	})
+	if err == nil {
+		t.Fatal("incomplete stream should fail")
+	}
+	if pblindHandler.Amount() != amount {
+		t.Fatalf("incomplete stream added tokens: %d -> %d", amount, pblindHandler.Amount())
+	}
+
+	// Streamed tokens are usable.
+	token, err := GetToken(PBlindTestZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyToken(token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamedIssuanceWireFormat(t *testing.T) {
+	pblindHandler, ok := GetHandler(PBlindTestZone)
+	if !ok {
+		t.Fatal("pblind test handler not registered")
+	}
+
+	serverState, setupResponse, err := HandleSetupRequest(&SetupRequest{
+		PBlind: map[string]*PBlindSetupRequest{
+			PBlindTestZone: nil,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, _, err := CreateTokenRequest(setupResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write the issued tokens in the streaming format.
+	var buf bytes.Buffer
+	err = IssueTokensStreamForDevice(serverState, request, "device", func(issued *IssuedToken) error {
+		return WriteIssuedToken(&buf, issued)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read and process them.
+	amount := pblindHandler.Amount()
+	stream := NewIssuedTokensStream()
+	if err := stream.ProcessFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if pblindHandler.Amount() <= amount {
+		t.Fatalf("expected streamed tokens to be added, have %d tokens", pblindHandler.Amount())
+	}
+}
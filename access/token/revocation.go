@@ -0,0 +1,120 @@
+package token
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mr-tron/base58"
+)
+
+// RevocationList holds revoked tokens of a zone. Tokens are identified by
+// their serial and a fingerprint of the token, so that specific issued tokens
+// can be revoked without rotating the issuer key.
+//
+// Revocation is checked before the double spend protection, so revoked tokens
+// are never recorded as spent. Revoking a token that was already spent has no
+// further effect.
+type RevocationList struct {
+	lock    sync.RWMutex
+	revoked map[string]struct{}
+}
+
+// NewRevocationList returns a new, empty revocation list.
+func NewRevocationList() *RevocationList {
+	return &RevocationList{
+		revoked: make(map[string]struct{}),
+	}
+}
+
+// TokenFingerprint returns the fingerprint of the given raw token.
+func TokenFingerprint(token []byte) string {
+	sum := sha256.Sum256(token)
+	return base58.Encode(sum[:])
+}
+
+// RevocationEntry returns the revocation list entry for the given serial and
+// raw token in the format "<serial>:<fingerprint>".
+func RevocationEntry(serial int, token []byte) string {
+	return makeRevocationEntry(serial, TokenFingerprint(token))
+}
+
+func makeRevocationEntry(serial int, fingerprint string) string {
+	return strconv.Itoa(serial) + ":" + fingerprint
+}
+
+// ParseRevocationEntry parses a revocation list entry in the format
+// "<serial>:<fingerprint>".
+func ParseRevocationEntry(entry string) (serial int, fingerprint string, err error) {
+	parts := strings.SplitN(entry, ":", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("revocation entry %q is not in the format <serial>:<fingerprint>", entry)
+	}
+
+	serial, err = strconv.Atoi(parts[0])
+	if err != nil || serial < 0 {
+		return 0, "", fmt.Errorf("revocation entry %q has an invalid serial", entry)
+	}
+	fp, err := base58.Decode(parts[1])
+	if err != nil || len(fp) != sha256.Size {
+		return 0, "", fmt.Errorf("revocation entry %q has an invalid fingerprint", entry)
+	}
+
+	return serial, parts[1], nil
+}
+
+// Revoke adds the token with the given serial and fingerprint to the list.
+func (rl *RevocationList) Revoke(serial int, fingerprint string) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	rl.revoked[makeRevocationEntry(serial, fingerprint)] = struct{}{}
+}
+
+// Load replaces the revoked tokens with the given revocation list entries.
+// The list is not changed if any entry is invalid.
+func (rl *RevocationList) Load(entries []string) error {
+	revoked := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		serial, fingerprint, err := ParseRevocationEntry(entry)
+		if err != nil {
+			return err
+		}
+		revoked[makeRevocationEntry(serial, fingerprint)] = struct{}{}
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	rl.revoked = revoked
+	return nil
+}
+
+// IsRevoked returns whether the given serial and raw token are revoked.
+func (rl *RevocationList) IsRevoked(serial int, token []byte) bool {
+	rl.lock.RLock()
+	defer rl.lock.RUnlock()
+
+	if len(rl.revoked) == 0 {
+		return false
+	}
+
+	_, revoked := rl.revoked[RevocationEntry(serial, token)]
+	return revoked
+}
+
+// Entries returns all revocation list entries in sorted order.
+func (rl *RevocationList) Entries() []string {
+	rl.lock.RLock()
+	defer rl.lock.RUnlock()
+
+	entries := make([]string, 0, len(rl.revoked))
+	for entry := range rl.revoked {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries
+}
@@ -0,0 +1,112 @@
+package token
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"testing"
+)
+
+func TestRevocationList(t *testing.T) {
+	rl := NewRevocationList()
+	if rl.IsRevoked(1, []byte("token")) {
+		t.Fatal("empty list must not revoke tokens")
+	}
+
+	// Revoke a token.
+	entry := RevocationEntry(1, []byte("token"))
+	serial, fingerprint, err := ParseRevocationEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl.Revoke(serial, fingerprint)
+	if !rl.IsRevoked(1, []byte("token")) {
+		t.Fatal("token should be revoked")
+	}
+	if rl.IsRevoked(2, []byte("token")) {
+		t.Fatal("token with other serial must not be revoked")
+	}
+
+	// Invalid entries must not change the list.
+	for _, invalid := range []string{"", "1", "x:" + fingerprint, "1:invalid"} {
+		if err := rl.Load([]string{invalid}); err == nil {
+			t.Errorf("entry %q should be invalid", invalid)
+		}
+	}
+	if len(rl.Entries()) != 1 {
+		t.Fatal("invalid load must not change the list")
+	}
+
+	// Loading replaces the list.
+	if err := rl.Load([]string{RevocationEntry(2, []byte("other"))}); err != nil {
+		t.Fatal(err)
+	}
+	if rl.IsRevoked(1, []byte("token")) || !rl.IsRevoked(2, []byte("other")) {
+		t.Fatal("load should replace the list")
+	}
+}
+
+func TestPBlindRevocation(t *testing.T) {
+	opts := PBlindOptions{
+		Zone:       PBlindTestZone,
+		Curve:      elliptic.P256(),
+		UseSerials: true,
+		BatchSize:  10,
+	}
+
+	issuer, client, err := newPBlindTestHandlers(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Get a token.
+	signerState, setupResponse, err := issuer.CreateSetup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := client.CreateTokenRequest(setupResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuedTokens, err := issuer.IssueTokens(signerState, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.ProcessIssuedTokens(issuedTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := client.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbt, err := UnpackPBlindToken(token.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verifier with the token revoked.
+	var doubleSpendChecks int
+	verifierOpts := *client.opts
+	verifierOpts.DoubleSpendProtection = func([]byte) error {
+		doubleSpendChecks++
+		return nil
+	}
+	verifierOpts.Revocations = NewRevocationList()
+	err = verifierOpts.Revocations.Load([]string{RevocationEntry(pbt.Serial, pbt.Token)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewPBlindHandler(verifierOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoked tokens are rejected before the double spend protection.
+	err = verifier.Verify(token)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("expected revoked token error, got %v", err)
+	}
+	if doubleSpendChecks != 0 {
+		t.Fatal("revoked token must not reach the double spend protection")
+	}
+}
@@ -222,6 +222,35 @@ func (sh *ScrambleHandler) Load(data []byte) error {
 	return nil
 }
 
+// LoadLenient loads the given tokens into the handler, but skips corrupted
+// tokens instead of failing. It returns the amount of loaded and dropped
+// tokens. An error is only returned if the data cannot be parsed at all.
+// Scramble tokens cannot be verified by clients, so only empty tokens are
+// detected as corrupted.
+func (sh *ScrambleHandler) LoadLenient(data []byte) (loaded, dropped int, err error) {
+	sh.storageLock.Lock()
+	defer sh.storageLock.Unlock()
+
+	s := &ScrambleStorage{}
+	_, err = dsd.Load(data, s)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Only keep tokens that have data.
+	valid := make([]*ScrambleToken, 0, len(s.Storage))
+	for _, t := range s.Storage {
+		if t == nil || len(t.Token) == 0 {
+			dropped++
+			continue
+		}
+		valid = append(valid, t)
+	}
+
+	sh.Storage = valid
+	return len(valid), dropped, nil
+}
+
 // Clear clears all the tokens in the handler.
 func (sh *ScrambleHandler) Clear() {
 	sh.storageLock.Lock()
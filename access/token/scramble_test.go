@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/safing/jess/lhash"
+	"github.com/safing/portbase/formats/dsd"
 )
 
 const ScrambleTestZone = "test-scramble"
@@ -80,3 +81,33 @@ func TestScramble(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestScrambleLoadLenient(t *testing.T) {
+	client, err := NewScrambleHandler(ScrambleOptions{
+		Zone:      ScrambleTestZone,
+		Algorithm: lhash.SHA2_256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Store tokens with an empty one in between.
+	data, err := dsd.Dump(&ScrambleStorage{
+		Storage: []*ScrambleToken{
+			{Token: []byte("token-1")},
+			{},
+			{Token: []byte("token-2")},
+		},
+	}, dsd.CBOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, dropped, err := client.LoadLenient(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 2 || dropped != 1 || client.Amount() != 2 {
+		t.Fatalf("unexpected result: loaded=%d dropped=%d amount=%d", loaded, dropped, client.Amount())
+	}
+}
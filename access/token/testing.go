@@ -0,0 +1,30 @@
+package token
+
+// Key pair used by the pblind test handlers.
+const (
+	pblindTestPrivateKey = "HbwGtLsqek1Fdwuz1MhNQfiY7tj9EpWHeMWHPZ9c6KYY"
+	pblindTestPublicKey  = "285oMDh3w5mxyFgpmmURifKfhkcqwwsdnePpPZ6Nqm8cc"
+)
+
+// newPBlindTestHandlers creates an issuer and a client handler with the given
+// options and the test key pair. Setup pool options only apply to the issuer.
+// Further handlers, such as verifiers, can be created from the client options.
+func newPBlindTestHandlers(opts PBlindOptions) (issuer, client *PBlindHandler, err error) {
+	issuerOpts := opts
+	issuerOpts.PrivateKey = pblindTestPrivateKey
+	issuer, err = NewPBlindHandler(issuerOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientOpts := opts
+	clientOpts.PublicKey = pblindTestPublicKey
+	clientOpts.SetupPoolSize = 0
+	clientOpts.SetupPoolRefillInterval = 0
+	client, err = NewPBlindHandler(clientOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return issuer, client, nil
+}
@@ -0,0 +1,50 @@
+package access
+
+import (
+	"sync"
+	"time"
+)
+
+// ZoneTokenUsage holds the usage statistics of accepted tokens of a zone.
+type ZoneTokenUsage struct {
+	// Accepted is the amount of accepted tokens.
+	Accepted uint64
+	// LastSerial is the serial of the last accepted token, if the zone uses
+	// serials.
+	LastSerial int `json:",omitempty"`
+	// LastAcceptedAt is when the last token was accepted.
+	LastAcceptedAt time.Time
+}
+
+var (
+	tokenUsage     = make(map[string]*ZoneTokenUsage)
+	tokenUsageLock sync.Mutex
+)
+
+// recordTokenAccepted records that a token of the given zone was accepted.
+// It is called by the token handlers of configured zones.
+func recordTokenAccepted(zone string, serial int) {
+	tokenUsageLock.Lock()
+	defer tokenUsageLock.Unlock()
+
+	usage, ok := tokenUsage[zone]
+	if !ok {
+		usage = &ZoneTokenUsage{}
+		tokenUsage[zone] = usage
+	}
+	usage.Accepted++
+	usage.LastSerial = serial
+	usage.LastAcceptedAt = time.Now()
+}
+
+// GetTokenUsage returns the usage statistics of accepted tokens per zone.
+func GetTokenUsage() map[string]ZoneTokenUsage {
+	tokenUsageLock.Lock()
+	defer tokenUsageLock.Unlock()
+
+	usage := make(map[string]ZoneTokenUsage, len(tokenUsage))
+	for zone, zoneUsage := range tokenUsage {
+		usage[zone] = *zoneUsage
+	}
+	return usage
+}
@@ -0,0 +1,522 @@
+package access
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"github.com/safing/jess/lhash"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/access/account"
+	"github.com/safing/spn/access/token"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/terminal"
+)
+
+// Zone Types.
+const (
+	ZoneTypePBlind   = "pblind"
+	ZoneTypeScramble = "scramble"
+)
+
+// ZoneConfig defines a token zone.
+type ZoneConfig struct {
+	// Zone is the name of the zone.
+	Zone string `json:"zone"`
+	// Type is the type of the token handler of the zone.
+	Type string `json:"type"`
+
+	// CurveName is the name of the elliptic curve used by pblind zones.
+	CurveName string `json:"curve,omitempty"`
+	// PublicKey is the public key of the issuer of pblind zones.
+	PublicKey string `json:"publicKey,omitempty"`
+	// BatchSize is the default amount of tokens requested at once for pblind
+	// zones.
+	BatchSize int `json:"batchSize,omitempty"`
+	// MinBatchSize and MaxBatchSize are the bounds within which clients may
+	// request batch sizes for pblind zones. They default to BatchSize.
+	MinBatchSize int `json:"minBatchSize,omitempty"`
+	MaxBatchSize int `json:"maxBatchSize,omitempty"`
+	// UseSerials defines whether pblind tokens use serials.
+	UseSerials bool `json:"useSerials,omitempty"`
+	// RandomizeOrder defines whether pblind tokens are used in random order.
+	RandomizeOrder bool `json:"randomizeOrder,omitempty"`
+	// Revocations holds revoked pblind tokens in the format
+	// "<serial>:<fingerprint>". Revoked tokens are rejected by verifiers.
+	Revocations []string `json:"revocations,omitempty"`
+
+	// Verifiers holds the initial verifiers of scramble zones.
+	Verifiers []string `json:"verifiers,omitempty"`
+
+	// Fallback defines whether the zone is only used when the token issuer is
+	// not available.
+	Fallback bool `json:"fallback,omitempty"`
+	// RequiredTier defines the minimum account tier required to use the zone.
+	RequiredTier int `json:"requiredTier,omitempty"`
+}
+
+// DefaultZoneConfigs are the zones used if no other zones are configured.
+// The order defines the order in which zones are used.
+var DefaultZoneConfigs = []*ZoneConfig{
+	{
+		// pblind1 is the first primary zone.
+		Zone:           "pblind1",
+		Type:           ZoneTypePBlind,
+		CurveName:      "P-256",
+		PublicKey:      "eXoJXzXbM66UEsM2eVi9HwyBPLMfVnNrC7gNrsfMUJDs",
+		BatchSize:      1000,
+		UseSerials:     true,
+		RandomizeOrder: true,
+		RequiredTier:   account.TierBasic,
+	},
+	{
+		// alpha2 is used for the transition phase.
+		Zone:         "alpha2",
+		Type:         ZoneTypeScramble,
+		Verifiers:    []string{"ZwojEvXZmAv7SZdNe7m94Xzu7F9J8vULqKf7QYtoTpN2tH"},
+		RequiredTier: account.TierBasic,
+	},
+	{
+		// fallback1 is used as fallback when the issuer is not available.
+		Zone:         "fallback1",
+		Type:         ZoneTypeScramble,
+		Verifiers:    []string{"ZwkQoaAttVBMURzeLzNXokFBMAMUUwECfM1iHojcVKBmjk"},
+		Fallback:     true,
+		RequiredTier: account.TierBasic,
+	},
+}
+
+// TierBatchSizeFactors define by which factor the default batch size of pblind
+// zones is scaled for clients of an account tier. Accounts of higher tiers use
+// more tokens and benefit from fewer, larger requests. The batch size is
+// always limited to the bounds of the zone.
+var TierBatchSizeFactors = map[int]float64{
+	account.TierNone:  1,
+	account.TierBasic: 1,
+	account.TierPlus:  2,
+}
+
+var (
+	zoneConfigs     []*ZoneConfig
+	zoneConfigsLock sync.RWMutex
+
+	// applyZoneConfigsLock serializes applying zone configs, so that
+	// concurrent reloads do not reconcile the running zones at the same time.
+	applyZoneConfigsLock sync.Mutex
+)
+
+func init() {
+	setZoneConfigs(DefaultZoneConfigs)
+}
+
+// Validate checks if the zone config is valid.
+func (zc *ZoneConfig) Validate() error {
+	if zc.Zone == "" {
+		return fmt.Errorf("%w: missing zone name", ErrInvalidZoneConfig)
+	}
+	if zc.RequiredTier < account.TierNone || zc.RequiredTier > account.TierPlus {
+		return fmt.Errorf("%w: zone %s has unknown required tier %d", ErrInvalidZoneConfig, zc.Zone, zc.RequiredTier)
+	}
+
+	switch zc.Type {
+	case ZoneTypePBlind:
+		switch zc.CurveName {
+		case "P-256", "P-384", "P-521":
+		default:
+			return fmt.Errorf("%w: zone %s has unsupported curve %q, use P-256, P-384 or P-521", ErrInvalidZoneConfig, zc.Zone, zc.CurveName)
+		}
+		if zc.PublicKey == "" {
+			return fmt.Errorf("%w: zone %s is missing the public key", ErrInvalidZoneConfig, zc.Zone)
+		}
+		if zc.BatchSize < 1 || zc.BatchSize > token.MaxPBlindBatchSize {
+			return fmt.Errorf("%w: zone %s has batch size %d, must be between 1 and %d", ErrInvalidZoneConfig, zc.Zone, zc.BatchSize, token.MaxPBlindBatchSize)
+		}
+		if zc.MinBatchSize != 0 && (zc.MinBatchSize < 1 || zc.MinBatchSize > zc.BatchSize) {
+			return fmt.Errorf("%w: zone %s has min batch size %d, must be between 1 and %d", ErrInvalidZoneConfig, zc.Zone, zc.MinBatchSize, zc.BatchSize)
+		}
+		if zc.MaxBatchSize != 0 && (zc.MaxBatchSize < zc.BatchSize || zc.MaxBatchSize > token.MaxPBlindBatchSize) {
+			return fmt.Errorf("%w: zone %s has max batch size %d, must be between %d and %d", ErrInvalidZoneConfig, zc.Zone, zc.MaxBatchSize, zc.BatchSize, token.MaxPBlindBatchSize)
+		}
+		if len(zc.Verifiers) > 0 {
+			return fmt.Errorf("%w: zone %s is of type %s and cannot have verifiers", ErrInvalidZoneConfig, zc.Zone, zc.Type)
+		}
+		for _, entry := range zc.Revocations {
+			if _, _, err := token.ParseRevocationEntry(entry); err != nil {
+				return fmt.Errorf("%w: zone %s has an invalid revocation: %s", ErrInvalidZoneConfig, zc.Zone, err)
+			}
+		}
+		if err := token.CheckPBlindPublicKey(zc.CurveName, zc.PublicKey); err != nil {
+			return fmt.Errorf("%w: zone %s has an invalid public key: %s", ErrInvalidZoneConfig, zc.Zone, err)
+		}
+
+	case ZoneTypeScramble:
+		if len(zc.Verifiers) == 0 {
+			return fmt.Errorf("%w: zone %s is missing verifiers", ErrInvalidZoneConfig, zc.Zone)
+		}
+		if zc.CurveName != "" || zc.PublicKey != "" || zc.BatchSize != 0 || zc.MinBatchSize != 0 || zc.MaxBatchSize != 0 {
+			return fmt.Errorf("%w: zone %s is of type %s and cannot have a curve, public key or batch size", ErrInvalidZoneConfig, zc.Zone, zc.Type)
+		}
+		if len(zc.Revocations) > 0 {
+			return fmt.Errorf("%w: zone %s is of type %s and cannot have revocations", ErrInvalidZoneConfig, zc.Zone, zc.Type)
+		}
+
+	default:
+		return fmt.Errorf("%w: zone %s has unknown type %q", ErrInvalidZoneConfig, zc.Zone, zc.Type)
+	}
+
+	return nil
+}
+
+// ValidateZoneConfigs checks if the zone configs are valid as a whole.
+func ValidateZoneConfigs(configs []*ZoneConfig) error {
+	if len(configs) == 0 {
+		return fmt.Errorf("%w: no zones defined", ErrInvalidZoneConfig)
+	}
+
+	seen := make(map[string]struct{}, len(configs))
+	for _, zc := range configs {
+		if err := zc.Validate(); err != nil {
+			return err
+		}
+		if _, ok := seen[zc.Zone]; ok {
+			return fmt.Errorf("%w: zone %s is defined multiple times", ErrInvalidZoneConfig, zc.Zone)
+		}
+		seen[zc.Zone] = struct{}{}
+	}
+
+	return nil
+}
+
+// ParseZoneConfigs parses and validates zone configs in YAML or JSON format.
+func ParseZoneConfigs(data []byte) ([]*ZoneConfig, error) {
+	var configs []*ZoneConfig
+	if err := yaml.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse: %s", ErrInvalidZoneConfig, err)
+	}
+	if err := ValidateZoneConfigs(configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// ZoneReloadReport describes how the running zones were reconciled with new
+// zone configs.
+type ZoneReloadReport struct {
+	// Kept holds the zones whose config did not change. Their handlers and
+	// tokens were kept as is.
+	Kept []string
+	// Changed holds the zones whose config changed. Their handlers were
+	// recreated and their tokens were stored and loaded again.
+	Changed []string
+	// Added holds the zones that were newly added.
+	Added []string
+	// Removed holds the zones that were removed. Their tokens are kept in
+	// storage.
+	Removed []string
+}
+
+func (report *ZoneReloadReport) String() string {
+	return fmt.Sprintf(
+		"kept %v, changed %v, added %v, removed %v",
+		report.Kept, report.Changed, report.Added, report.Removed,
+	)
+}
+
+// LoadZoneConfigFile loads the zone configs from the given file and applies
+// them. It may be called again to reload the file.
+func LoadZoneConfigFile(path string) (*ZoneReloadReport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zone config file: %w", err)
+	}
+
+	configs, err := ParseZoneConfigs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return ApplyZoneConfigs(configs)
+}
+
+// ApplyZoneConfigs validates and applies the given zone configs. If the
+// module is online, the running token handlers are reconciled: Only zones
+// whose config changed are recreated, while the handlers and tokens of
+// unchanged zones are kept. Tokens of changed and removed zones are stored
+// before their handlers are removed and closed. Tokens of removed zones are
+// kept in storage. All new handlers are created before any running zone is
+// touched, so if creating one fails, the current zones are kept as they are.
+func ApplyZoneConfigs(configs []*ZoneConfig) (*ZoneReloadReport, error) {
+	if err := ValidateZoneConfigs(configs); err != nil {
+		return nil, err
+	}
+
+	applyZoneConfigsLock.Lock()
+	defer applyZoneConfigsLock.Unlock()
+
+	// Compare with current configs.
+	report := diffZoneConfigs(getZoneConfigs(), configs)
+
+	// Apply directly if the zones are not initialized yet.
+	if !module.Online() {
+		setZoneConfigs(configs)
+		return report, nil
+	}
+
+	// Create the handlers of changed and added zones before touching the
+	// running zones, so that a failure leaves the current zones as they are.
+	kept := make(map[string]struct{}, len(report.Kept))
+	for _, zone := range report.Kept {
+		kept[zone] = struct{}{}
+	}
+	requestSignalHandler := getRequestSignalHandler()
+	handlers := make([]token.Handler, 0, len(report.Changed)+len(report.Added))
+	for _, zc := range configs {
+		if _, ok := kept[zc.Zone]; ok || !mayInitializeZone(zc.Zone) {
+			continue
+		}
+		handler, err := zc.newZoneHandler(requestSignalHandler)
+		if err != nil {
+			for _, h := range handlers {
+				closeZoneHandler(h)
+			}
+			return report, err
+		}
+		handlers = append(handlers, handler)
+	}
+
+	// Store tokens and remove handlers of changed and removed zones.
+	store := getTokenStore()
+	for _, zones := range [][]string{report.Changed, report.Removed} {
+		for _, zone := range zones {
+			if handler, ok := token.GetHandler(zone); ok {
+				storeZoneTokens(store, zone, handler)
+				token.UnregisterHandler(zone)
+			}
+		}
+	}
+
+	// Apply configs and register the new handlers.
+	setZoneConfigs(configs)
+	for _, handler := range handlers {
+		// Remove any stale handler of the zone, so that registering cannot
+		// fail halfway through the swap.
+		token.UnregisterHandler(handler.Zone())
+		if err := registerZoneHandler(handler); err != nil {
+			log.Errorf("access: failed to register %s token handler: %s", handler.Zone(), err)
+			closeZoneHandler(handler)
+			continue
+		}
+		loadZoneTokens(store, handler.Zone(), handler)
+	}
+
+	log.Infof("access: applied %d zone configs: %s", len(configs), report)
+	return report, nil
+}
+
+// diffZoneConfigs compares the current and the new zone configs.
+func diffZoneConfigs(current, configs []*ZoneConfig) *ZoneReloadReport {
+	report := &ZoneReloadReport{}
+
+	previous := make(map[string]*ZoneConfig, len(current))
+	for _, zc := range current {
+		previous[zc.Zone] = zc
+	}
+	for _, zc := range configs {
+		prev, ok := previous[zc.Zone]
+		switch {
+		case !ok:
+			report.Added = append(report.Added, zc.Zone)
+		case reflect.DeepEqual(prev, zc):
+			report.Kept = append(report.Kept, zc.Zone)
+		default:
+			report.Changed = append(report.Changed, zc.Zone)
+		}
+		delete(previous, zc.Zone)
+	}
+	for _, zc := range current {
+		if _, ok := previous[zc.Zone]; ok {
+			report.Removed = append(report.Removed, zc.Zone)
+		}
+	}
+
+	return report
+}
+
+// setZoneConfigs sets the zone configs and derives the zone lists from them.
+func setZoneConfigs(configs []*ZoneConfig) {
+	zones := make([]string, 0, len(configs))
+	permissions := make(map[string]terminal.Permission, len(configs))
+	tiers := make(map[string]int, len(configs))
+	for _, zc := range configs {
+		zones = append(zones, zc.Zone)
+		permissions[zc.Zone] = terminal.AddPermissions(terminal.MayExpand, terminal.MayConnect)
+		tiers[zc.Zone] = zc.RequiredTier
+	}
+
+	zoneConfigsLock.Lock()
+	defer zoneConfigsLock.Unlock()
+
+	zoneConfigs = configs
+	expandAndConnectZones = zones
+	persistentZones = zones
+	zonePermissions = permissions
+	zoneTiers = tiers
+}
+
+func getZoneConfigs() []*ZoneConfig {
+	zoneConfigsLock.RLock()
+	defer zoneConfigsLock.RUnlock()
+
+	return zoneConfigs
+}
+
+// GetExpandAndConnectZones returns the zones that grant permission to expand
+// and connect, in the order they should be used.
+func GetExpandAndConnectZones() []string {
+	zoneConfigsLock.RLock()
+	defer zoneConfigsLock.RUnlock()
+
+	return expandAndConnectZones
+}
+
+func getPersistentZones() []string {
+	zoneConfigsLock.RLock()
+	defer zoneConfigsLock.RUnlock()
+
+	return persistentZones
+}
+
+func getZonePermission(zone string) (permission terminal.Permission, ok bool) {
+	zoneConfigsLock.RLock()
+	defer zoneConfigsLock.RUnlock()
+
+	permission, ok = zonePermissions[zone]
+	return
+}
+
+func getZoneTier(zone string) (tier int, ok bool) {
+	zoneConfigsLock.RLock()
+	defer zoneConfigsLock.RUnlock()
+
+	tier, ok = zoneTiers[zone]
+	return
+}
+
+// batchSizeForTier returns the batch size clients of the given account tier
+// should request. The token handler limits it to the bounds of the zone.
+func (zc *ZoneConfig) batchSizeForTier(tier int) int {
+	factor, ok := TierBatchSizeFactors[tier]
+	if !ok || factor <= 0 {
+		return zc.BatchSize
+	}
+	return int(float64(zc.BatchSize) * factor)
+}
+
+// createZoneHandler creates and registers the token handler for the zone.
+func (zc *ZoneConfig) createZoneHandler(requestSignalHandler func(token.Handler)) error {
+	handler, err := zc.newZoneHandler(requestSignalHandler)
+	if err != nil {
+		return err
+	}
+	if err := registerZoneHandler(handler); err != nil {
+		closeZoneHandler(handler)
+		return err
+	}
+	return nil
+}
+
+// newZoneHandler creates the token handler for the zone without registering
+// it.
+func (zc *ZoneConfig) newZoneHandler(requestSignalHandler func(token.Handler)) (token.Handler, error) {
+	return zc.newZoneHandlerForTier(requestSignalHandler, getClientTier())
+}
+
+// newZoneHandlerForTier creates the token handler for the zone for the given
+// account tier without registering it.
+func (zc *ZoneConfig) newZoneHandlerForTier(requestSignalHandler func(token.Handler), tier int) (token.Handler, error) {
+	switch zc.Type {
+	case ZoneTypePBlind:
+		ph, err := zc.newPBlindHandler(requestSignalHandler)
+		if err != nil {
+			return nil, err
+		}
+		// Request batch sizes according to the account tier.
+		if conf.Client() {
+			ph.SetPreferredBatchSize(zc.batchSizeForTier(tier))
+		}
+		return ph, nil
+
+	case ZoneTypeScramble:
+		sh, err := token.NewScrambleHandler(token.ScrambleOptions{
+			Zone:             zc.Zone,
+			Algorithm:        lhash.BLAKE2b_256,
+			InitialVerifiers: zc.Verifiers,
+			Fallback:         zc.Fallback,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s token handler: %w", zc.Zone, err)
+		}
+		return sh, nil
+
+	default:
+		return nil, fmt.Errorf("%w: zone %s has unknown type %q", ErrInvalidZoneConfig, zc.Zone, zc.Type)
+	}
+}
+
+// registerZoneHandler registers a token handler created by newZoneHandler.
+func registerZoneHandler(handler token.Handler) error {
+	var err error
+	switch h := handler.(type) {
+	case *token.PBlindHandler:
+		err = token.RegisterPBlindHandler(h)
+	case *token.ScrambleHandler:
+		err = token.RegisterScrambleHandler(h)
+	default:
+		return fmt.Errorf("unsupported token handler type %T", handler)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to register %s token handler: %w", handler.Zone(), err)
+	}
+	return nil
+}
+
+// closeZoneHandler closes a token handler that was created, but not
+// registered, so that its workers, such as the setup pool, are stopped.
+func closeZoneHandler(handler token.Handler) {
+	if closer, ok := handler.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// newPBlindHandler creates a pblind token handler for the zone without
+// registering it.
+func (zc *ZoneConfig) newPBlindHandler(requestSignalHandler func(token.Handler)) (*token.PBlindHandler, error) {
+	var revocations *token.RevocationList
+	if len(zc.Revocations) > 0 {
+		revocations = token.NewRevocationList()
+		if err := revocations.Load(zc.Revocations); err != nil {
+			return nil, fmt.Errorf("%w: zone %s has an invalid revocation: %s", ErrInvalidZoneConfig, zc.Zone, err)
+		}
+	}
+
+	ph, err := token.NewPBlindHandler(token.PBlindOptions{
+		Zone:                zc.Zone,
+		CurveName:           zc.CurveName,
+		PublicKey:           zc.PublicKey,
+		UseSerials:          zc.UseSerials,
+		BatchSize:           zc.BatchSize,
+		MinBatchSize:        zc.MinBatchSize,
+		MaxBatchSize:        zc.MaxBatchSize,
+		RandomizeOrder:      zc.RandomizeOrder,
+		Revocations:         revocations,
+		Fallback:            zc.Fallback,
+		SignalShouldRequest: requestSignalHandler,
+		OnTokenAccepted:     recordTokenAccepted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s token handler: %w", zc.Zone, err)
+	}
+	return ph, nil
+}
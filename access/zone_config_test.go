@@ -0,0 +1,146 @@
+package access
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/safing/spn/access/account"
+	"github.com/safing/spn/access/token"
+)
+
+func TestDefaultZoneConfigs(t *testing.T) {
+	t.Parallel()
+
+	if err := ValidateZoneConfigs(DefaultZoneConfigs); err != nil {
+		t.Fatalf("default zone configs are invalid: %s", err)
+	}
+}
+
+func TestParseZoneConfigs(t *testing.T) {
+	t.Parallel()
+
+	// Valid config.
+	configs, err := ParseZoneConfigs([]byte(`
+- zone: pblind2
+  type: pblind
+  curve: P-256
+  publicKey: eXoJXzXbM66UEsM2eVi9HwyBPLMfVnNrC7gNrsfMUJDs
+  batchSize: 500
+  useSerials: true
+- zone: fallback2
+  type: scramble
+  verifiers: [xyz]
+  fallback: true
+`))
+	if err != nil {
+		t.Fatalf("failed to parse valid config: %s", err)
+	}
+	if len(configs) != 2 || configs[0].CurveName != "P-256" || !configs[1].Fallback {
+		t.Fatalf("unexpected parsed configs: %+v", configs)
+	}
+
+	// Invalid configs.
+	for _, test := range []struct {
+		data   string
+		errMsg string
+	}{
+		{`[]`, "no zones"},
+		{`[{zone: a, type: pblind, curve: P-123, publicKey: abc, batchSize: 10}]`, "unsupported curve"},
+		{`[{zone: a, type: pblind, curve: P-256, publicKey: abc, batchSize: 0}]`, "batch size"},
+		{`[{zone: a, type: pblind, curve: P-256, batchSize: 10}]`, "public key"},
+		{`[{zone: a, type: pblind, curve: P-256, publicKey: abc, batchSize: 10}]`, "invalid public key"},
+		{`[{zone: a, type: pblind, curve: P-384, publicKey: eXoJXzXbM66UEsM2eVi9HwyBPLMfVnNrC7gNrsfMUJDs, batchSize: 10}]`, "invalid public key"},
+		{`[{zone: a, type: pblind, curve: P-256, publicKey: abc, batchSize: 10, minBatchSize: 20}]`, "min batch size"},
+		{`[{zone: a, type: pblind, curve: P-256, publicKey: abc, batchSize: 10, maxBatchSize: 5}]`, "max batch size"},
+		{`[{zone: a, type: scramble}]`, "missing verifiers"},
+		{`[{zone: a, type: unknown}]`, "unknown type"},
+		{`[{zone: a, type: scramble, verifiers: [x]}, {zone: a, type: scramble, verifiers: [y]}]`, "multiple times"},
+	} {
+		_, err := ParseZoneConfigs([]byte(test.data))
+		if !errors.Is(err, ErrInvalidZoneConfig) {
+			t.Errorf("expected invalid zone config error for %s, got %v", test.data, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.errMsg) {
+			t.Errorf("expected error for %s to contain %q, got %q", test.data, test.errMsg, err)
+		}
+	}
+}
+
+func TestBatchSizeForTier(t *testing.T) {
+	t.Parallel()
+
+	zc := &ZoneConfig{BatchSize: 100}
+	if size := zc.batchSizeForTier(account.TierBasic); size != 100 {
+		t.Errorf("expected batch size of 100 for basic tier, got %d", size)
+	}
+	if size := zc.batchSizeForTier(account.TierPlus); size != 200 {
+		t.Errorf("expected batch size of 200 for plus tier, got %d", size)
+	}
+	if size := zc.batchSizeForTier(99); size != 100 {
+		t.Errorf("expected default batch size for unknown tier, got %d", size)
+	}
+}
+
+func TestDiffZoneConfigs(t *testing.T) {
+	t.Parallel()
+
+	current := []*ZoneConfig{
+		{Zone: "kept", Type: ZoneTypeScramble, Verifiers: []string{"a"}},
+		{Zone: "changed", Type: ZoneTypeScramble, Verifiers: []string{"b"}},
+		{Zone: "removed", Type: ZoneTypeScramble, Verifiers: []string{"c"}},
+	}
+	configs := []*ZoneConfig{
+		{Zone: "kept", Type: ZoneTypeScramble, Verifiers: []string{"a"}},
+		{Zone: "changed", Type: ZoneTypeScramble, Verifiers: []string{"b"}, Fallback: true},
+		{Zone: "added", Type: ZoneTypeScramble, Verifiers: []string{"d"}},
+	}
+
+	report := diffZoneConfigs(current, configs)
+	switch {
+	case len(report.Kept) != 1 || report.Kept[0] != "kept":
+		t.Errorf("unexpected kept zones: %v", report.Kept)
+	case len(report.Changed) != 1 || report.Changed[0] != "changed":
+		t.Errorf("unexpected changed zones: %v", report.Changed)
+	case len(report.Added) != 1 || report.Added[0] != "added":
+		t.Errorf("unexpected added zones: %v", report.Added)
+	case len(report.Removed) != 1 || report.Removed[0] != "removed":
+		t.Errorf("unexpected removed zones: %v", report.Removed)
+	}
+}
+
+func TestApplyZoneConfigsFailure(t *testing.T) {
+	if !module.Online() {
+		t.Skip("module is not online")
+	}
+
+	current := getZoneConfigs()
+	configs := append([]*ZoneConfig{}, current...)
+	configs = append(configs,
+		&ZoneConfig{Zone: "test-apply-valid", Type: ZoneTypeScramble, Verifiers: []string{"ZwojEvXZmAv7SZdNe7m94Xzu7F9J8vULqKf7QYtoTpN2tH"}},
+		// Passes validation, but the verifier cannot be decoded when creating
+		// the handler.
+		&ZoneConfig{Zone: "test-apply-invalid", Type: ZoneTypeScramble, Verifiers: []string{"0OIl"}},
+	)
+
+	if _, err := ApplyZoneConfigs(configs); err == nil {
+		t.Fatal("applying zone configs with a failing handler should fail")
+	}
+
+	// The current zones must be kept as they are.
+	if !reflect.DeepEqual(getZoneConfigs(), current) {
+		t.Error("zone configs must not change if a handler fails")
+	}
+	for _, zc := range current {
+		if mayInitializeZone(zc.Zone) {
+			if _, ok := token.GetHandler(zc.Zone); !ok {
+				t.Errorf("handler of zone %s must be kept", zc.Zone)
+			}
+		}
+	}
+	if _, ok := token.GetHandler("test-apply-valid"); ok {
+		t.Error("handler of the valid new zone must not be registered")
+	}
+}
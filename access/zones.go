@@ -2,83 +2,220 @@ package access
 
 import (
 	"fmt"
+	"sync"
 
+	"github.com/safing/spn/access/account"
 	"github.com/safing/spn/conf"
 
-	"github.com/safing/jess/lhash"
 	"github.com/safing/portbase/log"
 	"github.com/safing/spn/access/token"
 	"github.com/safing/spn/terminal"
 )
 
+// The zone lists are derived from the zone configs and must only be accessed
+// via their getters, as they are replaced when the zone configs change.
 var (
-	ExpandAndConnectZones = []string{"pblind1", "alpha2", "fallback1"}
-	persistentZones       = ExpandAndConnectZones
+	expandAndConnectZones []string
+	persistentZones       []string
 
-	zonePermissions = map[string]terminal.Permission{
-		"pblind1":   terminal.AddPermissions(terminal.MayExpand, terminal.MayConnect),
-		"alpha2":    terminal.AddPermissions(terminal.MayExpand, terminal.MayConnect),
-		"fallback1": terminal.AddPermissions(terminal.MayExpand, terminal.MayConnect),
-	}
+	zonePermissions map[string]terminal.Permission
+
+	// zoneTiers defines the minimum account tier required to use a zone.
+	zoneTiers map[string]int
+
+	// clientTier holds the account tier the zones were initialized for on
+	// clients.
+	clientTier     = account.TierNone
+	clientTierLock sync.Mutex
 )
 
-func initializeZones() error {
-	// Special client zone config.
-	var requestSignalHandler func(token.Handler)
-	if conf.Client() {
-		requestSignalHandler = shouldRequestTokensHandler
-	}
-
-	// Register pblind1 as the first primary zone.
-	ph, err := token.NewPBlindHandler(token.PBlindOptions{
-		Zone:                "pblind1",
-		CurveName:           "P-256",
-		PublicKey:           "eXoJXzXbM66UEsM2eVi9HwyBPLMfVnNrC7gNrsfMUJDs",
-		UseSerials:          true,
-		BatchSize:           1000,
-		RandomizeOrder:      true,
-		SignalShouldRequest: requestSignalHandler,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create pblind1 token handler: %w", err)
+// ZoneInfo holds information about a zone for the UI.
+type ZoneInfo struct {
+	Zone         string
+	RequiredTier int
+	Permitted    bool
+	Fallback     bool
+	Tokens       int
+}
+
+// ZonesStatus holds the account tier and the resulting zone access.
+type ZonesStatus struct {
+	Tier  int
+	Zones []*ZoneInfo
+}
+
+// ListZones returns information about all zones, including whether the
+// current account tier permits using them.
+func ListZones() []*ZoneInfo {
+	tier := getClientTier()
+
+	zoneNames := GetExpandAndConnectZones()
+	zones := make([]*ZoneInfo, 0, len(zoneNames))
+	for _, zone := range zoneNames {
+		requiredTier, _ := getZoneTier(zone)
+		info := &ZoneInfo{
+			Zone:         zone,
+			RequiredTier: requiredTier,
+			Permitted:    checkZoneTier(zone, tier) == nil,
+		}
+		if handler, ok := token.GetHandler(zone); ok {
+			info.Fallback = handler.IsFallback()
+			info.Tokens = handler.Amount()
+		}
+		zones = append(zones, info)
 	}
-	err = token.RegisterPBlindHandler(ph)
-	if err != nil {
-		return fmt.Errorf("failed to register pblind1 token handler: %w", err)
+	return zones
+}
+
+// FallbackAvailable returns whether the given zone is a fallback zone that
+// has stored tokens. In contrast to GetToken, it does not check whether the
+// token issuer is online, so it can be used to tell whether the SPN can still
+// be used when the token issuer becomes unreachable.
+func FallbackAvailable(zone string) bool {
+	handler, ok := token.GetHandler(zone)
+	if !ok {
+		return false
 	}
+	return handler.IsFallback() && handler.Amount() > 0
+}
 
-	// Register fallback1 zone as fallback when the issuer is not available.
-	sh, err := token.NewScrambleHandler(token.ScrambleOptions{
-		Zone:             "fallback1",
-		Algorithm:        lhash.BLAKE2b_256,
-		InitialVerifiers: []string{"ZwkQoaAttVBMURzeLzNXokFBMAMUUwECfM1iHojcVKBmjk"},
-		Fallback:         true,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create fallback1 token handler: %w", err)
+// checkZoneTier returns an error if the given tier does not permit using the
+// zone.
+func checkZoneTier(zone string, tier int) error {
+	requiredTier, ok := getZoneTier(zone)
+	if !ok {
+		return token.ErrZoneUnknown
 	}
-	err = token.RegisterScrambleHandler(sh)
-	if err != nil {
-		return fmt.Errorf("failed to register fallback1 token handler: %w", err)
+	if tier < requiredTier {
+		return fmt.Errorf("%w: zone %s requires tier %d, account has tier %d", ErrZoneAboveTier, zone, requiredTier, tier)
 	}
+	return nil
+}
 
-	// Register alpha2 zone for transition phase.
-	sh, err = token.NewScrambleHandler(token.ScrambleOptions{
-		Zone:             "alpha2",
-		Algorithm:        lhash.BLAKE2b_256,
-		InitialVerifiers: []string{"ZwojEvXZmAv7SZdNe7m94Xzu7F9J8vULqKf7QYtoTpN2tH"},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create alpha2 token handler: %w", err)
+func getClientTier() int {
+	clientTierLock.Lock()
+	defer clientTierLock.Unlock()
+
+	return clientTier
+}
+
+// mayInitializeZone returns whether the given zone should be initialized.
+// Servers initialize all zones, as they must verify all tokens, while clients
+// only initialize the zones their account tier permits.
+func mayInitializeZone(zone string) bool {
+	return mayInitializeZoneForTier(zone, getClientTier())
+}
+
+func mayInitializeZoneForTier(zone string, tier int) bool {
+	if !conf.Client() {
+		return true
 	}
-	err = token.RegisterScrambleHandler(sh)
-	if err != nil {
-		return fmt.Errorf("failed to register alpha2 token handler: %w", err)
+	return checkZoneTier(zone, tier) == nil
+}
+
+// updateClientTier sets the account tier of the client and reconciles the
+// zones if it changed. The handlers of zones that are permitted by both tiers
+// are kept with their tokens. Handlers of newly permitted zones are created
+// and loaded before the tier is switched, and tokens of zones that are not
+// permitted anymore are stored for a later upgrade after it was switched, so
+// that zones permitted by the current tier are always available.
+func updateClientTier(tier int) error {
+	// Exclude refills and other changes of the zones while switching.
+	clientRequestLock.Lock()
+	defer clientRequestLock.Unlock()
+	applyZoneConfigsLock.Lock()
+	defer applyZoneConfigsLock.Unlock()
+
+	previousTier := getClientTier()
+	if previousTier == tier {
+		return nil
+	}
+
+	// Create the handlers of newly permitted zones before touching the running
+	// zones, so that a failure leaves the current zones as they are.
+	var (
+		configs              = getZoneConfigs()
+		requestSignalHandler = getRequestSignalHandler()
+		added                []token.Handler
+		removed              []string
+	)
+	for _, zc := range configs {
+		permitted := mayInitializeZoneForTier(zc.Zone, tier)
+		handler, registered := token.GetHandler(zc.Zone)
+		switch {
+		case permitted && registered:
+			// Request batch sizes according to the new account tier.
+			if ph, ok := handler.(*token.PBlindHandler); ok && conf.Client() {
+				ph.SetPreferredBatchSize(zc.batchSizeForTier(tier))
+			}
+		case permitted:
+			newHandler, err := zc.newZoneHandlerForTier(requestSignalHandler, tier)
+			if err != nil {
+				for _, h := range added {
+					closeZoneHandler(h)
+				}
+				return err
+			}
+			added = append(added, newHandler)
+		case registered:
+			removed = append(removed, zc.Zone)
+		}
+	}
+
+	recordEvent(EventTierChanged, "changed to tier %d", tier)
+	log.Infof("access: account tier changed to %d, updating zones", tier)
+
+	// Register newly permitted zones with their stored tokens.
+	store := getTokenStore()
+	for _, handler := range added {
+		if err := registerZoneHandler(handler); err != nil {
+			log.Errorf("access: failed to register %s token handler: %s", handler.Zone(), err)
+			closeZoneHandler(handler)
+			continue
+		}
+		loadZoneTokens(store, handler.Zone(), handler)
+	}
+
+	// Switch to the new tier.
+	clientTierLock.Lock()
+	clientTier = tier
+	clientTierLock.Unlock()
+
+	// Store tokens of zones that are not permitted anymore and remove them.
+	for _, zone := range removed {
+		if handler, ok := token.GetHandler(zone); ok {
+			storeZoneTokens(store, zone, handler)
+			token.UnregisterHandler(zone)
+		}
 	}
 
 	return nil
 }
 
+func initializeZones() error {
+	// Create and register handlers for all configured zones.
+	requestSignalHandler := getRequestSignalHandler()
+	for _, zc := range getZoneConfigs() {
+		if !mayInitializeZone(zc.Zone) {
+			continue
+		}
+		if err := zc.createZoneHandler(requestSignalHandler); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getRequestSignalHandler returns the handler that is called when a zone
+// should request new tokens. It is only set on clients.
+func getRequestSignalHandler() func(token.Handler) {
+	if conf.Client() {
+		return shouldRequestTokensHandler
+	}
+	return nil
+}
+
 func resetZones() {
 	token.ResetRegistry()
 }
@@ -97,6 +234,11 @@ func shouldRequestTokensHandler(_ token.Handler) {
 func GetTokenAmount(zones []string) (regular, fallback int) {
 handlerLoop:
 	for _, zone := range zones {
+		// Skip zones that are not permitted by the account tier.
+		if !mayInitializeZone(zone) {
+			continue handlerLoop
+		}
+
 		// Get handler and check if it should be used.
 		handler, ok := token.GetHandler(zone)
 		if !ok {
@@ -114,9 +256,19 @@ handlerLoop:
 	return
 }
 
+// GetToken returns a token from one of the given zones. The zone is chosen
+// by the configured TokenSpendingStrategy.
 func GetToken(zones []string) (t *token.Token, err error) {
+	tier := getClientTier()
+
 handlerSelection:
-	for _, zone := range zones {
+	for _, zone := range getTokenSpendingStrategy()(zones) {
+		// Check if the account tier permits using the zone.
+		if tierErr := checkZoneTier(zone, tier); tierErr != nil {
+			err = tierErr
+			continue handlerSelection
+		}
+
 		// Get handler and check if it should be used.
 		handler, ok := token.GetHandler(zone)
 		switch {
@@ -167,9 +319,10 @@ func VerifyToken(t *token.Token) (granted terminal.Permission, err error) {
 	if err != nil {
 		return 0, fmt.Errorf("failed to verify token: %w", err)
 	}
+	issueTokenReceipt(t)
 
 	// Return permission of zone.
-	granted, ok = zonePermissions[t.Zone]
+	granted, ok = getZonePermission(t.Zone)
 	if !ok {
 		return terminal.NoPermission, nil
 	}
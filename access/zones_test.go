@@ -0,0 +1,207 @@
+package access
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/safing/jess/lhash"
+
+	"github.com/safing/spn/access/account"
+	"github.com/safing/spn/access/token"
+	"github.com/safing/spn/conf"
+)
+
+func TestCheckZoneTier(t *testing.T) {
+	t.Parallel()
+
+	for _, zone := range GetExpandAndConnectZones() {
+		if err := checkZoneTier(zone, account.TierNone); !errors.Is(err, ErrZoneAboveTier) {
+			t.Errorf("zone %s should not be permitted without a tier, got %v", zone, err)
+		}
+		if err := checkZoneTier(zone, account.TierBasic); err != nil {
+			t.Errorf("zone %s should be permitted for basic tier: %s", zone, err)
+		}
+		if err := checkZoneTier(zone, account.TierPlus); err != nil {
+			t.Errorf("zone %s should be permitted for plus tier: %s", zone, err)
+		}
+	}
+
+	if err := checkZoneTier("unknown", account.TierPlus); err == nil {
+		t.Error("unknown zone should not be permitted")
+	}
+}
+
+func TestFallbackAvailable(t *testing.T) {
+	t.Parallel()
+
+	// Register a fallback and a regular zone with tokens.
+	for _, fallback := range []bool{true, false} {
+		zone := "test-fallback-available"
+		if !fallback {
+			zone = "test-regular-available"
+		}
+		h, err := token.NewScrambleHandler(token.ScrambleOptions{
+			Zone:          zone,
+			Algorithm:     lhash.SHA2_256,
+			InitialTokens: []string{"2VqJ8BvDew1tUpytZhR7tuvq7ToPpW3tQtHvu3veE3iW"},
+			Fallback:      fallback,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := token.RegisterScrambleHandler(h); err != nil {
+			t.Fatal(err)
+		}
+		defer token.UnregisterHandler(zone)
+	}
+
+	if !FallbackAvailable("test-fallback-available") {
+		t.Error("fallback zone with tokens should be available")
+	}
+	if FallbackAvailable("test-regular-available") {
+		t.Error("regular zone should not be reported as fallback")
+	}
+	if FallbackAvailable("unknown") {
+		t.Error("unknown zone should not be available")
+	}
+
+	// Use up the fallback token.
+	if _, err := token.GetToken("test-fallback-available"); err != nil {
+		t.Fatal(err)
+	}
+	if FallbackAvailable("test-fallback-available") {
+		t.Error("fallback zone without tokens should not be available")
+	}
+}
+
+func TestSpendFromLargestSupply(t *testing.T) {
+	t.Parallel()
+
+	// Register an empty zone and a zone with tokens.
+	for _, zone := range []string{"test-spending-empty", "test-spending-full"} {
+		opts := token.ScrambleOptions{
+			Zone:      zone,
+			Algorithm: lhash.SHA2_256,
+		}
+		if zone == "test-spending-full" {
+			opts.InitialTokens = []string{"2VqJ8BvDew1tUpytZhR7tuvq7ToPpW3tQtHvu3veE3iW"}
+		}
+		h, err := token.NewScrambleHandler(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := token.RegisterScrambleHandler(h); err != nil {
+			t.Fatal(err)
+		}
+		defer token.UnregisterHandler(zone)
+	}
+
+	zones := []string{"test-spending-empty", "test-spending-full"}
+	if ordered := SpendInGivenOrder(zones); ordered[0] != "test-spending-empty" {
+		t.Errorf("given order should be kept, got %v", ordered)
+	}
+	ordered := SpendFromLargestSupply(zones)
+	if ordered[0] != "test-spending-full" || ordered[1] != "test-spending-empty" {
+		t.Errorf("zone with the largest supply should be first, got %v", ordered)
+	}
+	if zones[0] != "test-spending-empty" {
+		t.Error("given zones must not be modified")
+	}
+}
+
+func TestUpdateClientTier(t *testing.T) {
+	if !module.Online() {
+		t.Skip("module is not online")
+	}
+
+	// Act as a client and add a zone that requires the plus tier.
+	defer conf.EnableClient(conf.Client())
+	conf.EnableClient(true)
+	current := getZoneConfigs()
+	configs := append([]*ZoneConfig{}, current...)
+	configs = append(configs, &ZoneConfig{
+		Zone:         "test-tier-plus",
+		Type:         ZoneTypeScramble,
+		Verifiers:    []string{"ZwojEvXZmAv7SZdNe7m94Xzu7F9J8vULqKf7QYtoTpN2tH"},
+		RequiredTier: account.TierPlus,
+	})
+	if err := updateClientTier(account.TierBasic); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ApplyZoneConfigs(configs); err != nil {
+		t.Fatal(err)
+	}
+	defer func(tier int) {
+		_ = updateClientTier(account.TierBasic)
+		_, _ = ApplyZoneConfigs(current)
+		clientTierLock.Lock()
+		clientTier = tier
+		clientTierLock.Unlock()
+	}(getClientTier())
+
+	// Zones permitted by both tiers must stay available while switching.
+	var basicZones []string
+	for _, zc := range current {
+		if checkZoneTier(zc.Zone, account.TierBasic) == nil {
+			basicZones = append(basicZones, zc.Zone)
+		}
+	}
+	stop := make(chan struct{})
+	missing := make(chan string, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, zone := range basicZones {
+				if _, ok := token.GetHandler(zone); !ok {
+					select {
+					case missing <- zone:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	// Switch concurrently, the plus zone must only be registered once.
+	for i := 0; i < 10; i++ {
+		tier := account.TierPlus
+		if i%2 == 1 {
+			tier = account.TierBasic
+		}
+		var switchWg sync.WaitGroup
+		for j := 0; j < 2; j++ {
+			switchWg.Add(1)
+			go func() {
+				defer switchWg.Done()
+				if err := updateClientTier(tier); err != nil {
+					t.Errorf("failed to switch to tier %d: %s", tier, err)
+				}
+			}()
+		}
+		switchWg.Wait()
+
+		_, registered := token.GetHandler("test-tier-plus")
+		if registered != (tier == account.TierPlus) {
+			t.Fatalf("plus zone registered=%v on tier %d", registered, tier)
+		}
+		if getClientTier() != tier {
+			t.Fatalf("expected tier %d, got %d", tier, getClientTier())
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	select {
+	case zone := <-missing:
+		t.Fatalf("zone %s was not available while switching tiers", zone)
+	default:
+	}
+}
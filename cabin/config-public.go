@@ -84,6 +84,27 @@ var (
 	publicCfgOptionExit        config.StringArrayOption
 	publicCfgOptionExitDefault = []string{"- * TCP/25"}
 	publicCfgOptionExitOrder   = 522
+
+	// Key Rotation
+	publicCfgOptionKeyRotationKey     = "spn/publicHub/keyRotationPeriod"
+	publicCfgOptionKeyRotation        config.IntOption
+	publicCfgOptionKeyRotationDefault = 0
+	publicCfgOptionKeyRotationOrder   = 523
+
+	// Announcement and Status Size Limits
+	// The lane limit is disabled by default, because lanes that are not
+	// reported are not used for routing by other Hubs. Limiting them reduces
+	// the connectivity of the network, which should only be traded for a
+	// smaller status on Hubs with exceptionally many lanes.
+	publicCfgOptionMaxReportedLanesKey     = "spn/publicHub/maxReportedLanes"
+	publicCfgOptionMaxReportedLanes        config.IntOption
+	publicCfgOptionMaxReportedLanesDefault = 0
+	publicCfgOptionMaxReportedLanesOrder   = 524
+
+	publicCfgOptionMaxReportedTransportsKey     = "spn/publicHub/maxReportedTransports"
+	publicCfgOptionMaxReportedTransports        config.IntOption
+	publicCfgOptionMaxReportedTransportsDefault = 10
+	publicCfgOptionMaxReportedTransportsOrder   = 525
 )
 
 func prepPublicHubConfig() error {
@@ -267,6 +288,54 @@ func prepPublicHubConfig() error {
 	}
 	publicCfgOptionExit = config.GetAsStringArray(publicCfgOptionExitKey, publicCfgOptionExitDefault)
 
+	err = config.Register(&config.Option{
+		Name:           "Key Rotation Period",
+		Key:            publicCfgOptionKeyRotationKey,
+		Description:    "Amount of hours after which the exchange keys of the Hub are rotated, in addition to their regular renewal. Previous keys stay valid until they expire, so that connections that are being established are not interrupted. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   publicCfgOptionKeyRotationDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: publicCfgOptionKeyRotationOrder,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	publicCfgOptionKeyRotation = config.GetAsInt(publicCfgOptionKeyRotationKey, publicCfgOptionKeyRotationDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Max Reported Lanes",
+		Key:            publicCfgOptionMaxReportedLanesKey,
+		Description:    "Maximum amount of lanes that are published in the status of the Hub. If the Hub has more lanes, only the lanes with the highest capacity are published. This limits the size of the status, which is gossiped through the whole network. Lanes that are not published disappear from the map of other Hubs and are not used for routing, which is why this is disabled by default. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   publicCfgOptionMaxReportedLanesDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: publicCfgOptionMaxReportedLanesOrder,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	publicCfgOptionMaxReportedLanes = config.GetAsInt(publicCfgOptionMaxReportedLanesKey, publicCfgOptionMaxReportedLanesDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Max Reported Transports",
+		Key:            publicCfgOptionMaxReportedTransportsKey,
+		Description:    "Maximum amount of transports that are published in the announcement of the Hub. If more transports are configured, only the first ones are published. All configured transports are still listened on. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   publicCfgOptionMaxReportedTransportsDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: publicCfgOptionMaxReportedTransportsOrder,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	publicCfgOptionMaxReportedTransports = config.GetAsInt(publicCfgOptionMaxReportedTransportsKey, publicCfgOptionMaxReportedTransportsDefault)
+
 	// update defaults from system
 	setDynamicPublicDefaults()
 
@@ -285,6 +354,7 @@ func getPublicHubInfo() *hub.Announcement {
 		Transports:     publicCfgOptionTransports(),
 		Entry:          publicCfgOptionEntry(),
 		Exit:           publicCfgOptionExit(),
+		Capabilities:   hub.LocalCapabilities(),
 	}
 
 	ip4 := publicCfgOptionIPv4()
@@ -326,16 +396,30 @@ func setDynamicPublicDefaults() {
 		log.Warningf("spn/cabin: failed to get assigned addresses: %s", err)
 		return
 	}
+	var ip4, ip6 net.IP
 	if len(v4IPs) == 1 {
-		err = config.SetDefaultConfigOption(publicCfgOptionIPv4Key, v4IPs[0].String())
+		ip4 = v4IPs[0]
+	}
+	if len(v6IPs) == 1 {
+		ip6 = v6IPs[0]
+	}
+	SetDetectedIPs(ip4, ip6)
+}
+
+// SetDetectedIPs sets the given IPs as the defaults of the public IP config
+// options. IPs that are nil are ignored. IPs configured by the user take
+// precedence over the defaults.
+func SetDetectedIPs(ip4, ip6 net.IP) {
+	if ip4 != nil {
+		err := config.SetDefaultConfigOption(publicCfgOptionIPv4Key, ip4.String())
 		if err != nil {
-			log.Warningf("spn/cabin: failed to set %s default to %s", publicCfgOptionIPv4Key, v4IPs[0].String())
+			log.Warningf("spn/cabin: failed to set %s default to %s", publicCfgOptionIPv4Key, ip4)
 		}
 	}
-	if len(v6IPs) == 1 {
-		err = config.SetDefaultConfigOption(publicCfgOptionIPv6Key, v6IPs[0].String())
+	if ip6 != nil {
+		err := config.SetDefaultConfigOption(publicCfgOptionIPv6Key, ip6.String())
 		if err != nil {
-			log.Warningf("spn/cabin: failed to set %s default to %s", publicCfgOptionIPv6Key, v6IPs[0].String())
+			log.Warningf("spn/cabin: failed to set %s default to %s", publicCfgOptionIPv6Key, ip6)
 		}
 	}
 }
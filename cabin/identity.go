@@ -36,9 +36,16 @@ type Identity struct {
 	Signet *jess.Signet
 
 	ExchKeys map[string]*ExchKey
+	// rotateExchKeys signifies that the exchange keys should be rotated with
+	// the next maintenance.
+	rotateExchKeys bool
 
 	infoExportCache   []byte
 	statusExportCache []byte
+
+	// configuredTransports holds all configured transports. The announcement
+	// may only hold the first ones in order to stay within the size limit.
+	configuredTransports []string
 }
 
 // Lock locks the Identity through the Hub lock.
@@ -103,8 +110,18 @@ func (id *Identity) MaintainAnnouncement(selfcheck bool) (changed bool, err erro
 	if id.Hub.Info != nil {
 		newInfo.Timestamp = id.Hub.Info.Timestamp
 	}
+
+	// Limit transports to keep the announcement small. All configured
+	// transports are still listened on.
+	id.configuredTransports = newInfo.Transports
+	var droppedTransports int
+	newInfo.Transports, droppedTransports = limitTransports(newInfo.Transports, getMaxReportedTransports())
+
 	if !newInfo.Equal(id.Hub.Info) {
 		changed = true
+		if droppedTransports > 0 {
+			log.Warningf("spn/cabin: not announcing %d transports in order to stay within the configured limit", droppedTransports)
+		}
 	}
 
 	if changed {
@@ -136,6 +153,18 @@ func (id *Identity) MaintainAnnouncement(selfcheck bool) (changed bool, err erro
 	return changed, nil
 }
 
+// ConfiguredTransports returns all configured transports of the Hub. In
+// contrast to the transports in the announcement, they are not limited.
+func (id *Identity) ConfiguredTransports() []string {
+	id.Lock()
+	defer id.Unlock()
+
+	if id.configuredTransports == nil && id.Hub.Info != nil {
+		return id.Hub.Info.Transports
+	}
+	return id.configuredTransports
+}
+
 // MaintainStatus maintains the Hub's Status and returns whether there was a change that should be communicated to other Hubs.
 func (id *Identity) MaintainStatus(lanes []*hub.Lane, load *int, selfcheck bool) (changed bool, err error) {
 	id.Lock()
@@ -168,9 +197,18 @@ func (id *Identity) MaintainStatus(lanes []*hub.Lane, load *int, selfcheck bool)
 	}
 
 	// Update lanes.
-	if lanes != nil && !hub.LanesEqual(newStatus.Lanes, lanes) {
-		newStatus.Lanes = lanes
-		changed = true
+	if lanes != nil {
+		// Limit lanes to keep the status small.
+		var droppedLanes int
+		lanes, droppedLanes = limitLanes(lanes, newStatus.Lanes, getMaxReportedLanes())
+
+		if !hub.LanesEqual(newStatus.Lanes, lanes) {
+			newStatus.Lanes = lanes
+			changed = true
+			if droppedLanes > 0 {
+				log.Infof("spn/cabin: not reporting %d lanes with lowest capacity in order to stay within the configured limit, other Hubs will not use them", droppedLanes)
+			}
+		}
 	}
 
 	// Update load.
@@ -52,12 +52,41 @@ func initProvidedExchKeySchemes() error {
 	return nil
 }
 
+// getKeyRotationPeriod returns the configured period after which exchange
+// keys are rotated, or zero if scheduled rotation is disabled.
+func getKeyRotationPeriod() time.Duration {
+	if publicCfgOptionKeyRotation == nil {
+		return 0
+	}
+	hours := publicCfgOptionKeyRotation()
+	if hours <= 0 {
+		return 0
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// RequestExchKeyRotation requests the exchange keys to be rotated with the
+// next status maintenance.
+func (id *Identity) RequestExchKeyRotation() {
+	id.Lock()
+	defer id.Unlock()
+
+	id.rotateExchKeys = true
+}
+
 func (id *Identity) MaintainExchKeys(newStatus *hub.Status, now time.Time) (changed bool, err error) {
 	// create Keys map
 	if id.ExchKeys == nil {
 		id.ExchKeys = make(map[string]*ExchKey)
 	}
 
+	// Check if keys should be rotated.
+	// Rotated keys are not burnt before they expire, so that cranes that are
+	// being established with them can still be set up.
+	rotationPeriod := getKeyRotationPeriod()
+	forceRotation := id.rotateExchKeys
+	id.rotateExchKeys = false
+
 	// lifecycle management
 	for keyID, exchKey := range id.ExchKeys {
 		if exchKey.key != nil && now.After(exchKey.Expires.Add(burnAfter)) {
@@ -85,9 +114,11 @@ func (id *Identity) MaintainExchKeys(newStatus *hub.Status, now time.Time) (chan
 	for _, eks := range provideExchKeySchemes {
 		found := false
 		for _, exchKey := range id.ExchKeys {
-			if exchKey.key != nil &&
+			if !forceRotation &&
+				exchKey.key != nil &&
 				exchKey.key.Scheme == eks.id &&
-				now.Before(exchKey.Expires.Add(-renewBeforeExpiry)) {
+				now.Before(exchKey.Expires.Add(-renewBeforeExpiry)) &&
+				(rotationPeriod == 0 || now.Before(exchKey.Created.Add(rotationPeriod))) {
 				found = true
 				break
 			}
@@ -40,3 +40,55 @@ func TestKeyMaintenance(t *testing.T) {
 		t.Fatal("more keys than expected")
 	}
 }
+
+func TestKeyRotation(t *testing.T) {
+	id, err := CreateIdentity(context.Background(), conf.MainMapName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getExportedKeyID := func() string {
+		for keyID := range id.Hub.Status.Keys {
+			return keyID
+		}
+		return ""
+	}
+	oldKeyID := getExportedKeyID()
+
+	// Manual rotation.
+	now := time.Now()
+	id.RequestExchKeyRotation()
+	changed, err := id.MaintainExchKeys(id.Hub.Status, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKeyID := getExportedKeyID()
+	if !changed || newKeyID == oldKeyID {
+		t.Fatal("keys should have been rotated")
+	}
+
+	// The previous key must still be usable until it expires.
+	if _, err := id.GetSignet(oldKeyID, false); err != nil {
+		t.Fatalf("previous key should still be valid: %s", err)
+	}
+
+	// Scheduled rotation.
+	defer func() {
+		publicCfgOptionKeyRotation = nil
+	}()
+	publicCfgOptionKeyRotation = func() int64 { return 6 }
+	changed, err = id.MaintainExchKeys(id.Hub.Status, now.Add(5*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Fatal("keys should not be rotated before the rotation period")
+	}
+	changed, err = id.MaintainExchKeys(id.Hub.Status, now.Add(7*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || getExportedKeyID() == newKeyID {
+		t.Fatal("keys should have been rotated after the rotation period")
+	}
+}
@@ -0,0 +1,87 @@
+package cabin
+
+import (
+	"sort"
+
+	"github.com/safing/spn/hub"
+)
+
+// getMaxReportedLanes returns the maximum amount of lanes to publish in the
+// status, or zero if unlimited, which is the default.
+func getMaxReportedLanes() int {
+	if publicCfgOptionMaxReportedLanes == nil {
+		return 0
+	}
+	return int(publicCfgOptionMaxReportedLanes())
+}
+
+// getMaxReportedTransports returns the maximum amount of transports to
+// publish in the announcement, or zero if unlimited.
+func getMaxReportedTransports() int {
+	if publicCfgOptionMaxReportedTransports == nil {
+		return 0
+	}
+	return int(publicCfgOptionMaxReportedTransports())
+}
+
+// reportedLaneHysteresis is the capacity bonus in percent that lanes which
+// are already reported get when limiting lanes. This prevents lanes with a
+// similar capacity from taking turns in the status, which would cause the
+// status to be gossiped on every small capacity change.
+const reportedLaneHysteresis = 25
+
+// limitLanes returns the given lanes limited to the given maximum. The lanes
+// with the highest capacity are kept, followed by the lowest latency and the
+// Hub ID, so that the result is stable across updates. Lanes that are already
+// reported are preferred, unless another lane has a considerably higher
+// capacity. The returned lanes are sorted by Hub ID. The given slices are not
+// modified.
+// Dropped lanes are not known to other Hubs and disappear from their map, so
+// they are not used for routing anymore.
+func limitLanes(lanes, reported []*hub.Lane, max int) (limited []*hub.Lane, dropped int) {
+	if max <= 0 || len(lanes) <= max {
+		return lanes, 0
+	}
+
+	// Calculate the capacity used for sorting.
+	wasReported := make(map[string]struct{}, len(reported))
+	for _, lane := range reported {
+		wasReported[lane.ID] = struct{}{}
+	}
+	capacity := func(lane *hub.Lane) int {
+		if _, ok := wasReported[lane.ID]; ok {
+			return lane.Capacity + lane.Capacity*reportedLaneHysteresis/100
+		}
+		return lane.Capacity
+	}
+
+	// Sort a copy by importance.
+	limited = make([]*hub.Lane, len(lanes))
+	copy(limited, lanes)
+	sort.Slice(limited, func(i, j int) bool {
+		a, b := limited[i], limited[j]
+		aCapacity, bCapacity := capacity(a), capacity(b)
+		switch {
+		case aCapacity != bCapacity:
+			return aCapacity > bCapacity
+		case a.Latency != b.Latency:
+			return a.Latency < b.Latency
+		default:
+			return a.ID < b.ID
+		}
+	})
+
+	// Keep the most important lanes and restore the order for comparing.
+	limited = limited[:max]
+	hub.SortLanes(limited)
+	return limited, len(lanes) - max
+}
+
+// limitTransports returns the given transports limited to the given maximum.
+// Transports are ordered by preference, so the first ones are kept.
+func limitTransports(transports []string, max int) (limited []string, dropped int) {
+	if max <= 0 || len(transports) <= max {
+		return transports, 0
+	}
+	return transports[:max], len(transports) - max
+}
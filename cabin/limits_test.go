@@ -0,0 +1,92 @@
+package cabin
+
+import (
+	"testing"
+
+	"github.com/safing/spn/hub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitLanes(t *testing.T) {
+	t.Parallel()
+
+	lanes := []*hub.Lane{
+		{ID: "A", Capacity: 1, Latency: 1},
+		{ID: "B", Capacity: 5, Latency: 3},
+		{ID: "C", Capacity: 3, Latency: 1},
+		{ID: "D", Capacity: 5, Latency: 2},
+		{ID: "E", Capacity: 3, Latency: 1},
+	}
+
+	// No limit.
+	limited, dropped := limitLanes(lanes, nil, 0)
+	assert.Equal(t, lanes, limited)
+	assert.Equal(t, 0, dropped)
+
+	// Limit above lane count.
+	limited, dropped = limitLanes(lanes, nil, 10)
+	assert.Equal(t, lanes, limited)
+	assert.Equal(t, 0, dropped)
+
+	// Highest capacity is kept, then lowest latency, then ID.
+	limited, dropped = limitLanes(lanes, nil, 3)
+	assert.Equal(t, 2, dropped)
+	ids := make([]string, 0, len(limited))
+	for _, lane := range limited {
+		ids = append(ids, lane.ID)
+	}
+	assert.Equal(t, []string{"B", "C", "D"}, ids, "lanes should be selected by capacity and sorted by ID")
+
+	// Input must not be modified.
+	assert.Equal(t, "A", lanes[0].ID)
+
+	// Result must be stable regardless of input order.
+	reversed := make([]*hub.Lane, len(lanes))
+	for i, lane := range lanes {
+		reversed[len(lanes)-1-i] = lane
+	}
+	limitedReversed, _ := limitLanes(reversed, nil, 3)
+	assert.True(t, hub.LanesEqual(limited, limitedReversed), "limiting should be deterministic")
+}
+
+func TestLimitLanesHysteresis(t *testing.T) {
+	t.Parallel()
+
+	reported := []*hub.Lane{
+		{ID: "A", Capacity: 100},
+		{ID: "B", Capacity: 100},
+	}
+
+	// A slightly better lane does not replace a reported lane.
+	lanes := []*hub.Lane{
+		{ID: "A", Capacity: 100},
+		{ID: "B", Capacity: 100},
+		{ID: "C", Capacity: 110},
+	}
+	limited, dropped := limitLanes(lanes, reported, 2)
+	assert.Equal(t, 1, dropped)
+	assert.True(t, hub.LanesEqual(reported, limited), "reported lanes should be kept")
+
+	// A considerably better lane replaces a reported lane.
+	lanes[2].Capacity = 200
+	limited, _ = limitLanes(lanes, reported, 2)
+	ids := make([]string, 0, len(limited))
+	for _, lane := range limited {
+		ids = append(ids, lane.ID)
+	}
+	assert.Equal(t, []string{"A", "C"}, ids)
+}
+
+func TestLimitTransports(t *testing.T) {
+	t.Parallel()
+
+	transports := []string{"tcp:17", "http:80", "tcp:443"}
+
+	limited, dropped := limitTransports(transports, 0)
+	assert.Equal(t, transports, limited)
+	assert.Equal(t, 0, dropped)
+
+	limited, dropped = limitTransports(transports, 2)
+	assert.Equal(t, []string{"tcp:17", "http:80"}, limited)
+	assert.Equal(t, 1, dropped)
+}
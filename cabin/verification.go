@@ -33,10 +33,21 @@ type Verification struct {
 }
 
 func CreateVerificationRequest(purpose, clientReference, serverReference string) (v *Verification, request []byte, err error) {
-	// Generate random challenge.
-	challenge, err := rng.Bytes(verificationChallengeSize)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate challenge: %w", err)
+	return CreateVerificationRequestWithChallenge(nil, purpose, clientReference, serverReference)
+}
+
+// CreateVerificationRequestWithChallenge is like CreateVerificationRequest,
+// but uses the given challenge. If the challenge is empty, a random challenge
+// is generated.
+func CreateVerificationRequestWithChallenge(challenge []byte, purpose, clientReference, serverReference string) (v *Verification, request []byte, err error) {
+	// Generate random challenge, if none is given.
+	if len(challenge) == 0 {
+		challenge, err = rng.Bytes(verificationChallengeSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate challenge: %w", err)
+		}
+	} else if len(challenge) < verificationChallengeMinSize {
+		return nil, nil, errors.New("challenge too small")
 	}
 
 	// Create verification object.
@@ -1,12 +1,14 @@
 package captain
 
 import (
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 
+	"github.com/safing/jess"
 	"github.com/safing/portbase/formats/dsd"
 	"github.com/safing/portbase/log"
 	"github.com/safing/spn/conf"
@@ -27,16 +29,41 @@ var (
 	bootstrapFileFlag string
 )
 
+const (
+	bootstrapFileKeyID     = "bootstrap-file"
+	bootstrapFileMinKeyLen = 16
+
+	// Environment variables that may be used instead of the bootstrap flags.
+	// Flags take precedence.
+	bootstrapHubEnvVar  = "SPN_BOOTSTRAP_HUB"
+	bootstrapFileEnvVar = "SPN_BOOTSTRAP_FILE"
+)
+
 func init() {
 	flag.StringVar(&bootstrapHubFlag, "bootstrap-hub", "", "transport address of hub for bootstrapping with the hub ID in the fragment")
 	flag.StringVar(&bootstrapFileFlag, "bootstrap-file", "", "bootstrap file containing bootstrap hubs - will be initialized if running a public hub and it doesn't exist")
 }
 
-// prepBootstrapHubFlag checks the bootstrap-hub argument if it is valid.
+// prepBootstrapHubFlag applies the bootstrap environment variables for
+// arguments that were not given and checks the bootstrap-hub argument if it is
+// valid.
 func prepBootstrapHubFlag() error {
+	bootstrapHubSource := "bootstrap-hub argument"
+	if bootstrapHubFlag == "" {
+		if value := os.Getenv(bootstrapHubEnvVar); value != "" {
+			bootstrapHubFlag = value
+			bootstrapHubSource = bootstrapHubEnvVar + " environment variable"
+		}
+	}
+	if bootstrapFileFlag == "" {
+		bootstrapFileFlag = os.Getenv(bootstrapFileEnvVar)
+	}
+
 	if bootstrapHubFlag != "" {
 		_, err := hub.ParseBootstrapHub(bootstrapHubFlag, conf.MainMapName)
-		return err
+		if err != nil {
+			return fmt.Errorf("invalid bootstrap hub in %s: %w", bootstrapHubSource, err)
+		}
 	}
 	return nil
 }
@@ -83,6 +110,10 @@ func loadBootstrapFile(filename string) (err error) {
 	if err != nil {
 		return fmt.Errorf("failed to load bootstrap file: %w", err)
 	}
+	data, err = decryptBootstrapFile(data)
+	if err != nil {
+		return err
+	}
 	bootstrapFile := &BootstrapFile{}
 	_, err = dsd.Load(data, bootstrapFile)
 	if err != nil {
@@ -111,8 +142,12 @@ func createBootstrapFile(filename string) error {
 	if len(publicIdentity.Hub.Info.Transports) == 0 {
 		return errors.New("public identity has no transports available")
 	}
-	// parse first transport
-	t, err := hub.ParseTransport(publicIdentity.Hub.Info.Transports[0])
+	// parse first transport, prefer transports that are currently listening
+	transport := publicIdentity.Hub.Info.Transports[0]
+	if liveTransports := getLiveTransports(); len(liveTransports) > 0 {
+		transport = liveTransports[0]
+	}
+	t, err := hub.ParseTransport(transport)
 	if err != nil {
 		return fmt.Errorf("failed to parse transport of public identity: %w", err)
 	}
@@ -139,6 +174,12 @@ func createBootstrapFile(filename string) error {
 		return err
 	}
 
+	// encrypt, if a key is configured
+	fileData, err = encryptBootstrapFile(fileData)
+	if err != nil {
+		return err
+	}
+
 	// save to disk
 	err = ioutil.WriteFile(filename, fileData, 0664)
 	if err != nil {
@@ -148,3 +189,79 @@ func createBootstrapFile(filename string) error {
 	log.Infof("spn/captain: created bootstrap file %s", filename)
 	return nil
 }
+
+// getBootstrapFileEnvelope returns the envelope for encrypting and decrypting
+// bootstrap files. If no key is configured, it returns nil.
+func getBootstrapFileEnvelope() (*jess.Envelope, error) {
+	// Check if a key is configured.
+	if cfgOptionBootstrapFileKey == nil || cfgOptionBootstrapFileKey() == "" {
+		return nil, nil
+	}
+
+	// Parse key.
+	key, err := hex.DecodeString(cfgOptionBootstrapFileKey())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bootstrap file key: %w", err)
+	}
+	if len(key) < bootstrapFileMinKeyLen {
+		return nil, fmt.Errorf("bootstrap file key must have at least %d bytes", bootstrapFileMinKeyLen)
+	}
+
+	// Create envelope.
+	env := jess.NewUnconfiguredEnvelope()
+	env.SuiteID = jess.SuiteKeyV1
+	env.Secrets = []*jess.Signet{{
+		ID:     bootstrapFileKeyID,
+		Scheme: jess.SignetSchemeKey,
+		Key:    key,
+	}}
+	return env, nil
+}
+
+// encryptBootstrapFile encrypts the given bootstrap file data, if a key is
+// configured. Otherwise, the data is returned unchanged.
+func encryptBootstrapFile(data []byte) ([]byte, error) {
+	env, err := getBootstrapFileEnvelope()
+	if err != nil || env == nil {
+		return data, err
+	}
+
+	session, err := env.Correspondence(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initiate bootstrap file encryption: %w", err)
+	}
+	letter, err := session.Close(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt bootstrap file: %w", err)
+	}
+	return letter.ToDSD(dsd.JSON)
+}
+
+// decryptBootstrapFile decrypts the given bootstrap file data, if it is
+// encrypted. Otherwise, the data is returned unchanged.
+func decryptBootstrapFile(data []byte) ([]byte, error) {
+	// Check if the bootstrap file is encrypted.
+	// Plaintext bootstrap files will parse without a suite.
+	letter, err := jess.LetterFromDSD(data)
+	if err != nil || letter.SuiteID == "" {
+		return data, nil
+	}
+
+	env, err := getBootstrapFileEnvelope()
+	switch {
+	case err != nil:
+		return nil, err
+	case env == nil:
+		return nil, errors.New("bootstrap file is encrypted, but no bootstrap file key is configured")
+	}
+
+	session, err := env.Correspondence(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initiate bootstrap file decryption: %w", err)
+	}
+	data, err = session.Open(letter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt bootstrap file: %w", err)
+	}
+	return data, nil
+}
@@ -140,7 +140,7 @@ func preFlightCheck(ctx context.Context) error {
 
 		// There was an error updating the account.
 		// Check if we have enough tokens to continue anyway.
-		regular, fallback := access.GetTokenAmount(access.ExpandAndConnectZones)
+		regular, fallback := access.GetTokenAmount(access.GetExpandAndConnectZones())
 		if regular == 0 && fallback == 0 {
 			notifications.NotifyError(
 				"spn:tokens-exhausted",
@@ -1,6 +1,24 @@
 package captain
 
-import "github.com/safing/portbase/config"
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/access"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/navigator"
+	"github.com/safing/spn/ships"
+	"github.com/safing/spn/terminal"
+)
 
 var (
 	CfgOptionEnableSPNKey   = "spn/enable"
@@ -11,8 +29,133 @@ var (
 	cfgOptionSpecialAccessCodeDefault = "none"
 	cfgOptionSpecialAccessCode        config.StringOption
 	cfgOptionSpecialAccessCodeOrder   = 144
+
+	// Bootstrap File Key
+	cfgOptionBootstrapFileKeyKey     = "spn/bootstrapFileKey"
+	cfgOptionBootstrapFileKeyDefault = ""
+	cfgOptionBootstrapFileKey        config.StringOption
+	cfgOptionBootstrapFileKeyOrder   = 145
+
+	// Clock Skew Warning Threshold
+	cfgOptionClockSkewThresholdKey     = "spn/clockSkewWarningThreshold"
+	cfgOptionClockSkewThresholdDefault = 300
+	cfgOptionClockSkewThresholdOrder   = 147
+
+	// IP Detection
+	cfgOptionIPDetectionKey     = "spn/publicHub/ipDetection"
+	cfgOptionIPDetectionDefault = IPDetectionAssigned
+	cfgOptionIPDetection        config.StringOption
+	cfgOptionIPDetectionOrder   = 149
+
+	// Crane Pre-Warming
+	cfgOptionPrewarmCranesKey     = "spn/prewarmCranes"
+	cfgOptionPrewarmCranesDefault = 3
+	cfgOptionPrewarmCranes        config.IntOption
+	cfgOptionPrewarmCranesOrder   = 150
+
+	cfgOptionPrewarmWindowKey     = "spn/prewarmHistoryWindow"
+	cfgOptionPrewarmWindowDefault = 60
+	cfgOptionPrewarmWindow        config.IntOption
+	cfgOptionPrewarmWindowOrder   = 151
+
+	// Blocked Hubs
+	cfgOptionBlockedHubsKey     = "spn/blockedHubs"
+	cfgOptionBlockedHubs        config.StringArrayOption
+	cfgOptionBlockedHubsDefault = []string{}
+	cfgOptionBlockedHubsOrder   = 152
+
+	// Trusted Link Networks
+	cfgOptionTrustedLinkNetworksKey     = "spn/publicHub/trustedLinkNetworks"
+	cfgOptionTrustedLinkNetworks        config.StringArrayOption
+	cfgOptionTrustedLinkNetworksDefault = []string{}
+	cfgOptionTrustedLinkNetworksOrder   = 154
+
+	// Token Receipts
+	cfgOptionTokenReceiptsFileKey     = "spn/publicHub/tokenReceiptsFile"
+	cfgOptionTokenReceiptsFile        config.StringOption
+	cfgOptionTokenReceiptsFileDefault = ""
+	cfgOptionTokenReceiptsFileOrder   = 157
+
+	// Reachability Check
+	cfgOptionReachabilityCheckKey     = "spn/publicHub/reachabilityCheck"
+	cfgOptionReachabilityCheck        config.StringOption
+	cfgOptionReachabilityCheckDefault = ReachabilityCheckWarn
+	cfgOptionReachabilityCheckOrder   = 158
+
+	// Token Issuer Endpoints
+	cfgOptionTokenIssuerEndpointsKey     = "spn/tokenIssuerEndpoints"
+	cfgOptionTokenIssuerEndpoints        config.StringArrayOption
+	cfgOptionTokenIssuerEndpointsDefault = []string{}
+	cfgOptionTokenIssuerEndpointsOrder   = 159
+
+	// Flow Sync Checks
+	cfgOptionFlowSyncCheckIntervalKey     = "spn/flowSyncCheckInterval"
+	cfgOptionFlowSyncCheckInterval        config.IntOption
+	cfgOptionFlowSyncCheckIntervalDefault = 0
+	cfgOptionFlowSyncCheckIntervalOrder   = 161
+
+	// Zone Config File
+	cfgOptionZoneConfigFileKey     = "spn/zoneConfigFile"
+	cfgOptionZoneConfigFile        config.StringOption
+	cfgOptionZoneConfigFileDefault = ""
+	cfgOptionZoneConfigFileOrder   = 162
+
+	// Flow Window Auto Tuning
+	cfgOptionFlowWindowMaxKey     = "spn/flowWindowMax"
+	cfgOptionFlowWindowMax        config.IntOption
+	cfgOptionFlowWindowMaxDefault = 0
+	cfgOptionFlowWindowMaxOrder   = 163
+
+	// Peer Quarantine
+	cfgOptionQuarantineStrikeLimitKey     = "spn/quarantineStrikeLimit"
+	cfgOptionQuarantineStrikeLimit        config.IntOption
+	cfgOptionQuarantineStrikeLimitDefault = docks.DefaultQuarantineStrikeLimit
+	cfgOptionQuarantineStrikeLimitOrder   = 164
+
+	cfgOptionQuarantineStrikeWindowKey     = "spn/quarantineStrikeWindow"
+	cfgOptionQuarantineStrikeWindow        config.IntOption
+	cfgOptionQuarantineStrikeWindowDefault = int(docks.DefaultQuarantineStrikeWindow / time.Minute)
+	cfgOptionQuarantineStrikeWindowOrder   = 165
+
+	cfgOptionQuarantineDurationKey     = "spn/quarantineDuration"
+	cfgOptionQuarantineDuration        config.IntOption
+	cfgOptionQuarantineDurationDefault = int(docks.DefaultQuarantineDuration / time.Minute)
+	cfgOptionQuarantineDurationOrder   = 166
+
+	// Crane Recorder
+	cfgOptionCraneRecorderSizeKey     = "spn/craneRecorderSize"
+	cfgOptionCraneRecorderSize        config.IntOption
+	cfgOptionCraneRecorderSizeDefault = 0
+	cfgOptionCraneRecorderSizeOrder   = 167
+
+	cfgOptionCraneRecorderPlaintextKey     = "spn/craneRecorderPlaintext"
+	cfgOptionCraneRecorderPlaintext        config.BoolOption
+	cfgOptionCraneRecorderPlaintextDefault = false
+	cfgOptionCraneRecorderPlaintextOrder   = 168
+
+	// Token Refill
+	cfgOptionRefillConcurrencyKey     = "spn/refillConcurrency"
+	cfgOptionRefillConcurrency        config.IntOption
+	cfgOptionRefillConcurrencyDefault = access.DefaultRefillConcurrency
+	cfgOptionRefillConcurrencyOrder   = 169
+
+	// Flow Tracing
+	cfgOptionFlowTracingEventsKey     = "spn/flowTracingEvents"
+	cfgOptionFlowTracingEvents        config.IntOption
+	cfgOptionFlowTracingEventsDefault = 0
+	cfgOptionFlowTracingEventsOrder   = 170
+
+	// Token Issuer Circuit Breaker
+	cfgOptionTokenIssuerBreakerThresholdKey     = "spn/tokenIssuerBreakerThreshold"
+	cfgOptionTokenIssuerBreakerThreshold        config.IntOption
+	cfgOptionTokenIssuerBreakerThresholdDefault = access.DefaultTokenIssuerBreakerThreshold
+	cfgOptionTokenIssuerBreakerThresholdOrder   = 171
 )
 
+// maxFlowTracingEvents caps the amount of recorded events per flow queue, as
+// every terminal keeps its own trace.
+const maxFlowTracingEvents = 10000
+
 func prepConfig() error {
 	err := config.Register(&config.Option{
 		Name:         "Special Access Code",
@@ -31,5 +174,740 @@ func prepConfig() error {
 
 	cfgOptionSpecialAccessCode = config.Concurrent.GetAsString(cfgOptionSpecialAccessCodeKey, "")
 
+	err = config.Register(&config.Option{
+		Name:           "Bootstrap File Key",
+		Key:            cfgOptionBootstrapFileKeyKey,
+		Description:    "Hex encoded key with at least 16 bytes for encrypting the bootstrap file at rest. If set, newly created bootstrap files are encrypted. Encrypted bootstrap files can only be loaded with this key.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionBootstrapFileKeyDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionBootstrapFileKeyOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionBootstrapFileKey = config.Concurrent.GetAsString(cfgOptionBootstrapFileKeyKey, cfgOptionBootstrapFileKeyDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Clock Skew Warning Threshold",
+		Key:            cfgOptionClockSkewThresholdKey,
+		Description:    "Amount of seconds the status timestamp of a Hub may be ahead of the local time before the Hub is flagged for having a skewed clock. Flagged Hubs are still used. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionClockSkewThresholdDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionClockSkewThresholdOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	hub.SetClockSkewWarningThreshold(
+		config.Concurrent.GetAsInt(cfgOptionClockSkewThresholdKey, cfgOptionClockSkewThresholdDefault),
+	)
+
+	err = config.Register(&config.Option{
+		Name:           "Public Hub IP Detection",
+		Key:            cfgOptionIPDetectionKey,
+		Description:    "Method for detecting changes of the external IPs of a public Hub. Changed IPs are announced immediately. Use \"assigned\" to detect the IPs from the network interfaces or \"manual\" to disable detection. Additional methods may be registered by the application.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionIPDetectionDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionIPDetectionOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionIPDetection = config.Concurrent.GetAsString(cfgOptionIPDetectionKey, cfgOptionIPDetectionDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Pre-Warmed Cranes",
+		Key:            cfgOptionPrewarmCranesKey,
+		Description:    "Amount of the most used Hubs that connections are kept to, so that connecting to them is fast. Public Hubs keep lanes to them within their max lanes, clients re-establish the last used routes to them. Pre-warmed connections are still retired when they are not used anymore. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionPrewarmCranesDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPrewarmCranesOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionPrewarmCranes = config.Concurrent.GetAsInt(cfgOptionPrewarmCranesKey, cfgOptionPrewarmCranesDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Pre-Warming Usage History",
+		Key:            cfgOptionPrewarmWindowKey,
+		Description:    "Amount of minutes of usage history that is used to find the most used Hubs for pre-warming connections.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionPrewarmWindowDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPrewarmWindowOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionPrewarmWindow = config.Concurrent.GetAsInt(cfgOptionPrewarmWindowKey, cfgOptionPrewarmWindowDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Blocked Hubs",
+		Key:            cfgOptionBlockedHubsKey,
+		Description:    "List of Hub IDs that may not establish cranes to this Hub and that this Hub does not connect to. Existing cranes to newly blocked Hubs are stopped. Hubs discontinued by the intel data are always blocked.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionBlockedHubsDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionBlockedHubsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionBlockedHubs = config.Concurrent.GetAsStringArray(cfgOptionBlockedHubsKey, cfgOptionBlockedHubsDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Trusted Link Networks",
+		Key:            cfgOptionTrustedLinkNetworksKey,
+		Description:    "List of networks in CIDR notation, in which links to other Hubs are regarded as secure. Cranes on these links are not encrypted and the identity of the other Hub is not verified during the crane setup. Only use this for private networks that you fully control. The other Hub must regard the link as trusted too.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionTrustedLinkNetworksDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionTrustedLinkNetworksOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionTrustedLinkNetworks = config.Concurrent.GetAsStringArray(cfgOptionTrustedLinkNetworksKey, cfgOptionTrustedLinkNetworksDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Token Receipts File",
+		Key:            cfgOptionTokenReceiptsFileKey,
+		Description:    "Path of a file to which signed receipts of accepted access tokens are appended, one base64 encoded receipt per line. Receipts allow to reconcile token usage without revealing the tokens. Leave empty to disable. Changes require a restart.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionTokenReceiptsFileDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionTokenReceiptsFileOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionTokenReceiptsFile = config.Concurrent.GetAsString(cfgOptionTokenReceiptsFileKey, cfgOptionTokenReceiptsFileDefault)
+
+	err = config.Register(&config.Option{
+		Name:            "Public Hub Reachability Check",
+		Key:             cfgOptionReachabilityCheckKey,
+		Description:     "Before the Hub is published, a connected Hub is asked to connect back to the announced transports at the announced IPs in order to verify that they are reachable from the Internet. Failed checks are repeated after an hour. Use \"warn\" to check in the background and only log unreachable transports, \"enforce\" to refuse publishing the Hub until all transports are verified, or \"off\" to disable the check.",
+		OptType:         config.OptTypeString,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		DefaultValue:    cfgOptionReachabilityCheckDefault,
+		ValidationRegex: "^(" + ReachabilityCheckOff + "|" + ReachabilityCheckWarn + "|" + ReachabilityCheckEnforce + ")$",
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionReachabilityCheckOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionReachabilityCheck = config.Concurrent.GetAsString(cfgOptionReachabilityCheckKey, cfgOptionReachabilityCheckDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Token Issuer Endpoints",
+		Key:            cfgOptionTokenIssuerEndpointsKey,
+		Description:    "List of base URLs of the token issuer. The first endpoint is preferred, the others are only used while the preceding ones are failing. Leave empty to use the default token issuer.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionTokenIssuerEndpointsDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionTokenIssuerEndpointsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionTokenIssuerEndpoints = config.Concurrent.GetAsStringArray(cfgOptionTokenIssuerEndpointsKey, cfgOptionTokenIssuerEndpointsDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Flow Control Sync Check Interval",
+		Key:            cfgOptionFlowSyncCheckIntervalKey,
+		Description:    "Interval in seconds in which cranes compare their flow control state with the connected Hub in order to detect a desync, which is logged. Changes only apply to new cranes. Set to 0 to disable the checks.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionFlowSyncCheckIntervalDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionFlowSyncCheckIntervalOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionFlowSyncCheckInterval = config.Concurrent.GetAsInt(cfgOptionFlowSyncCheckIntervalKey, cfgOptionFlowSyncCheckIntervalDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Token Zone Config File",
+		Key:            cfgOptionZoneConfigFileKey,
+		Description:    "Path to a YAML file defining the token zones. Zones that are not changed by the file keep their tokens. The file is reloaded when the path changes or the file is modified. Leave empty to use the default zones.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionZoneConfigFileDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionZoneConfigFileOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionZoneConfigFile = config.Concurrent.GetAsString(cfgOptionZoneConfigFileKey, cfgOptionZoneConfigFileDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Max Flow Control Window",
+		Key:            cfgOptionFlowWindowMaxKey,
+		Description:    "Enables auto tuning of the receive window of crane terminals, which grows the window with the measured throughput and latency up to the given amount of messages. Changes only apply to new cranes. Set to 0 to disable auto tuning.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionFlowWindowMaxDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionFlowWindowMaxOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionFlowWindowMax = config.Concurrent.GetAsInt(cfgOptionFlowWindowMaxKey, cfgOptionFlowWindowMaxDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Quarantine Strike Limit",
+		Key:            cfgOptionQuarantineStrikeLimitKey,
+		Description:    "Amount of protocol violations of a peer that are tolerated within the strike window before the peer is quarantined. Peers that are not authenticated Hubs are only known by their IP address, which may be shared by many peers, and are tolerated three times as many violations. Set to 0 to disable the quarantine.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionQuarantineStrikeLimitDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionQuarantineStrikeLimitOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionQuarantineStrikeLimit = config.Concurrent.GetAsInt(cfgOptionQuarantineStrikeLimitKey, int64(cfgOptionQuarantineStrikeLimitDefault))
+
+	err = config.Register(&config.Option{
+		Name:           "Quarantine Strike Window",
+		Key:            cfgOptionQuarantineStrikeWindowKey,
+		Description:    "Time window in minutes in which protocol violations of a peer are counted.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionQuarantineStrikeWindowDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionQuarantineStrikeWindowOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionQuarantineStrikeWindow = config.Concurrent.GetAsInt(cfgOptionQuarantineStrikeWindowKey, int64(cfgOptionQuarantineStrikeWindowDefault))
+
+	err = config.Register(&config.Option{
+		Name:           "Quarantine Duration",
+		Key:            cfgOptionQuarantineDurationKey,
+		Description:    "Duration in minutes for which a peer is quarantined. Changes only apply to new quarantines.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionQuarantineDurationDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionQuarantineDurationOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionQuarantineDuration = config.Concurrent.GetAsInt(cfgOptionQuarantineDurationKey, int64(cfgOptionQuarantineDurationDefault))
+
+	err = config.Register(&config.Option{
+		Name:           "Crane Recorder Size",
+		Key:            cfgOptionCraneRecorderSizeKey,
+		Description:    "Record the most recent traffic of every connection to another Hub for debugging, in KB. Recordings of failed connections are kept in memory for later inspection. The total memory used by recordings is limited. Set to 0 to disable recording.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   cfgOptionCraneRecorderSizeDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionCraneRecorderSizeOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionCraneRecorderSize = config.Concurrent.GetAsInt(cfgOptionCraneRecorderSizeKey, cfgOptionCraneRecorderSizeDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Crane Recorder Plaintext",
+		Key:            cfgOptionCraneRecorderPlaintextKey,
+		Description:    "Additionally record the decrypted traffic of connections to other Hubs. This includes the plaintext of all connections routed through this Hub and must only be enabled for debugging.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionCraneRecorderPlaintextDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionCraneRecorderPlaintextOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionCraneRecorderPlaintext = config.Concurrent.GetAsBool(cfgOptionCraneRecorderPlaintextKey, cfgOptionCraneRecorderPlaintextDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Token Refill Concurrency",
+		Key:            cfgOptionRefillConcurrencyKey,
+		Description:    "Limit how many token zones are refilled at the same time. Every zone is refilled with its own requests to the token issuer. Further zones wait until a running refill finishes.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionRefillConcurrencyDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionRefillConcurrencyOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionRefillConcurrency = config.Concurrent.GetAsInt(cfgOptionRefillConcurrencyKey, cfgOptionRefillConcurrencyDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Flow Tracing Events",
+		Key:            cfgOptionFlowTracingEventsKey,
+		Description:    "Record the most recent flow control events of every crane terminal for debugging. The recorded events are available via the API. Changes only apply to new terminals. Set to 0 to disable tracing.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionFlowTracingEventsDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionFlowTracingEventsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionFlowTracingEvents = config.Concurrent.GetAsInt(cfgOptionFlowTracingEventsKey, cfgOptionFlowTracingEventsDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Token Issuer Failure Threshold",
+		Key:            cfgOptionTokenIssuerBreakerThresholdKey,
+		Description:    "Amount of consecutive failed requests after which the token issuer is regarded as failing. While it is failing, requests fail fast and fallback tokens are used. Higher values tolerate single failures, but delay using fallback tokens.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   cfgOptionTokenIssuerBreakerThresholdDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionTokenIssuerBreakerThresholdOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionTokenIssuerBreakerThreshold = config.Concurrent.GetAsInt(cfgOptionTokenIssuerBreakerThresholdKey, cfgOptionTokenIssuerBreakerThresholdDefault)
+
+	return nil
+}
+
+// enableTokenReceipts enables issuing signed receipts for accepted tokens, if
+// a receipts file is configured. Requires the public identity to be loaded.
+func enableTokenReceipts() error {
+	path := cfgOptionTokenReceiptsFile()
+	if path == "" {
+		return nil
+	}
+
+	sink, err := access.NewFileReceiptSink(path)
+	if err != nil {
+		return err
+	}
+	if err := access.EnableTokenReceipts(publicIdentity.ID, publicIdentity.SignHubMsg, sink); err != nil {
+		_ = sink.Close()
+		return err
+	}
+
+	log.Infof("spn/captain: writing token receipts to %s", path)
+	return nil
+}
+
+// registerHubBlocklistHook applies the configured Hub blocklist and updates
+// it when the configuration changes.
+func registerHubBlocklistHook() error {
+	docks.SetHubBlocklist(docks.BlocklistSourceConfig, cfgOptionBlockedHubs())
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update hub blocklist from config",
+		func(_ context.Context, _ interface{}) error {
+			docks.SetHubBlocklist(docks.BlocklistSourceConfig, cfgOptionBlockedHubs())
+			return nil
+		},
+	)
+}
+
+// registerFlowSyncChecksHook applies the configured flow sync check interval
+// and updates it when the configuration changes.
+func registerFlowSyncChecksHook() error {
+	applyFlowSyncCheckInterval()
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update flow sync check interval",
+		func(_ context.Context, _ interface{}) error {
+			applyFlowSyncCheckInterval()
+			return nil
+		},
+	)
+}
+
+func applyFlowSyncCheckInterval() {
+	interval := cfgOptionFlowSyncCheckInterval()
+	if interval < 0 {
+		interval = 0
+	}
+	docks.SetFlowSyncCheckInterval(time.Duration(interval) * time.Second)
+}
+
+// registerFlowWindowHook applies the configured max flow control window and
+// updates it when the configuration changes.
+func registerFlowWindowHook() error {
+	applyFlowWindowMax()
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update max flow control window",
+		func(_ context.Context, _ interface{}) error {
+			applyFlowWindowMax()
+			return nil
+		},
+	)
+}
+
+func applyFlowWindowMax() {
+	maxWindow := cfgOptionFlowWindowMax()
+	if maxWindow < 0 {
+		maxWindow = 0
+	}
+	docks.SetDefaultFlowWindowAutoTuning(uint32(maxWindow))
+}
+
+// registerFlowTracingHook applies the configured amount of flow tracing events
+// and updates it when the configuration changes.
+func registerFlowTracingHook() error {
+	applyFlowTracingEvents()
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update flow tracing events",
+		func(_ context.Context, _ interface{}) error {
+			applyFlowTracingEvents()
+			return nil
+		},
+	)
+}
+
+func applyFlowTracingEvents() {
+	n := cfgOptionFlowTracingEvents()
+	switch {
+	case n < 0:
+		n = 0
+	case n > maxFlowTracingEvents:
+		n = maxFlowTracingEvents
+	}
+	terminal.SetFlowTracingEvents(int(n))
+}
+
+// registerQuarantineHook applies the configured peer quarantine settings and
+// updates them when the configuration changes.
+func registerQuarantineHook() error {
+	applyQuarantineConfig()
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update peer quarantine settings",
+		func(_ context.Context, _ interface{}) error {
+			applyQuarantineConfig()
+			return nil
+		},
+	)
+}
+
+func applyQuarantineConfig() {
+	strikeLimit := cfgOptionQuarantineStrikeLimit()
+	if strikeLimit <= 0 {
+		// Disable the quarantine by requiring an unreachable amount of strikes.
+		strikeLimit = math.MaxInt32
+	}
+	strikeWindow := cfgOptionQuarantineStrikeWindow()
+	if strikeWindow < 1 {
+		strikeWindow = 1
+	}
+	duration := cfgOptionQuarantineDuration()
+	if duration < 1 {
+		duration = 1
+	}
+	docks.SetQuarantineConfig(
+		int(strikeLimit),
+		time.Duration(strikeWindow)*time.Minute,
+		time.Duration(duration)*time.Minute,
+	)
+}
+
+// registerCraneRecorderHook applies the configured crane recorder settings and
+// updates them when the configuration changes. Changes only apply to new
+// cranes.
+func registerCraneRecorderHook() error {
+	applyCraneRecorderConfig()
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update crane recorder settings",
+		func(_ context.Context, _ interface{}) error {
+			applyCraneRecorderConfig()
+			return nil
+		},
+	)
+}
+
+func applyCraneRecorderConfig() {
+	size := cfgOptionCraneRecorderSize()
+	switch {
+	case size < 0:
+		size = 0
+	case size > math.MaxInt32/1024:
+		size = math.MaxInt32 / 1024
+	}
+	docks.SetCraneRecorderConfig(int(size)*1024, cfgOptionCraneRecorderPlaintext())
+}
+
+// registerZoneConfigFileHook applies the configured zone config file and
+// reloads it when the configured path changes or the file was modified.
+func registerZoneConfigFileHook() error {
+	if err := applyZoneConfigFile(); err != nil {
+		return err
+	}
+
+	// Check the file for modifications regularly.
+	newManagedTask("reload zone config file", func(_ context.Context, _ *modules.Task) error {
+		return applyZoneConfigFile()
+	}).Repeat(zoneConfigFileCheckInterval)
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"reload zone config file",
+		func(_ context.Context, _ interface{}) error {
+			return applyZoneConfigFile()
+		},
+	)
+}
+
+// zoneConfigFileCheckInterval defines how often the zone config file is
+// checked for modifications.
+const zoneConfigFileCheckInterval = 1 * time.Minute
+
+var (
+	appliedZoneConfigFile        string
+	appliedZoneConfigFileModTime time.Time
+	appliedZoneConfigFileSize    int64
+	appliedZoneConfigFileLock    sync.Mutex
+)
+
+func applyZoneConfigFile() error {
+	appliedZoneConfigFileLock.Lock()
+	defer appliedZoneConfigFileLock.Unlock()
+
+	// Check if the file changed.
+	path := cfgOptionZoneConfigFile()
+	var (
+		modTime time.Time
+		size    int64
+	)
+	if path != "" {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to check zone config file: %w", err)
+		}
+		modTime = fileInfo.ModTime()
+		size = fileInfo.Size()
+	}
+	if path == appliedZoneConfigFile &&
+		modTime.Equal(appliedZoneConfigFileModTime) &&
+		size == appliedZoneConfigFileSize {
+		return nil
+	}
+
+	var (
+		report *access.ZoneReloadReport
+		err    error
+	)
+	if path != "" {
+		report, err = access.LoadZoneConfigFile(path)
+	} else {
+		report, err = access.ApplyZoneConfigs(access.DefaultZoneConfigs)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply zone config file: %w", err)
+	}
+
+	appliedZoneConfigFile = path
+	appliedZoneConfigFileModTime = modTime
+	appliedZoneConfigFileSize = size
+	log.Infof("spn/captain: updated token zones: %s", report)
+	return nil
+}
+
+// registerTrustedLinksHook applies the configured trusted link networks and
+// updates them when the configuration changes.
+func registerTrustedLinksHook() error {
+	if err := applyTrustedLinkNetworks(); err != nil {
+		return err
+	}
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update trusted link networks",
+		func(_ context.Context, _ interface{}) error {
+			return applyTrustedLinkNetworks()
+		},
+	)
+}
+
+// registerIssuerEndpointsHook applies the configured token issuer endpoints
+// and updates them when the configuration changes.
+func registerIssuerEndpointsHook() error {
+	if err := access.SetIssuerEndpoints(cfgOptionTokenIssuerEndpoints()); err != nil {
+		return err
+	}
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update token issuer endpoints",
+		func(_ context.Context, _ interface{}) error {
+			return access.SetIssuerEndpoints(cfgOptionTokenIssuerEndpoints())
+		},
+	)
+}
+
+// registerRefillConcurrencyHook applies the configured token refill
+// concurrency and updates it when the configuration changes.
+func registerRefillConcurrencyHook() error {
+	applyRefillConcurrency()
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update token refill concurrency",
+		func(_ context.Context, _ interface{}) error {
+			applyRefillConcurrency()
+			return nil
+		},
+	)
+}
+
+func applyRefillConcurrency() {
+	n := cfgOptionRefillConcurrency()
+	if n < 1 || n > math.MaxInt32 {
+		n = access.DefaultRefillConcurrency
+	}
+	if err := access.SetRefillConcurrency(int(n)); err != nil {
+		log.Warningf("spn/captain: failed to set token refill concurrency: %s", err)
+	}
+}
+
+// registerTokenIssuerBreakerHook applies the configured token issuer failure
+// threshold and updates it when the configuration changes.
+func registerTokenIssuerBreakerHook() error {
+	applyTokenIssuerBreakerThreshold()
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update token issuer failure threshold",
+		func(_ context.Context, _ interface{}) error {
+			applyTokenIssuerBreakerThreshold()
+			return nil
+		},
+	)
+}
+
+func applyTokenIssuerBreakerThreshold() {
+	n := cfgOptionTokenIssuerBreakerThreshold()
+	if n < 1 || n > math.MaxInt32 {
+		n = access.DefaultTokenIssuerBreakerThreshold
+	}
+	if err := access.SetTokenIssuerBreakerThreshold(int(n)); err != nil {
+		log.Warningf("spn/captain: failed to set token issuer failure threshold: %s", err)
+	}
+}
+
+// registerTransportPolicyHook applies the configured transport policy to
+// launching ships and updates it when the configuration changes.
+func registerTransportPolicyHook() error {
+	applyTransportPolicy()
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update transport policy",
+		func(_ context.Context, _ interface{}) error {
+			applyTransportPolicy()
+			return nil
+		},
+	)
+}
+
+func applyTransportPolicy() {
+	policy := navigator.ConfiguredTransportPolicy()
+	ships.SetTransportPolicy(policy)
+	if policy != nil {
+		log.Infof("spn/captain: using transport policy with %s", policy)
+	}
+}
+
+func applyTrustedLinkNetworks() error {
+	networks := cfgOptionTrustedLinkNetworks()
+	if err := docks.SetTrustedLinkNetworks(networks); err != nil {
+		return err
+	}
+	if len(networks) > 0 {
+		log.Warningf("spn/captain: cranes on links in %s are not encrypted", strings.Join(networks, ", "))
+	}
 	return nil
 }
@@ -0,0 +1,200 @@
+package captain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/access"
+	"github.com/safing/spn/clock"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/hub"
+)
+
+// Diagnostics holds a snapshot of the state of this instance for support.
+// It must never contain secrets, such as private keys or auth tokens.
+type Diagnostics struct {
+	CreatedAt time.Time
+
+	PublicHub     bool
+	Client        bool
+	CaptainOnline bool
+	SPNStatus     SPNStatusName
+	HomeHubID     string
+
+	// Announcement and Status of the public identity, if this is a public Hub.
+	Announcement *hub.Announcement `json:",omitempty"`
+	Status       *hub.Status       `json:",omitempty"`
+	// Reachability holds the result of the reachability check of the
+	// announced transports, if this is a public Hub.
+	Reachability *ReachabilityStatus `json:",omitempty"`
+
+	Modules []*ModuleDiagnostics
+	Cranes  []*CraneDiagnostics
+	Tasks   []TaskInfo
+
+	Zones           []*access.ZoneInfo
+	AccessEvents    []access.AccessEvent
+	TokenIssuer     *access.BreakerInfo          `json:",omitempty"`
+	IssuerTimeouts  *access.IssuerClientTimeouts `json:",omitempty"`
+	IssuerEndpoints []*access.IssuerEndpointInfo `json:",omitempty"`
+}
+
+// ModuleDiagnostics holds the state of a module used by the SPN.
+type ModuleDiagnostics struct {
+	Name           string
+	Online         bool
+	Status         uint8
+	FailureStatus  uint8  `json:",omitempty"`
+	FailureID      string `json:",omitempty"`
+	FailureMessage string `json:",omitempty"`
+}
+
+// CraneDiagnostics holds diagnostic information about a crane.
+type CraneDiagnostics struct {
+	ID           string
+	ConnectedHub string
+	Transport    string
+	Public       bool
+	Mine         bool
+	Stopping     bool
+	Stopped      bool
+
+	Latency        time.Duration
+	Capacity       int
+	LiveTransports []string `json:",omitempty"`
+
+	LifetimeBytesIn  uint64
+	LifetimeBytesOut uint64
+	LifetimeStarted  time.Time
+	PeriodBytesIn    uint64
+	PeriodBytesOut   uint64
+	PeriodStarted    time.Time
+}
+
+// ExportDiagnostics returns a JSON snapshot of the state of this instance,
+// including the public identity, module states, active cranes, token supply
+// and recent access events. Secrets are not included.
+func ExportDiagnostics() ([]byte, error) {
+	diag := &Diagnostics{
+		CreatedAt:     clock.Now(),
+		PublicHub:     conf.PublicHub(),
+		Client:        conf.Client(),
+		CaptainOnline: module.Online(),
+		Modules:       getModuleDiagnostics(),
+		Cranes:        getCraneDiagnostics(),
+		Tasks:         PendingTasks(),
+		Zones:         access.ListZones(),
+		AccessEvents:  access.RecentEvents(),
+	}
+
+	// Add the token issuer circuit breaker state, client timeouts and
+	// endpoints, which are only used by clients.
+	if conf.Client() {
+		diag.TokenIssuer = access.GetTokenIssuerBreakerInfo()
+		issuerTimeouts := access.GetIssuerClientTimeouts()
+		diag.IssuerTimeouts = &issuerTimeouts
+		diag.IssuerEndpoints = access.GetIssuerEndpointsInfo()
+	}
+
+	// Add SPN status.
+	func() {
+		spnStatus.Lock()
+		defer spnStatus.Unlock()
+
+		diag.SPNStatus = spnStatus.Status
+		diag.HomeHubID = spnStatus.HomeHubID
+	}()
+
+	// Add the public announcement and status, which only contain public data.
+	if publicIdentity != nil {
+		func() {
+			publicIdentity.Hub.Lock()
+			defer publicIdentity.Hub.Unlock()
+
+			diag.Announcement = publicIdentity.Hub.Info
+			diag.Status = publicIdentity.Hub.Status
+		}()
+
+		reachabilityStatus := GetReachabilityStatus()
+		diag.Reachability = &reachabilityStatus
+	}
+
+	data, err := dsd.Dump(diag, dsd.JSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize diagnostics: %w", err)
+	}
+	return data, nil
+}
+
+func getCraneDiagnostics() []*CraneDiagnostics {
+	cranes := docks.GetAllAssignedCranesSorted()
+	craneDiags := make([]*CraneDiagnostics, 0, len(cranes))
+	for _, crane := range cranes {
+		craneDiag := &CraneDiagnostics{
+			ID:       crane.ID,
+			Public:   crane.Public(),
+			Mine:     crane.IsMine(),
+			Stopping: crane.IsStopping(),
+			Stopped:  crane.Stopped(),
+		}
+		if transport := crane.Transport(); transport != nil {
+			craneDiag.Transport = transport.String()
+		}
+		if connectedHub := crane.ConnectedHub; connectedHub != nil {
+			connectedHub.Lock()
+			craneDiag.ConnectedHub = connectedHub.ID
+			measurements := connectedHub.GetMeasurementsWithLockedHub()
+			connectedHub.Unlock()
+
+			craneDiag.Latency, _ = measurements.GetLatency()
+			craneDiag.Capacity, _ = measurements.GetCapacity()
+			craneDiag.LiveTransports, _ = docks.GetLiveTransports(craneDiag.ConnectedHub)
+		}
+		craneDiag.LifetimeBytesIn,
+			craneDiag.LifetimeBytesOut,
+			craneDiag.LifetimeStarted,
+			craneDiag.PeriodBytesIn,
+			craneDiag.PeriodBytesOut,
+			craneDiag.PeriodStarted = crane.NetState.GetTrafficStats()
+
+		craneDiags = append(craneDiags, craneDiag)
+	}
+	return craneDiags
+}
+
+// getModuleDiagnostics returns the states of the captain module and all the
+// modules it depends on, ordered by name.
+func getModuleDiagnostics() []*ModuleDiagnostics {
+	seen := make(map[string]struct{})
+	var moduleDiags []*ModuleDiagnostics
+
+	var addModule func(m *modules.Module)
+	addModule = func(m *modules.Module) {
+		if _, ok := seen[m.Name]; ok {
+			return
+		}
+		seen[m.Name] = struct{}{}
+
+		moduleDiag := &ModuleDiagnostics{
+			Name:   m.Name,
+			Online: m.Online(),
+			Status: m.Status(),
+		}
+		moduleDiag.FailureStatus, moduleDiag.FailureID, moduleDiag.FailureMessage = m.FailureStatus()
+		moduleDiags = append(moduleDiags, moduleDiag)
+
+		for _, dep := range m.Dependencies() {
+			addModule(dep)
+		}
+	}
+	addModule(module)
+
+	sort.Slice(moduleDiags, func(i, j int) bool {
+		return moduleDiags[i].Name < moduleDiags[j].Name
+	})
+	return moduleDiags
+}
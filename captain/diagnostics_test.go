@@ -0,0 +1,44 @@
+package captain
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExportDiagnostics(t *testing.T) {
+	data, err := ExportDiagnostics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check if the export is valid JSON with all sections.
+	sections := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &sections); err != nil {
+		t.Fatalf("failed to parse diagnostics: %s", err)
+	}
+	for _, section := range []string{"CreatedAt", "SPNStatus", "Modules", "Cranes", "Tasks", "Zones", "AccessEvents"} {
+		if _, ok := sections[section]; !ok {
+			t.Errorf("diagnostics are missing the %s section", section)
+		}
+	}
+
+	// Check if the module states include the dependencies of the captain.
+	diag := &Diagnostics{}
+	if err := json.Unmarshal(data, diag); err != nil {
+		t.Fatalf("failed to parse diagnostics: %s", err)
+	}
+	modules := make(map[string]bool)
+	for _, m := range diag.Modules {
+		modules[m.Name] = true
+	}
+	for _, name := range []string{"captain", "docks", "access"} {
+		if !modules[name] {
+			t.Errorf("diagnostics are missing the state of the %s module", name)
+		}
+	}
+
+	// Check that no public identity is included on clients.
+	if diag.Announcement != nil || diag.Status != nil {
+		t.Error("diagnostics must not include a public identity if there is none")
+	}
+}
@@ -13,6 +13,36 @@ import (
 	"github.com/safing/spn/terminal"
 )
 
+// hubConnectError is returned by EstablishCrane if the crane to the Hub could
+// not be set up, which is regarded as a failure of the Hub.
+type hubConnectError struct {
+	err error
+}
+
+func (e *hubConnectError) Error() string {
+	return e.err.Error()
+}
+
+func (e *hubConnectError) Unwrap() error {
+	return e.err
+}
+
+// isHubFailure returns whether the given error was caused by failing to
+// connect to the Hub, in contrast to local errors or checks that failed.
+func isHubFailure(err error) bool {
+	var hcErr *hubConnectError
+	return errors.As(err, &hcErr)
+}
+
+// isHubTerminalFailure returns whether the given error, with which a terminal
+// to the Hub ended, was caused by the Hub. Only errors reported by the Hub
+// itself are regarded as a failure of the Hub. Local errors, such as a sunk
+// ship or a timeout, may just as well be caused by a network change or by the
+// device going to sleep.
+func isHubTerminalFailure(err *terminal.Error) bool {
+	return err.IsExternal() && err.IsError()
+}
+
 func EstablishCrane(ctx context.Context, dst *hub.Hub) (*docks.Crane, error) {
 	if conf.PublicHub() && dst.ID == publicIdentity.ID {
 		return nil, errors.New("connecting to self")
@@ -20,10 +50,16 @@ func EstablishCrane(ctx context.Context, dst *hub.Hub) (*docks.Crane, error) {
 	if docks.GetAssignedCrane(dst.ID) != nil {
 		return nil, fmt.Errorf("route to %s already exists", dst.ID)
 	}
+	if docks.IsHubQuarantined(dst.ID) {
+		return nil, fmt.Errorf("%s is quarantined", dst.ID)
+	}
 
-	ship, err := ships.Launch(ctx, dst, nil, nil)
+	ship, err := launchShip(ctx, dst)
 	if err != nil {
-		return nil, fmt.Errorf("failed to launch ship: %w", err)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to launch ship: %w", err)
+		}
+		return nil, &hubConnectError{fmt.Errorf("failed to launch ship: %w", err)}
 	}
 
 	crane, err := docks.NewCrane(context.Background(), ship, dst, publicIdentity)
@@ -33,7 +69,7 @@ func EstablishCrane(ctx context.Context, dst *hub.Hub) (*docks.Crane, error) {
 
 	err = crane.Start()
 	if err != nil {
-		return nil, fmt.Errorf("failed to start crane: %w", err)
+		return nil, &hubConnectError{fmt.Errorf("failed to start crane: %w", err)}
 	}
 
 	// Start gossip op for live map updates.
@@ -43,15 +79,42 @@ func EstablishCrane(ctx context.Context, dst *hub.Hub) (*docks.Crane, error) {
 		return nil, fmt.Errorf("failed to start gossip op: %w", tErr)
 	}
 
+	// Probe which transports of the Hub are currently listening.
+	startTransportProbe(crane)
+
 	return crane, nil
 }
 
+// launchShip launches a ship to the given Hub. Transports that the Hub
+// recently reported to be listening are tried first.
+func launchShip(ctx context.Context, dst *hub.Hub) (ships.Ship, error) {
+	transports, ok := docks.GetLiveTransports(dst.ID)
+	if ok && len(transports) > 0 {
+		transport, err := hub.ParseTransport(transports[0])
+		if err == nil {
+			ship, err := ships.Launch(ctx, dst, transport, nil)
+			if err == nil {
+				return ship, nil
+			}
+			log.Debugf("spn/captain: failed to launch ship to %s using live transport %s: %s", dst, transport, err)
+		}
+	}
+
+	return ships.Launch(ctx, dst, nil, nil)
+}
+
 func EstablishPublicLane(ctx context.Context, dst *hub.Hub) (*docks.Crane, *terminal.Error) {
 	crane, err := EstablishCrane(ctx, dst)
 	if err != nil {
 		return nil, terminal.ErrInternalError.With("failed to establish crane: %w", err)
 	}
 
+	// Verify that the announced transports are reachable before publishing.
+	if err := verifyReachability(crane); err != nil {
+		crane.Stop(nil)
+		return nil, terminal.ErrInternalError.With("refusing to publish: %w", err)
+	}
+
 	// Publish as Lane.
 	publishOp, tErr := NewPublishOp(crane.Controller, publicIdentity)
 	if tErr != nil {
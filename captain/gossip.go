@@ -1,12 +1,32 @@
 package captain
 
 import (
+	"context"
 	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
 )
 
+// gossipCoalesceWindow defines how long own announcement and status changes
+// are held back in order to send them as a single combined gossip message.
+const gossipCoalesceWindow = 3 * time.Second
+
+// GossipRelayMaxPressure defines the flow queue pressure of a crane controller
+// at which relayed gossip messages are no longer sent to the crane. Congested
+// peers will receive the gossip from other peers or via the next status update.
+var GossipRelayMaxPressure = 0.8
+
 var (
 	gossipOps     = make(map[string]*GossipOp)
 	gossipOpsLock sync.RWMutex
+
+	pendingGossipTask         *managedTask
+	pendingGossipAnnouncement []byte
+	pendingGossipStatus       []byte
+	pendingGossipScheduled    bool
+	pendingGossipLock         sync.Mutex
 )
 
 func registerGossipOp(craneID string, op *GossipOp) {
@@ -27,12 +47,80 @@ func gossipRelayMsg(receivedFrom string, msgType GossipMsgType, data []byte) {
 	gossipOpsLock.RLock()
 	defer gossipOpsLock.RUnlock()
 
+	var announcementData, statusData []byte
 	for craneID, gossipOp := range gossipOps {
 		// Don't return same msg back to sender.
 		if craneID == receivedFrom {
 			continue
 		}
 
+		// Don't relay to congested peers.
+		if pressure := gossipOp.controller.Pressure(); pressure >= GossipRelayMaxPressure {
+			log.Debugf("spn/captain: skipping gossip relay to congested %s (pressure %.2f)", gossipOp.controller.Crane, pressure)
+			if gossipRelaySkippedCongested != nil {
+				gossipRelaySkippedCongested.Inc()
+			}
+			continue
+		}
+
+		// Send combined messages separately to peers that do not support them.
+		if msgType == GossipHubAnnouncementAndStatusMsg && !gossipOp.supportsCombinedMsg() {
+			if announcementData == nil {
+				var err error
+				announcementData, statusData, err = parseCombinedGossipMsg(data)
+				if err != nil {
+					log.Warningf("spn/captain: failed to split %s for relaying: %s", msgType, err)
+					return
+				}
+			}
+			gossipOp.sendMsg(GossipHubAnnouncementMsg, announcementData)
+			gossipOp.sendMsg(GossipHubStatusMsg, statusData)
+			continue
+		}
+
 		gossipOp.sendMsg(msgType, data)
 	}
 }
+
+// queueOwnGossip queues an own announcement and/or status for gossiping.
+// Changes within gossipCoalesceWindow are sent as a single combined message.
+// Newer data replaces pending data of the same kind.
+func queueOwnGossip(announcementData, statusData []byte) {
+	pendingGossipLock.Lock()
+	defer pendingGossipLock.Unlock()
+
+	if announcementData != nil {
+		pendingGossipAnnouncement = announcementData
+	}
+	if statusData != nil {
+		pendingGossipStatus = statusData
+	}
+
+	// Schedule sending, if not yet scheduled.
+	if !pendingGossipScheduled {
+		pendingGossipScheduled = true
+		pendingGossipTask.Schedule(time.Now().Add(gossipCoalesceWindow))
+	}
+}
+
+func sendPendingGossip(_ context.Context, _ *modules.Task) error {
+	pendingGossipLock.Lock()
+	announcementData := pendingGossipAnnouncement
+	statusData := pendingGossipStatus
+	pendingGossipAnnouncement = nil
+	pendingGossipStatus = nil
+	pendingGossipScheduled = false
+	pendingGossipLock.Unlock()
+
+	switch {
+	case announcementData != nil && statusData != nil:
+		gossipRelayMsg("", GossipHubAnnouncementAndStatusMsg, packCombinedGossipMsg(announcementData, statusData))
+		log.Debug("spn/captain: gossiped combined announcement and status")
+	case announcementData != nil:
+		gossipRelayMsg("", GossipHubAnnouncementMsg, announcementData)
+	case statusData != nil:
+		gossipRelayMsg("", GossipHubStatusMsg, statusData)
+	}
+
+	return nil
+}
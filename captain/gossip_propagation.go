@@ -0,0 +1,137 @@
+package captain
+
+import (
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/metrics"
+	"github.com/safing/spn/clock"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/navigator"
+)
+
+// maxGossipPropagationDelay defines up to which age received gossip is
+// regarded as propagating. Older data is usually not propagating, but synced
+// after a reconnect, and would distort the measurements.
+const maxGossipPropagationDelay = 15 * time.Minute
+
+// unknownRegion is used as the region label of Hubs without a region.
+const unknownRegion = "unknown"
+
+var (
+	gossipPropagationHistograms     = make(map[string]*metrics.Histogram)
+	gossipPropagationHistogramsLock sync.Mutex
+
+	// gossipPropagationOrigins holds the last recorded origin timestamp by Hub
+	// and message type.
+	gossipPropagationOrigins     = make(map[string]int64)
+	gossipPropagationOriginsLock sync.Mutex
+)
+
+// getGossipPropagationHistogram returns the propagation delay histogram for
+// the given message type and origin region. It creates it if necessary.
+// Returns nil if the histogram could not be created.
+func getGossipPropagationHistogram(msgType, region string) *metrics.Histogram {
+	key := msgType + "/" + region
+
+	gossipPropagationHistogramsLock.Lock()
+	defer gossipPropagationHistogramsLock.Unlock()
+
+	if histogram, ok := gossipPropagationHistograms[key]; ok {
+		return histogram
+	}
+
+	histogram, err := metrics.NewHistogram(
+		"spn/gossip/propagation/histogram/delay/seconds",
+		map[string]string{
+			"type":   msgType,
+			"region": region,
+		},
+		&metrics.Options{
+			Name:       "SPN Gossip Propagation Delay Histogram",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		log.Warningf("spn/captain: failed to register gossip propagation metric for %s: %s", key, err)
+	}
+
+	// Also remember failures in order to not retry on every message.
+	gossipPropagationHistograms[key] = histogram
+	return histogram
+}
+
+// reportGossipPropagation records the delay between the signed origin
+// timestamp of newly received gossip and now, aggregated by the message type
+// and the region of the originating Hub. Only the first reception of a
+// message is recorded. The origin timestamps have a resolution of one second.
+func reportGossipPropagation(h *hub.Hub, announcement, status bool) {
+	if !metricsRegistered.IsSet() || h == nil {
+		return
+	}
+
+	// Get origin timestamps.
+	var announcedAt, statusAt int64
+	func() {
+		h.Lock()
+		defer h.Unlock()
+
+		if announcement && h.Info != nil {
+			announcedAt = h.Info.Timestamp
+		}
+		if status && h.Status != nil {
+			statusAt = h.Status.Timestamp
+		}
+	}()
+
+	// Get origin region.
+	region := unknownRegion
+	if navigator.Main != nil {
+		if regionID, ok := navigator.Main.GetHubRegion(h.ID); ok {
+			region = regionID
+		}
+	}
+
+	now := clock.Now()
+	for msgType, timestamp := range map[string]int64{
+		hub.MsgTypeAnnouncement: announcedAt,
+		hub.MsgTypeStatus:       statusAt,
+	} {
+		if timestamp == 0 {
+			continue
+		}
+
+		if !checkGossipPropagation(h.ID, msgType, timestamp, now) {
+			continue
+		}
+
+		if histogram := getGossipPropagationHistogram(msgType, region); histogram != nil {
+			histogram.UpdateDuration(time.Unix(timestamp, 0))
+		}
+	}
+}
+
+// checkGossipPropagation returns whether the propagation delay of gossip with
+// the given origin timestamp should be recorded. Data from the future (clock
+// skew) and data that is too old are ignored. Data that is not newer than the
+// last recorded data of the same Hub and message type is ignored too, as it
+// was received out of order or again via another relay.
+func checkGossipPropagation(hubID, msgType string, timestamp int64, now time.Time) bool {
+	delay := now.Sub(time.Unix(timestamp, 0))
+	if delay < 0 || delay > maxGossipPropagationDelay {
+		return false
+	}
+
+	key := hubID + "/" + msgType
+
+	gossipPropagationOriginsLock.Lock()
+	defer gossipPropagationOriginsLock.Unlock()
+
+	if timestamp <= gossipPropagationOrigins[key] {
+		return false
+	}
+	gossipPropagationOrigins[key] = timestamp
+	return true
+}
@@ -0,0 +1,40 @@
+package captain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/safing/spn/hub"
+)
+
+func TestCheckGossipPropagation(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	at := func(age time.Duration) int64 {
+		return now.Add(-age).Unix()
+	}
+
+	// Tests run in order, as they share the recorded origins.
+	for _, test := range []struct {
+		name      string
+		hubID     string
+		msgType   string
+		timestamp int64
+		recorded  bool
+	}{
+		{"first reception", "gossip-test-a", hub.MsgTypeStatus, at(10 * time.Second), true},
+		{"relayed copy", "gossip-test-a", hub.MsgTypeStatus, at(10 * time.Second), false},
+		{"out of order", "gossip-test-a", hub.MsgTypeStatus, at(20 * time.Second), false},
+		{"newer data", "gossip-test-a", hub.MsgTypeStatus, at(5 * time.Second), true},
+		{"other message type", "gossip-test-a", hub.MsgTypeAnnouncement, at(10 * time.Second), true},
+		{"other hub", "gossip-test-b", hub.MsgTypeStatus, at(10 * time.Second), true},
+		{"from the future", "gossip-test-c", hub.MsgTypeStatus, at(-time.Minute), false},
+		{"too old", "gossip-test-c", hub.MsgTypeStatus, at(maxGossipPropagationDelay + time.Minute), false},
+		{"after ignored data", "gossip-test-c", hub.MsgTypeStatus, at(time.Minute), true},
+	} {
+		if recorded := checkGossipPropagation(test.hubID, test.msgType, test.timestamp, now); recorded != test.recorded {
+			t.Errorf("%s: expected %v, got %v", test.name, test.recorded, recorded)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/safing/portbase/updater"
 	"github.com/safing/portmaster/updates"
 	"github.com/safing/spn/conf"
+	"github.com/safing/spn/docks"
 	"github.com/safing/spn/hub"
 	"github.com/safing/spn/navigator"
 	"github.com/safing/spn/ships"
@@ -61,7 +62,13 @@ func updateSPNIntel(ctx context.Context, _ interface{}) (err error) {
 	}
 
 	setVirtualNetworkConfig(intel.VirtualNetworks)
-	return navigator.Main.UpdateIntel(intel)
+	if err := navigator.Main.UpdateIntel(intel); err != nil {
+		return err
+	}
+
+	// Block discontinued Hubs. This also tears down existing cranes to them.
+	docks.SetHubBlocklist(docks.BlocklistSourceIntel, intel.DiscontinuedHubs)
+	return nil
 }
 
 func resetSPNIntel() {
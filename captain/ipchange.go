@@ -0,0 +1,219 @@
+package captain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/netenv"
+
+	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/clock"
+)
+
+const (
+	// IPDetectionAssigned detects the external IPs from the global addresses
+	// assigned to the local network interfaces.
+	IPDetectionAssigned = "assigned"
+
+	// IPDetectionManual disables IP detection. Changed IPs must be configured
+	// manually.
+	IPDetectionManual = "manual"
+
+	// ipChangeCheckInterval defines how often the external IPs are checked.
+	ipChangeCheckInterval = 1 * time.Minute
+	// ipChangeStableDuration defines how long newly detected IPs must stay the
+	// same before they are announced. This prevents announcing IPs that are
+	// flapping.
+	ipChangeStableDuration = 2 * time.Minute
+	// ipChangeMinAnnounceInterval defines the minimum interval between
+	// announcements triggered by IP changes.
+	ipChangeMinAnnounceInterval = 10 * time.Minute
+)
+
+// IPDetector detects the current external IPs of the Hub. Either IP may be nil
+// if the Hub does not have an external IP of that version.
+type IPDetector func(ctx context.Context) (ipv4, ipv6 net.IP, err error)
+
+var (
+	ipDetectors = map[string]IPDetector{
+		IPDetectionAssigned: detectAssignedIPs,
+	}
+	ipDetectorsLock sync.RWMutex
+
+	ipChangeLock         sync.Mutex
+	ipChangeCandidate4   net.IP
+	ipChangeCandidate6   net.IP
+	ipChangeCandidateAt  time.Time
+	ipChangeLastAnnounce time.Time
+	// ipChangeApplied4 and ipChangeApplied6 hold the last IPs that a change was
+	// acted on. They may differ from the announced IPs, eg. if IPs are
+	// configured, and must not trigger another announcement.
+	ipChangeApplied4 net.IP
+	ipChangeApplied6 net.IP
+)
+
+// RegisterIPDetector registers an additional IP detection method, such as a
+// STUN-like probe or a query to a configured provider. The method can then be
+// selected via the IP detection config option.
+func RegisterIPDetector(method string, detector IPDetector) error {
+	ipDetectorsLock.Lock()
+	defer ipDetectorsLock.Unlock()
+
+	switch {
+	case method == "" || method == IPDetectionManual:
+		return fmt.Errorf("invalid IP detection method name %q", method)
+	case detector == nil:
+		return errors.New("missing IP detector")
+	}
+	if _, ok := ipDetectors[method]; ok {
+		return fmt.Errorf("IP detection method %q already registered", method)
+	}
+
+	ipDetectors[method] = detector
+	return nil
+}
+
+func getIPDetector(method string) (detector IPDetector, ok bool) {
+	ipDetectorsLock.RLock()
+	defer ipDetectorsLock.RUnlock()
+
+	detector, ok = ipDetectors[method]
+	return
+}
+
+func detectAssignedIPs(_ context.Context) (ipv4, ipv6 net.IP, err error) {
+	v4IPs, v6IPs, err := netenv.GetAssignedGlobalAddresses()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only use the IPs if they are unambiguous.
+	if len(v4IPs) == 1 {
+		ipv4 = v4IPs[0]
+	}
+	if len(v6IPs) == 1 {
+		ipv6 = v6IPs[0]
+	}
+	return ipv4, ipv6, nil
+}
+
+func startIPChangeDetection() {
+	newManagedTask("detect ip changes", checkForIPChange).
+		Repeat(ipChangeCheckInterval).
+		Schedule(time.Now().Add(ipChangeCheckInterval))
+}
+
+func checkForIPChange(ctx context.Context, task *modules.Task) error {
+	method := cfgOptionIPDetection()
+	if method == IPDetectionManual {
+		return nil
+	}
+	detector, ok := getIPDetector(method)
+	if !ok {
+		log.Warningf("spn/captain: unknown ip detection method %q", method)
+		return nil
+	}
+
+	// Detect current IPs.
+	ipv4, ipv6, err := detector(ctx)
+	if err != nil {
+		log.Debugf("spn/captain: failed to detect external ips using %s: %s", method, err)
+		return nil
+	}
+	if ipv4 == nil && ipv6 == nil {
+		return nil
+	}
+
+	// Compare to the currently announced IPs.
+	publicIdentity.Lock()
+	announcedIPv4 := publicIdentity.Hub.Info.IPv4
+	announcedIPv6 := publicIdentity.Hub.Info.IPv6
+	publicIdentity.Unlock()
+
+	if !ipChanged(announcedIPv4, ipv4) && !ipChanged(announcedIPv6, ipv6) {
+		resetIPChangeCandidate()
+		return nil
+	}
+
+	if !ipChangeReady(ipv4, ipv6, clock.Now()) {
+		return nil
+	}
+
+	// Apply new IPs and update the announcement immediately.
+	log.Infof(
+		"spn/captain: detected external ip change from %s/%s to %s/%s, re-announcing",
+		announcedIPv4, announcedIPv6, ipv4, ipv6,
+	)
+	cabin.SetDetectedIPs(ipv4, ipv6)
+	return maintainPublicIdentity(ctx, nil)
+}
+
+// ipChanged returns whether the detected IP differs from the announced one.
+// An IP that was not detected is not regarded as a change.
+func ipChanged(announced, detected net.IP) bool {
+	return detected != nil && !detected.Equal(announced)
+}
+
+// ipChangeReady records the detected IPs as a change candidate and returns
+// whether the change is stable enough and allowed to be announced now.
+// A change is only reported once, until different IPs are detected.
+func ipChangeReady(ipv4, ipv6 net.IP, now time.Time) bool {
+	ipChangeLock.Lock()
+	defer ipChangeLock.Unlock()
+
+	// Ignore changes that were already acted on.
+	if ipChangeApplied4 != nil || ipChangeApplied6 != nil {
+		if ipv4.Equal(ipChangeApplied4) && ipv6.Equal(ipChangeApplied6) {
+			return false
+		}
+	}
+
+	// Start over if the candidate changed.
+	if ipChangeCandidateAt.IsZero() ||
+		!ipv4.Equal(ipChangeCandidate4) ||
+		!ipv6.Equal(ipChangeCandidate6) {
+		ipChangeCandidate4 = ipv4
+		ipChangeCandidate6 = ipv6
+		ipChangeCandidateAt = now
+		return false
+	}
+
+	// Wait for the IPs to be stable.
+	if now.Sub(ipChangeCandidateAt) < ipChangeStableDuration {
+		return false
+	}
+
+	// Rate limit announcements.
+	if !ipChangeLastAnnounce.IsZero() &&
+		now.Sub(ipChangeLastAnnounce) < ipChangeMinAnnounceInterval {
+		return false
+	}
+
+	// Clear the change state, as the change is now acted on.
+	ipChangeLastAnnounce = now
+	ipChangeApplied4 = ipv4
+	ipChangeApplied6 = ipv6
+	ipChangeCandidate4 = nil
+	ipChangeCandidate6 = nil
+	ipChangeCandidateAt = time.Time{}
+	return true
+}
+
+// resetIPChangeCandidate clears the change state when the detected IPs match
+// the announced ones.
+func resetIPChangeCandidate() {
+	ipChangeLock.Lock()
+	defer ipChangeLock.Unlock()
+
+	ipChangeCandidate4 = nil
+	ipChangeCandidate6 = nil
+	ipChangeCandidateAt = time.Time{}
+	ipChangeApplied4 = nil
+	ipChangeApplied6 = nil
+}
@@ -0,0 +1,65 @@
+package captain
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIPChangeReady(t *testing.T) {
+	resetIPChangeCandidate()
+	ipChangeLastAnnounce = time.Time{}
+	defer resetIPChangeCandidate()
+
+	ip1 := net.IPv4(192, 0, 2, 1)
+	ip2 := net.IPv4(192, 0, 2, 2)
+	ip6 := net.ParseIP("2001:db8::1")
+	now := time.Now()
+
+	// A new change must first be stable.
+	if ipChangeReady(ip1, ip6, now) {
+		t.Fatal("new change should not be ready")
+	}
+	if ipChangeReady(ip1, ip6, now.Add(ipChangeStableDuration/2)) {
+		t.Fatal("change should not be ready before being stable")
+	}
+
+	// A different candidate starts over.
+	if ipChangeReady(ip2, ip6, now.Add(ipChangeStableDuration)) {
+		t.Fatal("changed candidate should not be ready")
+	}
+	now = now.Add(ipChangeStableDuration)
+	if !ipChangeReady(ip2, ip6, now.Add(ipChangeStableDuration)) {
+		t.Fatal("stable change should be ready")
+	}
+	now = now.Add(ipChangeStableDuration)
+
+	// A change is only acted on once, even if it is never announced, eg. because
+	// IPs are configured.
+	for i := 1; i <= 3; i++ {
+		if ipChangeReady(ip2, ip6, now.Add(time.Duration(i)*ipChangeMinAnnounceInterval)) {
+			t.Fatal("change should only be ready once")
+		}
+	}
+
+	// Further changes are rate limited.
+	if ipChangeReady(ip1, ip6, now) {
+		t.Fatal("new change should not be ready")
+	}
+	if ipChangeReady(ip1, ip6, now.Add(ipChangeStableDuration)) {
+		t.Fatal("change should be rate limited")
+	}
+	if !ipChangeReady(ip1, ip6, now.Add(ipChangeMinAnnounceInterval)) {
+		t.Fatal("change should be ready after the rate limit")
+	}
+	now = now.Add(ipChangeMinAnnounceInterval)
+
+	// After the announced IPs match again, the same change is acted on again.
+	resetIPChangeCandidate()
+	if ipChangeReady(ip1, ip6, now) {
+		t.Fatal("new change should not be ready")
+	}
+	if !ipChangeReady(ip1, ip6, now.Add(ipChangeMinAnnounceInterval)) {
+		t.Fatal("change should be ready again after being reset")
+	}
+}
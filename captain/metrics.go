@@ -0,0 +1,36 @@
+package captain
+
+import (
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/metrics"
+	"github.com/tevino/abool"
+)
+
+var (
+	gossipRelaySkippedCongested *metrics.Counter
+
+	metricsRegistered = abool.New()
+)
+
+func registerMetrics() (err error) {
+	// Only register metrics once.
+	if !metricsRegistered.SetToIf(false, true) {
+		return nil
+	}
+
+	gossipRelaySkippedCongested, err = metrics.NewCounter(
+		"spn/gossip/relay/skipped/total",
+		map[string]string{
+			"reason": "congested",
+		},
+		&metrics.Options{
+			Name:       "SPN Gossip Relays Skipped",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -47,7 +47,8 @@ func init() {
 }
 
 func prep() error {
-	// Check if we can parse the bootstrap hub flag.
+	// Apply bootstrap environment variables and check if we can parse the
+	// bootstrap hub flag.
 	if err := prepBootstrapHubFlag(); err != nil {
 		return err
 	}
@@ -57,6 +58,16 @@ func prep() error {
 		return err
 	}
 
+	// Register metrics.
+	if err := registerMetrics(); err != nil {
+		return err
+	}
+
+	// Register task API.
+	if err := registerTaskAPI(); err != nil {
+		return err
+	}
+
 	if conf.PublicHub() {
 		// Register API authenticator.
 		if err := api.SetAuthenticator(apiAuthenticator); err != nil {
@@ -81,6 +92,45 @@ func start() error {
 	if err := registerIntelUpdateHook(); err != nil {
 		return err
 	}
+	if err := registerHubBlocklistHook(); err != nil {
+		return err
+	}
+	if err := registerFlowSyncChecksHook(); err != nil {
+		return err
+	}
+	if err := registerFlowWindowHook(); err != nil {
+		return err
+	}
+	if err := registerFlowTracingHook(); err != nil {
+		return err
+	}
+	if err := registerZoneConfigFileHook(); err != nil {
+		return err
+	}
+	if err := registerQuarantineHook(); err != nil {
+		return err
+	}
+	if err := registerCraneRecorderHook(); err != nil {
+		return err
+	}
+	if conf.PublicHub() {
+		if err := registerTrustedLinksHook(); err != nil {
+			return err
+		}
+	} else {
+		if err := registerTransportPolicyHook(); err != nil {
+			return err
+		}
+		if err := registerIssuerEndpointsHook(); err != nil {
+			return err
+		}
+		if err := registerRefillConcurrencyHook(); err != nil {
+			return err
+		}
+		if err := registerTokenIssuerBreakerHook(); err != nil {
+			return err
+		}
+	}
 	if err := updateSPNIntel(module.Ctx, nil); err != nil {
 		log.Errorf("spn/captain: failed to update SPN intel: %s", err)
 	}
@@ -94,6 +144,10 @@ func start() error {
 		if err := prepPublicIdentityMgmt(); err != nil {
 			return err
 		}
+		if err := enableTokenReceipts(); err != nil {
+			return err
+		}
+		startIPChangeDetection()
 		if err := startPierMgmt(); err != nil {
 			return err
 		}
@@ -115,14 +169,23 @@ func start() error {
 
 	// network optimizer
 	if conf.PublicHub() {
-		module.NewTask("optimize network", optimizeNetwork).
+		newManagedTask("optimize network", optimizeNetwork).
 			Repeat(1 * time.Minute).
 			Schedule(time.Now().Add(15 * time.Second))
 	}
 
+	// crane and route pre-warming
+	startCranePrewarming()
+
+	// live transports of connected Hubs
+	newManagedTask("refresh live transports", refreshLiveTransports).
+		Repeat(liveTransportsRefreshInterval).
+		Schedule(time.Now().Add(liveTransportsRefreshInterval))
+
 	// client + home hub manager
 	if conf.Client() {
 		module.StartServiceWorker("client manager", 0, clientManager)
+		startHomeTerminalLatencyMeasurement()
 	}
 
 	return nil
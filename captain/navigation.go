@@ -12,6 +12,7 @@ import (
 	"github.com/safing/portmaster/netenv"
 	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/spn/access"
+	"github.com/safing/spn/clock"
 	"github.com/safing/spn/docks"
 	"github.com/safing/spn/hub"
 	"github.com/safing/spn/navigator"
@@ -45,6 +46,11 @@ managing:
 				log.Info("spn/captain: client not ready")
 			}
 
+			// Report the lost connection to the previous Home Hub.
+			if home != nil && homeTerminal != nil {
+				reportHomeHubLoss(navigator.Main.ReportHubFailure, home.Hub.ID, homeTerminal.AbandonErr())
+			}
+
 			resetSPNStatus(StatusConnecting)
 			err = establishHomeHub(ctx)
 			if err != nil {
@@ -95,7 +101,7 @@ managing:
 				}
 				spnStatus.ConnectedTransport = homeTerminal.Transport().String()
 
-				now := time.Now()
+				now := clock.Now()
 				spnStatus.ConnectedSince = &now
 
 				// Push new status.
@@ -117,6 +123,19 @@ managing:
 	return nil
 }
 
+// reportHomeHubLoss reports a failure of the previous Home Hub, if the home
+// terminal ended because of the Hub. It returns whether a failure was
+// reported.
+func reportHomeHubLoss(report func(hubID string), hubID string, abandonErr *terminal.Error) (reported bool) {
+	if !isHubTerminalFailure(abandonErr) {
+		log.Debugf("spn/captain: lost connection to home hub %s without hub failure: %s", hubID, abandonErr)
+		return false
+	}
+
+	report(hubID)
+	return true
+}
+
 func establishHomeHub(ctx context.Context) error {
 	// Get own IP.
 	locations, ok := netenv.GetInternetLocation()
@@ -159,7 +178,11 @@ findCandidates:
 				return err
 			}
 			log.Debugf("spn/captain: failed to connect to %s as new home: %s", candidate, err)
+			if isHubFailure(err) {
+				navigator.Main.ReportHubFailure(candidate.ID)
+			}
 		} else {
+			navigator.Main.ReportHubSuccess(candidate.ID)
 			log.Infof("spn/captain: established connection to %s as new home with %d failed tries", candidate, tries)
 			return nil
 		}
@@ -283,7 +306,13 @@ optimize:
 			crane, tErr := EstablishPublicLane(ctx, connectTo.Hub)
 			if !tErr.IsOK() {
 				log.Warningf("spn/captain: failed to establish lane to %s: %s", connectTo.Hub, tErr)
+				// Only report failures to connect to the Hub, as failed local
+				// checks, such as the reachability check, are not its fault.
+				if isHubFailure(tErr) {
+					navigator.Main.ReportHubFailure(connectTo.Hub.ID)
+				}
 			} else {
+				navigator.Main.ReportHubSuccess(connectTo.Hub.ID)
 				createdConnections++
 				crane.NetState.UpdateLastSuggestedAt()
 
@@ -309,14 +338,14 @@ optimize:
 
 	// Retire cranes if unsuggested for a while.
 	if result.StopOthers {
-		for _, crane := range docks.GetAllAssignedCranes() {
+		for _, crane := range docks.GetAllAssignedCranesSorted() {
 			switch {
 			case !crane.IsMine():
 				// Skip cranes built by others.
 			case crane.Stopped() || crane.IsStopping():
 				// Skip cranes that are stopped or stopping.
 			case crane.NetState.LastSuggestedAt().After(
-				time.Now().Add(-stopCraneAfterBeingUnsuggestedFor),
+				clock.Now().Add(-stopCraneAfterBeingUnsuggestedFor),
 			):
 				// Skip cranes that were recently suggested.
 			default:
@@ -329,5 +358,49 @@ optimize:
 		}
 	}
 
+	// Retire unsuggested cranes immediately if there are more lanes than
+	// allowed.
+	if result.MaxLanes > 0 {
+		retireCranesAboveMaxLanes(result)
+	}
+
 	return nil
 }
+
+// retireCranesAboveMaxLanes marks own cranes that are not suggested as
+// stopping until the lanes are within the max lanes of the result.
+// Lanes built by others are counted, but can only be retired by them.
+func retireCranesAboveMaxLanes(result *navigator.OptimizationResult) {
+	suggested := make(map[string]struct{}, len(result.SuggestedConnections))
+	for _, sc := range result.SuggestedConnections {
+		suggested[sc.Hub.ID] = struct{}{}
+	}
+
+	cranes := docks.GetAllAssignedCranesSorted()
+	lanes := countActiveLanes()
+
+	for _, crane := range cranes {
+		if lanes <= result.MaxLanes {
+			return
+		}
+
+		switch {
+		case !crane.IsMine():
+			// Skip cranes built by others.
+		case crane.Stopped() || crane.IsStopping():
+			// Skip cranes that are stopped or stopping.
+		case crane.ConnectedHub == nil:
+			// Skip cranes that are not connected to a Hub.
+		default:
+			if _, ok := suggested[crane.ConnectedHub.ID]; ok {
+				// Skip cranes that are suggested.
+				continue
+			}
+			if crane.MarkStopping() {
+				log.Infof("spn/captain: retiring %s, exceeds max lanes of %d", crane, result.MaxLanes)
+				crane.NotifyUpdate()
+				lanes--
+			}
+		}
+	}
+}
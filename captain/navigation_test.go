@@ -0,0 +1,39 @@
+package captain
+
+import (
+	"testing"
+
+	"github.com/safing/spn/terminal"
+)
+
+func TestReportHomeHubLoss(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name   string
+		err    *terminal.Error
+		report bool
+	}{
+		{"no error", nil, false},
+		{"local stop", terminal.ErrStopping.With("connection closed"), false},
+		{"local ship sunk", terminal.ErrShipSunk.With("failed to load shipment"), false},
+		{"local timeout", terminal.ErrTimeout.With("no activity"), false},
+		{"remote stop", terminal.ErrStopping.AsExternal(), false},
+		{"remote internal error", terminal.ErrInternalError.AsExternal(), true},
+		{"remote hub unavailable", terminal.ErrHubUnavailable.AsExternal(), true},
+	} {
+		var reported []string
+		ok := reportHomeHubLoss(func(hubID string) {
+			reported = append(reported, hubID)
+		}, "hub", test.err)
+
+		switch {
+		case ok != test.report:
+			t.Errorf("%s: expected reported=%v, got %v", test.name, test.report, ok)
+		case test.report && (len(reported) != 1 || reported[0] != "hub"):
+			t.Errorf("%s: expected failure of hub to be reported, got %v", test.name, reported)
+		case !test.report && len(reported) != 0:
+			t.Errorf("%s: expected no failure to be reported, got %v", test.name, reported)
+		}
+	}
+}
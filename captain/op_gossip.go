@@ -1,6 +1,7 @@
 package captain
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/safing/portbase/container"
@@ -14,11 +15,16 @@ import (
 
 const GossipOpType string = "gossip"
 
+// GossipCombinedMsgFeature is the feature capability of Hubs that accept the
+// combined announcement and status gossip message.
+const GossipCombinedMsgFeature = "gossip/combined"
+
 type GossipMsgType uint8
 
 const (
-	GossipHubAnnouncementMsg GossipMsgType = 1
-	GossipHubStatusMsg       GossipMsgType = 2
+	GossipHubAnnouncementMsg          GossipMsgType = 1
+	GossipHubStatusMsg                GossipMsgType = 2
+	GossipHubAnnouncementAndStatusMsg GossipMsgType = 3
 )
 
 func (msgType GossipMsgType) String() string {
@@ -27,6 +33,8 @@ func (msgType GossipMsgType) String() string {
 		return "hub announcement"
 	case GossipHubStatusMsg:
 		return "hub status"
+	case GossipHubAnnouncementAndStatusMsg:
+		return "hub announcement and status"
 	default:
 		return "unknown gossip msg"
 	}
@@ -48,6 +56,7 @@ func init() {
 		Requires: terminal.IsCraneController,
 		RunOp:    runGossipOp,
 	})
+	hub.RegisterLocalCapability(hub.FeatureCapability(GossipCombinedMsgFeature))
 }
 
 func NewGossipOp(controller *docks.CraneControllerTerminal) (*GossipOp, *terminal.Error) {
@@ -83,6 +92,16 @@ func runGossipOp(t terminal.OpTerminal, opID uint32, data *container.Container)
 	return op, nil
 }
 
+// supportsCombinedMsg returns whether the connected Hub accepts the combined
+// announcement and status gossip message.
+func (op *GossipOp) supportsCombinedMsg() bool {
+	connectedHub := op.controller.Crane.ConnectedHub
+	if connectedHub == nil {
+		return false
+	}
+	return connectedHub.GetInfo().HasCapability(hub.FeatureCapability(GossipCombinedMsgFeature))
+}
+
 func (op *GossipOp) sendMsg(msgType GossipMsgType, data []byte) {
 	c := container.New(
 		varint.Pack8(uint8(msgType)),
@@ -109,6 +128,12 @@ func (op *GossipOp) Deliver(c *container.Container) *terminal.Error {
 		announcementData = data
 	case GossipHubStatusMsg:
 		statusData = data
+	case GossipHubAnnouncementAndStatusMsg:
+		var parseErr error
+		announcementData, statusData, parseErr = parseCombinedGossipMsg(data)
+		if parseErr != nil {
+			return terminal.ErrMalformedData.With("failed to parse %s: %w", gossipMsgType, parseErr)
+		}
 	default:
 		log.Warningf("spn/captain: received unknown gossip message type from %s: %d", op.controller.Crane.ID, gossipMsgType)
 		return nil
@@ -125,6 +150,9 @@ func (op *GossipOp) Deliver(c *container.Container) *terminal.Error {
 	} else if forward {
 		// Only log if we received something to save/forward.
 		log.Infof("spn/captain: received %s for %s", gossipMsgType, h)
+
+		// Record how long the new data took to get here.
+		reportGossipPropagation(h, announcementData != nil, statusData != nil)
 	}
 
 	// Relay data.
@@ -134,6 +162,30 @@ func (op *GossipOp) Deliver(c *container.Container) *terminal.Error {
 	return nil
 }
 
+// packCombinedGossipMsg packs an announcement and a status into the data of a
+// combined gossip message.
+func packCombinedGossipMsg(announcementData, statusData []byte) []byte {
+	c := container.New()
+	c.AppendAsBlock(announcementData)
+	c.AppendAsBlock(statusData)
+	return c.CompileData()
+}
+
+// parseCombinedGossipMsg splits the data of a combined gossip message into the
+// announcement and the status.
+func parseCombinedGossipMsg(data []byte) (announcementData, statusData []byte, err error) {
+	c := container.New(data)
+	announcementData, err = c.GetNextBlock()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get announcement: %w", err)
+	}
+	statusData, err = c.GetNextBlock()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get status: %w", err)
+	}
+	return announcementData, statusData, nil
+}
+
 func (op *GossipOp) End(err *terminal.Error) {
 	deleteGossipOp(op.controller.Crane.ID)
 }
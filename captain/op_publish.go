@@ -80,6 +80,15 @@ func runPublishOp(t terminal.OpTerminal, opID uint32, data *container.Container)
 	if err != nil {
 		return nil, terminal.ErrMalformedData.With("failed to get status: %w", err)
 	}
+	// Reject blocked Hubs before importing and verifying them.
+	// The signer ID is verified by the import.
+	hubID, err := hub.GetHubMsgSignerID(announcementData)
+	if err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to get hub ID from announcement: %w", err)
+	}
+	if tErr := docks.CheckHubAdmission(hubID); tErr != nil {
+		return nil, tErr
+	}
 	h, forward, tErr := docks.ImportAndVerifyHubInfo(module.Ctx, "", announcementData, statusData, conf.MainMapName, conf.MainMapScope)
 	if tErr != nil {
 		return nil, tErr.Wrap("failed to import and verify hub")
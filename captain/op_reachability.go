@@ -0,0 +1,229 @@
+package captain
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/ships"
+	"github.com/safing/spn/terminal"
+)
+
+// ReachabilityCheckOpType is the type name of the reachability check
+// operation.
+const ReachabilityCheckOpType string = "transport/reachability"
+
+const (
+	// maxReachabilityCheckTransports defines how many transports may be
+	// checked with one request.
+	maxReachabilityCheckTransports = 16
+
+	// reachabilityDialTimeout defines how long the connection attempt to a
+	// single transport may take.
+	reachabilityDialTimeout = 5 * time.Second
+)
+
+// ReachabilityCheckRequest asks the connected Hub to connect back to the
+// given transports of the requesting Hub at its announced IPs.
+type ReachabilityCheckRequest struct {
+	Transports []string
+	// IPv4 and IPv6 are the IPs announced by the requesting Hub. They are only
+	// checked if they match the address the request was received from.
+	IPv4 net.IP `json:",omitempty"`
+	IPv6 net.IP `json:",omitempty"`
+}
+
+// ReachabilityCheckResponse holds the results of a reachability check.
+type ReachabilityCheckResponse struct {
+	Results []TransportReachability
+}
+
+// TransportReachability is the result of the reachability check of a single
+// transport.
+type TransportReachability struct {
+	Transport string
+	Reachable bool
+	Error     string `json:",omitempty"`
+}
+
+// ReachabilityCheckOp connects back to the requesting Hub on the requested
+// transports and reports the results.
+type ReachabilityCheckOp struct {
+	terminal.OpBase
+}
+
+// Type returns the type ID.
+func (op *ReachabilityCheckOp) Type() string {
+	return ReachabilityCheckOpType
+}
+
+func init() {
+	terminal.RegisterOpType(terminal.OpParams{
+		Type:     ReachabilityCheckOpType,
+		Requires: terminal.IsCraneController,
+		RunOp:    runReachabilityCheckOp,
+	})
+}
+
+// CheckReachability asks the Hub connected via the given controller to connect
+// back to the given transports and returns the results.
+func CheckReachability(controller *docks.CraneControllerTerminal, transports []string, ipv4, ipv6 net.IP) ([]TransportReachability, *terminal.Error) {
+	if len(transports) > maxReachabilityCheckTransports {
+		return nil, terminal.ErrInvalidOptions.With(
+			"cannot check more than %d transports, got %d",
+			maxReachabilityCheckTransports,
+			len(transports),
+		)
+	}
+
+	response := &ReachabilityCheckResponse{}
+	op, tErr := terminal.NewRequestResponseOp(
+		controller,
+		ReachabilityCheckOpType,
+		&ReachabilityCheckRequest{
+			Transports: transports,
+			IPv4:       ipv4,
+			IPv6:       ipv6,
+		},
+		response,
+	)
+	if tErr != nil {
+		return nil, tErr
+	}
+	// Give the other Hub enough time to try all transports.
+	if tErr := op.Wait(time.Duration(len(transports)+2) * reachabilityDialTimeout); tErr != nil {
+		return nil, tErr
+	}
+
+	return response.Results, nil
+}
+
+func runReachabilityCheckOp(t terminal.OpTerminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
+	// Check if we are run by a controller.
+	controller, ok := t.(*docks.CraneControllerTerminal)
+	if !ok {
+		return nil, terminal.ErrIncorrectUsage.With("reachability check op may only be started by a crane controller terminal, but was started by %T", t)
+	}
+
+	// Only public Hubs check reachability for others.
+	if !conf.PublicHub() {
+		return nil, terminal.ErrIncorrectUsage.With("not a public hub")
+	}
+
+	// Load request.
+	request := &ReachabilityCheckRequest{}
+	if _, err := dsd.Load(data.CompileData(), request); err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to load request: %w", err)
+	}
+	if len(request.Transports) > maxReachabilityCheckTransports {
+		return nil, terminal.ErrInvalidOptions.With("too many transports: %d", len(request.Transports))
+	}
+
+	// Only ever connect back to the IP of the requester, so that the check
+	// cannot be abused to connect to others.
+	host, _, err := net.SplitHostPort(controller.Crane.RemoteAddr().String())
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to get remote address: %w", err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, terminal.ErrInternalError.With("failed to parse remote IP %q", host)
+	}
+	ip, err = selectReachabilityCheckIP(ip, request.IPv4, request.IPv6)
+	if err != nil {
+		return nil, terminal.ErrInvalidOptions.With("%w", err)
+	}
+
+	// Create operation.
+	op := &ReachabilityCheckOp{}
+	op.OpBase.Init()
+	op.OpBase.SetID(opID)
+
+	// Check transports in the background, as this takes a while.
+	module.StartWorker("check transport reachability", func(ctx context.Context) error {
+		response := &ReachabilityCheckResponse{
+			Results: make([]TransportReachability, 0, len(request.Transports)),
+		}
+		for _, definition := range request.Transports {
+			response.Results = append(response.Results, checkTransportReachability(ctx, definition, ip))
+		}
+
+		// Send results.
+		responseData, err := dsd.Dump(response, dsd.CBOR)
+		if err != nil {
+			controller.OpEnd(op, terminal.ErrInternalError.With("failed to pack response: %w", err))
+			return nil
+		}
+		if tErr := controller.OpSend(op, container.New(responseData)); tErr != nil {
+			controller.OpEnd(op, tErr.Wrap("failed to send response"))
+			return nil
+		}
+		controller.Flush()
+
+		log.Debugf("spn/captain: checked reachability of %s for %s: %+v", ip, controller.Crane, response.Results)
+		return nil
+	})
+
+	return op, nil
+}
+
+// selectReachabilityCheckIP returns the announced IP that matches the given
+// source IP. If no IPs were announced, the request stems from an older Hub and
+// the source IP is returned.
+func selectReachabilityCheckIP(source, ipv4, ipv6 net.IP) (net.IP, error) {
+	if ipv4 == nil && ipv6 == nil {
+		return source, nil
+	}
+
+	for _, announced := range []net.IP{ipv4, ipv6} {
+		if announced != nil && announced.Equal(source) {
+			return announced, nil
+		}
+	}
+	return nil, fmt.Errorf("announced IPs do not match source address %s", source)
+}
+
+// checkTransportReachability connects to the given transport at the given IP.
+func checkTransportReachability(ctx context.Context, definition string, ip net.IP) TransportReachability {
+	result := TransportReachability{
+		Transport: definition,
+	}
+
+	transport, err := hub.ParseTransport(definition)
+	if err != nil {
+		result.Error = "invalid transport: " + err.Error()
+		return result
+	}
+	builder := ships.GetBuilder(transport.Protocol)
+	if builder == nil {
+		result.Error = "unsupported protocol " + transport.Protocol
+		return result
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, reachabilityDialTimeout)
+	defer cancel()
+	ship, err := builder.LaunchShip(dialCtx, transport, ip)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	ship.Sink()
+
+	result.Reachable = true
+	return result
+}
+
+// Deliver delivers a message to the operation.
+func (op *ReachabilityCheckOp) Deliver(c *container.Container) *terminal.Error {
+	return terminal.ErrIncorrectUsage.With("unexpected data")
+}
+
+// End ends the operation.
+func (op *ReachabilityCheckOp) End(tErr *terminal.Error) {}
@@ -0,0 +1,173 @@
+package captain
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/terminal"
+)
+
+const TransportProbeOpType string = "transport/probe"
+
+// liveTransportsRefreshInterval defines how often the live transports of
+// connected Hubs are probed. It must be below docks.LiveTransportsTTL.
+const liveTransportsRefreshInterval = 4 * time.Minute
+
+// TransportProbeOp asks the connected Hub which of its announced transports
+// are currently listening.
+type TransportProbeOp struct {
+	terminal.OpBase
+	controller *docks.CraneControllerTerminal
+
+	transports []string
+	result     chan *terminal.Error
+}
+
+func (op *TransportProbeOp) Type() string {
+	return TransportProbeOpType
+}
+
+func init() {
+	terminal.RegisterOpType(terminal.OpParams{
+		Type:     TransportProbeOpType,
+		Requires: terminal.IsCraneController,
+		RunOp:    runTransportProbeOp,
+	})
+}
+
+// NewTransportProbeOp starts a transport probe on the given controller. When
+// finished, the live transports are cached on the crane.
+func NewTransportProbeOp(controller *docks.CraneControllerTerminal) (*TransportProbeOp, *terminal.Error) {
+	// Create and init.
+	op := &TransportProbeOp{
+		controller: controller,
+		result:     make(chan *terminal.Error, 1),
+	}
+	op.OpBase.Init()
+	tErr := controller.OpInit(op, nil)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	return op, nil
+}
+
+func runTransportProbeOp(t terminal.OpTerminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
+	// Check if we are run by a controller.
+	controller, ok := t.(*docks.CraneControllerTerminal)
+	if !ok {
+		return nil, terminal.ErrIncorrectUsage.With("transport probe op may only be started by a crane controller terminal, but was started by %T", t)
+	}
+
+	// Only public Hubs have piers.
+	if !conf.PublicHub() {
+		return nil, terminal.ErrIncorrectUsage.With("not a public hub")
+	}
+
+	// Create operation.
+	op := &TransportProbeOp{
+		controller: controller,
+	}
+	op.OpBase.Init()
+	op.OpBase.SetID(opID)
+
+	// Reply with the live transports.
+	transports := getLiveTransports()
+	response := container.New(varint.Pack64(uint64(len(transports))))
+	for _, transport := range transports {
+		response.AppendAsBlock([]byte(transport))
+	}
+	tErr := controller.OpSend(op, response)
+	if tErr != nil {
+		return nil, tErr.Wrap("failed to send live transports")
+	}
+
+	return op, nil
+}
+
+func (op *TransportProbeOp) Deliver(c *container.Container) *terminal.Error {
+	// Only the client receives data.
+	if op.result == nil {
+		return terminal.ErrIncorrectUsage.With("unexpected data")
+	}
+
+	// Parse live transports.
+	n, err := c.GetNextN64()
+	if err != nil {
+		return terminal.ErrMalformedData.With("failed to get transport count: %w", err)
+	}
+	if n > 255 {
+		return terminal.ErrMalformedData.With("too many transports: %d", n)
+	}
+	transports := make([]string, 0, n)
+	for i := uint64(0); i < n; i++ {
+		transport, err := c.GetNextBlock()
+		if err != nil {
+			return terminal.ErrMalformedData.With("failed to get transport: %w", err)
+		}
+		if _, err := hub.ParseTransport(string(transport)); err != nil {
+			return terminal.ErrMalformedData.With("invalid transport %q: %w", transport, err)
+		}
+		transports = append(transports, string(transport))
+	}
+
+	// Cache on crane and finish.
+	op.transports = transports
+	op.controller.Crane.SetLiveTransports(transports)
+	log.Debugf("spn/captain: %s reported live transports %v", op.controller.Crane, transports)
+
+	op.controller.OpEnd(op, nil)
+	return nil
+}
+
+func (op *TransportProbeOp) End(tErr *terminal.Error) {
+	if op.result != nil {
+		select {
+		case op.result <- tErr:
+		default:
+		}
+	}
+}
+
+// Transports returns the reported live transports after the op finished
+// successfully.
+func (op *TransportProbeOp) Transports() []string {
+	return op.transports
+}
+
+// Result returns the result (end error) of the operation.
+func (op *TransportProbeOp) Result() <-chan *terminal.Error {
+	return op.result
+}
+
+// startTransportProbe probes the live transports of the Hub connected to the
+// given crane, if the Hub supports it.
+func startTransportProbe(crane *docks.Crane) {
+	if !crane.ConnectedHub.GetInfo().HasCapability(hub.OpCapability(TransportProbeOpType)) {
+		return
+	}
+
+	if _, tErr := NewTransportProbeOp(crane.Controller); tErr != nil {
+		log.Debugf("spn/captain: failed to start transport probe op on %s: %s", crane, tErr)
+	}
+}
+
+// refreshLiveTransports probes the live transports of all connected Hubs, so
+// that the cached transports do not expire while connected.
+func refreshLiveTransports(_ context.Context, _ *modules.Task) error {
+	for _, crane := range docks.GetAllAssignedCranes() {
+		if crane.Stopped() || crane.IsStopping() || crane.Controller == nil {
+			continue
+		}
+		startTransportProbe(crane)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package captain
 
 import (
 	"context"
+	"sort"
 	"sync"
 
 	"github.com/safing/spn/docks"
@@ -13,22 +14,27 @@ import (
 )
 
 var (
-	managePiersTask *modules.Task
+	managePiersTask *managedTask
 	pierMgmtLock    sync.Mutex
 	pierMgmtCycleID int
 
 	dockingRequests = make(chan *ships.DockingRequest, 10)
+
+	// livePiers holds the transport definitions of the piers that are
+	// currently listening.
+	livePiers     = make(map[ships.Pier]string)
+	livePiersLock sync.Mutex
 )
 
 func startPierMgmt() error {
-	managePiersTask = module.NewTask(
+	managePiersTask = newManagedTask(
 		"manage piers",
 		managePiers,
 	)
 
 	module.StartServiceWorker("docking request handler", 0, dockingRequestHandler)
 
-	err := managePiers(module.Ctx, managePiersTask)
+	err := managePiers(module.Ctx, managePiersTask.task)
 	if err != nil {
 		log.Warningf("spn/captain: failed to initialize piers: %s", err)
 	}
@@ -46,7 +52,7 @@ func managePiers(ctx context.Context, task *modules.Task) error {
 	}
 	pierMgmtCycleID = 1
 
-	for _, t := range publicIdentity.Hub.Info.Transports {
+	for _, t := range publicIdentity.ConfiguredTransports() {
 		transport, err := hub.ParseTransport(t)
 		if err != nil {
 			log.Warningf("spn/captain: cannot build pier for invalid transport %q: %s", t, err)
@@ -60,6 +66,7 @@ func managePiers(ctx context.Context, task *modules.Task) error {
 			continue
 		}
 		log.Infof("spn/captain: pier for transport %q built", t)
+		setPierLive(pier, t)
 
 		// start accepting connections
 		module.StartWorker("pier docking", pier.Docking)
@@ -82,6 +89,7 @@ func dockingRequestHandler(ctx context.Context) error {
 				// TODO: Restart pier?
 				// TODO: Do actual pier management.
 				log.Errorf("spn/captain: pier %s failed: %s", r.Pier.Transport(), r.Err)
+				setPierDown(r.Pier)
 			case r.Ship != nil:
 				if checkDockingPermission(r.Ship) {
 					handleDockingRequest(r.Ship)
@@ -95,7 +103,53 @@ func dockingRequestHandler(ctx context.Context) error {
 	}
 }
 
+func setPierLive(pier ships.Pier, transport string) {
+	livePiersLock.Lock()
+	defer livePiersLock.Unlock()
+
+	livePiers[pier] = transport
+}
+
+func setPierDown(pier ships.Pier) {
+	livePiersLock.Lock()
+	defer livePiersLock.Unlock()
+
+	delete(livePiers, pier)
+}
+
+// getLiveTransports returns the transports of the piers that are currently
+// listening, in the order of the configured transports.
+func getLiveTransports() []string {
+	livePiersLock.Lock()
+	live := make(map[string]struct{}, len(livePiers))
+	for _, transport := range livePiers {
+		live[transport] = struct{}{}
+	}
+	livePiersLock.Unlock()
+
+	transports := make([]string, 0, len(live))
+	for _, transport := range publicIdentity.ConfiguredTransports() {
+		if _, ok := live[transport]; ok {
+			transports = append(transports, transport)
+			delete(live, transport)
+		}
+	}
+
+	// Add live transports that are not configured anymore.
+	remaining := make([]string, 0, len(live))
+	for transport := range live {
+		remaining = append(remaining, transport)
+	}
+	sort.Strings(remaining)
+	return append(transports, remaining...)
+}
+
 func checkDockingPermission(ship ships.Ship) (ok bool) {
+	// Deny quarantined peers.
+	if docks.IsAddrQuarantined(ship.RemoteAddr()) {
+		return false
+	}
+
 	// TODO: check docking policies (hub entry policy)
 	return true
 }
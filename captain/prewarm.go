@@ -0,0 +1,109 @@
+package captain
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/crew"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/navigator"
+)
+
+const (
+	prewarmInterval = 5 * time.Minute
+
+	// prewarmMaxEstablishPerRun limits how many new cranes or routes are
+	// established per pre-warming run, in order to not compete with network
+	// optimization and user connections.
+	prewarmMaxEstablishPerRun = 2
+)
+
+func startCranePrewarming() {
+	newManagedTask("pre-warm cranes", prewarmCranes).
+		Repeat(prewarmInterval).
+		Schedule(time.Now().Add(prewarmInterval))
+}
+
+// prewarmCranes pre-warms connections to the Hubs that were used the most
+// recently. Public Hubs establish or keep alive cranes, clients re-establish
+// the routes they last used.
+func prewarmCranes(ctx context.Context, task *modules.Task) error {
+	size := int(cfgOptionPrewarmCranes())
+	if size <= 0 {
+		return nil
+	}
+	window := time.Duration(cfgOptionPrewarmWindow()) * time.Minute
+	mostUsed := docks.GetMostUsedHubs(window, size)
+
+	switch {
+	case conf.PublicHub() && publicIdentity != nil:
+		prewarmPublicLanes(ctx, mostUsed)
+	case conf.Client():
+		crew.PrewarmRoutes(mostUsed, prewarmMaxEstablishPerRun)
+	}
+
+	return nil
+}
+
+// prewarmPublicLanes establishes or keeps alive cranes to the given Hubs.
+// Pre-warmed cranes are marked as suggested and are retired by the network
+// optimization like any other crane once they are not used anymore.
+// New cranes are only established within the max lanes of this Hub.
+func prewarmPublicLanes(ctx context.Context, hubIDs []string) {
+	maxLanes := navigator.Main.GetHomeHubMaxLanes()
+	lanes := countActiveLanes()
+
+	var established int
+	for _, hubID := range hubIDs {
+		if hubID == publicIdentity.ID {
+			continue
+		}
+
+		// Keep existing cranes alive.
+		if crane := docks.GetAssignedCrane(hubID); crane != nil {
+			crane.NetState.UpdateLastSuggestedAt()
+			if crane.AbortStopping() {
+				log.Infof("spn/captain: pre-warming aborted retiring of %s, removed stopping mark", crane)
+				crane.NotifyUpdate()
+			}
+			continue
+		}
+
+		// Establish new cranes to usable Hubs.
+		if established >= prewarmMaxEstablishPerRun {
+			continue
+		}
+		if maxLanes > 0 && lanes >= maxLanes {
+			// Only keep existing cranes alive when reaching the max lanes.
+			continue
+		}
+		h, ok := navigator.Main.GetUsableHub(hubID)
+		if !ok {
+			continue
+		}
+		established++
+
+		crane, tErr := EstablishPublicLane(ctx, h)
+		if !tErr.IsOK() {
+			log.Debugf("spn/captain: failed to pre-warm lane to %s: %s", h, tErr)
+			continue
+		}
+		lanes++
+		crane.NetState.UpdateLastSuggestedAt()
+		log.Infof("spn/captain: pre-warmed lane to %s", h)
+	}
+}
+
+// countActiveLanes returns the amount of assigned cranes that are neither
+// stopped nor stopping.
+func countActiveLanes() (lanes int) {
+	for _, crane := range docks.GetAllAssignedCranesSorted() {
+		if !crane.Stopped() && !crane.IsStopping() {
+			lanes++
+		}
+	}
+	return lanes
+}
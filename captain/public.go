@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
+	"net/http"
 	"time"
 
+	"github.com/safing/portbase/api"
 	"github.com/safing/portbase/metrics"
 
 	"github.com/safing/spn/conf"
@@ -22,14 +25,22 @@ import (
 const (
 	maintainStatusInterval    = 15 * time.Minute
 	maintainStatusUpdateDelay = 5 * time.Second
+
+	// maintainIdentityUpdateDelay is the base delay for updating the public
+	// identity after a config change.
+	maintainIdentityUpdateDelay = 5 * time.Minute
+	// maintainIdentityUpdateJitter is the maximum random delay added to
+	// maintainIdentityUpdateDelay in order to prevent Hubs that receive the
+	// same config change at the same time from announcing simultaneously.
+	maintainIdentityUpdateJitter = 5 * time.Minute
 )
 
 var (
 	publicIdentity    *cabin.Identity
 	publicIdentityKey = "core:spn/public/identity"
 
-	publicIdentityUpdateTask *modules.Task
-	statusUpdateTask         *modules.Task
+	publicIdentityUpdateTask *managedTask
+	statusUpdateTask         *managedTask
 )
 
 func loadPublicIdentity() (err error) {
@@ -88,28 +99,76 @@ func loadPublicIdentity() (err error) {
 }
 
 func prepPublicIdentityMgmt() error {
-	publicIdentityUpdateTask = module.NewTask(
+	publicIdentityUpdateTask = newManagedTask(
 		"maintain public identity",
 		maintainPublicIdentity,
 	)
 
-	statusUpdateTask = module.NewTask(
+	statusUpdateTask = newManagedTask(
 		"maintain public status",
 		maintainPublicStatus,
 	).Repeat(maintainStatusInterval)
 
+	pendingGossipTask = newManagedTask(
+		"send pending gossip",
+		sendPendingGossip,
+	)
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/publicHub/rotate-keys`,
+		Write:       api.PermitAdmin,
+		WriteMethod: http.MethodPost,
+		BelongsTo:   module,
+		ActionFunc: func(_ *api.Request) (msg string, err error) {
+			if err := RotatePublicHubKeys(); err != nil {
+				return "", err
+			}
+			return "Key rotation started.", nil
+		},
+		Name:        "Rotate Public Hub Keys",
+		Description: "Rotates the exchange keys of the public Hub and publishes them. Previous keys stay valid until they expire.",
+	}); err != nil {
+		return err
+	}
+
 	return module.RegisterEventHook(
 		"config",
 		"config change",
 		"update public identity from config",
 		func(_ context.Context, _ interface{}) error {
-			// trigger update in 5 minutes
-			publicIdentityUpdateTask.Schedule(time.Now().Add(5 * time.Minute))
+			// Trigger update in 5 to 10 minutes.
+			publicIdentityUpdateTask.Schedule(time.Now().Add(
+				withJitter(maintainIdentityUpdateDelay, maintainIdentityUpdateJitter),
+			))
 			return nil
 		},
 	)
 }
 
+// RotatePublicHubKeys rotates the exchange keys of the public Hub. The new
+// keys are published with the status and propagated via gossip. Previous keys
+// stay valid until they expire, so that cranes that are being established with
+// them are not interrupted.
+func RotatePublicHubKeys() error {
+	if publicIdentity == nil || statusUpdateTask == nil {
+		return errors.New("not a public hub")
+	}
+
+	publicIdentity.RequestExchKeyRotation()
+	statusUpdateTask.StartASAP()
+	log.Infof("spn/captain: rotating exchange keys of public hub %s", publicIdentity.ID)
+	return nil
+}
+
+// withJitter returns the given delay with a random duration of up to maxJitter
+// added.
+func withJitter(delay, maxJitter time.Duration) time.Duration {
+	if maxJitter <= 0 {
+		return delay
+	}
+	return delay + time.Duration(rand.Int63n(int64(maxJitter))) //nolint:gosec // Does not need to be secure.
+}
+
 func maintainPublicIdentity(ctx context.Context, task *modules.Task) error {
 	changed, err := publicIdentity.MaintainAnnouncement(false)
 	if err != nil {
@@ -120,6 +179,12 @@ func maintainPublicIdentity(ctx context.Context, task *modules.Task) error {
 		return nil
 	}
 
+	// Update available networks, as the IPs may have changed.
+	conf.SetHubNetworks(
+		publicIdentity.Hub.Info.IPv4 != nil,
+		publicIdentity.Hub.Info.IPv6 != nil,
+	)
+
 	// Update on map.
 	navigator.Main.UpdateHub(publicIdentity.Hub)
 	log.Debug("spn/captain: updated own hub on map after announcement change")
@@ -131,7 +196,7 @@ func maintainPublicIdentity(ctx context.Context, task *modules.Task) error {
 	}
 
 	// forward to other connected Hubs
-	gossipRelayMsg("", GossipHubAnnouncementMsg, announcementData)
+	queueOwnGossip(announcementData, nil)
 
 	// manage docks in order to react to possibly changed transports
 	if managePiersTask != nil {
@@ -143,7 +208,7 @@ func maintainPublicIdentity(ctx context.Context, task *modules.Task) error {
 
 func maintainPublicStatus(ctx context.Context, task *modules.Task) error {
 	// Get current lanes.
-	cranes := docks.GetAllAssignedCranes()
+	cranes := docks.GetAllAssignedCranesSorted()
 	lanes := make([]*hub.Lane, 0, len(cranes))
 	for _, crane := range cranes {
 		// Ignore private, stopped or stopping cranes.
@@ -206,7 +271,7 @@ func maintainPublicStatus(ctx context.Context, task *modules.Task) error {
 	}
 
 	// forward to other connected Hubs
-	gossipRelayMsg("", GossipHubStatusMsg, statusData)
+	queueOwnGossip(nil, statusData)
 
 	log.Infof(
 		"spn/captain: updated status with load %d and current lanes: %v",
@@ -224,6 +289,12 @@ func publishShutdownStatus() {
 		return
 	}
 
+	// Drop pending own gossip, as it would override the offline status.
+	pendingGossipLock.Lock()
+	pendingGossipAnnouncement = nil
+	pendingGossipStatus = nil
+	pendingGossipLock.Unlock()
+
 	// Forward to other connected Hubs.
 	gossipRelayMsg("", GossipHubStatusMsg, offlineStatusData)
 
@@ -0,0 +1,213 @@
+package captain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/clock"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/hub"
+	"github.com/tevino/abool"
+)
+
+const (
+	// ReachabilityCheckOff disables the reachability check.
+	ReachabilityCheckOff = "off"
+
+	// ReachabilityCheckWarn checks the reachability of the announced
+	// transports and logs a warning if they are not reachable.
+	ReachabilityCheckWarn = "warn"
+
+	// ReachabilityCheckEnforce checks the reachability of the announced
+	// transports and refuses to publish the Hub if they are not reachable.
+	ReachabilityCheckEnforce = "enforce"
+)
+
+// ErrUnreachable is returned when the announced transports of the Hub are not
+// reachable and the reachability check is enforced.
+var ErrUnreachable = errors.New("announced transports are not reachable")
+
+// ReachabilityStatus is the result of the last reachability check.
+type ReachabilityStatus struct {
+	Mode string
+
+	// Verified is set if all announced transports were reachable.
+	Verified bool
+	// CheckedAt is the time of the last check.
+	CheckedAt time.Time `json:",omitempty"`
+	// CheckedBy is the ID of the Hub that performed the last check.
+	CheckedBy string `json:",omitempty"`
+	// Error holds the error of the last check, if it could not be performed.
+	Error string `json:",omitempty"`
+	// Transports holds the results of the last check.
+	Transports []TransportReachability `json:",omitempty"`
+
+	// checkedTransports holds the transports that were checked.
+	checkedTransports []string
+}
+
+// reachabilityFailureCacheDuration defines how long a failed reachability
+// check is not repeated for the same transports.
+const reachabilityFailureCacheDuration = time.Hour
+
+var (
+	reachability     ReachabilityStatus
+	reachabilityLock sync.Mutex
+
+	// reachabilityCheckLock makes sure only one check runs at a time.
+	reachabilityCheckLock sync.Mutex
+
+	// reachabilityCheckRunning is set while a check runs in the background.
+	reachabilityCheckRunning = abool.New()
+)
+
+// GetReachabilityStatus returns the result of the last reachability check.
+func GetReachabilityStatus() ReachabilityStatus {
+	reachabilityLock.Lock()
+	defer reachabilityLock.Unlock()
+
+	status := reachability
+	status.Mode = cfgOptionReachabilityCheck()
+	return status
+}
+
+// verifyReachability checks if the announced transports are reachable by
+// asking the Hub connected via the given crane to connect back. The check is
+// done once and repeated when the announced transports change. Failed checks
+// are only repeated after reachabilityFailureCacheDuration.
+// If the check is enforced, it returns an error if the transports could not
+// be verified. Otherwise, the check is run in the background and failures are
+// only logged.
+func verifyReachability(crane *docks.Crane) error {
+	mode := cfgOptionReachabilityCheck()
+	if mode == ReachabilityCheckOff {
+		return nil
+	}
+
+	// Get announced transports and IPs.
+	publicIdentity.Lock()
+	transports := publicIdentity.Hub.Info.Transports
+	ipv4 := publicIdentity.Hub.Info.IPv4
+	ipv6 := publicIdentity.Hub.Info.IPv6
+	publicIdentity.Unlock()
+
+	// Check if the current transports were already checked.
+	if previous := GetReachabilityStatus(); transportsEqual(previous.checkedTransports, transports) {
+		switch {
+		case previous.Verified:
+			return nil
+		case time.Since(previous.CheckedAt) < reachabilityFailureCacheDuration:
+			if mode == ReachabilityCheckEnforce {
+				return fmt.Errorf("%w: check via %s failed at %s", ErrUnreachable, previous.CheckedBy, previous.CheckedAt.Format(time.RFC3339))
+			}
+			return nil
+		}
+	}
+
+	// Enforced checks must complete before publishing.
+	if mode == ReachabilityCheckEnforce {
+		reachabilityCheckLock.Lock()
+		defer reachabilityCheckLock.Unlock()
+
+		return checkReachability(crane, transports, ipv4, ipv6)
+	}
+
+	// Otherwise, check in the background.
+	if reachabilityCheckRunning.SetToIf(false, true) {
+		module.StartWorker("check reachability", func(_ context.Context) error {
+			defer reachabilityCheckRunning.UnSet()
+
+			reachabilityCheckLock.Lock()
+			defer reachabilityCheckLock.Unlock()
+
+			if err := checkReachability(crane, transports, ipv4, ipv6); err != nil {
+				log.Warningf("spn/captain: published hub regardless of failed reachability check: %s", err)
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+// checkReachability performs the reachability check and saves the result.
+func checkReachability(crane *docks.Crane, transports []string, ipv4, ipv6 net.IP) error {
+	status := ReachabilityStatus{
+		CheckedAt: clock.Now(),
+		CheckedBy: crane.ConnectedHub.ID,
+	}
+	defer func() {
+		reachabilityLock.Lock()
+		defer reachabilityLock.Unlock()
+
+		reachability = status
+	}()
+
+	// Check if the other Hub supports the check.
+	if !crane.ConnectedHub.GetInfo().HasCapability(hub.OpCapability(ReachabilityCheckOpType)) {
+		err := fmt.Errorf("%s does not support reachability checks", crane.ConnectedHub)
+		status.Error = err.Error()
+		return err
+	}
+
+	results, tErr := CheckReachability(crane.Controller, transports, ipv4, ipv6)
+	status.Transports = results
+	if tErr != nil {
+		status.Error = tErr.Error()
+		return fmt.Errorf("failed to check reachability via %s: %w", crane.ConnectedHub, tErr)
+	}
+	// Only remember the checked transports if the check was completed, so
+	// that failures of the check itself are not cached.
+	status.checkedTransports = transports
+
+	// Check results.
+	var unreachable int
+	for _, result := range results {
+		if !result.Reachable {
+			unreachable++
+			log.Warningf("spn/captain: announced transport %s is not reachable from %s: %s", result.Transport, crane.ConnectedHub, result.Error)
+		}
+	}
+	if unreachable > 0 || !transportsCovered(results, transports) {
+		return fmt.Errorf("%w: %d of %d transports failed", ErrUnreachable, unreachable, len(transports))
+	}
+
+	status.Verified = true
+	log.Infof("spn/captain: verified reachability of announced transports via %s", crane.ConnectedHub)
+	return nil
+}
+
+// transportsEqual returns whether the given transport lists are equal.
+func transportsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// transportsCovered returns whether all given transports are reachable
+// according to the given results.
+func transportsCovered(results []TransportReachability, transports []string) bool {
+	for _, transport := range transports {
+		var reachable bool
+		for _, result := range results {
+			if result.Transport == transport && result.Reachable {
+				reachable = true
+				break
+			}
+		}
+		if !reachable {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package captain
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTransportsEqual(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name  string
+		a, b  []string
+		equal bool
+	}{
+		{"both empty", nil, []string{}, true},
+		{"same", []string{"tcp:17", "http:80"}, []string{"tcp:17", "http:80"}, true},
+		{"different order", []string{"tcp:17", "http:80"}, []string{"http:80", "tcp:17"}, false},
+		{"different length", []string{"tcp:17"}, []string{"tcp:17", "http:80"}, false},
+		{"different transport", []string{"tcp:17"}, []string{"tcp:18"}, false},
+	} {
+		if equal := transportsEqual(test.a, test.b); equal != test.equal {
+			t.Errorf("%s: expected %v, got %v", test.name, test.equal, equal)
+		}
+	}
+}
+
+func TestTransportsCovered(t *testing.T) {
+	t.Parallel()
+
+	transports := []string{"tcp:17", "http:80"}
+	for _, test := range []struct {
+		name    string
+		results []TransportReachability
+		covered bool
+	}{
+		{"no results", nil, false},
+		{
+			"all reachable",
+			[]TransportReachability{
+				{Transport: "http:80", Reachable: true},
+				{Transport: "tcp:17", Reachable: true},
+			},
+			true,
+		},
+		{
+			"one unreachable",
+			[]TransportReachability{
+				{Transport: "tcp:17", Reachable: true},
+				{Transport: "http:80", Error: "connection refused"},
+			},
+			false,
+		},
+		{
+			"one missing",
+			[]TransportReachability{
+				{Transport: "tcp:17", Reachable: true},
+			},
+			false,
+		},
+		{
+			"other transports",
+			[]TransportReachability{
+				{Transport: "tcp:17", Reachable: true},
+				{Transport: "http:8080", Reachable: true},
+			},
+			false,
+		},
+	} {
+		if covered := transportsCovered(test.results, transports); covered != test.covered {
+			t.Errorf("%s: expected %v, got %v", test.name, test.covered, covered)
+		}
+	}
+}
+
+func TestSelectReachabilityCheckIP(t *testing.T) {
+	t.Parallel()
+
+	ipv4 := net.ParseIP("192.0.2.1")
+	ipv6 := net.ParseIP("2001:db8::1")
+	other := net.ParseIP("192.0.2.2")
+	for _, test := range []struct {
+		name       string
+		source     net.IP
+		ipv4, ipv6 net.IP
+		selected   net.IP
+		fails      bool
+	}{
+		{"nothing announced", other, nil, nil, other, false},
+		{"matches ipv4", ipv4, ipv4, ipv6, ipv4, false},
+		{"matches ipv6", ipv6, ipv4, ipv6, ipv6, false},
+		{"only ipv6 announced", ipv6, nil, ipv6, ipv6, false},
+		{"no match", other, ipv4, ipv6, nil, true},
+		{"no match with only ipv4", ipv6, ipv4, nil, nil, true},
+	} {
+		selected, err := selectReachabilityCheckIP(test.source, test.ipv4, test.ipv6)
+		switch {
+		case test.fails && err == nil:
+			t.Errorf("%s: expected error, got %s", test.name, selected)
+		case !test.fails && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		case !selected.Equal(test.selected):
+			t.Errorf("%s: expected %s, got %s", test.name, test.selected, selected)
+		}
+	}
+}
@@ -17,6 +17,12 @@ type SPNStatus struct {
 	ConnectedIP        string
 	ConnectedTransport string
 	ConnectedSince     *time.Time
+
+	// HomeLatency is the last measured end-to-end latency of the terminal to
+	// the Home Hub, including queuing.
+	HomeLatency time.Duration `json:",omitempty"`
+	// HomeQueuingDelay is the part of HomeLatency that was added by queuing.
+	HomeQueuingDelay time.Duration `json:",omitempty"`
 }
 
 type SPNStatusName string
@@ -53,6 +59,8 @@ func resetSPNStatus(statusName SPNStatusName) {
 	spnStatus.ConnectedIP = ""
 	spnStatus.ConnectedTransport = ""
 	spnStatus.ConnectedSince = nil
+	spnStatus.HomeLatency = 0
+	spnStatus.HomeQueuingDelay = 0
 
 	// Push new status.
 	pushSPNStatusUpdate()
@@ -0,0 +1,177 @@
+package captain
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/modules"
+)
+
+// TaskInfo holds information about a task of the captain module.
+type TaskInfo struct {
+	// Name is the name of the task.
+	Name string
+	// NextExecution is when the task is scheduled to be executed next.
+	// It is zero if the task is not scheduled.
+	NextExecution time.Time `json:",omitempty"`
+	// LastExecution is when the task was last executed.
+	// It is zero if the task was not executed yet.
+	LastExecution time.Time `json:",omitempty"`
+	// RepeatInterval is the interval in which the task is repeated.
+	// It is zero if the task is not repeated.
+	RepeatInterval time.Duration `json:",omitempty"`
+}
+
+// managedTask wraps a module task in order to keep track of its schedule,
+// as the scheduling state of module tasks cannot be read.
+type managedTask struct {
+	task *modules.Task
+	name string
+
+	repeat        time.Duration
+	nextExecution time.Time
+	lastExecution time.Time
+	lock          sync.Mutex
+}
+
+var (
+	// managedTasks holds the managed tasks by their name, so that tasks
+	// created again when the module is restarted replace the previous ones.
+	managedTasks     = make(map[string]*managedTask)
+	managedTasksLock sync.Mutex
+)
+
+// newManagedTask creates a new module task that is listed by PendingTasks.
+// It replaces any previous managed task with the same name.
+func newManagedTask(name string, fn func(context.Context, *modules.Task) error) *managedTask {
+	mt := &managedTask{
+		name: name,
+	}
+	mt.task = module.NewTask(name, func(ctx context.Context, task *modules.Task) error {
+		mt.executing()
+		return fn(ctx, task)
+	})
+
+	managedTasksLock.Lock()
+	defer managedTasksLock.Unlock()
+	managedTasks[name] = mt
+
+	return mt
+}
+
+// executing records the execution of the task and sets the next execution,
+// if the task is repeated. Rescheduling during the execution overrides the
+// next execution.
+func (mt *managedTask) executing() {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	now := time.Now()
+	mt.lastExecution = now
+	if mt.repeat > 0 {
+		mt.nextExecution = now.Add(mt.repeat)
+	} else {
+		mt.nextExecution = time.Time{}
+	}
+}
+
+// Repeat sets the task to be executed in the given interval.
+func (mt *managedTask) Repeat(interval time.Duration) *managedTask {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	mt.repeat = interval
+	mt.nextExecution = time.Now().Add(interval)
+	mt.task.Repeat(interval)
+	return mt
+}
+
+// Schedule schedules the task for execution at the given time.
+func (mt *managedTask) Schedule(executeAt time.Time) *managedTask {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	mt.nextExecution = executeAt
+	mt.task.Schedule(executeAt)
+	return mt
+}
+
+// StartASAP schedules the task for execution as soon as possible.
+func (mt *managedTask) StartASAP() *managedTask {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	mt.nextExecution = time.Now()
+	mt.task.StartASAP()
+	return mt
+}
+
+// Queue queues the task for execution.
+func (mt *managedTask) Queue() *managedTask {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	mt.nextExecution = time.Now()
+	mt.task.Queue()
+	return mt
+}
+
+// Info returns information about the task.
+func (mt *managedTask) Info() TaskInfo {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	return TaskInfo{
+		Name:           mt.name,
+		NextExecution:  mt.nextExecution,
+		LastExecution:  mt.lastExecution,
+		RepeatInterval: mt.repeat,
+	}
+}
+
+// PendingTasks returns information about the tasks of the captain module,
+// ordered by their next execution. Tasks that are not scheduled are listed
+// last. The next execution of repeated tasks is estimated from their last
+// execution.
+func PendingTasks() []TaskInfo {
+	managedTasksLock.Lock()
+	tasks := make([]TaskInfo, 0, len(managedTasks))
+	for _, mt := range managedTasks {
+		tasks = append(tasks, mt.Info())
+	}
+	managedTasksLock.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool {
+		switch {
+		case tasks[i].NextExecution.IsZero() && tasks[j].NextExecution.IsZero():
+			return tasks[i].Name < tasks[j].Name
+		case tasks[i].NextExecution.IsZero():
+			return false
+		case tasks[j].NextExecution.IsZero():
+			return true
+		case tasks[i].NextExecution.Equal(tasks[j].NextExecution):
+			return tasks[i].Name < tasks[j].Name
+		default:
+			return tasks[i].NextExecution.Before(tasks[j].NextExecution)
+		}
+	})
+	return tasks
+}
+
+func registerTaskAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/captain/tasks`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleTasksRequest,
+		Name:        "Get SPN captain tasks",
+		Description: "Returns the tasks of the captain module with their next scheduled execution and repeat interval.",
+	})
+}
+
+func handleTasksRequest(ar *api.Request) (i interface{}, err error) {
+	return PendingTasks(), nil
+}
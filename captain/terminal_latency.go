@@ -0,0 +1,49 @@
+package captain
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/spn/navigator"
+)
+
+// homeTerminalLatencyInterval defines how often the latency of the terminal
+// to the Home Hub is measured.
+const homeTerminalLatencyInterval = 1 * time.Minute
+
+func startHomeTerminalLatencyMeasurement() {
+	newManagedTask("measure home terminal latency", measureHomeTerminalLatency).
+		Repeat(homeTerminalLatencyInterval).
+		Schedule(time.Now().Add(homeTerminalLatencyInterval))
+}
+
+// measureHomeTerminalLatency measures the end-to-end latency of the terminal
+// to the Home Hub and adds it to the SPN status, so that latency added by
+// queuing can be told apart from the lane latency.
+func measureHomeTerminalLatency(_ context.Context, _ *modules.Task) error {
+	home, homeTerminal := navigator.Main.GetHome()
+	if home == nil || homeTerminal == nil || homeTerminal.IsAbandoned() {
+		return nil
+	}
+
+	if _, tErr := homeTerminal.MeasureLatency(); tErr != nil {
+		log.Debugf("spn/captain: failed to measure latency of home terminal to %s: %s", home.Hub, tErr)
+		return nil
+	}
+	stats := homeTerminal.Stats()
+
+	spnStatus.Lock()
+	defer spnStatus.Unlock()
+
+	// Only update the status of the same Home Hub.
+	if spnStatus.HomeHubID != home.Hub.ID {
+		return nil
+	}
+	spnStatus.HomeLatency = stats.Latency
+	spnStatus.HomeQueuingDelay = stats.QueuingDelay
+	pushSPNStatusUpdate()
+
+	return nil
+}
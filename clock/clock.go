@@ -0,0 +1,111 @@
+// Package clock provides an injectable clock for time dependent behavior.
+// It defaults to the real clock and may be replaced by a fake clock in tests
+// in order to deterministically trigger expiring behavior.
+//
+// Module tasks are always scheduled on the real clock, so times passed to
+// task.Schedule must be based on time.Now, not on this clock.
+package clock
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock provides the current time and timers.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the current time
+	// on the returned channel.
+	After(d time.Duration) <-chan time.Time
+
+	// NewTimer creates a new Timer that will send the current time on its
+	// channel after at least duration d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer represents a single event, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+
+	// Stop prevents the Timer from firing.
+	Stop() bool
+
+	// Reset changes the timer to expire after duration d.
+	Reset(d time.Duration) bool
+}
+
+// current holds the clock in use, wrapped in a holder, as an atomic value
+// must always store the same concrete type.
+var current atomic.Value
+
+type holder struct {
+	Clock
+}
+
+func init() {
+	current.Store(holder{Real{}})
+}
+
+// Set replaces the clock in use and returns a function that restores the
+// previous clock.
+func Set(c Clock) (restore func()) {
+	previous := get()
+	current.Store(holder{c})
+	return func() {
+		current.Store(holder{previous})
+	}
+}
+
+func get() Clock {
+	return current.Load().(holder).Clock
+}
+
+// Now returns the current time of the clock in use.
+func Now() time.Time {
+	return get().Now()
+}
+
+// Since returns the time elapsed since t according to the clock in use.
+func Since(t time.Time) time.Duration {
+	return get().Now().Sub(t)
+}
+
+// After waits for the duration to elapse on the clock in use and then sends
+// the current time on the returned channel.
+func After(d time.Duration) <-chan time.Time {
+	return get().After(d)
+}
+
+// NewTimer creates a new Timer on the clock in use.
+func NewTimer(d time.Duration) Timer {
+	return get().NewTimer(d)
+}
+
+// Real is the real clock backed by the time package.
+type Real struct{}
+
+// Now returns the current time.
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// After is like time.After.
+func (Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NewTimer is like time.NewTimer.
+func (Real) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
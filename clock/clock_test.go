@@ -0,0 +1,95 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+
+	after := f.After(10 * time.Second)
+	timer := f.NewTimer(20 * time.Second)
+	stopped := f.NewTimer(5 * time.Second)
+	if !stopped.Stop() {
+		t.Fatal("stopping a pending timer should return true")
+	}
+
+	// Nothing should fire before advancing.
+	f.Advance(9 * time.Second)
+	select {
+	case <-after:
+		t.Fatal("after fired too early")
+	default:
+	}
+
+	// After should fire now, the timer not yet.
+	f.Advance(1 * time.Second)
+	select {
+	case now := <-after:
+		if !now.Equal(start.Add(10 * time.Second)) {
+			t.Errorf("unexpected time: %s", now)
+		}
+	default:
+		t.Fatal("after did not fire")
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired too early")
+	case <-stopped.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+
+	// Reset timer and check it fires at the new time.
+	if !timer.Reset(5 * time.Second) {
+		t.Error("resetting a pending timer should return true")
+	}
+	f.Advance(5 * time.Second)
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("timer did not fire after reset")
+	}
+
+	if !f.Now().Equal(start.Add(15 * time.Second)) {
+		t.Errorf("unexpected time: %s", f.Now())
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	restore := Set(NewFake(start))
+
+	if !Now().Equal(start) {
+		t.Errorf("expected fake time, got %s", Now())
+	}
+
+	restore()
+	if _, ok := get().(Real); !ok {
+		t.Errorf("expected real clock to be restored, got %T", get())
+	}
+}
+
+func TestWallClockJump(t *testing.T) {
+	// Times with monotonic clock readings do not jump by themselves.
+	earlier := time.Now()
+	later := earlier.Add(time.Minute)
+	if jump := WallClockJump(earlier, later); jump != 0 {
+		t.Errorf("unexpected jump of %s", jump)
+	}
+
+	// Times without monotonic clock readings cannot be checked.
+	if jump := WallClockJump(earlier.Round(0), later.Add(-time.Hour)); jump != 0 {
+		t.Errorf("unexpected jump of %s without monotonic clock reading", jump)
+	}
+
+	// The fake clock has no monotonic clock reading.
+	defer Set(NewFake(earlier.Round(0)))()
+	if jump := NewJumpDetector().Check(); jump != 0 {
+		t.Errorf("unexpected jump of %s with fake clock", jump)
+	}
+}
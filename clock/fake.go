@@ -0,0 +1,122 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a clock that only advances when told to.
+type Fake struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake returns a new fake clock set to the given time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now: now,
+	}
+}
+
+// Now returns the current time of the fake clock.
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.now
+}
+
+// After returns a channel that receives the time once the fake clock was
+// advanced by at least d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// NewTimer returns a timer that fires once the fake clock was advanced by at
+// least d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	t := &fakeTimer{
+		clock: f,
+		c:     make(chan time.Time, 1),
+	}
+	f.schedule(t, d)
+	return t
+}
+
+// Advance moves the fake clock forward and fires all timers that expire in
+// the meantime.
+func (f *Fake) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.now = f.now.Add(d)
+
+	// Fire expired timers and keep the remaining ones.
+	remaining := f.timers[:0]
+	for _, t := range f.timers {
+		if t.fireAt.After(f.now) {
+			remaining = append(remaining, t)
+			continue
+		}
+		select {
+		case t.c <- f.now:
+		default:
+		}
+	}
+	f.timers = remaining
+}
+
+// schedule adds the timer to the pending timers. The clock must be locked.
+func (f *Fake) schedule(t *fakeTimer, d time.Duration) {
+	t.fireAt = f.now.Add(d)
+	if d <= 0 {
+		select {
+		case t.c <- f.now:
+		default:
+		}
+		return
+	}
+	f.timers = append(f.timers, t)
+}
+
+// unschedule removes the timer from the pending timers and returns whether it
+// was pending. The clock must be locked.
+func (f *Fake) unschedule(t *fakeTimer) bool {
+	for i, pending := range f.timers {
+		if pending == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock  *Fake
+	c      chan time.Time
+	fireAt time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	wasPending := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+	return wasPending
+}
@@ -0,0 +1,54 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// WallClockJump returns how far the wall clock jumped relative to the
+// monotonic clock between the two given times. A negative value means that
+// the wall clock was set back. If any of the times does not carry a monotonic
+// clock reading, zero is returned.
+//
+// Times returned by the real clock carry a monotonic clock reading, so
+// durations and comparisons between them, as used for timeouts and
+// scheduling, are not affected by wall clock changes. The reading is lost when
+// times are serialized or created from timestamps, eg. via time.Unix, so
+// comparisons with timestamps set by others, such as the token issuer, always
+// use the wall clock.
+func WallClockJump(earlier, later time.Time) time.Duration {
+	// Only times with a monotonic clock reading differ when stripped of it.
+	if earlier == earlier.Round(0) || later == later.Round(0) {
+		return 0
+	}
+
+	monotonic := later.Sub(earlier)
+	wall := later.Round(0).Sub(earlier.Round(0))
+	return wall - monotonic
+}
+
+// JumpDetector detects jumps of the wall clock between checks.
+type JumpDetector struct {
+	lock      sync.Mutex
+	lastCheck time.Time
+}
+
+// NewJumpDetector returns a new jump detector.
+func NewJumpDetector() *JumpDetector {
+	return &JumpDetector{
+		lastCheck: Now(),
+	}
+}
+
+// Check returns how far the wall clock jumped relative to the monotonic clock
+// since the last check. A negative value means that the wall clock was set
+// back.
+func (jd *JumpDetector) Check() time.Duration {
+	jd.lock.Lock()
+	defer jd.lock.Unlock()
+
+	now := Now()
+	jump := WallClockJump(jd.lastCheck, now)
+	jd.lastCheck = now
+	return jump
+}
@@ -87,6 +87,7 @@ func (t *Tunnel) handle(ctx context.Context) (err error) {
 		return nil
 	}
 	log.Infof("spn/crew: established route to %s with %d failed tries", dstPin.Hub, tries)
+	recordRouteUsage(dstPin, route)
 
 	// Create request and connect.
 	request := &ConnectRequest{
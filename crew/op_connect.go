@@ -242,6 +242,12 @@ func (op *ConnectOp) Deliver(c *container.Container) *terminal.Error {
 
 func (op *ConnectOp) connWriter(_ context.Context) error {
 	defer op.conn.Close()
+	defer func() {
+		// Report received data that was not written before stopping.
+		if dropped := op.DuplexFlowQueue.DrainRecvQueue(); dropped > 0 {
+			log.Warningf("spn/crew: %s dropped %d received containers that were not sent to %s", op.FmtID(), dropped, op.connectedType())
+		}
+	}()
 
 writing:
 	for {
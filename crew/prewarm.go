@@ -0,0 +1,82 @@
+package crew
+
+import (
+	"sync"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/navigator"
+)
+
+var (
+	// usedRoutes holds the last used route to every destination Hub, so that
+	// connections to the most used Hubs can be pre-warmed.
+	usedRoutes     = make(map[string]*navigator.Route)
+	usedRoutesLock sync.Mutex
+)
+
+// recordRouteUsage records that the given route to the given destination
+// Hub was used.
+func recordRouteUsage(dstPin *navigator.Pin, route *navigator.Route) {
+	docks.RecordHubUsage(dstPin.Hub.ID)
+
+	usedRoutesLock.Lock()
+	defer usedRoutesLock.Unlock()
+
+	usedRoutes[dstPin.Hub.ID] = route
+}
+
+// PrewarmRoutes re-establishes the last used routes to the given Hubs, if
+// there is no active connection to them. At most maxEstablish routes are
+// established. Pre-warmed connections are torn down by their idle timeout
+// like any other expansion, if they are not used.
+func PrewarmRoutes(hubIDs []string, maxEstablish int) (established int) {
+	usedRoutesLock.Lock()
+	routes := make([]*navigator.Route, 0, len(hubIDs))
+	for _, hubID := range hubIDs {
+		if route, ok := usedRoutes[hubID]; ok {
+			routes = append(routes, route)
+		}
+	}
+	// Forget routes to Hubs that are not used anymore.
+	for hubID := range usedRoutes {
+		if !containsHubID(hubIDs, hubID) {
+			delete(usedRoutes, hubID)
+		}
+	}
+	usedRoutesLock.Unlock()
+
+	for _, route := range routes {
+		if established >= maxEstablish {
+			break
+		}
+
+		// Skip Hubs that we already have a connection to.
+		dst := route.Path[len(route.Path)-1].Pin()
+		if dst.GetActiveTerminal() != nil || len(route.Path) == 1 {
+			continue
+		}
+		established++
+
+		dstPin, _, err := establishRoute(route)
+		if err != nil {
+			log.Debugf("spn/crew: failed to pre-warm route to %s: %s", dst.Hub, err)
+			continue
+		}
+		log.Infof("spn/crew: pre-warmed route to %s", dstPin.Hub)
+	}
+	if established > 0 {
+		navigator.Main.PushPinChanges()
+	}
+
+	return established
+}
+
+func containsHubID(hubIDs []string, hubID string) bool {
+	for _, id := range hubIDs {
+		if id == hubID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package docks
+
+import (
+	"github.com/safing/portbase/api"
+)
+
+func registerAPIEndpoints() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/docks/quarantine`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleQuarantineRequest,
+		Name:        "Get SPN quarantine",
+		Description: "Returns a list of peers that are quarantined because of protocol violations.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/docks/blocklist`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleBlocklistRequest,
+		Name:        "Get SPN hub blocklist",
+		Description: "Returns a list of Hubs that may not establish cranes, including their rejected attempts.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/docks/cranes`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleCranesRequest,
+		Name:        "Get SPN cranes",
+		Description: "Returns the state of all assigned cranes.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/docks/recordings`,
+		Read:        api.PermitAdmin,
+		BelongsTo:   module,
+		StructFunc:  handleRecordingsRequest,
+		Name:        "Get SPN crane recordings",
+		Description: "Returns the recorded traffic of active and failed cranes, if crane recording is enabled.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/docks/flow-traces`,
+		Read:        api.PermitAdmin,
+		BelongsTo:   module,
+		StructFunc:  handleFlowTracesRequest,
+		Name:        "Get SPN flow traces",
+		Description: "Returns the recorded flow queue events of the terminals of all assigned cranes, if flow tracing is enabled.",
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func handleQuarantineRequest(ar *api.Request) (i interface{}, err error) {
+	return GetQuarantineList(), nil
+}
+
+func handleBlocklistRequest(ar *api.Request) (i interface{}, err error) {
+	return GetHubBlocklist(), nil
+}
+
+func handleCranesRequest(ar *api.Request) (i interface{}, err error) {
+	return GetAllCraneStates(), nil
+}
+
+func handleRecordingsRequest(ar *api.Request) (i interface{}, err error) {
+	return GetCraneRecordings(), nil
+}
+
+func handleFlowTracesRequest(ar *api.Request) (i interface{}, err error) {
+	return GetFlowTraces(), nil
+}
@@ -0,0 +1,149 @@
+package docks
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/terminal"
+)
+
+// Hub blocklist sources.
+const (
+	BlocklistSourceIntel  = "intel"
+	BlocklistSourceConfig = "config"
+)
+
+// BlockedHub describes a blocked Hub.
+type BlockedHub struct {
+	// HubID is the ID of the blocked Hub.
+	HubID string
+	// Sources are the sources that block the Hub.
+	Sources []string
+	// Rejections is the amount of rejected crane admissions of the Hub.
+	Rejections int
+	// LastRejection is when a crane of the Hub was last rejected.
+	LastRejection time.Time `json:",omitempty"`
+}
+
+var (
+	hubBlocklists      = make(map[string]map[string]struct{}) // Source -> Hub IDs.
+	hubBlockRejections = make(map[string]*BlockedHub)
+	hubBlocklistLock   sync.Mutex
+)
+
+// SetHubBlocklist replaces the blocked Hub IDs of the given source. The
+// blocklist is the union of all sources. Cranes to newly blocked Hubs are
+// stopped.
+func SetHubBlocklist(source string, hubIDs []string) {
+	newlyBlocked := make([]string, 0, len(hubIDs))
+
+	func() {
+		hubBlocklistLock.Lock()
+		defer hubBlocklistLock.Unlock()
+
+		blocked := make(map[string]struct{}, len(hubIDs))
+		for _, hubID := range hubIDs {
+			if hubID == "" {
+				continue
+			}
+			blocked[hubID] = struct{}{}
+			if !isHubBlocked(hubID) {
+				newlyBlocked = append(newlyBlocked, hubID)
+			}
+		}
+
+		if len(blocked) == 0 {
+			delete(hubBlocklists, source)
+		} else {
+			hubBlocklists[source] = blocked
+		}
+
+		// Remove rejection stats of unblocked Hubs.
+		for hubID := range hubBlockRejections {
+			if !isHubBlocked(hubID) {
+				delete(hubBlockRejections, hubID)
+			}
+		}
+	}()
+
+	if stopped := StopCranesToHubs(newlyBlocked, "hub blocked"); stopped > 0 {
+		log.Infof("spn/docks: stopped %d cranes to hubs blocked by %s", stopped, source)
+	}
+}
+
+// isHubBlocked returns whether the given Hub is blocked by any source.
+// The blocklist lock must be held.
+func isHubBlocked(hubID string) bool {
+	for _, blocked := range hubBlocklists {
+		if _, ok := blocked[hubID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHubBlocked returns whether the Hub with the given ID is blocked.
+func IsHubBlocked(hubID string) bool {
+	hubBlocklistLock.Lock()
+	defer hubBlocklistLock.Unlock()
+
+	return isHubBlocked(hubID)
+}
+
+// CheckHubAdmission returns an error if cranes from or to the Hub with the
+// given ID may not be established. Rejections are counted and logged.
+func CheckHubAdmission(hubID string) *terminal.Error {
+	hubBlocklistLock.Lock()
+	defer hubBlocklistLock.Unlock()
+
+	if !isHubBlocked(hubID) {
+		return nil
+	}
+
+	// Count rejection.
+	entry, ok := hubBlockRejections[hubID]
+	if !ok {
+		entry = &BlockedHub{HubID: hubID}
+		hubBlockRejections[hubID] = entry
+	}
+	entry.Rejections++
+	entry.LastRejection = time.Now()
+
+	log.Warningf("spn/docks: rejected crane admission of blocked hub %s (rejection #%d)", hubID, entry.Rejections)
+	return terminal.ErrPermissinDenied.With("hub %s is blocked", hubID)
+}
+
+// GetHubBlocklist returns a list of all blocked Hubs.
+func GetHubBlocklist() []*BlockedHub {
+	hubBlocklistLock.Lock()
+	defer hubBlocklistLock.Unlock()
+
+	// Collect sources of all blocked Hubs.
+	entries := make(map[string]*BlockedHub)
+	for source, blocked := range hubBlocklists {
+		for hubID := range blocked {
+			entry, ok := entries[hubID]
+			if !ok {
+				entry = &BlockedHub{HubID: hubID}
+				if stats, ok := hubBlockRejections[hubID]; ok {
+					entry.Rejections = stats.Rejections
+					entry.LastRejection = stats.LastRejection
+				}
+				entries[hubID] = entry
+			}
+			entry.Sources = append(entry.Sources, source)
+		}
+	}
+
+	list := make([]*BlockedHub, 0, len(entries))
+	for _, entry := range entries {
+		sort.Strings(entry.Sources)
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].HubID < list[j].HubID
+	})
+	return list
+}
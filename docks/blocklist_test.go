@@ -0,0 +1,63 @@
+package docks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/ships"
+	"github.com/safing/spn/terminal"
+)
+
+func TestHubBlocklist(t *testing.T) {
+	defer SetHubBlocklist(BlocklistSourceIntel, nil)
+	defer SetHubBlocklist(BlocklistSourceConfig, nil)
+
+	// Block the same Hub from two sources.
+	SetHubBlocklist(BlocklistSourceIntel, []string{"blocked-a", "blocked-b"})
+	SetHubBlocklist(BlocklistSourceConfig, []string{"blocked-b"})
+	if !IsHubBlocked("blocked-a") || !IsHubBlocked("blocked-b") || IsHubBlocked("allowed") {
+		t.Fatal("unexpected blocklist state")
+	}
+
+	// Cranes to blocked Hubs must not be created.
+	_, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: "blocked-a"}, nil)
+	if err == nil {
+		t.Fatal("crane to blocked hub should be rejected")
+	}
+	if tErr := CheckHubAdmission("blocked-a"); !tErr.Is(terminal.ErrPermissinDenied) {
+		t.Fatalf("unexpected admission result: %s", tErr)
+	}
+	if tErr := CheckHubAdmission("allowed"); tErr != nil {
+		t.Fatalf("unexpected admission result: %s", tErr)
+	}
+
+	// Check list and rejection counts.
+	list := GetHubBlocklist()
+	if len(list) != 2 {
+		t.Fatalf("expected two blocked hubs, got %d", len(list))
+	}
+	if list[0].HubID != "blocked-a" || list[0].Rejections != 2 {
+		t.Fatalf("unexpected entry: %+v", list[0])
+	}
+	if len(list[1].Sources) != 2 {
+		t.Fatalf("expected two sources, got %v", list[1].Sources)
+	}
+
+	// Reloading a source unblocks Hubs that are not blocked by another source.
+	SetHubBlocklist(BlocklistSourceIntel, nil)
+	if IsHubBlocked("blocked-a") || !IsHubBlocked("blocked-b") {
+		t.Fatal("unexpected blocklist state after reload")
+	}
+
+	// Existing cranes to newly blocked Hubs are stopped.
+	crane, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: "blocked-a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterCrane(crane)
+	SetHubBlocklist(BlocklistSourceConfig, []string{"blocked-a"})
+	if !crane.Stopped() {
+		t.Fatal("crane to newly blocked hub should be stopped")
+	}
+}
@@ -1,10 +1,24 @@
 package docks
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/safing/portbase/container"
 	"github.com/safing/spn/terminal"
 )
 
+// flowSyncCheckInterval holds the interval in which crane controllers check
+// the flow control state with the other end. Zero disables the checks.
+var flowSyncCheckInterval int64
+
+// SetFlowSyncCheckInterval sets the interval in which newly started crane
+// controllers check the flow control state with the other end, if both sides
+// support it. Zero disables the checks.
+func SetFlowSyncCheckInterval(interval time.Duration) {
+	atomic.StoreInt64(&flowSyncCheckInterval, int64(interval))
+}
+
 type CraneControllerTerminal struct {
 	*terminal.TerminalBase
 	*terminal.DuplexFlowQueue
@@ -45,6 +59,7 @@ func initCraneController(
 ) *CraneControllerTerminal {
 	// Create Flow Queue.
 	dfq := terminal.NewDuplexFlowQueue(t, initMsg.QueueSize, t.SubmitAsDataMsg(crane.submitImportantTerminalMsg))
+	dfq.SetMaxMsgSize(initMsg.MaxMsgSize)
 
 	// Create Crane Terminal and assign it as the extended Terminal.
 	cct := &CraneControllerTerminal{
@@ -1,12 +1,14 @@
 package docks
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tevino/abool"
@@ -17,6 +19,7 @@ import (
 	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/rng"
 	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/conf"
 	"github.com/safing/spn/hub"
 	"github.com/safing/spn/ships"
 	"github.com/safing/spn/terminal"
@@ -38,6 +41,10 @@ var (
 	// loadingMaxWaitDuration is the maximum time a crane will wait for
 	// additional data to send.
 	loadingMaxWaitDuration = 5 * time.Millisecond
+
+	// loadRetryDelay is the delay before retrying to load data onto a ship
+	// after a temporary failure.
+	loadRetryDelay = 50 * time.Millisecond
 )
 
 // Errors.
@@ -45,11 +52,60 @@ var (
 	ErrDone = errors.New("crane is done")
 )
 
+// UnloaderOptions holds the buffer options for the crane unloader.
+type UnloaderOptions struct {
+	// QueueSize defines how many unloaded containers may wait for the handler.
+	QueueSize int
+	// ReadSize defines the size of the chunks read from the ship.
+	ReadSize int
+}
+
+var (
+	// RelayUnloaderOptions are the default unloader options for public Hubs.
+	// Relays handle many high-throughput connections, so they read bigger
+	// chunks and allow more containers to queue up for the handler.
+	RelayUnloaderOptions = UnloaderOptions{
+		QueueSize: 100,
+		ReadSize:  32768,
+	}
+
+	// ClientUnloaderOptions are the default unloader options for clients.
+	// They keep memory usage low, which matters on small devices.
+	ClientUnloaderOptions = UnloaderOptions{
+		QueueSize: 10,
+		ReadSize:  4096,
+	}
+)
+
+// defaultUnloaderOptions returns the default unloader options for the
+// current role.
+func defaultUnloaderOptions() UnloaderOptions {
+	if conf.PublicHub() {
+		return RelayUnloaderOptions
+	}
+	return ClientUnloaderOptions
+}
+
 type Crane struct {
 	// ID is the ID of the Crane.
 	ID string
+	// createdAt holds the time when the Crane was created.
+	createdAt time.Time
+	// recorder records the traffic of the Crane for debugging, if enabled.
+	recorder *craneRecorder
 	// opts holds options.
 	opts terminal.TerminalOpts
+	// capabilities holds the optional features both sides agreed on.
+	// It is set by the capabilities exchange and must be accessed atomically.
+	capabilities uint64
+	// agreedProtocolVersion holds the crane protocol version both sides agreed
+	// on. It is set by the capabilities exchange and must be accessed
+	// atomically.
+	agreedProtocolVersion uint32
+	// protocolVersion holds the highest crane protocol version spoken by this
+	// side. It is always CraneProtocolVersion, except in tests that need to
+	// speak an older protocol version.
+	protocolVersion uint8
 
 	// ctx is the context of the Terminal.
 	ctx context.Context
@@ -84,6 +140,13 @@ type Crane struct {
 
 	// ship represents the underlying physical connection.
 	ship ships.Ship
+	// unloaderOpts holds the buffer options for the unloader.
+	unloaderOpts UnloaderOptions
+	// flowWindowMax holds the maximum receive window of the flow queues of
+	// crane terminals, if auto tuning is enabled.
+	flowWindowMax uint32
+	// unloadReader buffers reading from the ship.
+	unloadReader *bufio.Reader
 	// unloading moves containers from the ship to the crane.
 	unloading chan *container.Container
 	// loading moves containers from the crane to the ship.
@@ -105,26 +168,40 @@ type Crane struct {
 }
 
 func NewCrane(ctx context.Context, ship ships.Ship, connectedHub *hub.Hub, id *cabin.Identity) (*Crane, error) {
+	// Check if the connected Hub may be admitted before setting anything up.
+	if connectedHub != nil {
+		if tErr := CheckHubAdmission(connectedHub.ID); tErr != nil {
+			return nil, tErr
+		}
+	}
+
 	ctx, cancelCtx := context.WithCancel(ctx)
+	unloaderOpts := defaultUnloaderOptions()
 
 	new := &Crane{
+		createdAt:     time.Now(),
 		ctx:           ctx,
 		cancelCtx:     cancelCtx,
 		stopping:      abool.NewBool(false),
 		stopped:       abool.NewBool(false),
 		authenticated: abool.NewBool(false),
 
-		ConnectedHub: connectedHub,
-		NetState:     newNetworkOptimizationState(),
-		identity:     id,
+		ConnectedHub:    connectedHub,
+		NetState:        newNetworkOptimizationState(),
+		identity:        id,
+		protocolVersion: CraneProtocolVersion,
 
 		ship:          ship,
-		unloading:     make(chan *container.Container, 0),
+		unloaderOpts:  unloaderOpts,
+		flowWindowMax: atomic.LoadUint32(&defaultFlowWindowMax),
+		unloadReader:  bufio.NewReaderSize(shipReader{ship: ship}, unloaderOpts.ReadSize),
+		unloading:     make(chan *container.Container, unloaderOpts.QueueSize),
 		loading:       make(chan *container.Container, 100),
 		terminalMsgs:  make(chan *container.Container, 100),
 		importantMsgs: make(chan *container.Container, 100),
 
 		terminals: make(map[uint32]terminal.TerminalInterface),
+		recorder:  newCraneRecorder(),
 	}
 	err := registerCrane(new)
 	if err != nil {
@@ -217,6 +294,23 @@ func (crane *Crane) Publish() error {
 		return fmt.Errorf("spn/docks: %s: cannot publish without defined connected hub", crane)
 	}
 
+	// Check if the connected Hub is quarantined.
+	if IsHubQuarantined(crane.ConnectedHub.ID) {
+		return fmt.Errorf("spn/docks: %s: cannot publish quarantined hub %s", crane, crane.ConnectedHub.ID)
+	}
+
+	// Check if the connected Hub is blocked.
+	if tErr := CheckHubAdmission(crane.ConnectedHub.ID); tErr != nil {
+		return fmt.Errorf("spn/docks: %s: cannot publish: %w", crane, tErr)
+	}
+
+	// Assign crane to make it available to others.
+	// If there already is a crane to the same Hub, only one of them is kept in
+	// order to not split terminals and lane capacity across cranes.
+	if err := crane.assignDeduplicated(crane.ConnectedHub.ID); err != nil {
+		return err
+	}
+
 	// Submit metrics.
 	if !crane.Public() {
 		newPublicCranes.Inc()
@@ -226,10 +320,7 @@ func (crane *Crane) Publish() error {
 	maskedID := crane.ship.MaskAddress(crane.ship.RemoteAddr())
 	crane.ship.MarkPublic()
 
-	// Assign crane to make it available to others.
-	AssignCrane(crane.ConnectedHub.ID, crane)
-
-	log.Infof("spn/docks: %s (was %s) is now public", crane, maskedID)
+	log.Infof("spn/docks: %s is now public (was %s) [%s]", crane, maskedID, crane.logFields())
 	return nil
 }
 
@@ -307,17 +398,30 @@ func (crane *Crane) AbandonTerminal(id uint32, err *terminal.Error) {
 	// Log reason the terminal is ending. Override stopping error with nil.
 	switch {
 	case err == nil:
-		log.Debugf("spn/docks: %T %s is being abandoned", t, t.FmtID())
+		log.Debugf("spn/docks: %s abandons %T %s [%s]", crane, t, t.FmtID(), crane.logFields())
 	case errors.Is(err, terminal.ErrStopping):
 		err = nil
-		log.Debugf("spn/docks: %T %s is being abandoned by peer", t, t.FmtID())
+		log.Debugf("spn/docks: %s abandons %T %s on request of peer [%s]", crane, t, t.FmtID(), crane.logFields())
 	default:
-		log.Warningf("spn/docks: %T %s: %s", t, t.FmtID(), err)
+		log.Warningf("spn/docks: %s abandons %T %s: %s [%s]", crane, t, t.FmtID(), err, crane.logFields())
+
+		// Stop the crane if the peer is quarantined because of this error.
+		if crane.reportViolation(err) {
+			module.StartWorker("stop quarantined crane", func(_ context.Context) error {
+				crane.Stop(terminal.ErrPermissinDenied.With("peer quarantined"))
+				return nil
+			})
+		}
 	}
 
 	// Call the terminal's abandon function.
 	t.Abandon(err)
 
+	crane.stopIfRetired()
+}
+
+// stopIfRetired stops the crane if it is stopping and may stop now.
+func (crane *Crane) stopIfRetired() {
 	// If the crane is stopping, check if we can stop.
 	// We can stop when all terminals are abandoned or after a timeout.
 	// FYI: The crane controller will always take up one slot.
@@ -384,6 +488,57 @@ func (crane *Crane) decrypt(shipment *container.Container) (decrypted *container
 	return container.New(decryptedData), nil
 }
 
+// SetUnloaderOptions sets the buffer options for the unloader.
+// It must be called before the crane is started.
+func (crane *Crane) SetUnloaderOptions(opts UnloaderOptions) {
+	if opts.QueueSize < 0 {
+		opts.QueueSize = 0
+	}
+	if opts.ReadSize <= 0 {
+		opts.ReadSize = defaultUnloaderOptions().ReadSize
+	}
+
+	crane.unloaderOpts = opts
+	crane.unloadReader = bufio.NewReaderSize(shipReader{ship: crane.ship}, opts.ReadSize)
+	crane.unloading = make(chan *container.Container, opts.QueueSize)
+}
+
+// SetFlowWindowAutoTuning enables auto tuning of the receive window of the flow
+// queues of the crane terminals, up to the given maximum window. The window is
+// tuned using the measured latency of the connected Hub.
+// It must be called before the crane is started.
+func (crane *Crane) SetFlowWindowAutoTuning(maxWindow uint32) {
+	crane.flowWindowMax = maxWindow
+}
+
+// defaultFlowWindowMax holds the maximum receive window that new cranes use
+// for auto tuning. Zero disables auto tuning.
+var defaultFlowWindowMax uint32
+
+// SetDefaultFlowWindowAutoTuning sets the maximum receive window that new
+// cranes use for auto tuning. Zero disables auto tuning.
+func SetDefaultFlowWindowAutoTuning(maxWindow uint32) {
+	atomic.StoreUint32(&defaultFlowWindowMax, maxWindow)
+}
+
+// getRTT returns the measured latency of the connected Hub, or zero if unknown.
+func (crane *Crane) getRTT() time.Duration {
+	if crane.ConnectedHub == nil {
+		return 0
+	}
+	latency, _ := crane.ConnectedHub.GetMeasurements().GetLatency()
+	return latency
+}
+
+// shipReader adapts a ship to the io.Reader interface.
+type shipReader struct {
+	ship ships.Ship
+}
+
+func (sr shipReader) Read(p []byte) (n int, err error) {
+	return sr.ship.UnloadTo(p)
+}
+
 func (crane *Crane) unloader(ctx context.Context) error {
 	for {
 		// Get first couple bytes to get the packet length.
@@ -433,7 +588,8 @@ func (crane *Crane) unloader(ctx context.Context) error {
 			}
 		}
 
-		// Submit to handler.
+		// Record and submit to handler.
+		crane.recordWireIn(shipmentBuf)
 		select {
 		case <-crane.ctx.Done():
 			crane.Stop(nil)
@@ -447,12 +603,12 @@ func (crane *Crane) unloadUntilFull(buf []byte) error {
 	var bytesRead int
 	for {
 		// Get shipment from ship.
-		n, err := crane.ship.UnloadTo(buf[bytesRead:])
+		n, err := crane.unloadReader.Read(buf[bytesRead:])
 		if err != nil {
 			return err
 		}
 		if n == 0 {
-			log.Tracef("spn/docks: %s unloaded 0 bytes", crane)
+			log.Tracef("spn/docks: %s unloaded 0 bytes [%s]", crane, crane.logFields())
 		}
 		bytesRead += n
 
@@ -488,6 +644,9 @@ handling:
 				crane.Stop(terminal.ErrIntegrity.With("failed to decrypt: %w", err))
 				return nil
 			}
+			if crane.jession != nil && crane.recorder.recordsPlaintext() {
+				crane.recorder.record(CraneRecordPlainIn, shipment.CompileData())
+			}
 
 			// Process all segments/containers of the shipment.
 			for shipment.HoldsData() {
@@ -563,14 +722,14 @@ handling:
 							})
 						}
 					} else {
-						log.Tracef("spn/docks: %s received msg for unknown terminal %d", crane, terminalID)
+						log.Tracef("spn/docks: %s received msg for unknown terminal %d [%s]", crane, terminalID, crane.logFields())
 					}
 
 				case terminal.MsgTypeStop:
 					// Parse error.
 					receivedErr, err := terminal.ParseExternalError(segment.CompileData())
 					if err != nil {
-						log.Warningf("spn/docks: %s failed to parse abandon error: %s", crane, err)
+						log.Warningf("spn/docks: %s failed to parse abandon error: %s [%s]", crane, err, crane.logFields())
 						receivedErr = terminal.ErrUnknownError.AsExternal()
 					}
 					// This is a hot path. Start a worker for abandoning the terminal.
@@ -628,7 +787,7 @@ func (crane *Crane) loader(ctx context.Context) (err error) {
 			if newSegment != nil {
 				// Check length.
 				if newSegment.Length() > maxSegmentLength {
-					log.Warningf("spn/docks: %s ignored oversized segment with length %d", crane, newSegment.Length())
+					log.Warningf("spn/docks: %s ignored oversized segment with length %d [%s]", crane, newSegment.Length(), crane.logFields())
 					continue fillingShipment
 				}
 
@@ -716,7 +875,7 @@ func (crane *Crane) load(c *container.Container) error {
 			if paddingNeeded > 0 {
 				padding, err := rng.Bytes(paddingNeeded)
 				if err != nil {
-					log.Debugf("spn/docks: %s failed to get random padding data, using zeros instead", crane)
+					log.Debugf("spn/docks: %s failed to get random padding data, using zeros instead [%s]", crane, crane.logFields())
 					padding = make([]byte, paddingNeeded)
 				}
 				c.Append(padding)
@@ -724,7 +883,10 @@ func (crane *Crane) load(c *container.Container) error {
 		}
 	}
 
-	// Encrypt shipment.
+	// Record and encrypt shipment.
+	if crane.jession != nil && crane.recorder.recordsPlaintext() {
+		crane.recorder.record(CraneRecordPlainOut, c.CompileData())
+	}
 	c, err := crane.encrypt(c)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt: %w", err)
@@ -733,13 +895,14 @@ func (crane *Crane) load(c *container.Container) error {
 	// Finalize data.
 	c.PrependLength()
 	readyToSend := c.CompileData()
+	crane.recorder.record(CraneRecordWireOut, readyToSend)
 
 	// Submit metrics.
 	crane.submitCraneTrafficStats(len(readyToSend))
 	crane.NetState.ReportTraffic(uint64(len(readyToSend)), false)
 
 	// Load onto ship.
-	err = crane.ship.Load(readyToSend)
+	err = crane.loadShip(readyToSend)
 	if err != nil {
 		return fmt.Errorf("failed to load ship: %w", err)
 	}
@@ -747,19 +910,42 @@ func (crane *Crane) load(c *container.Container) error {
 	return nil
 }
 
+// loadShip loads the data onto the ship and retries once if loading failed
+// temporarily. Fatal errors are returned immediately.
+func (crane *Crane) loadShip(data []byte) error {
+	err := crane.ship.Load(data)
+	if err == nil || !ships.IsRetriableLoadError(err) {
+		return err
+	}
+
+	// Retry once after a short delay.
+	log.Debugf("spn/docks: %s retrying to load ship after temporary error: %s [%s]", crane, err, crane.logFields())
+	select {
+	case <-time.After(loadRetryDelay):
+	case <-crane.ctx.Done():
+		return err
+	}
+	return crane.ship.Load(data)
+}
+
 func (crane *Crane) Stop(err *terminal.Error) {
 	if !crane.stopped.SetToIf(false, true) {
 		return
 	}
 
+	// Count protocol violations of the peer.
+	crane.reportViolation(err)
+
 	// Log error message.
 	if err != nil {
 		if err.IsOK() {
-			log.Infof("spn/docks: %s is done", crane)
+			log.Infof("spn/docks: %s is done [%s]", crane, crane.logFields())
 		} else {
-			log.Warningf("spn/docks: %s is stopping: %s", crane, err)
+			log.Warningf("spn/docks: %s is stopping: %s [%s]", crane, err, crane.logFields())
+			crane.saveFailedRecording(err.Error())
 		}
 	}
+	crane.recorder.release()
 
 	// Unregister crane.
 	unregisterCrane(crane)
@@ -0,0 +1,218 @@
+package docks
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/terminal"
+)
+
+/*
+
+Crane Capabilities Exchange:
+
+The crane protocol version and optional crane features are negotiated when
+the crane starts, so that they can be introduced without requiring all Hubs to
+update at the same time.
+
+1. The client sends its protocol version and capabilities in the options of
+   the crane controller terminal. Older servers ignore the unknown options.
+2. The server uses the lower of both protocol versions and the capabilities
+   supported by both sides and sends them to the client with a capabilities
+   operation on the crane controller. Older clients do not send a protocol
+   version and the server does not reply.
+3. The client uses the protocol version and capabilities sent by the server,
+   if also supported locally.
+
+As the exchange takes place within the crane channel, it is protected by the
+encryption of the crane or by the secure ship, so that it cannot be tampered
+with in order to downgrade the crane. If either side does not take part in the
+exchange, no optional features are used.
+
+*/
+
+// Crane Protocol Versions.
+const (
+	// CraneProtocolV0 is the initial crane protocol, which does not send any
+	// hub info flags and does not take part in the capabilities exchange.
+	// It is used if the other side does not send a protocol version.
+	CraneProtocolV0 uint8 = 0
+	// CraneProtocolV1 adds the hub info flags, chunked hub info replies and
+	// the capabilities exchange.
+	CraneProtocolV1 uint8 = 1
+
+	// CraneProtocolVersion is the latest crane protocol version spoken by
+	// this Hub.
+	CraneProtocolVersion = CraneProtocolV1
+)
+
+// CraneCapabilities is a set of optional crane features, represented as bit
+// flags. Flags unknown to the local Hub are ignored.
+//
+// Bits 0-47 are assigned to features of the SPN in order of introduction.
+// Assigned bits must never be reused, even if the feature is removed.
+// Bits 48-63 are reserved for experimental features, which must not be
+// enabled in production.
+type CraneCapabilities uint64
+
+// Crane Capabilities.
+const (
+	// CraneCapabilityFlowSync indicates that the crane controller handles flow
+	// sync checks, which may then be started by either side.
+	CraneCapabilityFlowSync CraneCapabilities = 1 << 0
+	// CraneCapabilityControlMsgs indicates that the crane controller handles
+	// control messages that bypass the flow control.
+	CraneCapabilityControlMsgs CraneCapabilities = 1 << 1
+)
+
+// LocalCraneCapabilities holds the capabilities supported by this Hub.
+var LocalCraneCapabilities = CraneCapabilityFlowSync | CraneCapabilityControlMsgs
+
+// Has returns whether all of the given capabilities are set.
+func (c CraneCapabilities) Has(capabilities CraneCapabilities) bool {
+	return c&capabilities == capabilities
+}
+
+// Intersect returns the capabilities that are set in both sets.
+func (c CraneCapabilities) Intersect(other CraneCapabilities) CraneCapabilities {
+	return c & other
+}
+
+// localCapabilities returns the capabilities supported by this side of the
+// crane. The initial protocol does not support any capabilities.
+func (crane *Crane) localCapabilities() CraneCapabilities {
+	if crane.protocolVersion < CraneProtocolV1 {
+		return 0
+	}
+	return LocalCraneCapabilities
+}
+
+// Capabilities returns the optional features both sides of the crane agreed
+// on. On the client, they are only available shortly after the crane started.
+func (crane *Crane) Capabilities() CraneCapabilities {
+	return CraneCapabilities(atomic.LoadUint64(&crane.capabilities))
+}
+
+// ProtocolVersion returns the crane protocol version both sides agreed on.
+// On the client, it is only available shortly after the crane started.
+func (crane *Crane) ProtocolVersion() uint8 {
+	return uint8(atomic.LoadUint32(&crane.agreedProtocolVersion))
+}
+
+// CraneCapabilitiesOpType is the type name of the crane capabilities
+// operation, which tells the client the agreed protocol version and
+// capabilities.
+const CraneCapabilitiesOpType = "crane/capabilities"
+
+// CraneCapabilitiesMessage is the request of the crane capabilities operation.
+type CraneCapabilitiesMessage struct {
+	Version      uint8  `json:"v"`
+	Capabilities uint64 `json:"c"`
+}
+
+func init() {
+	terminal.RegisterRequestResponseOpType(terminal.RequestResponseParams{
+		Type:     CraneCapabilitiesOpType,
+		Requires: terminal.IsCraneController,
+		NewRequest: func() interface{} {
+			return &CraneCapabilitiesMessage{}
+		},
+		Handle: handleCraneCapabilities,
+	})
+}
+
+// agreeOnCapabilities uses the protocol version and capabilities supported by
+// both sides and sends them to the client. It must be called by the server
+// after the crane controller was started.
+func (crane *Crane) agreeOnCapabilities() {
+	// Older clients and servers do not take part in the exchange.
+	if crane.opts.CraneVersion < CraneProtocolV1 || crane.protocolVersion < CraneProtocolV1 {
+		return
+	}
+
+	version := agreeOnProtocolVersion(crane.protocolVersion, crane.opts.CraneVersion)
+	capabilities := crane.localCapabilities().Intersect(CraneCapabilities(crane.opts.CraneCapabilities))
+	crane.applyCapabilities(version, capabilities)
+
+	// Send agreed capabilities to the client before anything else, so that
+	// they are received before any message using them.
+	op, tErr := terminal.NewRequestResponseOp(
+		crane.Controller,
+		CraneCapabilitiesOpType,
+		&CraneCapabilitiesMessage{
+			Version:      version,
+			Capabilities: uint64(capabilities),
+		},
+		nil,
+	)
+	if tErr != nil {
+		log.Warningf("spn/docks: %s failed to send capabilities: %s [%s]", crane, tErr, crane.logFields())
+		return
+	}
+	module.StartWorker("send crane capabilities", func(_ context.Context) error {
+		if tErr := op.Wait(0); tErr.IsError() {
+			log.Warningf("spn/docks: %s failed to send capabilities: %s [%s]", crane, tErr, crane.logFields())
+		}
+		return nil
+	})
+}
+
+func handleCraneCapabilities(t terminal.OpTerminal, request interface{}) (interface{}, *terminal.Error) {
+	// Check if we are a on a crane controller.
+	controller, ok := t.(*CraneControllerTerminal)
+	if !ok {
+		return nil, terminal.ErrIncorrectUsage.With("can only be used with a crane controller")
+	}
+
+	// Only the server decides on the capabilities.
+	crane := controller.Crane
+	if !crane.IsMine() {
+		return nil, terminal.ErrPermissinDenied.With("only the server may send the agreed capabilities")
+	}
+	if crane.protocolVersion < CraneProtocolV1 {
+		return nil, terminal.ErrIncorrectUsage.With("capabilities exchange is not supported by protocol version %d", crane.protocolVersion)
+	}
+
+	// Use the agreed protocol version and capabilities that are also supported
+	// locally.
+	msg := request.(*CraneCapabilitiesMessage)
+	if msg.Version < CraneProtocolV1 {
+		return nil, terminal.ErrInvalidOptions.With("invalid agreed protocol version %d", msg.Version)
+	}
+	crane.applyCapabilities(
+		agreeOnProtocolVersion(crane.protocolVersion, msg.Version),
+		crane.localCapabilities().Intersect(CraneCapabilities(msg.Capabilities)),
+	)
+	return nil, nil
+}
+
+// agreeOnProtocolVersion returns the highest crane protocol version spoken by
+// both sides.
+func agreeOnProtocolVersion(localVersion, remoteVersion uint8) uint8 {
+	if remoteVersion < localVersion {
+		return remoteVersion
+	}
+	return localVersion
+}
+
+// applyCapabilities sets the agreed protocol version and capabilities and
+// starts the features that depend on them.
+func (crane *Crane) applyCapabilities(version uint8, capabilities CraneCapabilities) {
+	atomic.StoreUint64(&crane.capabilities, uint64(capabilities))
+	atomic.StoreUint32(&crane.agreedProtocolVersion, uint32(version))
+	log.Debugf("spn/docks: %s agreed on protocol version %d and capabilities %#x [%s]", crane, version, uint64(capabilities), crane.logFields())
+
+	// Send control messages directly, if supported by both sides.
+	if capabilities.Has(CraneCapabilityControlMsgs) {
+		crane.Controller.EnableDirectControlMsgs()
+	}
+
+	// Start flow sync checks, if enabled.
+	if capabilities.Has(CraneCapabilityFlowSync) {
+		if interval := time.Duration(atomic.LoadInt64(&flowSyncCheckInterval)); interval > 0 {
+			terminal.StartFlowSyncChecks(crane.ctx, crane.Controller, interval)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package docks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/info"
+	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/ships"
+	"github.com/safing/spn/terminal"
+)
+
+func TestCraneProtocolCompatibility(t *testing.T) {
+	identity, connectedHub := getTestIdentity(t)
+	optimalMinLoadSize = 2000
+
+	versions := []uint8{CraneProtocolV0, CraneProtocolV1}
+	for _, clientVersion := range versions {
+		for _, serverVersion := range versions {
+			for _, secure := range []bool{false, true} {
+				clientVersion := clientVersion
+				serverVersion := serverVersion
+				secure := secure
+				t.Run(fmt.Sprintf("v%d-v%d-secure=%v", clientVersion, serverVersion, secure), func(t *testing.T) {
+					testCraneProtocolCompatibility(t, identity, connectedHub, secure, clientVersion, serverVersion)
+				})
+			}
+		}
+	}
+}
+
+func testCraneProtocolCompatibility(
+	t *testing.T,
+	identity *cabin.Identity,
+	connectedHub *hub.Hub,
+	secure bool,
+	clientVersion, serverVersion uint8,
+) {
+	t.Helper()
+
+	agreedVersion := clientVersion
+	if serverVersion < agreedVersion {
+		agreedVersion = serverVersion
+	}
+
+	ship := ships.NewTestShip(secure, 1000)
+	client, server := startCranePair(t, ship, ship.Reverse(), connectedHub, identity, clientVersion, serverVersion)
+	defer client.Stop(nil)
+	defer server.Stop(nil)
+
+	// Check if both sides are able to communicate.
+	op, tErr := terminal.NewCounterOp(client.Controller, terminal.CounterOpts{
+		ClientCountTo: 1000,
+		ServerCountTo: 1000,
+	})
+	if tErr != nil {
+		t.Fatalf("failed to run counter op: %s", tErr)
+	}
+	op.Wait()
+	if op.Error != nil {
+		t.Errorf("counter op failed: %s", op.Error)
+	}
+
+	// Check if the server received the protocol version of the client.
+	if server.opts.CraneVersion != clientVersion {
+		t.Errorf("server received client protocol version %d, expected %d", server.opts.CraneVersion, clientVersion)
+	}
+
+	// Check the negotiated protocol version. The server sent the agreed
+	// capabilities before any data of the counter op, so the client must have
+	// them by now.
+	for _, crane := range []*Crane{client, server} {
+		if v := crane.ProtocolVersion(); v != agreedVersion {
+			t.Errorf("%s agreed on protocol version %d, expected %d", crane, v, agreedVersion)
+		}
+		switch {
+		case agreedVersion < CraneProtocolV1 && crane.Capabilities() != 0:
+			t.Errorf("%s expected no capabilities, got %#x", crane, uint64(crane.Capabilities()))
+		case agreedVersion >= CraneProtocolV1 && !crane.Capabilities().Has(CraneCapabilityFlowSync):
+			t.Errorf("%s expected flow sync capability, got %#x", crane, uint64(crane.Capabilities()))
+		}
+	}
+	if client.Capabilities() != server.Capabilities() {
+		t.Errorf("client and server agreed on different capabilities: %#x != %#x", uint64(client.Capabilities()), uint64(server.Capabilities()))
+	}
+}
+
+func TestCraneStartWithRotatedKey(t *testing.T) {
+	identity, err := cabin.CreateIdentity(module.Ctx, "test")
+	if err != nil {
+		t.Fatalf("failed to create identity: %s", err)
+	}
+	var oldKeyIDs []string
+	for keyID := range identity.Hub.Status.Keys {
+		oldKeyIDs = append(oldKeyIDs, keyID)
+	}
+
+	// Rotate the keys of the server after it sent the hub info, so that the
+	// client starts the encrypted channel with a key that is gone.
+	ship := ships.NewTestShip(false, 1000)
+	serverShip := &keyRotatingShip{
+		Ship:     ship.Reverse(),
+		t:        t,
+		identity: identity,
+	}
+	client, server := startCranePair(t, ship, serverShip, identity.Hub, identity, CraneProtocolV1, CraneProtocolV1)
+	defer client.Stop(nil)
+	defer server.Stop(nil)
+
+	// Check if the keys were rotated before the client started.
+	if !serverShip.rotated {
+		t.Fatal("keys were not rotated")
+	}
+	for _, keyID := range oldKeyIDs {
+		if _, err := identity.GetSignet(keyID, false); err == nil {
+			t.Fatalf("old key %s is still usable", keyID)
+		}
+	}
+
+	// Check if both sides are able to communicate.
+	op, tErr := terminal.NewCounterOp(client.Controller, terminal.CounterOpts{
+		ClientCountTo: 100,
+		ServerCountTo: 100,
+	})
+	if tErr != nil {
+		t.Fatalf("failed to run counter op: %s", tErr)
+	}
+	op.Wait()
+	if op.Error != nil {
+		t.Errorf("counter op failed: %s", op.Error)
+	}
+}
+
+func TestCraneInfoFormatCompatibility(t *testing.T) {
+	identity, connectedHub := getTestIdentity(t)
+	versionInfo := info.GetInfo()
+
+	startClient := func(ship ships.Ship) *Crane {
+		client, err := NewCrane(context.TODO(), ship, connectedHub, nil)
+		if err != nil {
+			t.Fatalf("failed to create client crane: %s", err)
+		}
+		module.StartWorker("crane unloader", client.unloader)
+		return client
+	}
+
+	// New servers reply in the format preferred by the client and use JSON for
+	// older clients, which do not indicate a format.
+	ship := ships.NewTestShip(false, 1000)
+	server, err := NewCrane(context.TODO(), ship.Reverse(), nil, identity)
+	if err != nil {
+		t.Fatalf("failed to create server crane: %s", err)
+	}
+	defer server.Stop(nil)
+	go func() {
+		_ = server.Start()
+	}()
+	client := startClient(ship)
+	for _, format := range []uint8{preferredCraneInfoFormat, 0} {
+		expectedFormat := format
+		if format == 0 {
+			expectedFormat = dsd.JSON
+		}
+		infoData, tErr := client.fetchCraneInfo(format)
+		if tErr != nil {
+			t.Fatalf("failed to request info with format %d: %s", format, tErr)
+		}
+		if len(infoData) == 0 || infoData[0] != expectedFormat {
+			t.Errorf("expected info in format %d for requested format %d", expectedFormat, format)
+		}
+	}
+	receivedInfo, err := client.RequestCraneInfo()
+	if err != nil {
+		t.Fatalf("failed to request info: %s", err)
+	}
+	if receivedInfo.Version != versionInfo.Version {
+		t.Errorf("received version %q, expected %q", receivedInfo.Version, versionInfo.Version)
+	}
+	if tErr := client.endInit(); tErr != nil {
+		t.Errorf("failed to end init: %s", tErr)
+	}
+	client.Stop(nil)
+
+	// Older servers ignore the preferred format and always reply with JSON.
+	ship = ships.NewTestShip(false, 1000)
+	oldServerShip := ship.Reverse()
+	oldServerErrs := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1000)
+		n, err := oldServerShip.UnloadTo(buf)
+		if err != nil {
+			oldServerErrs <- err
+			return
+		}
+		request, err := container.New(buf[:n]).GetNextBlock()
+		if err != nil || len(request) != 2 || request[0] != CraneMsgTypeInfo || request[1] != preferredCraneInfoFormat {
+			oldServerErrs <- fmt.Errorf("unexpected info request %v", request)
+			return
+		}
+
+		infoData, err := dsd.Dump(versionInfo, dsd.JSON)
+		if err != nil {
+			oldServerErrs <- err
+			return
+		}
+		reply := container.New(infoData)
+		reply.PrependLength()
+		oldServerErrs <- oldServerShip.Load(reply.CompileData())
+	}()
+	client = startClient(ship)
+	defer client.Stop(nil)
+	receivedInfo, err = client.RequestCraneInfo()
+	if err != nil {
+		t.Fatalf("failed to request info from older server: %s", err)
+	}
+	if receivedInfo.Version != versionInfo.Version {
+		t.Errorf("received version %q from older server, expected %q", receivedInfo.Version, versionInfo.Version)
+	}
+	if err := <-oldServerErrs; err != nil {
+		t.Errorf("older server failed: %s", err)
+	}
+}
+
+// keyRotatingShip rotates the exchange keys of the identity after the first
+// data was loaded, which is the hub info reply of the server.
+type keyRotatingShip struct {
+	ships.Ship
+
+	t        *testing.T
+	identity *cabin.Identity
+	rotated  bool
+}
+
+func (ship *keyRotatingShip) Load(data []byte) error {
+	err := ship.Ship.Load(data)
+	if err != nil || ship.rotated {
+		return err
+	}
+	ship.rotated = true
+
+	// Wait for the next second, so that the new status is newer.
+	time.Sleep(time.Until(time.Unix(time.Now().Unix()+1, 0)))
+
+	// Burn the current keys by maintaining them after they expired, then
+	// export the status with the new keys.
+	ship.identity.Lock()
+	_, err = ship.identity.MaintainExchKeys(ship.identity.Hub.Status, time.Now().Add(61*time.Hour))
+	ship.identity.Unlock()
+	if err != nil {
+		ship.t.Errorf("failed to rotate keys: %s", err)
+		return nil
+	}
+	load := 1
+	if _, err := ship.identity.MaintainStatus(nil, &load, false); err != nil {
+		ship.t.Errorf("failed to export status: %s", err)
+	}
+	return nil
+}
+
+// startCranePair creates and starts a client and server crane speaking the
+// given crane protocol versions on the given ships.
+func startCranePair(
+	t *testing.T,
+	ship, reverseShip ships.Ship,
+	connectedHub *hub.Hub,
+	identity *cabin.Identity,
+	clientVersion, serverVersion uint8,
+) (client, server *Crane) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	start := func(crane **Crane, ship ships.Ship, connectedHub *hub.Hub, identity *cabin.Identity, version uint8) {
+		defer wg.Done()
+
+		var err error
+		*crane, err = NewCrane(context.TODO(), ship, connectedHub, identity)
+		if err != nil {
+			errs <- fmt.Errorf("failed to create crane: %w", err)
+			return
+		}
+		(*crane).protocolVersion = version
+		if err := (*crane).Start(); err != nil {
+			errs <- fmt.Errorf("failed to start crane: %w", err)
+		}
+	}
+
+	wg.Add(2)
+	go start(&client, ship, connectedHub, nil, clientVersion)
+	go start(&server, reverseShip, nil, identity, serverVersion)
+	wg.Wait()
+
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	return client, server
+}
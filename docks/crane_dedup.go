@@ -0,0 +1,118 @@
+package docks
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/terminal"
+)
+
+// ErrRedundantCrane is returned when a crane is not assigned, because there
+// already is a preferred crane to the same Hub.
+var ErrRedundantCrane = errors.New("redundant crane")
+
+// assignCraneDeduplicated assigns the crane to the given Hub. If there
+// already is a healthy crane assigned to the same Hub, only the preferred one
+// of the two stays assigned and the other one is returned as redundant,
+// together with the preferred one. The redundant crane must be retired by the
+// caller.
+func assignCraneDeduplicated(hubID string, crane *Crane) (redundant, preferred *Crane) {
+	cranesLock.Lock()
+	defer cranesLock.Unlock()
+
+	existing, ok := assignedCranes[hubID]
+	if ok && existing != crane && !existing.Stopped() && !existing.IsStopping() {
+		if preferredCrane(existing, crane) == existing {
+			return crane, existing
+		}
+		redundant = existing
+	}
+
+	assignedCranes[hubID] = crane
+	return redundant, crane
+}
+
+// preferredCrane returns which of two cranes to the same Hub should be kept.
+// The decision is deterministic, so that both Hubs keep the same crane:
+// If the cranes were established by different sides, the crane established
+// by the Hub with the lower ID wins. Otherwise, the older crane wins, as it
+// has proven to be working for longer.
+func preferredCrane(a, b *Crane) *Crane {
+	// Check if the cranes were established by different sides.
+	if a.IsMine() != b.IsMine() {
+		localHubID := a.localHubID()
+		remoteHubID := a.ConnectedHub.ID
+		if localHubID != "" && localHubID != remoteHubID {
+			// Get the crane we established.
+			mine, theirs := a, b
+			if !a.IsMine() {
+				mine, theirs = b, a
+			}
+
+			if localHubID < remoteHubID {
+				return mine
+			}
+			return theirs
+		}
+	}
+
+	// Prefer the older crane.
+	switch {
+	case a.createdAt.Before(b.createdAt):
+		return a
+	case b.createdAt.Before(a.createdAt):
+		return b
+	case a.ID < b.ID:
+		return a
+	default:
+		return b
+	}
+}
+
+// localHubID returns the ID of the local Hub, if known.
+func (crane *Crane) localHubID() string {
+	if crane.identity == nil {
+		return ""
+	}
+	return crane.identity.ID
+}
+
+// retireRedundant retires a crane that lost against a preferred crane to the
+// same Hub. Owned public cranes are marked as stopping, so that their
+// terminals may finish first. Public cranes established by the other side are
+// retired by it, as it comes to the same decision. Cranes that are not public
+// yet do not carry any terminals of others and are stopped directly.
+func (crane *Crane) retireRedundant(preferred *Crane) {
+	switch {
+	case crane.Stopped() || crane.IsStopping():
+		// Already being retired.
+	case crane.Public() && crane.IsMine():
+		if crane.MarkStopping() {
+			log.Infof("spn/docks: %s is redundant, retiring in favor of %s [%s]", crane, preferred, crane.logFields())
+			crane.NotifyUpdate()
+			crane.stopIfRetired()
+		}
+	case crane.Public():
+		log.Infof("spn/docks: %s is redundant, waiting for %s to be retired by the other side [%s]", crane, preferred, crane.logFields())
+	default:
+		log.Infof("spn/docks: %s is redundant, keeping %s [%s]", crane, preferred, crane.logFields())
+		crane.Stop(terminal.ErrStopping.With("redundant crane, keeping %s", preferred))
+	}
+}
+
+// assignDeduplicated assigns the crane to the given Hub and retires the
+// redundant crane, if there is one. Returns ErrRedundantCrane if the given
+// crane itself is redundant.
+func (crane *Crane) assignDeduplicated(hubID string) error {
+	redundant, preferred := assignCraneDeduplicated(hubID, crane)
+	if redundant == nil {
+		return nil
+	}
+
+	redundant.retireRedundant(preferred)
+	if redundant == crane {
+		return fmt.Errorf("spn/docks: %s: %w", crane, ErrRedundantCrane)
+	}
+	return nil
+}
@@ -3,9 +3,8 @@ package docks
 import (
 	"context"
 
-	"github.com/safing/portbase/log"
-
 	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/log"
 	"github.com/safing/spn/terminal"
 )
 
@@ -22,7 +21,7 @@ func (crane *Crane) EstablishNewTerminal(
 	// Send message.
 	select {
 	case crane.importantMsgs <- initData:
-		log.Debugf("spn/docks: %s initiated new terminal %d", crane, localTerm.ID())
+		log.Debugf("spn/docks: %s initiated new terminal %d [%s]", crane, localTerm.ID(), crane.logFields())
 		return nil
 	case <-crane.ctx.Done():
 		crane.AbandonTerminal(localTerm.ID(), terminal.ErrStopping.With("initation aborted"))
@@ -44,12 +43,12 @@ func (crane *Crane) establishTerminal(id uint32, initData *container.Container)
 	if err == nil {
 		// Register terminal with crane.
 		crane.setTerminal(newTerminal)
-		log.Debugf("spn/docks: %s established new crane terminal %d", crane, newTerminal.ID())
+		log.Debugf("spn/docks: %s established new crane terminal %d [%s]", crane, newTerminal.ID(), crane.logFields())
 		return
 	}
 
 	// If something goes wrong, send an error back.
-	log.Warningf("spn/docks: %s failed to establish crane terminal: %s", crane, err)
+	log.Warningf("spn/docks: %s failed to establish crane terminal: %s [%s]", crane, err, crane.logFields())
 
 	// Build abandon message.
 	abandonMsg := container.New(err.Pack())
@@ -1,6 +1,8 @@
 package docks
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/safing/portbase/formats/dsd"
@@ -22,11 +24,35 @@ used by init procedures
 - Data [bytes block]
 	- MsgType [varint]
 	- Data [bytes; only when MsgType is Verify or Start*]
+	- InfoFormat [varint; optional, only when MsgType is Info]
+	- HubInfoFlags [varint; optional, only when MsgType is RequestHubInfo]
 
 Crane Init Response Format:
 
 - Data [bytes block]
 
+Hub Info Response Format:
+
+- Announcement [bytes block]
+- Status [bytes block]
+- HubInfoFlags [varint; optional, only when requested with HubInfoFlags]
+
+Crane Start Reply Format:
+only sent when requested with HubInfoFlagStartReply
+
+- Data [bytes block]
+	- StartReply [varint]
+
+Chunked Hub Info Response Format:
+used when the hub info does not fit into a single response
+
+- First Chunk [bytes block]
+	- Marker [byte; always 0]
+	- ChunkCount [varint]
+	- Data [bytes]
+- Following Chunks [bytes block]
+	- Data [bytes]
+
 Crane Operational Message Format:
 
 - Data [bytes block]
@@ -34,6 +60,21 @@ Crane Operational Message Format:
 
 */
 
+var (
+	// HubNotReadyRetries defines how often a crane retries to get usable keys
+	// from a Hub that is not ready yet, eg. because it just restarted and has
+	// not announced new keys yet. Set to 0 to fail fast.
+	HubNotReadyRetries = 3
+
+	// HubNotReadyRetryDelay defines the delay before the first retry to get
+	// usable keys from a Hub. The delay is doubled with every retry.
+	HubNotReadyRetryDelay = 1 * time.Second
+)
+
+// maxHubNotReadyRetryDelay caps the retry delay, as the remote Hub only waits
+// 5 seconds for the next crane init message.
+const maxHubNotReadyRetryDelay = 4 * time.Second
+
 const (
 	CraneMsgTypeEnd              = 0
 	CraneMsgTypeInfo             = 1
@@ -43,8 +84,46 @@ const (
 	CraneMsgTypeStartUnencrypted = 5
 )
 
+const (
+	// HubInfoFlagChunked indicates that the requester is able to reassemble
+	// hub info that is sent in multiple chunks.
+	HubInfoFlagChunked = 1
+	// HubInfoFlagStartReply indicates that the requester wants to be told
+	// whether the encrypted channel could be started. If the remote Hub
+	// supports it, it includes the flag in its reply.
+	HubInfoFlagStartReply = 2
+
+	// supportedHubInfoFlags holds the hub info flags supported by this Hub.
+	supportedHubInfoFlags = HubInfoFlagChunked | HubInfoFlagStartReply
+
+	// hubInfoChunkedMarker starts the first chunk of a chunked hub info reply.
+	// Single hub info replies always start with the non-zero length of the
+	// announcement block.
+	hubInfoChunkedMarker = 0
+	// hubInfoChunkSize defines the maximum size of hub info data per reply.
+	// It leaves room for the chunk header and length prefix, so that every
+	// chunk passes the maxUnloadSize check of the requester.
+	hubInfoChunkSize = maxUnloadSize - 64
+	// maxHubInfoChunks limits the amount of chunks of a hub info reply.
+	maxHubInfoChunks = 16
+)
+
+// Crane Start Replies.
+const (
+	// CraneStartReplyOK means that the encrypted channel was started.
+	CraneStartReplyOK = 1
+	// CraneStartReplyFailed means that the remote Hub failed to decrypt the
+	// start message, eg. because it rotated its keys in the meantime.
+	CraneStartReplyFailed = 2
+)
+
+// maxCraneStartRetries defines how often an encrypted channel is started
+// again with updated hub info, after the remote Hub failed to decrypt the
+// start message.
+const maxCraneStartRetries = 2
+
 func (crane *Crane) Start() error {
-	log.Infof("spn/docks: %s is starting", crane)
+	log.Infof("spn/docks: %s is starting [%s]", crane, crane.logFields())
 
 	// Submit metrics.
 	newCranes.Inc()
@@ -62,7 +141,7 @@ func (crane *Crane) Start() error {
 		crane.Stop(tErr)
 		return tErr
 	} else {
-		log.Debugf("spn/docks: %s started", crane)
+		log.Debugf("spn/docks: %s started [%s]", crane, crane.logFields())
 		// Return an explicit nil for working "!= nil" checks.
 		return nil
 	}
@@ -71,113 +150,206 @@ func (crane *Crane) Start() error {
 func (crane *Crane) startLocal() *terminal.Error {
 	module.StartWorker("crane unloader", crane.unloader)
 
-	if !crane.ship.IsSecure() {
+	// Check if the link needs encryption.
+	secure := crane.ship.IsSecure()
+	if !secure && isTrustedLink(crane.ship) {
+		secure = true
+		log.Warningf("spn/docks: %s skipping encryption on trusted link to %s [%s]", crane, crane.ship.MaskAddress(crane.ship.RemoteAddr()), crane.logFields())
+	}
+
+	// Check if we have all the data we need from the Hub.
+	if !secure && crane.ConnectedHub == nil {
+		return terminal.ErrIncorrectUsage.With("cannot start encrypted channel without connected hub")
+	}
+
+	// Create crane controller.
+	// Our protocol version and capabilities are sent with the controller
+	// options, so that they are protected by the encrypted channel or the
+	// secure ship.
+	_, initData, tErr := NewLocalCraneControllerTerminal(crane, &terminal.TerminalOpts{
+		QueueSize:         terminal.DefaultQueueSize,
+		Padding:           8,
+		CraneVersion:      crane.protocolVersion,
+		CraneCapabilities: uint64(crane.localCapabilities()),
+	})
+	if tErr != nil {
+		return tErr.Wrap("failed to set up controller")
+	}
+
+	if secure {
+		// Send start message.
+		initData.PrependNumber(CraneMsgTypeStartUnencrypted)
+		initData.PrependLength()
+		err := crane.loadShip(initData.CompileData())
+		if err != nil {
+			return terminal.ErrShipSunk.With("failed to send init msg: %w", err)
+		}
+	} else {
 		// Start encrypted channel.
-		// Check if we have all the data we need from the Hub.
-		if crane.ConnectedHub == nil {
-			return terminal.ErrIncorrectUsage.With("cannot start encrypted channel without connected hub")
+		tErr := crane.startEncrypted(initData.CompileData())
+		if tErr != nil {
+			return tErr
 		}
+	}
 
-		// Always request hub info, as we don't know if the hub has restarted in
-		// the meantime and lost ephemeral keys.
-		hubInfoRequest := container.New(
-			varint.Pack8(CraneMsgTypeRequestHubInfo),
-		)
-		hubInfoRequest.PrependLength()
-		err := crane.ship.Load(hubInfoRequest.CompileData())
-		if err != nil {
-			return terminal.ErrShipSunk.With("failed to request hub info: %w", err)
+	// Start remaining workers.
+	module.StartWorker("crane loader", crane.loader)
+	module.StartWorker("crane handler", crane.handler)
+
+	return nil
+}
+
+// startEncrypted sets up encryption with the signets of the connected Hub and
+// sends the encrypted init message. If the Hub replies that it failed to
+// decrypt the init message, eg. because it rotated its keys in the meantime,
+// the hub info is requested again and the init message is sent again with a
+// signet that was not rejected yet.
+func (crane *Crane) startEncrypted(initMsg []byte) *terminal.Error {
+	var rejected []string
+	for i := 0; ; i++ {
+		// Get signets of the Hub, retrying with backoff if the Hub is not
+		// ready yet.
+		signets, startReply, tErr := crane.getHubSignets()
+		if tErr != nil {
+			return tErr
+		}
+		signets = withoutSignets(signets, rejected)
+		if len(signets) == 0 {
+			return terminal.ErrHubNotReady.With("no signets left after hub failed to decrypt init msg with %s", strings.Join(rejected, ", "))
 		}
 
-		// Wait for reply.
-		var reply *container.Container
-		select {
-		case reply = <-crane.unloading:
-		case <-time.After(5 * time.Second):
-			return terminal.ErrTimeout.With("timed out waiting for hub info")
-		case <-crane.ctx.Done():
-			return terminal.ErrShipSunk.With("waiting for hub info")
+		// Configure encryption.
+		signet, tErr := crane.setUpEncryption(signets)
+		if tErr != nil {
+			return tErr
 		}
 
-		// Parse and import Announcement and Status.
-		announcementData, err := reply.GetNextBlock()
+		// Encrypt controller initializer.
+		// Encrypt a copy, as the init message may need to be sent again.
+		letter, err := crane.jession.Close(append([]byte(nil), initMsg...))
+		if err != nil {
+			return terminal.ErrInternalError.With("failed to encrypt initial packet: %w", err)
+		}
+		initData, err := letter.ToWire()
 		if err != nil {
-			return terminal.ErrMalformedData.With("failed to get announcement: %w", err)
+			return terminal.ErrInternalError.With("failed to pack initial packet: %w", err)
 		}
-		statusData, err := reply.GetNextBlock()
+		initData.PrependNumber(CraneMsgTypeStartEncrypted)
+
+		// Send start message.
+		initData.PrependLength()
+		err = crane.loadShip(initData.CompileData())
 		if err != nil {
-			return terminal.ErrMalformedData.With("failed to get status: %w", err)
+			return terminal.ErrShipSunk.With("failed to send init msg: %w", err)
 		}
-		h, _, tErr := ImportAndVerifyHubInfo(
-			crane.ctx,
-			crane.ConnectedHub.ID,
-			announcementData, statusData, conf.MainMapName, conf.MainMapScope,
-		)
-		if tErr != nil {
-			return tErr.Wrap("failed to import and verify hub")
+
+		// Hubs that do not reply to the start message report failures by
+		// closing the connection.
+		if !startReply {
+			return nil
+		}
+		started, tErr := crane.waitForStartReply()
+		switch {
+		case tErr != nil:
+			return tErr
+		case started:
+			return nil
 		}
-		// Update reference in case it was changed by the import.
-		crane.ConnectedHub = h
 
-		// Now, try to select a public key again.
-		signet := crane.ConnectedHub.SelectSignet()
-		if signet == nil {
-			return terminal.ErrHubNotReady.With("failed to select signet (after updating hub info)")
+		// The Hub failed to decrypt the init message.
+		crane.jession = nil
+		rejected = append(rejected, signet.ID)
+		if i >= maxCraneStartRetries {
+			return terminal.ErrIntegrity.With("hub failed to decrypt init msg with %s", strings.Join(rejected, ", "))
 		}
+		log.Warningf("spn/docks: %s hub failed to decrypt init msg with signet %s, retrying with updated hub info [%s]", crane, signet.ID, crane.logFields())
+	}
+}
 
-		// Configure encryption.
+// setUpEncryption sets up the encryption session with the first usable of the
+// given signets and returns it.
+func (crane *Crane) setUpEncryption(signets []*jess.Signet) (*jess.Signet, *terminal.Error) {
+	// Try all available signets, as the Hub may be rotating keys.
+	var err error
+	for i, signet := range signets {
 		env := jess.NewUnconfiguredEnvelope()
 		env.SuiteID = jess.SuiteWireV1
 		env.Recipients = []*jess.Signet{signet}
 
 		// Do not encrypt directly, rather get session for future use, then encrypt.
 		crane.jession, err = env.WireCorrespondence(nil)
-		if err != nil {
-			return terminal.ErrInternalError.With("failed to create encryption session: %w", err)
+		if err == nil {
+			if i > 0 {
+				log.Infof("spn/docks: %s set up encryption with alternative signet %s [%s]", crane, signet.ID, crane.logFields())
+			} else {
+				log.Debugf("spn/docks: %s set up encryption with signet %s [%s]", crane, signet.ID, crane.logFields())
+			}
+			return signet, nil
 		}
+		log.Warningf("spn/docks: %s failed to set up encryption with signet %s: %s [%s]", crane, signet.ID, err, crane.logFields())
 	}
 
-	// Create crane controller.
-	_, initData, tErr := NewLocalCraneControllerTerminal(crane, &terminal.TerminalOpts{
-		QueueSize: terminal.DefaultQueueSize,
-		Padding:   8,
-	})
-	if tErr != nil {
-		return tErr.Wrap("failed to set up controller")
-	}
+	return nil, terminal.ErrInternalError.With("failed to create encryption session with any of %d signets: %w", len(signets), err)
+}
 
-	// Prepare init message for sending.
-	if crane.ship.IsSecure() {
-		initData.PrependNumber(CraneMsgTypeStartUnencrypted)
-	} else {
-		// Encrypt controller initializer.
-		letter, err := crane.jession.Close(initData.CompileData())
-		if err != nil {
-			return terminal.ErrInternalError.With("failed to encrypt initial packet: %w", err)
-		}
-		initData, err = letter.ToWire()
-		if err != nil {
-			return terminal.ErrInternalError.With("failed to pack initial packet: %w", err)
+// withoutSignets returns the signets that do not have any of the given IDs.
+func withoutSignets(signets []*jess.Signet, ids []string) []*jess.Signet {
+	filtered := make([]*jess.Signet, 0, len(signets))
+signets:
+	for _, signet := range signets {
+		for _, id := range ids {
+			if signet.ID == id {
+				continue signets
+			}
 		}
-		initData.PrependNumber(CraneMsgTypeStartEncrypted)
+		filtered = append(filtered, signet)
 	}
+	return filtered
+}
 
-	// Send start message.
-	initData.PrependLength()
-	err := crane.ship.Load(initData.CompileData())
-	if err != nil {
-		return terminal.ErrShipSunk.With("failed to send init msg: %w", err)
+// waitForStartReply waits for the reply of the Hub to the encrypted start
+// message and returns whether the encrypted channel was started.
+func (crane *Crane) waitForStartReply() (started bool, tErr *terminal.Error) {
+	var reply *container.Container
+	select {
+	case reply = <-crane.unloading:
+	case <-time.After(5 * time.Second):
+		return false, terminal.ErrTimeout.With("timed out waiting for start reply")
+	case <-crane.ctx.Done():
+		return false, terminal.ErrShipSunk.With("waiting for start reply")
 	}
 
-	// Start remaining workers.
-	module.StartWorker("crane loader", crane.loader)
-	module.StartWorker("crane handler", crane.handler)
+	startReply, err := reply.GetNextN8()
+	if err != nil {
+		return false, terminal.ErrMalformedData.With("failed to parse start reply: %w", err)
+	}
+	switch startReply {
+	case CraneStartReplyOK:
+		return true, nil
+	case CraneStartReplyFailed:
+		return false, nil
+	default:
+		return false, terminal.ErrMalformedData.With("unknown start reply %d", startReply)
+	}
+}
 
+// sendStartReply tells the client whether the encrypted channel was started.
+func (crane *Crane) sendStartReply(startReply uint8) *terminal.Error {
+	msg := container.New(varint.Pack8(startReply))
+	msg.PrependLength()
+	err := crane.loadShip(msg.CompileData())
+	if err != nil {
+		return terminal.ErrShipSunk.With("failed to send start reply: %w", err)
+	}
 	return nil
 }
 
 func (crane *Crane) startRemote() *terminal.Error {
-	var initMsg *container.Container
+	var (
+		initMsg      *container.Container
+		startReply   bool
+		failedStarts int
+	)
 
 	module.StartWorker("crane unloader", crane.unloader)
 
@@ -206,19 +378,20 @@ handling:
 
 		case CraneMsgTypeInfo:
 			// Info is a terminating request.
-			err := crane.handleCraneInfo()
+			err := crane.handleCraneInfo(request)
 			if err != nil {
 				return err
 			}
-			log.Debugf("spn/docks: %s sent version info", crane)
+			log.Debugf("spn/docks: %s sent version info [%s]", crane, crane.logFields())
 
 		case CraneMsgTypeRequestHubInfo:
 			// Handle Hub info request.
-			err := crane.handleCraneHubInfo()
+			flags, err := crane.handleCraneHubInfo(request)
 			if err != nil {
 				return err
 			}
-			log.Debugf("spn/docks: %s sent hub info", crane)
+			startReply = flags&HubInfoFlagStartReply != 0
+			log.Debugf("spn/docks: %s sent hub info [%s]", crane, crane.logFields())
 
 		case CraneMsgTypeVerify:
 			// Verify is a terminating request.
@@ -226,14 +399,21 @@ handling:
 			if err != nil {
 				return err
 			}
-			log.Debugf("spn/docks: %s sent hub verification", crane)
+			log.Debugf("spn/docks: %s sent hub verification [%s]", crane, crane.logFields())
 
 		case CraneMsgTypeStartUnencrypted:
+			// Only accept unencrypted channels on secure ships or trusted links.
+			if !crane.ship.IsSecure() {
+				if !isTrustedLink(crane.ship) {
+					return terminal.ErrPermissinDenied.With("unencrypted channel on insecure ship")
+				}
+				log.Warningf("spn/docks: %s accepting unencrypted channel on trusted link from %s [%s]", crane, crane.ship.MaskAddress(crane.ship.RemoteAddr()), crane.logFields())
+			}
 			initMsg = request
 
 			// Start crane with initMsg.
+			log.Debugf("spn/docks: %s initiated unencrypted channel [%s]", crane, crane.logFields())
 			break handling
-			log.Debugf("spn/docks: %s initiated unencrypted channel", crane)
 
 		case CraneMsgTypeStartEncrypted:
 			if crane.identity == nil {
@@ -241,22 +421,30 @@ handling:
 			}
 
 			// Set up encryption.
-			letter, err := jess.LetterFromWireData(request.CompileData())
-			if err != nil {
-				return terminal.ErrMalformedData.With("failed to unpack initial packet: %w", err)
+			initMsgData, tErr := crane.openInitMsg(request)
+			if tErr != nil {
+				// Let the client retry with updated hub info, if it asked for a
+				// reply, as we may have rotated our keys in the meantime.
+				if !startReply || failedStarts >= maxCraneStartRetries || tErr.Is(terminal.ErrMalformedData) {
+					return tErr
+				}
+				failedStarts++
+				crane.jession = nil
+				log.Warningf("spn/docks: %s failed to start encrypted channel, letting client retry: %s [%s]", crane, tErr, crane.logFields())
+				if tErr := crane.sendStartReply(CraneStartReplyFailed); tErr != nil {
+					return tErr
+				}
+				continue handling
 			}
-			crane.jession, err = letter.WireCorrespondence(crane.identity)
-			if err != nil {
-				return terminal.ErrInternalError.With("failed to create encryption session: %w", err)
-			}
-			initMsgData, err := crane.jession.Open(letter)
-			if err != nil {
-				return terminal.ErrIntegrity.With("failed to decrypt initial packet: %w", err)
+			if startReply {
+				if tErr := crane.sendStartReply(CraneStartReplyOK); tErr != nil {
+					return tErr
+				}
 			}
 			initMsg = container.New(initMsgData)
 
 			// Start crane with initMsg.
-			log.Debugf("spn/docks: %s initiated encrypted channel", crane)
+			log.Debugf("spn/docks: %s initiated encrypted channel [%s]", crane, crane.logFields())
 			break handling
 		}
 	}
@@ -266,6 +454,9 @@ handling:
 		return err.Wrap("failed to start crane controller")
 	}
 
+	// Agree on capabilities, if the client took part in the exchange.
+	crane.agreeOnCapabilities()
+
 	// Start remaining workers.
 	module.StartWorker("crane loader", crane.loader)
 	module.StartWorker("crane handler", crane.handler)
@@ -273,21 +464,110 @@ handling:
 	return nil
 }
 
+// openInitMsg sets up the encryption session with the encrypted init message
+// and returns the decrypted init message.
+func (crane *Crane) openInitMsg(request *container.Container) ([]byte, *terminal.Error) {
+	letter, err := jess.LetterFromWireData(request.CompileData())
+	if err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to unpack initial packet: %w", err)
+	}
+	crane.jession, err = letter.WireCorrespondence(crane.identity)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to create encryption session: %w", err)
+	}
+	initMsgData, err := crane.jession.Open(letter)
+	if err != nil {
+		return nil, terminal.ErrIntegrity.With("failed to decrypt initial packet: %w", err)
+	}
+	return initMsgData, nil
+}
+
 func (crane *Crane) endInit() *terminal.Error {
 	endMsg := container.New(
 		varint.Pack8(CraneMsgTypeEnd),
 	)
 	endMsg.PrependLength()
-	err := crane.ship.Load(endMsg.CompileData())
+	err := crane.loadShip(endMsg.CompileData())
 	if err != nil {
 		return terminal.ErrShipSunk.With("failed to send end msg: %w", err)
 	}
 	return nil
 }
 
-func (crane *Crane) handleCraneInfo() *terminal.Error {
-	// Pack info data.
-	infoData, err := dsd.Dump(info.GetInfo(), dsd.JSON)
+// preferredCraneInfoFormat is the format in which the info of the connected
+// Hub is requested. Hubs that do not support it reply with JSON.
+const preferredCraneInfoFormat = dsd.CBOR
+
+// RequestCraneInfo requests the version info of the connected Hub. It must
+// be executed in the init phase by the client.
+func (crane *Crane) RequestCraneInfo() (*info.Info, error) {
+	if !crane.ship.IsMine() || crane.nextTerminalID != 0 {
+		return nil, errors.New("crane info can only be requested in init phase by the client")
+	}
+
+	infoData, tErr := crane.fetchCraneInfo(preferredCraneInfoFormat)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	// The format of the reply is detected, as older Hubs always use JSON.
+	versionInfo := &info.Info{}
+	_, err := dsd.Load(infoData, versionInfo)
+	if err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to parse info: %w", err)
+	}
+	return versionInfo, nil
+}
+
+// fetchCraneInfo requests the info of the connected Hub in the given format
+// and returns the raw reply. If format is 0, no format is indicated, like
+// older clients do.
+func (crane *Crane) fetchCraneInfo(format uint8) ([]byte, *terminal.Error) {
+	// Send request with the preferred format.
+	request := container.New(varint.Pack8(CraneMsgTypeInfo))
+	if format != 0 {
+		request.Append(varint.Pack8(format))
+	}
+	request.PrependLength()
+	err := crane.loadShip(request.CompileData())
+	if err != nil {
+		return nil, terminal.ErrShipSunk.With("failed to request info: %w", err)
+	}
+
+	// Wait for reply.
+	select {
+	case reply := <-crane.unloading:
+		return reply.CompileData(), nil
+	case <-time.After(5 * time.Second):
+		return nil, terminal.ErrTimeout.With("timed out waiting for info")
+	case <-crane.ctx.Done():
+		return nil, terminal.ErrShipSunk.With("waiting for info")
+	}
+}
+
+// getCraneInfoFormat returns the format the info should be sent in.
+// Older clients do not indicate a preferred format, so JSON is used as the
+// default. Unknown formats also fall back to JSON.
+func getCraneInfoFormat(request *container.Container) uint8 {
+	if request.Length() == 0 {
+		return dsd.JSON
+	}
+
+	format, err := request.GetNextN8()
+	if err != nil {
+		return dsd.JSON
+	}
+	switch format {
+	case dsd.JSON, dsd.CBOR:
+		return format
+	default:
+		return dsd.JSON
+	}
+}
+
+func (crane *Crane) handleCraneInfo(request *container.Container) *terminal.Error {
+	// Pack info data in the format requested by the client.
+	infoData, err := dsd.Dump(info.GetInfo(), getCraneInfoFormat(request))
 	if err != nil {
 		return terminal.ErrInternalError.With("failed to pack info: %w", err)
 	}
@@ -295,7 +575,7 @@ func (crane *Crane) handleCraneInfo() *terminal.Error {
 
 	// Manually send reply.
 	msg.PrependLength()
-	err = crane.ship.Load(msg.CompileData())
+	err = crane.loadShip(msg.CompileData())
 	if err != nil {
 		return terminal.ErrShipSunk.With("failed to send info reply: %w", err)
 	}
@@ -303,34 +583,244 @@ func (crane *Crane) handleCraneInfo() *terminal.Error {
 	return nil
 }
 
-func (crane *Crane) handleCraneHubInfo() *terminal.Error {
+// getHubInfoFlags returns the hub info flags sent by the requester.
+// Older clients do not send any flags.
+func getHubInfoFlags(request *container.Container) uint64 {
+	if request.Length() == 0 {
+		return 0
+	}
+
+	flags, err := request.GetNextN64()
+	if err != nil {
+		return 0
+	}
+	return flags
+}
+
+// handleCraneHubInfo replies with the hub info and returns the hub info flags
+// that were requested and are supported by this Hub.
+func (crane *Crane) handleCraneHubInfo(request *container.Container) (flags uint64, tErr *terminal.Error) {
 	msg := container.New()
 
 	// Check if we have an identity.
 	if crane.identity == nil {
-		return terminal.ErrIncorrectUsage.With("cannot handle hub info request without designated identity")
+		return 0, terminal.ErrIncorrectUsage.With("cannot handle hub info request without designated identity")
+	}
+
+	// Hubs speaking the initial protocol ignore the flags.
+	if crane.protocolVersion >= CraneProtocolV1 {
+		flags = getHubInfoFlags(request) & supportedHubInfoFlags
 	}
 
 	// Add Hub Announcement.
 	announcementData, err := crane.identity.ExportAnnouncement()
 	if err != nil {
-		return terminal.ErrInternalError.With("failed to export announcement: %w", err)
+		return 0, terminal.ErrInternalError.With("failed to export announcement: %w", err)
 	}
 	msg.AppendAsBlock(announcementData)
 
 	// Add Hub Status.
 	statusData, err := crane.identity.ExportStatus()
 	if err != nil {
-		return terminal.ErrInternalError.With("failed to export status: %w", err)
+		return 0, terminal.ErrInternalError.With("failed to export status: %w", err)
 	}
 	msg.AppendAsBlock(statusData)
 
+	// Add supported flags, if the requester sent any.
+	if flags != 0 {
+		msg.Append(varint.Pack64(flags))
+	}
+
+	// Split into chunks, if needed and supported by the requester.
+	replies, tErr := packHubInfoReply(msg.CompileData(), flags&HubInfoFlagChunked != 0)
+	if tErr != nil {
+		return 0, tErr
+	}
+
 	// Manually send reply.
-	msg.PrependLength()
-	err = crane.ship.Load(msg.CompileData())
+	for _, reply := range replies {
+		reply.PrependLength()
+		err = crane.loadShip(reply.CompileData())
+		if err != nil {
+			return 0, terminal.ErrShipSunk.With("failed to send hub info reply: %w", err)
+		}
+	}
+
+	return flags, nil
+}
+
+// packHubInfoReply packs the hub info data into replies that each fit into a
+// single shipment. Hub info that fits is always sent in a single reply.
+func packHubInfoReply(data []byte, chunked bool) ([]*container.Container, *terminal.Error) {
+	// Send small hub info in one go.
+	if len(data) <= hubInfoChunkSize {
+		return []*container.Container{container.New(data)}, nil
+	}
+	if !chunked {
+		return nil, terminal.ErrInternalError.With(
+			"hub info of %d bytes exceeds maximum of %d bytes and requester does not support chunking",
+			len(data), hubInfoChunkSize,
+		)
+	}
+
+	// Split into chunks.
+	chunkCount := (len(data) + hubInfoChunkSize - 1) / hubInfoChunkSize
+	if chunkCount > maxHubInfoChunks {
+		return nil, terminal.ErrInternalError.With(
+			"hub info of %d bytes exceeds maximum of %d chunks",
+			len(data), maxHubInfoChunks,
+		)
+	}
+	replies := make([]*container.Container, 0, chunkCount)
+	for len(data) > 0 {
+		size := hubInfoChunkSize
+		if size > len(data) {
+			size = len(data)
+		}
+		reply := container.New(data[:size])
+		data = data[size:]
+
+		// Add header to first chunk.
+		if len(replies) == 0 {
+			reply.Prepend(varint.Pack64(uint64(chunkCount)))
+			reply.Prepend([]byte{hubInfoChunkedMarker})
+		}
+		replies = append(replies, reply)
+	}
+
+	return replies, nil
+}
+
+// unpackHubInfoReply returns the hub info data from the first received reply.
+// If the reply is chunked, the remaining chunks are received via next and
+// reassembled.
+func unpackHubInfoReply(
+	first *container.Container,
+	next func() (*container.Container, *terminal.Error),
+) (*container.Container, *terminal.Error) {
+	// Check if the reply is chunked.
+	data := first.CompileData()
+	if len(data) == 0 {
+		return nil, terminal.ErrMalformedData.With("empty hub info reply")
+	}
+	if data[0] != hubInfoChunkedMarker {
+		return first, nil
+	}
+
+	// Parse header.
+	reply := container.New(data[1:])
+	chunkCount, err := reply.GetNextN64()
 	if err != nil {
-		return terminal.ErrShipSunk.With("failed to send hub info reply: %w", err)
+		return nil, terminal.ErrMalformedData.With("failed to get hub info chunk count: %w", err)
+	}
+	if chunkCount < 2 || chunkCount > maxHubInfoChunks {
+		return nil, terminal.ErrMalformedData.With("invalid hub info chunk count: %d", chunkCount)
 	}
 
-	return nil
+	// Receive and append remaining chunks.
+	for i := uint64(1); i < chunkCount; i++ {
+		chunk, tErr := next()
+		if tErr != nil {
+			return nil, tErr.Wrap("failed to get hub info chunk %d/%d", i+1, chunkCount)
+		}
+		reply.AppendContainer(chunk)
+	}
+
+	return reply, nil
+}
+
+// getHubSignets requests the current hub info from the connected Hub and
+// returns the signets usable for starting an encrypted channel and whether the
+// Hub replies to the start message. If the Hub is not ready, it is retried
+// according to HubNotReadyRetries.
+func (crane *Crane) getHubSignets() (signets []*jess.Signet, startReply bool, tErr *terminal.Error) {
+	retryDelay := HubNotReadyRetryDelay
+	for i := 0; ; i++ {
+		signets, startReply, tErr = crane.requestHubSignets()
+		switch {
+		case tErr == nil:
+			return signets, startReply, nil
+		case !tErr.Is(terminal.ErrHubNotReady) || i >= HubNotReadyRetries:
+			return nil, false, tErr
+		}
+
+		// Wait before retrying.
+		log.Debugf("spn/docks: %s hub not ready, retrying in %s: %s [%s]", crane, retryDelay, tErr, crane.logFields())
+		select {
+		case <-time.After(retryDelay):
+		case <-crane.ctx.Done():
+			return nil, false, terminal.ErrShipSunk.With("waiting for hub to become ready")
+		}
+		retryDelay *= 2
+		if retryDelay > maxHubNotReadyRetryDelay {
+			retryDelay = maxHubNotReadyRetryDelay
+		}
+	}
+}
+
+// requestHubSignets requests the current hub info from the connected Hub
+// and returns the signets usable for starting an encrypted channel and
+// whether the Hub replies to the start message.
+func (crane *Crane) requestHubSignets() (signets []*jess.Signet, startReply bool, tErr *terminal.Error) {
+	// Always request hub info, as we don't know if the hub has restarted in
+	// the meantime and lost ephemeral keys.
+	// Hubs speaking the initial protocol ignore the flags.
+	hubInfoRequest := container.New(varint.Pack8(CraneMsgTypeRequestHubInfo))
+	if crane.protocolVersion >= CraneProtocolV1 {
+		hubInfoRequest.Append(varint.Pack64(HubInfoFlagChunked | HubInfoFlagStartReply))
+	}
+	hubInfoRequest.PrependLength()
+	err := crane.loadShip(hubInfoRequest.CompileData())
+	if err != nil {
+		return nil, false, terminal.ErrShipSunk.With("failed to request hub info: %w", err)
+	}
+
+	// Wait for reply.
+	waitForReply := func() (*container.Container, *terminal.Error) {
+		select {
+		case c := <-crane.unloading:
+			return c, nil
+		case <-time.After(5 * time.Second):
+			return nil, terminal.ErrTimeout.With("timed out waiting for hub info")
+		case <-crane.ctx.Done():
+			return nil, terminal.ErrShipSunk.With("waiting for hub info")
+		}
+	}
+	firstReply, tErr := waitForReply()
+	if tErr != nil {
+		return nil, false, tErr
+	}
+	reply, tErr := unpackHubInfoReply(firstReply, waitForReply)
+	if tErr != nil {
+		return nil, false, tErr
+	}
+
+	// Parse and import Announcement and Status.
+	announcementData, err := reply.GetNextBlock()
+	if err != nil {
+		return nil, false, terminal.ErrMalformedData.With("failed to get announcement: %w", err)
+	}
+	statusData, err := reply.GetNextBlock()
+	if err != nil {
+		return nil, false, terminal.ErrMalformedData.With("failed to get status: %w", err)
+	}
+	// Hubs that do not support any of the requested flags do not send flags.
+	flags := getHubInfoFlags(reply)
+	h, _, tErr := ImportAndVerifyHubInfo(
+		crane.ctx,
+		crane.ConnectedHub.ID,
+		announcementData, statusData, conf.MainMapName, conf.MainMapScope,
+	)
+	if tErr != nil {
+		return nil, false, tErr.Wrap("failed to import and verify hub")
+	}
+	// Update reference in case it was changed by the import.
+	crane.ConnectedHub = h
+
+	// Now, try to select a public key again.
+	signets = crane.ConnectedHub.SelectSignets()
+	if len(signets) == 0 {
+		return nil, false, terminal.ErrHubNotReady.With("failed to select signet (after updating hub info)")
+	}
+	return signets, flags&HubInfoFlagStartReply != 0, nil
 }
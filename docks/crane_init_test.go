@@ -0,0 +1,119 @@
+package docks
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/spn/terminal"
+)
+
+func TestGetCraneInfoFormat(t *testing.T) {
+	t.Parallel()
+
+	// Old clients do not send a format.
+	if f := getCraneInfoFormat(container.New()); f != dsd.JSON {
+		t.Errorf("expected JSON as default, got %d", f)
+	}
+
+	// New clients may request CBOR.
+	if f := getCraneInfoFormat(container.New(varint.Pack8(dsd.CBOR))); f != dsd.CBOR {
+		t.Errorf("expected CBOR, got %d", f)
+	}
+
+	// Unsupported formats fall back to JSON.
+	if f := getCraneInfoFormat(container.New(varint.Pack8(dsd.MsgPack))); f != dsd.JSON {
+		t.Errorf("expected JSON fallback, got %d", f)
+	}
+}
+
+func TestGetHubInfoFlags(t *testing.T) {
+	t.Parallel()
+
+	// Old clients do not send flags.
+	if f := getHubInfoFlags(container.New()); f != 0 {
+		t.Errorf("expected no flags, got %d", f)
+	}
+
+	// New clients support chunking.
+	if f := getHubInfoFlags(container.New(varint.Pack64(HubInfoFlagChunked))); f&HubInfoFlagChunked == 0 {
+		t.Errorf("expected chunked flag, got %d", f)
+	}
+}
+
+func TestHubInfoChunking(t *testing.T) {
+	t.Parallel()
+
+	testReassembly := func(data []byte, expectedReplies int) {
+		replies, tErr := packHubInfoReply(data, true)
+		if tErr != nil {
+			t.Fatal(tErr)
+		}
+		if len(replies) != expectedReplies {
+			t.Fatalf("expected %d replies, got %d", expectedReplies, len(replies))
+		}
+		for _, reply := range replies {
+			if reply.Length() > maxUnloadSize-2 {
+				t.Fatalf("reply of %d bytes exceeds maximum unload size", reply.Length())
+			}
+		}
+
+		next := 1
+		reply, tErr := unpackHubInfoReply(replies[0], func() (*container.Container, *terminal.Error) {
+			if next >= len(replies) {
+				return nil, terminal.ErrTimeout.With("no more replies")
+			}
+			next++
+			return replies[next-1], nil
+		})
+		if tErr != nil {
+			t.Fatal(tErr)
+		}
+		if !bytes.Equal(reply.CompileData(), data) {
+			t.Fatal("reassembled hub info does not match")
+		}
+	}
+
+	// Small hub info is sent in one go, starting with the announcement block.
+	small := container.New()
+	small.AppendAsBlock([]byte("announcement"))
+	small.AppendAsBlock([]byte("status"))
+	testReassembly(small.CompileData(), 1)
+
+	// Large hub info is chunked.
+	large := container.New()
+	large.AppendAsBlock(bytes.Repeat([]byte("a"), 3*hubInfoChunkSize))
+	large.AppendAsBlock([]byte("status"))
+	testReassembly(large.CompileData(), 4)
+
+	// Large hub info fails for requesters without chunking support.
+	if _, tErr := packHubInfoReply(large.CompileData(), false); tErr == nil {
+		t.Fatal("expected error for oversized hub info without chunking")
+	}
+
+	// Too many chunks are rejected.
+	if _, tErr := packHubInfoReply(make([]byte, (maxHubInfoChunks+1)*hubInfoChunkSize), true); tErr == nil {
+		t.Fatal("expected error for too many chunks")
+	}
+}
+
+func TestCraneCapabilities(t *testing.T) {
+	local := CraneCapabilities(0b0101)
+
+	// Only capabilities supported by both sides are used.
+	capabilities := local.Intersect(0b1110)
+	if capabilities != 0b0100 {
+		t.Errorf("expected intersection 0b0100, got %#b", capabilities)
+	}
+	if !capabilities.Has(0b0100) || capabilities.Has(0b0110) {
+		t.Errorf("unexpected capability check result for %#b", capabilities)
+	}
+
+	// Unknown hub info flags are ignored.
+	f := getHubInfoFlags(container.New(varint.Pack64(HubInfoFlagChunked | 0b1000)))
+	if f&HubInfoFlagChunked == 0 {
+		t.Errorf("expected chunked flag, got %d", f)
+	}
+}
@@ -0,0 +1,57 @@
+package docks
+
+import (
+	"strings"
+)
+
+// craneLogFields holds the structured fields of a crane for appending them to
+// log messages, so that all log lines of a crane can be correlated.
+// Messages are logged directly, so that the log shows the actual caller.
+// As the fields are only formatted when the message is logged, they do not
+// add any cost to messages below the log level.
+type craneLogFields struct {
+	crane *Crane
+}
+
+// logFields returns the structured log fields of the crane.
+func (crane *Crane) logFields() craneLogFields {
+	return craneLogFields{
+		crane: crane,
+	}
+}
+
+// Fields returns the structured fields of the crane as key-value pairs.
+// Fields are evaluated on every call, as some may change during the lifetime
+// of the crane.
+func (clf craneLogFields) Fields() map[string]string {
+	fields := map[string]string{
+		"crane": clf.crane.ID,
+	}
+	if connectedHub := clf.crane.ConnectedHub; connectedHub != nil {
+		connectedHub.Lock()
+		fields["hub"] = connectedHub.ID
+		connectedHub.Unlock()
+	}
+	if clf.crane.ship != nil {
+		fields["transport"] = clf.crane.Transport().String()
+		if clf.crane.ship.IsMine() {
+			fields["direction"] = "out"
+		} else {
+			fields["direction"] = "in"
+		}
+	}
+	return fields
+}
+
+// String formats the structured fields for appending them to a log message.
+func (clf craneLogFields) String() string {
+	fields := clf.Fields()
+	// Use a fixed order for easier grepping.
+	s := make([]string, 0, len(fields))
+	for _, key := range []string{"crane", "hub", "transport", "direction"} {
+		if value, ok := fields[key]; ok {
+			s = append(s, key+"="+value)
+		}
+	}
+	return strings.Join(s, " ")
+}
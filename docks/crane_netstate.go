@@ -4,6 +4,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/safing/spn/clock"
 )
 
 const NetStatePeriodInterval = 15 * time.Minute
@@ -40,7 +42,7 @@ func (netState *NetworkOptimizationState) UpdateLastSuggestedAt() {
 	netState.lock.Lock()
 	defer netState.lock.Unlock()
 
-	netState.lastSuggestedAt = time.Now()
+	netState.lastSuggestedAt = clock.Now()
 }
 
 func (netState *NetworkOptimizationState) LastSuggestedAt() time.Time {
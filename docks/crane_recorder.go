@@ -0,0 +1,322 @@
+package docks
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+)
+
+var (
+	// FailedCraneRecordings defines how many recordings of failed cranes are
+	// kept for later inspection.
+	FailedCraneRecordings = 10
+
+	craneRecorderSize      int
+	craneRecorderPlaintext bool
+	craneRecorderLock      sync.Mutex
+
+	// craneRecordingsMemory is the memory reserved by all recordings,
+	// including kept recordings of failed cranes.
+	craneRecordingsMemory int
+)
+
+const (
+	// maxCraneRecorderSize is the maximum size of a crane recording.
+	maxCraneRecorderSize = 16 * 1024 * 1024 // 16MB
+
+	// maxCraneRecordingsMemory is the maximum memory used by all recordings.
+	maxCraneRecordingsMemory = 64 * 1024 * 1024 // 64MB
+
+	// minCraneRecorderSize is the minimum size of a crane recording. No
+	// recording is started if less memory is available.
+	minCraneRecorderSize = 64 * 1024 // 64KB
+)
+
+// SetCraneRecorderConfig sets how many bytes of the most recent traffic are
+// recorded by new cranes. Set size to zero to disable recording. Recordings
+// are kept in memory only and are limited in total.
+// If plaintext is set, the decrypted traffic is recorded in addition to the
+// wire traffic. The decrypted traffic holds the plaintext of all terminals of
+// the crane, so this must only be enabled for debugging.
+func SetCraneRecorderConfig(size int, plaintext bool) {
+	craneRecorderLock.Lock()
+	defer craneRecorderLock.Unlock()
+
+	craneRecorderSize = size
+	craneRecorderPlaintext = plaintext
+}
+
+// reserveCraneRecordingMemory reserves up to the given amount of memory for a
+// recording and returns the reserved amount, which may be zero.
+func reserveCraneRecordingMemory(size int) int {
+	craneRecorderLock.Lock()
+	defer craneRecorderLock.Unlock()
+
+	if available := maxCraneRecordingsMemory - craneRecordingsMemory; size > available {
+		size = available
+	}
+	if size < minCraneRecorderSize {
+		return 0
+	}
+	craneRecordingsMemory += size
+	return size
+}
+
+// releaseCraneRecordingMemory releases memory reserved for a recording.
+func releaseCraneRecordingMemory(size int) {
+	craneRecorderLock.Lock()
+	defer craneRecorderLock.Unlock()
+
+	craneRecordingsMemory -= size
+}
+
+// Crane Record Types.
+const (
+	// CraneRecordWireIn is received framed data, as read from the ship.
+	CraneRecordWireIn uint8 = iota + 1
+	// CraneRecordWireOut is sent framed data, as loaded onto the ship.
+	CraneRecordWireOut
+	// CraneRecordPlainIn is received data after decryption.
+	CraneRecordPlainIn
+	// CraneRecordPlainOut is sent data before encryption.
+	CraneRecordPlainOut
+)
+
+// CraneRecord is a recorded chunk of data of a crane.
+type CraneRecord struct {
+	// Time is the time since the start of the recording.
+	Time time.Duration
+	// Type is the type of the record.
+	Type uint8
+	// Data holds the recorded data.
+	Data []byte
+}
+
+// CraneRecording holds the recorded traffic of a crane.
+type CraneRecording struct {
+	CraneID string
+	HubID   string
+	Started time.Time
+	// StopError holds the error the crane was stopped with, if it failed.
+	StopError string `json:",omitempty"`
+	// Plaintext is set if the recording includes decrypted data.
+	Plaintext bool
+	// Dropped is the amount of records that were evicted to stay within the
+	// size limit.
+	Dropped int
+	// Records holds the recorded chunks in order.
+	Records []CraneRecord
+
+	// reserved is the recording memory held by a kept recording.
+	reserved int
+}
+
+// craneRecorder records the traffic of a crane in a ring buffer bounded by
+// size.
+type craneRecorder struct {
+	lock sync.Mutex
+
+	started   time.Time
+	plaintext bool
+	maxSize   int
+	size      int
+	dropped   int
+	records   []CraneRecord
+	// reserved is the recording memory reserved by the recorder.
+	reserved int
+}
+
+var (
+	failedRecordings     []*CraneRecording
+	failedRecordingsLock sync.Mutex
+)
+
+// newCraneRecorder returns a new recorder as configured, or nil if recording
+// is disabled or the recording memory is exhausted.
+func newCraneRecorder() *craneRecorder {
+	craneRecorderLock.Lock()
+	maxSize := craneRecorderSize
+	plaintext := craneRecorderPlaintext
+	craneRecorderLock.Unlock()
+
+	if maxSize <= 0 {
+		return nil
+	}
+	if maxSize > maxCraneRecorderSize {
+		maxSize = maxCraneRecorderSize
+	}
+	maxSize = reserveCraneRecordingMemory(maxSize)
+	if maxSize == 0 {
+		return nil
+	}
+
+	return &craneRecorder{
+		started:   time.Now(),
+		plaintext: plaintext,
+		maxSize:   maxSize,
+		reserved:  maxSize,
+	}
+}
+
+// release stops recording and releases the reserved recording memory.
+func (r *craneRecorder) release() {
+	if reserved := r.takeReservation(); reserved > 0 {
+		releaseCraneRecordingMemory(reserved)
+	}
+}
+
+// takeReservation stops recording and returns the reserved recording memory,
+// which the caller must release.
+func (r *craneRecorder) takeReservation() (reserved int) {
+	if r == nil {
+		return 0
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	reserved = r.reserved
+	r.reserved = 0
+	r.maxSize = 0
+	r.size = 0
+	r.records = nil
+	return reserved
+}
+
+// record records a chunk of data. The data is copied.
+func (r *craneRecorder) record(recordType uint8, chunks ...[]byte) {
+	if r == nil {
+		return
+	}
+	if !r.plaintext && (recordType == CraneRecordPlainIn || recordType == CraneRecordPlainOut) {
+		return
+	}
+
+	// Copy data, as buffers are reused.
+	var dataLen int
+	for _, chunk := range chunks {
+		dataLen += len(chunk)
+	}
+	data := make([]byte, 0, dataLen)
+	for _, chunk := range chunks {
+		data = append(data, chunk...)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Check if the recording was stopped.
+	if r.maxSize == 0 {
+		return
+	}
+	// Only keep the end of oversized chunks.
+	if len(data) > r.maxSize {
+		data = data[len(data)-r.maxSize:]
+	}
+
+	// Evict oldest records until the new record fits.
+	for r.size+len(data) > r.maxSize && len(r.records) > 0 {
+		r.size -= len(r.records[0].Data)
+		r.records[0] = CraneRecord{}
+		r.records = r.records[1:]
+		r.dropped++
+	}
+
+	r.records = append(r.records, CraneRecord{
+		Time: time.Since(r.started),
+		Type: recordType,
+		Data: data,
+	})
+	r.size += len(data)
+}
+
+// export returns a copy of the recording.
+func (r *craneRecorder) export() *CraneRecording {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	recording := &CraneRecording{
+		Started:   r.started,
+		Plaintext: r.plaintext,
+		Dropped:   r.dropped,
+		Records:   make([]CraneRecord, len(r.records)),
+	}
+	copy(recording.Records, r.records)
+	return recording
+}
+
+// recordsPlaintext returns whether decrypted data is recorded.
+func (r *craneRecorder) recordsPlaintext() bool {
+	return r != nil && r.plaintext
+}
+
+// recordWireIn records a received shipment together with its length prefix.
+func (crane *Crane) recordWireIn(shipment []byte) {
+	if crane.recorder != nil {
+		crane.recorder.record(CraneRecordWireIn, varint.Pack64(uint64(len(shipment))), shipment)
+	}
+}
+
+// Recording returns the recorded traffic of the crane, or nil if recording is
+// not enabled.
+func (crane *Crane) Recording() *CraneRecording {
+	if crane.recorder == nil {
+		return nil
+	}
+
+	recording := crane.recorder.export()
+	recording.CraneID = crane.ID
+	if crane.ConnectedHub != nil {
+		recording.HubID = crane.ConnectedHub.ID
+	}
+	return recording
+}
+
+// saveFailedRecording keeps the recording of a failed crane.
+func (crane *Crane) saveFailedRecording(stopErr string) {
+	recording := crane.Recording()
+	if recording == nil || FailedCraneRecordings <= 0 {
+		return
+	}
+	recording.StopError = stopErr
+	// Keep the recording memory reserved for as long as the recording is kept.
+	recording.reserved = crane.recorder.takeReservation()
+
+	failedRecordingsLock.Lock()
+	defer failedRecordingsLock.Unlock()
+
+	failedRecordings = append(failedRecordings, recording)
+	if len(failedRecordings) > FailedCraneRecordings {
+		evicted := failedRecordings[:len(failedRecordings)-FailedCraneRecordings]
+		for _, r := range evicted {
+			releaseCraneRecordingMemory(r.reserved)
+		}
+		failedRecordings = failedRecordings[len(failedRecordings)-FailedCraneRecordings:]
+	}
+	log.Infof("spn/docks: %s saved recording of %d records for debugging [%s]", crane, len(recording.Records), crane.logFields())
+}
+
+// GetCraneRecordings returns the recordings of all active cranes and the
+// kept recordings of failed cranes.
+func GetCraneRecordings() []*CraneRecording {
+	recordings := make([]*CraneRecording, 0)
+
+	// Get recordings of active cranes.
+	for _, crane := range getAllCranes() {
+		if recording := crane.Recording(); recording != nil {
+			recordings = append(recordings, recording)
+		}
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].CraneID < recordings[j].CraneID
+	})
+
+	// Add recordings of failed cranes.
+	failedRecordingsLock.Lock()
+	defer failedRecordingsLock.Unlock()
+
+	return append(recordings, failedRecordings...)
+}
@@ -0,0 +1,90 @@
+package docks
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCraneRecorder(t *testing.T) {
+	// Recording is disabled by default.
+	if newCraneRecorder() != nil {
+		t.Fatal("recording should be disabled by default")
+	}
+
+	defer SetCraneRecorderConfig(0, false)
+	SetCraneRecorderConfig(minCraneRecorderSize, false)
+
+	// Plaintext is not recorded unless enabled.
+	r := newCraneRecorder()
+	defer r.release()
+	r.maxSize = 10 // Use a small size for testing.
+	r.record(CraneRecordPlainIn, []byte("secret"))
+	if len(r.export().Records) != 0 {
+		t.Fatal("plaintext must not be recorded")
+	}
+
+	// Data is copied and the oldest records are evicted to stay within the size.
+	buf := []byte("12345")
+	r.record(CraneRecordWireIn, buf)
+	buf[0] = 'x'
+	r.record(CraneRecordWireOut, []byte("678"))
+	r.record(CraneRecordWireIn, []byte("90"), []byte("ab"))
+	recording := r.export()
+	if len(recording.Records) != 2 || recording.Dropped != 1 {
+		t.Fatalf("expected 2 records and 1 dropped, got %d and %d", len(recording.Records), recording.Dropped)
+	}
+	if !bytes.Equal(recording.Records[1].Data, []byte("90ab")) {
+		t.Fatalf("unexpected record data %q", recording.Records[1].Data)
+	}
+
+	// Oversized chunks are truncated to their end.
+	r.record(CraneRecordWireIn, []byte("0123456789abcdef"))
+	recording = r.export()
+	if len(recording.Records) != 1 || !bytes.Equal(recording.Records[0].Data, []byte("6789abcdef")) {
+		t.Fatalf("unexpected records after oversized chunk: %+v", recording.Records)
+	}
+
+	// Nothing is recorded after releasing.
+	r.release()
+	r.record(CraneRecordWireIn, []byte("data"))
+	if len(r.export().Records) != 0 {
+		t.Fatal("released recorder must not record")
+	}
+
+	// Plaintext is recorded if enabled.
+	SetCraneRecorderConfig(minCraneRecorderSize, true)
+	r = newCraneRecorder()
+	defer r.release()
+	r.record(CraneRecordPlainOut, []byte("data"))
+	if recording := r.export(); !recording.Plaintext || len(recording.Records) != 1 {
+		t.Fatal("plaintext should be recorded when enabled")
+	}
+}
+
+func TestCraneRecorderMemoryLimit(t *testing.T) {
+	defer SetCraneRecorderConfig(0, false)
+	SetCraneRecorderConfig(maxCraneRecorderSize, false)
+
+	// Recorders are created until the memory limit is reached.
+	var recorders []*craneRecorder
+	for r := newCraneRecorder(); r != nil; r = newCraneRecorder() {
+		recorders = append(recorders, r)
+	}
+	if expected := maxCraneRecordingsMemory / maxCraneRecorderSize; len(recorders) != expected {
+		t.Fatalf("expected %d recorders, got %d", expected, len(recorders))
+	}
+
+	// Released memory can be used again.
+	recorders[0].release()
+	recorders[0] = newCraneRecorder()
+	if recorders[0] == nil {
+		t.Fatal("released memory should be available again")
+	}
+
+	for _, r := range recorders {
+		r.release()
+	}
+	if craneRecordingsMemory != 0 {
+		t.Fatalf("expected all recording memory to be released, %d is still reserved", craneRecordingsMemory)
+	}
+}
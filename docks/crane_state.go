@@ -0,0 +1,150 @@
+package docks
+
+import (
+	"time"
+
+	"github.com/safing/spn/terminal"
+)
+
+// CraneState is a snapshot of the state of a crane.
+type CraneState struct {
+	ID           string
+	ConnectedHub string `json:",omitempty"`
+	Transport    string `json:",omitempty"`
+
+	Public        bool
+	Mine          bool
+	Authenticated bool
+	Stopping      bool
+	Stopped       bool
+
+	// LaneLatency and LaneCapacity are the measurements of the lane to the
+	// connected Hub. They are zero if not yet measured.
+	LaneLatency  time.Duration
+	LaneCapacity int
+
+	Terminals int
+	// Flow summarizes the flow queues of all terminals of the crane.
+	Flow CraneFlowSummary
+
+	LifetimeBytesIn  uint64
+	LifetimeBytesOut uint64
+	PeriodBytesIn    uint64
+	PeriodBytesOut   uint64
+	PeriodStarted    time.Time
+
+	StartedAt time.Time
+	Uptime    time.Duration
+}
+
+// State returns a snapshot of the state of the crane.
+func (crane *Crane) State() CraneState {
+	state := CraneState{
+		ID:            crane.ID,
+		Public:        crane.Public(),
+		Mine:          crane.IsMine(),
+		Authenticated: crane.Authenticated(),
+		Stopping:      crane.IsStopping(),
+		Stopped:       crane.Stopped(),
+		Terminals:     crane.terminalCount(),
+		Flow:          crane.FlowSummary(),
+	}
+	if transport := crane.Transport(); transport != nil {
+		state.Transport = transport.String()
+	}
+
+	// Add lane measurements.
+	if connectedHub := crane.ConnectedHub; connectedHub != nil {
+		connectedHub.Lock()
+		state.ConnectedHub = connectedHub.ID
+		measurements := connectedHub.GetMeasurementsWithLockedHub()
+		connectedHub.Unlock()
+
+		state.LaneLatency, _ = measurements.GetLatency()
+		state.LaneCapacity, _ = measurements.GetCapacity()
+	}
+
+	// Add traffic stats in one go.
+	state.LifetimeBytesIn,
+		state.LifetimeBytesOut,
+		state.StartedAt,
+		state.PeriodBytesIn,
+		state.PeriodBytesOut,
+		state.PeriodStarted = crane.NetState.GetTrafficStats()
+	state.Uptime = time.Since(state.StartedAt)
+
+	return state
+}
+
+// CraneFlowSummary aggregates the flow stats of all terminals of a crane.
+type CraneFlowSummary struct {
+	// Terminals is the amount of terminals that provided flow stats.
+	Terminals int
+	// Backpressured is the amount of terminals that are waiting for the other
+	// end to accept more data.
+	Backpressured int
+	// MaxPressure is the highest send pressure of all terminals.
+	MaxPressure float64
+
+	SendQueued int
+	RecvQueued int
+
+	DroppedRecv int
+	// DroppedSend is the amount of data messages that were discarded by the
+	// send queue policies of the operations.
+	DroppedSend int
+	Desyncs     int
+
+	// BytesSent and BytesReceived are the data bytes of the current terminals.
+	// Bytes of terminals that have ended are not included.
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+// FlowSummary returns a summary of the flow stats of all terminals of the
+// crane.
+func (crane *Crane) FlowSummary() CraneFlowSummary {
+	// Collect terminals, so that the lock is not held while collecting stats.
+	crane.terminalsLock.Lock()
+	providers := make([]terminal.FlowStatsProvider, 0, len(crane.terminals))
+	for _, t := range crane.terminals {
+		if provider, ok := t.(terminal.FlowStatsProvider); ok {
+			providers = append(providers, provider)
+		}
+	}
+	crane.terminalsLock.Unlock()
+
+	var summary CraneFlowSummary
+	for _, provider := range providers {
+		stats := provider.GetFlowStats()
+
+		summary.Terminals++
+		if stats.Backpressured {
+			summary.Backpressured++
+		}
+		if stats.Pressure > summary.MaxPressure {
+			summary.MaxPressure = stats.Pressure
+		}
+		summary.SendQueued += stats.SendQueued
+		summary.RecvQueued += stats.RecvQueued
+		summary.DroppedRecv += stats.DroppedRecv
+		if t, ok := provider.(interface{ DroppedOpMsgs() int }); ok {
+			summary.DroppedSend += t.DroppedOpMsgs()
+		}
+		summary.Desyncs += stats.Desyncs
+		summary.BytesSent += stats.BytesSent
+		summary.BytesReceived += stats.BytesReceived
+	}
+
+	return summary
+}
+
+// GetAllCraneStates returns the states of all assigned cranes.
+func GetAllCraneStates() []CraneState {
+	cranes := GetAllAssignedCranesSorted()
+	states := make([]CraneState, 0, len(cranes))
+	for _, crane := range cranes {
+		states = append(states, crane.State())
+	}
+	return states
+}
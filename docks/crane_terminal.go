@@ -2,6 +2,7 @@ package docks
 
 import (
 	"net"
+	"sync"
 	"time"
 
 	"github.com/safing/portbase/container"
@@ -21,6 +22,10 @@ type CraneTerminal struct {
 	*terminal.DuplexFlowQueue
 
 	crane *Crane
+
+	// abandonErr holds the error the terminal was abandoned with.
+	abandonErr     *terminal.Error
+	abandonErrLock sync.Mutex
 }
 
 func NewLocalCraneTerminal(
@@ -76,6 +81,10 @@ func initCraneTerminal(
 ) *CraneTerminal {
 	// Create Flow Queue.
 	dfq := terminal.NewDuplexFlowQueue(t, initMsg.QueueSize, t.SubmitAsDataMsg(crane.submitTerminalMsg))
+	dfq.SetMaxMsgSize(initMsg.MaxMsgSize)
+	if crane.flowWindowMax > 0 {
+		dfq.EnableWindowAutoTuning(crane.flowWindowMax, crane.getRTT)
+	}
 
 	// Create Crane Terminal and assign it as the extended Terminal.
 	ct := &CraneTerminal{
@@ -131,8 +140,20 @@ func (t *CraneTerminal) IsAbandoned() bool {
 	return t.Abandoned.IsSet()
 }
 
+// AbandonErr returns the error the terminal was abandoned with, if any.
+func (t *CraneTerminal) AbandonErr() *terminal.Error {
+	t.abandonErrLock.Lock()
+	defer t.abandonErrLock.Unlock()
+
+	return t.abandonErr
+}
+
 func (t *CraneTerminal) Abandon(err *terminal.Error) {
 	if t.Abandoned.SetToIf(false, true) {
+		t.abandonErrLock.Lock()
+		t.abandonErr = err
+		t.abandonErrLock.Unlock()
+
 		// Send stop msg and end all operations.
 		t.Shutdown(err, err.IsExternal())
 
@@ -40,6 +40,26 @@ func testCraneWithCounter(t *testing.T, testID string, encrypting bool, loadSize
 	optimalMinLoadSize = loadSize * 2
 	ship := ships.NewTestShip(!encrypting, loadSize)
 
+	err := runCraneCounterTest(t, testID, ship, ship.Reverse(), connectedHub, identity, countTo, 10*time.Second)
+	if err != nil {
+		t.Fatalf("crane test %s counter op1 failed: %s", testID, err)
+	}
+}
+
+// runCraneCounterTest runs a counter op over cranes on the given ships and
+// returns the result of the op. If the op does not finish within the given
+// timeout, the cranes are stopped and a timeout error is returned.
+func runCraneCounterTest(
+	t *testing.T,
+	testID string,
+	ship, reverseShip ships.Ship,
+	connectedHub *hub.Hub,
+	identity *cabin.Identity,
+	countTo uint64,
+	timeout time.Duration,
+) error {
+	t.Helper()
+
 	var crane1, crane2 *Crane
 	var craneWg sync.WaitGroup
 	craneWg.Add(2)
@@ -60,7 +80,7 @@ func testCraneWithCounter(t *testing.T, testID string, encrypting bool, loadSize
 	}()
 	go func() {
 		var err error
-		crane2, err = NewCrane(context.TODO(), ship.Reverse(), nil, identity)
+		crane2, err = NewCrane(context.TODO(), reverseShip, nil, identity)
 		if err != nil {
 			panic(fmt.Sprintf("crane test %s could not create crane2: %s", testID, err))
 			return
@@ -76,13 +96,26 @@ func testCraneWithCounter(t *testing.T, testID string, encrypting bool, loadSize
 	craneWg.Wait()
 	t.Logf("crane test %s setup complete", testID)
 
-	// Wait async for test to complete, print stack after timeout.
+	// Wait async for test to complete, stop the cranes after timeout and print
+	// the stack if that does not end the test either.
 	finished := make(chan struct{})
+	timedOut := make(chan struct{})
 	go func() {
+		select {
+		case <-finished:
+			return
+		case <-time.After(timeout):
+		}
+
+		t.Logf("crane test %s is taking too long, stopping cranes", testID)
+		close(timedOut)
+		go crane1.Stop(terminal.ErrTimeout.With("crane test %s timed out", testID))
+		go crane2.Stop(terminal.ErrTimeout.With("crane test %s timed out", testID))
+
 		select {
 		case <-finished:
 		case <-time.After(10 * time.Second):
-			t.Logf("crane test %s is taking too long, print stack:", testID)
+			t.Logf("crane test %s did not stop, print stack:", testID)
 			_ = pprof.Lookup("goroutine").WriteTo(os.Stdout, 1)
 			os.Exit(1)
 		}
@@ -103,15 +136,46 @@ func testCraneWithCounter(t *testing.T, testID string, encrypting bool, loadSize
 	// Wait for completion.
 	op1.Wait()
 	close(finished)
+	select {
+	case <-timedOut:
+		return terminal.ErrTimeout.With("crane test %s did not finish within %s", testID, timeout)
+	default:
+	}
 
 	// Wait a little so that all errors can be propagated, so we can truly see
 	// if we succeeded.
 	time.Sleep(1 * time.Second)
 
-	// Check errors.
-	if op1.Error != nil {
-		t.Fatalf("crane test %s counter op1 failed: %s", testID, op1.Error)
+	return op1.Error
+}
+
+func TestCraneWithLossyShip(t *testing.T) {
+	identity, connectedHub := getTestIdentity(t)
+	optimalMinLoadSize = 2000
+
+	// Delays only slow down the connection and must not break anything.
+	delayingShip := ships.NewLossyTestShip(false, 1000, ships.LossyTestShipOptions{
+		MaxDelay: 1 * time.Millisecond,
+		Seed:     1,
+	})
+	err := runCraneCounterTest(t, "delaying-counter", delayingShip, delayingShip.Reverse(), connectedHub, identity, 1000, 30*time.Second)
+	if err != nil {
+		t.Fatalf("crane test delaying-counter failed: %s", err)
 	}
+
+	// Dropped and duplicated data must never let the op succeed. Drops of
+	// flow control data may stall the op, which the timeout ends.
+	lossyShip := ships.NewLossyTestShip(false, 1000, ships.LossyTestShipOptions{
+		DropRate:      0.01,
+		DuplicateRate: 0.01,
+		SkipFrames:    10,
+		Seed:          1,
+	})
+	err = runCraneCounterTest(t, "lossy-counter", lossyShip, lossyShip.TestShip.Reverse(), connectedHub, identity, 10000, 10*time.Second)
+	if err == nil {
+		t.Fatal("crane test lossy-counter should have failed")
+	}
+	t.Logf("crane test lossy-counter failed as expected: %s", err)
 }
 
 type StreamingTerminal struct {
@@ -267,3 +331,54 @@ func getTestIdentity(t *testing.T) (*cabin.Identity, *hub.Hub) {
 
 	return testIdentity, testIdentity.Hub
 }
+
+func BenchmarkCraneUnloader(b *testing.B) {
+	b.Run("client", func(b *testing.B) {
+		benchmarkCraneUnloader(b, ClientUnloaderOptions)
+	})
+	b.Run("relay", func(b *testing.B) {
+		benchmarkCraneUnloader(b, RelayUnloaderOptions)
+	})
+	b.Run("unbuffered", func(b *testing.B) {
+		benchmarkCraneUnloader(b, UnloaderOptions{QueueSize: 0, ReadSize: 16})
+	})
+}
+
+func benchmarkCraneUnloader(b *testing.B, opts UnloaderOptions) {
+	b.Helper()
+
+	// Build ship and crane.
+	ship := ships.NewTestShip(true, 1000)
+	crane, err := NewCrane(context.TODO(), ship.Reverse(), nil, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer unregisterCrane(crane)
+	crane.SetUnloaderOptions(opts)
+
+	// Build a shipment with many small containers.
+	msg := container.New(testData)
+	msg.PrependLength()
+	shipment := container.New()
+	for i := 0; i < 100; i++ {
+		shipment.Append(msg.CompileData())
+	}
+	shipmentData := shipment.CompileData()
+
+	// Start unloader.
+	go func() {
+		_ = crane.unloader(crane.ctx)
+	}()
+	defer crane.cancelCtx()
+
+	b.SetBytes(int64(len(shipmentData)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ship.Load(shipmentData); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < 100; j++ {
+			<-crane.unloading
+		}
+	}
+}
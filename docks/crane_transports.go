@@ -0,0 +1,71 @@
+package docks
+
+import (
+	"sync"
+	"time"
+)
+
+// LiveTransportsTTL defines how long the live transports reported by a Hub
+// are cached.
+var LiveTransportsTTL = 5 * time.Minute
+
+// liveTransportsEntry holds the transports of a Hub that were reported to be
+// currently listening.
+type liveTransportsEntry struct {
+	transports []string
+	expires    time.Time
+}
+
+var (
+	// liveTransports caches the live transports by Hub ID. They are kept
+	// independently of cranes, so that they can be used for reconnecting.
+	liveTransports     = make(map[string]*liveTransportsEntry)
+	liveTransportsLock sync.Mutex
+)
+
+// SetLiveTransports caches the transports that the connected Hub reported to
+// be currently listening.
+func (crane *Crane) SetLiveTransports(transports []string) {
+	if crane.ConnectedHub == nil {
+		return
+	}
+
+	liveTransportsLock.Lock()
+	defer liveTransportsLock.Unlock()
+
+	// Remove expired entries.
+	now := time.Now()
+	for hubID, entry := range liveTransports {
+		if now.After(entry.expires) {
+			delete(liveTransports, hubID)
+		}
+	}
+
+	liveTransports[crane.ConnectedHub.ID] = &liveTransportsEntry{
+		transports: transports,
+		expires:    now.Add(LiveTransportsTTL),
+	}
+}
+
+// GetLiveTransports returns the cached transports that the connected Hub
+// reported to be currently listening. If there is no valid cache entry, ok is
+// false.
+func (crane *Crane) GetLiveTransports() (transports []string, ok bool) {
+	if crane.ConnectedHub == nil {
+		return nil, false
+	}
+	return GetLiveTransports(crane.ConnectedHub.ID)
+}
+
+// GetLiveTransports returns the cached live transports of the given Hub. If
+// there is no valid cache entry, ok is false.
+func GetLiveTransports(hubID string) (transports []string, ok bool) {
+	liveTransportsLock.Lock()
+	defer liveTransportsLock.Unlock()
+
+	entry, ok := liveTransports[hubID]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.transports, true
+}
@@ -32,7 +32,7 @@ func (crane *Crane) VerifyConnectedHub() error {
 		request,
 	)
 	msg.PrependLength()
-	err = crane.ship.Load(msg.CompileData())
+	err = crane.loadShip(msg.CompileData())
 	if err != nil {
 		return terminal.ErrShipSunk.With("failed to send verification request: %w", err)
 	}
@@ -72,7 +72,7 @@ func (crane *Crane) handleCraneVerification(request *container.Container) *termi
 
 	// Manually send reply.
 	msg.PrependLength()
-	err = crane.ship.Load(msg.CompileData())
+	err = crane.loadShip(msg.CompileData())
 	if err != nil {
 		return terminal.ErrShipSunk.With("failed to send verification reply: %w", err)
 	}
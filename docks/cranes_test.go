@@ -0,0 +1,155 @@
+package docks
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/ships"
+)
+
+func TestGetCranesToHub(t *testing.T) {
+	crane1, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: "lookup-test-1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterCrane(crane1)
+	crane2, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: "lookup-test-2"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterCrane(crane2)
+
+	cranes := GetCranesToHub("lookup-test-1")
+	if len(cranes) != 1 || cranes[0] != crane1 {
+		t.Fatalf("unexpected cranes to hub: %v", cranes)
+	}
+	if len(GetCranesToHub("lookup-test-3")) != 0 {
+		t.Fatal("expected no cranes to unknown hub")
+	}
+}
+
+func TestGetAllAssignedCranesSorted(t *testing.T) {
+	hubIDs := []string{"sort-test-c", "sort-test-a", "sort-test-b"}
+	for _, hubID := range hubIDs {
+		crane, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: hubID}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssignCrane(hubID, crane)
+		defer unregisterCrane(crane)
+	}
+
+	// Check that the cranes are ordered by hub ID.
+	var lastHubID string
+	found := 0
+	for _, crane := range GetAllAssignedCranesSorted() {
+		if crane.ConnectedHub.ID < lastHubID {
+			t.Fatalf("cranes are not sorted: %s after %s", crane.ConnectedHub.ID, lastHubID)
+		}
+		lastHubID = crane.ConnectedHub.ID
+		if strings.HasPrefix(lastHubID, "sort-test-") {
+			found++
+		}
+	}
+	if found != len(hubIDs) {
+		t.Fatalf("expected %d test cranes, found %d", len(hubIDs), found)
+	}
+}
+
+func TestLiveTransports(t *testing.T) {
+	crane, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: "transports-test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		liveTransportsLock.Lock()
+		delete(liveTransports, "transports-test")
+		liveTransportsLock.Unlock()
+	}()
+
+	// Nothing is cached initially.
+	if _, ok := GetLiveTransports("transports-test"); ok {
+		t.Fatal("expected no cached live transports")
+	}
+
+	// Cached transports are returned until they expire.
+	crane.SetLiveTransports([]string{"tcp:17"})
+	transports, ok := GetLiveTransports("transports-test")
+	if !ok || len(transports) != 1 || transports[0] != "tcp:17" {
+		t.Fatalf("unexpected live transports: %v", transports)
+	}
+	liveTransportsLock.Lock()
+	liveTransports["transports-test"].expires = time.Now().Add(-time.Second)
+	liveTransportsLock.Unlock()
+	if _, ok := crane.GetLiveTransports(); ok {
+		t.Fatal("expected cached live transports to be expired")
+	}
+}
+
+func TestCraneState(t *testing.T) {
+	crane, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: "state-test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssignCrane("state-test", crane)
+	defer unregisterCrane(crane)
+	crane.NetState.ReportTraffic(100, true)
+
+	// Check that the state is included in the states of all cranes.
+	for _, state := range GetAllCraneStates() {
+		if state.ID != crane.ID {
+			continue
+		}
+		if state.ConnectedHub != "state-test" || !state.Mine || state.Stopped {
+			t.Fatalf("unexpected crane state: %+v", state)
+		}
+		if state.LifetimeBytesIn != 100 || state.PeriodBytesIn != 100 {
+			t.Fatalf("unexpected traffic stats: %+v", state)
+		}
+		if state.StartedAt.IsZero() || state.Uptime < 0 {
+			t.Fatalf("unexpected uptime: %+v", state)
+		}
+		return
+	}
+	t.Fatal("crane state not found")
+}
+
+func TestCraneDeduplication(t *testing.T) {
+	hubID := "dedup-test"
+	older, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: hubID}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterCrane(older)
+	newer, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: hubID}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterCrane(newer)
+	newer.createdAt = older.createdAt.Add(time.Second)
+
+	// The older crane wins if both were established by the same side.
+	if preferredCrane(older, newer) != older || preferredCrane(newer, older) != older {
+		t.Fatal("expected older crane to be preferred")
+	}
+
+	// The newer crane is redundant and must not replace the older one.
+	if redundant, _ := assignCraneDeduplicated(hubID, older); redundant != nil {
+		t.Fatalf("unexpected redundant crane %s", redundant)
+	}
+	if redundant, _ := assignCraneDeduplicated(hubID, newer); redundant != newer {
+		t.Fatalf("expected newer crane to be redundant, got %v", redundant)
+	}
+	if GetAssignedCrane(hubID) != older {
+		t.Fatal("older crane should still be assigned")
+	}
+
+	// Unregistering the redundant crane must not remove the assignment.
+	unregisterCrane(newer)
+	if GetAssignedCrane(hubID) != older {
+		t.Fatal("assignment was removed by redundant crane")
+	}
+}
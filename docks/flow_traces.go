@@ -0,0 +1,49 @@
+package docks
+
+import (
+	"sort"
+
+	"github.com/safing/spn/terminal"
+)
+
+// TerminalFlowTrace holds the recorded flow queue events of a crane terminal.
+type TerminalFlowTrace struct {
+	CraneID    string
+	TerminalID uint32
+	Trace      *terminal.FlowTrace
+}
+
+// GetFlowTraces returns the recorded flow queue events of the terminals of
+// all assigned cranes, if flow tracing is enabled.
+func GetFlowTraces() []TerminalFlowTrace {
+	var traces []TerminalFlowTrace
+	for _, crane := range GetAllAssignedCranesSorted() {
+		traces = append(traces, crane.getFlowTraces()...)
+	}
+	return traces
+}
+
+func (crane *Crane) getFlowTraces() []TerminalFlowTrace {
+	crane.terminalsLock.Lock()
+	defer crane.terminalsLock.Unlock()
+
+	traces := make([]TerminalFlowTrace, 0, len(crane.terminals))
+	for id, t := range crane.terminals {
+		tracer, ok := t.(interface{ Trace() *terminal.FlowTrace })
+		if !ok {
+			continue
+		}
+		if trace := tracer.Trace(); trace != nil {
+			traces = append(traces, TerminalFlowTrace{
+				CraneID:    crane.ID,
+				TerminalID: id,
+				Trace:      trace,
+			})
+		}
+	}
+
+	sort.Slice(traces, func(i, j int) bool {
+		return traces[i].TerminalID < traces[j].TerminalID
+	})
+	return traces
+}
@@ -0,0 +1,89 @@
+package docks
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxHubUsageEntries defines how many usage timestamps are kept per Hub.
+	maxHubUsageEntries = 1000
+	// maxHubUsageHubs defines for how many Hubs usage is recorded, as the Hub
+	// IDs are supplied by peers.
+	maxHubUsageHubs = 1000
+)
+
+var (
+	hubUsage     = make(map[string][]time.Time)
+	hubUsageLock sync.Mutex
+)
+
+// RecordHubUsage records that the Hub with the given ID was requested for
+// relaying, regardless of whether a crane to it was available.
+func RecordHubUsage(hubID string) {
+	hubUsageLock.Lock()
+	defer hubUsageLock.Unlock()
+
+	usage, ok := hubUsage[hubID]
+	if !ok && len(hubUsage) >= maxHubUsageHubs {
+		return
+	}
+
+	usage = append(usage, time.Now())
+	if len(usage) > maxHubUsageEntries {
+		usage = usage[len(usage)-maxHubUsageEntries:]
+	}
+	hubUsage[hubID] = usage
+}
+
+// GetMostUsedHubs returns the IDs of up to max Hubs that were used the most
+// within the given window, most used first. Usage older than the window is
+// discarded.
+func GetMostUsedHubs(window time.Duration, max int) []string {
+	hubUsageLock.Lock()
+	defer hubUsageLock.Unlock()
+
+	type hubUsageCount struct {
+		hubID string
+		count int
+	}
+	since := time.Now().Add(-window)
+	counts := make([]hubUsageCount, 0, len(hubUsage))
+
+	for hubID, usage := range hubUsage {
+		// Find first entry within the window.
+		i := sort.Search(len(usage), func(i int) bool {
+			return usage[i].After(since)
+		})
+
+		// Discard entries outside of the window.
+		if i >= len(usage) {
+			delete(hubUsage, hubID)
+			continue
+		}
+		if i > 0 {
+			usage = usage[i:]
+			hubUsage[hubID] = usage
+		}
+
+		counts = append(counts, hubUsageCount{hubID: hubID, count: len(usage)})
+	}
+
+	// Sort by usage, then by ID for a stable order.
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].count != counts[j].count {
+			return counts[i].count > counts[j].count
+		}
+		return counts[i].hubID < counts[j].hubID
+	})
+
+	if len(counts) > max {
+		counts = counts[:max]
+	}
+	hubIDs := make([]string, 0, len(counts))
+	for _, c := range counts {
+		hubIDs = append(hubIDs, c.hubID)
+	}
+	return hubIDs
+}
@@ -0,0 +1,32 @@
+package docks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetMostUsedHubs(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		RecordHubUsage("usage-test-a")
+	}
+	RecordHubUsage("usage-test-b")
+	for i := 0; i < 2; i++ {
+		RecordHubUsage("usage-test-c")
+	}
+
+	hubIDs := GetMostUsedHubs(time.Hour, 2)
+	if len(hubIDs) != 2 || hubIDs[0] != "usage-test-a" || hubIDs[1] != "usage-test-c" {
+		t.Fatalf("unexpected most used hubs: %v", hubIDs)
+	}
+
+	// Usage outside of the window is discarded.
+	time.Sleep(10 * time.Millisecond)
+	if hubIDs := GetMostUsedHubs(time.Millisecond, 10); len(hubIDs) != 0 {
+		t.Fatalf("expected no used hubs within window, got %v", hubIDs)
+	}
+	hubUsageLock.Lock()
+	defer hubUsageLock.Unlock()
+	if len(hubUsage) != 0 {
+		t.Fatalf("expected usage outside of window to be discarded, got %d entries", len(hubUsage))
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
+	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/modules"
 	"github.com/safing/portbase/rng"
 	_ "github.com/safing/spn/access" // Required module.
+	"github.com/safing/spn/terminal"
 )
 
 var (
@@ -22,10 +25,17 @@ var (
 )
 
 func init() {
-	module = modules.Register("docks", nil, start, stopAllCranes, "base", "cabin", "access")
+	module = modules.Register("docks", prep, start, stopAllCranes, "base", "cabin", "access")
+}
+
+func prep() error {
+	return registerAPIEndpoints()
 }
 
 func start() error {
+	module.NewTask("prune quarantine", pruneQuarantineTask).
+		Repeat(quarantinePruneInterval)
+
 	return registerMetrics()
 }
 
@@ -59,7 +69,9 @@ func unregisterCrane(crane *Crane) {
 	defer cranesLock.Unlock()
 
 	delete(allCranes, crane.ID)
-	if crane.ConnectedHub != nil {
+	// Only remove the assignment if it still points to this crane, as it may
+	// have been replaced by another crane to the same Hub.
+	if crane.ConnectedHub != nil && assignedCranes[crane.ConnectedHub.ID] == crane {
 		delete(assignedCranes, crane.ConnectedHub.ID)
 	}
 }
@@ -71,11 +83,11 @@ func stopAllCranes() error {
 	return nil
 }
 
-func AssignCrane(hubID string, crane *Crane) {
-	cranesLock.Lock()
-	defer cranesLock.Unlock()
-
-	assignedCranes[hubID] = crane
+// AssignCrane assigns the crane to the given Hub. If there already is a crane
+// assigned to the same Hub, the redundant one of them is retired. Returns
+// ErrRedundantCrane if the given crane is redundant and was not assigned.
+func AssignCrane(hubID string, crane *Crane) error {
+	return crane.assignDeduplicated(hubID)
 }
 
 func GetAssignedCrane(hubID string) *Crane {
@@ -112,3 +124,55 @@ func GetAllAssignedCranes() map[string]*Crane {
 	}
 	return copiedCranes
 }
+
+// GetAllAssignedCranesSorted returns all assigned cranes sorted by the ID of
+// the Hub they are assigned to. Use this instead of GetAllAssignedCranes when
+// a stable order is required.
+func GetAllAssignedCranesSorted() []*Crane {
+	cranesLock.RLock()
+	defer cranesLock.RUnlock()
+
+	hubIDs := make([]string, 0, len(assignedCranes))
+	for hubID := range assignedCranes {
+		hubIDs = append(hubIDs, hubID)
+	}
+	sort.Strings(hubIDs)
+
+	sortedCranes := make([]*Crane, 0, len(hubIDs))
+	for _, hubID := range hubIDs {
+		sortedCranes = append(sortedCranes, assignedCranes[hubID])
+	}
+	return sortedCranes
+}
+
+// GetCranesToHub returns all cranes that are connected to the Hub with the
+// given ID, including unassigned ones.
+func GetCranesToHub(hubID string) []*Crane {
+	cranesLock.RLock()
+	defer cranesLock.RUnlock()
+
+	var cranes []*Crane
+	for _, crane := range allCranes {
+		if crane.ConnectedHub != nil && crane.ConnectedHub.ID == hubID {
+			cranes = append(cranes, crane)
+		}
+	}
+	return cranes
+}
+
+// StopCranesToHubs stops all cranes that are connected to any of the given
+// Hubs, eg. because they were discontinued. Returns the amount of cranes
+// that were stopped.
+func StopCranesToHubs(hubIDs []string, reason string) (stopped int) {
+	for _, hubID := range hubIDs {
+		for _, crane := range GetCranesToHub(hubID) {
+			if crane.Stopped() {
+				continue
+			}
+			log.Infof("spn/docks: %s stopping: %s [%s]", crane, reason, crane.logFields())
+			crane.Stop(terminal.ErrHubUnavailable.With(reason))
+			stopped++
+		}
+	}
+	return stopped
+}
@@ -53,6 +53,7 @@ type CapacityTestOp struct {
 
 	testResult int
 	result     chan *terminal.Error
+	stopped    chan struct{}
 }
 
 type CapacityTestOptions struct {
@@ -95,6 +96,7 @@ func NewCapacityTestOp(t terminal.OpTerminal, opts *CapacityTestOptions) (*Capac
 		dataSent:        new(int64),
 		dataSentWasAckd: abool.New(),
 		result:          make(chan *terminal.Error, 1),
+		stopped:         make(chan struct{}),
 	}
 	op.OpBase.Init()
 
@@ -146,6 +148,7 @@ func runCapacityTestOp(t terminal.OpTerminal, opID uint32, data *container.Conta
 		dataSent:        new(int64),
 		dataSentWasAckd: abool.New(),
 		result:          make(chan *terminal.Error, 1),
+		stopped:         make(chan struct{}),
 	}
 	op.OpBase.Init()
 	op.OpBase.SetID(opID)
@@ -173,6 +176,10 @@ func (op *CapacityTestOp) handler(ctx context.Context) error {
 			returnErr = terminal.ErrCanceled
 			return nil
 
+		case <-op.stopped:
+			// The operation was ended, eg. canceled by the other side.
+			return nil
+
 		case <-opTimeout:
 			returnErr = terminal.ErrTimeout
 			return nil
@@ -300,12 +307,18 @@ func (op *CapacityTestOp) Deliver(c *container.Container) *terminal.Error {
 }
 
 func (op *CapacityTestOp) End(tErr *terminal.Error) {
+	close(op.stopped)
 	select {
 	case op.result <- tErr:
 	default:
 	}
 }
 
+// Cancel ends the operation on both ends before the test is finished.
+func (op *CapacityTestOp) Cancel() {
+	op.t.OpEnd(op, terminal.ErrCanceled.With("by initiator"))
+}
+
 func (op *CapacityTestOp) Result() <-chan *terminal.Error {
 	return op.result
 }
@@ -0,0 +1,203 @@
+package docks
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/rng"
+	"github.com/safing/spn/terminal"
+)
+
+const (
+	// EchoOpType is the type ID of the echo operation.
+	EchoOpType = "echo"
+
+	echoOpTimeout = 10 * time.Second
+)
+
+// EchoOp is the server side of the echo operation. It sends back any data it
+// receives verbatim.
+type EchoOp struct {
+	terminal.OpBase
+	t terminal.OpTerminal
+}
+
+// EchoClientOp sends a payload to the peer and checks that it is echoed back
+// verbatim. It is used for diagnosing transport issues, such as size limits or
+// corruption, over a specific crane.
+type EchoClientOp struct {
+	EchoOp
+
+	payload   []byte
+	sentAt    time.Time
+	roundTrip time.Duration
+	responses chan *container.Container
+
+	result chan *terminal.Error
+}
+
+// Type returns the type ID.
+func (op *EchoOp) Type() string {
+	return EchoOpType
+}
+
+func init() {
+	terminal.RegisterOpType(terminal.OpParams{
+		Type:     EchoOpType,
+		Requires: terminal.IsCraneController,
+		RunOp:    runEchoOp,
+	})
+}
+
+// NewEchoOp sends the given payload to the peer, which echoes it back.
+// The result reports an ErrIntegrity error if the echoed data does not match
+// and an ErrTimeout error if no echo was received in time.
+func NewEchoOp(t terminal.OpTerminal, payload []byte) (*EchoClientOp, *terminal.Error) {
+	if len(payload) == 0 {
+		return nil, terminal.ErrInvalidOptions.With("echo payload must not be empty")
+	}
+
+	// Create and init.
+	op := &EchoClientOp{
+		EchoOp: EchoOp{
+			t: t,
+		},
+		payload:   payload,
+		responses: make(chan *container.Container, 1),
+		result:    make(chan *terminal.Error, 1),
+	}
+	op.EchoOp.OpBase.Init()
+
+	// Send payload.
+	// Copy the payload, as the container may be modified when sending.
+	data := make([]byte, len(payload))
+	copy(data, payload)
+	op.sentAt = time.Now()
+	tErr := t.OpInit(op, container.New(data))
+	if tErr != nil {
+		return nil, tErr
+	}
+	t.Flush()
+
+	// Start handler.
+	module.StartWorker("op echo handler", op.handler)
+
+	return op, nil
+}
+
+// NewEchoOpWithSize sends a random payload of the given size to the peer,
+// which echoes it back. Use increasing sizes in order to find the size at
+// which transport issues start.
+func NewEchoOpWithSize(t terminal.OpTerminal, size int) (*EchoClientOp, *terminal.Error) {
+	if size <= 0 {
+		return nil, terminal.ErrInvalidOptions.With("echo payload size must be positive")
+	}
+
+	payload, err := rng.Bytes(size)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to create echo payload: %w", err)
+	}
+	return NewEchoOp(t, payload)
+}
+
+func (op *EchoClientOp) handler(ctx context.Context) error {
+	returnErr := terminal.ErrStopping
+	defer func() {
+		op.t.OpEnd(op, returnErr)
+	}()
+
+	select {
+	case <-ctx.Done():
+
+	case <-time.After(echoOpTimeout):
+		returnErr = terminal.ErrTimeout.With("no echo of %d bytes received within %s", len(op.payload), echoOpTimeout)
+
+	case data := <-op.responses:
+		// Check if the op ended.
+		if data == nil {
+			return nil
+		}
+
+		op.roundTrip = time.Since(op.sentAt)
+		if tErr := checkEcho(op.payload, data.CompileData()); tErr != nil {
+			returnErr = tErr
+		}
+	}
+
+	return nil
+}
+
+// checkEcho checks if the received echo matches the sent payload exactly.
+func checkEcho(sent, received []byte) *terminal.Error {
+	if len(sent) != len(received) {
+		return terminal.ErrIntegrity.With("echo length mismatch: sent %d bytes, received %d bytes", len(sent), len(received))
+	}
+	for i := range sent {
+		if sent[i] != received[i] {
+			return terminal.ErrIntegrity.With("echo data mismatch at byte %d of %d", i, len(sent))
+		}
+	}
+	return nil
+}
+
+// RoundTrip returns the duration until the echo was received.
+// It is only valid after the result was received.
+func (op *EchoClientOp) RoundTrip() time.Duration {
+	return op.roundTrip
+}
+
+// Deliver delivers a message to the operation.
+func (op *EchoClientOp) Deliver(c *container.Container) *terminal.Error {
+	select {
+	case op.responses <- c:
+		return nil
+	default:
+		return terminal.ErrIncorrectUsage.With("received more than one echo")
+	}
+}
+
+// End ends the operation.
+func (op *EchoClientOp) End(tErr *terminal.Error) {
+	close(op.responses)
+	select {
+	case op.result <- tErr:
+	default:
+	}
+}
+
+// Result returns the result of the operation.
+func (op *EchoClientOp) Result() <-chan *terminal.Error {
+	return op.result
+}
+
+func runEchoOp(t terminal.OpTerminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
+	// Create operation.
+	op := &EchoOp{
+		t: t,
+	}
+	op.OpBase.Init()
+	op.OpBase.SetID(opID)
+
+	// Echo the payload.
+	tErr := op.Deliver(data)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	return op, nil
+}
+
+// Deliver sends the received data back verbatim.
+func (op *EchoOp) Deliver(c *container.Container) *terminal.Error {
+	tErr := op.t.OpSend(op, c)
+	if tErr != nil {
+		return tErr.Wrap("failed to send echo")
+	}
+	op.t.Flush()
+
+	return nil
+}
+
+// End ends the operation.
+func (op *EchoOp) End(tErr *terminal.Error) {}
@@ -0,0 +1,59 @@
+package docks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/safing/spn/terminal"
+)
+
+func TestEchoOp(t *testing.T) {
+	var (
+		echoTestDelay            = 10 * time.Millisecond
+		echoTestQueueSize uint32 = 10
+	)
+
+	// Create test terminal pair.
+	a, b, err := terminal.NewSimpleTestTerminalPair(
+		echoTestDelay,
+		&terminal.TerminalOpts{
+			QueueSize: echoTestQueueSize,
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+
+	// Grant permission for op on remote terminal.
+	b.GrantPermission(terminal.IsCraneController)
+
+	for _, size := range []int{1, 100, 1000, 10000} {
+		op, tErr := NewEchoOpWithSize(a, size)
+		if tErr != nil {
+			t.Fatalf("failed to start op with %d bytes: %s", size, tErr)
+		}
+
+		// Wait for result and check error.
+		tErr = <-op.Result()
+		if tErr.IsError() {
+			t.Fatalf("op with %d bytes failed: %s", size, tErr)
+		}
+		t.Logf("echoed %d bytes in %s", size, op.RoundTrip())
+	}
+}
+
+func TestCheckEcho(t *testing.T) {
+	t.Parallel()
+
+	sent := []byte{1, 2, 3, 4}
+
+	if tErr := checkEcho(sent, []byte{1, 2, 3, 4}); tErr != nil {
+		t.Fatalf("matching echo should pass: %s", tErr)
+	}
+	if tErr := checkEcho(sent, []byte{1, 2, 3}); !tErr.Is(terminal.ErrIntegrity) {
+		t.Fatalf("truncated echo should fail with integrity error, got %s", tErr)
+	}
+	if tErr := checkEcho(sent, []byte{1, 2, 0, 4}); !tErr.Is(terminal.ErrIntegrity) {
+		t.Fatalf("modified echo should fail with integrity error, got %s", tErr)
+	}
+}
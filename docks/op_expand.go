@@ -90,7 +90,8 @@ func expand(t terminal.OpTerminal, opID uint32, data *container.Container) (term
 		return nil, tErr.Wrap("failed to parse terminal options")
 	}
 
-	// Get crane with destination.
+	// Get crane with destination and record usage for pre-warming.
+	RecordHubUsage(string(dstData))
 	relayCrane := GetAssignedCrane(string(dstData))
 	if relayCrane == nil {
 		return nil, terminal.ErrHubUnavailable.With("no crane assigned to %q", string(dstData))
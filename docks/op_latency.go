@@ -1,14 +1,10 @@
 package docks
 
 import (
-	"bytes"
 	"context"
-	"fmt"
 	"time"
 
-	"github.com/safing/portbase/formats/varint"
 	"github.com/safing/portbase/log"
-	"github.com/safing/portbase/rng"
 
 	"github.com/safing/portbase/container"
 	"github.com/safing/spn/terminal"
@@ -33,14 +29,10 @@ type LatencyTestOp struct {
 
 type LatencyTestClientOp struct {
 	LatencyTestOp
+	measurementOp
 
-	lastPingSentAt    time.Time
-	lastPingNonce     []byte
 	measuredLatencies []time.Duration
-	responses         chan *container.Container
 	testResult        time.Duration
-
-	result chan *terminal.Error
 }
 
 func (op *LatencyTestOp) Type() string {
@@ -51,6 +43,7 @@ func init() {
 	terminal.RegisterOpType(terminal.OpParams{
 		Type:     LatencyTestOpType,
 		Requires: terminal.IsCraneController,
+		Control:  true,
 		RunOp:    runLatencyTestOp,
 	})
 }
@@ -61,14 +54,19 @@ func NewLatencyTestOp(t terminal.OpTerminal) (*LatencyTestClientOp, *terminal.Er
 		LatencyTestOp: LatencyTestOp{
 			t: t,
 		},
-		responses:         make(chan *container.Container),
+		measurementOp: newMeasurementOp(
+			"ping",
+			latencyPingRequest,
+			latencyTestNonceSize,
+			latencyTestPauseDuration,
+			latencyTestOpTimeout,
+		),
 		measuredLatencies: make([]time.Duration, 0, latencyTestRuns),
-		result:            make(chan *terminal.Error, 1),
 	}
 	op.LatencyTestOp.OpBase.Init()
 
 	// Make ping request.
-	pingRequest, err := op.createPingRequest()
+	pingRequest, err := op.createRequest()
 	if err != nil {
 		return nil, terminal.ErrInternalError.With("%w", err)
 	}
@@ -86,99 +84,33 @@ func NewLatencyTestOp(t terminal.OpTerminal) (*LatencyTestClientOp, *terminal.Er
 }
 
 func (op *LatencyTestClientOp) handler(ctx context.Context) error {
-	returnErr := terminal.ErrStopping
-	defer op.t.OpEnd(op, returnErr)
-
-	var nextTest <-chan time.Time
-	opTimeout := time.After(latencyTestOpTimeout)
-
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-
-		case <-opTimeout:
-			return nil
-
-		case <-nextTest:
-			// Create ping request and send it.
-			pingRequest, err := op.createPingRequest()
-			if err != nil {
-				returnErr = terminal.ErrInternalError.With("%w", err)
-				return nil
-			}
-			tErr := op.t.OpSend(op, pingRequest)
-			if tErr != nil {
-				returnErr = tErr.Wrap("failed to send ping request")
-				return nil
-			}
-			op.t.Flush()
-
-			nextTest = nil
-
-		case data := <-op.responses:
-			// Check if the op ended.
-			if data == nil {
-				return nil
-			}
-
-			// Handle response
-			tErr := op.handleResponse(data)
-			if tErr != nil {
-				returnErr = tErr
-				return nil
-			}
-
-			// Check if we have enough latency tests.
-			if len(op.measuredLatencies) >= latencyTestRuns {
-				op.reportMeasuredLatencies()
-				return nil
-			}
-
-			// Schedule next latency test, if not yet scheduled.
-			if nextTest == nil {
-				nextTest = time.After(latencyTestPauseDuration)
-			}
-		}
-	}
-}
-
-func (op *LatencyTestClientOp) createPingRequest() (*container.Container, error) {
-	// Generate nonce.
-	nonce, err := rng.Bytes(latencyTestNonceSize)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create ping nonce")
-	}
-
-	// Set client request state.
-	op.lastPingSentAt = time.Now()
-	op.lastPingNonce = nonce
-
-	return container.New(
-		varint.Pack8(latencyPingRequest),
-		nonce,
-	), nil
+	op.handle(ctx, op.t, op, op.handleResponse)
+	return nil
 }
 
-func (op *LatencyTestClientOp) handleResponse(data *container.Container) *terminal.Error {
+func (op *LatencyTestClientOp) handleResponse(data *container.Container) (done bool, tErr *terminal.Error) {
 	rType, err := data.GetNextN8()
 	if err != nil {
-		return terminal.ErrMalformedData.With("failed to get response type: %w", err)
+		return false, terminal.ErrMalformedData.With("failed to get response type: %w", err)
 	}
 
 	switch rType {
 	case latencyPingResponse:
 		// Check if the ping nonce matches.
-		if !bytes.Equal(op.lastPingNonce, data.CompileData()) {
-			return terminal.ErrIntegrity.With("ping nonce mismatch")
+		if tErr := op.checkNonce(data); tErr != nil {
+			return false, tErr
 		}
-		op.lastPingNonce = nil
 		// Save latency.
-		op.measuredLatencies = append(op.measuredLatencies, time.Since(op.lastPingSentAt))
+		op.measuredLatencies = append(op.measuredLatencies, time.Since(op.lastRequestSentAt))
 
-		return nil
+		// Check if we have enough latency tests.
+		if len(op.measuredLatencies) >= latencyTestRuns {
+			op.reportMeasuredLatencies()
+			return true, nil
+		}
+		return false, nil
 	default:
-		return terminal.ErrIncorrectUsage.With("unknown response type")
+		return false, terminal.ErrIncorrectUsage.With("unknown response type")
 	}
 }
 
@@ -208,29 +140,16 @@ func (op *LatencyTestClientOp) reportMeasuredLatencies() *terminal.Error {
 }
 
 func (op *LatencyTestClientOp) Deliver(c *container.Container) *terminal.Error {
-	// Optimized delivery with 1s timeout.
-	select {
-	case op.responses <- c:
-	default:
-		select {
-		case op.responses <- c:
-		case <-time.After(1 * time.Second):
-			return terminal.ErrTimeout
-		}
-	}
-	return nil
+	return op.deliver(c)
 }
 
 func (op *LatencyTestClientOp) End(tErr *terminal.Error) {
-	close(op.responses)
-	select {
-	case op.result <- tErr:
-	default:
-	}
+	op.end(tErr)
 }
 
-func (op *LatencyTestClientOp) Result() <-chan *terminal.Error {
-	return op.result
+// Cancel ends the operation on both ends before the test is finished.
+func (op *LatencyTestClientOp) Cancel() {
+	op.t.OpEnd(op, terminal.ErrCanceled.With("by initiator"))
 }
 
 func runLatencyTestOp(t terminal.OpTerminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
@@ -262,11 +181,10 @@ func (op *LatencyTestOp) Deliver(c *container.Container) *terminal.Error {
 		c.PrependNumber(latencyPingResponse)
 
 		// Send response.
-		tErr := op.t.OpSend(op, c)
+		tErr := terminal.SendControlMsg(op.t, op, c)
 		if tErr != nil {
 			return tErr.Wrap("failed to send ping response")
 		}
-		op.t.Flush()
 
 		return nil
 
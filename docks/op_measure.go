@@ -0,0 +1,163 @@
+package docks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/rng"
+	"github.com/safing/spn/terminal"
+)
+
+// measurementOp holds the client side state shared by the operations that
+// measure the connection to a peer by exchanging a series of requests and
+// responses, such as the latency test and the time sync operation.
+type measurementOp struct {
+	name        string
+	requestType uint8
+	nonceSize   int
+	pause       time.Duration
+	timeout     time.Duration
+
+	lastRequestSentAt time.Time
+	lastRequestNonce  []byte
+	responses         chan *container.Container
+
+	result chan *terminal.Error
+}
+
+func newMeasurementOp(name string, requestType uint8, nonceSize int, pause, timeout time.Duration) measurementOp {
+	return measurementOp{
+		name:        name,
+		requestType: requestType,
+		nonceSize:   nonceSize,
+		pause:       pause,
+		timeout:     timeout,
+		responses:   make(chan *container.Container),
+		result:      make(chan *terminal.Error, 1),
+	}
+}
+
+// createRequest creates a new request with a fresh nonce and records when it
+// was created.
+func (m *measurementOp) createRequest() (*container.Container, error) {
+	// Generate nonce.
+	nonce, err := rng.Bytes(m.nonceSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s nonce", m.name)
+	}
+
+	// Set client request state.
+	m.lastRequestSentAt = time.Now()
+	m.lastRequestNonce = nonce
+
+	return container.New(
+		varint.Pack8(m.requestType),
+		nonce,
+	), nil
+}
+
+// checkNonce checks if the remaining data of the response matches the nonce
+// of the last request.
+func (m *measurementOp) checkNonce(data *container.Container) *terminal.Error {
+	if !bytes.Equal(m.lastRequestNonce, data.CompileData()) {
+		return terminal.ErrIntegrity.With("%s nonce mismatch", m.name)
+	}
+	m.lastRequestNonce = nil
+	return nil
+}
+
+// handle sends a request after every response until handleResponse reports
+// that the measurement is complete. It ends the operation when done.
+func (m *measurementOp) handle(
+	ctx context.Context,
+	t terminal.OpTerminal,
+	op terminal.Operation,
+	handleResponse func(data *container.Container) (done bool, tErr *terminal.Error),
+) {
+	returnErr := terminal.ErrStopping
+	defer func() {
+		t.OpEnd(op, returnErr)
+	}()
+
+	var nextTest <-chan time.Time
+	opTimeout := time.After(m.timeout)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-opTimeout:
+			returnErr = terminal.ErrTimeout.With("%s did not complete", m.name)
+			return
+
+		case <-nextTest:
+			// Create request and send it.
+			request, err := m.createRequest()
+			if err != nil {
+				returnErr = terminal.ErrInternalError.With("%w", err)
+				return
+			}
+			tErr := terminal.SendControlMsg(t, op, request)
+			if tErr != nil {
+				returnErr = tErr.Wrap("failed to send %s request", m.name)
+				return
+			}
+
+			nextTest = nil
+
+		case data := <-m.responses:
+			// Check if the op ended.
+			if data == nil {
+				return
+			}
+
+			// Handle response.
+			done, tErr := handleResponse(data)
+			if tErr != nil {
+				returnErr = tErr
+				return
+			}
+			if done {
+				return
+			}
+
+			// Schedule next request, if not yet scheduled.
+			if nextTest == nil {
+				nextTest = time.After(m.pause)
+			}
+		}
+	}
+}
+
+func (m *measurementOp) deliver(c *container.Container) *terminal.Error {
+	// Optimized delivery with 1s timeout.
+	select {
+	case m.responses <- c:
+	default:
+		select {
+		case m.responses <- c:
+		case <-time.After(1 * time.Second):
+			return terminal.ErrTimeout
+		}
+	}
+	return nil
+}
+
+func (m *measurementOp) end(tErr *terminal.Error) {
+	close(m.responses)
+	select {
+	case m.result <- tErr:
+	default:
+	}
+}
+
+// Result returns the channel that receives the result of the operation when
+// it ends.
+func (m *measurementOp) Result() <-chan *terminal.Error {
+	return m.result
+}
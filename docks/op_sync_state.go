@@ -4,8 +4,6 @@ import (
 	"context"
 	"time"
 
-	"github.com/safing/portbase/container"
-	"github.com/safing/portbase/formats/dsd"
 	"github.com/safing/spn/terminal"
 )
 
@@ -13,25 +11,18 @@ const (
 	SyncStateOpType = "sync/state"
 )
 
-type SyncStateOp struct {
-	terminal.OpBase
-	t      terminal.OpTerminal
-	result chan *terminal.Error
-}
-
 type SyncStateMessage struct {
 	Stopping bool
 }
 
-func (op *SyncStateOp) Type() string {
-	return SyncStateOpType
-}
-
 func init() {
-	terminal.RegisterOpType(terminal.OpParams{
+	terminal.RegisterRequestResponseOpType(terminal.RequestResponseParams{
 		Type:     SyncStateOpType,
 		Requires: terminal.IsCraneController,
-		RunOp:    runSyncStateOp,
+		NewRequest: func() interface{} {
+			return &SyncStateMessage{}
+		},
+		Handle: handleSyncState,
 	})
 }
 
@@ -41,43 +32,24 @@ func (controller *CraneControllerTerminal) SyncState(ctx context.Context) *termi
 		return nil
 	}
 
-	// Create and init.
-	op := &SyncStateOp{
-		t:      controller,
-		result: make(chan *terminal.Error, 1),
-	}
-	op.OpBase.Init()
-
 	// Create sync message.
 	msg := &SyncStateMessage{
 		Stopping: controller.Crane.stopping.IsSet(),
 	}
-	data, err := dsd.Dump(msg, dsd.CBOR)
-	if err != nil {
-		return terminal.ErrInternalError.With("%w", err)
-	}
 
-	// Send message.
-	tErr := controller.OpInit(op, container.New(data))
+	// Send message and wait for it to be applied. There is no response.
+	op, tErr := terminal.NewRequestResponseOp(controller, SyncStateOpType, msg, nil)
 	if tErr != nil {
 		return tErr
 	}
-
-	// Wait for reply
-	select {
-	case tErr = <-op.result:
-		if tErr.IsError() {
-			return tErr
-		}
+	tErr = op.WaitContext(ctx, 1*time.Minute)
+	if tErr != nil && ctx.Err() != nil {
 		return nil
-	case <-ctx.Done():
-		return nil
-	case <-time.After(1 * time.Minute):
-		return terminal.ErrTimeout.With("timed out while waiting for sync crane result")
 	}
+	return tErr
 }
 
-func runSyncStateOp(t terminal.OpTerminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
+func handleSyncState(t terminal.OpTerminal, request interface{}) (interface{}, *terminal.Error) {
 	// Check if we are a on a crane controller.
 	var ok bool
 	var controller *CraneControllerTerminal
@@ -90,14 +62,8 @@ func runSyncStateOp(t terminal.OpTerminal, opID uint32, data *container.Containe
 		return nil, terminal.ErrPermissinDenied.With("only public lane owner may change the crane status")
 	}
 
-	// Load message.
-	syncState := &SyncStateMessage{}
-	_, err := dsd.Load(data.CompileData(), syncState)
-	if err != nil {
-		return nil, terminal.ErrMalformedData.With("failed to load sync state message: %w", err)
-	}
-
 	// Apply sync state.
+	syncState := request.(*SyncStateMessage)
 	var changed bool
 	if syncState.Stopping {
 		if controller.Crane.stopping.SetToIf(false, true) {
@@ -116,16 +82,3 @@ func runSyncStateOp(t terminal.OpTerminal, opID uint32, data *container.Containe
 
 	return nil, nil
 }
-
-func (op *SyncStateOp) Deliver(c *container.Container) *terminal.Error {
-	return terminal.ErrIncorrectUsage
-}
-
-func (op *SyncStateOp) End(tErr *terminal.Error) {
-	if op.result != nil {
-		select {
-		case op.result <- tErr:
-		default:
-		}
-	}
-}
@@ -0,0 +1,251 @@
+package docks
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/terminal"
+)
+
+const (
+	TimeSyncOpType = "timesync"
+
+	timeSyncRequest  = 1
+	timeSyncResponse = 2
+
+	timeSyncNonceSize     = 16
+	timeSyncRuns          = 5
+	timeSyncPauseDuration = 500 * time.Millisecond
+	timeSyncOpTimeout     = timeSyncRuns * timeSyncPauseDuration * 6
+)
+
+// TimeSyncOp is the server side of the time sync operation.
+// It responds to time sync requests with the time the request was received and
+// the time the response was sent.
+type TimeSyncOp struct {
+	terminal.OpBase
+	t terminal.OpTerminal
+}
+
+// TimeSyncClientOp is the client side of the time sync operation.
+// It estimates the clock offset and round-trip delay to the peer by exchanging
+// timestamps, similar to NTP.
+type TimeSyncClientOp struct {
+	TimeSyncOp
+	measurementOp
+
+	samples []timeSyncSample
+
+	offset time.Duration
+	delay  time.Duration
+}
+
+// timeSyncSample holds a single offset and delay measurement.
+type timeSyncSample struct {
+	offset time.Duration
+	delay  time.Duration
+}
+
+func (op *TimeSyncOp) Type() string {
+	return TimeSyncOpType
+}
+
+func init() {
+	terminal.RegisterOpType(terminal.OpParams{
+		Type:     TimeSyncOpType,
+		Requires: terminal.IsCraneController,
+		Control:  true,
+		RunOp:    runTimeSyncOp,
+	})
+}
+
+// NewTimeSyncOp starts a new time sync operation on the given terminal.
+// Wait for Result() and then use Offset() and Delay() to get the estimates.
+func NewTimeSyncOp(t terminal.OpTerminal) (*TimeSyncClientOp, *terminal.Error) {
+	// Create and init.
+	op := &TimeSyncClientOp{
+		TimeSyncOp: TimeSyncOp{
+			t: t,
+		},
+		measurementOp: newMeasurementOp(
+			"time sync",
+			timeSyncRequest,
+			timeSyncNonceSize,
+			timeSyncPauseDuration,
+			timeSyncOpTimeout,
+		),
+		samples: make([]timeSyncSample, 0, timeSyncRuns),
+	}
+	op.TimeSyncOp.OpBase.Init()
+
+	// Make time sync request.
+	request, err := op.createRequest()
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("%w", err)
+	}
+
+	// Send request.
+	tErr := t.OpInit(op, request)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	// Start handler.
+	module.StartWorker("op time sync handler", op.handler)
+
+	return op, nil
+}
+
+func (op *TimeSyncClientOp) handler(ctx context.Context) error {
+	op.handle(ctx, op.t, op, op.handleResponse)
+	return nil
+}
+
+func (op *TimeSyncClientOp) handleResponse(data *container.Container) (done bool, tErr *terminal.Error) {
+	receivedAt := time.Now()
+
+	rType, err := data.GetNextN8()
+	if err != nil {
+		return false, terminal.ErrMalformedData.With("failed to get response type: %w", err)
+	}
+
+	switch rType {
+	case timeSyncResponse:
+		// Get remote timestamps.
+		remoteReceivedAt, err := data.GetNextN64()
+		if err != nil {
+			return false, terminal.ErrMalformedData.With("failed to get remote receive time: %w", err)
+		}
+		remoteSentAt, err := data.GetNextN64()
+		if err != nil {
+			return false, terminal.ErrMalformedData.With("failed to get remote send time: %w", err)
+		}
+
+		// Check if the nonce matches.
+		if tErr := op.checkNonce(data); tErr != nil {
+			return false, tErr
+		}
+
+		// Save sample.
+		op.samples = append(op.samples, calculateTimeSyncSample(
+			op.lastRequestSentAt,
+			time.Unix(0, int64(remoteReceivedAt)),
+			time.Unix(0, int64(remoteSentAt)),
+			receivedAt,
+		))
+
+		// Check if we have enough samples.
+		if len(op.samples) >= timeSyncRuns {
+			op.evaluateSamples()
+			return true, nil
+		}
+		return false, nil
+	default:
+		return false, terminal.ErrIncorrectUsage.With("unknown response type")
+	}
+}
+
+// calculateTimeSyncSample calculates the clock offset and round-trip delay
+// from the four timestamps of a request, like NTP does.
+// A positive offset means that the remote clock is ahead of the local clock.
+func calculateTimeSyncSample(sentAt, remoteReceivedAt, remoteSentAt, receivedAt time.Time) timeSyncSample {
+	return timeSyncSample{
+		offset: (remoteReceivedAt.Sub(sentAt) + remoteSentAt.Sub(receivedAt)) / 2,
+		delay:  receivedAt.Sub(sentAt) - remoteSentAt.Sub(remoteReceivedAt),
+	}
+}
+
+func (op *TimeSyncClientOp) evaluateSamples() {
+	// Use the sample with the lowest delay, as its offset is the most accurate.
+	best := op.samples[0]
+	for _, sample := range op.samples[1:] {
+		if sample.delay < best.delay {
+			best = sample
+		}
+	}
+	op.offset = best.offset
+	op.delay = best.delay
+
+	if controller, ok := op.t.(*CraneControllerTerminal); ok && controller.Crane.ConnectedHub != nil {
+		log.Infof(
+			"docks: estimated clock offset to %s: %s (delay %s)",
+			controller.Crane.ConnectedHub, op.offset, op.delay,
+		)
+	}
+}
+
+// Offset returns the estimated clock offset to the peer.
+// A positive offset means that the remote clock is ahead of the local clock.
+// Only valid after the operation finished successfully.
+func (op *TimeSyncClientOp) Offset() time.Duration {
+	return op.offset
+}
+
+// Delay returns the round-trip delay of the sample used for the offset.
+// Only valid after the operation finished successfully.
+func (op *TimeSyncClientOp) Delay() time.Duration {
+	return op.delay
+}
+
+func (op *TimeSyncClientOp) Deliver(c *container.Container) *terminal.Error {
+	return op.deliver(c)
+}
+
+func (op *TimeSyncClientOp) End(tErr *terminal.Error) {
+	op.end(tErr)
+}
+
+// Cancel ends the operation on both ends before the time sync is finished.
+func (op *TimeSyncClientOp) Cancel() {
+	op.t.OpEnd(op, terminal.ErrCanceled.With("by initiator"))
+}
+
+func runTimeSyncOp(t terminal.OpTerminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
+	// Create operation.
+	op := &TimeSyncOp{
+		t: t,
+	}
+	op.OpBase.Init()
+	op.OpBase.SetID(opID)
+
+	// Handle first request.
+	tErr := op.Deliver(data)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	return op, nil
+}
+
+func (op *TimeSyncOp) Deliver(c *container.Container) *terminal.Error {
+	receivedAt := time.Now()
+
+	rType, err := c.GetNextN8()
+	if err != nil {
+		return terminal.ErrMalformedData.With("failed to get request type: %w", err)
+	}
+
+	switch rType {
+	case timeSyncRequest:
+		// Keep the nonce and prepend the timestamps and the msg type.
+		c.Prepend(varint.Pack64(uint64(time.Now().UnixNano())))
+		c.Prepend(varint.Pack64(uint64(receivedAt.UnixNano())))
+		c.PrependNumber(timeSyncResponse)
+
+		// Send response.
+		tErr := terminal.SendControlMsg(op.t, op, c)
+		if tErr != nil {
+			return tErr.Wrap("failed to send time sync response")
+		}
+
+		return nil
+
+	default:
+		return terminal.ErrIncorrectUsage.With("unknown request type")
+	}
+}
+
+func (op *TimeSyncOp) End(tErr *terminal.Error) {}
@@ -0,0 +1,73 @@
+package docks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/safing/spn/terminal"
+)
+
+func TestTimeSyncOp(t *testing.T) {
+	var (
+		timeSyncTestDelay            = 10 * time.Millisecond
+		timeSyncTestQueueSize uint32 = 10
+	)
+
+	// Create test terminal pair.
+	a, b, err := terminal.NewSimpleTestTerminalPair(
+		timeSyncTestDelay,
+		&terminal.TerminalOpts{
+			QueueSize: timeSyncTestQueueSize,
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+
+	// Grant permission for op on remote terminal and start op.
+	b.GrantPermission(terminal.IsCraneController)
+	op, tErr := NewTimeSyncOp(a)
+	if tErr != nil {
+		t.Fatalf("failed to start op: %s", tErr)
+	}
+
+	// Wait for result and check error.
+	tErr = <-op.Result()
+	if tErr.IsError() {
+		t.Fatalf("op failed: %s", tErr)
+	}
+	t.Logf("estimated offset: %s, delay: %s", op.Offset(), op.Delay())
+
+	// Both terminals use the same clock, so the offset must be small.
+	if op.Offset() > timeSyncTestDelay/2 || op.Offset() < -timeSyncTestDelay/2 {
+		t.Fatalf("estimated offset too large: %s", op.Offset())
+	}
+
+	// Check if the delay is within parameters.
+	expectedDelay := float64(timeSyncTestDelay * 2)
+	if float64(op.Delay()) > expectedDelay*1.2 {
+		t.Fatal("measured delay too high")
+	}
+	if float64(op.Delay()) < expectedDelay*0.9 {
+		t.Fatal("measured delay too low")
+	}
+}
+
+func TestCalculateTimeSyncSample(t *testing.T) {
+	t.Parallel()
+
+	// Remote clock is 5s ahead, 10ms one-way delay, 2ms processing time.
+	now := time.Now()
+	sample := calculateTimeSyncSample(
+		now,
+		now.Add(5*time.Second+10*time.Millisecond),
+		now.Add(5*time.Second+12*time.Millisecond),
+		now.Add(22*time.Millisecond),
+	)
+	if sample.offset != 5*time.Second {
+		t.Errorf("unexpected offset: %s", sample.offset)
+	}
+	if sample.delay != 20*time.Millisecond {
+		t.Errorf("unexpected delay: %s", sample.delay)
+	}
+}
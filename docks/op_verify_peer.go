@@ -0,0 +1,174 @@
+package docks
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/terminal"
+)
+
+const (
+	// VerifyPeerOpType is the type ID of the verify peer operation.
+	VerifyPeerOpType = "verify/peer"
+
+	verifyPeerPurpose   = "peer identity verification"
+	verifyPeerOpTimeout = 1 * time.Minute
+)
+
+// VerifyPeerOp challenges the peer of a running crane to prove its identity
+// by signing a challenge with its Hub identity key.
+type VerifyPeerOp struct {
+	terminal.OpBase
+
+	controller   *CraneControllerTerminal
+	verification *cabin.Verification
+	result       chan *terminal.Error
+}
+
+// Type returns the type ID.
+func (op *VerifyPeerOp) Type() string {
+	return VerifyPeerOpType
+}
+
+func init() {
+	terminal.RegisterOpType(terminal.OpParams{
+		Type:     VerifyPeerOpType,
+		Requires: terminal.IsCraneController,
+		RunOp:    runVerifyPeerOp,
+	})
+}
+
+// NewVerifyPeerOp starts a new verify peer operation on the given crane
+// controller. If no challenge is given, a random one is generated.
+// The result is sent to the Result() channel and is nil if the peer
+// successfully verified its identity.
+func NewVerifyPeerOp(controller *CraneControllerTerminal, challenge []byte) (*VerifyPeerOp, *terminal.Error) {
+	// Check if we know which Hub we expect.
+	if controller.Crane.ConnectedHub == nil {
+		return nil, terminal.ErrIncorrectUsage.With("cannot verify peer without connected hub")
+	}
+
+	// Create verification request.
+	v, request, err := cabin.CreateVerificationRequestWithChallenge(challenge, verifyPeerPurpose, "", "")
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to create verification request: %w", err)
+	}
+
+	// Create and init.
+	op := &VerifyPeerOp{
+		controller:   controller,
+		verification: v,
+		result:       make(chan *terminal.Error, 1),
+	}
+	op.OpBase.Init()
+
+	// Send request.
+	tErr := controller.OpInit(op, container.New(request))
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	// End operation if the peer does not respond in time.
+	module.StartWorker("verify peer op timeout", func(ctx context.Context) error {
+		select {
+		case <-time.After(verifyPeerOpTimeout):
+			controller.OpEnd(op, terminal.ErrTimeout.With("waiting for peer verification"))
+		case <-ctx.Done():
+		}
+		return nil
+	})
+
+	return op, nil
+}
+
+// Result returns the result channel of the operation.
+func (op *VerifyPeerOp) Result() <-chan *terminal.Error {
+	return op.result
+}
+
+func runVerifyPeerOp(t terminal.OpTerminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
+	// Check if we are run by a controller.
+	controller, ok := t.(*CraneControllerTerminal)
+	if !ok {
+		return nil, terminal.ErrIncorrectUsage.With("verify peer op may only be started by a crane controller terminal, but was started by %T", t)
+	}
+
+	// Check if we have an identity.
+	if controller.Crane.identity == nil {
+		return nil, terminal.ErrIncorrectUsage.With("cannot handle verification request without designated identity")
+	}
+
+	// Create operation.
+	op := &VerifyPeerOp{
+		controller: controller,
+	}
+	op.OpBase.Init()
+	op.OpBase.SetID(opID)
+
+	// Sign verification request.
+	response, err := controller.Crane.identity.SignVerificationRequest(
+		data.CompileData(),
+		verifyPeerPurpose,
+		"", "",
+	)
+	if err != nil {
+		return nil, terminal.ErrPermissinDenied.With("failed to sign verification request: %w", err)
+	}
+
+	// Reply with signed response.
+	tErr := controller.OpSend(op, container.New(response))
+	if tErr != nil {
+		return nil, tErr.Wrap("failed to send verification response")
+	}
+
+	return op, nil
+}
+
+// Deliver delivers a message to the operation.
+func (op *VerifyPeerOp) Deliver(c *container.Container) *terminal.Error {
+	// Only the client receives data.
+	if op.verification == nil {
+		return terminal.ErrIncorrectUsage.With("unexpected data")
+	}
+
+	// Verify the signed response with the known Hub.
+	response := c.CompileData()
+	err := op.verification.Verify(response, op.controller.Crane.ConnectedHub)
+	if err != nil {
+		// The Hub info might have been updated since the crane started.
+		// Check again with the latest Hub info.
+		latest, getErr := hub.GetHub(conf.MainMapName, op.controller.Crane.ConnectedHub.ID)
+		if getErr != nil {
+			return terminal.ErrIntegrity.With("failed to verify peer: %w", err)
+		}
+		err = op.verification.Verify(response, latest)
+		if err != nil {
+			return terminal.ErrIntegrity.With("failed to verify peer with latest hub info: %w", err)
+		}
+	}
+
+	log.Infof("spn/docks: %s peer successfully verified its identity [%s]", op.controller.Crane, op.controller.Crane.logFields())
+	return terminal.ErrExplicitAck
+}
+
+// End ends the operation.
+func (op *VerifyPeerOp) End(tErr *terminal.Error) {
+	if op.result == nil {
+		return
+	}
+
+	// Report success as nil.
+	if tErr.Is(terminal.ErrExplicitAck) {
+		tErr = nil
+	}
+
+	select {
+	case op.result <- tErr:
+	default:
+	}
+}
@@ -0,0 +1,308 @@
+package docks
+
+import (
+	"context"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/spn/terminal"
+)
+
+// Default quarantine configuration.
+const (
+	// DefaultQuarantineStrikeLimit defines how many protocol violations of a
+	// peer are tolerated within the strike window before it is quarantined.
+	DefaultQuarantineStrikeLimit = 5
+
+	// DefaultQuarantineStrikeWindow defines the time window in which protocol
+	// violations are counted.
+	DefaultQuarantineStrikeWindow = 10 * time.Minute
+
+	// DefaultQuarantineDuration defines how long a peer is quarantined.
+	DefaultQuarantineDuration = 1 * time.Hour
+
+	// quarantineStrikeDedupWindow defines the time window in which further
+	// violations of a peer are not counted as another strike. A violation
+	// usually surfaces at several places, such as the abandoned terminal and
+	// the stopping crane, which must only count once.
+	quarantineStrikeDedupWindow = 1 * time.Second
+
+	// quarantineIPStrikeFactor multiplies the strike limit for peers that are
+	// only known by their IP address. Many peers may share an IP address
+	// behind a NAT, which must not be quarantined for a single one of them.
+	quarantineIPStrikeFactor = 3
+
+	// maxPeerStrikes limits the amount of peers with strikes that are tracked
+	// at the same time. Strikes of further peers are not counted until
+	// tracked peers are pruned.
+	maxPeerStrikes = 10000
+
+	// quarantinePruneInterval defines how often expired strikes and
+	// quarantines are pruned.
+	quarantinePruneInterval = 10 * time.Minute
+)
+
+// QuarantineEntry describes a quarantined peer.
+type QuarantineEntry struct {
+	// HubID is the ID of the quarantined Hub, if known.
+	HubID string
+	// IP is the IP address of the quarantined peer, if known.
+	IP string
+	// Reason is the last protocol violation of the peer.
+	Reason string
+	// Since is when the peer was quarantined.
+	Since time.Time
+	// Until is when the quarantine ends.
+	Until time.Time
+}
+
+var (
+	peerStrikes     = make(map[string][]time.Time) // Key is "hub:<ID>" or "ip:<IP>".
+	quarantinedPeer = make(map[string]*QuarantineEntry)
+	quarantineLock  sync.Mutex
+
+	quarantineStrikeLimit  = DefaultQuarantineStrikeLimit
+	quarantineStrikeWindow = DefaultQuarantineStrikeWindow
+	quarantineDuration     = DefaultQuarantineDuration
+)
+
+// SetQuarantineConfig sets how many protocol violations of a peer are
+// tolerated within the strike window before it is quarantined for the given
+// duration. Existing quarantines are not changed.
+func SetQuarantineConfig(strikeLimit int, strikeWindow, duration time.Duration) {
+	quarantineLock.Lock()
+	defer quarantineLock.Unlock()
+
+	quarantineStrikeLimit = strikeLimit
+	quarantineStrikeWindow = strikeWindow
+	quarantineDuration = duration
+}
+
+// isProtocolViolation returns whether the given error signifies a protocol
+// violation by the peer.
+func isProtocolViolation(tErr *terminal.Error) bool {
+	switch {
+	case tErr == nil:
+		return false
+	case tErr.IsExternal():
+		// Errors reported by the peer are not violations by the peer.
+		return false
+	case tErr.Is(terminal.ErrMalformedData),
+		tErr.Is(terminal.ErrIntegrity),
+		tErr.Is(terminal.ErrQueueOverflow):
+		return true
+	default:
+		return false
+	}
+}
+
+// peerKey returns the quarantine key of the peer of the crane. Authenticated
+// Hubs are identified by their Hub ID. Other peers are identified by their IP
+// address.
+func (crane *Crane) peerKey() (hubID, ip, key string) {
+	if remoteAddr := crane.ship.RemoteAddr(); remoteAddr != nil {
+		if remoteIP, err := netutils.IPFromAddr(remoteAddr); err == nil {
+			ip = remoteIP.String()
+		}
+	}
+
+	switch {
+	case crane.ConnectedHub != nil:
+		hubID = crane.ConnectedHub.ID
+		return hubID, ip, "hub:" + hubID
+	case ip != "":
+		return "", ip, "ip:" + ip
+	default:
+		return "", "", ""
+	}
+}
+
+// reportViolation reports the given error as a strike against the peer of
+// the crane, if it is a protocol violation. Strikes are counted per Hub ID, or
+// per remote IP address for peers that are not authenticated, so that
+// reconnecting does not reset them. Peers that are only known by their IP
+// address are given more strikes, as the IP address may be shared by many
+// peers. Violations within the dedup window of the previous strike are not
+// counted again. It returns whether the peer was quarantined because of it.
+func (crane *Crane) reportViolation(tErr *terminal.Error) (quarantined bool) {
+	return crane.reportViolationAt(tErr, time.Now())
+}
+
+func (crane *Crane) reportViolationAt(tErr *terminal.Error, now time.Time) (quarantined bool) {
+	if !isProtocolViolation(tErr) {
+		return false
+	}
+	hubID, ip, key := crane.peerKey()
+	if key == "" {
+		return false
+	}
+
+	quarantineLock.Lock()
+	defer quarantineLock.Unlock()
+
+	// Make room for the new peer, if needed.
+	strikes, tracked := peerStrikes[key]
+	if !tracked && len(peerStrikes) >= maxPeerStrikes {
+		pruneQuarantine(now)
+		if len(peerStrikes) >= maxPeerStrikes {
+			log.Warningf("spn/docks: %s not counting protocol violation, too many peers with strikes: %s [%s]", crane, tErr, crane.logFields())
+			return false
+		}
+	}
+
+	// Add strike, unless the previous strike is within the dedup window.
+	if len(strikes) == 0 || now.Sub(strikes[len(strikes)-1]) >= quarantineStrikeDedupWindow {
+		strikes = append(strikes, now)
+	}
+
+	// Remove strikes outside of the window.
+	windowStart := now.Add(-quarantineStrikeWindow)
+	for len(strikes) > 0 && strikes[0].Before(windowStart) {
+		strikes = strikes[1:]
+	}
+	peerStrikes[key] = strikes
+
+	// Check if the peer reached the strike limit.
+	strikeLimit := quarantineStrikeLimit
+	if hubID == "" && strikeLimit <= math.MaxInt32/quarantineIPStrikeFactor {
+		strikeLimit *= quarantineIPStrikeFactor
+	}
+	if len(strikes) < strikeLimit {
+		return false
+	}
+
+	// Quarantine peer.
+	entry := &QuarantineEntry{
+		HubID:  hubID,
+		IP:     ip,
+		Reason: tErr.Error(),
+		Since:  now,
+		Until:  now.Add(quarantineDuration),
+	}
+	quarantinedPeer[key] = entry
+	delete(peerStrikes, key)
+
+	log.Warningf("spn/docks: %s peer quarantined until %s after %d protocol violations: %s [%s]", crane, entry.Until.Format(time.RFC3339), strikeLimit, tErr, crane.logFields())
+	return true
+}
+
+// pruneQuarantineTask periodically removes expired strikes and quarantines.
+func pruneQuarantineTask(_ context.Context, _ *modules.Task) error {
+	quarantineLock.Lock()
+	defer quarantineLock.Unlock()
+
+	pruneQuarantine(time.Now())
+	return nil
+}
+
+// pruneQuarantine removes strikes that are outside of the strike window and
+// quarantines that ended. The caller must hold quarantineLock.
+func pruneQuarantine(now time.Time) {
+	windowStart := now.Add(-quarantineStrikeWindow)
+	for key, strikes := range peerStrikes {
+		if len(strikes) == 0 || strikes[len(strikes)-1].Before(windowStart) {
+			delete(peerStrikes, key)
+		}
+	}
+	for key, entry := range quarantinedPeer {
+		if now.After(entry.Until) {
+			delete(quarantinedPeer, key)
+		}
+	}
+}
+
+// isQuarantined returns whether the given key is quarantined.
+func isQuarantined(key string) bool {
+	quarantineLock.Lock()
+	defer quarantineLock.Unlock()
+
+	entry, ok := quarantinedPeer[key]
+	if !ok {
+		return false
+	}
+
+	// Remove expired quarantine.
+	if time.Now().After(entry.Until) {
+		delete(quarantinedPeer, key)
+		return false
+	}
+	return true
+}
+
+// IsHubQuarantined returns whether the Hub with the given ID is quarantined.
+func IsHubQuarantined(hubID string) bool {
+	return isQuarantined("hub:" + hubID)
+}
+
+// IsIPQuarantined returns whether the given IP address is quarantined.
+func IsIPQuarantined(ip net.IP) bool {
+	return isQuarantined("ip:" + ip.String())
+}
+
+// IsAddrQuarantined returns whether the IP address of the given address is
+// quarantined.
+func IsAddrQuarantined(addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	ip, err := netutils.IPFromAddr(addr)
+	if err != nil {
+		return false
+	}
+	return IsIPQuarantined(ip)
+}
+
+// GetQuarantineList returns a list of all currently quarantined peers.
+func GetQuarantineList() []*QuarantineEntry {
+	quarantineLock.Lock()
+	defer quarantineLock.Unlock()
+
+	// Collect unique and active entries and clean up expired ones.
+	now := time.Now()
+	seen := make(map[*QuarantineEntry]struct{})
+	list := make([]*QuarantineEntry, 0, len(quarantinedPeer))
+	for key, entry := range quarantinedPeer {
+		if now.After(entry.Until) {
+			delete(quarantinedPeer, key)
+			continue
+		}
+		if _, ok := seen[entry]; ok {
+			continue
+		}
+		seen[entry] = struct{}{}
+
+		copied := *entry
+		list = append(list, &copied)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Since.Before(list[j].Since)
+	})
+	return list
+}
+
+// ReleaseFromQuarantine removes the given Hub ID or IP address from the
+// quarantine and resets its strikes.
+func ReleaseFromQuarantine(hubIDOrIP string) (released bool) {
+	quarantineLock.Lock()
+	defer quarantineLock.Unlock()
+
+	for _, key := range []string{"hub:" + hubIDOrIP, "ip:" + hubIDOrIP} {
+		if _, ok := quarantinedPeer[key]; ok {
+			delete(quarantinedPeer, key)
+			released = true
+		}
+		delete(peerStrikes, key)
+	}
+
+	if released {
+		log.Infof("spn/docks: released %s from quarantine", hubIDOrIP)
+	}
+	return released
+}
@@ -0,0 +1,216 @@
+package docks
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/ships"
+	"github.com/safing/spn/terminal"
+)
+
+func TestQuarantine(t *testing.T) {
+	newCrane := func() *Crane {
+		crane, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: "quarantine-test"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return crane
+	}
+
+	// External errors and non-violations must not count.
+	crane := newCrane()
+	for i := 0; i < DefaultQuarantineStrikeLimit; i++ {
+		crane.reportViolation(terminal.ErrMalformedData.AsExternal())
+		crane.reportViolation(terminal.ErrTimeout)
+	}
+	unregisterCrane(crane)
+	if IsHubQuarantined("quarantine-test") {
+		t.Fatal("hub should not be quarantined")
+	}
+
+	// Violations within the dedup window only count once.
+	now := time.Now()
+	crane = newCrane()
+	crane.reportViolationAt(terminal.ErrMalformedData.With("test"), now)
+	crane.reportViolationAt(terminal.ErrIntegrity.With("test"), now.Add(quarantineStrikeDedupWindow/2))
+	unregisterCrane(crane)
+	quarantineLock.Lock()
+	strikes := len(peerStrikes["hub:quarantine-test"])
+	quarantineLock.Unlock()
+	if strikes != 1 {
+		t.Fatalf("expected 1 strike, got %d", strikes)
+	}
+
+	// Repeated violations on the same crane count, as do violations on new
+	// cranes of the same peer.
+	crane = newCrane()
+	defer unregisterCrane(crane)
+	for i := 2; i <= DefaultQuarantineStrikeLimit; i++ {
+		now = now.Add(quarantineStrikeDedupWindow)
+		reporter := crane
+		if i%2 == 0 {
+			reporter = newCrane()
+		}
+		quarantined := reporter.reportViolationAt(terminal.ErrMalformedData.With("test"), now)
+		if reporter != crane {
+			unregisterCrane(reporter)
+		}
+		if quarantined != (i == DefaultQuarantineStrikeLimit) {
+			t.Fatalf("unexpected quarantine state %v after %d violations", quarantined, i)
+		}
+	}
+	if !IsHubQuarantined("quarantine-test") {
+		t.Fatal("hub should be quarantined")
+	}
+	if len(GetQuarantineList()) != 1 {
+		t.Fatalf("expected one quarantine entry, got %d", len(GetQuarantineList()))
+	}
+
+	// Release.
+	if !ReleaseFromQuarantine("quarantine-test") {
+		t.Fatal("failed to release hub")
+	}
+	if IsHubQuarantined("quarantine-test") {
+		t.Fatal("hub should not be quarantined anymore")
+	}
+}
+
+// addrShip is a ship with a remote address.
+type addrShip struct {
+	ships.Ship
+
+	remoteAddr net.Addr
+}
+
+func (ship *addrShip) RemoteAddr() net.Addr {
+	return ship.remoteAddr
+}
+
+func TestQuarantineKeys(t *testing.T) {
+	remoteAddr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 17}
+	newCrane := func(connectedHub *hub.Hub) *Crane {
+		ship := &addrShip{
+			Ship:       ships.NewTestShip(true, 100),
+			remoteAddr: remoteAddr,
+		}
+		crane, err := NewCrane(context.TODO(), ship, connectedHub, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return crane
+	}
+	defer ReleaseFromQuarantine("quarantine-keys-test")
+	defer ReleaseFromQuarantine(remoteAddr.IP.String())
+
+	// Quarantining a Hub does not quarantine its IP address, which may be
+	// shared by others.
+	now := time.Now()
+	crane := newCrane(&hub.Hub{ID: "quarantine-keys-test"})
+	for i := 0; i < DefaultQuarantineStrikeLimit; i++ {
+		now = now.Add(quarantineStrikeDedupWindow)
+		crane.reportViolationAt(terminal.ErrMalformedData.With("test"), now)
+	}
+	unregisterCrane(crane)
+	if !IsHubQuarantined("quarantine-keys-test") {
+		t.Fatal("hub should be quarantined")
+	}
+	if IsIPQuarantined(remoteAddr.IP) {
+		t.Fatal("ip of hub should not be quarantined")
+	}
+
+	// Unauthenticated peers are quarantined by IP address, with a higher
+	// strike limit.
+	crane = newCrane(nil)
+	defer unregisterCrane(crane)
+	ipStrikeLimit := DefaultQuarantineStrikeLimit * quarantineIPStrikeFactor
+	for i := 1; i <= ipStrikeLimit; i++ {
+		now = now.Add(quarantineStrikeDedupWindow)
+		quarantined := crane.reportViolationAt(terminal.ErrMalformedData.With("test"), now)
+		if quarantined != (i == ipStrikeLimit) {
+			t.Fatalf("unexpected quarantine state %v after %d violations", quarantined, i)
+		}
+	}
+	if !IsAddrQuarantined(remoteAddr) {
+		t.Fatal("ip should be quarantined")
+	}
+}
+
+func TestQuarantinePruning(t *testing.T) {
+	crane, err := NewCrane(context.TODO(), ships.NewTestShip(true, 100), &hub.Hub{ID: "quarantine-prune-test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterCrane(crane)
+
+	locked := func(fn func()) {
+		quarantineLock.Lock()
+		defer quarantineLock.Unlock()
+		fn()
+	}
+	defer locked(func() {
+		peerStrikes = make(map[string][]time.Time)
+		quarantinedPeer = make(map[string]*QuarantineEntry)
+	})
+	setStrikes := func(i int, strikeAt time.Time) {
+		peerStrikes[fmt.Sprintf("hub:prune-%d", i)] = []time.Time{strikeAt}
+	}
+	expired := time.Now().Add(-2 * DefaultQuarantineStrikeWindow)
+
+	// Fill up strikes of other peers, half of them expired.
+	now := time.Now()
+	var strikesLeft, quarantinesLeft int
+	locked(func() {
+		for i := 0; i < maxPeerStrikes; i++ {
+			if i%2 == 0 {
+				setStrikes(i, expired)
+			} else {
+				setStrikes(i, now)
+			}
+		}
+		quarantinedPeer["hub:prune-expired"] = &QuarantineEntry{Until: now.Add(-time.Second)}
+		quarantinedPeer["hub:prune-active"] = &QuarantineEntry{Until: now.Add(time.Hour)}
+
+		pruneQuarantine(now)
+		strikesLeft = len(peerStrikes)
+		quarantinesLeft = len(quarantinedPeer)
+	})
+	if strikesLeft != maxPeerStrikes/2 {
+		t.Fatalf("expected %d peers with strikes after pruning, got %d", maxPeerStrikes/2, strikesLeft)
+	}
+	if quarantinesLeft != 1 || !isQuarantined("hub:prune-active") {
+		t.Fatal("expected only the active quarantine to remain")
+	}
+
+	// Strikes of new peers are not counted while the tracked peers are full.
+	var counted bool
+	locked(func() {
+		for i := 0; i < maxPeerStrikes; i += 2 {
+			setStrikes(i, now)
+		}
+	})
+	crane.reportViolationAt(terminal.ErrMalformedData.With("test"), now)
+	locked(func() {
+		_, counted = peerStrikes["hub:quarantine-prune-test"]
+	})
+	if counted {
+		t.Fatal("strike should not be counted while tracked peers are full")
+	}
+
+	// Expired strikes are pruned to make room for new peers.
+	locked(func() {
+		for i := 0; i < maxPeerStrikes; i += 2 {
+			setStrikes(i, expired)
+		}
+	})
+	crane.reportViolationAt(terminal.ErrMalformedData.With("test"), now)
+	locked(func() {
+		_, counted = peerStrikes["hub:quarantine-prune-test"]
+	})
+	if !counted {
+		t.Fatal("strike should be counted after pruning")
+	}
+}
@@ -0,0 +1,70 @@
+package docks
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/safing/spn/ships"
+)
+
+var (
+	trustedLinkNetworks     []*net.IPNet
+	trustedLinkNetworksLock sync.RWMutex
+)
+
+// SetTrustedLinkNetworks sets the networks in which links are regarded as
+// secure, so that cranes to or from them do not use an additional encryption
+// layer, even if the ship does not provide transport security.
+// Cranes on trusted links are neither encrypted nor is the identity of the
+// connected Hub verified during the crane setup. Only use this for private
+// networks that are fully controlled by the operator.
+// Both ends of the crane must regard the link as trusted.
+func SetTrustedLinkNetworks(cidrs []string) error {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted link network %q: %w", cidr, err)
+		}
+		if ones, _ := network.Mask.Size(); ones == 0 {
+			return fmt.Errorf("trusted link network %q covers all addresses", cidr)
+		}
+		networks = append(networks, network)
+	}
+
+	trustedLinkNetworksLock.Lock()
+	defer trustedLinkNetworksLock.Unlock()
+
+	trustedLinkNetworks = networks
+	return nil
+}
+
+// isTrustedLink returns whether the remote address of the given ship is in
+// one of the trusted link networks.
+func isTrustedLink(ship ships.Ship) bool {
+	trustedLinkNetworksLock.RLock()
+	defer trustedLinkNetworksLock.RUnlock()
+
+	if len(trustedLinkNetworks) == 0 {
+		return false
+	}
+
+	// Get IP of remote address.
+	var ip net.IP
+	switch addr := ship.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return false
+	}
+
+	for _, network := range trustedLinkNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
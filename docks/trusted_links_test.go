@@ -0,0 +1,57 @@
+package docks
+
+import (
+	"net"
+	"testing"
+
+	"github.com/safing/spn/ships"
+)
+
+type trustedLinkTestShip struct {
+	*ships.TestShip
+	remoteAddr net.Addr
+}
+
+func (s *trustedLinkTestShip) RemoteAddr() net.Addr {
+	return s.remoteAddr
+}
+
+func TestTrustedLinks(t *testing.T) {
+	defer func() {
+		_ = SetTrustedLinkNetworks(nil)
+	}()
+
+	newShip := func(ip string) ships.Ship {
+		return &trustedLinkTestShip{
+			TestShip:   ships.NewTestShip(false, 100),
+			remoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 17},
+		}
+	}
+
+	// Nothing is trusted by default.
+	if isTrustedLink(newShip("10.0.0.1")) {
+		t.Fatal("link should not be trusted without configuration")
+	}
+
+	// Invalid and overly broad networks are rejected.
+	if err := SetTrustedLinkNetworks([]string{"10.0.0.1"}); err == nil {
+		t.Fatal("network without prefix length should be rejected")
+	}
+	if err := SetTrustedLinkNetworks([]string{"0.0.0.0/0"}); err == nil {
+		t.Fatal("network covering all addresses should be rejected")
+	}
+
+	// Only links in the trusted networks are trusted.
+	if err := SetTrustedLinkNetworks([]string{"10.0.0.0/8", "fd00::/8"}); err != nil {
+		t.Fatal(err)
+	}
+	if !isTrustedLink(newShip("10.1.2.3")) || !isTrustedLink(newShip("fd12::1")) {
+		t.Fatal("link in trusted network should be trusted")
+	}
+	if isTrustedLink(newShip("192.168.1.1")) {
+		t.Fatal("link outside of trusted networks should not be trusted")
+	}
+	if isTrustedLink(ships.NewTestShip(false, 100)) {
+		t.Fatal("link without remote address should not be trusted")
+	}
+}
@@ -0,0 +1,146 @@
+package hub
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+
+Hub Capabilities:
+
+Hubs advertise the features they support in the Capabilities field of their
+Announcement, so that clients can avoid routing through Hubs that lack a
+needed feature, instead of finding out at connection time.
+
+A capability is formatted as "<namespace>/<name>", where the name may contain
+further slashes. Defined namespaces are:
+
+- op: an operation type that the Hub accepts, eg. "op/connect"
+- feature: a protocol feature, eg. "feature/example"
+
+Capabilities with unknown namespaces are kept, so that new namespaces can be
+introduced without requiring all Hubs to update at the same time.
+
+Announcements without capabilities stem from Hubs that predate the field.
+They, and all others, are treated as supporting the BaselineCapabilities.
+
+*/
+
+// Capability Namespaces.
+const (
+	CapabilityNamespaceOp      = "op"
+	CapabilityNamespaceFeature = "feature"
+)
+
+const (
+	maxCapabilities      = 255
+	maxCapabilityLength  = 64
+	capabilitySeparator  = "/"
+	debugOpTypeNamespace = "debug/"
+)
+
+// BaselineCapabilities are the capabilities of Hubs that do not advertise
+// any, as they were supported by all Hubs before the capabilities were
+// introduced.
+var BaselineCapabilities = []string{
+	OpCapability("auth"),
+	OpCapability("capacity"),
+	OpCapability("connect"),
+	OpCapability("expand"),
+	OpCapability("gossip"),
+	OpCapability("gossip/query"),
+	OpCapability("latency"),
+	OpCapability("publish"),
+	OpCapability("sync/state"),
+}
+
+var (
+	localCapabilities     = make(map[string]struct{})
+	localCapabilitiesLock sync.Mutex
+)
+
+// OpCapability returns the capability for accepting the given operation type.
+func OpCapability(opType string) string {
+	return CapabilityNamespaceOp + capabilitySeparator + opType
+}
+
+// FeatureCapability returns the capability for the given protocol feature.
+func FeatureCapability(feature string) string {
+	return CapabilityNamespaceFeature + capabilitySeparator + feature
+}
+
+// RegisterLocalCapability registers a capability of this Hub, which will be
+// advertised in its Announcement.
+// Operation types of the debug namespace are never advertised.
+func RegisterLocalCapability(capability string) {
+	if strings.HasPrefix(capability, OpCapability(debugOpTypeNamespace)) {
+		return
+	}
+
+	localCapabilitiesLock.Lock()
+	defer localCapabilitiesLock.Unlock()
+
+	localCapabilities[capability] = struct{}{}
+}
+
+// LocalCapabilities returns the sorted capabilities of this Hub.
+func LocalCapabilities() []string {
+	localCapabilitiesLock.Lock()
+	defer localCapabilitiesLock.Unlock()
+
+	capabilities := make([]string, 0, len(localCapabilities))
+	for capability := range localCapabilities {
+		capabilities = append(capabilities, capability)
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// HasCapability returns whether the Hub announced the given capability or
+// if it is part of the baseline.
+func (a *Announcement) HasCapability(capability string) bool {
+	if a == nil {
+		return false
+	}
+
+	for _, c := range a.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	for _, c := range BaselineCapabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCapabilities returns whether the Hub has all of the given capabilities.
+func (a *Announcement) HasCapabilities(capabilities ...string) bool {
+	for _, capability := range capabilities {
+		if !a.HasCapability(capability) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkCapabilitiesFormat checks if the given capabilities conform to the
+// capability format.
+func checkCapabilitiesFormat(capabilities []string) error {
+	if err := checkStringSliceFormat("Capabilities", capabilities, maxCapabilities, maxCapabilityLength); err != nil {
+		return err
+	}
+
+	for _, capability := range capabilities {
+		parts := strings.SplitN(capability, capabilitySeparator, 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("field Capabilities has invalid capability %q", capability)
+		}
+	}
+	return nil
+}
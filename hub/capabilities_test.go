@@ -0,0 +1,47 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilities(t *testing.T) {
+	t.Parallel()
+
+	// Announcements without capabilities have the baseline.
+	old := &Announcement{}
+	assert.True(t, old.HasCapability(OpCapability("connect")), "baseline capability must be present")
+	assert.False(t, old.HasCapability(OpCapability("ping")), "newer capability must not be present")
+
+	// Advertised capabilities are added to the baseline.
+	current := &Announcement{
+		Capabilities: []string{OpCapability("ping"), FeatureCapability("example")},
+	}
+	assert.True(t, current.HasCapabilities(
+		OpCapability("connect"),
+		OpCapability("ping"),
+		FeatureCapability("example"),
+	), "advertised and baseline capabilities must be present")
+	assert.False(t, current.HasCapabilities(OpCapability("ping"), OpCapability("unknown")))
+
+	// Nil announcements have no capabilities.
+	var missing *Announcement
+	assert.False(t, missing.HasCapability(OpCapability("connect")))
+
+	// Debug operations are not advertised.
+	RegisterLocalCapability(OpCapability("debug/test"))
+	RegisterLocalCapability(OpCapability("test"))
+	assert.NotContains(t, LocalCapabilities(), OpCapability("debug/test"))
+	assert.Contains(t, LocalCapabilities(), OpCapability("test"))
+}
+
+func TestCheckCapabilitiesFormat(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, checkCapabilitiesFormat(nil))
+	assert.NoError(t, checkCapabilitiesFormat([]string{"op/sync/state", "unknown/capability"}))
+	assert.Error(t, checkCapabilitiesFormat([]string{"op"}))
+	assert.Error(t, checkCapabilitiesFormat([]string{"/connect"}))
+	assert.Error(t, checkCapabilitiesFormat([]string{"op/"}))
+}
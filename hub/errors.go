@@ -9,6 +9,9 @@ var (
 	// ErrMissingTransports signifies that the hub announcement did not specify any transports.
 	ErrMissingTransports = errors.New("hub announcement is missing transports")
 
+	// ErrNoPermittedTransports signifies that none of the transports of the hub are permitted by the transport policy.
+	ErrNoPermittedTransports = errors.New("hub has no transports permitted by the transport policy")
+
 	// ErrMissingIPs signifies that the hub announcement did not specify any IPs.
 	ErrMissingIPs = errors.New("hub announcement is missing IPs")
 
@@ -58,6 +58,7 @@ type Hub struct {
 	VerifiedIPs   bool
 	InvalidInfo   bool
 	InvalidStatus bool
+	ClockSkewed   bool
 }
 
 // Announcement is the main message type to publish Hub Information. This only changes if updated manually.
@@ -107,6 +108,12 @@ type Announcement struct {
 	// {"+ ", "- *"}
 	Exit []string
 	// {"- * TCP/25", "- US"}
+
+	// Capabilities holds the features supported by the Hub.
+	// Announcements without capabilities are treated as supporting the
+	// BaselineCapabilities.
+	Capabilities []string
+	// {"op/connect", "op/ping", "feature/example"}
 }
 
 // Copy returns a deep copy of the Announcement.
@@ -267,6 +274,8 @@ func (a *Announcement) Equal(b *Announcement) bool {
 		return false
 	case !equalStringSlice(a.Exit, b.Exit):
 		return false
+	case !equalStringSlice(a.Capabilities, b.Capabilities):
+		return false
 	default:
 		return true
 	}
@@ -310,6 +319,9 @@ func (a *Announcement) validateFormatting() (err error) {
 	if err = checkStringSliceFormat("Exit", a.Exit, 255, 255); err != nil {
 		return err
 	}
+	if err = checkCapabilitiesFormat(a.Capabilities); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -3,6 +3,7 @@ package hub
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -69,3 +70,20 @@ func TestStringify(t *testing.T) {
 	assert.Equal(t, "<Hub Franz bcde-fghi>", (&Hub{ID: "abcdefghi", Info: &Announcement{Name: "Franz"}}).String())
 	assert.Equal(t, "<Hub AVeryLongAndProbablyAutoGenera bcde-fghi>", (&Hub{ID: "abcdefghi", Info: &Announcement{Name: "AVeryLongAndProbablyAutoGeneratedName"}}).String())
 }
+
+func TestCheckClockSkew(t *testing.T) {
+	h := &Hub{ID: "clock-skew-test", Info: &Announcement{}}
+
+	// Current and old timestamps are fine.
+	if h.checkClockSkew(&Status{Timestamp: time.Now().Unix()}) {
+		t.Error("current timestamp should not be flagged")
+	}
+	if h.checkClockSkew(&Status{Timestamp: time.Now().Add(-24 * time.Hour).Unix()}) {
+		t.Error("old timestamp should not be flagged")
+	}
+
+	// Timestamps too far in the future are flagged.
+	if !h.checkClockSkew(&Status{Timestamp: time.Now().Add(30 * time.Minute).Unix()}) {
+		t.Error("future timestamp should be flagged")
+	}
+}
@@ -1,12 +1,17 @@
 package hub
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/ghodss/yaml"
 	"github.com/safing/jess/lhash"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portmaster/intel"
 	"github.com/safing/portmaster/profile/endpoints"
 )
 
@@ -41,6 +46,10 @@ type Intel struct {
 	// Regions defines regions to assist network optimization.
 	Regions []*RegionConfig
 
+	// HubLanes holds lane limits for individual Hubs by Hub ID, which are
+	// applied on top of the regional lane parameters.
+	HubLanes map[string]*HubLaneConfig
+
 	// VirtualNetworks holds network configurations for virtual cloud networks.
 	VirtualNetworks []*VirtualNetworkConfig
 
@@ -83,6 +92,15 @@ type RegionConfig struct {
 	InternalMaxHops int
 }
 
+// HubLaneConfig holds the lane limits of a Hub.
+type HubLaneConfig struct {
+	// MinLanes specifies how many lanes the Hub should build at minimum.
+	MinLanes int
+	// MaxLanes specifies how many lanes the Hub should build at maximum.
+	// Zero means no limit.
+	MaxLanes int
+}
+
 // VirtualNetworkConfig holds configuration of a virtual network that binds multiple Hubs together.
 type VirtualNetworkConfig struct {
 	// Name is a human readable name of the virtual network.
@@ -103,6 +121,83 @@ type ParsedIntel struct {
 
 	// DestinationHubAdvisory is only taken into account when selecting a Destination Hub.
 	DestinationHubAdvisory endpoints.Endpoints
+
+	// matchCache caches advisory match results by list and IP. As the cache
+	// belongs to the parsed intel, it is implicitly invalidated when new intel
+	// is parsed.
+	matchCache     map[advisoryMatchKey]endpoints.EPResult
+	matchCacheLock sync.RWMutex
+}
+
+// AdvisoryList identifies one of the advisory lists of the parsed intel.
+type AdvisoryList uint8
+
+// Advisory Lists.
+const (
+	HubAdvisoryList AdvisoryList = iota + 1
+	HomeHubAdvisoryList
+	DestinationHubAdvisoryList
+)
+
+// MaxAdvisoryMatchCacheSize defines how many match results are cached at most
+// per parsed intel. The cache is reset when it is full.
+var MaxAdvisoryMatchCacheSize = 10000
+
+type advisoryMatchKey struct {
+	list AdvisoryList
+	ip   string
+}
+
+// getAdvisoryList returns the endpoint list of the given advisory list.
+func (pi *ParsedIntel) getAdvisoryList(list AdvisoryList) endpoints.Endpoints {
+	switch list {
+	case HubAdvisoryList:
+		return pi.HubAdvisory
+	case HomeHubAdvisoryList:
+		return pi.HomeHubAdvisory
+	case DestinationHubAdvisoryList:
+		return pi.DestinationHubAdvisory
+	default:
+		return nil
+	}
+}
+
+// MatchAdvisory matches the entity against the given advisory list. Results
+// are cached by the IP of the entity, as the entities of Hubs only change with
+// new intel data, which also resets the cache.
+func (pi *ParsedIntel) MatchAdvisory(list AdvisoryList, entity *intel.Entity) endpoints.EPResult {
+	endpointList := pi.getAdvisoryList(list)
+
+	// Do not cache entities without an IP.
+	if entity == nil || entity.IP == nil {
+		result, _ := endpointList.Match(context.TODO(), entity)
+		return result
+	}
+	key := advisoryMatchKey{
+		list: list,
+		ip:   entity.IP.String(),
+	}
+
+	// Check the cache.
+	pi.matchCacheLock.RLock()
+	result, ok := pi.matchCache[key]
+	pi.matchCacheLock.RUnlock()
+	if ok {
+		return result
+	}
+
+	// Match and save to cache.
+	result, _ = endpointList.Match(context.TODO(), entity)
+
+	pi.matchCacheLock.Lock()
+	defer pi.matchCacheLock.Unlock()
+
+	if pi.matchCache == nil || len(pi.matchCache) >= MaxAdvisoryMatchCacheSize {
+		pi.matchCache = make(map[advisoryMatchKey]endpoints.EPResult)
+	}
+	pi.matchCache[key] = result
+
+	return result
 }
 
 // Parsed returns the collection of parsed intel data.
@@ -110,24 +205,66 @@ func (i *Intel) Parsed() *ParsedIntel {
 	return i.parsed
 }
 
-// ParseIntel parses Hub intelligence data.
+// compactIntelPrefix marks intel data in the compact distribution format.
+// YAML never starts with a null byte, so the formats cannot be confused.
+var compactIntelPrefix = []byte("\x00SPNI")
+
+// ParseIntel parses Hub intelligence data. The data may either be YAML, which
+// is the human-authored format, or the compact format used for distribution.
 func ParseIntel(data []byte) (*Intel, error) {
 	// Load data into struct.
 	intel := &Intel{}
-	err := yaml.Unmarshal(data, intel)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse data: %w", err)
+	if bytes.HasPrefix(data, compactIntelPrefix) {
+		_, err := dsd.Load(data[len(compactIntelPrefix):], intel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse compact data: %w", err)
+		}
+	} else {
+		err := yaml.Unmarshal(data, intel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse data: %w", err)
+		}
 	}
 
 	// Parse all endpoint lists.
-	err = intel.ParseAdvisories()
+	err := intel.ParseAdvisories()
 	if err != nil {
 		return nil, err
 	}
 
+	// Check Hub lane limits.
+	for hubID, laneConfig := range intel.HubLanes {
+		if err := laneConfig.check(); err != nil {
+			return nil, fmt.Errorf("invalid lane config for hub %s: %w", hubID, err)
+		}
+	}
+
 	return intel, nil
 }
 
+// ConvertIntelToCompact converts YAML intel data to the compact format used
+// for distribution. The intel is fully parsed first, so that invalid intel is
+// never distributed.
+func ConvertIntelToCompact(yamlData []byte) ([]byte, error) {
+	intel, err := ParseIntel(yamlData)
+	if err != nil {
+		return nil, err
+	}
+
+	return intel.ExportCompact()
+}
+
+// ExportCompact exports the intel in the compact format used for
+// distribution, which is CBOR compressed with GZIP.
+func (i *Intel) ExportCompact() ([]byte, error) {
+	data, err := dsd.DumpAndCompress(i, dsd.CBOR, dsd.GZIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack intel: %w", err)
+	}
+
+	return append(append([]byte{}, compactIntelPrefix...), data...), nil
+}
+
 // ParseAdvisories parses all advisory endpoint lists.
 func (i *Intel) ParseAdvisories() (err error) {
 	i.parsed = &ParsedIntel{}
@@ -194,3 +331,19 @@ func ParseBootstrapHub(bootstrapTransport string, mapName string) (*Hub, error)
 
 	return bootstrapHub, nil
 }
+
+// check checks if the lane config is valid.
+func (hlc *HubLaneConfig) check() error {
+	switch {
+	case hlc == nil:
+		return errors.New("missing config")
+	case hlc.MinLanes < 0:
+		return fmt.Errorf("negative min lanes %d", hlc.MinLanes)
+	case hlc.MaxLanes < 0:
+		return fmt.Errorf("negative max lanes %d", hlc.MaxLanes)
+	case hlc.MaxLanes > 0 && hlc.MinLanes > hlc.MaxLanes:
+		return fmt.Errorf("min lanes %d exceed max lanes %d", hlc.MinLanes, hlc.MaxLanes)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,187 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// makeTestAdvisory creates an advisory list with the given amount of IP range
+// entries.
+func makeTestAdvisory(entries int) []string {
+	advisory := make([]string, 0, entries)
+	for i := 0; i < entries; i++ {
+		advisory = append(advisory, fmt.Sprintf("- 10.%d.%d.0/24", (i/256)%256, i%256))
+	}
+	return advisory
+}
+
+// makeTestEntities creates entities with IPs that partly match the test
+// advisory list.
+func makeTestEntities(amount int) []*intel.Entity {
+	entities := make([]*intel.Entity, 0, amount)
+	for i := 0; i < amount; i++ {
+		var ip net.IP
+		if i%2 == 0 {
+			ip = net.IPv4(10, 0, byte(i%256), 1)
+		} else {
+			ip = net.IPv4(192, 168, byte(i%256), 1)
+		}
+		entities = append(entities, &intel.Entity{IP: ip})
+	}
+	return entities
+}
+
+func TestMatchAdvisory(t *testing.T) {
+	t.Parallel()
+
+	i := &Intel{
+		HubAdvisory: []string{"- 10.0.0.0/24"},
+	}
+	if err := i.ParseAdvisories(); err != nil {
+		t.Fatal(err)
+	}
+	pi := i.Parsed()
+
+	denied := &intel.Entity{IP: net.IPv4(10, 0, 0, 1)}
+	other := &intel.Entity{IP: net.IPv4(10, 0, 1, 1)}
+
+	// Check twice to also hit the cache.
+	for round := 0; round < 2; round++ {
+		if r := pi.MatchAdvisory(HubAdvisoryList, denied); r != endpoints.Denied {
+			t.Errorf("round %d: expected denied, got %s", round, r)
+		}
+		if r := pi.MatchAdvisory(HubAdvisoryList, other); r == endpoints.Denied {
+			t.Errorf("round %d: expected no deny, got %s", round, r)
+		}
+		if r := pi.MatchAdvisory(HomeHubAdvisoryList, denied); r == endpoints.Denied {
+			t.Errorf("round %d: expected no deny on empty list, got %s", round, r)
+		}
+	}
+
+	// Re-parsing must reset the cache.
+	i.HubAdvisory = nil
+	if err := i.ParseAdvisories(); err != nil {
+		t.Fatal(err)
+	}
+	if r := i.Parsed().MatchAdvisory(HubAdvisoryList, denied); r == endpoints.Denied {
+		t.Errorf("expected no deny after re-parsing, got %s", r)
+	}
+}
+
+func BenchmarkMatchAdvisory(b *testing.B) {
+	i := &Intel{
+		HubAdvisory: makeTestAdvisory(1000),
+	}
+	if err := i.ParseAdvisories(); err != nil {
+		b.Fatal(err)
+	}
+	pi := i.Parsed()
+	entities := makeTestEntities(100)
+
+	b.Run("uncached", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			_, _ = pi.HubAdvisory.Match(context.TODO(), entities[n%len(entities)])
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			_ = pi.MatchAdvisory(HubAdvisoryList, entities[n%len(entities)])
+		}
+	})
+}
+
+func TestCompactIntel(t *testing.T) {
+	t.Parallel()
+
+	// Create YAML intel with a large advisory.
+	yamlIntel := `BootstrapHubs:
+  - tcp:10.0.0.1:17#Zwu5LnTzKRk5X7ywjLuGTYd6KnDB6CH7hDY9SHmCLdZmAn
+TrustedHubs:
+  - Zwu5LnTzKRk5X7ywjLuGTYd6KnDB6CH7hDY9SHmCLdZmAn
+AdviseOnlyTrustedHomeHubs: true
+VirtualNetworks:
+  - Name: test
+    Mapping:
+      Zwu5LnTzKRk5X7ywjLuGTYd6KnDB6CH7hDY9SHmCLdZmAn: 192.168.0.1
+HubAdvisory:
+`
+	var advisory strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&advisory, "  - \"- 10.%d.%d.0/24\"\n", (i/256)%256, i%256)
+	}
+	yamlData := []byte(yamlIntel + advisory.String())
+
+	// Convert to compact format.
+	compactData, err := ConvertIntelToCompact(yamlData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compactData) >= len(yamlData) {
+		t.Errorf("compact intel (%d bytes) is not smaller than yaml intel (%d bytes)", len(compactData), len(yamlData))
+	}
+
+	// Both formats must result in the same intel.
+	fromYAML, err := ParseIntel(yamlData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromCompact, err := ParseIntel(compactData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case len(fromCompact.BootstrapHubs) != 1 || fromCompact.BootstrapHubs[0] != fromYAML.BootstrapHubs[0]:
+		t.Error("bootstrap hubs do not match")
+	case !fromCompact.AdviseOnlyTrustedHomeHubs:
+		t.Error("advice does not match")
+	case len(fromCompact.HubAdvisory) != len(fromYAML.HubAdvisory):
+		t.Error("hub advisory does not match")
+	case len(fromCompact.Parsed().HubAdvisory) != len(fromYAML.Parsed().HubAdvisory):
+		t.Error("parsed hub advisory does not match")
+	case len(fromCompact.VirtualNetworks) != 1 ||
+		!fromCompact.VirtualNetworks[0].Mapping["Zwu5LnTzKRk5X7ywjLuGTYd6KnDB6CH7hDY9SHmCLdZmAn"].Equal(net.IPv4(192, 168, 0, 1)):
+		t.Error("virtual networks do not match")
+	}
+
+	// Invalid intel must not be converted.
+	if _, err := ConvertIntelToCompact([]byte("HubAdvisory:\n  - invalid entry\n")); err == nil {
+		t.Error("invalid intel should fail to convert")
+	}
+}
+
+func TestIntelHubLanes(t *testing.T) {
+	t.Parallel()
+
+	// Valid lane limits are parsed.
+	parsed, err := ParseIntel([]byte(`HubLanes:
+  Zwu5LnTzKRk5X7ywjLuGTYd6KnDB6CH7hDY9SHmCLdZmAn:
+    MinLanes: 2
+    MaxLanes: 5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	laneConfig := parsed.HubLanes["Zwu5LnTzKRk5X7ywjLuGTYd6KnDB6CH7hDY9SHmCLdZmAn"]
+	if laneConfig == nil || laneConfig.MinLanes != 2 || laneConfig.MaxLanes != 5 {
+		t.Fatalf("unexpected lane config: %+v", laneConfig)
+	}
+
+	// Invalid lane limits are rejected.
+	for _, invalid := range []string{
+		"MinLanes: 6\n    MaxLanes: 5",
+		"MinLanes: -1",
+		"MaxLanes: -1",
+	} {
+		_, err := ParseIntel([]byte("HubLanes:\n  Zwu5LnTzKRk5X7ywjLuGTYd6KnDB6CH7hDY9SHmCLdZmAn:\n    " + invalid + "\n"))
+		if err == nil {
+			t.Errorf("lane config %q should be rejected", invalid)
+		}
+	}
+}
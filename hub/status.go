@@ -62,28 +62,50 @@ func (s *Status) Copy() (*Status, error) {
 
 // SelectSignet selects the public key to use for initiating connections to that Hub.
 func (h *Hub) SelectSignet() *jess.Signet {
+	signets := h.SelectSignets()
+	if len(signets) == 0 {
+		return nil
+	}
+	return signets[0]
+}
+
+// SelectSignets returns all public keys that may be used for initiating
+// connections to that Hub, ordered by preference.
+func (h *Hub) SelectSignets() []*jess.Signet {
 	h.Lock()
 	defer h.Unlock()
 
-	// Return no Signet if we don't have a Status.
+	// Return no Signets if we don't have a Status.
 	if h.Status == nil {
 		return nil
 	}
 
+	// Collect all keys that have not yet expired.
 	// TODO: select key based on preferred alg?
 	now := time.Now().Unix()
+	signets := make([]*jess.Signet, 0, len(h.Status.Keys))
+	expires := make(map[string]int64, len(h.Status.Keys))
 	for id, key := range h.Status.Keys {
 		if now < key.Expires {
-			return &jess.Signet{
+			signets = append(signets, &jess.Signet{
 				ID:     id,
 				Scheme: key.Scheme,
 				Key:    key.Key,
 				Public: true,
-			}
+			})
+			expires[id] = key.Expires
 		}
 	}
 
-	return nil
+	// Prefer keys that are valid for longer.
+	sort.Slice(signets, func(i, j int) bool {
+		if expires[signets[i].ID] != expires[signets[j].ID] {
+			return expires[signets[i].ID] > expires[signets[j].ID]
+		}
+		return signets[i].ID < signets[j].ID
+	})
+
+	return signets
 }
 
 // GetSignet returns the public key identified by the given ID from the Hub Status.
@@ -0,0 +1,112 @@
+package hub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TransportPolicy defines which transport protocols may be used to connect to
+// Hubs. A nil policy permits all transports.
+type TransportPolicy struct {
+	// Allowed holds the permitted protocols. If empty, all protocols that are
+	// not blocked are permitted.
+	Allowed []string
+	// Blocked holds the protocols that are never used.
+	Blocked []string
+}
+
+// NewTransportPolicy returns a new transport policy with the given allowed
+// and blocked protocols. If both are empty, nil is returned.
+func NewTransportPolicy(allowed, blocked []string) (*TransportPolicy, error) {
+	if len(allowed) == 0 && len(blocked) == 0 {
+		return nil, nil
+	}
+
+	tp := &TransportPolicy{
+		Allowed: make([]string, 0, len(allowed)),
+		Blocked: make([]string, 0, len(blocked)),
+	}
+	for _, protocol := range allowed {
+		protocol = strings.ToLower(strings.TrimSpace(protocol))
+		if protocol == "" {
+			return nil, fmt.Errorf("empty protocol in allowed transports")
+		}
+		tp.Allowed = append(tp.Allowed, protocol)
+	}
+	for _, protocol := range blocked {
+		protocol = strings.ToLower(strings.TrimSpace(protocol))
+		if protocol == "" {
+			return nil, fmt.Errorf("empty protocol in blocked transports")
+		}
+		tp.Blocked = append(tp.Blocked, protocol)
+	}
+
+	return tp, nil
+}
+
+// Permits returns whether the given transport may be used.
+func (tp *TransportPolicy) Permits(t *Transport) bool {
+	if tp == nil {
+		return true
+	}
+
+	protocol := strings.ToLower(t.Protocol)
+	for _, blocked := range tp.Blocked {
+		if protocol == blocked {
+			return false
+		}
+	}
+	if len(tp.Allowed) == 0 {
+		return true
+	}
+	for _, allowed := range tp.Allowed {
+		if protocol == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// PermittedTransports parses the given transport definitions and returns the
+// ones that may be used. Invalid definitions are ignored.
+func (tp *TransportPolicy) PermittedTransports(definitions []string) []*Transport {
+	permitted := make([]*Transport, 0, len(definitions))
+	for _, definition := range definitions {
+		t, err := ParseTransport(definition)
+		if err != nil {
+			continue
+		}
+		if tp.Permits(t) {
+			permitted = append(permitted, t)
+		}
+	}
+	return permitted
+}
+
+// String returns a human-readable representation of the policy.
+func (tp *TransportPolicy) String() string {
+	if tp == nil {
+		return "all transports"
+	}
+
+	s := make([]string, 0, 2)
+	if len(tp.Allowed) > 0 {
+		s = append(s, "allowed: "+strings.Join(tp.Allowed, ", "))
+	}
+	if len(tp.Blocked) > 0 {
+		s = append(s, "blocked: "+strings.Join(tp.Blocked, ", "))
+	}
+	return strings.Join(s, "; ")
+}
+
+// HasPermittedTransport returns whether the Hub announced at least one valid
+// transport that is permitted by the given policy.
+func (a *Announcement) HasPermittedTransport(tp *TransportPolicy) bool {
+	if a == nil {
+		return false
+	}
+	if tp == nil {
+		return true
+	}
+	return len(tp.PermittedTransports(a.Transports)) > 0
+}
@@ -144,3 +144,36 @@ func TestTransportParsing(t *testing.T) {
 	assert.NotEqual(t, parseTError("spn:17/example?query#fragment"), nil, "should fail")
 
 }
+
+func TestTransportPolicy(t *testing.T) {
+	definitions := []string{"tcp:17", "kcp:17", "http:80", "invalid"}
+
+	// Nil policies permit everything.
+	var tp *TransportPolicy
+	assert.Len(t, tp.PermittedTransports(definitions), 3, "nil policy should permit all valid transports")
+	tp, err := NewTransportPolicy(nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, tp, "empty policy should be nil")
+
+	// Blocked protocols are skipped.
+	tp, err = NewTransportPolicy(nil, []string{"TCP"})
+	assert.NoError(t, err)
+	assert.False(t, tp.Permits(parseT(t, "tcp:17")), "tcp should be blocked")
+	assert.Len(t, tp.PermittedTransports(definitions), 2, "should permit all but tcp")
+
+	// Only allowed protocols are used, unless blocked.
+	tp, err = NewTransportPolicy([]string{"http", "kcp"}, []string{"kcp"})
+	assert.NoError(t, err)
+	permitted := tp.PermittedTransports(definitions)
+	if assert.Len(t, permitted, 1) {
+		assert.Equal(t, "http", permitted[0].Protocol)
+	}
+
+	// Announcements need at least one permitted transport.
+	assert.True(t, (&Announcement{Transports: []string{"tcp:17", "http:80"}}).HasPermittedTransport(tp))
+	assert.False(t, (&Announcement{Transports: []string{"tcp:17"}}).HasPermittedTransport(tp))
+
+	// Empty protocols are rejected.
+	_, err = NewTransportPolicy([]string{" "}, nil)
+	assert.Error(t, err)
+}
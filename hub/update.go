@@ -24,8 +24,20 @@ var (
 	// SenderAuthentication provides pre-decryption integrity. That is all we need.
 
 	clockSkewTolerance = 1 * time.Hour
+
+	// clockSkewWarningThreshold returns the amount of seconds a status
+	// timestamp may be ahead of the local time before the Hub is flagged for
+	// having a skewed clock.
+	clockSkewWarningThreshold = func() int64 { return 300 }
 )
 
+// SetClockSkewWarningThreshold sets the function that returns the amount of
+// seconds a status timestamp may be ahead of the local time before the Hub is
+// flagged for having a skewed clock.
+func SetClockSkewWarningThreshold(threshold func() int64) {
+	clockSkewWarningThreshold = threshold
+}
+
 // SignHubMsg signs the given serialized hub msg with the given configuration.
 func SignHubMsg(msg []byte, env *jess.Envelope, enableTofu bool) ([]byte, error) {
 	// start session from envelope
@@ -70,29 +82,54 @@ func SignHubMsg(msg []byte, env *jess.Envelope, enableTofu bool) ([]byte, error)
 	return data, nil
 }
 
-// OpenHubMsg opens a signed hub msg and verifies the signature using the
-// provided hub or the local database. If TOFU is enabled, the signature is
-// always accepted, if valid.
-func OpenHubMsg(hub *Hub, data []byte, mapName string, tofu bool) (msg []byte, sendingHub *Hub, known bool, err error) {
+// GetHubMsgSignerID returns the ID of the Hub that signed the given hub msg.
+// The signature is not verified, so the ID may only be used to reject a msg
+// early, eg. if the Hub is blocked.
+func GetHubMsgSignerID(data []byte) (string, error) {
 	letter, err := jess.LetterFromDSD(data)
 	if err != nil {
-		return nil, nil, false, fmt.Errorf("malformed letter: %s", err)
+		return "", fmt.Errorf("malformed letter: %s", err)
 	}
 
-	// check signatures
+	seal, err := getHubMsgSeal(letter)
+	if err != nil {
+		return "", err
+	}
+	return seal.ID, nil
+}
+
+// getHubMsgSeal returns the single signature of a hub msg.
+func getHubMsgSeal(letter *jess.Letter) (*jess.Seal, error) {
 	var seal *jess.Seal
 	switch len(letter.Signatures) {
 	case 0:
-		return nil, nil, false, errors.New("missing signature")
+		return nil, errors.New("missing signature")
 	case 1:
 		seal = letter.Signatures[0]
 	default:
-		return nil, nil, false, fmt.Errorf("too many signatures (%d)", len(letter.Signatures))
+		return nil, fmt.Errorf("too many signatures (%d)", len(letter.Signatures))
 	}
 
 	// check signature signer ID
 	if seal.ID == "" {
-		return nil, nil, false, errors.New("signature is missing signer ID")
+		return nil, errors.New("signature is missing signer ID")
+	}
+	return seal, nil
+}
+
+// OpenHubMsg opens a signed hub msg and verifies the signature using the
+// provided hub or the local database. If TOFU is enabled, the signature is
+// always accepted, if valid.
+func OpenHubMsg(hub *Hub, data []byte, mapName string, tofu bool) (msg []byte, sendingHub *Hub, known bool, err error) {
+	letter, err := jess.LetterFromDSD(data)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("malformed letter: %s", err)
+	}
+
+	// check signatures
+	seal, err := getHubMsgSeal(letter)
+	if err != nil {
+		return nil, nil, false, err
 	}
 
 	// get hub for public key
@@ -457,9 +494,36 @@ func ApplyStatus(existingHub *Hub, data []byte, mapName string, scope Scope, sel
 		hub.Status = status
 	}
 
+	// Check the clock of the Hub. This only flags the Hub and does not reject
+	// the status.
+	if !selfcheck {
+		hub.ClockSkewed = hub.checkClockSkew(status)
+	}
+
 	return
 }
 
+// checkClockSkew returns whether the timestamp of the status indicates that
+// the clock of the Hub is skewed.
+// The status timestamp is signed by the Hub and set when the status is issued.
+// As statuses are forwarded by other Hubs, an old timestamp does not indicate
+// a skewed clock. Only timestamps that are ahead of the local time are
+// regarded.
+func (hub *Hub) checkClockSkew(status *Status) bool {
+	skew := time.Until(time.Unix(status.Timestamp, 0))
+	threshold := time.Duration(clockSkewWarningThreshold()) * time.Second
+	if threshold <= 0 || skew <= threshold {
+		return false
+	}
+
+	log.Warningf(
+		"spn/hub: status of %s is %s ahead of local time, clock of Hub or local clock may be skewed",
+		hub.StringWithoutLocking(),
+		skew.Round(time.Second),
+	)
+	return true
+}
+
 func (hub *Hub) validateStatus(status *Status) error {
 	// value formatting
 	if err := status.validateFormatting(); err != nil {
@@ -61,6 +61,14 @@ func TestHubUpdate(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	signerID, err := GetHubMsgSignerID(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signerID != s1.ID {
+		t.Fatalf("unexpected signer ID %s, expected %s", signerID, s1.ID)
+	}
+
 	_, _, _, err = OpenHubMsg(nil, data, "test", true)
 	if err != nil {
 		t.Fatal(err)
@@ -58,6 +58,17 @@ func registerAPIEndpoints() error {
 		return err
 	}
 
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/map/{map:[A-Za-z0-9]{1,255}}/hubs`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleMapHubsRequest,
+		Name:        "Get SPN map hub summaries",
+		Description: "Returns a summary of every Hub on the map, including its status and whether there is an active crane to it.",
+	}); err != nil {
+		return err
+	}
+
 	if err := api.RegisterEndpoint(api.Endpoint{
 		Path:        `spn/map/{map:[A-Za-z0-9]{1,255}}/optimization`,
 		Read:        api.PermitUser,
@@ -148,6 +159,16 @@ func handleMapPinsRequest(ar *api.Request) (i interface{}, err error) {
 	return exportedPins, nil
 }
 
+func handleMapHubsRequest(ar *api.Request) (i interface{}, err error) {
+	// Get map.
+	m, ok := getMapForAPI(ar.URLVars["map"])
+	if !ok {
+		return nil, errors.New("map not found")
+	}
+
+	return m.ListHubs(), nil
+}
+
 func handleMapOptimizationRequest(ar *api.Request) (i interface{}, err error) {
 	// Get map.
 	m, ok := getMapForAPI(ar.URLVars["map"])
@@ -192,6 +213,7 @@ func handleMapOptimizationTableRequest(ar *api.Request) (data []byte, err error)
 	}
 	fmt.Fprintf(buf, "MaxConnect: %d\n", result.MaxConnect)
 	fmt.Fprintf(buf, "StopOthers: %v\n", result.StopOthers)
+	fmt.Fprintf(buf, "MaxLanes: %d\n", result.MaxLanes)
 
 	// Build table of suggested connections.
 	buf.WriteString("\nSuggested Connections:\n")
@@ -0,0 +1,238 @@
+package navigator
+
+import (
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+var (
+	// CfgOptionPinnedHubsKey is the config key for the list of pinned Hubs.
+	CfgOptionPinnedHubsKey     = "spn/pinnedHubs"
+	cfgOptionPinnedHubs        config.StringArrayOption
+	cfgOptionPinnedHubsDefault = []string{}
+	cfgOptionPinnedHubsOrder   = 146
+
+	// CfgOptionSelectionPolicyKey is the config key for the Hub selection policy.
+	CfgOptionSelectionPolicyKey     = "spn/selectionPolicy"
+	cfgOptionSelectionPolicy        config.StringOption
+	cfgOptionSelectionPolicyDefault = SelectionPolicyDeterministic
+	cfgOptionSelectionPolicyOrder   = 148
+
+	// CfgOptionPreferredRegionsKey is the config key for the preferred regions.
+	CfgOptionPreferredRegionsKey     = "spn/preferredRegions"
+	cfgOptionPreferredRegions        config.StringArrayOption
+	cfgOptionPreferredRegionsDefault = []string{}
+	cfgOptionPreferredRegionsOrder   = 153
+
+	// CfgOptionAllowedTransportsKey is the config key for the allowed transport protocols.
+	CfgOptionAllowedTransportsKey     = "spn/allowedTransports"
+	cfgOptionAllowedTransports        config.StringArrayOption
+	cfgOptionAllowedTransportsDefault = []string{}
+	cfgOptionAllowedTransportsOrder   = 155
+
+	// CfgOptionBlockedTransportsKey is the config key for the blocked transport protocols.
+	CfgOptionBlockedTransportsKey     = "spn/blockedTransports"
+	cfgOptionBlockedTransports        config.StringArrayOption
+	cfgOptionBlockedTransportsDefault = []string{}
+	cfgOptionBlockedTransportsOrder   = 156
+
+	// CfgOptionOfflineGracePeriodKey is the config key for the grace period
+	// before a failing Hub is regarded as offline.
+	CfgOptionOfflineGracePeriodKey     = "spn/offlineGracePeriod"
+	cfgOptionOfflineGracePeriod        config.IntOption
+	cfgOptionOfflineGracePeriodDefault = 60
+	cfgOptionOfflineGracePeriodOrder   = 157
+
+	// CfgOptionHubFailingDurationKey is the config key for how long a Hub is
+	// disregarded after failing.
+	CfgOptionHubFailingDurationKey     = "spn/hubFailingDuration"
+	cfgOptionHubFailingDuration        config.IntOption
+	cfgOptionHubFailingDurationDefault = 600
+	cfgOptionHubFailingDurationOrder   = 160
+)
+
+func prepConfig() error {
+	err := config.Register(&config.Option{
+		Name:           "Pinned Hubs",
+		Key:            CfgOptionPinnedHubsKey,
+		Description:    "List of Hub IDs that should always be part of the route, if possible. If no route can be found through all pinned Hubs, routing falls back to ignoring them. Pinning only affects routing and does not make a Hub trusted.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   cfgOptionPinnedHubsDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPinnedHubsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionPinnedHubs = config.Concurrent.GetAsStringArray(CfgOptionPinnedHubsKey, cfgOptionPinnedHubsDefault)
+
+	err = config.Register(&config.Option{
+		Name:            "Hub Selection Policy",
+		Key:             CfgOptionSelectionPolicyKey,
+		Description:     "Defines how to select among comparable Hubs. \"deterministic\" always selects the best Hub, while \"weighted-random\" selects randomly among comparable Hubs weighted by their available capacity in order to spread load.",
+		OptType:         config.OptTypeString,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		DefaultValue:    cfgOptionSelectionPolicyDefault,
+		ValidationRegex: "^(" + SelectionPolicyDeterministic + "|" + SelectionPolicyWeightedRandom + ")$",
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionSelectionPolicyOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionSelectionPolicy = config.Concurrent.GetAsString(CfgOptionSelectionPolicyKey, cfgOptionSelectionPolicyDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Preferred Regions",
+		Key:            CfgOptionPreferredRegionsKey,
+		Description:    "List of region IDs in order of preference. Home and exit Hubs are selected from the first preferred region that has a suitable Hub. If none of the preferred regions has a suitable Hub, Hubs of all regions are used.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   cfgOptionPreferredRegionsDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPreferredRegionsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionPreferredRegions = config.Concurrent.GetAsStringArray(CfgOptionPreferredRegionsKey, cfgOptionPreferredRegionsDefault)
+
+	err = config.Register(&config.Option{
+		Name:            "Allowed Transports",
+		Key:             CfgOptionAllowedTransportsKey,
+		Description:     "List of transport protocols, such as \"tcp\" or \"kcp\", that may be used to connect to the Home Hub. If empty, all protocols that are not blocked may be used. Home Hubs that cannot be reached with an allowed protocol are not used.",
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		DefaultValue:    cfgOptionAllowedTransportsDefault,
+		ValidationRegex: "^[a-z0-9-]+$",
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionAllowedTransportsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionAllowedTransports = config.Concurrent.GetAsStringArray(CfgOptionAllowedTransportsKey, cfgOptionAllowedTransportsDefault)
+
+	err = config.Register(&config.Option{
+		Name:            "Blocked Transports",
+		Key:             CfgOptionBlockedTransportsKey,
+		Description:     "List of transport protocols, such as \"tcp\" or \"kcp\", that are never used to connect to the Home Hub. Home Hubs that can only be reached with blocked protocols are not used.",
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		DefaultValue:    cfgOptionBlockedTransportsDefault,
+		ValidationRegex: "^[a-z0-9-]+$",
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionBlockedTransportsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionBlockedTransports = config.Concurrent.GetAsStringArray(CfgOptionBlockedTransportsKey, cfgOptionBlockedTransportsDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Offline Grace Period",
+		Key:            CfgOptionOfflineGracePeriodKey,
+		Description:    "Time in seconds after a failed connection to a Hub during which the Hub is only deprioritized instead of being disregarded, giving reconnecting a chance. This reduces route changes caused by brief network issues. Set to 0 to disregard failing Hubs immediately.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   cfgOptionOfflineGracePeriodDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionOfflineGracePeriodOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionOfflineGracePeriod = config.Concurrent.GetAsInt(CfgOptionOfflineGracePeriodKey, int64(cfgOptionOfflineGracePeriodDefault))
+
+	err = config.Register(&config.Option{
+		Name:           "Hub Failing Duration",
+		Key:            CfgOptionHubFailingDurationKey,
+		Description:    "Time in seconds during which a Hub is disregarded after it failed again after the offline grace period.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   cfgOptionHubFailingDurationDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionHubFailingDurationOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionHubFailingDuration = config.Concurrent.GetAsInt(CfgOptionHubFailingDurationKey, int64(cfgOptionHubFailingDurationDefault))
+
+	return nil
+}
+
+// configuredSelectionPolicy returns the currently configured selection policy.
+func configuredSelectionPolicy() string {
+	if cfgOptionSelectionPolicy == nil {
+		return SelectionPolicyDeterministic
+	}
+	return cfgOptionSelectionPolicy()
+}
+
+// configuredPinnedHubs returns the currently configured pinned Hubs.
+func configuredPinnedHubs() []string {
+	if cfgOptionPinnedHubs == nil {
+		return nil
+	}
+	return cfgOptionPinnedHubs()
+}
+
+// configuredPreferredRegions returns the currently configured preferred regions.
+func configuredPreferredRegions() []string {
+	if cfgOptionPreferredRegions == nil {
+		return nil
+	}
+	return cfgOptionPreferredRegions()
+}
+
+// configuredOfflineGracePeriod returns the currently configured offline grace
+// period.
+func configuredOfflineGracePeriod() time.Duration {
+	if cfgOptionOfflineGracePeriod == nil {
+		return time.Duration(cfgOptionOfflineGracePeriodDefault) * time.Second
+	}
+	return time.Duration(cfgOptionOfflineGracePeriod()) * time.Second
+}
+
+// configuredHubFailingDuration returns the currently configured duration
+// during which failing Hubs are disregarded.
+func configuredHubFailingDuration() time.Duration {
+	if cfgOptionHubFailingDuration == nil {
+		return time.Duration(cfgOptionHubFailingDurationDefault) * time.Second
+	}
+	return time.Duration(cfgOptionHubFailingDuration()) * time.Second
+}
+
+// ConfiguredTransportPolicy returns the transport policy defined by the
+// configured allowed and blocked transports, or nil if none are configured.
+func ConfiguredTransportPolicy() *hub.TransportPolicy {
+	if cfgOptionAllowedTransports == nil || cfgOptionBlockedTransports == nil {
+		return nil
+	}
+
+	policy, err := hub.NewTransportPolicy(cfgOptionAllowedTransports(), cfgOptionBlockedTransports())
+	if err != nil {
+		log.Warningf("spn/navigator: invalid transport policy config: %s", err)
+		return nil
+	}
+	return policy
+}
@@ -131,6 +131,7 @@ func withdrawMapDatabase() error {
 
 func (m *Map) PushPinChanges() {
 	module.StartWorker("push pin changes", m.pushPinChangesWorker)
+	m.triggerReachabilityCheck()
 }
 
 func (m *Map) pushPinChangesWorker(ctx context.Context) error {
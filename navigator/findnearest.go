@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/safing/portbase/log"
 	"github.com/safing/portmaster/intel/geoip"
 	"github.com/safing/spn/hub"
 )
@@ -17,6 +18,13 @@ type nearbyPins struct {
 	maxPins      int
 	minProximity float32
 	cutOffLimit  float32
+
+	// preferredRegion is the ID of the preferred region the Pins were
+	// restricted to, if any.
+	preferredRegion string
+	// regionFallback is set if regions were preferred, but none of them had
+	// any matching Pins.
+	regionFallback bool
 }
 
 // nearbyPin represents a Pin and the proximity to a certain location.
@@ -118,11 +126,17 @@ func (m *Map) FindNearestHubs(locationV4, locationV6 *geoip.Location, opts *Opti
 	}
 
 	// Find nearest Pins.
-	nearby, err := m.findNearestPins(locationV4, locationV6, opts.Matcher(matchFor), maxMatches)
+	nearby, err := m.findPreferredNearestPins(locationV4, locationV6, opts, matchFor, maxMatches)
 	if err != nil {
 		return nil, err
 	}
 
+	// Order according to the selection policy.
+	explanation := nearby.applySelectionPolicy(opts.SelectionPolicy)
+	if opts.SelectionPolicy == SelectionPolicyWeightedRandom {
+		log.Tracef("spn/navigator: selected nearest hubs with %s", explanation)
+	}
+
 	// Convert to Hub list and return.
 	hubs := make([]*hub.Hub, 0, len(nearby.pins))
 	for _, nbPin := range nearby.pins {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/safing/portbase/log"
 	"github.com/safing/portmaster/intel/geoip"
 )
 
@@ -54,7 +55,7 @@ func (m *Map) FindRoutes(ip net.IP, opts *Options, maxRoutes int) (*Routes, erro
 	}
 
 	// Find nearest Pins.
-	nearby, err := m.findNearestPins(locationV4, locationV6, opts.Matcher(DestinationHub), maxRoutes)
+	nearby, err := m.findPreferredNearestPins(locationV4, locationV6, opts, DestinationHub, maxRoutes)
 	if err != nil {
 		return nil, err
 	}
@@ -67,6 +68,54 @@ func (m *Map) findRoutes(dsts *nearbyPins, opts *Options, maxRoutes int) (*Route
 		return nil, ErrHomeHubUnset
 	}
 
+	// Try to find routes through the pinned Hubs first.
+	if len(opts.PinnedHubs) > 0 {
+		err := m.checkPinnedHubs(opts)
+		if err == nil {
+			routes := m.exploreRoutes(dsts, opts, maxRoutes, opts.PinnedHubs)
+			if len(routes.All) > 0 {
+				routes.makeExportReady(opts.RoutingProfile)
+				return routes, nil
+			}
+			err = errors.New("no route satisfies all pinned hubs")
+		}
+		log.Warningf("spn/navigator: ignoring pinned hubs %v on map %s: %s", opts.PinnedHubs, m.Name, err)
+	}
+
+	// Find routes without any required Hubs.
+	routes := m.exploreRoutes(dsts, opts, maxRoutes, nil)
+
+	// Check if we found anything.
+	if len(routes.All) == 0 {
+		return nil, errors.New("failed to find any routes")
+	}
+
+	routes.makeExportReady(opts.RoutingProfile)
+	return routes, nil
+}
+
+// checkPinnedHubs checks if all pinned Hubs of the given options are on the
+// map and may be used as a Transit Hub.
+func (m *Map) checkPinnedHubs(opts *Options) error {
+	transitMatcher := opts.Matcher(TransitHub)
+	for _, hubID := range opts.PinnedHubs {
+		pin, ok := m.all[hubID]
+		switch {
+		case !ok:
+			return fmt.Errorf("pinned hub %s is not on the map", hubID)
+		case pin == m.home:
+			// The Home Hub is always part of the route.
+		case !transitMatcher(pin):
+			return fmt.Errorf("pinned hub %s is unusable with states %s", pin, pin.State)
+		}
+	}
+	return nil
+}
+
+// exploreRoutes explores all routes from the Home Hub to the given
+// destinations. If requiredHubs is set, only routes that include all of these
+// Hubs are accepted.
+func (m *Map) exploreRoutes(dsts *nearbyPins, opts *Options, maxRoutes int, requiredHubs []string) *Routes {
 	// Initialize matchers.
 	var done bool
 	transitMatcher := opts.Matcher(TransitHub)
@@ -127,8 +176,9 @@ func (m *Map) findRoutes(dsts *nearbyPins, opts *Options, maxRoutes int) (*Route
 		switch routingProfile.checkRouteCompliance(route, routes) {
 		case routeOk:
 			// Route would be compliant.
-			// Now, check if the last hop qualifies as a Destination Hub.
-			if destinationMatcher(lane.Pin) {
+			// Now, check if the last hop qualifies as a Destination Hub and if the
+			// route includes all required Hubs.
+			if destinationMatcher(lane.Pin) && route.includesHubs(requiredHubs) {
 				// Get Pin as nearby Pin.
 				nbPin := dsts.get(lane.Pin.Hub.ID)
 				if nbPin != nil {
@@ -157,11 +207,5 @@ func (m *Map) findRoutes(dsts *nearbyPins, opts *Options, maxRoutes int) (*Route
 	// routes to the list.
 	exploreLanes(route)
 
-	// Check if we found anything.
-	if len(routes.All) == 0 {
-		return nil, errors.New("failed to find any routes")
-	}
-
-	routes.makeExportReady(opts.RoutingProfile)
-	return routes, nil
+	return routes
 }
@@ -27,6 +27,46 @@ func TestFindRoutes(t *testing.T) {
 	}
 }
 
+func TestFindRoutesWithPinnedHubs(t *testing.T) {
+	// Create map and lock faking in order to guarantee reproducability of faked data.
+	m := getOptimizedDefaultTestMap(t)
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+
+	// Pin a Hub that is directly connected to the Home Hub.
+	var pinned *Pin
+	for _, lane := range m.home.ConnectedTo {
+		pinned = lane.Pin
+		break
+	}
+	if pinned == nil {
+		t.Skip("home hub has no lanes")
+	}
+	opts := m.DefaultOptions()
+	opts.PinnedHubs = []string{pinned.Hub.ID}
+	if err := m.checkPinnedHubs(opts); err != nil {
+		t.Skipf("cannot use pinned hub: %s", err)
+	}
+
+	dstIP, _ := createGoodIP(true)
+	routes, err := m.FindRoutes(dstIP, opts, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range routes.All {
+		if !route.includesHubs(opts.PinnedHubs) {
+			t.Errorf("route %s does not include pinned hub %s", route, pinned)
+		}
+	}
+
+	// Pinning an unknown Hub must fall back to regular routing.
+	opts.PinnedHubs = []string{"unknown"}
+	_, err = m.FindRoutes(dstIP, opts, 10)
+	if err != nil {
+		t.Errorf("expected fallback to regular routing, got: %s", err)
+	}
+}
+
 func BenchmarkFindRoutes(b *testing.B) {
 	// Create map and lock faking in order to guarantee reproducability of faked data.
 	m := getOptimizedDefaultTestMap(nil)
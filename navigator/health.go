@@ -0,0 +1,140 @@
+package navigator
+
+import (
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+// Hub Health.
+const (
+	// HubHealthOnline signifies that no recent failures are known.
+	HubHealthOnline = "online"
+	// HubHealthDegraded signifies that a crane to the Hub recently failed, but
+	// the Hub is still within the offline grace period.
+	HubHealthDegraded = "degraded"
+	// HubHealthOffline signifies that the Hub is disregarded, either because it
+	// kept failing after the grace period or because it announced going offline.
+	HubHealthOffline = "offline"
+)
+
+// degradedHubCost is added to the cost of degraded Hubs, so that they are
+// only used if there is no good alternative.
+const degradedHubCost float32 = 1000
+
+// ReportHubFailure reports that a crane to the Hub with the given ID failed.
+// Within the configured offline grace period, the Hub is only deprioritized
+// in order to give reconnecting a chance. If the Hub fails again after the
+// grace period, it is marked as failing and disregarded for the configured
+// failing duration.
+func (m *Map) ReportHubFailure(hubID string) {
+	m.Lock()
+	defer m.Unlock()
+
+	pin, ok := m.all[hubID]
+	if !ok {
+		return
+	}
+
+	pin.Lock()
+	defer pin.Unlock()
+
+	if pin.markFailure(time.Now(), configuredOfflineGracePeriod(), configuredHubFailingDuration()) {
+		log.Infof("navigator: marked %s as failing until %s", pin.Hub.StringWithoutLocking(), pin.FailingUntil.Format(time.RFC3339))
+	}
+	pin.pushChanges.Set()
+}
+
+// ReportHubSuccess reports that a crane to the Hub with the given ID was
+// successfully established, which clears any degraded or failing state.
+func (m *Map) ReportHubSuccess(hubID string) {
+	m.Lock()
+	defer m.Unlock()
+
+	pin, ok := m.all[hubID]
+	if !ok {
+		return
+	}
+
+	pin.Lock()
+	defer pin.Unlock()
+
+	if pin.State.hasAnyOf(StateDegraded | StateFailing) {
+		pin.markRecovered()
+		pin.pushChanges.Set()
+	}
+}
+
+// markFailure marks a failure of the Pin and returns whether the Pin was
+// marked as failing. The Pin is only marked as failing if it fails again after
+// the grace period.
+func (pin *Pin) markFailure(now time.Time, gracePeriod, failingDuration time.Duration) (failing bool) {
+	switch {
+	case pin.State.has(StateFailing):
+		// Already failing, extend.
+	case gracePeriod <= 0:
+		// Grace period is disabled.
+	case !pin.State.has(StateDegraded):
+		// First failure, start grace period.
+		pin.addStates(StateDegraded)
+		pin.DegradedSince = now
+		pin.updateCost()
+		return false
+	case now.Sub(pin.DegradedSince) < gracePeriod:
+		// Still within grace period.
+		return false
+	}
+
+	pin.removeStates(StateDegraded)
+	pin.addStates(StateFailing)
+	pin.DegradedSince = time.Time{}
+	pin.FailingUntil = now.Add(failingDuration)
+	pin.updateCost()
+	return true
+}
+
+// expireDegraded removes the degraded state of the Pin if no further failure
+// was reported within the grace period and the failing duration, as the
+// failure is then regarded as stale. It returns whether the state was removed.
+func (pin *Pin) expireDegraded(now time.Time, gracePeriod, failingDuration time.Duration) (expired bool) {
+	if !pin.State.has(StateDegraded) ||
+		now.Sub(pin.DegradedSince) < gracePeriod+failingDuration {
+		return false
+	}
+
+	pin.markRecovered()
+	return true
+}
+
+// markRecovered removes any degraded or failing state from the Pin.
+func (pin *Pin) markRecovered() {
+	pin.removeStates(StateDegraded | StateFailing)
+	pin.DegradedSince = time.Time{}
+	pin.FailingUntil = time.Time{}
+	pin.updateCost()
+}
+
+// updateCost updates the cost of the Pin based on the Hub load and health.
+func (pin *Pin) updateCost() {
+	var load int
+	if pin.Hub.Status != nil {
+		load = pin.Hub.Status.Load
+	}
+
+	pin.Cost = CalculateHubCost(load)
+	if pin.State.has(StateDegraded) {
+		pin.Cost += degradedHubCost
+	}
+}
+
+// health returns the health of the Pin.
+func (pin *Pin) health() string {
+	switch {
+	case pin.State.hasAnyOf(StateFailing | StateOffline):
+		return HubHealthOffline
+	case pin.State.has(StateDegraded):
+		return HubHealthDegraded
+	default:
+		return HubHealthOnline
+	}
+}
@@ -0,0 +1,57 @@
+package navigator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/safing/spn/hub"
+)
+
+func TestPinHealth(t *testing.T) {
+	t.Parallel()
+
+	pin := &Pin{
+		Hub: &hub.Hub{
+			Status: &hub.Status{},
+		},
+	}
+	pin.updateCost()
+	baseCost := pin.Cost
+	now := time.Now()
+	gracePeriod := time.Minute
+	failingDuration := 10 * time.Minute
+
+	// First failure degrades the Hub.
+	assert.False(t, pin.markFailure(now, gracePeriod, failingDuration))
+	assert.Equal(t, HubHealthDegraded, pin.health())
+	assert.Greater(t, pin.Cost, baseCost, "degraded hub should be deprioritized")
+	assert.False(t, pin.State.hasAnyOf(StateSummaryDisregard), "degraded hub should not be disregarded")
+
+	// Failures within the grace period do not change anything.
+	assert.False(t, pin.markFailure(now.Add(30*time.Second), gracePeriod, failingDuration))
+	assert.Equal(t, HubHealthDegraded, pin.health())
+
+	// Failures after the grace period mark the Hub as failing.
+	assert.True(t, pin.markFailure(now.Add(gracePeriod), gracePeriod, failingDuration))
+	assert.Equal(t, HubHealthOffline, pin.health())
+	assert.Equal(t, baseCost, pin.Cost)
+	assert.Equal(t, now.Add(gracePeriod).Add(failingDuration), pin.FailingUntil)
+
+	// Recovering clears all failure states.
+	pin.markRecovered()
+	assert.Equal(t, HubHealthOnline, pin.health())
+	assert.Equal(t, baseCost, pin.Cost)
+
+	// A degraded state without further failures expires.
+	assert.False(t, pin.markFailure(now, gracePeriod, failingDuration))
+	assert.False(t, pin.expireDegraded(now.Add(gracePeriod), gracePeriod, failingDuration), "degraded state should not expire yet")
+	assert.Equal(t, HubHealthDegraded, pin.health())
+	assert.True(t, pin.expireDegraded(now.Add(gracePeriod+failingDuration), gracePeriod, failingDuration))
+	assert.Equal(t, HubHealthOnline, pin.health())
+
+	// Without a grace period, the Hub is marked as failing immediately.
+	assert.True(t, pin.markFailure(now, 0, failingDuration))
+	assert.Equal(t, HubHealthOffline, pin.health())
+}
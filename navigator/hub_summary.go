@@ -0,0 +1,97 @@
+package navigator
+
+import (
+	"sort"
+	"time"
+
+	"github.com/safing/spn/docks"
+)
+
+// HubSummary is a snapshot of a Hub on a Map, intended for network map views.
+type HubSummary struct {
+	ID     string
+	Name   string
+	Region string `json:",omitempty"`
+
+	States    []string
+	Health    string // One of HubHealthOnline, HubHealthDegraded and HubHealthOffline.
+	Trusted   bool
+	Reachable bool
+	Active    bool
+
+	HopDistance int
+	Cost        float32
+
+	// Last status published by the Hub.
+	Version    string
+	Load       int
+	Lanes      int
+	StatusTime time.Time `json:",omitempty"`
+
+	// ActiveCrane signifies that there is a crane assigned to the Hub.
+	ActiveCrane bool
+	// SessionActive signifies that there is an active terminal to the Hub, or
+	// that it is the Home Hub.
+	SessionActive bool
+}
+
+// ListHubs returns a summary of every Hub on the Map, sorted by ID.
+// The Map is only locked for copying the list of Pins, so every summary is
+// consistent in itself, but the summaries may be taken at slightly different
+// times.
+func (m *Map) ListHubs() []HubSummary {
+	// Copy Pins and their regions while holding the map lock.
+	m.RLock()
+	pins := make([]*Pin, 0, len(m.all))
+	regions := make(map[string]string, len(m.all))
+	for _, pin := range m.all {
+		pins = append(pins, pin)
+		if pin.region != nil {
+			regions[pin.Hub.ID] = pin.region.ID
+		}
+	}
+	m.RUnlock()
+	sort.Sort(sortByPinID(pins))
+
+	// Get assigned cranes once.
+	cranes := docks.GetAllAssignedCranes()
+
+	summaries := make([]HubSummary, 0, len(pins))
+	for _, pin := range pins {
+		summaries = append(summaries, pin.summary(regions[pin.Hub.ID], cranes))
+	}
+	return summaries
+}
+
+// summary returns a summary of the Pin.
+func (pin *Pin) summary(region string, cranes map[string]*docks.Crane) HubSummary {
+	pin.Lock()
+	defer pin.Unlock()
+
+	summary := HubSummary{
+		ID:            pin.Hub.ID,
+		Name:          pin.Hub.Info.Name,
+		Region:        region,
+		States:        pin.State.Export(),
+		Health:        pin.health(),
+		Trusted:       pin.State.has(StateTrusted),
+		Reachable:     pin.State.has(StateReachable),
+		Active:        pin.State.has(StateActive),
+		HopDistance:   pin.HopDistance,
+		Cost:          pin.Cost,
+		SessionActive: pin.hasActiveTerminal() || pin.State.has(StateIsHomeHub),
+	}
+	if status := pin.Hub.Status; status != nil {
+		summary.Version = status.Version
+		summary.Load = status.Load
+		summary.Lanes = len(status.Lanes)
+		if status.Timestamp != 0 {
+			summary.StatusTime = time.Unix(status.Timestamp, 0)
+		}
+	}
+	if crane, ok := cranes[pin.Hub.ID]; ok && crane != nil && !crane.Stopped() {
+		summary.ActiveCrane = true
+	}
+
+	return summary
+}
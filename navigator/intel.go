@@ -1,7 +1,6 @@
 package navigator
 
 import (
-	"context"
 	"errors"
 
 	"github.com/safing/portbase/log"
@@ -32,6 +31,7 @@ func (m *Map) UpdateIntel(update *hub.Intel) error {
 
 	// Configure the map's regions.
 	m.updateRegions(m.intel.Regions)
+	m.checkHubLaneConflicts()
 
 	log.Infof("spn/navigator: updated intel on map %s", m.Name)
 
@@ -74,38 +74,39 @@ func (m *Map) updateIntelStatuses(pin *Pin) {
 		pin,
 		StateUsageDiscouraged,
 		m.intel.AdviseOnlyTrustedHubs,
-		m.intel.Parsed().HubAdvisory,
+		m.intel.Parsed(),
+		hub.HubAdvisoryList,
 	)
 	// Check for UsageAsHomeDiscouraged.
 	checkStatusList(
 		pin,
 		StateUsageAsHomeDiscouraged,
 		m.intel.AdviseOnlyTrustedHomeHubs,
-		m.intel.Parsed().HomeHubAdvisory,
+		m.intel.Parsed(),
+		hub.HomeHubAdvisoryList,
 	)
 	// Check for UsageAsDestinationDiscouraged.
 	checkStatusList(
 		pin,
 		StateUsageAsDestinationDiscouraged,
 		m.intel.AdviseOnlyTrustedDestinationHubs,
-		m.intel.Parsed().DestinationHubAdvisory,
+		m.intel.Parsed(),
+		hub.DestinationHubAdvisoryList,
 	)
 }
 
-func checkStatusList(pin *Pin, state PinState, requireTrusted bool, endpointList endpoints.Endpoints) {
+func checkStatusList(pin *Pin, state PinState, requireTrusted bool, parsedIntel *hub.ParsedIntel, list hub.AdvisoryList) {
 	if requireTrusted && !pin.State.has(StateTrusted) {
 		pin.addStates(state)
 		return
 	}
 
-	result, _ := endpointList.Match(context.TODO(), pin.EntityV4)
-	if result == endpoints.Denied {
+	if parsedIntel.MatchAdvisory(list, pin.EntityV4) == endpoints.Denied {
 		pin.addStates(state)
 		return
 	}
 
-	result, _ = endpointList.Match(context.TODO(), pin.EntityV6)
-	if result == endpoints.Denied {
+	if parsedIntel.MatchAdvisory(list, pin.EntityV6) == endpoints.Denied {
 		pin.addStates(state)
 	}
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/safing/portbase/database"
 	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
 	"github.com/safing/spn/docks"
 	"github.com/safing/spn/hub"
 )
@@ -31,6 +32,14 @@ type Map struct {
 	analysisLock           sync.Mutex
 	regardedPins           []*Pin
 	lastDesegrationAttempt time.Time
+
+	// reachabilityLock guards all reachability subscription fields.
+	// If both locks are needed, the map lock must be acquired first.
+	reachabilityLock           sync.Mutex
+	reachabilitySubs           []chan ReachabilityEvent
+	reachabilityKnown          map[string]int
+	reachabilityTask           *modules.Task
+	reachabilityCheckScheduled bool
 }
 
 // NewMap returns a new and empty Map.
@@ -58,6 +67,21 @@ func (m *Map) GetPin(hubID string) (pin *Pin, ok bool) {
 	return
 }
 
+// GetUsableHub returns the Hub with the given ID, if it is currently regarded
+// as usable.
+func (m *Map) GetUsableHub(hubID string) (h *hub.Hub, ok bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	pin, ok := m.all[hubID]
+	if !ok ||
+		!pin.State.has(StateSummaryRegard) ||
+		!pin.State.hasNoneOf(StateSummaryDisregard) {
+		return nil, false
+	}
+	return pin.Hub, true
+}
+
 // GetHome returns the current home and it's accompanying terminal.
 // Both may be nil.
 func (m *Map) GetHome() (*Pin, *docks.CraneTerminal) {
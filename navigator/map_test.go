@@ -271,3 +271,22 @@ func createCapacity() int {
 	// Return a value between 10Mbit/s and 1Gbit/s.
 	return gofakeit.Number(10000000, 1000000000)
 }
+
+func TestListHubs(t *testing.T) {
+	t.Parallel()
+
+	m := getDefaultTestMap()
+	summaries := m.ListHubs()
+	if len(summaries) != len(m.all) {
+		t.Fatalf("expected %d hub summaries, got %d", len(m.all), len(summaries))
+	}
+	for i, summary := range summaries {
+		if i > 0 && summaries[i-1].ID >= summary.ID {
+			t.Fatal("hub summaries are not sorted by ID")
+		}
+		pin := m.all[summary.ID]
+		if summary.Reachable != pin.State.has(StateReachable) {
+			t.Fatalf("reachability of %s does not match pin state", summary.ID)
+		}
+	}
+}
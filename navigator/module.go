@@ -29,6 +29,10 @@ func init() {
 }
 
 func prep() error {
+	if err := prepConfig(); err != nil {
+		return err
+	}
+
 	return registerAPIEndpoints()
 }
 
@@ -106,6 +106,11 @@ type OptimizationResult struct {
 	// be stopped.
 	StopOthers bool
 
+	// MaxLanes specifies how many lanes the Hub should have at maximum.
+	// Other connections than the suggested ones above the limit should be
+	// stopped immediately. Zero means no limit.
+	MaxLanes int
+
 	// opts holds the options for matching Hubs in this optimization.
 	opts *Options
 
@@ -278,6 +283,9 @@ func (m *Map) optimize(opts *Options) (result *OptimizationResult, err error) {
 		return nil, err
 	}
 
+	// Apply lane limits configured for this Hub.
+	m.optimizeForHubLaneLimits(result)
+
 	// Lapse traffic stats after optimizing for good fresh data next time.
 	for _, crane := range docks.GetAllAssignedCranes() {
 		crane.NetState.LapsePeriod()
@@ -0,0 +1,138 @@
+package navigator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+// getHubLaneConfig returns the lane limits of the given Hub, if configured.
+func (m *Map) getHubLaneConfig(hubID string) *hub.HubLaneConfig {
+	if m.intel == nil {
+		return nil
+	}
+	return m.intel.HubLanes[hubID]
+}
+
+// checkHubLaneConflicts logs conflicts between the configured Hub lane limits
+// and the lane parameters of the regions of the Hubs. The Hub lane limits take
+// precedence.
+func (m *Map) checkHubLaneConflicts() {
+	if m.intel == nil {
+		return
+	}
+
+	for hubID, laneConfig := range m.intel.HubLanes {
+		pin, ok := m.all[hubID]
+		if !ok || pin.region == nil {
+			continue
+		}
+
+		if laneConfig.MaxLanes > 0 && laneConfig.MaxLanes < pin.region.internalMinLanesOnHub {
+			log.Warningf(
+				"spn/navigator: max lanes %d of %s are below the minimum of %d lanes within region %s, using max lanes",
+				laneConfig.MaxLanes,
+				pin.Hub.StringWithoutLocking(),
+				pin.region.internalMinLanesOnHub,
+				pin.region.getName(),
+			)
+		}
+		if pin.region.regionalMaxLanesOnHub > 0 && laneConfig.MinLanes > pin.region.regionalMaxLanesOnHub {
+			log.Warningf(
+				"spn/navigator: min lanes %d of %s exceed the maximum of %d lanes to other regions of region %s, using min lanes",
+				laneConfig.MinLanes,
+				pin.Hub.StringWithoutLocking(),
+				pin.region.regionalMaxLanesOnHub,
+				pin.region.getName(),
+			)
+		}
+	}
+}
+
+// optimizeForHubLaneLimits applies the lane limits configured for the Home
+// Hub to the suggested connections. The max lanes also include existing
+// lanes, including lanes built by other Hubs.
+func (m *Map) optimizeForHubLaneLimits(result *OptimizationResult) {
+	laneConfig := m.getHubLaneConfig(m.home.Hub.ID)
+	if laneConfig == nil {
+		return
+	}
+
+	// Count suggested Hubs.
+	var suggested int
+	for _, sc := range result.SuggestedConnections {
+		if !sc.Duplicate {
+			suggested++
+		}
+	}
+
+	if suggested < laneConfig.MinLanes {
+		result.addApproach(fmt.Sprintf("Connect to best (lowest cost) Hubs until reaching %d lanes as configured for this Hub.", laneConfig.MinLanes))
+
+		// Add the best Hubs that are not yet suggested.
+		sort.Sort(sortByLowestMeasuredCost(m.regardedPins))
+		for _, pin := range m.regardedPins {
+			if suggested >= laneConfig.MinLanes {
+				break
+			}
+			if pin.analysis.Suggested || pin == m.home {
+				continue
+			}
+			result.addSuggested("hub minimum lanes", pin)
+			suggested++
+		}
+	}
+
+	if laneConfig.MaxLanes <= 0 {
+		return
+	}
+	result.MaxLanes = laneConfig.MaxLanes
+
+	if suggested > laneConfig.MaxLanes {
+		result.addApproach(fmt.Sprintf("Limit to %d lanes as configured for this Hub.", laneConfig.MaxLanes))
+
+		// Keep the first suggested Hubs, including their duplicates.
+		kept := make(map[string]struct{}, laneConfig.MaxLanes)
+		limited := make([]*SuggestedConnection, 0, len(result.SuggestedConnections))
+		for _, sc := range result.SuggestedConnections {
+			if _, ok := kept[sc.Hub.ID]; !ok {
+				if len(kept) >= laneConfig.MaxLanes {
+					sc.pin.analysis.Suggested = false
+					continue
+				}
+				kept[sc.Hub.ID] = struct{}{}
+			}
+			limited = append(limited, sc)
+		}
+		result.SuggestedConnections = limited
+	}
+
+	// Do not create new lanes while existing lanes reach the limit.
+	// Unsuggested lanes above the limit are retired.
+	newLanes := laneConfig.MaxLanes - len(m.home.ConnectedTo)
+	if newLanes < 0 {
+		newLanes = 0
+	}
+	if newLanes < result.MaxConnect {
+		result.addApproach(fmt.Sprintf("Create at most %d new lanes, as %d lanes exist.", newLanes, len(m.home.ConnectedTo)))
+		result.MaxConnect = newLanes
+	}
+}
+
+// GetHomeHubMaxLanes returns the max lanes configured for the Home Hub.
+// Zero means that there is no limit.
+func (m *Map) GetHomeHubMaxLanes() int {
+	m.RLock()
+	defer m.RUnlock()
+
+	if m.home == nil {
+		return 0
+	}
+	laneConfig := m.getHubLaneConfig(m.home.Hub.ID)
+	if laneConfig == nil || laneConfig.MaxLanes <= 0 {
+		return 0
+	}
+	return laneConfig.MaxLanes
+}
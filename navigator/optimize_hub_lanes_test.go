@@ -0,0 +1,88 @@
+package navigator
+
+import (
+	"testing"
+
+	"github.com/safing/spn/hub"
+)
+
+func countSuggestedHubs(result *OptimizationResult) (suggested int) {
+	for _, sc := range result.SuggestedConnections {
+		if !sc.Duplicate {
+			suggested++
+		}
+	}
+	return suggested
+}
+
+func TestHubLaneLimits(t *testing.T) {
+	// Use a private map, as the intel is changed.
+	m := createRandomTestMap(3, 50)
+	m.optimizeTestMap(t)
+
+	// Pick any Hub with lanes as home.
+	for _, pin := range m.all {
+		if len(pin.ConnectedTo) == 0 {
+			continue
+		}
+		if !m.SetHome(pin.Hub.ID, nil) {
+			t.Fatal("failed to set home")
+		}
+		break
+	}
+	updateMeasurements(m, newMeasurementCachedFactory())
+	homeID := m.home.Hub.ID
+	existingLanes := len(m.home.ConnectedTo)
+
+	// Without limits.
+	result, err := m.optimize(m.defaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Purpose != OptimizePurposeTargetStructure {
+		t.Fatalf("unexpected optimization purpose %s", result.Purpose)
+	}
+	unlimited := countSuggestedHubs(result)
+	if unlimited < 2 {
+		t.Fatalf("expected at least 2 suggested hubs, got %d", unlimited)
+	}
+
+	// Max lanes limit the suggestions.
+	m.intel.HubLanes = map[string]*hub.HubLaneConfig{
+		homeID: {MaxLanes: 1},
+	}
+	result, err = m.optimize(m.defaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suggested := countSuggestedHubs(result); suggested != 1 {
+		t.Fatalf("expected 1 suggested hub with max lanes, got %d", suggested)
+	}
+	if result.MaxLanes != 1 {
+		t.Fatalf("expected max lanes of 1 in result, got %d", result.MaxLanes)
+	}
+
+	// Existing lanes count towards the max lanes.
+	m.intel.HubLanes = map[string]*hub.HubLaneConfig{
+		homeID: {MaxLanes: existingLanes + 1},
+	}
+	result, err = m.optimize(m.defaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MaxConnect != 1 {
+		t.Fatalf("expected 1 new lane with %d existing lanes, got %d", existingLanes, result.MaxConnect)
+	}
+
+	// Min lanes add suggestions.
+	m.intel.HubLanes = map[string]*hub.HubLaneConfig{
+		homeID: {MinLanes: unlimited + 5},
+	}
+	result, err = m.optimize(m.defaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suggested := countSuggestedHubs(result); suggested != unlimited+5 {
+		t.Fatalf("expected %d suggested hubs with min lanes, got %d", unlimited+5, suggested)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/safing/portmaster/intel"
 	"github.com/safing/portmaster/profile/endpoints"
+	"github.com/safing/spn/hub"
 )
 
 type HubType uint8
@@ -50,6 +51,29 @@ type Options struct {
 
 	// RoutingProfile defines the algorithm to use to find a route.
 	RoutingProfile string
+
+	// PinnedHubs is a list of Hub IDs that must be part of every route, if
+	// feasible. If no route can be found through all pinned Hubs, they are
+	// ignored. Pinning is about routing only and does not imply trust.
+	PinnedHubs []string
+
+	// SelectionPolicy defines how to select among comparable Hubs. Defaults to
+	// SelectionPolicyDeterministic.
+	SelectionPolicy string
+
+	// PreferredRegions is a list of region IDs in order of preference. Home
+	// and Destination Hubs are selected from the first preferred region that
+	// has a suitable Hub. If none has, all regions are taken into account.
+	PreferredRegions []string
+
+	// RequiredCapabilities is a list of Hub capabilities that all Hubs must
+	// have in order to be taken into account for the operation.
+	RequiredCapabilities []string
+
+	// TransportPolicy defines which transports may be used to connect to the
+	// Home Hub. Home Hubs without a permitted transport are not taken into
+	// account. Other Hubs are not connected to directly and are not affected.
+	TransportPolicy *hub.TransportPolicy
 }
 
 func (o *Options) Copy() *Options {
@@ -64,6 +88,11 @@ func (o *Options) Copy() *Options {
 		NoDefaults:                    o.NoDefaults,
 		RequireTrustedDestinationHubs: o.RequireTrustedDestinationHubs,
 		RoutingProfile:                o.RoutingProfile,
+		PinnedHubs:                    o.PinnedHubs,
+		SelectionPolicy:               o.SelectionPolicy,
+		PreferredRegions:              o.PreferredRegions,
+		RequiredCapabilities:          o.RequiredCapabilities,
+		TransportPolicy:               o.TransportPolicy,
 	}
 }
 
@@ -79,7 +108,11 @@ func (m *Map) DefaultOptions() *Options {
 
 func (m *Map) defaultOptions() *Options {
 	opts := &Options{
-		RoutingProfile: RoutingProfileDefaultName,
+		RoutingProfile:   RoutingProfileDefaultName,
+		PinnedHubs:       configuredPinnedHubs(),
+		SelectionPolicy:  configuredSelectionPolicy(),
+		PreferredRegions: configuredPreferredRegions(),
+		TransportPolicy:  ConfiguredTransportPolicy(),
 	}
 
 	if m.intel != nil && m.intel.Parsed() != nil {
@@ -130,12 +163,29 @@ func (o *Options) Matcher(hubType HubType) PinMatcher {
 		destinationHubPolicy = o.DestinationHubPolicy
 	}
 
+	requiredCapabilities := o.RequiredCapabilities
+	var transportPolicy *hub.TransportPolicy
+	if hubType == HomeHub {
+		transportPolicy = o.TransportPolicy
+	}
+
 	return func(pin *Pin) bool {
 		// Check required Pin States.
 		if !pin.State.has(regard) || pin.State.hasAnyOf(disregard) {
 			return false
 		}
 
+		// Check required capabilities.
+		if len(requiredCapabilities) > 0 &&
+			!pin.Hub.GetInfo().HasCapabilities(requiredCapabilities...) {
+			return false
+		}
+
+		// Check if the Hub can be connected to with a permitted transport.
+		if transportPolicy != nil && !pin.Hub.GetInfo().HasPermittedTransport(transportPolicy) {
+			return false
+		}
+
 		// Check main policy.
 		if hubPolicy != nil {
 			if endpointListMatch(hubPolicy, pin.EntityV4) == endpoints.Denied ||
@@ -36,6 +36,9 @@ type Pin struct {
 	// FailingUntil specifies until when this Hub should be regarded as failing.
 	// This is connected to StateFailing.
 	FailingUntil time.Time
+	// DegradedSince specifies when the offline grace period of this Hub started.
+	// This is connected to StateDegraded.
+	DegradedSince time.Time
 
 	// Connection holds a information about a connection to the Hub of this Pin.
 	Connection *PinConnection
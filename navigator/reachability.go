@@ -0,0 +1,158 @@
+package navigator
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+)
+
+// ReachabilityEventDebounce defines how long changes are collected before
+// reachability events are emitted. Hubs that flap within this time do not
+// produce any events.
+var ReachabilityEventDebounce = 10 * time.Second
+
+const reachabilitySubscriptionBufferSize = 100
+
+// ReachabilityEvent signifies that a Hub became reachable or unreachable.
+type ReachabilityEvent struct {
+	// Map is the name of the map the Hub is in.
+	Map string
+	// HubID is the ID of the Hub.
+	HubID string
+	// Reachable holds whether the Hub is now reachable.
+	Reachable bool
+	// HopDistance is the new hop distance of the Hub, if reachable.
+	HopDistance int
+}
+
+// SubscribeReachabilityChanges returns a channel that receives an event
+// whenever a Hub becomes reachable or unreachable. Events are debounced.
+// If the subscriber does not keep up, events are dropped.
+func (m *Map) SubscribeReachabilityChanges() <-chan ReachabilityEvent {
+	sub := make(chan ReachabilityEvent, reachabilitySubscriptionBufferSize)
+
+	// Get current state before locking reachability, as the map lock must
+	// always be acquired first.
+	current := m.reachableHubs()
+
+	m.reachabilityLock.Lock()
+	defer m.reachabilityLock.Unlock()
+
+	// Initialize known reachability with the first subscriber.
+	if m.reachabilityKnown == nil {
+		m.reachabilityKnown = current
+	}
+	m.reachabilitySubs = append(m.reachabilitySubs, sub)
+	return sub
+}
+
+// UnsubscribeReachabilityChanges removes the given subscription and closes
+// its channel.
+func (m *Map) UnsubscribeReachabilityChanges(sub <-chan ReachabilityEvent) {
+	m.reachabilityLock.Lock()
+	defer m.reachabilityLock.Unlock()
+
+	for i, existing := range m.reachabilitySubs {
+		if existing == sub {
+			m.reachabilitySubs = append(m.reachabilitySubs[:i], m.reachabilitySubs[i+1:]...)
+			close(existing)
+			return
+		}
+	}
+}
+
+// triggerReachabilityCheck schedules a check for reachability changes, if
+// there are any subscribers and no check is scheduled yet.
+// May be called while the map is locked.
+func (m *Map) triggerReachabilityCheck() {
+	m.reachabilityLock.Lock()
+	defer m.reachabilityLock.Unlock()
+
+	switch {
+	case len(m.reachabilitySubs) == 0:
+		return
+	case m.reachabilityCheckScheduled:
+		return
+	}
+
+	if m.reachabilityTask == nil {
+		m.reachabilityTask = module.NewTask("check reachability changes", m.checkReachabilityChanges)
+	}
+	m.reachabilityTask.Schedule(time.Now().Add(ReachabilityEventDebounce))
+	m.reachabilityCheckScheduled = true
+}
+
+// reachableHubs returns the hop distances of all reachable Hubs.
+func (m *Map) reachableHubs() map[string]int {
+	m.RLock()
+	defer m.RUnlock()
+
+	reachable := make(map[string]int)
+	for _, pin := range m.all {
+		if pin.State.has(StateReachable) {
+			reachable[pin.Hub.ID] = pin.HopDistance
+		}
+	}
+	return reachable
+}
+
+func (m *Map) checkReachabilityChanges(_ context.Context, _ *modules.Task) error {
+	m.reachabilityLock.Lock()
+	m.reachabilityCheckScheduled = false
+	m.reachabilityLock.Unlock()
+
+	// Get current state before locking reachability, as the map lock must
+	// always be acquired first.
+	current := m.reachableHubs()
+
+	m.reachabilityLock.Lock()
+	defer m.reachabilityLock.Unlock()
+
+	// Calculate changes.
+	events := diffReachability(m.Name, m.reachabilityKnown, current)
+	m.reachabilityKnown = current
+
+	// Send events to subscribers.
+	for _, event := range events {
+		for _, sub := range m.reachabilitySubs {
+			select {
+			case sub <- event:
+			default:
+				log.Warningf("spn/navigator: dropping reachability event for %s: subscriber is not keeping up", event.HubID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// diffReachability returns events for all Hubs that changed reachability.
+func diffReachability(mapName string, previous, current map[string]int) []ReachabilityEvent {
+	var events []ReachabilityEvent
+
+	// Check for Hubs that became reachable.
+	for hubID, hopDistance := range current {
+		if _, ok := previous[hubID]; !ok {
+			events = append(events, ReachabilityEvent{
+				Map:         mapName,
+				HubID:       hubID,
+				Reachable:   true,
+				HopDistance: hopDistance,
+			})
+		}
+	}
+
+	// Check for Hubs that became unreachable.
+	for hubID := range previous {
+		if _, ok := current[hubID]; !ok {
+			events = append(events, ReachabilityEvent{
+				Map:   mapName,
+				HubID: hubID,
+			})
+		}
+	}
+
+	return events
+}
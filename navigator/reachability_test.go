@@ -0,0 +1,35 @@
+package navigator
+
+import (
+	"testing"
+)
+
+func TestDiffReachability(t *testing.T) {
+	previous := map[string]int{
+		"a": 1,
+		"b": 2,
+	}
+	current := map[string]int{
+		"b": 3,
+		"c": 1,
+	}
+
+	events := diffReachability("test", previous, current)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %+v", len(events), events)
+	}
+	for _, event := range events {
+		switch event.HubID {
+		case "a":
+			if event.Reachable {
+				t.Error("hub a should be unreachable")
+			}
+		case "c":
+			if !event.Reachable || event.HopDistance != 1 {
+				t.Errorf("hub c should be reachable at distance 1: %+v", event)
+			}
+		default:
+			t.Errorf("unexpected event: %+v", event)
+		}
+	}
+}
@@ -228,3 +228,16 @@ func (m *Map) updatePinRegion(pin *Pin) {
 		}
 	}
 }
+
+// GetHubRegion returns the ID of the region the Hub with the given ID belongs
+// to, if it is in any region.
+func (m *Map) GetHubRegion(hubID string) (regionID string, ok bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	pin, ok := m.all[hubID]
+	if !ok || pin.region == nil {
+		return "", false
+	}
+	return pin.region.ID, true
+}
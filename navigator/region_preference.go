@@ -0,0 +1,41 @@
+package navigator
+
+import (
+	"github.com/safing/portmaster/intel/geoip"
+)
+
+// findPreferredNearestPins finds the nearest Pins like findNearestPins, but
+// regards the preferred regions of the options when selecting Home or
+// Destination Hubs. The preferred regions are tried in order and the nearest
+// Pins of the first region with matching Pins are returned. If none of the
+// preferred regions have matching Pins, all Pins are taken into account.
+func (m *Map) findPreferredNearestPins(locationV4, locationV6 *geoip.Location, opts *Options, matchFor HubType, maxMatches int) (*nearbyPins, error) {
+	matcher := opts.Matcher(matchFor)
+
+	// Regional preferences only apply to Home and Destination Hubs.
+	if len(opts.PreferredRegions) == 0 || matchFor == TransitHub {
+		return m.findNearestPins(locationV4, locationV6, matcher, maxMatches)
+	}
+
+	// Try the preferred regions in order.
+	for _, regionID := range opts.PreferredRegions {
+		nearby, err := m.findNearestPins(locationV4, locationV6, func(pin *Pin) bool {
+			return pin.region != nil && pin.region.ID == regionID && matcher(pin)
+		}, maxMatches)
+		if err != nil {
+			return nil, err
+		}
+		if len(nearby.pins) > 0 {
+			nearby.preferredRegion = regionID
+			return nearby, nil
+		}
+	}
+
+	// Fall back to all Hubs.
+	nearby, err := m.findNearestPins(locationV4, locationV6, matcher, maxMatches)
+	if err != nil {
+		return nil, err
+	}
+	nearby.regionFallback = true
+	return nearby, nil
+}
@@ -0,0 +1,121 @@
+package navigator
+
+import (
+	"testing"
+
+	"github.com/safing/spn/hub"
+)
+
+func TestRegionPreference(t *testing.T) {
+	// Create map and lock faking in order to guarantee reproducability of faked data.
+	m := createRandomTestMap(2, 50)
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+
+	// Put every other suitable Home Hub into the preferred region.
+	opts := m.DefaultOptions()
+	matcher := opts.Matcher(HomeHub)
+	region := &Region{ID: "preferred"}
+	var suitable int
+	for _, pin := range m.all {
+		if !matcher(pin) {
+			continue
+		}
+		if suitable%2 == 0 {
+			pin.region = region
+		}
+		suitable++
+	}
+
+	_, loc4 := createGoodIP(true)
+
+	// The first preferred region with suitable Hubs is used.
+	opts.PreferredRegions = []string{"missing", "preferred"}
+	explanation, err := m.ExplainSelection(loc4, nil, opts, HomeHub, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.PreferredRegion != "preferred" || explanation.RegionFallback {
+		t.Fatalf("preferred region was not used: %s", explanation)
+	}
+	for _, candidate := range explanation.Candidates {
+		if m.all[candidate.HubID].region != region {
+			t.Fatalf("candidate %s is not in the preferred region", candidate.HubID)
+		}
+	}
+
+	// Without suitable Hubs in the preferred regions, all Hubs are used.
+	opts.PreferredRegions = []string{"missing"}
+	explanation, err = m.ExplainSelection(loc4, nil, opts, HomeHub, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.PreferredRegion != "" || !explanation.RegionFallback || len(explanation.Candidates) == 0 {
+		t.Fatalf("expected fallback to all regions: %s", explanation)
+	}
+
+	// Transit Hubs are not affected.
+	nearby, err := m.findPreferredNearestPins(loc4, nil, opts, TransitHub, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nearby.preferredRegion != "" || nearby.regionFallback {
+		t.Fatal("regional preference should not apply to transit hubs")
+	}
+}
+
+func TestTransportPolicyExclusion(t *testing.T) {
+	// Create map and lock faking in order to guarantee reproducability of faked data.
+	m := createRandomTestMap(3, 50)
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+
+	// Give every other suitable Home Hub only a tcp transport.
+	opts := m.DefaultOptions()
+	matcher := opts.Matcher(HomeHub)
+	var tcpOnly []string
+	var suitable int
+	for _, pin := range m.all {
+		if !matcher(pin) {
+			continue
+		}
+		if suitable%2 == 0 {
+			pin.Hub.Info.Transports = []string{"tcp:17"}
+			tcpOnly = append(tcpOnly, pin.Hub.ID)
+		} else {
+			pin.Hub.Info.Transports = []string{"tcp:17", "kcp:17"}
+		}
+		suitable++
+	}
+
+	// Hubs without permitted transports are excluded and explained.
+	_, loc4 := createGoodIP(true)
+	var err error
+	opts.TransportPolicy, err = hub.NewTransportPolicy(nil, []string{"tcp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	explanation, err := m.ExplainSelection(loc4, nil, opts, HomeHub, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explanation.ExcludedByTransport) != len(tcpOnly) {
+		t.Fatalf("expected %d hubs to be excluded, got %d", len(tcpOnly), len(explanation.ExcludedByTransport))
+	}
+	for _, candidate := range explanation.Candidates {
+		for _, excluded := range tcpOnly {
+			if candidate.HubID == excluded {
+				t.Fatalf("candidate %s should have been excluded", candidate.HubID)
+			}
+		}
+	}
+
+	// Transit Hubs are not affected.
+	transitWithPolicy := opts.Matcher(TransitHub)
+	transitWithoutPolicy := m.DefaultOptions().Matcher(TransitHub)
+	for _, hubID := range tcpOnly {
+		if transitWithPolicy(m.all[hubID]) != transitWithoutPolicy(m.all[hubID]) {
+			t.Fatal("transport policy should not apply to transit hubs")
+		}
+	}
+}
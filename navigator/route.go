@@ -124,6 +124,20 @@ func (r *Route) recalculateTotalCost() {
 	}
 }
 
+// includesHubs returns whether all of the given Hub IDs are part of the Route.
+func (r *Route) includesHubs(hubIDs []string) bool {
+checkHubs:
+	for _, hubID := range hubIDs {
+		for _, hop := range r.Path {
+			if hop.pin.Hub.ID == hubID {
+				continue checkHubs
+			}
+		}
+		return false
+	}
+	return true
+}
+
 // CopyUpTo makes a somewhat deep copy of the Route up to the specified amount
 // and returns it. Hops themselves are not copied, because their data does not
 // change. Therefore, returned Hops may not be edited.
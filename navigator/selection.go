@@ -0,0 +1,227 @@
+package navigator
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/safing/portmaster/intel/geoip"
+)
+
+// Hub Selection Policies.
+const (
+	// SelectionPolicyDeterministic always selects the best Hub first.
+	SelectionPolicyDeterministic = "deterministic"
+
+	// SelectionPolicyWeightedRandom selects randomly among the Hubs within
+	// SelectionQualityBand of the best Hub, weighted by their available
+	// capacity, in order to spread load across comparable Hubs.
+	SelectionPolicyWeightedRandom = "weighted-random"
+)
+
+var (
+	// SelectionQualityBand defines the maximum proximity difference to the best
+	// Hub for a Hub to be regarded as comparable in weighted random selection.
+	SelectionQualityBand float32 = 5
+
+	// minSelectionWeight is the weight of Hubs without any known capacity, so
+	// that they still have a chance to be selected.
+	minSelectionWeight float64 = 1
+)
+
+// SelectionExplanation describes how Hubs were selected.
+type SelectionExplanation struct {
+	Policy     string
+	Candidates []*SelectionCandidate
+
+	// PreferredRegion is the ID of the preferred region that the candidates
+	// were restricted to, if any.
+	PreferredRegion string `json:",omitempty"`
+	// RegionFallback is set if regions were preferred, but none of them had a
+	// suitable Hub, so that all regions were taken into account.
+	RegionFallback bool `json:",omitempty"`
+
+	// ExcludedByTransport holds the IDs of the Hubs that would have been
+	// suitable, but were excluded, because none of their transports are
+	// permitted by the transport policy.
+	ExcludedByTransport []string `json:",omitempty"`
+}
+
+// SelectionCandidate describes a candidate of a Hub selection.
+type SelectionCandidate struct {
+	HubID     string
+	Proximity float32
+	// Weight is only set for candidates that took part in a weighted random
+	// selection.
+	Weight float64
+	Chosen bool
+}
+
+// String returns a human readable trace of the selection.
+func (se *SelectionExplanation) String() string {
+	s := make([]string, 0, len(se.Candidates))
+	for _, c := range se.Candidates {
+		chosen := ""
+		if c.Chosen {
+			chosen = " (chosen)"
+		}
+		s = append(s, fmt.Sprintf("%s at %.2f prox with weight %.2f%s", c.HubID, c.Proximity, c.Weight, chosen))
+	}
+	var excluded string
+	if len(se.ExcludedByTransport) > 0 {
+		excluded = fmt.Sprintf(" (excluded for transport policy: %s)", strings.Join(se.ExcludedByTransport, ", "))
+	}
+	switch {
+	case se.PreferredRegion != "":
+		return fmt.Sprintf("%s in preferred region %s: %s%s", se.Policy, se.PreferredRegion, strings.Join(s, ", "), excluded)
+	case se.RegionFallback:
+		return fmt.Sprintf("%s without suitable hub in preferred regions: %s%s", se.Policy, strings.Join(s, ", "), excluded)
+	default:
+		return fmt.Sprintf("%s: %s%s", se.Policy, strings.Join(s, ", "), excluded)
+	}
+}
+
+// GetLaneCapacity returns the total capacity of all lanes of the Pin in bit/s.
+func (pin *Pin) GetLaneCapacity() (capacity int) {
+	for _, lane := range pin.ConnectedTo {
+		capacity += lane.Capacity
+	}
+	return capacity
+}
+
+// selectionWeight returns the weight of the Pin for weighted random selection.
+// It is based on the lane capacity of the Hub and reduced by its load.
+func (pin *Pin) selectionWeight() float64 {
+	// Use capacity in Mbit/s as the base weight.
+	weight := float64(pin.GetLaneCapacity()) / 1000000
+
+	// Reduce by reported load.
+	if pin.Hub.Status != nil && pin.Hub.Status.Load > 0 {
+		load := pin.Hub.Status.Load
+		if load > 100 {
+			load = 100
+		}
+		weight *= float64(100-load) / 100
+	}
+
+	if weight < minSelectionWeight {
+		return minSelectionWeight
+	}
+	return weight
+}
+
+// applySelectionPolicy orders the nearby Pins according to the given policy
+// and returns an explanation of the selection.
+func (nb *nearbyPins) applySelectionPolicy(policy string) *SelectionExplanation {
+	explanation := &SelectionExplanation{
+		Policy:          policy,
+		Candidates:      make([]*SelectionCandidate, 0, len(nb.pins)),
+		PreferredRegion: nb.preferredRegion,
+		RegionFallback:  nb.regionFallback,
+	}
+	for _, nbPin := range nb.pins {
+		explanation.Candidates = append(explanation.Candidates, &SelectionCandidate{
+			HubID:     nbPin.pin.Hub.ID,
+			Proximity: nbPin.proximity,
+		})
+	}
+	if len(nb.pins) == 0 {
+		return explanation
+	}
+
+	// Deterministic selection keeps the order of proximity.
+	if policy != SelectionPolicyWeightedRandom {
+		explanation.Policy = SelectionPolicyDeterministic
+		explanation.Candidates[0].Chosen = true
+		return explanation
+	}
+
+	// Calculate weights of all Pins within the quality band of the best Pin.
+	// The Pins are sorted by proximity already.
+	var totalWeight float64
+	inBand := 0
+	for i, nbPin := range nb.pins {
+		if nbPin.proximity < nb.pins[0].proximity-SelectionQualityBand {
+			break
+		}
+		weight := nbPin.pin.selectionWeight()
+		explanation.Candidates[i].Weight = weight
+		totalWeight += weight
+		inBand++
+	}
+
+	// Select a Pin weighted by its capacity.
+	chosen := 0
+	pick := rand.Float64() * totalWeight //nolint:gosec // Does not need to be secure.
+	for i := 0; i < inBand; i++ {
+		pick -= explanation.Candidates[i].Weight
+		if pick < 0 {
+			chosen = i
+			break
+		}
+	}
+	explanation.Candidates[chosen].Chosen = true
+
+	// Move the chosen Pin to the front.
+	if chosen > 0 {
+		chosenPin := nb.pins[chosen]
+		copy(nb.pins[1:chosen+1], nb.pins[:chosen])
+		nb.pins[0] = chosenPin
+
+		chosenCandidate := explanation.Candidates[chosen]
+		copy(explanation.Candidates[1:chosen+1], explanation.Candidates[:chosen])
+		explanation.Candidates[0] = chosenCandidate
+	}
+
+	return explanation
+}
+
+// ExplainSelection runs the same selection as FindNearestHubs and returns a
+// trace of the selection, including the weights used in weighted random
+// selection.
+func (m *Map) ExplainSelection(locationV4, locationV6 *geoip.Location, opts *Options, matchFor HubType, maxMatches int) (*SelectionExplanation, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	// Check if map is populated.
+	if m.isEmpty() {
+		return nil, ErrEmptyMap
+	}
+
+	// Set default options if unset.
+	if opts == nil {
+		opts = m.defaultOptions()
+	}
+
+	// Find nearest Pins and apply selection policy.
+	nearby, err := m.findPreferredNearestPins(locationV4, locationV6, opts, matchFor, maxMatches)
+	if err != nil {
+		return nil, err
+	}
+	explanation := nearby.applySelectionPolicy(opts.SelectionPolicy)
+	explanation.ExcludedByTransport = m.excludedByTransport(opts, matchFor)
+	return explanation, nil
+}
+
+// excludedByTransport returns the IDs of the Hubs that match the given
+// options, except for the transport policy.
+func (m *Map) excludedByTransport(opts *Options, matchFor HubType) []string {
+	if opts.TransportPolicy == nil || matchFor != HomeHub {
+		return nil
+	}
+
+	// Match without the transport policy.
+	withoutPolicy := opts.Copy()
+	withoutPolicy.TransportPolicy = nil
+	matcher := withoutPolicy.Matcher(matchFor)
+
+	var excluded []string
+	for _, pin := range m.all {
+		if matcher(pin) && !pin.Hub.GetInfo().HasPermittedTransport(opts.TransportPolicy) {
+			excluded = append(excluded, pin.Hub.ID)
+		}
+	}
+	sort.Strings(excluded)
+	return excluded
+}
@@ -0,0 +1,63 @@
+package navigator
+
+import (
+	"testing"
+
+	"github.com/safing/spn/hub"
+)
+
+func makeSelectionTestPin(id string, capacity, load int) *Pin {
+	return &Pin{
+		Hub: &hub.Hub{
+			ID:     id,
+			Status: &hub.Status{Load: load},
+		},
+		ConnectedTo: map[string]*Lane{
+			"peer": {Capacity: capacity},
+		},
+	}
+}
+
+func TestWeightedRandomSelection(t *testing.T) {
+	t.Parallel()
+
+	newNearby := func() *nearbyPins {
+		return &nearbyPins{
+			pins: []*nearbyPin{
+				{pin: makeSelectionTestPin("best-small", 1000000, 0), proximity: 90},
+				{pin: makeSelectionTestPin("big", 100000000, 0), proximity: 88},
+				{pin: makeSelectionTestPin("big-loaded", 100000000, 100), proximity: 87},
+				{pin: makeSelectionTestPin("far", 1000000000, 0), proximity: 50},
+			},
+		}
+	}
+
+	// Deterministic selection keeps the best Hub first.
+	nearby := newNearby()
+	explanation := nearby.applySelectionPolicy(SelectionPolicyDeterministic)
+	if nearby.pins[0].pin.Hub.ID != "best-small" || !explanation.Candidates[0].Chosen {
+		t.Fatalf("deterministic selection changed order: %s", explanation)
+	}
+
+	// Weighted random selection should mostly choose the big Hub and never the
+	// Hub outside of the quality band.
+	chosen := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		nearby := newNearby()
+		explanation := nearby.applySelectionPolicy(SelectionPolicyWeightedRandom)
+		if !explanation.Candidates[0].Chosen || explanation.Candidates[0].HubID != nearby.pins[0].pin.Hub.ID {
+			t.Fatalf("chosen hub is not first: %s", explanation)
+		}
+		if len(nearby.pins) != 4 {
+			t.Fatalf("selection changed amount of pins")
+		}
+		chosen[nearby.pins[0].pin.Hub.ID]++
+	}
+	t.Logf("chosen: %v", chosen)
+	if chosen["far"] > 0 {
+		t.Error("hub outside of quality band was chosen")
+	}
+	if chosen["big"] < chosen["best-small"] || chosen["big"] < chosen["big-loaded"] {
+		t.Error("hub with highest available capacity was not chosen most")
+	}
+}
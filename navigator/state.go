@@ -25,7 +25,7 @@ const (
 	StateSuperseded // 0x02
 
 	// StateFailing signifies that a recent error was encountered while
-	// communicating with this Hub. Pin.IgnoreUntil specifies when this state is
+	// communicating with this Hub. Pin.FailingUntil specifies when this state is
 	// re-evaluated at earliest.
 	StateFailing // 0x04
 
@@ -70,6 +70,19 @@ const (
 	// all cases.
 	StateIsHomeHub // 0x1000
 
+	// Health States
+
+	// StateClockSkewed signifies that the Hub published a status with a
+	// timestamp too far ahead of the local time, indicating a misconfigured
+	// clock. This is informational and does not disregard the Hub.
+	StateClockSkewed // 0x2000
+
+	// StateDegraded signifies that a crane to the Hub recently failed, but the
+	// Hub is still within the offline grace period. The Hub is deprioritized,
+	// but not disregarded. Pin.DegradedSince specifies when the grace period
+	// started.
+	StateDegraded // 0x4000
+
 	// State Summaries
 
 	// StateSummaryRegard summarizes all states that must always be set in order to take a Hub into consideration for any task.
@@ -99,6 +112,8 @@ var (
 		StateUsageAsHomeDiscouraged,
 		StateUsageAsDestinationDiscouraged,
 		StateIsHomeHub,
+		StateClockSkewed,
+		StateDegraded,
 	}
 )
 
@@ -356,6 +371,10 @@ func (pinState PinState) Name() string {
 		return "UsageAsDestinationDiscouraged"
 	case StateIsHomeHub:
 		return "IsHomeHub"
+	case StateClockSkewed:
+		return "ClockSkewed"
+	case StateDegraded:
+		return "Degraded"
 	default:
 		return "Unknown"
 	}
@@ -181,7 +181,7 @@ func (m *Map) updateHub(h *hub.Hub, lockMap, lockHub bool) {
 	m.updateInfoOverrides(pin)
 
 	// Update Hub cost.
-	pin.Cost = CalculateHubCost(pin.Hub.Status.Load)
+	pin.updateCost()
 
 	// Ensure measurements are set when enabled.
 	if m.measuringEnabled && pin.measurements == nil {
@@ -231,6 +231,13 @@ func (m *Map) updateHub(h *hub.Hub, lockMap, lockHub bool) {
 		pin.removeStates(StateInvalid)
 	}
 
+	// Update the clock skew status of the Pin.
+	if pin.Hub.ClockSkewed {
+		pin.addStates(StateClockSkewed)
+	} else {
+		pin.removeStates(StateClockSkewed)
+	}
+
 	// Update online status of the Pin.
 	if pin.Hub.Status.Version == hub.VersionOffline {
 		pin.addStates(StateOffline)
@@ -399,6 +406,11 @@ pinLoop:
 		if pin.State.has(StateFailing) && now.After(pin.FailingUntil) {
 			pin.removeStates(StateFailing)
 		}
+		// Expire stale degraded states. Degraded Hubs are only marked as
+		// failing when they fail again after the grace period.
+		if pin.expireDegraded(now, configuredOfflineGracePeriod(), configuredHubFailingDuration()) {
+			pin.pushChanges.Set()
+		}
 
 		// Check for discontinued Hubs.
 		if m.intel != nil {
@@ -434,7 +446,9 @@ pinLoop:
 	m.updateActiveHubs()
 
 	// Update StateReachable.
-	return m.recalculateReachableHubs()
+	err := m.recalculateReachableHubs()
+	m.triggerReachabilityCheck()
+	return err
 }
 
 // AddBootstrapHubs adds the given bootstrap hubs to the map
@@ -14,7 +14,11 @@ func Launch(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip net.IP
 	var ips []net.IP
 
 	// choose transports
+	policy := GetTransportPolicy()
 	if transport != nil {
+		if !policy.Permits(transport) {
+			return nil, fmt.Errorf("transport %s to %s: %w", transport, h, hub.ErrNoPermittedTransports)
+		}
 		transports = []*hub.Transport{transport}
 	} else {
 		if h.Info == nil {
@@ -22,15 +26,21 @@ func Launch(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip net.IP
 		}
 		for _, definition := range h.Info.Transports {
 			t, err := hub.ParseTransport(definition)
-			if err != nil {
+			switch {
+			case err != nil:
 				log.Warningf("spn/ships: failed to parse transport definition %s of %s: %s", definition, h, err)
-			} else {
+			case !policy.Permits(t):
+				log.Debugf("spn/ships: skipping transport %s of %s, as it is not permitted by the transport policy", definition, h)
+			default:
 				transports = append(transports, t)
 			}
 		}
 		if len(h.Info.Transports) == 0 {
 			return nil, hub.ErrMissingTransports
 		}
+		if len(transports) == 0 && policy != nil {
+			return nil, hub.ErrNoPermittedTransports
+		}
 	}
 
 	// choose IPs
@@ -16,8 +16,30 @@ const (
 
 var (
 	ErrSunk = errors.New("ship sunk")
+
+	// ErrLoadRetriable is returned by Load when loading failed temporarily
+	// before any data was sent, so that loading the same data again may
+	// succeed.
+	ErrLoadRetriable = errors.New("temporary load failure")
 )
 
+// IsRetriableLoadError returns whether the given error returned by Load is
+// temporary and loading the same data again may succeed. All other errors
+// must be regarded as fatal.
+func IsRetriableLoadError(err error) bool {
+	return errors.Is(err, ErrLoadRetriable)
+}
+
+// isTemporaryNetError returns whether the given error is a temporary network
+// error.
+func isTemporaryNetError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+	return false
+}
+
 // Ship represents a network layer connection.
 type Ship interface {
 	// String returns a human readable informational summary about the ship.
@@ -45,6 +67,8 @@ type Ship interface {
 
 	// Load loads data into the ship - ie. sends the data via the connection.
 	// Returns ErrSunk if the ship has already sunk earlier.
+	// Returns an error wrapping ErrLoadRetriable if loading failed temporarily
+	// and no data was sent, so that the caller may retry.
 	Load(data []byte) error
 
 	// UnloadTo unloads data from the ship - ie. receives data from the
@@ -157,17 +181,30 @@ func (ship *ShipBase) Load(data []byte) error {
 	}
 
 	// Send all given data.
-	n, err := ship.conn.Write(data)
-	switch {
-	case err != nil:
-		return err
-	case n == 0:
-		return errors.New("loaded 0 bytes")
-	case n < len(data):
-		// If not all data was sent, try again.
-		log.Debugf("spn/ships: %s only loaded %d/%d bytes", ship, n, len(data))
-		data = data[n:]
-		return ship.Load(data)
+	var loaded int
+	for loaded < len(data) {
+		n, err := ship.conn.Write(data[loaded:])
+		loaded += n
+		switch {
+		case loaded > 0 && loaded < len(data) && (err != nil || n == 0):
+			// A partial load cannot be retried with the same data, as the
+			// other side already received a part of it.
+			if err == nil {
+				err = errors.New("loaded 0 bytes")
+			}
+			return fmt.Errorf("failed after loading %d/%d bytes: %w", loaded, len(data), err)
+		case err != nil:
+			// Only classify as retriable if nothing was sent.
+			if loaded == 0 && isTemporaryNetError(err) {
+				return fmt.Errorf("%w: %s", ErrLoadRetriable, err)
+			}
+			return err
+		case n == 0:
+			return fmt.Errorf("%w: loaded 0 bytes", ErrLoadRetriable)
+		case loaded < len(data):
+			// If not all data was sent, try again.
+			log.Debugf("spn/ships: %s only loaded %d/%d bytes", ship, loaded, len(data))
+		}
 	}
 
 	return nil
@@ -0,0 +1,85 @@
+package ships
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// writeStubConn is a net.Conn that returns the configured write results.
+// The results in next are returned first, one per write.
+type writeStubConn struct {
+	net.Conn
+
+	n   int
+	err error
+
+	next []writeResult
+}
+
+type writeResult struct {
+	n   int
+	err error
+}
+
+func (c *writeStubConn) Write(b []byte) (int, error) {
+	if len(c.next) > 0 {
+		result := c.next[0]
+		c.next = c.next[1:]
+		return result.n, result.err
+	}
+	return c.n, c.err
+}
+
+func TestLoadErrorClassification(t *testing.T) {
+	t.Parallel()
+
+	newShip := func(n int, err error) *ShipBase {
+		ship := &ShipBase{
+			conn: &writeStubConn{n: n, err: err},
+		}
+		ship.initBase()
+		return ship
+	}
+	data := []byte("test data")
+
+	// Temporary errors without any data sent are retriable.
+	if err := newShip(0, timeoutError{}).Load(data); !IsRetriableLoadError(err) {
+		t.Errorf("timeout without data sent should be retriable, got %v", err)
+	}
+
+	// Nothing sent without an error is retriable.
+	if err := newShip(0, nil).Load(data); !IsRetriableLoadError(err) {
+		t.Errorf("zero bytes sent should be retriable, got %v", err)
+	}
+
+	// Partial writes cannot be retried.
+	if err := newShip(2, timeoutError{}).Load(data); err == nil || IsRetriableLoadError(err) {
+		t.Errorf("partial write should be fatal, got %v", err)
+	}
+
+	// A temporary failure after a partial write cannot be retried.
+	partialShip := newShip(0, timeoutError{})
+	partialShip.conn.(*writeStubConn).next = []writeResult{{n: 2}}
+	if err := partialShip.Load(data); err == nil || IsRetriableLoadError(err) {
+		t.Errorf("failure after partial write should be fatal, got %v", err)
+	}
+
+	// Partial writes are continued.
+	completingShip := newShip(len(data)-2, nil)
+	completingShip.conn.(*writeStubConn).next = []writeResult{{n: 2}}
+	if err := completingShip.Load(data); err != nil {
+		t.Errorf("continued partial write should succeed, got %v", err)
+	}
+
+	// Other errors are fatal.
+	if err := newShip(0, errors.New("connection reset")).Load(data); err == nil || IsRetriableLoadError(err) {
+		t.Errorf("connection error should be fatal, got %v", err)
+	}
+}
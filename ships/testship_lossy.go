@@ -0,0 +1,132 @@
+package ships
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// LossyTestShipOptions defines how a LossyTestShip disturbs loaded data.
+// All rates are probabilities between 0 and 1 and are applied per call to
+// Load().
+type LossyTestShipOptions struct {
+	// DropRate is the probability of a frame being dropped.
+	DropRate float64
+	// DuplicateRate is the probability of a frame being sent twice.
+	DuplicateRate float64
+	// ReorderRate is the probability of a frame being held back and sent
+	// after the next frame.
+	ReorderRate float64
+	// MaxDelay is the maximum random delay before a frame is sent.
+	MaxDelay time.Duration
+	// SkipFrames defines how many frames are sent without disturbance before
+	// the other options apply, eg. to let a handshake complete.
+	SkipFrames int
+	// Seed is the seed for the random source, in order to be able to
+	// reproduce test runs.
+	Seed int64
+}
+
+// LossyTestShip is a TestShip that drops, duplicates, reorders and delays
+// loaded data in order to test higher level components under adverse
+// network conditions.
+type LossyTestShip struct {
+	*TestShip
+
+	opts LossyTestShipOptions
+
+	lock     sync.Mutex
+	rng      *rand.Rand
+	heldBack []byte
+	frames   int
+}
+
+// NewLossyTestShip returns a new LossyTestShip for simulation.
+func NewLossyTestShip(secure bool, loadSize int, opts LossyTestShipOptions) *LossyTestShip {
+	return &LossyTestShip{
+		TestShip: NewTestShip(secure, loadSize),
+		opts:     opts,
+		rng:      rand.New(rand.NewSource(opts.Seed)), //nolint:gosec // Only used for testing.
+	}
+}
+
+// Reverse creates a connected LossyTestShip that disturbs the other direction
+// with the same options. Use TestShip.Reverse() for a reliable reverse ship.
+func (ship *LossyTestShip) Reverse() *LossyTestShip {
+	opts := ship.opts
+	opts.Seed++
+	return &LossyTestShip{
+		TestShip: ship.TestShip.Reverse(),
+		opts:     opts,
+		rng:      rand.New(rand.NewSource(opts.Seed)), //nolint:gosec // Only used for testing.
+	}
+}
+
+// String returns a human readable informational summary about the ship.
+func (ship *LossyTestShip) String() string {
+	if ship.mine {
+		return "<LossyTestShip outbound>"
+	}
+	return "<LossyTestShip inbound>"
+}
+
+// Load loads data into the ship - ie. sends the data via the connection.
+// Returns ErrSunk if the ship has already sunk earlier.
+func (ship *LossyTestShip) Load(data []byte) error {
+	// Empty load is used as a signal to cease operation.
+	if len(data) == 0 {
+		return ship.TestShip.Load(data)
+	}
+
+	ship.lock.Lock()
+	defer ship.lock.Unlock()
+
+	// Send first frames without disturbance.
+	ship.frames++
+	if ship.frames <= ship.opts.SkipFrames {
+		return ship.TestShip.Load(data)
+	}
+
+	// Drop frame.
+	if ship.roll(ship.opts.DropRate) {
+		return nil
+	}
+
+	// Delay frame.
+	if ship.opts.MaxDelay > 0 {
+		time.Sleep(time.Duration(ship.rng.Int63n(int64(ship.opts.MaxDelay))))
+	}
+
+	// Hold back frame to send it after the next one.
+	// Copy the data, as the caller may reuse the buffer after returning.
+	if ship.heldBack == nil && ship.roll(ship.opts.ReorderRate) {
+		ship.heldBack = make([]byte, len(data))
+		copy(ship.heldBack, data)
+		return nil
+	}
+
+	// Send frame, possibly twice.
+	if err := ship.TestShip.Load(data); err != nil {
+		return err
+	}
+	if ship.roll(ship.opts.DuplicateRate) {
+		if err := ship.TestShip.Load(data); err != nil {
+			return err
+		}
+	}
+
+	// Send held back frame.
+	if ship.heldBack != nil {
+		heldBack := ship.heldBack
+		ship.heldBack = nil
+		return ship.TestShip.Load(heldBack)
+	}
+
+	return nil
+}
+
+// roll returns true with the given probability.
+// The ship must be locked.
+func (ship *LossyTestShip) roll(probability float64) bool {
+	return probability > 0 && ship.rng.Float64() < probability
+}
@@ -54,3 +54,57 @@ func TestTestShip(t *testing.T) {
 	ship.Sink()
 	srvShip.Sink()
 }
+
+func TestLossyTestShip(t *testing.T) {
+	// Without disturbances, the lossy ship must behave like a normal ship.
+	reliable := NewLossyTestShip(true, 100, LossyTestShipOptions{})
+	var _ Ship = reliable
+	reliableSrv := reliable.Reverse()
+	for i := 0; i < 10; i++ {
+		if err := reliable.Load(testData); err != nil {
+			t.Fatal(err)
+		}
+		buf := getTestBuf()
+		if _, err := reliableSrv.UnloadTo(buf); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, testData, buf, "should match")
+	}
+
+	// Dropping everything must not deliver anything.
+	dropping := NewLossyTestShip(true, 100, LossyTestShipOptions{DropRate: 1})
+	for i := 0; i < 10; i++ {
+		if err := dropping.Load(testData); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dropping.forward) != 0 {
+		t.Fatalf("expected no frames to be sent, got %d", len(dropping.forward))
+	}
+
+	// Duplicating everything must deliver twice.
+	duplicating := NewLossyTestShip(true, 100, LossyTestShipOptions{DuplicateRate: 1})
+	for i := 0; i < 10; i++ {
+		if err := duplicating.Load(testData); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(duplicating.forward) != 20 {
+		t.Fatalf("expected 20 frames to be sent, got %d", len(duplicating.forward))
+	}
+
+	// Reordering must swap frames.
+	reordering := NewLossyTestShip(true, 100, LossyTestShipOptions{ReorderRate: 1})
+	_ = reordering.Load([]byte{1})
+	_ = reordering.Load([]byte{2})
+	assert.Equal(t, []byte{2}, <-reordering.forward, "should be reordered")
+	assert.Equal(t, []byte{1}, <-reordering.forward, "should be reordered")
+
+	// Held back frames must not be changed by the caller reusing the buffer.
+	buf := []byte{3}
+	_ = reordering.Load(buf)
+	buf[0] = 4
+	_ = reordering.Load(buf)
+	assert.Equal(t, []byte{4}, <-reordering.forward, "should be reordered")
+	assert.Equal(t, []byte{3}, <-reordering.forward, "should keep held back data")
+}
@@ -0,0 +1,29 @@
+package ships
+
+import (
+	"sync"
+
+	"github.com/safing/spn/hub"
+)
+
+var (
+	transportPolicyLock sync.Mutex
+	transportPolicy     *hub.TransportPolicy
+)
+
+// SetTransportPolicy sets the policy that defines which transports may be
+// used to launch ships. A nil policy permits all transports.
+func SetTransportPolicy(policy *hub.TransportPolicy) {
+	transportPolicyLock.Lock()
+	defer transportPolicyLock.Unlock()
+
+	transportPolicy = policy
+}
+
+// GetTransportPolicy returns the current transport policy.
+func GetTransportPolicy() *hub.TransportPolicy {
+	transportPolicyLock.Lock()
+	defer transportPolicyLock.Unlock()
+
+	return transportPolicy
+}
@@ -0,0 +1,38 @@
+package terminal
+
+import (
+	"sync"
+
+	"github.com/safing/portbase/container"
+)
+
+// containerPool holds containers for reuse on the hot path of the flow queue.
+//
+// Containers passed to Deliver or DeliverBatch are owned by the flow queue.
+// Containers that only hold a space report are fully consumed by the flow
+// queue and are returned to the pool. Containers that hold data are handed to
+// the receiver via the receive queue and are never returned to the pool, as
+// they escape the flow queue. Containers that are discarded from the receive
+// queue by DrainRecvQueue are returned to the pool.
+// Containers taken from the pool for sending space reports are owned by the
+// upstream submit function and are not returned by the sender.
+var containerPool = sync.Pool{
+	New: func() interface{} {
+		return &container.Container{}
+	},
+}
+
+// getPooledContainer returns a container from the pool that holds the given
+// data.
+func getPooledContainer(data []byte) *container.Container {
+	c := containerPool.Get().(*container.Container)
+	c.Append(data)
+	return c
+}
+
+// releaseContainer resets the given container and returns it to the pool.
+// The container must not be referenced anywhere else anymore.
+func releaseContainer(c *container.Container) {
+	*c = container.Container{}
+	containerPool.Put(c)
+}
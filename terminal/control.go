@@ -0,0 +1,91 @@
+package terminal
+
+import (
+	"sync/atomic"
+
+	"github.com/safing/portbase/container"
+)
+
+// MaxControlMsgSize is the maximum size of the data of a control message.
+const MaxControlMsgSize = 512
+
+// controlSender is implemented by terminal extensions that can send control
+// messages before other waiting data, such as the DuplexFlowQueue.
+type controlSender interface {
+	SendControl(c *container.Container) *Error
+}
+
+// controlReceiver is implemented by terminal extensions that receive control
+// messages separately from other data, such as the DuplexFlowQueue.
+type controlReceiver interface {
+	ReceiveControl() <-chan *container.Container
+}
+
+// OpSendControl sends the given data as a control message. In contrast to
+// OpSend, the message is not buffered together with the messages of other
+// operations and it is sent before any data waiting in the send queue.
+// If the extension sends control messages directly, they also bypass the flow
+// control, so that they are sent even if the send space is exhausted.
+//
+// In order to prevent bulk data from skipping the queue, only operations
+// registered with OpParams.Control may send control messages and their data
+// is limited to MaxControlMsgSize. An operation qualifies as a control
+// operation if it exchanges a small and bounded amount of messages that does
+// not depend on any user data, and if it is sensitive to latency, such as
+// latency measurements and time synchronization.
+func (t *TerminalBase) OpSendControl(op Operation, data *container.Container) *Error {
+	// Check if the operation may send control messages.
+	if !isControlOpType(op.Type()) {
+		return ErrIncorrectUsage.With("operation %s is not a control operation", op.Type())
+	}
+	if data.Length() > MaxControlMsgSize {
+		return ErrIncorrectUsage.With("control message of %d bytes exceeds maximum of %d", data.Length(), MaxControlMsgSize)
+	}
+
+	// Use the regular path if the extension does not support control messages
+	// or the terminal is encrypted, as encrypted messages must be sent in the
+	// order they were encrypted in.
+	sender, ok := t.ext.(controlSender)
+	if !ok || t.opts.Encrypt {
+		if tErr := t.OpSend(op, data); tErr != nil {
+			return tErr
+		}
+		t.Flush()
+		return nil
+	}
+
+	// Make message and send it.
+	MakeMsg(data, op.ID(), MsgTypeData)
+	c, tErr := t.prepareOpMsgs(data)
+	if tErr != nil {
+		return tErr
+	}
+	atomic.StoreUint32(t.idleCounter, 0)
+	return sender.SendControl(c)
+}
+
+// SendControlMsg sends the given data as a control message, if the terminal
+// supports it. Otherwise, the data is sent with OpSend and flushed.
+func SendControlMsg(t OpTerminal, op Operation, data *container.Container) *Error {
+	if ct, ok := t.(interface {
+		OpSendControl(op Operation, data *container.Container) *Error
+	}); ok {
+		return ct.OpSendControl(op, data)
+	}
+
+	if tErr := t.OpSend(op, data); tErr != nil {
+		return tErr
+	}
+	t.Flush()
+	return nil
+}
+
+// isControlOpType returns whether the given operation type was registered as
+// a control operation.
+func isControlOpType(opType string) bool {
+	opRegistryLock.Lock()
+	defer opRegistryLock.Unlock()
+
+	params, ok := opRegistry[opType]
+	return ok && params.Control
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/safing/portbase/formats/varint"
 
@@ -15,17 +16,52 @@ const (
 	DefaultQueueSize        = 50000
 	MaxQueueSize            = 1000000
 	forceReportBelowPercent = 0.75
+
+	// controlQueueSize defines how many control messages may wait to be sent.
+	controlQueueSize = 10
+	// recvControlQueueSize defines how many received control messages may wait
+	// to be processed.
+	recvControlQueueSize = 100
+
+	// controlMsgFlowHeader is sent instead of the reported space in order to
+	// mark control messages that bypass the flow control. Space reports of 1
+	// are never sent, so the value is free to use.
+	controlMsgFlowHeader = 1
+
+	// windowAutoTuneIdleShrink defines after how long without received data an
+	// auto tuned receive window is halved.
+	windowAutoTuneIdleShrink = 10 * time.Second
 )
 
+// WindowAutoTuneInterval defines how often an auto tuned receive window is
+// adjusted.
+var WindowAutoTuneInterval = 500 * time.Millisecond
+
+// SendRawTimeout defines how long SendRaw waits for the upstream to accept raw
+// data before giving up.
+var SendRawTimeout = 5 * time.Second
+
 type DuplexFlowQueue struct {
 	// ti is the interface to the Terminal that is using the DFQ.
 	ti TerminalInterface
 
-	// upstream is the channel to put containers into to send them upstream.
+	// submitUpstream submits containers for sending upstream. It may block
+	// while the upstream is busy. It is only called by the FlowHandler.
 	submitUpstream func(*container.Container)
+	// rawSend hands raw data from SendRaw to the FlowHandler. It is
+	// unbuffered, so that data is only accepted when the FlowHandler is ready
+	// to submit it.
+	rawSend chan *container.Container
 
 	// sendQueue holds the containers that are waiting to be sent.
 	sendQueue chan *container.Container
+	// controlQueue holds control messages, which are sent before the
+	// containers in the sendQueue.
+	controlQueue chan *container.Container
+	// directControlMsgs indicates whether the other end accepts control
+	// messages that bypass the flow control. It is set with
+	// EnableDirectControlMsgs.
+	directControlMsgs *int32
 	// sendSpace indicates the amount free slots in the recvQueue on the other end.
 	sendSpace *int32
 	// readyToSend is used to notify sending components that there is free space.
@@ -36,6 +72,9 @@ type DuplexFlowQueue struct {
 
 	// recvQueue holds the containers that are waiting to be processed.
 	recvQueue chan *container.Container
+	// recvControlQueue holds received control messages, which bypass the flow
+	// control and are not accounted for in the recvQueue.
+	recvControlQueue chan *container.Container
 	// reportedSpace indicates the amount of free slots that the other end knows
 	// about.
 	reportedSpace *int32
@@ -44,43 +83,318 @@ type DuplexFlowQueue struct {
 	// forceSpaceReport forces the sender to send a space report.
 	forceSpaceReport chan struct{}
 
+	// recvOverflow holds received containers that do not fit into the
+	// recvQueue, if auto tuning is enabled. The recvQueue keeps the initial
+	// queue size, so that memory is only used when the window is actually
+	// needed. Containers are moved to the recvQueue by Receive.
+	recvOverflow     []*container.Container
+	recvOverflowLock sync.Mutex
+
+	// recvWindow is the current receive window, if auto tuning is enabled.
+	recvWindow *int32
+	// autoTune holds the state of the receive window auto tuning, if enabled.
+	autoTune *windowAutoTuner
+
+	// recorder records flow events for debugging, if enabled.
+	recorder *flowRecorder
+
+	// droppedRecv counts the received containers that were discarded by
+	// DrainRecvQueue instead of being processed.
+	droppedRecv *int32
+
+	// flowDesyncs counts the detected divergences of the flow control views of
+	// both ends.
+	flowDesyncs *int32
+
+	// sentBytes and recvBytes count the data bytes that passed the flow queue.
+	sentBytes *uint64
+	recvBytes *uint64
+
+	// maxMsgSize is the maximum size of a received container. If 0, the size
+	// set with SetMaxMsgSize is used.
+	maxMsgSize uint32
+
 	// flush is used to send a finish function to the handler, which will write
 	// all pending messages and then call the received function.
 	flush chan func()
 }
 
+// NewDuplexFlowQueue returns a new duplex flow queue that uses the same size
+// for the send and receive queues.
+// The given submitUpstream function may block until the upstream accepts the
+// container. It is only called by the FlowHandler, so that SendRaw can give up
+// on a busy upstream after SendRawTimeout.
 func NewDuplexFlowQueue(
 	ti TerminalInterface,
 	queueSize uint32,
 	submitUpstream func(*container.Container),
+) *DuplexFlowQueue {
+	return newDuplexFlowQueue(ti, queueSize, queueSize, submitUpstream)
+}
+
+// NewAsymmetricDuplexFlowQueue returns a new duplex flow queue with separate
+// sizes for the send and receive queues. The send queue size is also the
+// initial send space and must match the receive queue size of the other end.
+func NewAsymmetricDuplexFlowQueue(
+	ti TerminalInterface,
+	sendQueueSize uint32,
+	recvQueueSize uint32,
+	submitUpstream func(*container.Container),
+) (*DuplexFlowQueue, *Error) {
+	if sendQueueSize == 0 || sendQueueSize > MaxQueueSize {
+		return nil, ErrInvalidOptions.With("invalid send queue size %d", sendQueueSize)
+	}
+	if recvQueueSize == 0 || recvQueueSize > MaxQueueSize {
+		return nil, ErrInvalidOptions.With("invalid receive queue size %d", recvQueueSize)
+	}
+
+	return newDuplexFlowQueue(ti, sendQueueSize, recvQueueSize, submitUpstream), nil
+}
+
+func newDuplexFlowQueue(
+	ti TerminalInterface,
+	sendQueueSize uint32,
+	recvQueueSize uint32,
+	submitUpstream func(*container.Container),
 ) *DuplexFlowQueue {
 	dfq := &DuplexFlowQueue{
-		ti:               ti,
-		submitUpstream:   submitUpstream,
-		sendQueue:        make(chan *container.Container, queueSize),
-		sendSpace:        new(int32),
-		readyToSend:      make(chan struct{}),
-		wakeSender:       make(chan struct{}, 1),
-		recvQueue:        make(chan *container.Container, queueSize),
-		reportedSpace:    new(int32),
-		forceSpaceReport: make(chan struct{}, 1),
-		flush:            make(chan func()),
-	}
-	atomic.StoreInt32(dfq.sendSpace, int32(queueSize))
-	atomic.StoreInt32(dfq.reportedSpace, int32(queueSize))
+		ti:                ti,
+		submitUpstream:    submitUpstream,
+		rawSend:           make(chan *container.Container),
+		sendQueue:         make(chan *container.Container, sendQueueSize),
+		controlQueue:      make(chan *container.Container, controlQueueSize),
+		directControlMsgs: new(int32),
+		sendSpace:         new(int32),
+		readyToSend:       make(chan struct{}),
+		wakeSender:        make(chan struct{}, 1),
+		recvQueue:         make(chan *container.Container, recvQueueSize),
+		recvControlQueue:  make(chan *container.Container, recvControlQueueSize),
+		reportedSpace:     new(int32),
+		forceSpaceReport:  make(chan struct{}, 1),
+		droppedRecv:       new(int32),
+		flowDesyncs:       new(int32),
+		sentBytes:         new(uint64),
+		recvBytes:         new(uint64),
+		flush:             make(chan func()),
+	}
+	atomic.StoreInt32(dfq.sendSpace, int32(sendQueueSize))
+	atomic.StoreInt32(dfq.reportedSpace, int32(recvQueueSize))
+	dfq.EnableTracing(int(atomic.LoadInt32(&flowTracingEvents)))
 
 	return dfq
 }
 
+// SetMaxMsgSize sets the maximum size of received containers. Messages are
+// always received within a single container, so that oversized messages are
+// rejected before they are queued. If size is 0, the size set with
+// SetMaxMsgSize of the package is used.
+func (dfq *DuplexFlowQueue) SetMaxMsgSize(size uint32) {
+	dfq.maxMsgSize = size
+}
+
+// checkMsgSize checks if the received container is within the maximum
+// message size.
+func (dfq *DuplexFlowQueue) checkMsgSize(c *container.Container) *Error {
+	if maxSize := getMaxMsgSize(dfq.maxMsgSize); uint32(c.Length()) > maxSize {
+		return ErrMalformedData.With("received container of %d bytes exceeds maximum msg size of %d", c.Length(), maxSize)
+	}
+	return nil
+}
+
+// windowAutoTuner holds the state of the receive window auto tuning.
+type windowAutoTuner struct {
+	// minWindow is the smallest receive window, which is the initial queue size.
+	minWindow int32
+	// maxWindow is the largest receive window.
+	maxWindow int32
+	// getRTT returns the currently known round trip time of the link.
+	getRTT func() time.Duration
+
+	// received counts the containers received since the last tuning.
+	received int32
+	// lastTune holds when the receive window was last tuned.
+	lastTune time.Time
+	// idleSince holds since when no data was received.
+	idleSince time.Time
+}
+
+// EnableWindowAutoTuning enables auto tuning of the receive window. The window
+// is grown towards twice the measured bandwidth-delay product, up to
+// maxWindow, and shrunk again when idle. The initial queue size is used as the
+// minimum window. Received containers beyond the initial queue size are held
+// in an overflow that only grows when used. getRTT must return the round trip
+// time of the link or zero, if unknown.
+// It must be called before the flow queue is used.
+func (dfq *DuplexFlowQueue) EnableWindowAutoTuning(maxWindow uint32, getRTT func() time.Duration) {
+	if maxWindow > MaxQueueSize {
+		maxWindow = MaxQueueSize
+	}
+	minWindow := int32(cap(dfq.recvQueue))
+	if int32(maxWindow) <= minWindow || getRTT == nil {
+		return
+	}
+
+	dfq.recvWindow = new(int32)
+	atomic.StoreInt32(dfq.recvWindow, minWindow)
+	dfq.autoTune = &windowAutoTuner{
+		minWindow: minWindow,
+		maxWindow: int32(maxWindow),
+		getRTT:    getRTT,
+		lastTune:  time.Now(),
+	}
+}
+
+// queueRecv queues a received container for processing and returns whether
+// there was space for it.
+func (dfq *DuplexFlowQueue) queueRecv(c *container.Container) bool {
+	if dfq.autoTune == nil {
+		select {
+		case dfq.recvQueue <- c:
+			return true
+		default:
+			return false
+		}
+	}
+
+	dfq.recvOverflowLock.Lock()
+	defer dfq.recvOverflowLock.Unlock()
+
+	// Only use the recvQueue directly if nothing is waiting in the overflow, in
+	// order to keep the order.
+	if len(dfq.recvOverflow) == 0 {
+		select {
+		case dfq.recvQueue <- c:
+			return true
+		default:
+		}
+	}
+	// Space granted with a larger window may still be used after the window
+	// was shrunk, so only the max window is enforced.
+	if len(dfq.recvQueue)+len(dfq.recvOverflow) >= int(dfq.autoTune.maxWindow) {
+		return false
+	}
+	dfq.recvOverflow = append(dfq.recvOverflow, c)
+	return true
+}
+
+// refillRecvQueue moves waiting containers from the overflow to the recvQueue.
+func (dfq *DuplexFlowQueue) refillRecvQueue() {
+	dfq.recvOverflowLock.Lock()
+	defer dfq.recvOverflowLock.Unlock()
+
+	var moved int
+fill:
+	for moved < len(dfq.recvOverflow) {
+		select {
+		case dfq.recvQueue <- dfq.recvOverflow[moved]:
+			dfq.recvOverflow[moved] = nil
+			moved++
+		default:
+			break fill
+		}
+	}
+
+	// Free the overflow when it is empty.
+	if moved == len(dfq.recvOverflow) {
+		dfq.recvOverflow = nil
+	} else {
+		dfq.recvOverflow = dfq.recvOverflow[moved:]
+	}
+}
+
+// recvQueued returns the amount of received containers waiting to be
+// processed.
+func (dfq *DuplexFlowQueue) recvQueued() int {
+	if dfq.autoTune == nil {
+		return len(dfq.recvQueue)
+	}
+
+	dfq.recvOverflowLock.Lock()
+	defer dfq.recvOverflowLock.Unlock()
+
+	return len(dfq.recvQueue) + len(dfq.recvOverflow)
+}
+
+// recvQueueSize returns the max amount of received containers that may wait
+// to be processed.
+func (dfq *DuplexFlowQueue) recvQueueSize() int {
+	if dfq.autoTune != nil {
+		return int(dfq.autoTune.maxWindow)
+	}
+	return cap(dfq.recvQueue)
+}
+
+// getRecvWindow returns the current receive window.
+func (dfq *DuplexFlowQueue) getRecvWindow() int32 {
+	if dfq.recvWindow != nil {
+		return atomic.LoadInt32(dfq.recvWindow)
+	}
+	return int32(cap(dfq.recvQueue))
+}
+
+// tuneRecvWindow adjusts the receive window to the observed throughput and
+// returns whether the window was grown.
+// It must only be called by the flow handler.
+func (dfq *DuplexFlowQueue) tuneRecvWindow(now time.Time) (grown bool) {
+	at := dfq.autoTune
+	received := atomic.SwapInt32(&at.received, 0)
+	elapsed := now.Sub(at.lastTune)
+	at.lastTune = now
+	window := atomic.LoadInt32(dfq.recvWindow)
+
+	// Shrink the window when idle.
+	if received == 0 {
+		switch {
+		case at.idleSince.IsZero():
+			at.idleSince = now
+		case now.Sub(at.idleSince) >= windowAutoTuneIdleShrink && window > at.minWindow:
+			window /= 2
+			if window < at.minWindow {
+				window = at.minWindow
+			}
+			atomic.StoreInt32(dfq.recvWindow, window)
+			dfq.record(FlowEventWindow, window, dfq.recvQueued())
+			at.idleSince = now
+		}
+		return false
+	}
+	at.idleSince = time.Time{}
+
+	// Calculate the bandwidth-delay product in containers and aim for twice of
+	// it, so that the window does not limit the throughput.
+	rtt := at.getRTT()
+	if rtt <= 0 || elapsed <= 0 {
+		return false
+	}
+	throughput := float64(received) / elapsed.Seconds()
+	target := 2 * throughput * rtt.Seconds()
+	if target > float64(at.maxWindow) {
+		target = float64(at.maxWindow)
+	}
+	if int32(target) <= window {
+		return false
+	}
+
+	atomic.StoreInt32(dfq.recvWindow, int32(target))
+	dfq.record(FlowEventWindow, int32(target), dfq.recvQueued())
+	return true
+}
+
 // shouldReportRecvSpace returns whether the receive space should be reported.
 func (dfq *DuplexFlowQueue) shouldReportRecvSpace() bool {
-	return atomic.LoadInt32(dfq.reportedSpace) < int32(float32(cap(dfq.recvQueue))*forceReportBelowPercent)
+	return atomic.LoadInt32(dfq.reportedSpace) < int32(float32(dfq.getRecvWindow())*forceReportBelowPercent)
 }
 
 // decrementReportedRecvSpace decreases the reported recv space by 1 and
 // returns if the receive space should be reported.
 func (dfq *DuplexFlowQueue) decrementReportedRecvSpace() (shouldReportRecvSpace bool) {
-	return atomic.AddInt32(dfq.reportedSpace, -1) < int32(float32(cap(dfq.recvQueue))*forceReportBelowPercent)
+	return dfq.subtractReportedRecvSpace(1)
+}
+
+// subtractReportedRecvSpace decreases the reported recv space by n and
+// returns if the receive space should be reported.
+func (dfq *DuplexFlowQueue) subtractReportedRecvSpace(n int32) (shouldReportRecvSpace bool) {
+	return atomic.AddInt32(dfq.reportedSpace, -n) < int32(float32(dfq.getRecvWindow())*forceReportBelowPercent)
 }
 
 // getSendSpace returns the current send space.
@@ -114,10 +428,11 @@ func (dfq *DuplexFlowQueue) reportableRecvSpace() int32 {
 
 	// Calculate reportable receive space and add it to the reported space.
 	reportedSpace := atomic.LoadInt32(dfq.reportedSpace)
-	toReport := int32(cap(dfq.recvQueue)-len(dfq.recvQueue)) - reportedSpace
+	toReport := dfq.getRecvWindow() - int32(dfq.recvQueued()) - reportedSpace
 
 	// Never report values below zero.
-	// This can happen, as dfq.reportedSpace is decreased after a container is
+	// This can happen when an auto tuned receive window was shrunk, or as
+	// dfq.reportedSpace is decreased after a container is
 	// submitted to dfq.recvQueue by dfq.Deliver(). This race condition can only
 	// lower the space to report, not increase it. A simple check here solved
 	// this problem and keeps performance high.
@@ -142,6 +457,14 @@ func (dfq *DuplexFlowQueue) FlowHandler(_ context.Context) error {
 	var sendSpaceDepleted bool
 	var flushFinished func()
 
+	// Tune the receive window regularly, if enabled.
+	var tuneTicker <-chan time.Time
+	if dfq.autoTune != nil {
+		ticker := time.NewTicker(WindowAutoTuneInterval)
+		defer ticker.Stop()
+		tuneTicker = ticker.C
+	}
+
 sending:
 	for {
 		// If the send queue is depleted, wait to be woken.
@@ -154,15 +477,25 @@ sending:
 					continue sending
 				}
 
+			case c := <-dfq.rawSend:
+				// Raw data does not use send space.
+				dfq.submitUpstream(c)
+				continue sending
+
+			case c := <-dfq.directControlQueue():
+				// Direct control messages do not use send space.
+				dfq.submitControl(c)
+				continue sending
+
 			case <-dfq.forceSpaceReport:
 				// Forced reporting of space.
-				// We do not need to check if there is enough sending space, as there is
-				// no data included.
-				spaceToReport := dfq.reportableRecvSpace()
-				if spaceToReport > 0 {
-					dfq.submitUpstream(container.New(
-						varint.Pack64(uint64(spaceToReport)),
-					))
+				dfq.sendSpaceReport()
+				continue sending
+
+			case now := <-tuneTicker:
+				// Report new space immediately if the receive window was grown.
+				if dfq.tuneRecvWindow(now) {
+					dfq.sendSpaceReport()
 				}
 				continue sending
 
@@ -171,9 +504,32 @@ sending:
 			}
 		}
 
+		// Send raw data and control messages first.
+		select {
+		case c := <-dfq.rawSend:
+			dfq.submitUpstream(c)
+			continue sending
+		case c := <-dfq.controlQueue:
+			if dfq.sendControlMsg(c) {
+				sendSpaceDepleted = true
+			}
+			continue sending
+		default:
+		}
+
 		// Get Container from send queue.
 
 		select {
+		case c := <-dfq.rawSend:
+			// Send raw data.
+			dfq.submitUpstream(c)
+
+		case c := <-dfq.controlQueue:
+			// Send control message.
+			if dfq.sendControlMsg(c) {
+				sendSpaceDepleted = true
+			}
+
 		case dfq.readyToSend <- struct{}{}:
 			// Notify that we are ready to send.
 
@@ -185,14 +541,8 @@ sending:
 				return nil
 			}
 
-			// Prepend available receiving space and flow ID.
-			c.Prepend(varint.Pack64(uint64(dfq.reportableRecvSpace())))
-
-			// Submit for sending upstream.
-			dfq.submitUpstream(c)
-
-			// Decrease the send space and set flag if depleted.
-			if dfq.decrementSendSpace() <= 0 {
+			// Submit and set flag if send space is depleted.
+			if dfq.submitData(c) {
 				sendSpaceDepleted = true
 			}
 
@@ -204,14 +554,7 @@ sending:
 
 		case <-dfq.forceSpaceReport:
 			// Forced reporting of space.
-			// We do not need to check if there is enough sending space, as there is
-			// no data included.
-			spaceToReport := dfq.reportableRecvSpace()
-			if spaceToReport > 0 {
-				dfq.submitUpstream(container.New(
-					varint.Pack64(uint64(spaceToReport)),
-				))
-			}
+			dfq.sendSpaceReport()
 
 		case newFlushFinishedFn := <-dfq.flush:
 			// Signal immediately if send queue is empty.
@@ -230,12 +573,85 @@ sending:
 				}
 			}
 
+		case now := <-tuneTicker:
+			// Report new space immediately if the receive window was grown.
+			if dfq.tuneRecvWindow(now) {
+				dfq.sendSpaceReport()
+			}
+
 		case <-dfq.ti.Ctx().Done():
 			return nil
 		}
 	}
 }
 
+// submitData prepends the reportable receive space to the given container and
+// submits it for sending upstream. It returns whether the send space is
+// depleted afterwards. It must only be called by the FlowHandler.
+func (dfq *DuplexFlowQueue) submitData(c *container.Container) (sendSpaceDepleted bool) {
+	// Count data bytes.
+	atomic.AddUint64(dfq.sentBytes, uint64(c.Length()))
+
+	// Prepend available receiving space and flow ID.
+	recvQueueLen := dfq.recvQueued()
+	reportedSpace := dfq.reportableRecvSpace()
+	c.Prepend(varint.Pack64(uint64(reportedSpace)))
+
+	// Submit for sending upstream.
+	dfq.submitUpstream(c)
+
+	// Decrease the send space and check if depleted.
+	sendSpaceDepleted = dfq.decrementSendSpace() <= 0
+	dfq.record(FlowEventSubmitData, reportedSpace, recvQueueLen)
+	return sendSpaceDepleted
+}
+
+// directControlQueue returns the control queue, if control messages are sent
+// directly, or nil otherwise.
+func (dfq *DuplexFlowQueue) directControlQueue() <-chan *container.Container {
+	if atomic.LoadInt32(dfq.directControlMsgs) == 1 {
+		return dfq.controlQueue
+	}
+	return nil
+}
+
+// sendControlMsg sends the given control message directly, if the other end
+// supports it, or as regular data otherwise. It returns whether the send
+// space is depleted afterwards. It must only be called by the FlowHandler.
+func (dfq *DuplexFlowQueue) sendControlMsg(c *container.Container) (sendSpaceDepleted bool) {
+	if atomic.LoadInt32(dfq.directControlMsgs) == 1 {
+		dfq.submitControl(c)
+		return dfq.getSendSpace() <= 0
+	}
+	return dfq.submitData(c)
+}
+
+// submitControl marks the given container as a control message and submits
+// it for sending upstream. It neither reports receive space nor uses send
+// space. It must only be called by the FlowHandler.
+func (dfq *DuplexFlowQueue) submitControl(c *container.Container) {
+	// Count data bytes.
+	atomic.AddUint64(dfq.sentBytes, uint64(c.Length()))
+
+	// Mark as control message and submit for sending upstream.
+	c.Prepend(varint.Pack64(controlMsgFlowHeader))
+	dfq.submitUpstream(c)
+}
+
+// sendSpaceReport sends the reportable receive space without any data.
+// We do not need to check if there is enough sending space, as there is no
+// data included.
+func (dfq *DuplexFlowQueue) sendSpaceReport() {
+	recvQueueLen := dfq.recvQueued()
+	spaceToReport := dfq.reportableRecvSpace()
+	if spaceToReport > 0 {
+		dfq.submitUpstream(getPooledContainer(
+			varint.Pack64(uint64(spaceToReport)),
+		))
+		dfq.record(FlowEventSubmitReport, spaceToReport, recvQueueLen)
+	}
+}
+
 // Flush waits for all waiting data to be sent.
 func (dfq *DuplexFlowQueue) Flush() {
 	// Create channel and function for notifying.
@@ -256,6 +672,58 @@ func (dfq *DuplexFlowQueue) Flush() {
 	}
 }
 
+// FlushProgress requests a flush and returns a channel that reports how many
+// containers are still waiting to be sent, checked in the given interval.
+// The channel is closed when the flush has finished or the terminal stops.
+// The reported values are only a snapshot, so readers that fall behind only
+// receive the latest value. Callers may stop receiving at any time in order to
+// bail out of a flush that is taking too long - the queued data will still be
+// sent, but nobody waits for it anymore.
+func (dfq *DuplexFlowQueue) FlushProgress(interval time.Duration) <-chan int {
+	progress := make(chan int, 1)
+
+	// Create channel and function for notifying.
+	wait := make(chan struct{})
+	finished := func() {
+		close(wait)
+	}
+
+	// Request flush and return when stopping.
+	select {
+	case dfq.flush <- finished:
+	case <-dfq.ti.Ctx().Done():
+		close(progress)
+		return progress
+	}
+
+	// Report progress until the flush has finished.
+	module.StartWorker("flush progress reporter", func(_ context.Context) error {
+		defer close(progress)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// Replace an unread value with the current one.
+				select {
+				case <-progress:
+				default:
+				}
+				progress <- len(dfq.sendQueue)
+
+			case <-wait:
+				return nil
+			case <-dfq.ti.Ctx().Done():
+				return nil
+			}
+		}
+	})
+
+	return progress
+}
+
 var ready = make(chan struct{})
 
 func init() {
@@ -270,6 +738,40 @@ func (dfq *DuplexFlowQueue) ReadyToSend() <-chan struct{} {
 	return dfq.readyToSend
 }
 
+// Pressure returns a graduated measure of how congested the sending side is,
+// from 0 (no pressure) to 1 (full pressure). Producers may use it to slow
+// down generating data before Send starts to block.
+//
+// The pressure is the amount of containers waiting in the send queue relative
+// to the amount of containers that the other end is currently able to accept,
+// as signaled by sendSpace. It reaches 1 when the queued containers fill up all
+// of the available sendSpace or when the send queue itself is full. In
+// contrast, ReadyToSend only signals whether there is any sendSpace left.
+func (dfq *DuplexFlowQueue) Pressure() float64 {
+	queued := len(dfq.sendQueue)
+	if queued == 0 {
+		return 0
+	}
+
+	// Check if the send queue is full, which makes Send block.
+	if queued >= cap(dfq.sendQueue) {
+		return 1
+	}
+
+	// Calculate pressure relative to the available send space.
+	sendSpace := dfq.getSendSpace()
+	if sendSpace < 0 {
+		sendSpace = 0
+	}
+	pressure := float64(queued) / float64(int32(queued)+sendSpace)
+
+	// Also regard how full the send queue is.
+	if queueFill := float64(queued) / float64(cap(dfq.sendQueue)); queueFill > pressure {
+		pressure = queueFill
+	}
+	return pressure
+}
+
 // Send adds the given container to the send queue.
 func (dfq *DuplexFlowQueue) Send(c *container.Container) *Error {
 	select {
@@ -280,10 +782,54 @@ func (dfq *DuplexFlowQueue) Send(c *container.Container) *Error {
 	}
 }
 
-// SendRaw sends the given raw data without any further processing.
+// EnableDirectControlMsgs enables sending control messages directly upstream,
+// without using send space. It must only be enabled if the other end is
+// known to handle control messages, as it would otherwise account for them
+// like for any other received container.
+func (dfq *DuplexFlowQueue) EnableDirectControlMsgs() {
+	atomic.StoreInt32(dfq.directControlMsgs, 1)
+}
+
+// SendControl adds the given control message to the control queue, which is
+// sent before any containers waiting in the send queue. If direct control
+// messages are enabled, they bypass the flow control and are sent even if the
+// send space is exhausted. Otherwise, they still use the send space, as the
+// other end accounts for every received container. If the control queue is
+// full, the message is added to the send queue like with Send. Use
+// TerminalBase.OpSendControl instead of calling this directly.
+func (dfq *DuplexFlowQueue) SendControl(c *container.Container) *Error {
+	select {
+	case dfq.controlQueue <- c:
+		return nil
+	default:
+		return dfq.Send(c)
+	}
+}
+
+// SendRaw sends the given raw data without any further processing. The data
+// bypasses the send queue and flow control and is handed to the FlowHandler,
+// which submits it upstream before any queued data. If the FlowHandler does
+// not accept the data within SendRawTimeout, eg. because it is waiting for a
+// stuck upstream, SendRaw returns ErrTimeout and the data is never sent.
 func (dfq *DuplexFlowQueue) SendRaw(c *container.Container) *Error {
-	dfq.submitUpstream(c)
-	return nil
+	// Hand over directly if the FlowHandler is waiting.
+	select {
+	case dfq.rawSend <- c:
+		return nil
+	default:
+	}
+
+	// Otherwise, wait for the FlowHandler to catch up.
+	timer := time.NewTimer(SendRawTimeout)
+	defer timer.Stop()
+	select {
+	case dfq.rawSend <- c:
+		return nil
+	case <-timer.C:
+		return ErrTimeout.With("upstream did not accept raw data within %s", SendRawTimeout)
+	case <-dfq.ti.Ctx().Done():
+		return ErrStopping
+	}
 }
 
 // Receive receives a container from the recv queue.
@@ -296,10 +842,42 @@ func (dfq *DuplexFlowQueue) Receive() <-chan *container.Container {
 		}
 	}
 
+	// Move waiting containers from the overflow.
+	if dfq.autoTune != nil {
+		dfq.refillRecvQueue()
+	}
+
 	return dfq.recvQueue
 }
 
+// ReceiveControl receives a control message from the control recv queue.
+// Control messages should be handled before containers from Receive.
+func (dfq *DuplexFlowQueue) ReceiveControl() <-chan *container.Container {
+	return dfq.recvControlQueue
+}
+
+// deliverControl queues a received control message for processing. Control
+// messages are not accounted for in the receive space.
+func (dfq *DuplexFlowQueue) deliverControl(c *container.Container) *Error {
+	if !c.HoldsData() {
+		return ErrMalformedData.With("received empty control message")
+	}
+	if tErr := dfq.checkMsgSize(c); tErr != nil {
+		return tErr
+	}
+
+	dataLen := c.Length()
+	select {
+	case dfq.recvControlQueue <- c:
+	default:
+		return ErrQueueOverflow.With("control recv queue is full")
+	}
+	atomic.AddUint64(dfq.recvBytes, uint64(dataLen))
+	return nil
+}
+
 // Deliver submits a container for receiving from upstream.
+// The flow queue takes ownership of the container.
 func (dfq *DuplexFlowQueue) Deliver(c *container.Container) *Error {
 	// Ignore nil containers.
 	if c == nil {
@@ -311,42 +889,193 @@ func (dfq *DuplexFlowQueue) Deliver(c *container.Container) *Error {
 	if err != nil {
 		return ErrMalformedData.With("failed to parse reported space: %w", err)
 	}
+	if addSpace == controlMsgFlowHeader {
+		return dfq.deliverControl(c)
+	}
 	if addSpace > 0 {
 		dfq.addToSendSpace(int32(addSpace))
 	}
 	// Abort processing if the container only contained a space update.
+	recvQueueLen := dfq.recvQueued()
 	if !c.HoldsData() {
+		dfq.record(FlowEventDeliverReport, int32(addSpace), recvQueueLen)
+		releaseContainer(c)
 		return nil
 	}
 
-	select {
-	case dfq.recvQueue <- c:
-		// If the recv queue accepted the Container, decrement the recv space.
-		shouldReportRecvSpace := dfq.decrementReportedRecvSpace()
-		// If the reported recv space is nearing its end, force a report, if the
-		// sender worker is idle.
-		if shouldReportRecvSpace {
+	if tErr := dfq.checkMsgSize(c); tErr != nil {
+		return tErr
+	}
+
+	dataLen := c.Length()
+	if !dfq.queueRecv(c) {
+		// If the recv queue is full, return an error.
+		// The whole point of the flow queue is to guarantee that this never happens.
+		return ErrQueueOverflow
+	}
+	atomic.AddUint64(dfq.recvBytes, uint64(dataLen))
+
+	// Count received containers for tuning the receive window.
+	if dfq.autoTune != nil {
+		atomic.AddInt32(&dfq.autoTune.received, 1)
+	}
+
+	// If the recv queue accepted the Container, decrement the recv space.
+	shouldReportRecvSpace := dfq.decrementReportedRecvSpace()
+	dfq.record(FlowEventDeliverData, int32(addSpace), recvQueueLen)
+	// If the reported recv space is nearing its end, force a report, if the
+	// sender worker is idle.
+	if shouldReportRecvSpace {
+		select {
+		case dfq.forceSpaceReport <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// DeliverBatch submits multiple containers for receiving from upstream. It
+// behaves like calling Deliver for every container, but does the space
+// accounting only once for the whole batch. Processing stops at the first
+// error, such as a queue overflow, and the containers before the failed one
+// stay delivered. The flow queue takes ownership of all processed containers.
+func (dfq *DuplexFlowQueue) DeliverBatch(cs []*container.Container) *Error {
+	// Deliver one by one if tracing is enabled, so that the recorded events
+	// hold the exact state and can be replayed.
+	if dfq.recorder != nil {
+		for _, c := range cs {
+			if tErr := dfq.Deliver(c); tErr != nil {
+				return tErr
+			}
+		}
+		return nil
+	}
+
+	var (
+		addSpace       int32
+		delivered      int32
+		deliveredBytes uint64
+		tErr           *Error
+	)
+deliver:
+	for _, c := range cs {
+		// Ignore nil containers.
+		if c == nil {
+			tErr = ErrMalformedData.With("no data")
+			break
+		}
+
+		// Get new reported space.
+		space, err := c.GetNextN16()
+		if err != nil {
+			tErr = ErrMalformedData.With("failed to parse reported space: %w", err)
+			break
+		}
+		if space == controlMsgFlowHeader {
+			if tErr = dfq.deliverControl(c); tErr != nil {
+				break
+			}
+			continue
+		}
+		addSpace += int32(space)
+		// Continue with next container if this one only contained a space update.
+		if !c.HoldsData() {
+			releaseContainer(c)
+			continue
+		}
+
+		if tErr = dfq.checkMsgSize(c); tErr != nil {
+			break
+		}
+
+		dataLen := c.Length()
+		if !dfq.queueRecv(c) {
+			// If the recv queue is full, return an error.
+			// The whole point of the flow queue is to guarantee that this never happens.
+			tErr = ErrQueueOverflow
+			break deliver
+		}
+		delivered++
+		deliveredBytes += uint64(dataLen)
+	}
+
+	// Add new reported space of all processed containers.
+	if addSpace > 0 {
+		dfq.addToSendSpace(addSpace)
+	}
+
+	if delivered > 0 {
+		atomic.AddUint64(dfq.recvBytes, deliveredBytes)
+
+		// Count received containers for tuning the receive window.
+		if dfq.autoTune != nil {
+			atomic.AddInt32(&dfq.autoTune.received, delivered)
+		}
+
+		// Decrement the recv space by the delivered containers and force a
+		// report, if the reported recv space is nearing its end and the sender
+		// worker is idle.
+		if dfq.subtractReportedRecvSpace(delivered) {
 			select {
 			case dfq.forceSpaceReport <- struct{}{}:
 			default:
 			}
 		}
+	}
 
-		return nil
-	default:
-		// If the recv queue is full, return an error.
-		// The whole point of the flow queue is to guarantee that this never happens.
-		return ErrQueueOverflow
+	return tErr
+}
+
+// DrainRecvQueue discards all containers waiting in the receive queue and
+// returns how many were discarded. It is intended to be called when the
+// owner of the flow queue shuts down, in order to detect whether received
+// data was lost. The discarded containers were already sent by the other end,
+// so their space is not reported again.
+func (dfq *DuplexFlowQueue) DrainRecvQueue() (dropped int) {
+	dfq.recvOverflowLock.Lock()
+	overflow := dfq.recvOverflow
+	dfq.recvOverflow = nil
+	dfq.recvOverflowLock.Unlock()
+
+	// Return the drained containers to the pool, as they were never handed to
+	// the receiver.
+	for _, c := range overflow {
+		releaseContainer(c)
 	}
+	dropped = len(overflow)
+
+	for {
+		select {
+		case c := <-dfq.recvQueue:
+			releaseContainer(c)
+			dropped++
+		case c := <-dfq.recvControlQueue:
+			releaseContainer(c)
+			dropped++
+		default:
+			atomic.AddInt32(dfq.droppedRecv, int32(dropped))
+			return dropped
+		}
+	}
+}
+
+// DroppedRecvContainers returns the total amount of received containers that
+// were discarded by DrainRecvQueue.
+func (dfq *DuplexFlowQueue) DroppedRecvContainers() int {
+	return int(atomic.LoadInt32(dfq.droppedRecv))
 }
 
 // FlowStats returns a k=v formatted string of internal stats.
 func (dfq *DuplexFlowQueue) FlowStats() string {
 	return fmt.Sprintf(
-		"sq=%d rq=%d sends=%d reps=%d",
+		"sq=%d rq=%d sends=%d reps=%d win=%d drop=%d desync=%d",
 		len(dfq.sendQueue),
-		len(dfq.recvQueue),
+		dfq.recvQueued(),
 		atomic.LoadInt32(dfq.sendSpace),
 		atomic.LoadInt32(dfq.reportedSpace),
+		dfq.getRecvWindow(),
+		atomic.LoadInt32(dfq.droppedRecv),
+		atomic.LoadInt32(dfq.flowDesyncs),
 	)
 }
@@ -0,0 +1,65 @@
+package terminal
+
+import (
+	"sync/atomic"
+)
+
+// FlowStatsStruct is a snapshot of the internal state of a DuplexFlowQueue.
+type FlowStatsStruct struct {
+	// SendQueued and RecvQueued are the amount of containers waiting in the
+	// send and receive queues.
+	SendQueued    int
+	SendQueueSize int
+	RecvQueued    int
+	RecvQueueSize int
+
+	// SendSpace is the amount of containers the other end is able to accept.
+	SendSpace int32
+	// ReportedSpace is the amount of free receive slots the other end knows
+	// about.
+	ReportedSpace int32
+	// RecvWindow is the current receive window, if auto tuning is enabled.
+	RecvWindow int32
+
+	// Pressure is the send pressure, as returned by Pressure().
+	Pressure float64
+	// Backpressured is set if containers are waiting to be sent, but the other
+	// end cannot accept any more or the send queue is full.
+	Backpressured bool
+
+	DroppedRecv int
+	Desyncs     int
+
+	// BytesSent and BytesReceived are the data bytes that passed the flow
+	// queue, excluding flow control overhead.
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+// FlowStatsProvider is implemented by terminals that provide flow stats.
+type FlowStatsProvider interface {
+	GetFlowStats() FlowStatsStruct
+}
+
+// GetFlowStats returns a snapshot of the internal state of the flow queue.
+// The values are read individually and are not synchronized with each other.
+func (dfq *DuplexFlowQueue) GetFlowStats() FlowStatsStruct {
+	stats := FlowStatsStruct{
+		SendQueued:    len(dfq.sendQueue),
+		SendQueueSize: cap(dfq.sendQueue),
+		RecvQueued:    dfq.recvQueued(),
+		RecvQueueSize: dfq.recvQueueSize(),
+		SendSpace:     atomic.LoadInt32(dfq.sendSpace),
+		ReportedSpace: atomic.LoadInt32(dfq.reportedSpace),
+		RecvWindow:    dfq.getRecvWindow(),
+		Pressure:      dfq.Pressure(),
+		DroppedRecv:   int(atomic.LoadInt32(dfq.droppedRecv)),
+		Desyncs:       int(atomic.LoadInt32(dfq.flowDesyncs)),
+		BytesSent:     atomic.LoadUint64(dfq.sentBytes),
+		BytesReceived: atomic.LoadUint64(dfq.recvBytes),
+	}
+	stats.Backpressured = stats.SendQueued > 0 &&
+		(stats.SendSpace <= 0 || stats.SendQueued >= stats.SendQueueSize)
+
+	return stats
+}
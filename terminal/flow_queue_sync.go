@@ -0,0 +1,172 @@
+package terminal
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+// FlowSyncOpType is the type name of the flow sync check operation.
+const FlowSyncOpType string = "flow/sync"
+
+// minFlowDesyncTolerance is the minimum divergence of the flow control views
+// that is tolerated, regardless of the window size.
+const minFlowDesyncTolerance = 10
+
+var (
+	// FlowDesyncTolerance defines the tolerated divergence of the flow control
+	// views of both ends, relative to the receive window. Some divergence is
+	// expected, as data and space reports may be in flight during the check.
+	FlowDesyncTolerance = 0.1
+
+	// ResetFlowOnDesync defines whether the send space is reset to the view of
+	// the other end when a desync is detected. The receiving end is the
+	// authority on its own queue, so its view is used. The reset is skipped
+	// while data is queued on either end, as the views are expected to differ
+	// then.
+	ResetFlowOnDesync = false
+)
+
+// FlowSyncState is the view of one end on the flow control state. It is the
+// request and response of the flow sync check operation.
+type FlowSyncState struct {
+	// SendSpace is the space the end thinks it may still use on the other end.
+	SendSpace int32 `json:"s"`
+	// ReportedSpace is the space the end granted to the other end.
+	ReportedSpace int32 `json:"r"`
+	// Window is the receive window of the end.
+	Window int32 `json:"w"`
+	// Queued is the amount of data queued for sending or receiving on the end.
+	Queued int32 `json:"q"`
+}
+
+// flowSyncer is a terminal that uses a DuplexFlowQueue.
+type flowSyncer interface {
+	FlowSyncState() FlowSyncState
+	checkFlowSync(remote FlowSyncState, fmtID string) (divergence int32)
+}
+
+func init() {
+	RegisterRequestResponseOpType(RequestResponseParams{
+		Type:     FlowSyncOpType,
+		Requires: IsCraneController,
+		NewRequest: func() interface{} {
+			return &FlowSyncState{}
+		},
+		Handle: func(t OpTerminal, request interface{}) (interface{}, *Error) {
+			fs, ok := t.(flowSyncer)
+			if !ok {
+				return nil, ErrIncorrectUsage.With("terminal does not use flow control")
+			}
+
+			// Check the view of the other end and reply with our own.
+			local := fs.FlowSyncState()
+			fs.checkFlowSync(*request.(*FlowSyncState), t.FmtID())
+			return &local, nil
+		},
+	})
+}
+
+// FlowSyncState returns the current view on the flow control state.
+func (dfq *DuplexFlowQueue) FlowSyncState() FlowSyncState {
+	return FlowSyncState{
+		SendSpace:     dfq.getSendSpace(),
+		ReportedSpace: atomic.LoadInt32(dfq.reportedSpace),
+		Window:        dfq.getRecvWindow(),
+		Queued:        int32(len(dfq.sendQueue) + dfq.recvQueued()),
+	}
+}
+
+// checkFlowSync compares the send space with the space the other end reports
+// to have granted and returns the divergence, if it exceeds the tolerance.
+// A negative divergence means that more is sent than the other end can take,
+// which leads to queue overflows. A positive divergence means that granted
+// space is lost, which slows down or stalls sending.
+func (dfq *DuplexFlowQueue) checkFlowSync(remote FlowSyncState, fmtID string) (divergence int32) {
+	// Calculate tolerance from the window of the other end.
+	tolerance := int32(float64(remote.Window) * FlowDesyncTolerance)
+	if tolerance < minFlowDesyncTolerance {
+		tolerance = minFlowDesyncTolerance
+	}
+
+	// Compare views.
+	divergence = remote.ReportedSpace - dfq.getSendSpace()
+	if divergence >= -tolerance && divergence <= tolerance {
+		return 0
+	}
+	atomic.AddInt32(dfq.flowDesyncs, 1)
+
+	// Report and reset, if enabled.
+	if divergence < 0 {
+		log.Warningf(
+			"spn/terminal: %s flow control desync: send space exceeds granted space by %d, expect queue overflows (%s)",
+			fmtID, -divergence, dfq.FlowStats(),
+		)
+	} else {
+		log.Warningf(
+			"spn/terminal: %s flow control desync: %d of granted space is unaccounted for, expect stalls (%s)",
+			fmtID, divergence, dfq.FlowStats(),
+		)
+	}
+	if ResetFlowOnDesync && remote.Queued == 0 && len(dfq.sendQueue) == 0 && dfq.recvQueued() == 0 {
+		dfq.addToSendSpace(divergence)
+		log.Infof("spn/terminal: %s reset send space by %d to resync flow control", fmtID, divergence)
+	}
+
+	return divergence
+}
+
+// FlowDesyncs returns how often the flow control views of both ends were
+// found to diverge. Together with queue overflows, this is a signal for a
+// broken flow control.
+func (dfq *DuplexFlowQueue) FlowDesyncs() int {
+	return int(atomic.LoadInt32(dfq.flowDesyncs))
+}
+
+// CheckFlowSync exchanges the views on the flow control state with the other
+// end of the given terminal. Both ends check the view of the other and report
+// a desync. The local divergence is returned, which is zero if within the
+// tolerance.
+func CheckFlowSync(t OpTerminal) (divergence int32, tErr *Error) {
+	fs, ok := t.(flowSyncer)
+	if !ok {
+		return 0, ErrIncorrectUsage.With("terminal does not use flow control")
+	}
+
+	// Exchange views.
+	response := &FlowSyncState{}
+	op, tErr := NewRequestResponseOp(t, FlowSyncOpType, fs.FlowSyncState(), response)
+	if tErr != nil {
+		return 0, tErr
+	}
+	if tErr := op.Wait(0); tErr != nil {
+		return 0, tErr
+	}
+
+	return fs.checkFlowSync(*response, t.FmtID()), nil
+}
+
+// StartFlowSyncChecks periodically checks the flow control state of the given
+// terminal until the context is canceled.
+func StartFlowSyncChecks(ctx context.Context, t OpTerminal, interval time.Duration) {
+	module.StartWorker("flow sync checker", func(_ context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, tErr := CheckFlowSync(t); tErr != nil {
+					if tErr.IsOK() {
+						return nil
+					}
+					log.Debugf("spn/terminal: %s failed to check flow sync: %s", t.FmtID(), tErr)
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+}
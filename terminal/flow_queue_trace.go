@@ -0,0 +1,413 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+)
+
+// flowTracingEvents defines how many events are recorded by new flow queues.
+// It must be accessed atomically.
+var flowTracingEvents int32
+
+// SetFlowTracingEvents sets how many events are recorded by new flow queues.
+// Set to zero to disable tracing. Only the most recent events are kept, so
+// tracing may be left enabled for debugging hangs in the field.
+func SetFlowTracingEvents(maxEvents int) {
+	if maxEvents < 0 {
+		maxEvents = 0
+	}
+	atomic.StoreInt32(&flowTracingEvents, int32(maxEvents))
+}
+
+// flowTraceVersion is the version of the binary flow trace format.
+const flowTraceVersion = 1
+
+// Flow Trace Event Types.
+const (
+	// FlowEventSubmitData is recorded when data is submitted upstream. The
+	// value is the receive space that was reported along with the data.
+	FlowEventSubmitData uint8 = iota + 1
+	// FlowEventSubmitReport is recorded when a space report without data is
+	// submitted upstream. The value is the reported receive space.
+	FlowEventSubmitReport
+	// FlowEventDeliverData is recorded when data is delivered from upstream.
+	// The value is the send space that was added by the other end.
+	FlowEventDeliverData
+	// FlowEventDeliverReport is recorded when a space report without data is
+	// delivered from upstream. The value is the added send space.
+	FlowEventDeliverReport
+	// FlowEventWindow is recorded when the receive window was tuned. The value
+	// is the new receive window.
+	FlowEventWindow
+)
+
+// FlowTraceEvent is a recorded event of a flow queue.
+type FlowTraceEvent struct {
+	// Seq is the sequence number of the event.
+	Seq uint64
+	// Time is the time since the start of the trace.
+	Time time.Duration
+	// Type is the type of the event.
+	Type uint8
+	// Value is the event value, as defined by the event type.
+	Value int32
+
+	// RecvQueueLen is the length of the receive queue before the event.
+	RecvQueueLen int32
+	// SendQueueLen is the length of the send queue after the event.
+	SendQueueLen int32
+	// SendSpace is the send space after the event.
+	SendSpace int32
+	// ReportedSpace is the reported receive space after the event.
+	ReportedSpace int32
+}
+
+// FlowTrace holds recorded events of a flow queue.
+type FlowTrace struct {
+	// QueueSize is the initial size of the receive queue.
+	QueueSize int32
+	// RecvQueueCap is the capacity of the receive queue, which differs from
+	// the queue size if window auto tuning is enabled.
+	RecvQueueCap int32
+	// Events holds the recorded events in order.
+	Events []FlowTraceEvent
+}
+
+// flowRecorder records flow queue events in a ring buffer.
+type flowRecorder struct {
+	lock sync.Mutex
+
+	started time.Time
+	nextSeq uint64
+	events  []FlowTraceEvent
+	full    bool
+}
+
+// EnableTracing enables recording the most recent maxEvents events of the flow
+// queue. It must be called before the flow queue is used.
+func (dfq *DuplexFlowQueue) EnableTracing(maxEvents int) {
+	if maxEvents <= 0 {
+		return
+	}
+	dfq.recorder = &flowRecorder{
+		started: time.Now(),
+		events:  make([]FlowTraceEvent, 0, maxEvents),
+	}
+}
+
+// record records an event, if tracing is enabled.
+func (dfq *DuplexFlowQueue) record(eventType uint8, value int32, recvQueueLen int) {
+	r := dfq.recorder
+	if r == nil {
+		return
+	}
+
+	event := FlowTraceEvent{
+		Type:          eventType,
+		Value:         value,
+		RecvQueueLen:  int32(recvQueueLen),
+		SendQueueLen:  int32(len(dfq.sendQueue)),
+		SendSpace:     atomic.LoadInt32(dfq.sendSpace),
+		ReportedSpace: atomic.LoadInt32(dfq.reportedSpace),
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	event.Seq = r.nextSeq
+	event.Time = time.Since(r.started)
+	r.nextSeq++
+
+	// Overwrite the oldest event when full.
+	if len(r.events) < cap(r.events) {
+		r.events = append(r.events, event)
+		return
+	}
+	r.events[event.Seq%uint64(cap(r.events))] = event
+	r.full = true
+}
+
+// Trace returns the recorded events of the flow queue, or nil if tracing is
+// not enabled.
+func (dfq *DuplexFlowQueue) Trace() *FlowTrace {
+	r := dfq.recorder
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	queueSize := int32(cap(dfq.recvQueue))
+	if dfq.autoTune != nil {
+		queueSize = dfq.autoTune.minWindow
+	}
+
+	trace := &FlowTrace{
+		QueueSize:    queueSize,
+		RecvQueueCap: int32(dfq.recvQueueSize()),
+		Events:       make([]FlowTraceEvent, 0, len(r.events)),
+	}
+	if !r.full {
+		trace.Events = append(trace.Events, r.events...)
+		return trace
+	}
+
+	// Start with the oldest event.
+	oldest := int(r.nextSeq % uint64(cap(r.events)))
+	trace.Events = append(trace.Events, r.events[oldest:]...)
+	trace.Events = append(trace.Events, r.events[:oldest]...)
+	return trace
+}
+
+// MarshalBinary packs the trace into its compact binary format.
+func (trace *FlowTrace) MarshalBinary() ([]byte, error) {
+	c := container.New(
+		varint.Pack8(flowTraceVersion),
+		varint.Pack64(uint64(trace.QueueSize)),
+		varint.Pack64(uint64(trace.RecvQueueCap)),
+		varint.Pack64(uint64(len(trace.Events))),
+	)
+
+	// Sequence numbers and times are delta encoded.
+	var lastSeq uint64
+	var lastTime time.Duration
+	for i, event := range trace.Events {
+		if i == 0 {
+			c.Append(varint.Pack64(event.Seq))
+			c.Append(varint.Pack64(uint64(event.Time)))
+		} else {
+			c.Append(varint.Pack64(event.Seq - lastSeq))
+			c.Append(varint.Pack64(uint64(event.Time - lastTime)))
+		}
+		lastSeq = event.Seq
+		lastTime = event.Time
+
+		c.Append(varint.Pack8(event.Type))
+		c.Append(varint.Pack64(zigzag(event.Value)))
+		c.Append(varint.Pack64(zigzag(event.RecvQueueLen)))
+		c.Append(varint.Pack64(zigzag(event.SendQueueLen)))
+		c.Append(varint.Pack64(zigzag(event.SendSpace)))
+		c.Append(varint.Pack64(zigzag(event.ReportedSpace)))
+	}
+
+	return c.CompileData(), nil
+}
+
+// ParseFlowTrace parses a flow trace in its compact binary format.
+func ParseFlowTrace(data []byte) (*FlowTrace, error) {
+	c := container.New(data)
+
+	version, err := c.GetNextN8()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version: %w", err)
+	}
+	if version != flowTraceVersion {
+		return nil, fmt.Errorf("unsupported flow trace version %d", version)
+	}
+
+	trace := &FlowTrace{}
+	queueSize, err := c.GetNextN64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queue size: %w", err)
+	}
+	recvQueueCap, err := c.GetNextN64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get receive queue capacity: %w", err)
+	}
+	if queueSize > MaxQueueSize || recvQueueCap > MaxQueueSize {
+		return nil, errors.New("queue size exceeds maximum")
+	}
+	trace.QueueSize = int32(queueSize)
+	trace.RecvQueueCap = int32(recvQueueCap)
+
+	count, err := c.GetNextN64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event count: %w", err)
+	}
+	// Every event takes at least 8 bytes.
+	if count > uint64(c.Length()/8) {
+		return nil, fmt.Errorf("event count %d exceeds data", count)
+	}
+
+	trace.Events = make([]FlowTraceEvent, 0, count)
+	var lastSeq uint64
+	var lastTime time.Duration
+	for i := uint64(0); i < count; i++ {
+		var event FlowTraceEvent
+		seq, err := c.GetNextN64()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get sequence of event %d: %w", i, err)
+		}
+		timeDelta, err := c.GetNextN64()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get time of event %d: %w", i, err)
+		}
+		event.Seq = lastSeq + seq
+		event.Time = lastTime + time.Duration(timeDelta)
+		lastSeq = event.Seq
+		lastTime = event.Time
+
+		event.Type, err = c.GetNextN8()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get type of event %d: %w", i, err)
+		}
+		for _, field := range []*int32{
+			&event.Value,
+			&event.RecvQueueLen,
+			&event.SendQueueLen,
+			&event.SendSpace,
+			&event.ReportedSpace,
+		} {
+			n, err := c.GetNextN64()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get field of event %d: %w", i, err)
+			}
+			*field = unzigzag(n)
+		}
+
+		trace.Events = append(trace.Events, event)
+	}
+
+	return trace, nil
+}
+
+func zigzag(n int32) uint64 {
+	return uint64(uint32((n << 1) ^ (n >> 31)))
+}
+
+func unzigzag(n uint64) int32 {
+	return int32(uint32(n)>>1) ^ -int32(uint32(n)&1)
+}
+
+// FlowReplayResult holds the result of replaying a flow trace.
+type FlowReplayResult struct {
+	// Replayed is the amount of replayed events.
+	Replayed int
+	// Divergence describes the first event where the replayed state differed
+	// from the recorded state. It is empty if the replay matched the trace.
+	Divergence string
+	// Err holds the error returned by the flow queue during the replay, such
+	// as a queue overflow.
+	Err *Error
+	// Stalled is set if the sender ended up without send space while data was
+	// waiting to be sent, which means the flow queue hangs unless the other
+	// end reports new space.
+	Stalled bool
+
+	// SendSpace and ReportedSpace hold the state after the replay.
+	SendSpace     int32
+	ReportedSpace int32
+}
+
+// ReplayFlowTrace feeds the recorded events through a fresh flow queue in
+// order to reproduce flow queue issues deterministically. Consumption of the
+// receive queue is reproduced from the recorded queue lengths. The replay
+// starts with the recorded state of the first event, so that traces of long
+// running flow queues that only hold the most recent events can be replayed.
+func ReplayFlowTrace(trace *FlowTrace) *FlowReplayResult {
+	dfq := NewDuplexFlowQueue(nil, uint32(trace.QueueSize), func(*container.Container) {})
+	if trace.RecvQueueCap > trace.QueueSize {
+		dfq.recvQueue = make(chan *container.Container, trace.RecvQueueCap)
+		dfq.recvWindow = new(int32)
+		atomic.StoreInt32(dfq.recvWindow, trace.QueueSize)
+	}
+	result := &FlowReplayResult{}
+
+	for i, event := range trace.Events {
+		// Restore the state before the first event.
+		if i == 0 {
+			replayRestoreState(dfq, event)
+		}
+
+		// Reproduce consumption of the receive queue.
+		for len(dfq.recvQueue) > int(event.RecvQueueLen) {
+			<-dfq.recvQueue
+		}
+
+		// Apply event.
+		var replayedValue int32
+		switch event.Type {
+		case FlowEventSubmitData:
+			replayedValue = dfq.reportableRecvSpace()
+			dfq.decrementSendSpace()
+		case FlowEventSubmitReport:
+			replayedValue = dfq.reportableRecvSpace()
+		case FlowEventDeliverData, FlowEventDeliverReport:
+			c := container.New(varint.Pack64(uint64(event.Value)))
+			if event.Type == FlowEventDeliverData {
+				c.Append([]byte{0})
+			}
+			replayedValue = event.Value
+			if tErr := dfq.Deliver(c); tErr != nil {
+				result.Err = tErr
+			}
+		case FlowEventWindow:
+			if dfq.recvWindow != nil {
+				atomic.StoreInt32(dfq.recvWindow, event.Value)
+			}
+			replayedValue = event.Value
+		default:
+			result.Divergence = fmt.Sprintf("event %d has unknown type %d", event.Seq, event.Type)
+			return result
+		}
+		result.Replayed++
+
+		// Compare with the recorded state.
+		if result.Divergence == "" {
+			sendSpace := atomic.LoadInt32(dfq.sendSpace)
+			reportedSpace := atomic.LoadInt32(dfq.reportedSpace)
+			switch {
+			case replayedValue != event.Value:
+				result.Divergence = fmt.Sprintf("event %d: value %d, recorded %d", event.Seq, replayedValue, event.Value)
+			case sendSpace != event.SendSpace:
+				result.Divergence = fmt.Sprintf("event %d: send space %d, recorded %d", event.Seq, sendSpace, event.SendSpace)
+			case reportedSpace != event.ReportedSpace:
+				result.Divergence = fmt.Sprintf("event %d: reported space %d, recorded %d", event.Seq, reportedSpace, event.ReportedSpace)
+			}
+		}
+		if result.Err != nil {
+			break
+		}
+	}
+
+	result.SendSpace = atomic.LoadInt32(dfq.sendSpace)
+	result.ReportedSpace = atomic.LoadInt32(dfq.reportedSpace)
+	if len(trace.Events) > 0 {
+		last := trace.Events[len(trace.Events)-1]
+		result.Stalled = result.SendSpace <= 0 && last.SendQueueLen > 0
+	}
+	return result
+}
+
+// replayRestoreState sets the state of the flow queue to the state before the
+// given event.
+func replayRestoreState(dfq *DuplexFlowQueue, event FlowTraceEvent) {
+	sendSpace := event.SendSpace
+	reportedSpace := event.ReportedSpace
+	switch event.Type {
+	case FlowEventSubmitData:
+		sendSpace++
+		reportedSpace -= event.Value
+	case FlowEventSubmitReport:
+		reportedSpace -= event.Value
+	case FlowEventDeliverData:
+		sendSpace -= event.Value
+		reportedSpace++
+	case FlowEventDeliverReport:
+		sendSpace -= event.Value
+	}
+	atomic.StoreInt32(dfq.sendSpace, sendSpace)
+	atomic.StoreInt32(dfq.reportedSpace, reportedSpace)
+
+	// Fill the receive queue to the recorded length.
+	for len(dfq.recvQueue) < int(event.RecvQueueLen) && len(dfq.recvQueue) < cap(dfq.recvQueue) {
+		dfq.recvQueue <- container.New()
+	}
+}
@@ -0,0 +1,109 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+)
+
+// runTracedReceiver delivers data to a traced flow queue, consumes some of it
+// and reports the receive space, as the flow handler would.
+func runTracedReceiver(t *testing.T, dfq *DuplexFlowQueue, rounds int) {
+	t.Helper()
+
+	for i := 0; i < rounds; i++ {
+		// Receive data.
+		for j := 0; j < 5; j++ {
+			c := container.New(varint.Pack64(uint64(j%2)), []byte("data"))
+			if tErr := dfq.Deliver(c); tErr != nil {
+				t.Fatal(tErr)
+			}
+		}
+		// Receive a space report.
+		if tErr := dfq.Deliver(container.New(varint.Pack64(3))); tErr != nil {
+			t.Fatal(tErr)
+		}
+		// Consume data and report space.
+		for j := 0; j < 5; j++ {
+			<-dfq.recvQueue
+		}
+		dfq.sendSpaceReport()
+	}
+}
+
+func TestFlowQueueTraceReplay(t *testing.T) {
+	dfq := NewDuplexFlowQueue(nil, 10, func(*container.Container) {})
+	dfq.EnableTracing(1000)
+	runTracedReceiver(t, dfq, 10)
+
+	// Pack and parse trace.
+	data, err := dfq.Trace().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace, err := ParseFlowTrace(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Events) != len(dfq.Trace().Events) {
+		t.Fatalf("expected %d events, got %d", len(dfq.Trace().Events), len(trace.Events))
+	}
+
+	// Replaying the trace must reproduce the recorded state.
+	result := ReplayFlowTrace(trace)
+	if result.Divergence != "" || result.Err != nil {
+		t.Fatalf("unexpected replay result: %+v", result)
+	}
+	if result.Replayed != len(trace.Events) {
+		t.Fatalf("expected %d replayed events, got %d", len(trace.Events), result.Replayed)
+	}
+
+	// A trace without consumption reproduces a queue overflow.
+	for i := range trace.Events {
+		trace.Events[i].RecvQueueLen = 10
+	}
+	result = ReplayFlowTrace(trace)
+	if !result.Err.Is(ErrQueueOverflow) {
+		t.Fatalf("expected queue overflow, got %+v", result)
+	}
+}
+
+func TestFlowQueueTraceRingBuffer(t *testing.T) {
+	dfq := NewDuplexFlowQueue(nil, 10, func(*container.Container) {})
+	dfq.EnableTracing(7)
+	runTracedReceiver(t, dfq, 10)
+
+	// Only the most recent events are kept in order.
+	trace := dfq.Trace()
+	if len(trace.Events) != 7 {
+		t.Fatalf("expected 7 events, got %d", len(trace.Events))
+	}
+	for i := 1; i < len(trace.Events); i++ {
+		if trace.Events[i].Seq != trace.Events[i-1].Seq+1 {
+			t.Fatalf("events are not in order: %d after %d", trace.Events[i].Seq, trace.Events[i-1].Seq)
+		}
+	}
+
+	// Partial traces can be replayed too.
+	result := ReplayFlowTrace(trace)
+	if result.Divergence != "" || result.Err != nil {
+		t.Fatalf("unexpected replay result: %+v", result)
+	}
+}
+
+func TestFlowQueueTraceStall(t *testing.T) {
+	// The sender used up all send space while data is waiting.
+	trace := &FlowTrace{
+		QueueSize:    10,
+		RecvQueueCap: 10,
+		Events: []FlowTraceEvent{
+			{Seq: 0, Type: FlowEventSubmitData, SendSpace: 1, ReportedSpace: 10, SendQueueLen: 2},
+			{Seq: 1, Type: FlowEventSubmitData, SendSpace: 0, ReportedSpace: 10, SendQueueLen: 1},
+		},
+	}
+	result := ReplayFlowTrace(trace)
+	if result.Divergence != "" || !result.Stalled {
+		t.Fatalf("expected stall, got %+v", result)
+	}
+}
@@ -36,6 +36,18 @@ type TerminalOpts struct {
 	QueueSize uint32 `json:"qs,omitempty"`
 	Padding   uint16 `json:"p,omitempty"`
 	Encrypt   bool   `json:"e,omitempty"`
+
+	// CraneVersion holds the highest crane protocol version spoken by the
+	// sender. It is only used by crane controllers.
+	CraneVersion uint8 `json:"cv,omitempty"`
+	// CraneCapabilities holds the optional crane features supported by the
+	// sender. It is only used by crane controllers.
+	CraneCapabilities uint64 `json:"cc,omitempty"`
+
+	// MaxMsgSize defines the maximum size of received messages.
+	// It is a local setting and is not sent to the other end.
+	// Defaults to the size set with SetMaxMsgSize.
+	MaxMsgSize uint32 `json:"-"`
 }
 
 func ParseTerminalOpts(c *container.Container) (*TerminalOpts, *Error) {
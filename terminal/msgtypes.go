@@ -1,6 +1,8 @@
 package terminal
 
 import (
+	"sync/atomic"
+
 	"github.com/safing/portbase/container"
 	"github.com/safing/portbase/formats/varint"
 )
@@ -21,6 +23,7 @@ Terminal and Operation Message Format:
 		- Data [bytes]
 	- MsgTypeData:
 		- AddAvailableSpace [varint, if Flow Queue is used]
+			- A value of 1 marks a control message, which bypasses the flow control.
 		- (Encrypted) Data [bytes]
 	- MsgTypeStop:
 		- Error Code [varint]
@@ -39,6 +42,49 @@ const (
 	MsgTypeStop MsgType = 3
 )
 
+// DefaultMaxMsgSize is the default maximum size of a received message.
+const DefaultMaxMsgSize = 4 * 1024 * 1024 // 4MB
+
+// maxMsgSize holds the maximum size of a received message that is used if
+// none is configured in the terminal options.
+var maxMsgSize uint32 = DefaultMaxMsgSize
+
+// SetMaxMsgSize sets the maximum size of a received message that is used if
+// none is configured in the terminal options. If size is 0, DefaultMaxMsgSize
+// is used.
+func SetMaxMsgSize(size uint32) {
+	if size == 0 {
+		size = DefaultMaxMsgSize
+	}
+	atomic.StoreUint32(&maxMsgSize, size)
+}
+
+// getMaxMsgSize returns the given maximum message size, or the configured one
+// if it is 0.
+func getMaxMsgSize(size uint32) uint32 {
+	if size == 0 {
+		return atomic.LoadUint32(&maxMsgSize)
+	}
+	return size
+}
+
+// GetNextMsgLength parses the length of the next message and checks it
+// against the given maximum size before any data of the message is used.
+// If maxSize is 0, the size set with SetMaxMsgSize is used.
+func GetNextMsgLength(c *container.Container, maxSize uint32) (uint32, *Error) {
+	msgLength, err := c.GetNextN32()
+	if err != nil {
+		return 0, ErrMalformedData.With("failed to get msg length: %w", err)
+	}
+
+	maxSize = getMaxMsgSize(maxSize)
+	if msgLength > maxSize {
+		return 0, ErrMalformedData.With("msg length of %d exceeds maximum of %d", msgLength, maxSize)
+	}
+
+	return msgLength, nil
+}
+
 // AddIDType prepends the ID and Type header to the message.
 func AddIDType(c *container.Container, id uint32, msgType MsgType) {
 	c.Prepend(varint.Pack32(id | uint32(msgType)))
@@ -0,0 +1,54 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
+)
+
+func TestGetNextMsgLength(t *testing.T) {
+	// Valid length.
+	c := container.New(varint.Pack32(100))
+	msgLength, tErr := GetNextMsgLength(c, 1000)
+	if tErr != nil {
+		t.Fatal(tErr)
+	}
+	if msgLength != 100 {
+		t.Fatalf("unexpected msg length %d", msgLength)
+	}
+
+	// Over-large declared length with custom maximum.
+	c = container.New(varint.Pack32(1001))
+	if _, tErr := GetNextMsgLength(c, 1000); !tErr.Is(ErrMalformedData) {
+		t.Fatalf("expected ErrMalformedData, got %s", tErr)
+	}
+
+	// Over-large declared length with default maximum.
+	c = container.New(varint.Pack32(1 << 30))
+	if _, tErr := GetNextMsgLength(c, 0); !tErr.Is(ErrMalformedData) {
+		t.Fatalf("expected ErrMalformedData, got %s", tErr)
+	}
+
+	// Over-large declared length with set maximum.
+	SetMaxMsgSize(1000)
+	defer SetMaxMsgSize(0)
+	c = container.New(varint.Pack32(1001))
+	if _, tErr := GetNextMsgLength(c, 0); !tErr.Is(ErrMalformedData) {
+		t.Fatalf("expected ErrMalformedData, got %s", tErr)
+	}
+}
+
+func TestDeliverOversizedMsg(t *testing.T) {
+	dfq := NewDuplexFlowQueue(nil, 10, nil)
+	dfq.SetMaxMsgSize(10)
+
+	// Oversized containers must be rejected before they are queued.
+	c := container.New(varint.Pack16(0), make([]byte, 11))
+	if tErr := dfq.Deliver(c); !tErr.Is(ErrMalformedData) {
+		t.Fatalf("expected ErrMalformedData, got %s", tErr)
+	}
+	if dfq.recvQueued() != 0 {
+		t.Fatal("oversized container must not be queued")
+	}
+}
@@ -2,6 +2,7 @@ package terminal
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/safing/portbase/container"
 	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/utils"
+	"github.com/safing/spn/hub"
 	"github.com/tevino/abool"
 )
 
@@ -27,6 +29,14 @@ type Operation interface {
 	End(err *Error)
 }
 
+// CancelableOperation is an operation that can be canceled by its initiator.
+// Canceling ends the operation locally and sends a stop message with
+// ErrCanceled to the remote end, which then ends its side of the operation.
+type CancelableOperation interface {
+	Operation
+	Cancel()
+}
+
 type OpParams struct {
 	// Type is the type name of an operation.
 	Type string
@@ -34,6 +44,10 @@ type OpParams struct {
 	Requires Permission
 	// RunOp is the function that start a new operation.
 	RunOp OpRunner
+	// Control defines whether the operation is a control operation, which
+	// may send its messages before any other waiting data with
+	// OpSendControl. See OpSendControl for the criteria.
+	Control bool
 }
 
 type OpRunner func(t OpTerminal, opID uint32, initData *container.Container) (Operation, *Error)
@@ -64,6 +78,9 @@ func RegisterOpType(params OpParams) {
 
 	// Save to registry.
 	opRegistry[params.Type] = &params
+
+	// Advertise operation type as a capability of the Hub.
+	hub.RegisterLocalCapability(hub.OpCapability(params.Type))
 }
 
 func lockOpRegistry() {
@@ -120,6 +137,10 @@ type OpTerminal interface {
 	// OpSendWithTimeout sends data, but fails after the given timeout passed.
 	OpSendWithTimeout(op Operation, data *container.Container, timeout time.Duration) *Error
 
+	// OpSendWithPolicy sends data and applies the given policy if the data
+	// cannot be sent right away.
+	OpSendWithPolicy(op Operation, data *container.Container, policy SendQueuePolicy) *Error
+
 	// OpEnd sends the end signal and calls End(ErrNil) on the Operation.
 	// The Operation should cease operation after calling this function.
 	OpEnd(op Operation, err *Error)
@@ -163,6 +184,82 @@ func (t *TerminalBase) OpSendWithTimeout(op Operation, data *container.Container
 	return t.addToOpMsgSendBuffer(op.ID(), MsgTypeData, data, timeout)
 }
 
+// SendQueuePolicy defines what OpSendWithPolicy does when the terminal cannot
+// send the data right away.
+type SendQueuePolicy uint8
+
+// Send Queue Policies.
+const (
+	// SendQueueBlock waits until the data can be sent, like OpSend.
+	// This is the default and must be used by reliable operations.
+	SendQueueBlock SendQueuePolicy = iota
+	// SendQueueDropNewest discards the data that is being sent.
+	SendQueueDropNewest
+	// SendQueueDropOldest discards the oldest data waiting to be sent with a
+	// dropping policy in order to make room for the data that is being sent.
+	// This is useful for latency sensitive streams, where stale data is
+	// useless.
+	SendQueueDropOldest
+)
+
+func (p SendQueuePolicy) String() string {
+	switch p {
+	case SendQueueBlock:
+		return "block"
+	case SendQueueDropNewest:
+		return "drop-newest"
+	case SendQueueDropOldest:
+		return "drop-oldest"
+	default:
+		return fmt.Sprintf("unknown(%d)", p)
+	}
+}
+
+// OpSendWithPolicy sends data and applies the given policy if the data cannot
+// be sent right away. Only data is ever dropped, the init and end messages of
+// operations are always sent. Data sent with a dropping policy may be
+// reordered with data sent by other means and may still arrive after the
+// operation ended.
+func (t *TerminalBase) OpSendWithPolicy(op Operation, data *container.Container, policy SendQueuePolicy) *Error {
+	switch policy {
+	case SendQueueDropNewest:
+		MakeMsg(data, op.ID(), MsgTypeData)
+		select {
+		case t.lossyOpMsgQueue <- data:
+		default:
+			atomic.AddInt32(t.droppedOpMsgs, 1)
+		}
+		return nil
+
+	case SendQueueDropOldest:
+		MakeMsg(data, op.ID(), MsgTypeData)
+		for {
+			select {
+			case t.lossyOpMsgQueue <- data:
+				return nil
+			default:
+			}
+
+			// Discard the oldest data to make room.
+			select {
+			case <-t.lossyOpMsgQueue:
+				atomic.AddInt32(t.droppedOpMsgs, 1)
+			default:
+				// The sender took data in the meantime.
+			}
+		}
+
+	default:
+		return t.OpSend(op, data)
+	}
+}
+
+// DroppedOpMsgs returns the total amount of data messages that were discarded
+// by OpSendWithPolicy.
+func (t *TerminalBase) DroppedOpMsgs() int {
+	return int(atomic.LoadInt32(t.droppedOpMsgs))
+}
+
 // OpEnd sends the end signal with an optional error and then deletes the
 // operation from the Terminal state and calls End(ErrNil) on the Operation.
 // The Operation should cease operation after calling this function.
@@ -177,7 +274,7 @@ func (t *TerminalBase) OpEnd(op Operation, err *Error) {
 	switch {
 	case err == nil:
 		log.Debugf("spn/terminal: operation %s %s ended", op.Type(), fmtOperationID(t.parentID, t.id, op.ID()))
-	case err.IsOK() || err.Is(ErrTryAgainLater):
+	case err.IsOK() || err.Is(ErrTryAgainLater) || err.Is(ErrCanceled):
 		log.Debugf("spn/terminal: operation %s %s ended: %s", op.Type(), fmtOperationID(t.parentID, t.id, op.ID()), err)
 	default:
 		log.Warningf("spn/terminal: operation %s %s failed: %s", op.Type(), fmtOperationID(t.parentID, t.id, op.ID()), err)
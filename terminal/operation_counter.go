@@ -226,6 +226,11 @@ func (op *CounterOp) SendCounter() *Error {
 	return op.t.OpSend(op, container.New(varint.Pack64(counter)))
 }
 
+// Cancel ends the operation on both ends before counting is finished.
+func (op *CounterOp) Cancel() {
+	op.t.OpEnd(op, ErrCanceled.With("by initiator"))
+}
+
 func (op *CounterOp) Wait() {
 	op.wg.Wait()
 }
@@ -0,0 +1,124 @@
+package terminal
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/rng"
+)
+
+// PingOpType is the type name of the ping operation.
+const PingOpType string = "ping"
+
+const pingNonceSize = 8
+
+// PingOp measures the round trip time of a terminal. In contrast to the
+// latency test of cranes, the ping travels through the whole terminal, so the
+// measured time includes the delay of the message buffers and flow queues on
+// both ends.
+type PingOp struct {
+	OpBase
+	t OpTerminal
+
+	nonce  []byte
+	sentAt time.Time
+
+	rtt    time.Duration
+	result chan *Error
+}
+
+// Type returns the type ID.
+func (op *PingOp) Type() string {
+	return PingOpType
+}
+
+func init() {
+	RegisterOpType(OpParams{
+		Type:  PingOpType,
+		RunOp: runPingOp,
+	})
+}
+
+// NewPingOp starts a new ping operation on the given terminal.
+func NewPingOp(t OpTerminal) (*PingOp, *Error) {
+	// Create operation.
+	op := &PingOp{
+		t:      t,
+		result: make(chan *Error, 1),
+	}
+	op.OpBase.Init()
+
+	// Generate nonce.
+	var err error
+	op.nonce, err = rng.Bytes(pingNonceSize)
+	if err != nil {
+		return nil, ErrInternalError.With("failed to create ping nonce: %w", err)
+	}
+
+	// Send ping and do not wait for more data.
+	op.sentAt = time.Now()
+	tErr := t.OpInit(op, container.New(op.nonce))
+	if tErr != nil {
+		return nil, tErr
+	}
+	t.Flush()
+
+	return op, nil
+}
+
+func runPingOp(t OpTerminal, opID uint32, data *container.Container) (Operation, *Error) {
+	// Create operation.
+	op := &PingOp{
+		t: t,
+	}
+	op.OpBase.Init()
+	op.OpBase.SetID(opID)
+
+	// Reply with the nonce.
+	tErr := t.OpSend(op, data)
+	if tErr != nil {
+		return nil, tErr.Wrap("failed to send ping response")
+	}
+	t.Flush()
+
+	return op, nil
+}
+
+// Deliver delivers a message to the operation.
+func (op *PingOp) Deliver(c *container.Container) *Error {
+	if op.result == nil {
+		return ErrIncorrectUsage.With("ping responder cannot receive")
+	}
+
+	// Check if the nonce matches and save the round trip time.
+	if !bytes.Equal(op.nonce, c.CompileData()) {
+		return ErrIntegrity.With("ping nonce mismatch")
+	}
+	op.rtt = time.Since(op.sentAt)
+
+	return ErrExplicitAck
+}
+
+// End ends the operation.
+func (op *PingOp) End(tErr *Error) {
+	if op.result == nil {
+		return
+	}
+
+	select {
+	case op.result <- tErr:
+	default:
+	}
+}
+
+// Result returns the result channel of the operation.
+func (op *PingOp) Result() <-chan *Error {
+	return op.result
+}
+
+// RTT returns the measured round trip time. It is only valid after the
+// operation ended successfully.
+func (op *PingOp) RTT() time.Duration {
+	return op.rtt
+}
@@ -0,0 +1,237 @@
+package terminal
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+)
+
+/*
+Request/Response Operation Format:
+
+- Init Message [from client]:
+	- Request [DSD; usually CBOR]
+- Data Message [from server]:
+	- Response [DSD; usually CBOR]
+- Stop Message [from client]:
+	- ErrExplicitAck if the response was received and loaded successfully.
+
+The server sends exactly one response and the client ends the operation when
+it received it. If the server fails to handle the request, it ends the
+operation with an error instead of responding.
+
+Operations without a response skip the data message: The server ends the
+operation without an error once the request was handled.
+*/
+
+// RequestResponseParams defines a request/response operation type.
+type RequestResponseParams struct {
+	// Type is the type name of the operation.
+	Type string
+	// Requires defines the required permissions to run the operation.
+	Requires Permission
+	// NewRequest returns a new value to load a received request into.
+	NewRequest func() interface{}
+	// Handle handles a request and returns the response to send. If the
+	// returned response is nil, the operation is ended successfully without
+	// sending a response.
+	Handle func(t OpTerminal, request interface{}) (response interface{}, tErr *Error)
+}
+
+// RegisterRequestResponseOpType registers a new request/response operation
+// type. Like RegisterOpType, it may only be called during Go's init and a
+// module's prep phase.
+func RegisterRequestResponseOpType(params RequestResponseParams) {
+	RegisterOpType(OpParams{
+		Type:     params.Type,
+		Requires: params.Requires,
+		RunOp: func(t OpTerminal, opID uint32, data *container.Container) (Operation, *Error) {
+			return runRequestResponseOp(t, opID, data, &params)
+		},
+	})
+}
+
+// RequestResponseOp is an operation that sends a single request and waits
+// for a single response. It takes care of serialization, correlating the
+// response and timeouts, so that simple operations do not need to implement
+// this themselves.
+type RequestResponseOp struct {
+	OpBase
+	t      OpTerminal
+	opType string
+
+	response   interface{}
+	sentAt     time.Time
+	receivedAt time.Time
+	result     chan *Error
+}
+
+// Type returns the type ID.
+func (op *RequestResponseOp) Type() string {
+	return op.opType
+}
+
+// NewRequestResponseOp sends the given request with a new operation of the
+// given type. The response will be loaded into the given response value. If
+// the operation type does not respond, response must be nil.
+// Use Wait() to wait for the response.
+func NewRequestResponseOp(t OpTerminal, opType string, request, response interface{}) (*RequestResponseOp, *Error) {
+	// Create operation.
+	op := &RequestResponseOp{
+		t:        t,
+		opType:   opType,
+		response: response,
+		result:   make(chan *Error, 1),
+	}
+	op.OpBase.Init()
+
+	// Serialize request.
+	data, err := dsd.Dump(request, dsd.CBOR)
+	if err != nil {
+		return nil, ErrInternalError.With("failed to pack request: %w", err)
+	}
+
+	// Send request and do not wait for more data.
+	op.sentAt = time.Now()
+	tErr := t.OpInit(op, container.New(data))
+	if tErr != nil {
+		return nil, tErr
+	}
+	t.Flush()
+
+	return op, nil
+}
+
+// SendRequest sends the given request with a new operation of the given type
+// and waits until the response was loaded into the given response value or
+// the timeout is reached. If timeout is zero, DefaultOperationTimeout is used.
+func SendRequest(t OpTerminal, opType string, request, response interface{}, timeout time.Duration) *Error {
+	op, tErr := NewRequestResponseOp(t, opType, request, response)
+	if tErr != nil {
+		return tErr
+	}
+	return op.Wait(timeout)
+}
+
+func runRequestResponseOp(t OpTerminal, opID uint32, data *container.Container, params *RequestResponseParams) (Operation, *Error) {
+	// Create operation.
+	op := &RequestResponseOp{
+		t:      t,
+		opType: params.Type,
+	}
+	op.OpBase.Init()
+	op.OpBase.SetID(opID)
+
+	// Load request.
+	request := params.NewRequest()
+	_, err := dsd.Load(data.CompileData(), request)
+	if err != nil {
+		return nil, ErrMalformedData.With("failed to load request: %w", err)
+	}
+
+	// Handle request.
+	response, tErr := params.Handle(t, request)
+	if tErr != nil {
+		return nil, tErr
+	}
+	if response == nil {
+		return nil, nil
+	}
+
+	// Send response.
+	responseData, err := dsd.Dump(response, dsd.CBOR)
+	if err != nil {
+		return nil, ErrInternalError.With("failed to pack response: %w", err)
+	}
+	tErr = t.OpSend(op, container.New(responseData))
+	if tErr != nil {
+		return nil, tErr.Wrap("failed to send response")
+	}
+	t.Flush()
+
+	return op, nil
+}
+
+// Deliver delivers a message to the operation.
+func (op *RequestResponseOp) Deliver(c *container.Container) *Error {
+	// Only the client receives data, if a response is expected.
+	if op.result == nil || op.response == nil {
+		return ErrIncorrectUsage.With("unexpected data")
+	}
+	op.receivedAt = time.Now()
+
+	// Load response.
+	_, err := dsd.Load(c.CompileData(), op.response)
+	if err != nil {
+		return ErrMalformedData.With("failed to load response: %w", err)
+	}
+
+	return ErrExplicitAck
+}
+
+// End ends the operation.
+func (op *RequestResponseOp) End(tErr *Error) {
+	if op.result == nil {
+		return
+	}
+
+	select {
+	case op.result <- tErr:
+	default:
+	}
+}
+
+// Wait waits for the response and returns nil when it was received. If the
+// timeout is reached, the operation is ended. If timeout is zero,
+// DefaultOperationTimeout is used.
+func (op *RequestResponseOp) Wait(timeout time.Duration) *Error {
+	return op.WaitContext(context.Background(), timeout)
+}
+
+// WaitContext is like Wait, but also stops waiting when the given context is
+// canceled. The operation is left to be ended by the terminal then.
+func (op *RequestResponseOp) WaitContext(ctx context.Context, timeout time.Duration) *Error {
+	if timeout == 0 {
+		timeout = DefaultOperationTimeout
+	}
+
+	// Stop waiting when the terminal ends.
+	var terminalDone <-chan struct{}
+	if ctxT, ok := op.t.(interface{ Ctx() context.Context }); ok {
+		terminalDone = ctxT.Ctx().Done()
+	}
+
+	select {
+	case tErr := <-op.result:
+		switch {
+		case tErr.Is(ErrExplicitAck):
+			return nil
+		case op.response == nil && tErr.IsOK():
+			// Operations without a response are ended without an error.
+			return nil
+		case tErr == nil:
+			return ErrStopping
+		default:
+			return tErr
+		}
+
+	case <-time.After(timeout):
+		tErr := ErrTimeout.With("no response to %s request", op.opType)
+		op.t.OpEnd(op, tErr)
+		return tErr
+
+	case <-terminalDone:
+		return ErrStopping
+
+	case <-ctx.Done():
+		return ErrCanceled
+	}
+}
+
+// RoundTripTime returns the time between sending the request and receiving
+// the response. It is only valid after Wait returned successfully.
+func (op *RequestResponseOp) RoundTripTime() time.Duration {
+	return op.receivedAt.Sub(op.sentAt)
+}
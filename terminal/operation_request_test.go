@@ -0,0 +1,95 @@
+package terminal
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	testRequestOpType = "debug/request"
+	testNotifyOpType  = "debug/notify"
+)
+
+type testRequest struct {
+	A, B int
+}
+
+type testResponse struct {
+	Sum int
+}
+
+func init() {
+	RegisterRequestResponseOpType(RequestResponseParams{
+		Type: testRequestOpType,
+		NewRequest: func() interface{} {
+			return &testRequest{}
+		},
+		Handle: func(_ OpTerminal, request interface{}) (interface{}, *Error) {
+			r := request.(*testRequest)
+			if r.A < 0 || r.B < 0 {
+				return nil, ErrIncorrectUsage.With("negative numbers are not supported")
+			}
+			return &testResponse{Sum: r.A + r.B}, nil
+		},
+	})
+	RegisterRequestResponseOpType(RequestResponseParams{
+		Type: testNotifyOpType,
+		NewRequest: func() interface{} {
+			return &testRequest{}
+		},
+		Handle: func(_ OpTerminal, request interface{}) (interface{}, *Error) {
+			if request.(*testRequest).A < 0 {
+				return nil, ErrIncorrectUsage.With("negative numbers are not supported")
+			}
+			return nil, nil
+		},
+	})
+}
+
+func TestRequestResponseOp(t *testing.T) {
+	term1, term2, err := NewSimpleTestTerminalPair(0, nil)
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+
+	// Send a valid request.
+	response := &testResponse{}
+	tErr := SendRequest(term1, testRequestOpType, &testRequest{A: 2, B: 3}, response, 0)
+	if tErr != nil {
+		t.Fatalf("request failed: %s", tErr)
+	}
+	if response.Sum != 5 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	// Errors of the handler are returned to the requester.
+	tErr = SendRequest(term1, testRequestOpType, &testRequest{A: -1}, &testResponse{}, 0)
+	if !tErr.Is(ErrIncorrectUsage) {
+		t.Fatalf("unexpected error: %s", tErr)
+	}
+
+	// Operations without a response succeed when the request was handled.
+	tErr = SendRequest(term1, testNotifyOpType, &testRequest{A: 1}, nil, 0)
+	if tErr != nil {
+		t.Fatalf("request without response failed: %s", tErr)
+	}
+	tErr = SendRequest(term1, testNotifyOpType, &testRequest{A: -1}, nil, 0)
+	if !tErr.Is(ErrIncorrectUsage) {
+		t.Fatalf("unexpected error: %s", tErr)
+	}
+
+	// Unknown operation types are rejected.
+	tErr = SendRequest(term1, "debug/unknown", &testRequest{}, &testResponse{}, 0)
+	if !tErr.Is(ErrUnknownOperationType) {
+		t.Fatalf("unexpected error: %s", tErr)
+	}
+
+	// The operations must be cleaned up on both ends.
+	time.Sleep(100 * time.Millisecond)
+	if term1.GetActiveOpCount() != 0 {
+		t.Errorf("expected no active ops on local terminal, got %d", term1.GetActiveOpCount())
+	}
+	if term2.GetActiveOpCount() != 0 {
+		t.Errorf("expected no active ops on remote terminal, got %d", term2.GetActiveOpCount())
+	}
+}
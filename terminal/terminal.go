@@ -61,6 +61,11 @@ type TerminalBase struct {
 
 	// opMsgQueue is used by operations to submit messages for sending.
 	opMsgQueue chan *container.Container
+	// lossyOpMsgQueue holds data messages of operations that rather drop
+	// messages than wait for sending. See OpSendWithPolicy.
+	lossyOpMsgQueue chan *container.Container
+	// droppedOpMsgs counts the data messages discarded by OpSendWithPolicy.
+	droppedOpMsgs *int32
 	// waitForFlush signifies if sending should be delayed until the next call
 	// to Flush()
 	waitForFlush *abool.AtomicBool
@@ -88,6 +93,9 @@ type TerminalBase struct {
 	// permission holds the permissions of the terminal.
 	permission Permission
 
+	// latency holds the measured round trip times of the terminal.
+	latency terminalLatency
+
 	// opts holds the terminal options. It must not be modified after the terminal
 	// has started.
 	opts *TerminalOpts
@@ -109,6 +117,8 @@ func createTerminalBase(
 		id:              id,
 		parentID:        parentID,
 		opMsgQueue:      make(chan *container.Container),
+		lossyOpMsgQueue: make(chan *container.Container, lossyOpMsgQueueSize),
+		droppedOpMsgs:   new(int32),
 		waitForFlush:    abool.New(),
 		flush:           make(chan func()),
 		idleTicker:      time.NewTicker(time.Minute),
@@ -165,6 +175,10 @@ const (
 	sendThresholdLength  = 100  // bytes
 	sendMaxLength        = 4000 // bytes
 	sendThresholdMaxWait = 20 * time.Millisecond
+
+	// lossyOpMsgQueueSize defines how many data messages sent with a dropping
+	// SendQueuePolicy may wait to be sent.
+	lossyOpMsgQueueSize = 100
 )
 
 // Handler receives and handles messages and must be started as a worker in the
@@ -172,7 +186,23 @@ const (
 func (t *TerminalBase) Handler(_ context.Context) error {
 	defer t.ext.Abandon(ErrInternalError.With("handler died"))
 
+	// Receive control messages, if supported by the extension.
+	var recvControl <-chan *container.Container
+	if cr, ok := t.ext.(controlReceiver); ok {
+		recvControl = cr.ReceiveControl()
+	}
+
 	for {
+		// Handle control messages first.
+		select {
+		case c := <-recvControl:
+			if !t.handleReceived(c) {
+				return nil // Controlled worker exit.
+			}
+			continue
+		default:
+		}
+
 		select {
 		case <-t.ctx.Done():
 			t.ext.Abandon(nil)
@@ -185,28 +215,35 @@ func (t *TerminalBase) Handler(_ context.Context) error {
 				return nil // Controlled worker exit.
 			}
 
+		case c := <-recvControl:
+			if !t.handleReceived(c) {
+				return nil // Controlled worker exit.
+			}
+
 		case c := <-t.ext.Receive():
-			if c.HoldsData() {
-				err := t.handleReceive(c)
-				if err != nil {
-					if !errors.Is(err, ErrStopping) {
-						t.ext.Abandon(err.Wrap("failed to handle"))
-					}
-					return nil // Controlled worker exit.
-				}
-				switch err {
-				case nil:
-					// Continue.
-				case ErrStopping:
-				default:
-					return nil // Controlled worker exit.
-				}
+			if !t.handleReceived(c) {
+				return nil // Controlled worker exit.
 			}
+		}
+	}
+}
 
-			// Register activity.
-			atomic.StoreUint32(t.idleCounter, 0)
+// handleReceived handles a received container and returns whether the handler
+// should continue.
+func (t *TerminalBase) handleReceived(c *container.Container) (ok bool) {
+	if c.HoldsData() {
+		err := t.handleReceive(c)
+		if err != nil {
+			if !errors.Is(err, ErrStopping) {
+				t.ext.Abandon(err.Wrap("failed to handle"))
+			}
+			return false
 		}
 	}
+
+	// Register activity.
+	atomic.StoreUint32(t.idleCounter, 0)
+	return true
 }
 
 // Sender handles sending messages and must be started as a worker in the
@@ -241,6 +278,12 @@ func (t *TerminalBase) Sender(_ context.Context) error {
 		}
 		return t.opMsgQueue
 	}
+	recvLossyOpMsgs := func() <-chan *container.Container {
+		if msgBufferLimitReached {
+			return nil
+		}
+		return t.lossyOpMsgQueue
+	}
 
 	// Only wait for sending slot when the current msg buffer is ready to be sent.
 	readyToSend := func() <-chan struct{} {
@@ -250,6 +293,27 @@ func (t *TerminalBase) Sender(_ context.Context) error {
 		return nil
 	}
 
+	// Add message to the current msg buffer.
+	bufferOpMsg := func(c *container.Container) {
+		// Add container to current buffer.
+		msgBufferLen += c.Length()
+		msgBuffer.AppendContainer(c)
+
+		// Check if there is enough data to hit the sending threshold.
+		if msgBufferLen >= sendThresholdLength {
+			sendMsgs = true
+		} else if sendMaxWait == nil && t.waitForFlush.IsNotSet() {
+			sendMaxWait = time.NewTimer(sendThresholdMaxWait)
+		}
+
+		if msgBufferLen >= sendMaxLength {
+			msgBufferLimitReached = true
+		}
+
+		// Register activity.
+		atomic.StoreUint32(t.idleCounter, 0)
+	}
+
 	// Calculate current max wait time to send the msg buffer.
 	getSendMaxWait := func() <-chan time.Time {
 		if sendMaxWait != nil {
@@ -272,23 +336,10 @@ func (t *TerminalBase) Sender(_ context.Context) error {
 			}
 
 		case c := <-recvOpMsgs():
-			// Add container to current buffer.
-			msgBufferLen += c.Length()
-			msgBuffer.AppendContainer(c)
+			bufferOpMsg(c)
 
-			// Check if there is enough data to hit the sending threshold.
-			if msgBufferLen >= sendThresholdLength {
-				sendMsgs = true
-			} else if sendMaxWait == nil && t.waitForFlush.IsNotSet() {
-				sendMaxWait = time.NewTimer(sendThresholdMaxWait)
-			}
-
-			if msgBufferLen >= sendMaxLength {
-				msgBufferLimitReached = true
-			}
-
-			// Register activity.
-			atomic.StoreUint32(t.idleCounter, 0)
+		case c := <-recvLossyOpMsgs():
+			bufferOpMsg(c)
 
 		case <-getSendMaxWait():
 			// The timer for waiting for more data has ended.
@@ -459,10 +510,10 @@ func (t *TerminalBase) handleReceive(c *container.Container) *Error {
 
 	// Handle operation messages.
 	for c.HoldsData() {
-		// Get next messagt length.
-		msgLength, err := c.GetNextN32()
-		if err != nil {
-			return ErrMalformedData.With("failed to get operation msg length: %w", err)
+		// Get next message length.
+		msgLength, tErr := GetNextMsgLength(c, t.opts.MaxMsgSize)
+		if tErr != nil {
+			return tErr.Wrap("failed to get operation msg length")
 		}
 		if msgLength == 0 {
 			// Remainder is padding.
@@ -549,6 +600,17 @@ func (t *TerminalBase) handlePaddingMsg(c *container.Container) {
 }
 
 func (t *TerminalBase) sendOpMsgs(c *container.Container) *Error {
+	c, tErr := t.prepareOpMsgs(c)
+	if tErr != nil {
+		return tErr
+	}
+
+	// Send data.
+	return t.ext.Send(c)
+}
+
+// prepareOpMsgs pads and encrypts the given operation messages for sending.
+func (t *TerminalBase) prepareOpMsgs(c *container.Container) (*container.Container, *Error) {
 	if t.opts.Padding > 0 {
 		// Add Padding if needed.
 		paddingNeeded := (int(t.opts.Padding) - c.Length()) % int(t.opts.Padding)
@@ -570,14 +632,7 @@ func (t *TerminalBase) sendOpMsgs(c *container.Container) *Error {
 	}
 
 	// Encrypt operative data.
-	var tErr *Error
-	c, tErr = t.encrypt(c)
-	if tErr != nil {
-		return tErr
-	}
-
-	// Send data.
-	return t.ext.Send(c)
+	return t.encrypt(c)
 }
 
 func (t *TerminalBase) addToOpMsgSendBuffer(
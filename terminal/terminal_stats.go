@@ -0,0 +1,109 @@
+package terminal
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyEWMAFactor defines how much a new measurement changes the average
+// latency.
+const latencyEWMAFactor = 0.25
+
+// TerminalStats holds statistics of a terminal.
+type TerminalStats struct {
+	// ActiveOps is the amount of active operations.
+	ActiveOps int
+
+	// Latency is the last measured round trip time of the terminal. It is
+	// measured end-to-end through the terminal and includes the delay of the
+	// message buffers and flow queues on both ends.
+	Latency time.Duration
+	// AvgLatency is the moving average of the measured round trip times.
+	AvgLatency time.Duration
+	// MinLatency is the lowest measured round trip time. As it was measured
+	// with the least queuing, it approximates the network latency.
+	MinLatency time.Duration
+	// QueuingDelay is the part of the last measured round trip time that was
+	// added by queuing, compared to MinLatency. A high queuing delay together
+	// with a low lane latency indicates that deep queues add latency.
+	QueuingDelay time.Duration
+	// LatencySamples is the amount of latency measurements.
+	LatencySamples int
+	// LatencyMeasuredAt is when the latency was last measured.
+	LatencyMeasuredAt time.Time `json:",omitempty"`
+}
+
+// terminalLatency holds the latency measurements of a terminal.
+type terminalLatency struct {
+	lock sync.Mutex
+
+	last       time.Duration
+	avg        time.Duration
+	min        time.Duration
+	samples    int
+	measuredAt time.Time
+}
+
+func (tl *terminalLatency) add(rtt time.Duration, now time.Time) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+
+	tl.last = rtt
+	if tl.samples == 0 {
+		tl.avg = rtt
+		tl.min = rtt
+	} else {
+		tl.avg += time.Duration(latencyEWMAFactor * float64(rtt-tl.avg))
+		if rtt < tl.min {
+			tl.min = rtt
+		}
+	}
+	tl.samples++
+	tl.measuredAt = now
+}
+
+// MeasureLatency measures the round trip time of the terminal with a ping
+// operation and adds it to the terminal stats. The measured time includes the
+// delay of the message buffers and flow queues on both ends.
+func (t *TerminalBase) MeasureLatency() (time.Duration, *Error) {
+	op, tErr := NewPingOp(t.ext)
+	if tErr != nil {
+		return 0, tErr.Wrap("failed to start ping")
+	}
+
+	// Wait for the result.
+	select {
+	case tErr = <-op.Result():
+	case <-time.After(DefaultOperationTimeout):
+		t.OpEnd(op, ErrTimeout.With("no ping response"))
+		return 0, ErrTimeout.With("no ping response")
+	case <-t.ctx.Done():
+		return 0, ErrStopping
+	}
+	if !tErr.Is(ErrExplicitAck) {
+		return 0, tErr
+	}
+
+	t.latency.add(op.RTT(), time.Now())
+	return op.RTT(), nil
+}
+
+// Stats returns the current statistics of the terminal.
+func (t *TerminalBase) Stats() TerminalStats {
+	stats := TerminalStats{
+		ActiveOps: t.GetActiveOpCount(),
+	}
+
+	t.latency.lock.Lock()
+	defer t.latency.lock.Unlock()
+
+	if t.latency.samples > 0 {
+		stats.Latency = t.latency.last
+		stats.AvgLatency = t.latency.avg
+		stats.MinLatency = t.latency.min
+		stats.QueuingDelay = t.latency.last - t.latency.min
+		stats.LatencySamples = t.latency.samples
+		stats.LatencyMeasuredAt = t.latency.measuredAt
+	}
+	return stats
+}
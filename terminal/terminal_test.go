@@ -1,6 +1,7 @@
 package terminal
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"runtime/pprof"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/varint"
 	"github.com/safing/spn/cabin"
 )
 
@@ -286,3 +288,653 @@ func printCTStats(t *testing.T, testName, name string, term *TestTerminal) {
 		len(term.opMsgQueue),
 	)
 }
+
+func TestFlowQueuePressure(t *testing.T) {
+	dfq := NewDuplexFlowQueue(nil, 10, nil)
+
+	// Empty queue has no pressure.
+	if p := dfq.Pressure(); p != 0 {
+		t.Errorf("expected no pressure on empty queue, got %f", p)
+	}
+
+	// Queued containers add pressure relative to the send space.
+	for i := 0; i < 5; i++ {
+		dfq.sendQueue <- container.New()
+	}
+	if p := dfq.Pressure(); p <= 0 || p >= 1 {
+		t.Errorf("expected partial pressure, got %f", p)
+	}
+
+	// Without send space, any queued container is full pressure.
+	atomic.StoreInt32(dfq.sendSpace, 0)
+	if p := dfq.Pressure(); p != 1 {
+		t.Errorf("expected full pressure without send space, got %f", p)
+	}
+}
+
+func TestFlowQueueGetFlowStats(t *testing.T) {
+	dfq := NewDuplexFlowQueue(nil, 10, nil)
+
+	// Received data bytes are counted without the flow control overhead.
+	if tErr := dfq.Deliver(container.New(varint.Pack64(0), []byte("data"))); tErr != nil {
+		t.Fatal(tErr)
+	}
+	stats := dfq.GetFlowStats()
+	if stats.BytesReceived != 4 || stats.RecvQueued != 1 {
+		t.Errorf("unexpected receive stats: %+v", stats)
+	}
+	if stats.Backpressured {
+		t.Error("empty send queue should not be backpressured")
+	}
+
+	// Queued containers without send space are backpressured.
+	dfq.sendQueue <- container.New()
+	atomic.StoreInt32(dfq.sendSpace, 0)
+	stats = dfq.GetFlowStats()
+	if !stats.Backpressured || stats.SendQueued != 1 || stats.Pressure != 1 {
+		t.Errorf("expected backpressure: %+v", stats)
+	}
+}
+
+func TestAsymmetricFlowQueue(t *testing.T) {
+	// Sizes are validated.
+	if _, tErr := NewAsymmetricDuplexFlowQueue(nil, 0, 10, nil); !tErr.Is(ErrInvalidOptions) {
+		t.Errorf("expected invalid send queue size to fail, got %s", tErr)
+	}
+	if _, tErr := NewAsymmetricDuplexFlowQueue(nil, 10, MaxQueueSize+1, nil); !tErr.Is(ErrInvalidOptions) {
+		t.Errorf("expected invalid receive queue size to fail, got %s", tErr)
+	}
+
+	dfq, tErr := NewAsymmetricDuplexFlowQueue(nil, 10, 100, nil)
+	if tErr != nil {
+		t.Fatal(tErr)
+	}
+
+	// Each direction uses its own size.
+	if cap(dfq.sendQueue) != 10 || dfq.getSendSpace() != 10 {
+		t.Errorf("unexpected send queue: cap=%d space=%d", cap(dfq.sendQueue), dfq.getSendSpace())
+	}
+	if cap(dfq.recvQueue) != 100 || atomic.LoadInt32(dfq.reportedSpace) != 100 {
+		t.Errorf("unexpected receive queue: cap=%d reported=%d", cap(dfq.recvQueue), atomic.LoadInt32(dfq.reportedSpace))
+	}
+
+	// The receive space is reported relative to the receive queue size.
+	for i := 0; i < 50; i++ {
+		if tErr := dfq.Deliver(container.New(varint.Pack64(0), []byte("data"))); tErr != nil {
+			t.Fatal(tErr)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		<-dfq.recvQueue
+	}
+	if space := dfq.reportableRecvSpace(); space != 50 {
+		t.Errorf("expected to report 50 free slots, got %d", space)
+	}
+}
+
+func TestFlowQueueDeliverBatch(t *testing.T) {
+	makeBatch := func(n int) []*container.Container {
+		batch := make([]*container.Container, 0, n+1)
+		for i := 0; i < n; i++ {
+			batch = append(batch, container.New(varint.Pack64(uint64(i%2)), []byte("data")))
+		}
+		// Add a pure space report.
+		return append(batch, container.New(varint.Pack64(3)))
+	}
+
+	// A batch results in the same state as delivering one by one.
+	single := NewDuplexFlowQueue(nil, 10, nil)
+	for _, c := range makeBatch(8) {
+		if tErr := single.Deliver(c); tErr != nil {
+			t.Fatal(tErr)
+		}
+	}
+	batched := NewDuplexFlowQueue(nil, 10, nil)
+	if tErr := batched.DeliverBatch(makeBatch(8)); tErr != nil {
+		t.Fatal(tErr)
+	}
+	if single.FlowStats() != batched.FlowStats() {
+		t.Fatalf("batch delivery resulted in different state: %s vs %s", batched.FlowStats(), single.FlowStats())
+	}
+	if single.GetFlowStats() != batched.GetFlowStats() {
+		t.Fatalf("batch delivery resulted in different stats: %+v vs %+v", batched.GetFlowStats(), single.GetFlowStats())
+	}
+	if len(batched.forceSpaceReport) != 1 {
+		t.Fatal("batch delivery should force a space report")
+	}
+
+	// Containers up to the overflow stay delivered.
+	overflow := NewDuplexFlowQueue(nil, 10, nil)
+	if tErr := overflow.DeliverBatch(makeBatch(12)); !tErr.Is(ErrQueueOverflow) {
+		t.Fatalf("expected queue overflow, got %v", tErr)
+	}
+	if len(overflow.recvQueue) != 10 {
+		t.Fatalf("expected 10 delivered containers, got %d", len(overflow.recvQueue))
+	}
+	if reported := atomic.LoadInt32(overflow.reportedSpace); reported != 0 {
+		t.Fatalf("expected no reported space left, got %d", reported)
+	}
+}
+
+func TestFlowQueueDrainRecvQueue(t *testing.T) {
+	dfq := NewDuplexFlowQueue(nil, 10, nil)
+
+	// Nothing to drain.
+	if dropped := dfq.DrainRecvQueue(); dropped != 0 {
+		t.Fatalf("expected nothing to drain, drained %d", dropped)
+	}
+
+	// Received but unprocessed containers are counted.
+	for i := 0; i < 5; i++ {
+		if tErr := dfq.Deliver(container.New(varint.Pack64(0), []byte("data"))); tErr != nil {
+			t.Fatal(tErr)
+		}
+	}
+	<-dfq.Receive()
+	if dropped := dfq.DrainRecvQueue(); dropped != 4 {
+		t.Fatalf("expected 4 drained containers, drained %d", dropped)
+	}
+	if len(dfq.recvQueue) != 0 {
+		t.Fatal("receive queue should be empty after draining")
+	}
+	if total := dfq.DroppedRecvContainers(); total != 4 {
+		t.Fatalf("expected 4 dropped containers in total, got %d", total)
+	}
+}
+
+func TestOpSendWithPolicy(t *testing.T) {
+	// Use a terminal without a sender, so that nothing is sent.
+	term := createTerminalBase(context.Background(), 1, "test", false, &TerminalOpts{})
+	op := newUnknownOp(8, "test/lossy")
+	fill := func() {
+		for i := 0; i < lossyOpMsgQueueSize; i++ {
+			if tErr := term.OpSendWithPolicy(op, container.New([]byte{byte(i)}), SendQueueDropNewest); tErr != nil {
+				t.Fatal(tErr)
+			}
+		}
+	}
+	getData := func() byte {
+		msg, err := (<-term.lossyOpMsgQueue).GetNextBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := container.New(msg)
+		opID, msgType, err := ParseIDType(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opID != op.ID() || msgType != MsgTypeData {
+			t.Fatalf("unexpected msg for op %d with type %d", opID, msgType)
+		}
+		return c.CompileData()[0]
+	}
+
+	// Drop newest discards the data being sent.
+	fill()
+	if tErr := term.OpSendWithPolicy(op, container.New([]byte{255}), SendQueueDropNewest); tErr != nil {
+		t.Fatal(tErr)
+	}
+	if dropped := term.DroppedOpMsgs(); dropped != 1 {
+		t.Fatalf("expected 1 dropped msg, got %d", dropped)
+	}
+	for i := 0; i < lossyOpMsgQueueSize; i++ {
+		if got := getData(); got != byte(i) {
+			t.Fatalf("expected data %d, got %d", i, got)
+		}
+	}
+
+	// Drop oldest evicts the oldest waiting data.
+	fill()
+	if tErr := term.OpSendWithPolicy(op, container.New([]byte{255}), SendQueueDropOldest); tErr != nil {
+		t.Fatal(tErr)
+	}
+	if dropped := term.DroppedOpMsgs(); dropped != 2 {
+		t.Fatalf("expected 2 dropped msgs, got %d", dropped)
+	}
+	if got := getData(); got != 1 {
+		t.Fatalf("expected oldest data to be evicted, got %d", got)
+	}
+	for i := 2; i < lossyOpMsgQueueSize; i++ {
+		getData()
+	}
+	if got := getData(); got != 255 {
+		t.Fatalf("expected newest data to be kept, got %d", got)
+	}
+
+	// Messages that must not be dropped never use the lossy queue.
+	term.cancelCtx()
+	if tErr := term.OpSendWithPolicy(op, container.New([]byte{1}), SendQueueBlock); !tErr.Is(ErrStopping) {
+		t.Fatalf("expected blocking send to wait for the sender, got %v", tErr)
+	}
+	if len(term.lossyOpMsgQueue) != 0 {
+		t.Fatalf("unexpected data in lossy queue")
+	}
+}
+
+func TestFlowQueueWindowAutoTuning(t *testing.T) {
+	dfq := NewDuplexFlowQueue(nil, 10, nil)
+	dfq.EnableWindowAutoTuning(100, func() time.Duration {
+		return 100 * time.Millisecond
+	})
+	if w := dfq.getRecvWindow(); w != 10 {
+		t.Fatalf("expected initial window of 10, got %d", w)
+	}
+
+	// 200 containers/s with an RTT of 100ms is a BDP of 20, aim for 40.
+	now := time.Now()
+	dfq.autoTune.lastTune = now.Add(-time.Second)
+	atomic.StoreInt32(&dfq.autoTune.received, 200)
+	if !dfq.tuneRecvWindow(now) {
+		t.Fatal("expected window to grow")
+	}
+	if w := dfq.getRecvWindow(); w != 40 {
+		t.Fatalf("expected window of 40, got %d", w)
+	}
+
+	// Growing is capped at the max window.
+	now = now.Add(time.Second)
+	atomic.StoreInt32(&dfq.autoTune.received, 10000)
+	dfq.tuneRecvWindow(now)
+	if w := dfq.getRecvWindow(); w != 100 {
+		t.Fatalf("expected window to be capped at 100, got %d", w)
+	}
+
+	// The window is halved when idle, but not below the initial size.
+	for i := 0; i < 10; i++ {
+		now = now.Add(windowAutoTuneIdleShrink)
+		dfq.tuneRecvWindow(now)
+	}
+	if w := dfq.getRecvWindow(); w != 10 {
+		t.Fatalf("expected window to shrink to 10, got %d", w)
+	}
+}
+
+func TestFlowQueueWindowLazyGrowth(t *testing.T) {
+	dfq := NewDuplexFlowQueue(nil, 10, nil)
+	dfq.EnableWindowAutoTuning(100, func() time.Duration {
+		return 100 * time.Millisecond
+	})
+	if cap(dfq.recvQueue) != 10 {
+		t.Fatalf("expected receive queue to keep the initial size, got %d", cap(dfq.recvQueue))
+	}
+
+	// Containers beyond the initial size are held in the overflow.
+	for i := 0; i < 30; i++ {
+		if tErr := dfq.Deliver(container.New(varint.Pack64(0), []byte{byte(i)})); tErr != nil {
+			t.Fatal(tErr)
+		}
+	}
+	if len(dfq.recvOverflow) != 20 || dfq.recvQueued() != 30 {
+		t.Fatalf("expected 20 containers in overflow and 30 queued, got %d and %d", len(dfq.recvOverflow), dfq.recvQueued())
+	}
+
+	// Containers are received in order.
+	for i := 0; i < 30; i++ {
+		c := <-dfq.Receive()
+		if data := c.CompileData(); len(data) != 1 || data[0] != byte(i) {
+			t.Fatalf("expected container %d, got %v", i, data)
+		}
+	}
+	if dfq.recvOverflow != nil || dfq.recvQueued() != 0 {
+		t.Fatal("expected overflow to be freed")
+	}
+
+	// The max window is enforced.
+	for i := 0; i < 100; i++ {
+		if tErr := dfq.Deliver(container.New(varint.Pack64(0), []byte("data"))); tErr != nil {
+			t.Fatal(tErr)
+		}
+	}
+	if tErr := dfq.Deliver(container.New(varint.Pack64(0), []byte("data"))); !tErr.Is(ErrQueueOverflow) {
+		t.Fatalf("expected queue overflow, got %v", tErr)
+	}
+}
+
+type flushTestTerminal struct {
+	ctx context.Context
+}
+
+func (t *flushTestTerminal) ID() uint32                          { return 1 }
+func (t *flushTestTerminal) Ctx() context.Context                { return t.ctx }
+func (t *flushTestTerminal) Deliver(*container.Container) *Error { return nil }
+func (t *flushTestTerminal) Abandon(*Error)                      {}
+func (t *flushTestTerminal) FmtID() string                       { return "flush-test" }
+func (t *flushTestTerminal) Flush()                              {}
+
+func TestFlowQueueFlushProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(module.Ctx)
+	defer cancel()
+
+	// Create flow queue that slowly submits upstream.
+	dfq := NewDuplexFlowQueue(&flushTestTerminal{ctx: ctx}, 100, func(*container.Container) {
+		time.Sleep(10 * time.Millisecond)
+	})
+	for i := 0; i < 20; i++ {
+		dfq.sendQueue <- container.New([]byte("test"))
+	}
+	module.StartWorker("flush progress test flow queue", dfq.FlowHandler)
+
+	// Wait for flush to finish while observing progress.
+	var reports int
+	for remaining := range dfq.FlushProgress(20 * time.Millisecond) {
+		t.Logf("flush progress: %d containers remaining", remaining)
+		reports++
+	}
+	if reports == 0 {
+		t.Error("flush finished without reporting progress")
+	}
+	if len(dfq.sendQueue) != 0 {
+		t.Fatalf("send queue not empty after flush: %d", len(dfq.sendQueue))
+	}
+}
+
+func TestFlowQueueSendRaw(t *testing.T) {
+	ctx, cancel := context.WithCancel(module.Ctx)
+	defer cancel()
+
+	// Create flow queue with an upstream that is stuck until released.
+	release := make(chan struct{})
+	submitted := make(chan *container.Container, 10)
+	dfq := NewDuplexFlowQueue(&flushTestTerminal{ctx: ctx}, 10, func(c *container.Container) {
+		<-release
+		submitted <- c
+	})
+	module.StartWorker("send raw test flow queue", dfq.FlowHandler)
+
+	// The first message is accepted and then stuck in the upstream.
+	if tErr := dfq.SendRaw(container.New([]byte{0})); tErr != nil {
+		t.Fatalf("first raw message should be accepted, got %s", tErr)
+	}
+
+	// Further raw sends time out while the upstream is stuck.
+	defer func(timeout time.Duration) {
+		SendRawTimeout = timeout
+	}(SendRawTimeout)
+	SendRawTimeout = 10 * time.Millisecond
+	if tErr := dfq.SendRaw(container.New([]byte{1})); !tErr.Is(ErrTimeout) {
+		t.Fatalf("expected timeout, got %v", tErr)
+	}
+
+	// Releasing the upstream submits the accepted message only.
+	close(release)
+	select {
+	case c := <-submitted:
+		if data := c.CompileData(); len(data) != 1 || data[0] != 0 {
+			t.Fatalf("unexpected raw message submitted: %v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("accepted raw message was not submitted")
+	}
+
+	// Raw sends are accepted again.
+	if tErr := dfq.SendRaw(container.New([]byte{2})); tErr != nil {
+		t.Fatalf("raw message should be accepted after release, got %s", tErr)
+	}
+	select {
+	case c := <-submitted:
+		if data := c.CompileData(); len(data) != 1 || data[0] != 2 {
+			t.Fatalf("timed out raw message must not be submitted, got %v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("raw message was not submitted after release")
+	}
+}
+
+func TestOperationCancel(t *testing.T) {
+	term1, term2, err := NewSimpleTestTerminalPair(0, nil)
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+
+	// Start a counter that would take very long to finish.
+	counter, tErr := NewCounterOp(term1, CounterOpts{
+		ClientCountTo: 1000000,
+		ServerCountTo: 1000000,
+		Wait:          10 * time.Millisecond,
+	})
+	if tErr != nil {
+		t.Fatalf("failed to start counter: %s", tErr)
+	}
+
+	// Wait for the operation to start on the remote end.
+	time.Sleep(100 * time.Millisecond)
+	if term2.GetActiveOpCount() != 1 {
+		t.Fatalf("expected 1 active op on remote terminal, got %d", term2.GetActiveOpCount())
+	}
+
+	// Cancel and wait for the local end.
+	counter.Cancel()
+	counter.Wait()
+	if counter.Error == nil {
+		t.Error("canceled counter should report that it did not finish")
+	}
+
+	// Check that both ends cleaned up.
+	time.Sleep(100 * time.Millisecond)
+	if term1.GetActiveOpCount() != 0 {
+		t.Errorf("expected no active ops on local terminal, got %d", term1.GetActiveOpCount())
+	}
+	if term2.GetActiveOpCount() != 0 {
+		t.Errorf("expected no active ops on remote terminal, got %d", term2.GetActiveOpCount())
+	}
+}
+
+func TestTerminalLatency(t *testing.T) {
+	term1, _, err := NewSimpleTestTerminalPair(10*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+
+	// Measure a couple of times.
+	for i := 0; i < 3; i++ {
+		rtt, tErr := term1.MeasureLatency()
+		if tErr != nil {
+			t.Fatalf("failed to measure latency: %s", tErr)
+		}
+		// The test terminals delay every message in both directions.
+		if rtt < 20*time.Millisecond {
+			t.Fatalf("measured latency %s is lower than the added delay", rtt)
+		}
+	}
+
+	// Check stats.
+	stats := term1.Stats()
+	if stats.LatencySamples != 3 {
+		t.Fatalf("expected 3 latency samples, got %d", stats.LatencySamples)
+	}
+	if stats.MinLatency > stats.Latency || stats.QueuingDelay != stats.Latency-stats.MinLatency {
+		t.Fatalf("inconsistent latency stats: %+v", stats)
+	}
+
+	// The ping operations must be cleaned up on both ends.
+	time.Sleep(100 * time.Millisecond)
+	if term1.GetActiveOpCount() != 0 {
+		t.Errorf("expected no active ops, got %d", term1.GetActiveOpCount())
+	}
+}
+
+func TestOpSendControl(t *testing.T) {
+	term1, _, err := NewSimpleTestTerminalPair(0, nil)
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+
+	// Register a control operation type for testing.
+	opRegistryLock.Lock()
+	opRegistry["test/control"] = &OpParams{Type: "test/control", Control: true}
+	opRegistryLock.Unlock()
+	defer func() {
+		opRegistryLock.Lock()
+		delete(opRegistry, "test/control")
+		opRegistryLock.Unlock()
+	}()
+
+	// Regular operations must not send control messages.
+	if tErr := term1.OpSendControl(newUnknownOp(8, "test/regular"), container.New([]byte{1})); !tErr.Is(ErrIncorrectUsage) {
+		t.Fatalf("expected regular op to be rejected, got %v", tErr)
+	}
+
+	// Control messages are limited in size.
+	big := container.New(make([]byte, MaxControlMsgSize+1))
+	if tErr := term1.OpSendControl(newUnknownOp(16, "test/control"), big); !tErr.Is(ErrIncorrectUsage) {
+		t.Fatalf("expected oversized control message to be rejected, got %v", tErr)
+	}
+
+	// Control messages are sent before queued data.
+	ctx, cancel := context.WithCancel(module.Ctx)
+	defer cancel()
+	submitted := make(chan *container.Container, 10)
+	dfq := NewDuplexFlowQueue(&flushTestTerminal{ctx: ctx}, 10, func(c *container.Container) {
+		submitted <- c
+	})
+	dfq.sendQueue <- container.New([]byte{1})
+	if tErr := dfq.SendControl(container.New([]byte{2})); tErr != nil {
+		t.Fatal(tErr)
+	}
+	module.StartWorker("control test flow queue", dfq.FlowHandler)
+	for _, expected := range []byte{2, 1} {
+		select {
+		case c := <-submitted:
+			// Strip the reported receive space.
+			if _, err := c.GetNextN64(); err != nil {
+				t.Fatal(err)
+			}
+			if data := c.CompileData(); len(data) != 1 || data[0] != expected {
+				t.Fatalf("expected message %d to be sent upstream, got %v", expected, data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d was not sent upstream", expected)
+		}
+	}
+}
+
+func TestFlowQueueDirectControlMsgs(t *testing.T) {
+	ctx, cancel := context.WithCancel(module.Ctx)
+	defer cancel()
+
+	// Connect a sender to a receiver with a small queue.
+	deliveryErrs := make(chan *Error, 10)
+	receiver := NewDuplexFlowQueue(&flushTestTerminal{ctx: ctx}, 2, func(c *container.Container) {})
+	sender := NewDuplexFlowQueue(&flushTestTerminal{ctx: ctx}, 2, func(c *container.Container) {
+		if tErr := receiver.Deliver(c); tErr != nil {
+			deliveryErrs <- tErr
+		}
+	})
+	sender.EnableDirectControlMsgs()
+	module.StartWorker("direct control test flow queue", sender.FlowHandler)
+
+	// Exhaust the send space and queue more data.
+	for i := byte(1); i <= 3; i++ {
+		if tErr := sender.Send(container.New([]byte{i})); tErr != nil {
+			t.Fatal(tErr)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sender.getSendSpace() != 0 || len(receiver.recvQueue) != 2 || len(sender.sendQueue) != 1 {
+		t.Fatalf("send space was not exhausted: %s", sender.FlowStats())
+	}
+
+	// Control messages still get through.
+	if tErr := sender.SendControl(container.New([]byte{9})); tErr != nil {
+		t.Fatal(tErr)
+	}
+	select {
+	case c := <-receiver.ReceiveControl():
+		if data := c.CompileData(); len(data) != 1 || data[0] != 9 {
+			t.Fatalf("unexpected control message %v", data)
+		}
+	case tErr := <-deliveryErrs:
+		t.Fatalf("failed to deliver control message: %s", tErr)
+	case <-time.After(time.Second):
+		t.Fatal("control message was not sent while the send space was exhausted")
+	}
+
+	// Control messages do not affect the flow control.
+	if sender.getSendSpace() != 0 || len(receiver.recvQueue) != 2 || atomic.LoadInt32(receiver.reportedSpace) != 0 {
+		t.Fatalf("control message was accounted for in the flow control: %s / %s", sender.FlowStats(), receiver.FlowStats())
+	}
+}
+
+func TestCheckFlowSync(t *testing.T) {
+	term1, term2, err := NewSimpleTestTerminalPair(0, &TerminalOpts{QueueSize: 100})
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+
+	// The check is only permitted on crane controllers.
+	if _, tErr := CheckFlowSync(term1); tErr == nil {
+		t.Fatal("expected flow sync check to be refused")
+	}
+	term2.GrantPermission(IsCraneController)
+
+	// Views are in sync after setup.
+	divergence, tErr := CheckFlowSync(term1)
+	if tErr != nil {
+		t.Fatalf("flow sync check failed: %s", tErr)
+	}
+	if divergence != 0 {
+		t.Fatalf("expected no divergence, got %d", divergence)
+	}
+
+	// Lose granted space and let the check resync it.
+	ResetFlowOnDesync = true
+	defer func() {
+		ResetFlowOnDesync = false
+	}()
+	before := term1.getSendSpace()
+	atomic.AddInt32(term1.sendSpace, -50)
+	divergence, tErr = CheckFlowSync(term1)
+	if tErr != nil {
+		t.Fatalf("flow sync check failed: %s", tErr)
+	}
+	if divergence < 40 {
+		t.Fatalf("expected divergence of about 50, got %d", divergence)
+	}
+	if term1.FlowDesyncs() != 1 {
+		t.Fatalf("expected 1 desync, got %d", term1.FlowDesyncs())
+	}
+	if after := term1.getSendSpace(); after < before-10 {
+		t.Fatalf("expected send space to be reset to about %d, got %d", before, after)
+	}
+	if term2.FlowDesyncs() != 0 {
+		t.Fatalf("expected no desync on the other end, got %d", term2.FlowDesyncs())
+	}
+}
+
+func TestPooledContainer(t *testing.T) {
+	t.Parallel()
+
+	// Pooled containers are reset and usable like new ones.
+	c := getPooledContainer([]byte{1, 2})
+	c.Prepend([]byte{0})
+	if data := c.CompileData(); string(data) != string([]byte{0, 1, 2}) {
+		t.Fatalf("unexpected data: %v", data)
+	}
+	releaseContainer(c)
+
+	c = getPooledContainer([]byte{3})
+	if data := c.CompileData(); string(data) != string([]byte{3}) {
+		t.Fatalf("released container was not reset: %v", data)
+	}
+}
+
+// BenchmarkSpaceReportContainer measures the allocations of creating a space
+// report and consuming it on the receiving side.
+func BenchmarkSpaceReportContainer(b *testing.B) {
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c := container.New(varint.Pack64(uint64(i % 100)))
+			_, _ = c.GetNextN16()
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c := getPooledContainer(varint.Pack64(uint64(i % 100)))
+			_, _ = c.GetNextN16()
+			releaseContainer(c)
+		}
+	})
+}
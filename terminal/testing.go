@@ -62,6 +62,7 @@ func initTestTerminal(
 ) *TestTerminal {
 	// Create Flow Queue.
 	dfq := NewDuplexFlowQueue(t, initMsg.QueueSize, submitUpstream)
+	dfq.SetMaxMsgSize(initMsg.MaxMsgSize)
 
 	// Create Crane Terminal and assign it as the extended Terminal.
 	ct := &TestTerminal{